@@ -54,6 +54,14 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Handle migrate subcommand
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(flag.Args()[1:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		os.Exit(0)
+	}
+
 	// Print banner
 	printBanner()
 
@@ -200,17 +208,7 @@ func createConfig() pkg.FrameworkConfig {
 			PprofPath:       "/debug/pprof",
 			ShutdownTimeout: 30 * time.Second,
 		},
-		DatabaseConfig: pkg.DatabaseConfig{
-			Driver:          *dbDriver,
-			Host:            *dbHost,
-			Port:            *dbPort,
-			Database:        *dbName,
-			Username:        *dbUser,
-			Password:        *dbPass,
-			MaxOpenConns:    25,
-			MaxIdleConns:    5,
-			ConnMaxLifetime: 5 * time.Minute,
-		},
+		DatabaseConfig: createDatabaseConfig(),
 		CacheConfig: pkg.CacheConfig{
 			Type:       "memory",
 			MaxSize:    100 * 1024 * 1024,
@@ -267,6 +265,20 @@ func createConfig() pkg.FrameworkConfig {
 	return config
 }
 
+func createDatabaseConfig() pkg.DatabaseConfig {
+	return pkg.DatabaseConfig{
+		Driver:          *dbDriver,
+		Host:            *dbHost,
+		Port:            *dbPort,
+		Database:        *dbName,
+		Username:        *dbUser,
+		Password:        *dbPass,
+		MaxOpenConns:    25,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 5 * time.Minute,
+	}
+}
+
 func loadPlugins(app *pkg.Framework) error {
 	// With compile-time plugins, they are already registered via init()
 	// This function now just reports what plugins are available
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/echterhof/rockstar-web-framework/pkg"
+)
+
+// runMigrate handles 'rockstar [flags] migrate up|down|status [options]'.
+// Database flags are given before the subcommand, migration options after it.
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: rockstar [flags] migrate up|down|status [options]")
+	}
+
+	command := args[0]
+	fs := flag.NewFlagSet("migrate "+command, flag.ContinueOnError)
+	sqlDir := fs.String("sql-dir", "./sql", "SQL directory containing <driver>/migrations")
+	dir := fs.String("dir", "", "Migration directory (default: <sql-dir>/<driver>/migrations)")
+	target := fs.Int64("target", 0, "Version to migrate to (up: 0 = latest)")
+	steps := fs.Int("steps", 1, "Number of migrations to roll back (down)")
+	dryRun := fs.Bool("dry-run", false, "Print the plan and SQL without applying it")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	dbConfig := createDatabaseConfig()
+	dbConfig.Options = map[string]string{"sql_dir": *sqlDir}
+
+	db := pkg.NewDatabaseManager()
+	if err := db.Connect(dbConfig); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	migrator, err := pkg.NewMigrator(db, pkg.MigratorConfig{Dir: *dir})
+	if err != nil {
+		return err
+	}
+
+	options := pkg.MigrateOptions{Target: *target, Steps: *steps, DryRun: *dryRun}
+
+	var plan *pkg.MigrationPlan
+	switch command {
+	case "up":
+		// Versioned migrations build on the framework tables
+		if !*dryRun {
+			if err := db.CreateTables(); err != nil {
+				return fmt.Errorf("failed to create framework tables: %w", err)
+			}
+		}
+		plan, err = migrator.Up(options)
+	case "down":
+		plan, err = migrator.Down(options)
+	case "status":
+		return printMigrationStatus(migrator)
+	default:
+		return fmt.Errorf("unknown migrate command %q (expected up, down or status)", command)
+	}
+
+	if plan != nil {
+		if *dryRun {
+			fmt.Println("Dry run, no changes applied:")
+		}
+		if writeErr := plan.Write(os.Stdout, *dryRun); writeErr != nil && err == nil {
+			err = fmt.Errorf("failed to write migration plan: %w", writeErr)
+		}
+	}
+	return err
+}
+
+// printMigrationStatus prints a table of known and applied migrations
+func printMigrationStatus(migrator pkg.Migrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	if len(statuses) == 0 {
+		fmt.Println("No migrations found.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, s := range statuses {
+		state := "pending"
+		appliedAt := ""
+		if s.Applied {
+			state = "applied"
+			appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		if s.Modified {
+			state = "modified"
+		}
+		if s.Missing {
+			state = "missing"
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+	}
+	return w.Flush()
+}
@@ -269,7 +269,8 @@ func servicesHandler(ctx pkg.Context) error {
 // timeoutHandler demonstrates WithTimeout context control
 func timeoutHandler(ctx pkg.Context) error {
 	// Create a context with timeout
-	timeoutCtx := ctx.WithTimeout(5 * time.Second)
+	timeoutCtx, cancel := pkg.ContextWithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
 	// Check if context has deadline
 	deadline, hasDeadline := timeoutCtx.Context().Deadline()
@@ -62,9 +62,9 @@ func main() {
 	router := app.Router()
 	db := app.Database()
 
-	// Initialize database tables
-	if err := db.CreateTables(); err != nil {
-		log.Fatalf("Failed to create database tables: %v", err)
+	// Create the database tables and apply the framework migrations
+	if err := db.Migrate(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
 	}
 
 	// Create REST API manager
@@ -37,9 +37,9 @@ func main() {
 		log.Fatalf("Failed to create framework: %v", err)
 	}
 
-	// Initialize database tables
-	if err := app.Database().CreateTables(); err != nil {
-		log.Fatalf("Failed to create database tables: %v", err)
+	// Create the database tables and apply the framework migrations
+	if err := app.Database().Migrate(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
 	}
 
 	// Get metrics collector
@@ -16,6 +16,7 @@ type AuthManager struct {
 	db           DatabaseManager
 	jwtSecret    []byte
 	oauth2Config OAuth2Config
+	mfaConfig    MFAConfig
 }
 
 // OAuth2Config defines OAuth2 configuration
@@ -65,6 +66,7 @@ func NewAuthManager(db DatabaseManager, jwtSecret string, oauth2Config OAuth2Con
 		db:           db,
 		jwtSecret:    []byte(jwtSecret),
 		oauth2Config: oauth2Config,
+		mfaConfig:    DefaultMFAConfig(),
 	}
 }
 
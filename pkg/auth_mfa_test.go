@@ -0,0 +1,375 @@
+package pkg
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// rfcTestSecret is the shared secret from RFC 4226 / RFC 6238 test vectors
+var rfcTestSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func newTestMFAAuthManager() *AuthManager {
+	am := NewAuthManager(nil, "test-secret-key-32-bytes-long!", OAuth2Config{})
+	am.SetMFAConfig(MFAConfig{
+		Issuer:            "Rockstar Test",
+		RecoveryCodeCount: 3,
+		Hasher:            NewBcryptHasher(bcrypt.MinCost),
+	})
+	return am
+}
+
+func TestGenerateHOTP_RFC4226Vectors(t *testing.T) {
+	expected := []string{"755224", "287082", "359152", "969429", "338314"}
+
+	for counter, want := range expected {
+		got, err := GenerateHOTP(rfcTestSecret, uint64(counter), 6, OTPAlgorithmSHA1)
+		if err != nil {
+			t.Fatalf("GenerateHOTP(%d) returned error: %v", counter, err)
+		}
+		if got != want {
+			t.Errorf("GenerateHOTP(%d) = %s, want %s", counter, got, want)
+		}
+	}
+}
+
+func TestGenerateTOTP_RFC6238Vectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := GenerateTOTP(rfcTestSecret, time.Unix(tt.unix, 0), 30*time.Second, 8, OTPAlgorithmSHA1)
+		if err != nil {
+			t.Fatalf("GenerateTOTP(%d) returned error: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("GenerateTOTP(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestEnrollTOTP(t *testing.T) {
+	am := newTestMFAAuthManager()
+
+	enrollment, err := am.EnrollTOTP(&User{ID: "user-1", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("EnrollTOTP failed: %v", err)
+	}
+
+	device := enrollment.Device
+	if device.Type != MFATypeTOTP || device.UserID != "user-1" {
+		t.Errorf("unexpected device: %+v", device)
+	}
+	if device.Confirmed {
+		t.Error("new device should not be confirmed before the first verification")
+	}
+
+	if len(enrollment.RecoveryCodes) != 3 || len(device.RecoveryCodes) != 3 {
+		t.Fatalf("expected 3 recovery codes, got %d plain / %d hashed",
+			len(enrollment.RecoveryCodes), len(device.RecoveryCodes))
+	}
+	for i, code := range enrollment.RecoveryCodes {
+		if device.RecoveryCodes[i] == code {
+			t.Error("recovery codes must be stored hashed")
+		}
+	}
+
+	u, err := url.Parse(enrollment.URI)
+	if err != nil {
+		t.Fatalf("invalid otpauth URI: %v", err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" {
+		t.Errorf("unexpected URI scheme/host: %s", enrollment.URI)
+	}
+	if !strings.Contains(u.Path, "alice@example.com") {
+		t.Errorf("URI label should contain account name: %s", u.Path)
+	}
+	q := u.Query()
+	if q.Get("secret") != device.Secret || q.Get("issuer") != "Rockstar Test" || q.Get("period") != "30" {
+		t.Errorf("unexpected URI parameters: %v", q)
+	}
+}
+
+func TestEnrollTOTP_RequiresUser(t *testing.T) {
+	am := newTestMFAAuthManager()
+
+	if _, err := am.EnrollTOTP(nil); err == nil {
+		t.Error("expected error for nil user")
+	}
+}
+
+func TestVerifyTOTP_DriftAndReplay(t *testing.T) {
+	am := newTestMFAAuthManager()
+	device := &MFADevice{Type: MFATypeTOTP, Secret: rfcTestSecret, Algorithm: OTPAlgorithmSHA1, Digits: 6, Period: 30}
+
+	now := time.Unix(1700000000, 0)
+	previous, _ := GenerateTOTP(rfcTestSecret, now.Add(-30*time.Second), 30*time.Second, 6, OTPAlgorithmSHA1)
+	current, _ := GenerateTOTP(rfcTestSecret, now, 30*time.Second, 6, OTPAlgorithmSHA1)
+	stale, _ := GenerateTOTP(rfcTestSecret, now.Add(-90*time.Second), 30*time.Second, 6, OTPAlgorithmSHA1)
+
+	// Outside the drift window
+	if err := am.verifyTOTPAt(device, stale, now); err == nil {
+		t.Error("expected code outside drift window to be rejected")
+	}
+
+	// Previous step is inside the default window of 1
+	if err := am.verifyTOTPAt(device, previous, now); err != nil {
+		t.Fatalf("expected previous step to be accepted: %v", err)
+	}
+	if !device.Confirmed {
+		t.Error("device should be confirmed after first successful verification")
+	}
+
+	// Replaying the same code must fail
+	err := am.verifyTOTPAt(device, previous, now)
+	fwErr, ok := GetFrameworkError(err)
+	if !ok || fwErr.Code != ErrCodeMFACodeReused {
+		t.Fatalf("expected %s, got %v", ErrCodeMFACodeReused, err)
+	}
+
+	// A later step is still accepted
+	if err := am.verifyTOTPAt(device, current, now); err != nil {
+		t.Fatalf("expected current step to be accepted: %v", err)
+	}
+	if err := am.verifyTOTPAt(device, current, now); err == nil {
+		t.Error("expected replay of current code to be rejected")
+	}
+}
+
+func TestVerifyTOTP_SkewDisabled(t *testing.T) {
+	am := newTestMFAAuthManager()
+	config := am.MFAConfig()
+	config.Skew = -1
+	am.SetMFAConfig(config)
+
+	// Applying the defaults again keeps drift tolerance disabled
+	am.SetMFAConfig(am.MFAConfig())
+	if am.MFAConfig().Skew != -1 {
+		t.Fatalf("expected skew to stay disabled, got %d", am.MFAConfig().Skew)
+	}
+
+	device := &MFADevice{Type: MFATypeTOTP, Secret: rfcTestSecret, Algorithm: OTPAlgorithmSHA1, Digits: 6, Period: 30}
+	now := time.Unix(1700000000, 0)
+	previous, _ := GenerateTOTP(rfcTestSecret, now.Add(-30*time.Second), 30*time.Second, 6, OTPAlgorithmSHA1)
+	current, _ := GenerateTOTP(rfcTestSecret, now, 30*time.Second, 6, OTPAlgorithmSHA1)
+
+	if err := am.verifyTOTPAt(device, previous, now); err == nil {
+		t.Error("expected previous step to be rejected without drift tolerance")
+	}
+	if err := am.verifyTOTPAt(device, current, now); err != nil {
+		t.Errorf("expected current step to be accepted: %v", err)
+	}
+}
+
+func TestVerifyHOTP_LookAhead(t *testing.T) {
+	am := newTestMFAAuthManager()
+	device := &MFADevice{Type: MFATypeHOTP, Secret: rfcTestSecret, Algorithm: OTPAlgorithmSHA1, Digits: 6}
+
+	// Counter 3 is within the look-ahead window
+	if err := am.VerifyHOTP(device, "969429"); err != nil {
+		t.Fatalf("expected code within look-ahead window to be accepted: %v", err)
+	}
+	if device.Counter != 4 {
+		t.Errorf("expected counter to advance to 4, got %d", device.Counter)
+	}
+
+	// Earlier counter values can no longer be used
+	if err := am.VerifyHOTP(device, "755224"); err == nil {
+		t.Error("expected old HOTP code to be rejected")
+	}
+}
+
+func TestVerifyHOTP_LookAheadDisabled(t *testing.T) {
+	am := newTestMFAAuthManager()
+	config := am.MFAConfig()
+	config.HOTPLookAhead = -1
+	am.SetMFAConfig(config)
+
+	// Applying the defaults again keeps the look-ahead disabled
+	am.SetMFAConfig(am.MFAConfig())
+	if am.MFAConfig().HOTPLookAhead != -1 {
+		t.Fatalf("expected look-ahead to stay disabled, got %d", am.MFAConfig().HOTPLookAhead)
+	}
+
+	device := &MFADevice{Type: MFATypeHOTP, Secret: rfcTestSecret, Algorithm: OTPAlgorithmSHA1, Digits: 6}
+
+	// Counter 1 is ahead of the stored counter
+	if err := am.VerifyHOTP(device, "287082"); err == nil {
+		t.Error("expected code ahead of the counter to be rejected without look-ahead")
+	}
+	if err := am.VerifyHOTP(device, "755224"); err != nil {
+		t.Fatalf("expected code of the current counter to be accepted: %v", err)
+	}
+	if device.Counter != 1 {
+		t.Errorf("expected counter to advance to 1, got %d", device.Counter)
+	}
+}
+
+func TestVerifyRecoveryCode_ConsumesCode(t *testing.T) {
+	am := newTestMFAAuthManager()
+
+	enrollment, err := am.EnrollTOTP(&User{ID: "user-1"})
+	if err != nil {
+		t.Fatalf("EnrollTOTP failed: %v", err)
+	}
+	device := enrollment.Device
+	code := enrollment.RecoveryCodes[1]
+
+	// Codes are accepted case-insensitively and without separators
+	if err := am.VerifyRecoveryCode(device, strings.ToUpper(strings.ReplaceAll(code, "-", ""))); err != nil {
+		t.Fatalf("expected recovery code to be accepted: %v", err)
+	}
+	if len(device.RecoveryCodes) != 2 {
+		t.Errorf("expected recovery code to be consumed, %d left", len(device.RecoveryCodes))
+	}
+	if err := am.VerifyRecoveryCode(device, code); err == nil {
+		t.Error("expected consumed recovery code to be rejected")
+	}
+}
+
+// countingHasher counts the hashes it verifies
+type countingHasher struct {
+	PasswordHasher
+	verified int
+}
+
+func (h *countingHasher) Verify(password, hash string) (bool, error) {
+	h.verified++
+	return h.PasswordHasher.Verify(password, hash)
+}
+
+func TestStepUp_RecoveryCodeOnlyForRecoveryFormat(t *testing.T) {
+	am := newTestMFAAuthManager()
+	hasher := &countingHasher{PasswordHasher: am.mfaConfig.Hasher}
+	am.mfaConfig.Hasher = hasher
+
+	enrollment, err := am.EnrollTOTP(&User{ID: "user-1"})
+	if err != nil {
+		t.Fatalf("EnrollTOTP failed: %v", err)
+	}
+	device := enrollment.Device
+
+	// A wrong one-time password is not checked against the recovery codes
+	if err := am.StepUp(nil, &Session{Data: map[string]interface{}{}}, device, "123456"); err == nil {
+		t.Fatal("expected a wrong code to be rejected")
+	}
+	if hasher.verified != 0 {
+		t.Errorf("expected no recovery code hashes to be verified, got %d", hasher.verified)
+	}
+
+	// A recovery code still steps up
+	session := &Session{Data: map[string]interface{}{}}
+	if err := am.StepUp(nil, session, device, enrollment.RecoveryCodes[0]); err != nil {
+		t.Fatalf("expected the recovery code to be accepted: %v", err)
+	}
+	if _, ok := session.Data[SessionKeyMFAVerifiedAt]; !ok {
+		t.Error("expected the step-up to be recorded in the session")
+	}
+}
+
+func TestMFAVerifiedAt_SurvivesJSONRoundTrip(t *testing.T) {
+	session := &Session{ID: "s1"}
+	MarkMFAVerified(session)
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+
+	var decoded Session
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+
+	verifiedAt, ok := MFAVerifiedAt(&decoded)
+	if !ok {
+		t.Fatal("expected MFA verification time after JSON round trip")
+	}
+	if time.Since(verifiedAt) > time.Minute {
+		t.Errorf("unexpected verification time: %v", verifiedAt)
+	}
+}
+
+func TestRequireMFA(t *testing.T) {
+	config := DefaultSessionConfig()
+	config.EncryptionKey = make([]byte, 32)
+
+	sm, err := NewSessionManager(config, NewNoopDatabaseManager(), nil)
+	if err != nil {
+		t.Fatalf("failed to create session manager: %v", err)
+	}
+
+	newCtx := func(session *Session) Context {
+		cookieCtx := &mockContext{}
+		if err := sm.SetCookie(cookieCtx, session); err != nil {
+			t.Fatalf("failed to set cookie: %v", err)
+		}
+		cookie := cookieCtx.cookies["rockstar_session"]
+
+		req := &Request{Header: http.Header{}, Params: map[string]string{}}
+		req.Header.Set("Cookie", cookie.Name+"="+cookie.Value)
+
+		ctx := NewContext(req, NewResponseWriter(httptest.NewRecorder()), context.Background())
+		ctx.(*contextImpl).SetSession(sm)
+		return ctx
+	}
+
+	handlerCalled := false
+	handler := func(ctx Context) error {
+		handlerCalled = true
+		return nil
+	}
+	mw := RequireMFA(10 * time.Minute)
+
+	session, err := sm.Create(nil)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	// No MFA yet
+	err = mw(newCtx(session), handler)
+	if fwErr, ok := GetFrameworkError(err); !ok || fwErr.Code != ErrCodeMFARequired {
+		t.Fatalf("expected %s, got %v", ErrCodeMFARequired, err)
+	}
+	if handlerCalled {
+		t.Fatal("handler should not run without MFA")
+	}
+
+	// MFA too long ago
+	session.Data[SessionKeyMFAVerifiedAt] = time.Now().Add(-time.Hour).Unix()
+	_ = sm.Save(nil, session)
+	if err := mw(newCtx(session), handler); err == nil {
+		t.Fatal("expected stale MFA verification to be rejected")
+	}
+
+	// Step-up via TOTP
+	am := newTestMFAAuthManager()
+	device := &MFADevice{Type: MFATypeTOTP, Secret: rfcTestSecret, Algorithm: OTPAlgorithmSHA1, Digits: 6, Period: 30}
+	code, _ := GenerateTOTP(rfcTestSecret, time.Now(), 30*time.Second, 6, OTPAlgorithmSHA1)
+
+	ctx := newCtx(session)
+	if err := am.StepUp(ctx, session, device, code); err != nil {
+		t.Fatalf("StepUp failed: %v", err)
+	}
+	if err := mw(newCtx(session), handler); err != nil {
+		t.Fatalf("expected request to pass after step-up, got %v", err)
+	}
+	if !handlerCalled {
+		t.Error("expected handler to be called")
+	}
+}
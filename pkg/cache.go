@@ -1,9 +1,11 @@
 package pkg
 
 import (
+	"container/list"
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,6 +16,21 @@ var (
 	ErrCacheExpired = errors.New("cache entry expired")
 )
 
+// Eviction policies of a size-bounded cache
+const (
+	// CacheEvictionLRU evicts the least recently used entries
+	CacheEvictionLRU = "lru"
+	// CacheEvictionLFU evicts the least frequently used entries
+	CacheEvictionLFU = "lfu"
+	// CacheEvictionTinyLFU evicts using W-TinyLFU, which keeps frequently
+	// used entries through scans of keys that are used once
+	CacheEvictionTinyLFU = "tinylfu"
+)
+
+// cacheMetricsInterval is how often cache counters are reported to the
+// metrics collector
+const cacheMetricsInterval = 10 * time.Second
+
 // CacheConfig holds configuration options for the cache system
 type CacheConfig struct {
 	// Type specifies the cache backend type.
@@ -21,7 +38,9 @@ type CacheConfig struct {
 	// Default: "memory"
 	Type string
 
-	// MaxSize specifies the maximum cache size in bytes.
+	// MaxSize specifies the maximum cache size in bytes. Entry sizes are
+	// estimated from their keys and values. When the cache is full, entries
+	// are evicted according to EvictionPolicy.
 	// 0 means no limit. Negative values are normalized to 0.
 	// Default: 0 (unlimited)
 	MaxSize int64
@@ -30,13 +49,71 @@ type CacheConfig struct {
 	// 0 means no expiration. Negative values are normalized to 0.
 	// Default: 0 (no expiration)
 	DefaultTTL time.Duration
+
+	// StaleWhileRevalidate is how long GetOrLoad serves a value after its
+	// TTL while reloading it in the background.
+	// Default: 0 (disabled)
+	StaleWhileRevalidate time.Duration
+
+	// EarlyRefreshBeta enables probabilistic early refresh in GetOrLoad.
+	// Default: 0 (disabled)
+	EarlyRefreshBeta float64
+
+	// NegativeTTL is how long GetOrLoad remembers values the loader
+	// reported as not found.
+	// Default: 0 (disabled)
+	NegativeTTL time.Duration
+
+	// LoadLockTimeout is how long GetOrLoad waits for another instance
+	// holding the distributed load lock of a key before loading it itself.
+	// Default: 10 seconds
+	LoadLockTimeout time.Duration
+
+	// EvictionPolicy selects the entries evicted when MaxSize is reached.
+	// Supported values: "lru", "lfu", "tinylfu"
+	// Default: "lru"
+	EvictionPolicy string
+
+	// Shards is the number of independently locked parts of the cache,
+	// rounded up to a power of two. Each shard holds an equal part of
+	// MaxSize, so caches smaller than 1 MB per shard use fewer shards.
+	// Default: 16
+	Shards int
+
+	// Invalidation configures the invalidation of in-memory entries that
+	// other instances change
+	Invalidation CacheInvalidationConfig
+
+	// Serialization configures how values are encoded in the distributed cache
+	Serialization CacheSerializationConfig
 }
 
 // cacheEntry represents a single cache entry with expiration
 type cacheEntry struct {
+	key       string
+	hash      uint64
 	value     interface{}
 	expiresAt time.Time
 	tags      []string
+	size      int64
+
+	// version orders the entry against invalidations
+	version uint64
+
+	// Set by GetOrLoad: the value is served stale after freshUntil until
+	// expiresAt, loadTime drives early refreshes and negative marks a
+	// cached absence
+	freshUntil time.Time
+	loadTime   time.Duration
+	negative   bool
+
+	// Eviction policy state
+	element   *list.Element
+	frequency uint64
+	lastUse   uint64
+	index     int
+	segment   int
+	candidate bool
 }
 
 // isExpired checks if the cache entry has expired
@@ -47,22 +124,61 @@ func (e *cacheEntry) isExpired() bool {
 	return time.Now().After(e.expiresAt)
 }
 
+// isStale checks if the entry is expired or past its fresh period
+func (e *cacheEntry) isStale(now time.Time) bool {
+	if !e.freshUntil.IsZero() && now.After(e.freshUntil) {
+		return true
+	}
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// snapshot returns a copy of the entry's value and metadata that can be
+// read without holding the shard lock
+func (e *cacheEntry) snapshot() cacheEntry {
+	return cacheEntry{
+		key:        e.key,
+		value:      e.value,
+		expiresAt:  e.expiresAt,
+		freshUntil: e.freshUntil,
+		loadTime:   e.loadTime,
+		negative:   e.negative,
+	}
+}
+
 // cacheManagerImpl implements the CacheManager interface
 type cacheManagerImpl struct {
 	// In-memory cache storage
-	cache map[string]*cacheEntry
-	mu    sync.RWMutex
+	store *cacheStore
 
 	// Request-specific caches using arena-like approach
 	requestCaches map[string]*requestCacheImpl
 	requestMu     sync.RWMutex
 
 	// Tag-based invalidation
-	tagIndex map[string][]string // tag -> []keys
+	tagIndex map[string]map[string]struct{} // tag -> keys
 	tagMu    sync.RWMutex
 
-	// Distributed cache support (optional)
+	// Distributed cache support (optional); values are stored encoded
 	distributed DistributedCache
+	serializer  *cacheSerializer
+
+	// Invalidation of entries changed here or on other instances
+	clock               cacheClock
+	tombstones          *cacheTombstones
+	bus                 CacheInvalidationBus
+	busMu               sync.RWMutex
+	remoteInvalidations atomic.Int64
+
+	// Loads of GetOrLoad, one per key at a time
+	loads      loadGroup
+	loadCount  atomic.Int64
+	loadErrors atomic.Int64
+
+	// Metrics reporting (optional)
+	metrics    MetricsCollector
+	lastReport atomic.Int64
+	reportMu   sync.Mutex
+	reported   cacheStats
 
 	// Configuration
 	config CacheConfig
@@ -70,234 +186,265 @@ type cacheManagerImpl struct {
 
 // NewCacheManager creates a new cache manager instance with the given configuration
 func NewCacheManager(config CacheConfig) CacheManager {
-	// Apply default values for zero values
-	config.ApplyDefaults()
-
-	return &cacheManagerImpl{
-		cache:         make(map[string]*cacheEntry),
-		requestCaches: make(map[string]*requestCacheImpl),
-		tagIndex:      make(map[string][]string),
-		config:        config,
-	}
+	return newCacheManager(config, nil)
 }
 
 // NewCacheManagerWithDistributed creates a cache manager with distributed cache support
 func NewCacheManagerWithDistributed(config CacheConfig, distributed DistributedCache) CacheManager {
-	// Apply default values for zero values
-	config.ApplyDefaults()
 	// Override Type to "distributed" when using distributed cache
-	if config.Type == "memory" {
+	if config.Type == "" || config.Type == "memory" {
 		config.Type = "distributed"
 	}
+	return newCacheManager(config, distributed)
+}
 
-	return &cacheManagerImpl{
-		cache:         make(map[string]*cacheEntry),
+// newCacheManager creates a cache manager
+func newCacheManager(config CacheConfig, distributed DistributedCache) *cacheManagerImpl {
+	// Apply default values for zero values
+	config.ApplyDefaults()
+	switch config.EvictionPolicy {
+	case CacheEvictionLRU, CacheEvictionLFU, CacheEvictionTinyLFU:
+	default:
+		fmt.Printf("WARN: Unknown cache eviction policy %q, using %q\n", config.EvictionPolicy, CacheEvictionLRU)
+		config.EvictionPolicy = CacheEvictionLRU
+	}
+
+	c := &cacheManagerImpl{
 		requestCaches: make(map[string]*requestCacheImpl),
-		tagIndex:      make(map[string][]string),
+		tagIndex:      make(map[string]map[string]struct{}),
 		distributed:   distributed,
+		serializer:    newCacheSerializer(config.Serialization),
+		tombstones:    newCacheTombstones(config.Invalidation.TombstoneTTL),
 		config:        config,
 	}
+	if distributed != nil && c.serializer.err != nil {
+		fmt.Printf("WARN: Values cannot be stored in the distributed cache: %v\n", c.serializer.err)
+	}
+	c.store = newCacheStore(config, c.untag)
+	c.store.admit = c.tombstones.admits
+	return c
+}
+
+// setMetrics reports hit, miss and eviction counts and the cache size to metrics
+func (c *cacheManagerImpl) setMetrics(metrics MetricsCollector) {
+	c.metrics = metrics
+	c.lastReport.Store(time.Now().UnixNano())
+}
+
+// expiresAt returns the expiration time for ttl, using DefaultTTL if ttl is 0
+func (c *cacheManagerImpl) expiresAt(ttl time.Duration) (time.Time, time.Duration) {
+	effectiveTTL := ttl
+	if ttl == 0 && c.config.DefaultTTL > 0 {
+		effectiveTTL = c.config.DefaultTTL
+	}
+	if effectiveTTL > 0 {
+		return time.Now().Add(effectiveTTL), effectiveTTL
+	}
+	return time.Time{}, effectiveTTL
 }
 
 // Get retrieves a value from cache
 func (c *cacheManagerImpl) Get(key string) (interface{}, error) {
-	c.mu.RLock()
-	entry, exists := c.cache[key]
-	c.mu.RUnlock()
+	return c.get(key, c.decodeAny)
+}
 
-	if !exists {
+// get retrieves a value, decoding values from the distributed cache with decode
+func (c *cacheManagerImpl) get(key string, decode func(data []byte) (interface{}, error)) (interface{}, error) {
+	entry, err := c.store.get(key)
+	c.maybeReportMetrics()
+
+	if err == ErrCacheKeyNotFound && c.distributed != nil {
 		// Try distributed cache if available
-		if c.distributed != nil {
-			return c.distributed.Get(key)
+		raw, err := c.distributed.Get(key)
+		if err != nil {
+			return nil, err
 		}
-		return nil, ErrCacheKeyNotFound
+		return c.fromDistributed(raw, decode)
 	}
-
-	if entry.isExpired() {
-		// Return expired error before cleanup
-		err := ErrCacheExpired
-		// Clean up expired entry
-		c.mu.Lock()
-		delete(c.cache, key)
-		c.mu.Unlock()
+	if err != nil {
 		return nil, err
 	}
-
+	if entry.negative {
+		return nil, ErrCacheKeyNotFound
+	}
+	if entry.isStale(time.Now()) {
+		// Stale values are only served by GetOrLoad while it refreshes them
+		return nil, ErrCacheExpired
+	}
 	return entry.value, nil
 }
 
 // Set stores a value in cache with TTL
 func (c *cacheManagerImpl) Set(key string, value interface{}, ttl time.Duration) error {
-	entry := &cacheEntry{
-		value: value,
-	}
-
-	// Use DefaultTTL if ttl is 0 and DefaultTTL is configured
-	effectiveTTL := ttl
-	if ttl == 0 && c.config.DefaultTTL > 0 {
-		effectiveTTL = c.config.DefaultTTL
-	}
-
-	if effectiveTTL > 0 {
-		entry.expiresAt = time.Now().Add(effectiveTTL)
-	}
-
-	c.mu.Lock()
-	c.cache[key] = entry
-	c.mu.Unlock()
+	expiresAt, effectiveTTL := c.expiresAt(ttl)
+	inv := c.invalidation(CacheInvalidateKey, key)
+	c.store.set(key, value, expiresAt, nil, inv.Version)
+	c.maybeReportMetrics()
 
 	// Also set in distributed cache if available
+	var err error
 	if c.distributed != nil {
-		return c.distributed.Set(key, value, effectiveTTL)
+		var data []byte
+		if data, err = c.serializer.encode(value); err == nil {
+			err = c.distributed.Set(key, data, effectiveTTL)
+		}
 	}
 
-	return nil
+	// Other instances drop their older copies
+	return c.publish(err, inv)
 }
 
 // Delete removes a value from cache
 func (c *cacheManagerImpl) Delete(key string) error {
-	c.mu.Lock()
-	delete(c.cache, key)
-	c.mu.Unlock()
+	inv := c.invalidation(CacheInvalidateKey, key)
+	c.invalidate(inv)
 
 	// Also delete from distributed cache if available
+	var err error
 	if c.distributed != nil {
-		return c.distributed.Delete(key)
+		err = c.distributed.Delete(key)
 	}
 
-	return nil
+	return c.publish(err, inv)
 }
 
 // Exists checks if a key exists in cache
 func (c *cacheManagerImpl) Exists(key string) bool {
-	c.mu.RLock()
-	entry, exists := c.cache[key]
-	c.mu.RUnlock()
-
-	if !exists {
-		return false
-	}
-
-	if entry.isExpired() {
-		return false
-	}
-
-	return true
+	entry, exists := c.store.peek(key)
+	return exists && !entry.negative && !entry.isStale(time.Now())
 }
 
 // Clear removes all entries from cache
 func (c *cacheManagerImpl) Clear() error {
-	c.mu.Lock()
-	c.cache = make(map[string]*cacheEntry)
-	c.mu.Unlock()
+	inv := c.invalidation(CacheInvalidateAll, "")
+	c.tombstones.record(inv, time.Now())
+	c.store.clear()
 
 	c.tagMu.Lock()
-	c.tagIndex = make(map[string][]string)
+	c.tagIndex = make(map[string]map[string]struct{})
 	c.tagMu.Unlock()
 
 	// Also clear distributed cache if available
+	var err error
 	if c.distributed != nil {
-		return c.distributed.Clear()
+		err = c.distributed.Clear()
 	}
 
-	return nil
+	return c.publish(err, inv)
 }
 
 // GetMultiple retrieves multiple values from cache
 func (c *cacheManagerImpl) GetMultiple(keys []string) (map[string]interface{}, error) {
 	result := make(map[string]interface{})
 
-	c.mu.RLock()
 	for _, key := range keys {
-		if entry, exists := c.cache[key]; exists && !entry.isExpired() {
+		if entry, err := c.store.get(key); err == nil && !entry.negative && !entry.isStale(time.Now()) {
 			result[key] = entry.value
 		}
 	}
-	c.mu.RUnlock()
+	c.maybeReportMetrics()
 
 	return result, nil
 }
 
 // SetMultiple stores multiple values in cache
 func (c *cacheManagerImpl) SetMultiple(items map[string]interface{}, ttl time.Duration) error {
-	// Use DefaultTTL if ttl is 0 and DefaultTTL is configured
-	effectiveTTL := ttl
-	if ttl == 0 && c.config.DefaultTTL > 0 {
-		effectiveTTL = c.config.DefaultTTL
+	expiresAt, effectiveTTL := c.expiresAt(ttl)
+
+	invs := make([]CacheInvalidation, 0, len(items))
+	for key, value := range items {
+		inv := c.invalidation(CacheInvalidateKey, key)
+		c.store.set(key, value, expiresAt, nil, inv.Version)
+		invs = append(invs, inv)
 	}
+	c.maybeReportMetrics()
 
-	var expiresAt time.Time
-	if effectiveTTL > 0 {
-		expiresAt = time.Now().Add(effectiveTTL)
+	// Also set in distributed cache if available
+	var err error
+	if c.distributed != nil {
+		err = c.setMultipleDistributed(items, effectiveTTL)
 	}
 
-	c.mu.Lock()
+	return c.publish(err, invs...)
+}
+
+// setMultipleDistributed encodes items and stores them in the distributed cache
+func (c *cacheManagerImpl) setMultipleDistributed(items map[string]interface{}, ttl time.Duration) error {
+	encoded := make(map[string]interface{}, len(items))
 	for key, value := range items {
-		c.cache[key] = &cacheEntry{
-			value:     value,
-			expiresAt: expiresAt,
+		data, err := c.serializer.encode(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
 		}
+		encoded[key] = data
 	}
-	c.mu.Unlock()
+	return c.distributed.SetMultiple(encoded, ttl)
+}
 
-	// Also set in distributed cache if available
-	if c.distributed != nil {
-		return c.distributed.SetMultiple(items, effectiveTTL)
+// fromDistributed decodes a value read from the distributed cache. Values
+// that were not encoded by a cache manager are returned as they are.
+func (c *cacheManagerImpl) fromDistributed(raw interface{}, decode func(data []byte) (interface{}, error)) (interface{}, error) {
+	data, ok := raw.([]byte)
+	if !ok || !isCacheEnvelope(data) {
+		return raw, nil
 	}
+	return decode(data)
+}
 
-	return nil
+// decodeAny decodes an encoded value into the codec's generic types, e.g.
+// map[string]interface{} for JSON objects
+func (c *cacheManagerImpl) decodeAny(data []byte) (interface{}, error) {
+	var value interface{}
+	if err := c.serializer.decode(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
 }
 
 // DeleteMultiple removes multiple values from cache
 func (c *cacheManagerImpl) DeleteMultiple(keys []string) error {
-	c.mu.Lock()
+	invs := make([]CacheInvalidation, 0, len(keys))
 	for _, key := range keys {
-		delete(c.cache, key)
+		inv := c.invalidation(CacheInvalidateKey, key)
+		c.invalidate(inv)
+		invs = append(invs, inv)
 	}
-	c.mu.Unlock()
 
 	// Also delete from distributed cache if available
+	var err error
 	if c.distributed != nil {
-		return c.distributed.DeleteMultiple(keys)
+		err = c.distributed.DeleteMultiple(keys)
 	}
 
-	return nil
+	return c.publish(err, invs...)
 }
 
 // Increment increments a numeric value in cache
 func (c *cacheManagerImpl) Increment(key string, delta int64) (int64, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	var result int64
+	err := c.store.update(key, func(shard *cacheShard, hash uint64) error {
+		entry, exists := shard.entries[key]
+		if !exists || entry.negative || entry.isStale(time.Now()) {
+			// Initialize or reset with delta
+			shard.setLocked(&cacheEntry{key: key, hash: hash, value: delta, version: c.clock.next()})
+			result = delta
+			return nil
+		}
 
-	entry, exists := c.cache[key]
-	if !exists {
-		// Initialize with delta
-		c.cache[key] = &cacheEntry{
-			value: delta,
+		// Try to increment existing value
+		switch v := entry.value.(type) {
+		case int64:
+			result = v + delta
+		case int:
+			result = int64(v) + delta
+		default:
+			return fmt.Errorf("cannot increment non-numeric value")
 		}
-		return delta, nil
-	}
-
-	if entry.isExpired() {
-		// Reset with delta
-		entry.value = delta
-		entry.expiresAt = time.Time{}
-		return delta, nil
-	}
-
-	// Try to increment existing value
-	switch v := entry.value.(type) {
-	case int64:
-		newValue := v + delta
-		entry.value = newValue
-		return newValue, nil
-	case int:
-		newValue := int64(v) + delta
-		entry.value = newValue
-		return newValue, nil
-	default:
-		return 0, fmt.Errorf("cannot increment non-numeric value")
-	}
+		entry.value = result
+		entry.version = c.clock.next()
+		return nil
+	})
+	return result, err
 }
 
 // Decrement decrements a numeric value in cache
@@ -307,38 +454,38 @@ func (c *cacheManagerImpl) Decrement(key string, delta int64) (int64, error) {
 
 // Expire sets a new TTL for a cache entry
 func (c *cacheManagerImpl) Expire(key string, ttl time.Duration) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	entry, exists := c.cache[key]
-	if !exists {
-		return ErrCacheKeyNotFound
-	}
-
-	if ttl > 0 {
-		entry.expiresAt = time.Now().Add(ttl)
-	} else {
-		entry.expiresAt = time.Time{}
-	}
+	return c.store.update(key, func(shard *cacheShard, hash uint64) error {
+		entry, exists := shard.entries[key]
+		if !exists {
+			return ErrCacheKeyNotFound
+		}
 
-	return nil
+		if ttl > 0 {
+			entry.expiresAt = time.Now().Add(ttl)
+		} else {
+			entry.expiresAt = time.Time{}
+		}
+		entry.freshUntil = time.Time{}
+		return nil
+	})
 }
 
 // TTL returns the time-to-live for a cache entry
 func (c *cacheManagerImpl) TTL(key string) (time.Duration, error) {
-	c.mu.RLock()
-	entry, exists := c.cache[key]
-	c.mu.RUnlock()
-
-	if !exists {
+	entry, exists := c.store.peek(key)
+	if !exists || entry.negative {
 		return 0, ErrCacheKeyNotFound
 	}
 
-	if entry.expiresAt.IsZero() {
+	expiresAt := entry.expiresAt
+	if !entry.freshUntil.IsZero() {
+		expiresAt = entry.freshUntil
+	}
+	if expiresAt.IsZero() {
 		return 0, nil // No expiration
 	}
 
-	ttl := time.Until(entry.expiresAt)
+	ttl := time.Until(expiresAt)
 	if ttl < 0 {
 		return 0, ErrCacheExpired
 	}
@@ -375,88 +522,76 @@ func (c *cacheManagerImpl) ClearRequestCache(requestID string) error {
 
 // Invalidate removes all cache entries matching a pattern
 func (c *cacheManagerImpl) Invalidate(pattern string) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	// Simple pattern matching (supports * wildcard)
-	keysToDelete := []string{}
-	for key := range c.cache {
-		if matchPattern(key, pattern) {
-			keysToDelete = append(keysToDelete, key)
-		}
-	}
-
-	for _, key := range keysToDelete {
-		delete(c.cache, key)
-	}
+	inv := c.invalidation(CacheInvalidatePattern, pattern)
+	c.invalidate(inv)
 
 	// Also invalidate in distributed cache if available
+	var err error
 	if c.distributed != nil {
-		return c.distributed.Invalidate(pattern)
+		err = c.distributed.Invalidate(pattern)
 	}
 
-	return nil
+	return c.publish(err, inv)
+}
+
+// TaggedCache is implemented by cache managers that can tag entries and
+// invalidate them by tag. The cache manager of the framework implements it;
+// it is separate from CacheManager so that existing implementations keep
+// compiling.
+type TaggedCache interface {
+	SetWithTags(key string, value interface{}, ttl time.Duration, tags []string) error
+	InvalidateTag(tag string) error
 }
 
 // InvalidateTag removes all cache entries with a specific tag
 func (c *cacheManagerImpl) InvalidateTag(tag string) error {
-	c.tagMu.RLock()
-	keys, exists := c.tagIndex[tag]
-	c.tagMu.RUnlock()
-
-	if !exists {
-		return nil
-	}
-
-	// Delete all keys with this tag
-	c.mu.Lock()
-	for _, key := range keys {
-		delete(c.cache, key)
-	}
-	c.mu.Unlock()
-
-	// Remove tag from index
-	c.tagMu.Lock()
-	delete(c.tagIndex, tag)
-	c.tagMu.Unlock()
+	inv := c.invalidation(CacheInvalidateTag, tag)
+	c.invalidate(inv)
 
 	// Also invalidate in distributed cache if available
+	var err error
 	if c.distributed != nil {
-		return c.distributed.InvalidateTag(tag)
+		err = c.distributed.InvalidateTag(tag)
 	}
 
-	return nil
+	return c.publish(err, inv)
 }
 
 // SetWithTags stores a value with tags for invalidation
 func (c *cacheManagerImpl) SetWithTags(key string, value interface{}, ttl time.Duration, tags []string) error {
-	entry := &cacheEntry{
-		value: value,
-		tags:  tags,
-	}
-
-	// Use DefaultTTL if ttl is 0 and DefaultTTL is configured
-	effectiveTTL := ttl
-	if ttl == 0 && c.config.DefaultTTL > 0 {
-		effectiveTTL = c.config.DefaultTTL
-	}
-
-	if effectiveTTL > 0 {
-		entry.expiresAt = time.Now().Add(effectiveTTL)
+	expiresAt, _ := c.expiresAt(ttl)
+	inv := c.invalidation(CacheInvalidateKey, key)
+	if !c.store.set(key, value, expiresAt, tags, inv.Version) {
+		return nil
 	}
 
-	c.mu.Lock()
-	c.cache[key] = entry
-	c.mu.Unlock()
-
 	// Update tag index
 	c.tagMu.Lock()
 	for _, tag := range tags {
-		c.tagIndex[tag] = append(c.tagIndex[tag], key)
+		if c.tagIndex[tag] == nil {
+			c.tagIndex[tag] = make(map[string]struct{})
+		}
+		c.tagIndex[tag][key] = struct{}{}
 	}
 	c.tagMu.Unlock()
 
-	return nil
+	return c.publish(nil, inv)
+}
+
+// untag removes an evicted or deleted entry from the tag index
+func (c *cacheManagerImpl) untag(e *cacheEntry) {
+	c.tagMu.Lock()
+	defer c.tagMu.Unlock()
+
+	for _, tag := range e.tags {
+		if keys := c.tagIndex[tag]; keys != nil {
+			delete(keys, e.key)
+			if len(keys) == 0 {
+				delete(c.tagIndex, tag)
+			}
+		}
+	}
 }
 
 // matchPattern performs simple wildcard pattern matching
@@ -476,18 +611,63 @@ func matchPattern(str, pattern string) bool {
 
 // CleanupExpired removes all expired entries from cache
 func (c *cacheManagerImpl) CleanupExpired() int {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	count := 0
-	for key, entry := range c.cache {
-		if entry.isExpired() {
-			delete(c.cache, key)
-			count++
+	count := c.store.removeIf(func(e *cacheEntry) bool {
+		return e.isExpired()
+	})
+	c.maybeReportMetrics()
+	return count
+}
+
+// maybeReportMetrics reports the cache counters if the last report is older
+// than cacheMetricsInterval
+func (c *cacheManagerImpl) maybeReportMetrics() {
+	if c.metrics == nil {
+		return
+	}
+	now := time.Now().UnixNano()
+	last := c.lastReport.Load()
+	if now-last < int64(cacheMetricsInterval) || !c.lastReport.CompareAndSwap(last, now) {
+		return
+	}
+	c.reportMetrics()
+}
+
+// reportMetrics reports the counters since the last report and the current size
+func (c *cacheManagerImpl) reportMetrics() {
+	stats := c.store.stats()
+	stats.loads = c.loadCount.Load()
+	stats.loadErrors = c.loadErrors.Load()
+	stats.remoteInvalidations = c.remoteInvalidations.Load()
+
+	c.reportMu.Lock()
+	defer c.reportMu.Unlock()
+
+	for _, counter := range []struct {
+		name    string
+		current int64
+		last    int64
+	}{
+		{"cache.hits", stats.hits, c.reported.hits},
+		{"cache.misses", stats.misses, c.reported.misses},
+		{"cache.evictions", stats.evictions, c.reported.evictions},
+		{"cache.loads", stats.loads, c.reported.loads},
+		{"cache.load_errors", stats.loadErrors, c.reported.loadErrors},
+		{"cache.remote_invalidations", stats.remoteInvalidations, c.reported.remoteInvalidations},
+	} {
+		if delta := counter.current - counter.last; delta > 0 {
+			_ = c.metrics.IncrementCounterBy(counter.name, delta, nil)
 		}
 	}
+	_ = c.metrics.SetGauge("cache.size", float64(stats.size), nil)
+	_ = c.metrics.SetGauge("cache.entries", float64(stats.entries), nil)
+	c.reported = stats
+}
 
-	return count
+// DistributedCacheLocker is implemented by distributed caches that can set a
+// key only if it does not exist. GetOrLoad uses it for the lock that lets a
+// single instance load a missing value.
+type DistributedCacheLocker interface {
+	SetIfAbsent(key string, value interface{}, ttl time.Duration) (bool, error)
 }
 
 // DistributedCache defines the interface for distributed caching backends
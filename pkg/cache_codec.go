@@ -0,0 +1,262 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Codecs of values stored in the distributed cache
+const (
+	// CacheCodecJSON encodes values with encoding/json
+	CacheCodecJSON = "json"
+	// CacheCodecGob encodes values with encoding/gob
+	CacheCodecGob = "gob"
+	// CacheCodecMessagePack encodes values in MessagePack
+	CacheCodecMessagePack = "msgpack"
+)
+
+var (
+	// ErrCacheDecode is returned when a value read from the distributed
+	// cache cannot be decoded
+	ErrCacheDecode = errors.New("cache value cannot be decoded")
+	// ErrCacheTypeMismatch is returned when a cached value does not have the
+	// requested type
+	ErrCacheTypeMismatch = errors.New("cache value has a different type")
+)
+
+// CacheSerializationConfig configures how values are encoded in the
+// distributed cache
+type CacheSerializationConfig struct {
+	// Codec encodes the values. Codecs added with RegisterCacheCodec can be
+	// used by name.
+	// Supported values: "json", "gob", "msgpack"
+	// Default: "json"
+	Codec string
+
+	// CompressionThreshold is the encoded size in bytes from which values
+	// are compressed with gzip. 0 disables compression.
+	// Default: 0 (disabled)
+	CompressionThreshold int
+
+	// EncryptionKey encrypts values with AES-GCM when set. It must be 16,
+	// 24 or 32 bytes long. Instances sharing the distributed cache need the
+	// same key.
+	// Default: nil (not encrypted)
+	EncryptionKey []byte
+}
+
+// CacheCodec serializes the values stored in the distributed cache
+type CacheCodec interface {
+	// Name identifies the codec in encoded values
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	cacheCodecsMu sync.RWMutex
+	cacheCodecs   = map[string]CacheCodec{
+		CacheCodecJSON:        jsonCacheCodec{},
+		CacheCodecGob:         gobCacheCodec{},
+		CacheCodecMessagePack: messagePackCacheCodec{},
+	}
+)
+
+// RegisterCacheCodec makes codec available to CacheSerializationConfig.Codec
+// under its name. Values are decoded with the codec they were encoded with,
+// so a codec must stay registered while values encoded with it are cached.
+func RegisterCacheCodec(codec CacheCodec) {
+	cacheCodecsMu.Lock()
+	defer cacheCodecsMu.Unlock()
+	cacheCodecs[codec.Name()] = codec
+}
+
+// lookupCacheCodec returns the codec registered under name
+func lookupCacheCodec(name string) (CacheCodec, bool) {
+	cacheCodecsMu.RLock()
+	defer cacheCodecsMu.RUnlock()
+	codec, ok := cacheCodecs[name]
+	return codec, ok
+}
+
+// jsonCacheCodec encodes values with encoding/json
+type jsonCacheCodec struct{}
+
+func (jsonCacheCodec) Name() string { return CacheCodecJSON }
+
+func (jsonCacheCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCacheCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// gobCacheCodec encodes values with encoding/gob. Gob keeps Go types
+// exactly, but values can only be decoded into their type, so they have to
+// be read with GetTyped or GetOrLoadTyped.
+type gobCacheCodec struct{}
+
+func (gobCacheCodec) Name() string { return CacheCodecGob }
+
+func (gobCacheCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCacheCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Envelope of values in the distributed cache: a magic byte, the format
+// version, flags, the length and name of the codec, then the payload
+const (
+	cacheEnvelopeMagic   = 0xc1 // never used by MessagePack, rare elsewhere
+	cacheEnvelopeVersion = 1
+
+	cacheEnvelopeCompressed = 1 << 0
+	cacheEnvelopeEncrypted  = 1 << 1
+)
+
+// cacheSerializer encodes values for the distributed cache
+type cacheSerializer struct {
+	codec                CacheCodec
+	compressionThreshold int
+	aead                 cipher.AEAD
+
+	// err is returned by every call if the configuration is invalid, so
+	// that a bad encryption key never stores plaintext
+	err error
+}
+
+// newCacheSerializer creates the serializer for config
+func newCacheSerializer(config CacheSerializationConfig) *cacheSerializer {
+	s := &cacheSerializer{compressionThreshold: config.CompressionThreshold}
+
+	codec, ok := lookupCacheCodec(config.Codec)
+	if !ok {
+		s.err = fmt.Errorf("unknown cache codec %q", config.Codec)
+		return s
+	}
+	s.codec = codec
+
+	if len(config.EncryptionKey) > 0 {
+		block, err := aes.NewCipher(config.EncryptionKey)
+		if err != nil {
+			s.err = fmt.Errorf("invalid cache encryption key: %w", err)
+			return s
+		}
+		if s.aead, err = cipher.NewGCM(block); err != nil {
+			s.err = fmt.Errorf("invalid cache encryption key: %w", err)
+		}
+	}
+	return s
+}
+
+// encode returns the envelope of v
+func (s *cacheSerializer) encode(v interface{}) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	payload, err := s.codec.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode cache value: %w", err)
+	}
+
+	var flags byte
+	if s.compressionThreshold > 0 && len(payload) >= s.compressionThreshold {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(payload)
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress cache value: %w", err)
+		}
+		// Incompressible values are stored as they are
+		if buf.Len() < len(payload) {
+			payload = buf.Bytes()
+			flags |= cacheEnvelopeCompressed
+		}
+	}
+	if s.aead != nil {
+		flags |= cacheEnvelopeEncrypted
+	}
+
+	name := s.codec.Name()
+	header := append([]byte{cacheEnvelopeMagic, cacheEnvelopeVersion, flags, byte(len(name))}, name...)
+	if s.aead == nil {
+		return append(header, payload...), nil
+	}
+
+	// The header is authenticated, so its flags and codec cannot be changed
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	envelope := append(header, nonce...)
+	return s.aead.Seal(envelope, nonce, payload, header), nil
+}
+
+// isCacheEnvelope reports whether data looks like an encoded value
+func isCacheEnvelope(data []byte) bool {
+	return len(data) >= 4 && data[0] == cacheEnvelopeMagic && data[1] == cacheEnvelopeVersion
+}
+
+// decode decodes the envelope data into v, a pointer
+func (s *cacheSerializer) decode(data []byte, v interface{}) error {
+	if s.err != nil {
+		return s.err
+	}
+	if !isCacheEnvelope(data) {
+		return fmt.Errorf("%w: not an encoded value", ErrCacheDecode)
+	}
+	flags, nameLen := data[2], int(data[3])
+	if len(data) < 4+nameLen {
+		return fmt.Errorf("%w: truncated header", ErrCacheDecode)
+	}
+	header, payload := data[:4+nameLen], data[4+nameLen:]
+	codec, ok := lookupCacheCodec(string(header[4:]))
+	if !ok {
+		return fmt.Errorf("%w: unknown codec %q", ErrCacheDecode, header[4:])
+	}
+
+	if flags&cacheEnvelopeEncrypted != 0 {
+		if s.aead == nil {
+			return fmt.Errorf("%w: value is encrypted but no encryption key is configured", ErrCacheDecode)
+		}
+		nonceSize := s.aead.NonceSize()
+		if len(payload) < nonceSize {
+			return fmt.Errorf("%w: truncated ciphertext", ErrCacheDecode)
+		}
+		plaintext, err := s.aead.Open(nil, payload[:nonceSize], payload[nonceSize:], header)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCacheDecode, err)
+		}
+		payload = plaintext
+	} else if s.aead != nil {
+		// Reading plaintext would let anyone with write access inject values
+		return fmt.Errorf("%w: value is not encrypted", ErrCacheDecode)
+	}
+
+	if flags&cacheEnvelopeCompressed != 0 {
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCacheDecode, err)
+		}
+		if payload, err = io.ReadAll(r); err != nil {
+			return fmt.Errorf("%w: %v", ErrCacheDecode, err)
+		}
+	}
+
+	if err := codec.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrCacheDecode, err)
+	}
+	return nil
+}
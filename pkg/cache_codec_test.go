@@ -0,0 +1,152 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cachedProfile is a value stored in the distributed cache in tests
+type cachedProfile struct {
+	Name   string            `json:"name"`
+	Age    int               `json:"age"`
+	Score  float64           `json:"score"`
+	Tags   []string          `json:"tags"`
+	Labels map[string]string `json:"labels"`
+	Active bool              `json:"active"`
+}
+
+var testProfile = cachedProfile{
+	Name:   "Ada",
+	Age:    36,
+	Score:  -1.5,
+	Tags:   []string{"admin", strings.Repeat("x", 300)},
+	Labels: map[string]string{"team": "core"},
+	Active: true,
+}
+
+func TestCacheCodecs_RoundTrip(t *testing.T) {
+	for _, name := range []string{CacheCodecJSON, CacheCodecGob, CacheCodecMessagePack} {
+		s := newCacheSerializer(CacheSerializationConfig{Codec: name})
+		data, err := s.encode(testProfile)
+		if err != nil {
+			t.Fatalf("%s: failed to encode: %v", name, err)
+		}
+		var decoded cachedProfile
+		if err := s.decode(data, &decoded); err != nil {
+			t.Fatalf("%s: failed to decode: %v", name, err)
+		}
+		if !reflect.DeepEqual(decoded, testProfile) {
+			t.Errorf("%s: expected %+v, got %+v", name, testProfile, decoded)
+		}
+	}
+}
+
+func TestMessagePackCacheCodec(t *testing.T) {
+	codec := messagePackCacheCodec{}
+	data, err := codec.Marshal(map[string]interface{}{"a": 1, "b": []interface{}{true, nil, -5, 300, "hi"}})
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	want := []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x95, 0xc3, 0xc0, 0xfb, 0xd1, 0x01, 0x2c, 0xa2, 'h', 'i'}
+	if !bytes.Equal(data, want) {
+		t.Errorf("Expected % x, got % x", want, data)
+	}
+
+	// Values of other encoders use the wider formats
+	var decoded map[string]interface{}
+	other := []byte{0x81, 0xd9, 0x01, 'n', 0xcf, 0, 0, 0, 0, 0, 0, 0x01, 0x00}
+	if err := codec.Unmarshal(other, &decoded); err != nil || decoded["n"] != float64(256) {
+		t.Errorf("Expected n=256, got %v, %v", decoded, err)
+	}
+
+	for _, invalid := range [][]byte{{0x92, 0x01}, {0xa5, 'a'}, {0xc1}, {0x01, 0x02}} {
+		if err := codec.Unmarshal(invalid, &decoded); err == nil {
+			t.Errorf("Expected an error for % x", invalid)
+		}
+	}
+}
+
+func TestCacheSerializer_CompressionAndEncryption(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	plain := newCacheSerializer(CacheSerializationConfig{Codec: CacheCodecJSON})
+	compressed := newCacheSerializer(CacheSerializationConfig{Codec: CacheCodecJSON, CompressionThreshold: 100})
+	encrypted := newCacheSerializer(CacheSerializationConfig{Codec: CacheCodecJSON, CompressionThreshold: 100, EncryptionKey: key})
+
+	plainData, _ := plain.encode(testProfile)
+	compressedData, _ := compressed.encode(testProfile)
+	if len(compressedData) >= len(plainData) || compressedData[2]&cacheEnvelopeCompressed == 0 {
+		t.Errorf("Expected a compressed value, got %d bytes from %d", len(compressedData), len(plainData))
+	}
+	if small, _ := compressed.encode("small"); small[2]&cacheEnvelopeCompressed != 0 {
+		t.Error("Expected values below the threshold not to be compressed")
+	}
+
+	encryptedData, err := encrypted.encode(testProfile)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	if bytes.Contains(encryptedData, []byte("Ada")) {
+		t.Error("Expected the value to be encrypted")
+	}
+	var decoded cachedProfile
+	if err := encrypted.decode(encryptedData, &decoded); err != nil || !reflect.DeepEqual(decoded, testProfile) {
+		t.Errorf("Failed to decrypt: %v", err)
+	}
+
+	// Tampering, other keys and unencrypted values are detected
+	tampered := append([]byte(nil), encryptedData...)
+	tampered[2] &^= cacheEnvelopeCompressed
+	otherKey := newCacheSerializer(CacheSerializationConfig{Codec: CacheCodecJSON, EncryptionKey: []byte("fedcba9876543210fedcba9876543210")})
+	for name, check := range map[string]error{
+		"tampered":    encrypted.decode(tampered, &decoded),
+		"other key":   otherKey.decode(encryptedData, &decoded),
+		"unencrypted": encrypted.decode(plainData, &decoded),
+		"no key":      plain.decode(encryptedData, &decoded),
+		"garbage":     plain.decode([]byte("garbage"), &decoded),
+	} {
+		if !errors.Is(check, ErrCacheDecode) {
+			t.Errorf("%s: expected ErrCacheDecode, got %v", name, check)
+		}
+	}
+
+	// A bad key never stores plaintext
+	invalid := newCacheSerializer(CacheSerializationConfig{Codec: CacheCodecJSON, EncryptionKey: []byte("short")})
+	if _, err := invalid.encode(testProfile); err == nil {
+		t.Error("Expected an invalid key to fail encoding")
+	}
+}
+
+// upperCacheCodec is a custom codec storing strings in upper case
+type upperCacheCodec struct{}
+
+func (upperCacheCodec) Name() string { return "upper" }
+
+func (upperCacheCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(strings.ToUpper(v.(string))), nil
+}
+
+func (upperCacheCodec) Unmarshal(data []byte, v interface{}) error {
+	reflect.ValueOf(v).Elem().Set(reflect.ValueOf(string(data)))
+	return nil
+}
+
+func TestRegisterCacheCodec(t *testing.T) {
+	RegisterCacheCodec(upperCacheCodec{})
+	custom := newCacheSerializer(CacheSerializationConfig{Codec: "upper"})
+	data, err := custom.encode("hello")
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	// Values are decoded with the codec that encoded them
+	var decoded string
+	if err := newCacheSerializer(CacheSerializationConfig{Codec: CacheCodecJSON}).decode(data, &decoded); err != nil || decoded != "HELLO" {
+		t.Errorf("Expected HELLO, got %q, %v", decoded, err)
+	}
+	if _, err := newCacheSerializer(CacheSerializationConfig{Codec: "missing"}).encode("hello"); err == nil {
+		t.Error("Expected an unknown codec to fail")
+	}
+}
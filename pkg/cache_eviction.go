@@ -0,0 +1,318 @@
+package pkg
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// evictionPolicy orders the entries of a size-bounded cache shard. The shard
+// calls it with its lock held.
+type evictionPolicy interface {
+	// record notes a lookup of the key with hash, whether it hit or not
+	record(hash uint64)
+	// add tracks a new entry
+	add(e *cacheEntry)
+	// access notes a hit on e
+	access(e *cacheEntry)
+	// remove stops tracking e
+	remove(e *cacheEntry)
+	// evict stops tracking and returns the entry to evict next, or nil if
+	// no entries are tracked
+	evict() *cacheEntry
+}
+
+// newEvictionPolicy creates the policy name for a shard of maxSize bytes
+func newEvictionPolicy(name string, maxSize int64) evictionPolicy {
+	switch name {
+	case CacheEvictionLFU:
+		return &lfuPolicy{}
+	case CacheEvictionTinyLFU:
+		return newTinyLFUPolicy(maxSize)
+	default:
+		return &lruPolicy{order: list.New()}
+	}
+}
+
+// lruPolicy evicts the least recently used entry
+type lruPolicy struct {
+	order *list.List // most recently used first
+}
+
+func (p *lruPolicy) record(hash uint64) {}
+
+func (p *lruPolicy) add(e *cacheEntry) {
+	e.element = p.order.PushFront(e)
+}
+
+func (p *lruPolicy) access(e *cacheEntry) {
+	p.order.MoveToFront(e.element)
+}
+
+func (p *lruPolicy) remove(e *cacheEntry) {
+	p.order.Remove(e.element)
+	e.element = nil
+}
+
+func (p *lruPolicy) evict() *cacheEntry {
+	back := p.order.Back()
+	if back == nil {
+		return nil
+	}
+	e := back.Value.(*cacheEntry)
+	p.remove(e)
+	return e
+}
+
+// lfuPolicy evicts the least frequently used entry, and of those the one
+// used least recently
+type lfuPolicy struct {
+	entries lfuHeap
+	clock   uint64
+}
+
+func (p *lfuPolicy) record(hash uint64) {}
+
+func (p *lfuPolicy) add(e *cacheEntry) {
+	// An overwritten entry keeps the frequency of the value it replaces
+	e.frequency++
+	p.clock++
+	e.lastUse = p.clock
+	heap.Push(&p.entries, e)
+}
+
+func (p *lfuPolicy) access(e *cacheEntry) {
+	e.frequency++
+	p.clock++
+	e.lastUse = p.clock
+	heap.Fix(&p.entries, e.index)
+}
+
+func (p *lfuPolicy) remove(e *cacheEntry) {
+	heap.Remove(&p.entries, e.index)
+}
+
+func (p *lfuPolicy) evict() *cacheEntry {
+	if len(p.entries) == 0 {
+		return nil
+	}
+	return heap.Pop(&p.entries).(*cacheEntry)
+}
+
+// lfuHeap is a min-heap of entries by frequency and last use
+type lfuHeap []*cacheEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].frequency != h[j].frequency {
+		return h[i].frequency < h[j].frequency
+	}
+	return h[i].lastUse < h[j].lastUse
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*cacheEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	e.index = -1
+	return e
+}
+
+// Segments of the W-TinyLFU policy
+const (
+	segmentWindow = iota
+	segmentProbation
+	segmentProtected
+)
+
+// tinyLFUPolicy implements W-TinyLFU: new entries enter a small LRU window.
+// Entries leaving the window join the probation segment of a segmented LRU,
+// and are only kept over its victim when their estimated access frequency is
+// higher. Entries hit in probation move to the protected segment. This keeps
+// frequently used entries cached through scans of one-off keys.
+type tinyLFUPolicy struct {
+	sketch                      *frequencySketch
+	window                      *list.List
+	probation                   *list.List
+	protected                   *list.List
+	windowSize, windowMax       int64
+	protectedSize, protectedMax int64
+}
+
+func newTinyLFUPolicy(maxSize int64) *tinyLFUPolicy {
+	windowMax := maxSize / 100
+	if windowMax < 1 {
+		windowMax = 1
+	}
+	return &tinyLFUPolicy{
+		// Assume entries of about 256 bytes to size the sketch
+		sketch:       newFrequencySketch(maxSize / 256),
+		window:       list.New(),
+		probation:    list.New(),
+		protected:    list.New(),
+		windowMax:    windowMax,
+		protectedMax: (maxSize - windowMax) * 8 / 10,
+	}
+}
+
+func (p *tinyLFUPolicy) record(hash uint64) {
+	p.sketch.increment(hash)
+}
+
+func (p *tinyLFUPolicy) add(e *cacheEntry) {
+	e.segment = segmentWindow
+	e.element = p.window.PushFront(e)
+	p.windowSize += e.size
+
+	// Entries leaving the window become candidates for the main segments
+	for p.windowSize > p.windowMax && p.window.Len() > 1 {
+		candidate := p.window.Back().Value.(*cacheEntry)
+		p.window.Remove(candidate.element)
+		p.windowSize -= candidate.size
+		candidate.segment = segmentProbation
+		candidate.candidate = true
+		candidate.element = p.probation.PushFront(candidate)
+	}
+}
+
+func (p *tinyLFUPolicy) access(e *cacheEntry) {
+	switch e.segment {
+	case segmentWindow:
+		p.window.MoveToFront(e.element)
+	case segmentProbation:
+		p.probation.Remove(e.element)
+		e.segment = segmentProtected
+		e.candidate = false
+		e.element = p.protected.PushFront(e)
+		p.protectedSize += e.size
+
+		// Demote the least recently used protected entries
+		for p.protectedSize > p.protectedMax && p.protected.Len() > 1 {
+			demoted := p.protected.Back().Value.(*cacheEntry)
+			p.protected.Remove(demoted.element)
+			p.protectedSize -= demoted.size
+			demoted.segment = segmentProbation
+			demoted.element = p.probation.PushFront(demoted)
+		}
+	case segmentProtected:
+		p.protected.MoveToFront(e.element)
+	}
+}
+
+func (p *tinyLFUPolicy) remove(e *cacheEntry) {
+	switch e.segment {
+	case segmentWindow:
+		p.window.Remove(e.element)
+		p.windowSize -= e.size
+	case segmentProbation:
+		p.probation.Remove(e.element)
+	case segmentProtected:
+		p.protected.Remove(e.element)
+		p.protectedSize -= e.size
+	}
+	e.element = nil
+}
+
+func (p *tinyLFUPolicy) evict() *cacheEntry {
+	var victim *cacheEntry
+	switch {
+	case p.probation.Len() > 0:
+		victim = p.probation.Back().Value.(*cacheEntry)
+	case p.protected.Len() > 0:
+		victim = p.protected.Back().Value.(*cacheEntry)
+	case p.window.Len() > 0:
+		victim = p.window.Back().Value.(*cacheEntry)
+	default:
+		return nil
+	}
+
+	// The newest candidate from the window competes with the victim
+	if front := p.probation.Front(); front != nil {
+		if candidate := front.Value.(*cacheEntry); candidate.candidate && candidate != victim {
+			candidate.candidate = false
+			if p.sketch.estimate(candidate.hash) <= p.sketch.estimate(victim.hash) {
+				victim = candidate
+			}
+		}
+	}
+	p.remove(victim)
+	return victim
+}
+
+// frequencySketch is a count-min sketch of 4-bit counters that estimates how
+// often a key was accessed. Counters are halved periodically so that the
+// estimates follow changes in popularity.
+type frequencySketch struct {
+	rows      [4][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+// frequencySketchSeeds derive the row indexes from a key hash
+var frequencySketchSeeds = [4]uint64{0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325}
+
+// newFrequencySketch creates a sketch for about width distinct keys
+func newFrequencySketch(width int64) *frequencySketch {
+	size := int64(64)
+	for size < width && size < 1<<20 {
+		size <<= 1
+	}
+	s := &frequencySketch{mask: uint64(size - 1), resetAt: int(size) * 10}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, size)
+	}
+	return s
+}
+
+// index returns the counter of hash in row i
+func (s *frequencySketch) index(hash uint64, i int) uint64 {
+	h := (hash ^ frequencySketchSeeds[i]) * 0x9e3779b97f4a7c15
+	return (h >> 32) & s.mask
+}
+
+// increment counts an access to hash
+func (s *frequencySketch) increment(hash uint64) {
+	added := false
+	for i := range s.rows {
+		if idx := s.index(hash, i); s.rows[i][idx] < 15 {
+			s.rows[i][idx]++
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+	if s.additions++; s.additions >= s.resetAt {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] >>= 1
+			}
+		}
+		s.additions /= 2
+	}
+}
+
+// estimate returns the estimated access count of hash
+func (s *frequencySketch) estimate(hash uint64) uint8 {
+	min := uint8(15)
+	for i := range s.rows {
+		if v := s.rows[i][s.index(hash, i)]; v < min {
+			min = v
+		}
+	}
+	return min
+}
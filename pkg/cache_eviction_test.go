@@ -0,0 +1,201 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// newBoundedCache creates a cache of maxSize bytes using policy
+func newBoundedCache(maxSize int64, policy string) *cacheManagerImpl {
+	return NewCacheManager(CacheConfig{MaxSize: maxSize, EvictionPolicy: policy}).(*cacheManagerImpl)
+}
+
+// value returns a string that makes an entry of key size bytes
+func value(key string, size int) string {
+	return strings.Repeat("x", size-len(key))
+}
+
+func TestCacheManager_SizeAccounting(t *testing.T) {
+	cache := newBoundedCache(1000, CacheEvictionLRU)
+
+	cache.Set("a", value("a", 100), 0)
+	cache.Set("b", value("b", 200), 0)
+	if stats := cache.store.stats(); stats.size != 300 || stats.entries != 2 {
+		t.Fatalf("Expected 300 bytes in 2 entries, got %+v", stats)
+	}
+
+	// Overwriting replaces the size of the old value
+	cache.Set("a", value("a", 50), 0)
+	cache.Delete("b")
+	if stats := cache.store.stats(); stats.size != 50 || stats.entries != 1 {
+		t.Fatalf("Expected 50 bytes in 1 entry, got %+v", stats)
+	}
+
+	// An entry larger than the cache is not stored
+	cache.Set("huge", value("huge", 2000), 0)
+	if cache.Exists("huge") || !cache.Exists("a") {
+		t.Error("Expected an oversized entry to be rejected without evicting others")
+	}
+
+	cache.Clear()
+	if stats := cache.store.stats(); stats.size != 0 || stats.entries != 0 {
+		t.Errorf("Expected an empty cache after Clear, got %+v", stats)
+	}
+}
+
+func TestCacheManager_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newBoundedCache(400, CacheEvictionLRU)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		cache.Set(key, value(key, 100), 0)
+	}
+	cache.Get("a")
+	cache.Set("e", value("e", 100), 0)
+
+	if cache.Exists("b") {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	for _, key := range []string{"a", "c", "d", "e"} {
+		if !cache.Exists(key) {
+			t.Errorf("Expected %s to be cached", key)
+		}
+	}
+	if stats := cache.store.stats(); stats.size > 400 || stats.evictions != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestCacheManager_EvictsLeastFrequentlyUsed(t *testing.T) {
+	cache := newBoundedCache(400, CacheEvictionLFU)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		cache.Set(key, value(key, 100), 0)
+	}
+	for i := 0; i < 3; i++ {
+		cache.Get("a")
+		cache.Get("b")
+		cache.Get("d")
+	}
+	cache.Get("c")
+	cache.Get("a")
+
+	// c was used least often, though more recently than b and d
+	cache.Set("e", value("e", 100), 0)
+	if cache.Exists("c") {
+		t.Error("Expected the least frequently used entry to be evicted")
+	}
+	for _, key := range []string{"a", "b", "d", "e"} {
+		if !cache.Exists(key) {
+			t.Errorf("Expected %s to be cached", key)
+		}
+	}
+}
+
+func TestCacheManager_TinyLFUKeepsHotEntriesThroughScans(t *testing.T) {
+	survivors := func(policy string) int {
+		cache := newBoundedCache(10000, policy)
+		hot := make([]string, 40)
+		for i := range hot {
+			hot[i] = fmt.Sprintf("hot-%d", i)
+			cache.Set(hot[i], value(hot[i], 100), 0)
+		}
+		for round := 0; round < 5; round++ {
+			for _, key := range hot {
+				cache.Get(key)
+			}
+		}
+
+		// A scan of keys used once, e.g. a crawler paging through results
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("scan-%d", i)
+			if _, err := cache.Get(key); err != nil {
+				cache.Set(key, value(key, 100), 0)
+			}
+		}
+
+		count := 0
+		for _, key := range hot {
+			if cache.Exists(key) {
+				count++
+			}
+		}
+		if stats := cache.store.stats(); stats.size > 10000 {
+			t.Errorf("%s: cache exceeds its size limit: %+v", policy, stats)
+		}
+		return count
+	}
+
+	if lru := survivors(CacheEvictionLRU); lru != 0 {
+		t.Errorf("Expected the scan to evict all hot entries under LRU, %d survived", lru)
+	}
+	if tinyLFU := survivors(CacheEvictionTinyLFU); tinyLFU < 36 {
+		t.Errorf("Expected hot entries to survive the scan under W-TinyLFU, only %d of 40 did", tinyLFU)
+	}
+}
+
+func TestCacheManager_EvictionRemovesTags(t *testing.T) {
+	cache := newBoundedCache(200, CacheEvictionLRU)
+	cache.SetWithTags("a", value("a", 100), 0, []string{"users"})
+	cache.SetWithTags("b", value("b", 100), 0, []string{"users"})
+	cache.Set("c", value("c", 100), 0)
+
+	cache.tagMu.RLock()
+	_, indexed := cache.tagIndex["users"]["a"]
+	cache.tagMu.RUnlock()
+	if cache.Exists("a") || indexed {
+		t.Error("Expected the evicted entry to be removed from the tag index")
+	}
+	cache.InvalidateTag("users")
+	if cache.Exists("b") || !cache.Exists("c") {
+		t.Error("Expected InvalidateTag to remove the tagged entries only")
+	}
+}
+
+func TestCacheShardCount(t *testing.T) {
+	tests := []struct {
+		config CacheConfig
+		want   int
+	}{
+		{CacheConfig{Shards: 16}, 16},
+		{CacheConfig{Shards: 10}, 16},
+		{CacheConfig{Shards: 16, MaxSize: 1000}, 1},
+		{CacheConfig{Shards: 16, MaxSize: 4 << 20}, 4},
+		{CacheConfig{Shards: 16, MaxSize: 1 << 30}, 16},
+	}
+	for _, tt := range tests {
+		if got := cacheShardCount(tt.config); got != tt.want {
+			t.Errorf("%+v: expected %d shards, got %d", tt.config, tt.want, got)
+		}
+	}
+}
+
+func TestCacheManager_ReportsMetrics(t *testing.T) {
+	metrics := NewMetricsCollector(NewNoopDatabaseManager())
+	cache := newBoundedCache(200, CacheEvictionLRU)
+	cache.setMetrics(metrics)
+
+	cache.Set("a", value("a", 100), 0)
+	cache.Set("b", value("b", 100), 0)
+	cache.Set("c", value("c", 100), 0)
+	cache.Get("c")
+	cache.Get("c")
+	cache.Get("a")
+	cache.reportMetrics()
+
+	exported, _ := metrics.Export()
+	counters := exported["counters"].(map[string]int64)
+	gauges := exported["gauges"].(map[string]float64)
+	if counters["cache.hits"] != 2 || counters["cache.misses"] != 1 || counters["cache.evictions"] != 1 {
+		t.Errorf("Unexpected counters %v", counters)
+	}
+	if gauges["cache.size"] != 200 || gauges["cache.entries"] != 2 {
+		t.Errorf("Unexpected gauges %v", gauges)
+	}
+
+	// Only the counts since the last report are added
+	cache.Get("b")
+	cache.reportMetrics()
+	exported, _ = metrics.Export()
+	if hits := exported["counters"].(map[string]int64)["cache.hits"]; hits != 3 {
+		t.Errorf("Expected 3 hits, got %d", hits)
+	}
+}
@@ -0,0 +1,590 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of cache invalidations
+const (
+	// CacheInvalidateKey removes a single key
+	CacheInvalidateKey = "key"
+	// CacheInvalidatePattern removes the keys matching a pattern
+	CacheInvalidatePattern = "pattern"
+	// CacheInvalidateTag removes the keys with a tag
+	CacheInvalidateTag = "tag"
+	// CacheInvalidateAll removes all keys
+	CacheInvalidateAll = "all"
+)
+
+// CacheInvalidationDatabase selects the invalidation bus that exchanges
+// invalidations through the cache_invalidations table
+const CacheInvalidationDatabase = "database"
+
+// cacheInvalidationGapWait is how long the database bus waits for an
+// invalidation whose ID was skipped, because its transaction may commit
+// after those of higher IDs
+const cacheInvalidationGapWait = 10 * time.Second
+
+// ErrInvalidationBusClosed is returned when publishing to or subscribing to
+// a closed invalidation bus
+var ErrInvalidationBusClosed = errors.New("cache invalidation bus closed")
+
+// CacheInvalidation describes entries to remove from the in-memory caches of
+// all instances
+type CacheInvalidation struct {
+	// Source is the instance ID of the cache that published the invalidation
+	Source string
+	// Kind is CacheInvalidateKey, CacheInvalidatePattern, CacheInvalidateTag or CacheInvalidateAll
+	Kind string
+	// Value is the key, pattern or tag
+	Value string
+	// Version orders the invalidation against the entry versions of the
+	// publishing instance. Receiving instances ignore it and order the
+	// invalidation by the time it arrives, because their clocks may differ.
+	Version uint64
+}
+
+// CacheInvalidationBus broadcasts cache invalidations between instances
+type CacheInvalidationBus interface {
+	// Publish sends inv to the subscribers of all instances
+	Publish(inv CacheInvalidation) error
+	// Subscribe registers handler for invalidations published on the bus,
+	// including those of the subscriber's own instance
+	Subscribe(handler func(inv CacheInvalidation)) error
+	// Close stops delivering invalidations
+	Close() error
+}
+
+// CacheInvalidationConfig configures how in-memory cache entries are
+// invalidated across instances
+type CacheInvalidationConfig struct {
+	// Bus selects the invalidation bus the framework creates.
+	// Supported values: "" (none), "database"
+	// Default: "" (none)
+	Bus string
+
+	// InstanceID identifies this instance on the bus.
+	// Default: host name with a random suffix
+	InstanceID string
+
+	// PollInterval is how often the database bus reads new invalidations.
+	// Default: 1 second
+	PollInterval time.Duration
+
+	// BatchSize is the maximum number of invalidations read per poll.
+	// Default: 500
+	BatchSize int
+
+	// Retention is how long invalidations are kept in the database.
+	// Default: 10 minutes
+	Retention time.Duration
+
+	// TombstoneTTL is how long an invalidated key rejects entries loaded
+	// before the invalidation. It should exceed the longest load.
+	// Default: 1 minute
+	TombstoneTTL time.Duration
+}
+
+// cacheClock issues the entry versions of an instance: the wall clock in
+// nanoseconds, increased past every issued version. Versions are only
+// compared within the instance that issued them.
+type cacheClock struct {
+	last atomic.Uint64
+}
+
+// next returns a version higher than all versions issued
+func (c *cacheClock) next() uint64 {
+	for {
+		last := c.last.Load()
+		version := uint64(time.Now().UnixNano())
+		if version <= last {
+			version = last + 1
+		}
+		if c.last.CompareAndSwap(last, version) {
+			return version
+		}
+	}
+}
+
+// cacheTombstone records the version of an invalidation
+type cacheTombstone struct {
+	version uint64
+	at      time.Time
+}
+
+// cacheTombstones remember recent invalidations, so that a value loaded
+// before an invalidation is not cached after it
+type cacheTombstones struct {
+	mu       sync.RWMutex
+	ttl      time.Duration
+	keys     map[string]cacheTombstone
+	patterns map[string]cacheTombstone
+	tags     map[string]cacheTombstone
+	all      cacheTombstone
+	pruned   time.Time
+}
+
+func newCacheTombstones(ttl time.Duration) *cacheTombstones {
+	return &cacheTombstones{
+		ttl:      ttl,
+		keys:     make(map[string]cacheTombstone),
+		patterns: make(map[string]cacheTombstone),
+		tags:     make(map[string]cacheTombstone),
+	}
+}
+
+// record remembers inv and forgets tombstones older than the TTL
+func (t *cacheTombstones) record(inv CacheInvalidation, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tombstone := cacheTombstone{version: inv.Version, at: now}
+	remember := func(m map[string]cacheTombstone) {
+		if existing, exists := m[inv.Value]; !exists || existing.version < inv.Version {
+			m[inv.Value] = tombstone
+		}
+	}
+	switch inv.Kind {
+	case CacheInvalidateKey:
+		remember(t.keys)
+	case CacheInvalidatePattern:
+		remember(t.patterns)
+	case CacheInvalidateTag:
+		remember(t.tags)
+	case CacheInvalidateAll:
+		if t.all.version < inv.Version {
+			t.all = tombstone
+		}
+	}
+
+	if now.Sub(t.pruned) < t.ttl {
+		return
+	}
+	t.pruned = now
+	for _, m := range []map[string]cacheTombstone{t.keys, t.patterns, t.tags} {
+		for value, tombstone := range m {
+			if now.Sub(tombstone.at) >= t.ttl {
+				delete(m, value)
+			}
+		}
+	}
+}
+
+// admits reports whether e is newer than all live invalidations covering it
+func (t *cacheTombstones) admits(e *cacheEntry) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if len(t.keys) == 0 && len(t.patterns) == 0 && len(t.tags) == 0 && t.all.version == 0 {
+		return true
+	}
+	now := time.Now()
+	covers := func(tombstone cacheTombstone) bool {
+		return tombstone.version >= e.version && now.Sub(tombstone.at) < t.ttl
+	}
+
+	if covers(t.all) {
+		return false
+	}
+	if tombstone, exists := t.keys[e.key]; exists && covers(tombstone) {
+		return false
+	}
+	for _, tag := range e.tags {
+		if tombstone, exists := t.tags[tag]; exists && covers(tombstone) {
+			return false
+		}
+	}
+	for pattern, tombstone := range t.patterns {
+		if covers(tombstone) && matchPattern(e.key, pattern) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewCacheManagerWithInvalidation creates a cache manager that publishes the
+// keys, patterns and tags it changes on bus and removes entries that other
+// instances change from its in-memory cache. distributed may be nil.
+func NewCacheManagerWithInvalidation(config CacheConfig, distributed DistributedCache, bus CacheInvalidationBus) (CacheManager, error) {
+	if distributed != nil && (config.Type == "" || config.Type == "memory") {
+		config.Type = "distributed"
+	}
+	if config.Invalidation.InstanceID == "" {
+		config.Invalidation.InstanceID = defaultInstanceID()
+	}
+
+	c := newCacheManager(config, distributed)
+	if err := bus.Subscribe(c.receiveInvalidation); err != nil {
+		return nil, fmt.Errorf("failed to subscribe to cache invalidations: %w", err)
+	}
+	c.busMu.Lock()
+	c.bus = bus
+	c.busMu.Unlock()
+	return c, nil
+}
+
+// invalidation returns a new invalidation of value by this instance
+func (c *cacheManagerImpl) invalidation(kind, value string) CacheInvalidation {
+	return CacheInvalidation{
+		Source:  c.config.Invalidation.InstanceID,
+		Kind:    kind,
+		Value:   value,
+		Version: c.clock.next(),
+	}
+}
+
+// invalidate removes the entries covered by inv that are not newer than it
+func (c *cacheManagerImpl) invalidate(inv CacheInvalidation) {
+	// The tombstone goes first, so that an entry stored while the matching
+	// entries are removed is either rejected or removed
+	c.tombstones.record(inv, time.Now())
+
+	switch inv.Kind {
+	case CacheInvalidateKey:
+		c.store.deleteVersion(inv.Value, inv.Version)
+	case CacheInvalidatePattern:
+		c.store.removeIf(func(e *cacheEntry) bool {
+			return e.version <= inv.Version && matchPattern(e.key, inv.Value)
+		})
+	case CacheInvalidateTag:
+		c.tagMu.RLock()
+		keys := make([]string, 0, len(c.tagIndex[inv.Value]))
+		for key := range c.tagIndex[inv.Value] {
+			keys = append(keys, key)
+		}
+		c.tagMu.RUnlock()
+
+		// Removed entries leave the tag index through untag
+		for _, key := range keys {
+			c.store.deleteVersion(key, inv.Version)
+		}
+	case CacheInvalidateAll:
+		c.store.removeIf(func(e *cacheEntry) bool {
+			return e.version <= inv.Version
+		})
+	}
+}
+
+// receiveInvalidation applies an invalidation published by another instance.
+// The invalidation gets a version of this instance's clock: the change was
+// made before it arrived, so every entry stored or loaded before then may be
+// stale, whatever the clock of the publishing instance says.
+func (c *cacheManagerImpl) receiveInvalidation(inv CacheInvalidation) {
+	if inv.Source == c.config.Invalidation.InstanceID {
+		return
+	}
+	inv.Version = c.clock.next()
+	c.invalidate(inv)
+	c.remoteInvalidations.Add(1)
+}
+
+// publish sends invs to the other instances. err is the result of the
+// operation that caused them and is returned if not nil.
+func (c *cacheManagerImpl) publish(err error, invs ...CacheInvalidation) error {
+	c.busMu.RLock()
+	bus := c.bus
+	c.busMu.RUnlock()
+	if bus == nil {
+		return err
+	}
+
+	for _, inv := range invs {
+		if publishErr := bus.Publish(inv); publishErr != nil && err == nil {
+			err = fmt.Errorf("failed to publish cache invalidation: %w", publishErr)
+		}
+	}
+	return err
+}
+
+// closeInvalidation stops publishing and receiving invalidations and closes the bus
+func (c *cacheManagerImpl) closeInvalidation() error {
+	c.busMu.Lock()
+	bus := c.bus
+	c.bus = nil
+	c.busMu.Unlock()
+
+	if bus == nil {
+		return nil
+	}
+	return bus.Close()
+}
+
+// inMemoryInvalidationBus delivers invalidations to the caches of this process
+type inMemoryInvalidationBus struct {
+	mu       sync.RWMutex
+	handlers []func(inv CacheInvalidation)
+	closed   bool
+}
+
+// NewInMemoryInvalidationBus creates a bus for cache managers in the same
+// process. Invalidations are delivered before Publish returns.
+func NewInMemoryInvalidationBus() CacheInvalidationBus {
+	return &inMemoryInvalidationBus{}
+}
+
+func (b *inMemoryInvalidationBus) Publish(inv CacheInvalidation) error {
+	b.mu.RLock()
+	handlers, closed := b.handlers, b.closed
+	b.mu.RUnlock()
+
+	if closed {
+		return ErrInvalidationBusClosed
+	}
+	for _, handler := range handlers {
+		handler(inv)
+	}
+	return nil
+}
+
+func (b *inMemoryInvalidationBus) Subscribe(handler func(inv CacheInvalidation)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrInvalidationBusClosed
+	}
+	b.handlers = append(b.handlers[:len(b.handlers):len(b.handlers)], handler)
+	return nil
+}
+
+func (b *inMemoryInvalidationBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	b.handlers = nil
+	return nil
+}
+
+// databaseInvalidationBus exchanges invalidations through the
+// cache_invalidations table, which every instance polls for new rows
+type databaseInvalidationBus struct {
+	db     DatabaseManager
+	config CacheInvalidationConfig
+
+	mu       sync.Mutex
+	handlers []func(inv CacheInvalidation)
+	stop     chan struct{}
+	done     chan struct{}
+	closed   bool
+
+	// Rows above floor are read on every poll. Rows up to high were read;
+	// gaps are the missing IDs below high with the time they were noticed
+	// and delivered the IDs above floor that were passed to the handlers.
+	floor     int64
+	high      int64
+	gaps      map[int64]time.Time
+	delivered map[int64]struct{}
+	cleaned   time.Time
+}
+
+// NewDatabaseInvalidationBus creates a bus that stores invalidations in the
+// database. Subscribers poll for invalidations every PollInterval, so other
+// instances serve a changed entry for up to that long.
+func NewDatabaseInvalidationBus(db DatabaseManager, config CacheInvalidationConfig) CacheInvalidationBus {
+	config.ApplyDefaults()
+	return &databaseInvalidationBus{
+		db:        db,
+		config:    config,
+		gaps:      make(map[int64]time.Time),
+		delivered: make(map[int64]struct{}),
+	}
+}
+
+// query loads a named query
+func (b *databaseInvalidationBus) query(name string) (string, error) {
+	query, err := b.db.GetQuery(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to load %s query: %w", name, err)
+	}
+	return query, nil
+}
+
+func (b *databaseInvalidationBus) Publish(inv CacheInvalidation) error {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+	if closed {
+		return ErrInvalidationBusClosed
+	}
+
+	query, err := b.query("insert_cache_invalidation")
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec(query, inv.Source, inv.Kind, inv.Value, int64(inv.Version), time.Now().UTC())
+	return err
+}
+
+// Subscribe registers handler. The first subscription starts polling for
+// invalidations published after it.
+func (b *databaseInvalidationBus) Subscribe(handler func(inv CacheInvalidation)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrInvalidationBusClosed
+	}
+	if b.stop == nil {
+		query, err := b.query("load_last_cache_invalidation_id")
+		if err != nil {
+			return err
+		}
+		var last int64
+		if err := b.db.QueryRow(query).Scan(&last); err != nil {
+			return fmt.Errorf("failed to load last cache invalidation: %w", err)
+		}
+		b.floor, b.high = last, last
+		b.stop = make(chan struct{})
+		b.done = make(chan struct{})
+		go b.run(b.stop, b.done)
+	}
+	b.handlers = append(b.handlers[:len(b.handlers):len(b.handlers)], handler)
+	return nil
+}
+
+// Close stops polling
+func (b *databaseInvalidationBus) Close() error {
+	b.mu.Lock()
+	stop, done := b.stop, b.done
+	b.closed = true
+	b.stop = nil
+	b.handlers = nil
+	b.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	return nil
+}
+
+// run polls until stop is closed
+func (b *databaseInvalidationBus) run(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(b.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := b.poll(context.Background(), time.Now()); err != nil {
+				fmt.Printf("WARN: Failed to poll cache invalidations: %v\n", err)
+			}
+		}
+	}
+}
+
+// poll delivers the invalidations published since the last poll and removes
+// those older than the retention
+func (b *databaseInvalidationBus) poll(ctx context.Context, now time.Time) error {
+	for {
+		n, fresh, err := b.pollBatch(ctx, now)
+		if err != nil {
+			return err
+		}
+		if n < b.config.BatchSize || fresh == 0 {
+			break
+		}
+	}
+
+	if now.Sub(b.cleaned) >= b.config.Retention/10 {
+		b.cleaned = now
+		query, err := b.query("cleanup_cache_invalidations")
+		if err != nil {
+			return err
+		}
+		if _, err := b.db.ExecContext(ctx, query, now.Add(-b.config.Retention).UTC()); err != nil {
+			return fmt.Errorf("failed to remove old cache invalidations: %w", err)
+		}
+	}
+	return nil
+}
+
+// pollBatch reads one batch of rows above the floor and delivers those not
+// delivered yet. It returns the number of rows read and delivered.
+func (b *databaseInvalidationBus) pollBatch(ctx context.Context, now time.Time) (int, int, error) {
+	query, err := b.query("load_cache_invalidations")
+	if err != nil {
+		return 0, 0, err
+	}
+	rows, err := b.db.QueryContext(ctx, query, b.floor, b.config.BatchSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to load cache invalidations: %w", err)
+	}
+
+	type row struct {
+		id  int64
+		inv CacheInvalidation
+	}
+	var batch []row
+	for rows.Next() {
+		var (
+			r       row
+			version int64
+		)
+		if err := rows.Scan(&r.id, &r.inv.Source, &r.inv.Kind, &r.inv.Value, &version); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("failed to scan cache invalidation: %w", err)
+		}
+		r.inv.Version = uint64(version)
+		batch = append(batch, r)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, 0, err
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	b.mu.Lock()
+	handlers := b.handlers
+	b.mu.Unlock()
+
+	fresh := 0
+	for _, r := range batch {
+		if _, done := b.delivered[r.id]; done {
+			continue
+		}
+		for _, handler := range handlers {
+			handler(r.inv)
+		}
+		fresh++
+		b.delivered[r.id] = struct{}{}
+		delete(b.gaps, r.id)
+
+		// IDs skipped here may belong to transactions that commit later
+		for missing := b.high + 1; missing < r.id && len(b.gaps) < b.config.BatchSize; missing++ {
+			b.gaps[missing] = now
+		}
+		if r.id > b.high {
+			b.high = r.id
+		}
+	}
+
+	// A full batch without new rows means the gaps hold back too many rows
+	if len(batch) == b.config.BatchSize && fresh == 0 {
+		b.gaps = make(map[int64]time.Time)
+	}
+	b.floor = b.high
+	for id, noticed := range b.gaps {
+		if now.Sub(noticed) >= cacheInvalidationGapWait {
+			delete(b.gaps, id)
+		} else if id-1 < b.floor {
+			b.floor = id - 1
+		}
+	}
+	for id := range b.delivered {
+		if id <= b.floor {
+			delete(b.delivered, id)
+		}
+	}
+	return len(batch), fresh, nil
+}
@@ -0,0 +1,118 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// connectCacheTestDB connects to a fresh SQLite database with the framework tables
+func connectCacheTestDB(t *testing.T) DatabaseManager {
+	t.Helper()
+	dm := NewDatabaseManager()
+	if err := dm.Connect(createTestDBConfig(filepath.Join(t.TempDir(), "cache.db"))); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { dm.Close() })
+	if err := dm.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	return dm
+}
+
+func TestIntegration_DatabaseInvalidationBus(t *testing.T) {
+	db := connectCacheTestDB(t)
+	config := CacheInvalidationConfig{PollInterval: 10 * time.Millisecond}
+
+	// Every instance has its own bus polling the shared table
+	var nodes []*cacheManagerImpl
+	for _, id := range []string{"node-a", "node-b"} {
+		bus := NewDatabaseInvalidationBus(db, config)
+		t.Cleanup(func() { bus.Close() })
+		cache, err := NewCacheManagerWithInvalidation(CacheConfig{Invalidation: CacheInvalidationConfig{InstanceID: id}}, nil, bus)
+		if err != nil {
+			t.Fatalf("Failed to create cache manager: %v", err)
+		}
+		nodes = append(nodes, cache.(*cacheManagerImpl))
+	}
+	a, b := nodes[0], nodes[1]
+
+	// Concurrent writes remove each other's copy, whatever the clocks say
+	a.Set("user:1", "alice", 0)
+	b.Set("user:1", "alice smith", 0)
+	waitFor(t, "invalidation of both copies", func() bool { return !a.Exists("user:1") && !b.Exists("user:1") })
+
+	// A write after the invalidations arrived is kept
+	b.Set("user:1", "alice smith", 0)
+	waitFor(t, "a to receive b's writes", func() bool { return a.remoteInvalidations.Load() >= 2 })
+	if value, _ := b.Get("user:1"); value != "alice smith" {
+		t.Errorf("Expected b to keep its later value, got %v", value)
+	}
+
+	b.Delete("user:1")
+	a.SetWithTags("product:1", "p1", 0, []string{"products"})
+	waitFor(t, "b to receive a's writes", func() bool { return b.remoteInvalidations.Load() >= 2 })
+	b.InvalidateTag("products")
+	waitFor(t, "tag invalidation of a", func() bool { return !a.Exists("product:1") })
+}
+
+func TestIntegration_DatabaseInvalidationBusWaitsForGaps(t *testing.T) {
+	db := connectCacheTestDB(t)
+	bus := NewDatabaseInvalidationBus(db, CacheInvalidationConfig{PollInterval: time.Hour}).(*databaseInvalidationBus)
+	defer bus.Close()
+
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+	if err := bus.Subscribe(func(inv CacheInvalidation) {
+		mu.Lock()
+		received = append(received, inv.Value)
+		mu.Unlock()
+	}); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	insert := func(id int64, key string) {
+		t.Helper()
+		if _, err := db.Exec("INSERT INTO cache_invalidations (id, source, kind, value, version, created_at) VALUES (?, 'node-a', 'key', ?, 1, ?)",
+			id, key, time.Now().UTC()); err != nil {
+			t.Fatalf("Failed to insert invalidation: %v", err)
+		}
+	}
+	ctx := context.Background()
+	now := time.Now()
+
+	// Row 2 belongs to a transaction that commits after row 3
+	insert(1, "a")
+	insert(3, "c")
+	if err := bus.poll(ctx, now); err != nil {
+		t.Fatalf("Failed to poll: %v", err)
+	}
+	insert(2, "b")
+	bus.poll(ctx, now.Add(time.Second))
+	bus.poll(ctx, now.Add(2*time.Second))
+
+	mu.Lock()
+	got := append([]string(nil), received...)
+	mu.Unlock()
+	if len(got) != 3 || got[0] != "a" || got[1] != "c" || got[2] != "b" {
+		t.Errorf("Expected each invalidation once, including the late one, got %v", got)
+	}
+	if bus.floor != 3 || len(bus.gaps) != 0 {
+		t.Errorf("Expected the floor to advance past the filled gap, got %d with gaps %v", bus.floor, bus.gaps)
+	}
+
+	// A gap that is never filled is given up after cacheInvalidationGapWait
+	insert(5, "e")
+	bus.poll(ctx, now.Add(3*time.Second))
+	bus.poll(ctx, now.Add(3*time.Second+cacheInvalidationGapWait))
+	if bus.floor != 5 {
+		t.Errorf("Expected the floor to pass an expired gap, got %d", bus.floor)
+	}
+}
@@ -0,0 +1,195 @@
+package pkg
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newInvalidatedCaches creates cache managers of two instances sharing bus
+// and distributed, which may be nil
+func newInvalidatedCaches(t *testing.T, bus CacheInvalidationBus, distributed DistributedCache) (*cacheManagerImpl, *cacheManagerImpl) {
+	t.Helper()
+	nodes := make([]*cacheManagerImpl, 2)
+	for i, id := range []string{"node-a", "node-b"} {
+		config := CacheConfig{Invalidation: CacheInvalidationConfig{InstanceID: id}}
+		cache, err := NewCacheManagerWithInvalidation(config, distributed, bus)
+		if err != nil {
+			t.Fatalf("Failed to create cache manager: %v", err)
+		}
+		nodes[i] = cache.(*cacheManagerImpl)
+	}
+	return nodes[0], nodes[1]
+}
+
+func TestCacheManager_InvalidatesOtherInstances(t *testing.T) {
+	a, b := newInvalidatedCaches(t, NewInMemoryInvalidationBus(), nil)
+
+	b.Set("user:1", "alice", 0)
+	a.Delete("user:1")
+	if b.Exists("user:1") {
+		t.Error("Expected Delete to invalidate the other instance")
+	}
+
+	// A write replaces the copies of other instances
+	b.Set("user:2", "bob", 0)
+	a.Set("user:2", "robert", 0)
+	if b.Exists("user:2") {
+		t.Error("Expected Set to invalidate the other instance's copy")
+	}
+	if value, _ := a.Get("user:2"); value != "robert" {
+		t.Errorf("Expected the writer to keep its value, got %v", value)
+	}
+
+	for _, key := range []string{"user:3", "user:4", "post:1"} {
+		b.Set(key, key, 0)
+	}
+	a.Invalidate("user:*")
+	if b.Exists("user:3") || b.Exists("user:4") || !b.Exists("post:1") {
+		t.Error("Expected Invalidate to remove the matching keys on the other instance")
+	}
+
+	b.SetWithTags("product:1", "p1", 0, []string{"products"})
+	b.SetWithTags("product:2", "p2", 0, []string{"products"})
+	a.InvalidateTag("products")
+	b.tagMu.RLock()
+	indexed := len(b.tagIndex["products"])
+	b.tagMu.RUnlock()
+	if b.Exists("product:1") || b.Exists("product:2") || indexed != 0 {
+		t.Error("Expected InvalidateTag to remove the tagged keys and their index on the other instance")
+	}
+
+	a.Clear()
+	if b.Exists("post:1") {
+		t.Error("Expected Clear to invalidate the other instance")
+	}
+	if received := b.remoteInvalidations.Load(); received == 0 {
+		t.Error("Expected received invalidations to be counted")
+	}
+}
+
+func TestCacheManager_InvalidationWithDistributedCache(t *testing.T) {
+	a, b := newInvalidatedCaches(t, NewInMemoryInvalidationBus(), newMapDistributedCache())
+
+	a.Set("config", "v1", 0)
+	b.Set("config", "v1", 0)
+	a.Set("config", "v2", 0)
+
+	// The stale in-memory copy is gone, so b reads the new value
+	if value, err := b.Get("config"); err != nil || value != "v2" {
+		t.Errorf("Expected v2 from the distributed cache, got %v, %v", value, err)
+	}
+}
+
+func TestCacheManager_InvalidationRejectsOlderEntries(t *testing.T) {
+	a, b := newInvalidatedCaches(t, NewInMemoryInvalidationBus(), nil)
+
+	// b loads the value while a deletes it
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var loads atomic.Int32
+	loader := func(key string) (interface{}, error) {
+		if loads.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		return "old", nil
+	}
+	result := make(chan interface{})
+	go func() {
+		value, _ := GetOrLoad(b, "report", time.Minute, loader)
+		result <- value
+	}()
+	<-started
+	a.Delete("report")
+	close(release)
+
+	if value := <-result; value != "old" {
+		t.Errorf("Expected the load to return its value, got %v", value)
+	}
+	if b.Exists("report") {
+		t.Error("Expected a value loaded before the invalidation not to be cached")
+	}
+	GetOrLoad(b, "report", time.Minute, loader)
+	if loads.Load() != 2 || !b.Exists("report") {
+		t.Error("Expected a later load to be cached")
+	}
+
+	// An instance with a clock behind still removes entries cached before its
+	// invalidation arrives
+	behind := CacheInvalidation{Source: "node-a", Kind: CacheInvalidateKey, Value: "report", Version: 1}
+	b.receiveInvalidation(behind)
+	if b.Exists("report") {
+		t.Error("Expected an invalidation from a clock behind to remove the entry")
+	}
+
+	// An instance with a clock ahead does not block writes after its invalidation
+	GetOrLoad(b, "report", time.Minute, loader)
+	ahead := CacheInvalidation{Source: "node-a", Kind: CacheInvalidateKey, Value: "report", Version: uint64(time.Now().Add(time.Hour).UnixNano())}
+	b.receiveInvalidation(ahead)
+	if b.Exists("report") {
+		t.Error("Expected an invalidation from a clock ahead to remove the entry")
+	}
+	b.Set("report", "new", 0)
+	if value, _ := b.Get("report"); value != "new" {
+		t.Errorf("Expected a write after the invalidation to be cached, got %v", value)
+	}
+}
+
+func TestCacheTombstones(t *testing.T) {
+	tombstones := newCacheTombstones(20 * time.Millisecond)
+	tombstones.record(CacheInvalidation{Kind: CacheInvalidateKey, Value: "a", Version: 10}, time.Now())
+	tombstones.record(CacheInvalidation{Kind: CacheInvalidatePattern, Value: "user:*", Version: 10}, time.Now())
+	tombstones.record(CacheInvalidation{Kind: CacheInvalidateTag, Value: "products", Version: 10}, time.Now())
+
+	tests := []struct {
+		entry cacheEntry
+		want  bool
+	}{
+		{cacheEntry{key: "a", version: 9}, false},
+		{cacheEntry{key: "a", version: 11}, true},
+		{cacheEntry{key: "user:1", version: 10}, false},
+		{cacheEntry{key: "b", version: 5, tags: []string{"products"}}, false},
+		{cacheEntry{key: "b", version: 5}, true},
+	}
+	for _, tt := range tests {
+		if got := tombstones.admits(&tt.entry); got != tt.want {
+			t.Errorf("%+v: expected admits %v, got %v", tt.entry, tt.want, got)
+		}
+	}
+
+	// Expired tombstones admit older entries and are removed
+	time.Sleep(30 * time.Millisecond)
+	if !tombstones.admits(&cacheEntry{key: "a", version: 9}) {
+		t.Error("Expected an expired tombstone to admit older entries")
+	}
+	tombstones.record(CacheInvalidation{Kind: CacheInvalidateAll, Version: 20}, time.Now())
+	if len(tombstones.keys) != 0 || len(tombstones.patterns) != 0 || len(tombstones.tags) != 0 {
+		t.Error("Expected expired tombstones to be pruned")
+	}
+	if tombstones.admits(&cacheEntry{key: "c", version: 15}) {
+		t.Error("Expected Clear to reject older entries of all keys")
+	}
+}
+
+func TestCacheManager_CloseInvalidation(t *testing.T) {
+	bus := NewInMemoryInvalidationBus()
+	a, b := newInvalidatedCaches(t, bus, nil)
+
+	if err := a.closeInvalidation(); err != nil {
+		t.Fatalf("Failed to close invalidation: %v", err)
+	}
+	// A closed bus no longer delivers, so b keeps its entry
+	b.Set("k", "v", 0)
+	a.Delete("k")
+	if !b.Exists("k") {
+		t.Error("Expected no invalidations after the bus was closed")
+	}
+	if err := b.Delete("k"); !errors.Is(err, ErrInvalidationBusClosed) {
+		t.Errorf("Expected publishing on a closed bus to fail, got %v", err)
+	}
+	if err := bus.Publish(CacheInvalidation{}); err != ErrInvalidationBusClosed {
+		t.Errorf("Expected ErrInvalidationBusClosed, got %v", err)
+	}
+}
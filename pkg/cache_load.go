@@ -0,0 +1,528 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// CacheLoader loads the value of key when it is not cached. Returning an
+// error that wraps ErrCacheKeyNotFound reports that the value does not exist,
+// which is cached for CacheLoadOptions.NegativeTTL.
+type CacheLoader func(key string) (interface{}, error)
+
+// CacheLoadOptions configures a GetOrLoadWithOptions call. Zero values use
+// the defaults of CacheConfig.
+type CacheLoadOptions struct {
+	// TTL is how long a loaded value is fresh. 0 uses CacheConfig.DefaultTTL.
+	TTL time.Duration
+
+	// StaleWhileRevalidate is how long after TTL a value is still served
+	// while it is reloaded in the background
+	StaleWhileRevalidate time.Duration
+
+	// EarlyRefreshBeta enables probabilistic early refresh (XFetch). A value
+	// is reloaded in the background before it expires with a probability
+	// that grows as expiry nears and with the time its load took. 1 is a
+	// good start; larger values refresh earlier.
+	EarlyRefreshBeta float64
+
+	// NegativeTTL is how long a value the loader reports as not found is
+	// remembered. Negative caching is off when 0.
+	NegativeTTL time.Duration
+}
+
+// loadGroup runs one load per key at a time. Concurrent callers for the
+// same key wait for the running load and share its result.
+type loadGroup struct {
+	mu    sync.Mutex
+	calls map[string]*loadCall
+}
+
+// loadCall is a running or finished load
+type loadCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// do runs fn for key unless a load of key is running, in which case it
+// waits for that load and returns its result
+func (g *loadGroup) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	call, leader := g.start(key)
+	if leader {
+		g.run(key, call, fn)
+	}
+	<-call.done
+	return call.value, call.err
+}
+
+// doAsync runs fn for key in the background unless a load of key is running
+func (g *loadGroup) doAsync(key string, fn func() (interface{}, error)) {
+	if call, leader := g.start(key); leader {
+		go g.run(key, call, fn)
+	}
+}
+
+// start returns the running load of key, or registers a new one and
+// reports that the caller has to run it
+func (g *loadGroup) start(key string) (*loadCall, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.calls == nil {
+		g.calls = make(map[string]*loadCall)
+	}
+	if call, running := g.calls[key]; running {
+		return call, false
+	}
+	call := &loadCall{done: make(chan struct{})}
+	g.calls[key] = call
+	return call, true
+}
+
+// run runs fn as the load call of key. A panic in fn is returned as error.
+func (g *loadGroup) run(key string, call *loadCall, fn func() (interface{}, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			call.value, call.err = nil, fmt.Errorf("cache loader for %s panicked: %v", key, r)
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.value, call.err = fn()
+}
+
+// CacheLoaderManager is implemented by cache managers that load missing
+// values with stampede protection. It is separate from CacheManager so that
+// existing implementations keep compiling; use GetOrLoad and
+// GetOrLoadWithOptions, which fall back to Get and Set without it.
+type CacheLoaderManager interface {
+	GetOrLoad(key string, ttl time.Duration, loader CacheLoader) (interface{}, error)
+	GetOrLoadWithOptions(key string, loader CacheLoader, opts CacheLoadOptions) (interface{}, error)
+}
+
+// GetOrLoad returns the value of key from cache, loading and caching it for
+// ttl with loader if it is not cached
+func GetOrLoad(cache CacheManager, key string, ttl time.Duration, loader CacheLoader) (interface{}, error) {
+	return GetOrLoadWithOptions(cache, key, loader, CacheLoadOptions{TTL: ttl})
+}
+
+// GetOrLoadWithOptions is GetOrLoad with load options. Caches that do not
+// implement CacheLoaderManager only use opts.TTL: they neither coalesce loads
+// nor serve stale values, and do not cache values that are not found.
+func GetOrLoadWithOptions(cache CacheManager, key string, loader CacheLoader, opts CacheLoadOptions) (interface{}, error) {
+	if c, ok := cache.(CacheLoaderManager); ok {
+		return c.GetOrLoadWithOptions(key, loader, opts)
+	}
+	if value, err := cache.Get(key); err == nil {
+		return value, nil
+	}
+	value, err := loader(key)
+	if err != nil {
+		return nil, err
+	}
+	// A value that cannot be cached is still returned, like a failed write
+	// to the distributed cache
+	_ = cache.Set(key, value, opts.TTL)
+	return value, nil
+}
+
+// GetOrLoad returns the value of key, loading and caching it for ttl with
+// loader if it is not cached. Concurrent calls for the same key share a
+// single load.
+func (c *cacheManagerImpl) GetOrLoad(key string, ttl time.Duration, loader CacheLoader) (interface{}, error) {
+	return c.GetOrLoadWithOptions(key, loader, CacheLoadOptions{TTL: ttl})
+}
+
+// GetOrLoadWithOptions is GetOrLoad with stale-while-revalidate, early
+// refresh and negative caching options
+func (c *cacheManagerImpl) GetOrLoadWithOptions(key string, loader CacheLoader, opts CacheLoadOptions) (interface{}, error) {
+	return c.getOrLoad(key, loader, opts, c.decodeAny)
+}
+
+// getOrLoad implements GetOrLoadWithOptions, decoding values from the
+// distributed cache with decode
+func (c *cacheManagerImpl) getOrLoad(key string, loader CacheLoader, opts CacheLoadOptions, decode func(data []byte) (interface{}, error)) (interface{}, error) {
+	opts = c.loadOptions(opts)
+
+	entry, err := c.store.get(key)
+	c.maybeReportMetrics()
+	if err == nil {
+		now := time.Now()
+		switch {
+		case !entry.isStale(now):
+			if entry.negative {
+				return nil, ErrCacheKeyNotFound
+			}
+			if c.shouldRefreshEarly(entry, opts, now) {
+				c.loads.doAsync(key, func() (interface{}, error) { return c.load(key, loader, opts, true, decode) })
+			}
+			return entry.value, nil
+		case !entry.negative:
+			// Serve the stale value while one caller refreshes it
+			c.loads.doAsync(key, func() (interface{}, error) { return c.load(key, loader, opts, true, decode) })
+			return entry.value, nil
+		}
+	}
+
+	return c.loads.do(key, func() (interface{}, error) { return c.load(key, loader, opts, false, decode) })
+}
+
+// loadOptions applies the defaults of the cache configuration to opts
+func (c *cacheManagerImpl) loadOptions(opts CacheLoadOptions) CacheLoadOptions {
+	if opts.TTL == 0 {
+		opts.TTL = c.config.DefaultTTL
+	}
+	if opts.StaleWhileRevalidate == 0 {
+		opts.StaleWhileRevalidate = c.config.StaleWhileRevalidate
+	}
+	if opts.EarlyRefreshBeta == 0 {
+		opts.EarlyRefreshBeta = c.config.EarlyRefreshBeta
+	}
+	if opts.NegativeTTL == 0 {
+		opts.NegativeTTL = c.config.NegativeTTL
+	}
+	return opts
+}
+
+// shouldRefreshEarly decides whether to refresh a fresh entry before it
+// expires, following the XFetch algorithm: refresh when
+// now - loadTime * beta * ln(rand) reaches the expiry.
+func (c *cacheManagerImpl) shouldRefreshEarly(entry cacheEntry, opts CacheLoadOptions, now time.Time) bool {
+	if opts.EarlyRefreshBeta <= 0 || entry.loadTime <= 0 || entry.freshUntil.IsZero() {
+		return false
+	}
+	r := rand.Float64()
+	if r == 0 {
+		return true
+	}
+	gap := time.Duration(float64(entry.loadTime) * opts.EarlyRefreshBeta * -math.Log(r))
+	return !now.Add(gap).Before(entry.freshUntil)
+}
+
+// load loads key through the distributed cache and loader and caches the
+// result. A failed background refresh keeps the stale value. The result is
+// not cached if key is invalidated during the load.
+func (c *cacheManagerImpl) load(key string, loader CacheLoader, opts CacheLoadOptions, refresh bool, decode func(data []byte) (interface{}, error)) (interface{}, error) {
+	// Another caller may have loaded the value while this one waited
+	if !refresh {
+		if entry, exists := c.store.peek(key); exists && !entry.isStale(time.Now()) {
+			if entry.negative {
+				return nil, ErrCacheKeyNotFound
+			}
+			return entry.value, nil
+		}
+	}
+
+	var (
+		value      interface{}
+		err        error
+		loadTime   time.Duration
+		freshUntil time.Time
+		version    = c.clock.next()
+	)
+	if c.distributed != nil {
+		// Only a value fresher than the cached one replaces it
+		after := time.Now()
+		if entry, exists := c.store.peek(key); exists && entry.freshUntil.After(after) {
+			after = entry.freshUntil
+		}
+		value, loadTime, freshUntil, err = c.loadDistributed(key, loader, opts, version, refresh, after, decode)
+	} else {
+		value, loadTime, err = c.callLoader(key, loader)
+	}
+
+	if err != nil {
+		if errors.Is(err, ErrCacheKeyNotFound) {
+			// The value no longer exists; forget a stale copy
+			if opts.NegativeTTL > 0 {
+				c.store.setEntry(&cacheEntry{key: key, negative: true, expiresAt: time.Now().Add(opts.NegativeTTL), version: version})
+			} else {
+				c.store.deleteVersion(key, version)
+			}
+		}
+		return nil, err
+	}
+	entry := c.loadedEntry(key, value, opts, loadTime, version)
+	if opts.TTL > 0 && !freshUntil.IsZero() {
+		entry.freshUntil = freshUntil
+		entry.expiresAt = freshUntil.Add(opts.StaleWhileRevalidate)
+	}
+	c.store.setEntry(entry)
+	return value, nil
+}
+
+// callLoader calls loader and measures how long it took
+func (c *cacheManagerImpl) callLoader(key string, loader CacheLoader) (interface{}, time.Duration, error) {
+	c.loadCount.Add(1)
+	start := time.Now()
+	value, err := loader(key)
+	if err != nil && !errors.Is(err, ErrCacheKeyNotFound) {
+		c.loadErrors.Add(1)
+	}
+	return value, time.Since(start), err
+}
+
+// loadedEntry returns the entry for a value loaded with opts
+func (c *cacheManagerImpl) loadedEntry(key string, value interface{}, opts CacheLoadOptions, loadTime time.Duration, version uint64) *cacheEntry {
+	entry := &cacheEntry{key: key, value: value, loadTime: loadTime, version: version}
+	if opts.TTL > 0 {
+		now := time.Now()
+		entry.freshUntil = now.Add(opts.TTL)
+		entry.expiresAt = entry.freshUntil.Add(opts.StaleWhileRevalidate)
+	}
+	return entry
+}
+
+// loadDistributed loads key from the distributed cache. On a miss, the
+// instance that takes the lock key "<key>:lock" calls loader and writes the
+// value to the distributed cache; the others wait for that value until
+// CacheConfig.LoadLockTimeout and then load it themselves. When the loader
+// finds no value or fails, the lock holder publishes the outcome under
+// "<key>:load" so that waiting instances return it instead of waiting.
+//
+// Loaded values are written with their freshness and load time under
+// "<key>:meta". A distributed value is only taken if it is fresh until after
+// after; a refresh does not take values without that record. It returns the
+// value with its load time and the end of its freshness, which is zero when
+// it starts now.
+func (c *cacheManagerImpl) loadDistributed(key string, loader CacheLoader, opts CacheLoadOptions, version uint64, refresh bool, after time.Time, decode func(data []byte) (interface{}, error)) (interface{}, time.Duration, time.Time, error) {
+	fresh := func() (interface{}, time.Duration, time.Time, bool) {
+		value, meta, ok := c.getDistributed(key, decode)
+		switch {
+		case !ok:
+			return nil, 0, time.Time{}, false
+		case meta == nil || meta.FreshUntil.IsZero():
+			return value, 0, time.Time{}, !refresh
+		default:
+			return value, meta.LoadTime, meta.FreshUntil, meta.FreshUntil.After(after)
+		}
+	}
+	if value, loadTime, freshUntil, ok := fresh(); ok {
+		return value, loadTime, freshUntil, nil
+	}
+
+	lockKey := key + ":lock"
+	token := generateUUIDv7()
+	locked := c.lockDistributed(lockKey, token)
+	if locked {
+		defer c.unlockDistributed(lockKey, token)
+	} else {
+		holder, _ := c.lockHolder(lockKey)
+		deadline := time.Now().Add(c.config.LoadLockTimeout)
+		interval := c.config.LoadLockTimeout / 50
+		if interval < 5*time.Millisecond {
+			interval = 5 * time.Millisecond
+		}
+		for time.Now().Before(deadline) {
+			time.Sleep(interval)
+			if value, loadTime, freshUntil, ok := fresh(); ok {
+				return value, loadTime, freshUntil, nil
+			}
+			if err := c.loadOutcome(key, holder); err != nil {
+				return nil, 0, time.Time{}, err
+			}
+			if _, held := c.lockHolder(lockKey); !held {
+				// Released without a value, e.g. deleted while loading
+				if value, loadTime, freshUntil, ok := fresh(); ok {
+					return value, loadTime, freshUntil, nil
+				}
+				break
+			}
+		}
+	}
+
+	value, loadTime, err := c.callLoader(key, loader)
+	if err != nil {
+		if locked {
+			c.publishLoadOutcome(key, token, err)
+		}
+		return nil, loadTime, time.Time{}, err
+	}
+	var freshUntil time.Time
+	if opts.TTL > 0 {
+		freshUntil = time.Now().Add(opts.TTL)
+	}
+	if !c.tombstones.admits(&cacheEntry{key: key, version: version}) {
+		// Deleted while loading; the value may predate the change
+		return value, loadTime, freshUntil, nil
+	}
+	if err := c.setDistributedLoaded(key, value, opts, cacheLoadMeta{FreshUntil: freshUntil, LoadTime: loadTime}); err != nil {
+		fmt.Printf("WARN: Failed to write loaded cache value %s to distributed cache: %v\n", key, err)
+	}
+	return value, loadTime, freshUntil, nil
+}
+
+// cacheLoadMeta is written with a loaded value, so that other instances
+// take over its freshness and load time
+type cacheLoadMeta struct {
+	Sum        uint64        `json:"sum"`         // FNV-1a hash of the encoded value
+	FreshUntil time.Time     `json:"fresh_until"` // Zero if the value does not expire
+	LoadTime   time.Duration `json:"load_time"`
+}
+
+// setDistributedLoaded writes a loaded value and its meta record to the
+// distributed cache for TTL + StaleWhileRevalidate
+func (c *cacheManagerImpl) setDistributedLoaded(key string, value interface{}, opts CacheLoadOptions, meta cacheLoadMeta) error {
+	data, err := c.serializer.encode(value)
+	if err != nil {
+		return err
+	}
+	meta.Sum = cacheLoadSum(data)
+	metaData, err := c.serializer.encode(meta)
+	if err != nil {
+		return err
+	}
+	return c.distributed.SetMultiple(map[string]interface{}{key: data, key + ":meta": metaData}, opts.TTL+opts.StaleWhileRevalidate)
+}
+
+// cacheLoadSum hashes an encoded value, tying a meta record to the value
+// it was written with
+func cacheLoadSum(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
+
+// cacheLoadOutcome is published by the lock holder when a load yields no value
+type cacheLoadOutcome struct {
+	Token    string `json:"token"`     // Lock token of the load
+	NotFound bool   `json:"not_found"` // The loader reported ErrCacheKeyNotFound
+	Error    string `json:"error"`     // Message of any other loader error
+}
+
+// publishLoadOutcome tells instances waiting for the load under token that
+// it failed with err. The outcome expires with the lock.
+func (c *cacheManagerImpl) publishLoadOutcome(key, token string, err error) {
+	outcome := cacheLoadOutcome{Token: token, NotFound: errors.Is(err, ErrCacheKeyNotFound)}
+	if !outcome.NotFound {
+		outcome.Error = err.Error()
+	}
+	data, encodeErr := c.serializer.encode(outcome)
+	if encodeErr == nil {
+		encodeErr = c.distributed.Set(key+":load", data, c.config.LoadLockTimeout)
+	}
+	if encodeErr != nil {
+		fmt.Printf("WARN: Failed to publish cache load outcome of %s to distributed cache: %v\n", key, encodeErr)
+	}
+}
+
+// loadOutcome returns the error published for the load of key under token,
+// or nil if the load has not failed
+func (c *cacheManagerImpl) loadOutcome(key, token string) error {
+	if token == "" {
+		return nil
+	}
+	raw, err := c.distributed.Get(key + ":load")
+	if err != nil {
+		return nil
+	}
+	var outcome cacheLoadOutcome
+	if err := c.decodeDistributed(raw, &outcome); err != nil || outcome.Token != token {
+		return nil
+	}
+	if outcome.NotFound {
+		return ErrCacheKeyNotFound
+	}
+	return fmt.Errorf("cache loader for %s failed on another instance: %s", key, outcome.Error)
+}
+
+// getDistributed reads key and its meta record from the distributed cache.
+// The meta record is nil unless it was written with the value that was
+// read. A value that cannot be decoded counts as missing, so that it is
+// loaded and overwritten.
+func (c *cacheManagerImpl) getDistributed(key string, decode func(data []byte) (interface{}, error)) (interface{}, *cacheLoadMeta, bool) {
+	raw, err := c.distributed.Get(key)
+	if err != nil {
+		return nil, nil, false
+	}
+	value, err := c.fromDistributed(raw, decode)
+	if err != nil {
+		fmt.Printf("WARN: Ignoring cache value %s from distributed cache: %v\n", key, err)
+		return nil, nil, false
+	}
+
+	var data []byte
+	switch raw := raw.(type) {
+	case []byte:
+		data = raw
+	case string:
+		data = []byte(raw)
+	default:
+		return value, nil, true
+	}
+	rawMeta, err := c.distributed.Get(key + ":meta")
+	if err != nil {
+		return value, nil, true
+	}
+	var meta cacheLoadMeta
+	if err := c.decodeDistributed(rawMeta, &meta); err != nil || meta.Sum != cacheLoadSum(data) {
+		return value, nil, true
+	}
+	return value, &meta, true
+}
+
+// lockDistributed tries to take lockKey in the distributed cache. Backends
+// implementing DistributedCacheLocker take it atomically; others are checked
+// and set, which can rarely let two instances load at once. The token is
+// written through the cache serializer like any other value.
+func (c *cacheManagerImpl) lockDistributed(lockKey, token string) bool {
+	data, err := c.serializer.encode(token)
+	if err != nil {
+		return false
+	}
+
+	if locker, ok := c.distributed.(DistributedCacheLocker); ok {
+		acquired, err := locker.SetIfAbsent(lockKey, data, c.config.LoadLockTimeout)
+		return err == nil && acquired
+	}
+
+	if _, err := c.distributed.Get(lockKey); err == nil {
+		return false
+	}
+	if err := c.distributed.Set(lockKey, data, c.config.LoadLockTimeout); err != nil {
+		return false
+	}
+	holder, ok := c.lockHolder(lockKey)
+	return ok && holder == token
+}
+
+// unlockDistributed releases lockKey if this caller still holds it
+func (c *cacheManagerImpl) unlockDistributed(lockKey, token string) {
+	if holder, ok := c.lockHolder(lockKey); ok && holder == token {
+		_ = c.distributed.Delete(lockKey)
+	}
+}
+
+// lockHolder returns the token stored under lockKey
+func (c *cacheManagerImpl) lockHolder(lockKey string) (string, bool) {
+	raw, err := c.distributed.Get(lockKey)
+	if err != nil {
+		return "", false
+	}
+	var holder string
+	if err := c.decodeDistributed(raw, &holder); err != nil {
+		return "", false
+	}
+	return holder, true
+}
+
+// decodeDistributed decodes a value the cache wrote to the distributed cache
+// into v. Backends may return the encoded bytes as []byte or string.
+func (c *cacheManagerImpl) decodeDistributed(raw interface{}, v interface{}) error {
+	switch data := raw.(type) {
+	case []byte:
+		return c.serializer.decode(data, v)
+	case string:
+		return c.serializer.decode([]byte(data), v)
+	default:
+		return fmt.Errorf("%w: unexpected %T", ErrCacheDecode, raw)
+	}
+}
@@ -0,0 +1,418 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mapDistributedCache is a DistributedCache shared by cache managers in tests
+type mapDistributedCache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func newMapDistributedCache() *mapDistributedCache {
+	return &mapDistributedCache{entries: make(map[string]cacheEntry)}
+}
+
+func (m *mapDistributedCache) Get(key string) (interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, exists := m.entries[key]
+	if !exists || entry.isExpired() {
+		return nil, ErrCacheKeyNotFound
+	}
+	return entry.value, nil
+}
+
+func (m *mapDistributedCache) Set(key string, value interface{}, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := cacheEntry{value: value}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+	m.entries[key] = entry
+	return nil
+}
+
+func (m *mapDistributedCache) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *mapDistributedCache) Clear() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = make(map[string]cacheEntry)
+	return nil
+}
+
+func (m *mapDistributedCache) GetMultiple(keys []string) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for _, key := range keys {
+		if value, err := m.Get(key); err == nil {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+func (m *mapDistributedCache) SetMultiple(items map[string]interface{}, ttl time.Duration) error {
+	for key, value := range items {
+		m.Set(key, value, ttl)
+	}
+	return nil
+}
+
+func (m *mapDistributedCache) DeleteMultiple(keys []string) error {
+	for _, key := range keys {
+		m.Delete(key)
+	}
+	return nil
+}
+
+func (m *mapDistributedCache) Invalidate(pattern string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.entries {
+		if matchPattern(key, pattern) {
+			delete(m.entries, key)
+		}
+	}
+	return nil
+}
+
+func (m *mapDistributedCache) InvalidateTag(tag string) error { return nil }
+
+// lockingDistributedCache adds an atomic SetIfAbsent
+type lockingDistributedCache struct {
+	*mapDistributedCache
+}
+
+func (m lockingDistributedCache) SetIfAbsent(key string, value interface{}, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, exists := m.entries[key]; exists && !entry.isExpired() {
+		return false, nil
+	}
+	m.entries[key] = cacheEntry{value: value, expiresAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+// bytesDistributedCache stores values as bytes like network backends do, so
+// a value read back is never the value that was written
+type bytesDistributedCache struct {
+	*mapDistributedCache
+}
+
+func (m bytesDistributedCache) Get(key string) (interface{}, error) {
+	value, err := m.mapDistributedCache.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), value.([]byte)...), nil
+}
+
+func (m bytesDistributedCache) Set(key string, value interface{}, ttl time.Duration) error {
+	data, ok := value.([]byte)
+	if !ok {
+		data = []byte(fmt.Sprint(value))
+	}
+	return m.mapDistributedCache.Set(key, append([]byte(nil), data...), ttl)
+}
+
+func TestCacheManager_GetOrLoadCoalescesLoads(t *testing.T) {
+	cache := NewCacheManager(CacheConfig{})
+	var loads atomic.Int32
+	loader := func(key string) (interface{}, error) {
+		loads.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		return "profile of " + key, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := GetOrLoad(cache, "user:7", time.Minute, loader)
+			if err != nil || value != "profile of user:7" {
+				t.Errorf("Unexpected result %v, %v", value, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if loads.Load() != 1 {
+		t.Errorf("Expected concurrent misses to share one load, got %d", loads.Load())
+	}
+	if value, _ := cache.Get("user:7"); value != "profile of user:7" {
+		t.Errorf("Expected the loaded value to be cached, got %v", value)
+	}
+}
+
+func TestCacheManager_GetOrLoadErrors(t *testing.T) {
+	cache := NewCacheManager(CacheConfig{})
+
+	if _, err := GetOrLoad(cache, "a", time.Minute, func(key string) (interface{}, error) {
+		return nil, errors.New("database down")
+	}); err == nil || err.Error() != "database down" {
+		t.Errorf("Expected the loader error, got %v", err)
+	}
+	if cache.Exists("a") {
+		t.Error("Expected failed loads not to be cached")
+	}
+
+	if _, err := GetOrLoad(cache, "a", time.Minute, func(key string) (interface{}, error) {
+		panic("nil map")
+	}); err == nil || !strings.Contains(err.Error(), "panicked") {
+		t.Errorf("Expected a panic to be returned as error, got %v", err)
+	}
+	if value, err := GetOrLoad(cache, "a", time.Minute, func(key string) (interface{}, error) {
+		return 1, nil
+	}); err != nil || value != 1 {
+		t.Errorf("Expected loads to work after a panic, got %v, %v", value, err)
+	}
+}
+
+func TestCacheManager_GetOrLoadStaleWhileRevalidate(t *testing.T) {
+	cache := NewCacheManager(CacheConfig{})
+	opts := CacheLoadOptions{TTL: 20 * time.Millisecond, StaleWhileRevalidate: time.Minute}
+	version := atomic.Int32{}
+	release := make(chan struct{})
+	loader := func(key string) (interface{}, error) {
+		if version.Add(1) > 1 {
+			<-release
+		}
+		return fmt.Sprintf("v%d", version.Load()), nil
+	}
+
+	GetOrLoadWithOptions(cache, "config", loader, opts)
+	time.Sleep(30 * time.Millisecond)
+
+	// The stale value is served at once while one caller reloads it
+	for i := 0; i < 5; i++ {
+		if value, err := GetOrLoadWithOptions(cache, "config", loader, opts); err != nil || value != "v1" {
+			t.Fatalf("Expected the stale value, got %v, %v", value, err)
+		}
+	}
+	if _, err := cache.Get("config"); err != ErrCacheExpired {
+		t.Errorf("Expected Get to treat a stale value as expired, got %v", err)
+	}
+	close(release)
+
+	waitFor(t, "refreshed value", func() bool {
+		value, _ := GetOrLoadWithOptions(cache, "config", loader, opts)
+		return value == "v2"
+	})
+	if version.Load() != 2 {
+		t.Errorf("Expected a single background refresh, got %d loads", version.Load())
+	}
+}
+
+func TestCacheManager_GetOrLoadEarlyRefresh(t *testing.T) {
+	cache := NewCacheManager(CacheConfig{})
+	var loads atomic.Int32
+	loader := func(key string) (interface{}, error) {
+		loads.Add(1)
+		time.Sleep(time.Millisecond)
+		return "rates", nil
+	}
+
+	GetOrLoad(cache, "rates", time.Hour, loader)
+	for i := 0; i < 10; i++ {
+		GetOrLoad(cache, "rates", time.Hour, loader)
+	}
+	if loads.Load() != 1 {
+		t.Fatalf("Expected no early refresh without beta, got %d loads", loads.Load())
+	}
+
+	// With a huge beta, the hour-long TTL counts as about to expire
+	opts := CacheLoadOptions{TTL: time.Hour, EarlyRefreshBeta: 1e12}
+	if value, err := GetOrLoadWithOptions(cache, "rates", loader, opts); err != nil || value != "rates" {
+		t.Fatalf("Expected the cached value, got %v, %v", value, err)
+	}
+	waitFor(t, "early refresh", func() bool { return loads.Load() == 2 })
+}
+
+func TestCacheManager_GetOrLoadStaleWhileRevalidateWithDistributed(t *testing.T) {
+	distributed := newMapDistributedCache()
+	cache := NewCacheManagerWithDistributed(CacheConfig{}, distributed)
+	opts := CacheLoadOptions{TTL: 20 * time.Millisecond, StaleWhileRevalidate: time.Minute}
+	var loads atomic.Int32
+	loader := func(key string) (interface{}, error) {
+		return fmt.Sprintf("v%d", loads.Add(1)), nil
+	}
+
+	GetOrLoadWithOptions(cache, "config", loader, opts)
+	time.Sleep(30 * time.Millisecond)
+
+	// The stale copy in the distributed cache does not count as a refresh
+	if value, err := GetOrLoadWithOptions(cache, "config", loader, opts); err != nil || value != "v1" {
+		t.Fatalf("Expected the stale value, got %v, %v", value, err)
+	}
+	waitFor(t, "background refresh", func() bool { return loads.Load() == 2 })
+	waitFor(t, "refreshed value", func() bool {
+		value, _ := cache.Get("config")
+		return value == "v2"
+	})
+
+	// Another node takes the refreshed value from the distributed cache
+	other := NewCacheManagerWithDistributed(CacheConfig{}, distributed)
+	if value, err := GetOrLoadWithOptions(other, "config", loader, opts); err != nil || value != "v2" || loads.Load() != 2 {
+		t.Errorf("Expected the refreshed value without a load, got %v, %v after %d loads", value, err, loads.Load())
+	}
+}
+
+func TestCacheManager_GetOrLoadEarlyRefreshWithDistributed(t *testing.T) {
+	distributed := newMapDistributedCache()
+	opts := CacheLoadOptions{TTL: time.Hour, EarlyRefreshBeta: 1e12}
+	var loads atomic.Int32
+	loader := func(key string) (interface{}, error) {
+		loads.Add(1)
+		time.Sleep(time.Millisecond)
+		return "rates", nil
+	}
+
+	cache := NewCacheManagerWithDistributed(CacheConfig{}, distributed)
+	GetOrLoadWithOptions(cache, "rates", loader, opts)
+	GetOrLoadWithOptions(cache, "rates", loader, opts)
+	waitFor(t, "early refresh", func() bool {
+		// The refresh is written once the load lock is released
+		_, err := distributed.Get("rates:lock")
+		return loads.Load() == 2 && err != nil
+	})
+
+	// A value taken from the distributed cache keeps its load time, so it
+	// is refreshed early as well, by calling the loader
+	other := NewCacheManagerWithDistributed(CacheConfig{}, distributed)
+	if value, err := GetOrLoadWithOptions(other, "rates", loader, opts); err != nil || value != "rates" || loads.Load() != 2 {
+		t.Fatalf("Expected the value of the distributed cache, got %v, %v after %d loads", value, err, loads.Load())
+	}
+	GetOrLoadWithOptions(other, "rates", loader, opts)
+	waitFor(t, "early refresh of the other node", func() bool { return loads.Load() == 3 })
+}
+
+func TestCacheManager_GetOrLoadNegativeCaching(t *testing.T) {
+	cache := NewCacheManager(CacheConfig{NegativeTTL: time.Minute})
+	var loads atomic.Int32
+	loader := func(key string) (interface{}, error) {
+		loads.Add(1)
+		return nil, fmt.Errorf("no user %s: %w", key, ErrCacheKeyNotFound)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := GetOrLoad(cache, "user:404", time.Minute, loader); !errors.Is(err, ErrCacheKeyNotFound) {
+			t.Fatalf("Expected a not found error, got %v", err)
+		}
+	}
+	if loads.Load() != 1 {
+		t.Errorf("Expected the absence to be cached, got %d loads", loads.Load())
+	}
+	if cache.Exists("user:404") {
+		t.Error("Expected a cached absence not to exist")
+	}
+	if _, err := cache.Get("user:404"); err != ErrCacheKeyNotFound {
+		t.Errorf("Expected Get to report a cached absence as not found, got %v", err)
+	}
+
+	// Without NegativeTTL the loader runs every time
+	GetOrLoadWithOptions(cache, "user:405", loader, CacheLoadOptions{TTL: time.Minute, NegativeTTL: -1})
+	GetOrLoadWithOptions(cache, "user:405", loader, CacheLoadOptions{TTL: time.Minute, NegativeTTL: -1})
+	if loads.Load() != 3 {
+		t.Errorf("Expected absences not to be cached, got %d loads", loads.Load())
+	}
+}
+
+func TestCacheManager_GetOrLoadAcrossNodes(t *testing.T) {
+	for name, distributed := range map[string]DistributedCache{
+		"locking":     lockingDistributedCache{newMapDistributedCache()},
+		"best effort": newMapDistributedCache(),
+		"bytes":       bytesDistributedCache{newMapDistributedCache()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := CacheConfig{LoadLockTimeout: 2 * time.Second}
+			nodes := []CacheManager{
+				NewCacheManagerWithDistributed(config, distributed),
+				NewCacheManagerWithDistributed(config, distributed),
+			}
+			var loads atomic.Int32
+			loader := func(key string) (interface{}, error) {
+				loads.Add(1)
+				time.Sleep(50 * time.Millisecond)
+				return "report", nil
+			}
+
+			// The first node takes the lock; the second waits for its value
+			var wg sync.WaitGroup
+			for i, node := range nodes {
+				wg.Add(1)
+				go func(node CacheManager) {
+					defer wg.Done()
+					if value, err := GetOrLoad(node, "report:2026", time.Minute, loader); err != nil || value != "report" {
+						t.Errorf("Unexpected result %v, %v", value, err)
+					}
+				}(node)
+				if i == 0 {
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+			wg.Wait()
+
+			if loads.Load() != 1 {
+				t.Errorf("Expected one load across nodes, got %d", loads.Load())
+			}
+			if _, err := distributed.Get("report:2026:lock"); err == nil {
+				t.Error("Expected the load lock to be released")
+			}
+		})
+	}
+}
+
+func TestCacheManager_GetOrLoadAcrossNodesPublishesFailures(t *testing.T) {
+	distributed := bytesDistributedCache{newMapDistributedCache()}
+	config := CacheConfig{LoadLockTimeout: 5 * time.Second}
+	holder := NewCacheManagerWithDistributed(config, distributed)
+	waiter := NewCacheManagerWithDistributed(config, distributed)
+
+	for name, loadErr := range map[string]error{
+		"not found": fmt.Errorf("no report: %w", ErrCacheKeyNotFound),
+		"error":     errors.New("database down"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			key := "report:" + name
+			started := make(chan struct{})
+			go GetOrLoad(holder, key, time.Minute, func(key string) (interface{}, error) {
+				close(started)
+				time.Sleep(50 * time.Millisecond)
+				return nil, loadErr
+			})
+			<-started
+
+			// The waiter returns the holder's outcome instead of waiting for LoadLockTimeout
+			start := time.Now()
+			_, err := GetOrLoad(waiter, key, time.Minute, func(key string) (interface{}, error) {
+				t.Error("Expected the waiting node not to load")
+				return nil, nil
+			})
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Expected the waiter to stop waiting, took %v", elapsed)
+			}
+			if errors.Is(loadErr, ErrCacheKeyNotFound) {
+				if !errors.Is(err, ErrCacheKeyNotFound) {
+					t.Errorf("Expected a not found error, got %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), "database down") {
+				t.Errorf("Expected the loader error of the other node, got %v", err)
+			}
+		})
+	}
+}
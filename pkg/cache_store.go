@@ -0,0 +1,298 @@
+package pkg
+
+import (
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// minCacheShardSize is the smallest size limit of a shard. Caches with a
+// small MaxSize use fewer shards so that eviction stays close to the policy.
+const minCacheShardSize = 1 << 20
+
+// cacheStore holds the entries of the in-memory cache in shards, each with
+// its own lock, size limit and eviction policy
+type cacheStore struct {
+	seed   maphash.Seed
+	shards []*cacheShard
+	mask   uint64
+
+	// admit is called with the shard locked before an entry is stored and
+	// rejects entries older than an invalidation of their key
+	admit func(e *cacheEntry) bool
+}
+
+// cacheShard is a part of the cache store
+type cacheShard struct {
+	mu         sync.Mutex
+	entries    map[string]*cacheEntry
+	policy     evictionPolicy // nil without size limit
+	policyName string
+	size       int64
+	maxSize    int64
+
+	// Counters reported to the metrics collector
+	hits, misses, evictions int64
+
+	// onRemove is called for removed entries that have tags
+	onRemove func(e *cacheEntry)
+}
+
+// cacheStats is a snapshot of the cache counters
+type cacheStats struct {
+	hits, misses, evictions int64
+	loads, loadErrors       int64
+	remoteInvalidations     int64
+	entries                 int
+	size                    int64
+}
+
+// newCacheStore creates a store for config. onRemove is called with the
+// shard locked for each removed entry that has tags.
+func newCacheStore(config CacheConfig, onRemove func(e *cacheEntry)) *cacheStore {
+	n := cacheShardCount(config)
+	s := &cacheStore{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*cacheShard, n),
+		mask:   uint64(n - 1),
+	}
+	for i := range s.shards {
+		shard := &cacheShard{
+			entries:    make(map[string]*cacheEntry),
+			policyName: config.EvictionPolicy,
+			onRemove:   onRemove,
+		}
+		if config.MaxSize > 0 {
+			shard.maxSize = config.MaxSize / int64(n)
+			shard.policy = newEvictionPolicy(config.EvictionPolicy, shard.maxSize)
+		}
+		s.shards[i] = shard
+	}
+	return s
+}
+
+// cacheShardCount returns the number of shards for config, a power of two
+func cacheShardCount(config CacheConfig) int {
+	n := 1
+	for n < config.Shards {
+		n <<= 1
+	}
+	if config.MaxSize > 0 {
+		for n > 1 && config.MaxSize/int64(n) < minCacheShardSize {
+			n >>= 1
+		}
+	}
+	return n
+}
+
+// hash returns the hash of key
+func (s *cacheStore) hash(key string) uint64 {
+	return maphash.String(s.seed, key)
+}
+
+// shard returns the shard of hash
+func (s *cacheStore) shard(hash uint64) *cacheShard {
+	return s.shards[hash&s.mask]
+}
+
+// get returns a snapshot of the entry of key and records a hit or miss
+func (s *cacheStore) get(key string) (cacheEntry, error) {
+	hash := s.hash(key)
+	shard := s.shard(hash)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e, err := shard.getLocked(key, hash)
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	return e.snapshot(), nil
+}
+
+// peek returns a snapshot of the entry of key without counting an access
+func (s *cacheStore) peek(key string) (cacheEntry, bool) {
+	shard := s.shard(s.hash(key))
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e, exists := shard.entries[key]
+	if !exists {
+		return cacheEntry{}, false
+	}
+	return e.snapshot(), true
+}
+
+// set stores value under key with version, evicting entries if the shard is full
+func (s *cacheStore) set(key string, value interface{}, expiresAt time.Time, tags []string, version uint64) bool {
+	return s.setEntry(&cacheEntry{key: key, value: value, expiresAt: expiresAt, tags: tags, version: version})
+}
+
+// setEntry stores e, evicting entries if the shard is full. It reports false
+// if e was rejected because its key was invalidated after e's version.
+func (s *cacheStore) setEntry(e *cacheEntry) bool {
+	e.hash = s.hash(e.key)
+	shard := s.shard(e.hash)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if s.admit != nil && !s.admit(e) {
+		return false
+	}
+	shard.setLocked(e)
+	return true
+}
+
+// delete removes key
+func (s *cacheStore) delete(key string) {
+	shard := s.shard(s.hash(key))
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if e, exists := shard.entries[key]; exists {
+		shard.removeLocked(e)
+	}
+}
+
+// deleteVersion removes key if its entry is not newer than version
+func (s *cacheStore) deleteVersion(key string, version uint64) {
+	shard := s.shard(s.hash(key))
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if e, exists := shard.entries[key]; exists && e.version <= version {
+		shard.removeLocked(e)
+	}
+}
+
+// update calls fn with the shard of key locked
+func (s *cacheStore) update(key string, fn func(shard *cacheShard, hash uint64) error) error {
+	hash := s.hash(key)
+	shard := s.shard(hash)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	return fn(shard, hash)
+}
+
+// removeIf removes all entries for which match returns true and returns
+// their number
+func (s *cacheStore) removeIf(match func(e *cacheEntry) bool) int {
+	count := 0
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		for _, e := range shard.entries {
+			if match(e) {
+				shard.removeLocked(e)
+				count++
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return count
+}
+
+// clear removes all entries. The counters are kept.
+func (s *cacheStore) clear() {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		shard.entries = make(map[string]*cacheEntry)
+		shard.size = 0
+		if shard.policy != nil {
+			shard.policy = newEvictionPolicy(shard.policyName, shard.maxSize)
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// stats returns the counters and size of all shards
+func (s *cacheStore) stats() cacheStats {
+	var stats cacheStats
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		stats.hits += shard.hits
+		stats.misses += shard.misses
+		stats.evictions += shard.evictions
+		stats.entries += len(shard.entries)
+		stats.size += shard.size
+		shard.mu.Unlock()
+	}
+	return stats
+}
+
+// getLocked returns the live entry of key and records a hit or miss.
+// Expired entries are removed.
+func (s *cacheShard) getLocked(key string, hash uint64) (*cacheEntry, error) {
+	if s.policy != nil {
+		s.policy.record(hash)
+	}
+	e, exists := s.entries[key]
+	if !exists {
+		s.misses++
+		return nil, ErrCacheKeyNotFound
+	}
+	if e.isExpired() {
+		s.misses++
+		s.removeLocked(e)
+		return nil, ErrCacheExpired
+	}
+	s.hits++
+	if s.policy != nil {
+		s.policy.access(e)
+	}
+	return e, nil
+}
+
+// setLocked stores e, replacing the entry with the same key, after evicting
+// entries until it fits the shard's size limit. An entry larger than the
+// limit is not stored.
+func (s *cacheShard) setLocked(e *cacheEntry) {
+	e.size = int64(len(e.key))
+	if !e.negative {
+		e.size += estimateSize(e.value)
+	}
+	if old, exists := s.entries[e.key]; exists {
+		e.frequency = old.frequency
+		s.removeLocked(old)
+	}
+	if s.policy == nil {
+		s.entries[e.key] = e
+		s.size += e.size
+		return
+	}
+
+	s.policy.record(e.hash)
+	if e.size > s.maxSize {
+		s.evictions++
+		return
+	}
+
+	// Make room before adding, so the victim is chosen among the cached entries
+	for s.size+e.size > s.maxSize {
+		victim := s.policy.evict()
+		if victim == nil {
+			break
+		}
+		s.dropLocked(victim)
+		s.evictions++
+	}
+	s.entries[e.key] = e
+	s.size += e.size
+	s.policy.add(e)
+}
+
+// removeLocked removes e
+func (s *cacheShard) removeLocked(e *cacheEntry) {
+	if s.policy != nil {
+		s.policy.remove(e)
+	}
+	s.dropLocked(e)
+}
+
+// dropLocked removes e from the entries after the policy stopped tracking it
+func (s *cacheShard) dropLocked(e *cacheEntry) {
+	delete(s.entries, e.key)
+	s.size -= e.size
+	if len(e.tags) > 0 && s.onRemove != nil {
+		s.onRemove(e)
+	}
+}
@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// GetTyped returns the value of key as a T. Values read from the
+// distributed cache are decoded into T; a cached value of another type
+// returns ErrCacheTypeMismatch.
+func GetTyped[T any](cache CacheManager, key string) (T, error) {
+	var result T
+	c, ok := cache.(*cacheManagerImpl)
+	if !ok {
+		value, err := cache.Get(key)
+		if err != nil {
+			return result, err
+		}
+		return cacheValueAs[T](key, value)
+	}
+
+	value, err := c.get(key, decodeCacheValueAs[T](c))
+	if err != nil {
+		return result, err
+	}
+	return cacheValueAs[T](key, value)
+}
+
+// SetTyped stores value under key with ttl
+func SetTyped[T any](cache CacheManager, key string, value T, ttl time.Duration) error {
+	return cache.Set(key, value, ttl)
+}
+
+// GetOrLoadTyped is GetOrLoad for values of type T. Values read from the
+// distributed cache are decoded into T.
+func GetOrLoadTyped[T any](cache CacheManager, key string, ttl time.Duration, loader func(key string) (T, error)) (T, error) {
+	load := func(key string) (interface{}, error) {
+		return loader(key)
+	}
+
+	var (
+		value interface{}
+		err   error
+	)
+	if c, ok := cache.(*cacheManagerImpl); ok {
+		value, err = c.getOrLoad(key, load, CacheLoadOptions{TTL: ttl}, decodeCacheValueAs[T](c))
+	} else {
+		value, err = GetOrLoad(cache, key, ttl, load)
+	}
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return cacheValueAs[T](key, value)
+}
+
+// cacheValueAs returns value as a T
+func cacheValueAs[T any](key string, value interface{}) (T, error) {
+	var result T
+	if value == nil {
+		return result, nil
+	}
+	result, ok := value.(T)
+	if !ok {
+		return result, fmt.Errorf("%w: %s holds %T, not %v", ErrCacheTypeMismatch, key, value, reflect.TypeOf((*T)(nil)).Elem())
+	}
+	return result, nil
+}
+
+// decodeCacheValueAs returns a function decoding encoded values into a T
+func decodeCacheValueAs[T any](c *cacheManagerImpl) func(data []byte) (interface{}, error) {
+	return func(data []byte) (interface{}, error) {
+		var result T
+		if err := c.serializer.decode(data, &result); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+}
@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetTyped(t *testing.T) {
+	cache := NewCacheManager(CacheConfig{})
+	SetTyped(cache, "profile", testProfile, time.Minute)
+	cache.Set("count", 3, time.Minute)
+
+	if profile, err := GetTyped[cachedProfile](cache, "profile"); err != nil || profile.Name != "Ada" {
+		t.Errorf("Expected the cached profile, got %+v, %v", profile, err)
+	}
+	if _, err := GetTyped[string](cache, "count"); !errors.Is(err, ErrCacheTypeMismatch) {
+		t.Errorf("Expected ErrCacheTypeMismatch, got %v", err)
+	}
+	if _, err := GetTyped[string](cache, "missing"); err != ErrCacheKeyNotFound {
+		t.Errorf("Expected ErrCacheKeyNotFound, got %v", err)
+	}
+
+	// Other cache managers are type-asserted
+	mock := newMockSessionCacheManager()
+	mock.Set("count", 3, 0)
+	if count, err := GetTyped[int](mock, "count"); err != nil || count != 3 {
+		t.Errorf("Expected 3, got %v, %v", count, err)
+	}
+}
+
+func TestGetTyped_DistributedCache(t *testing.T) {
+	for _, codec := range []string{CacheCodecJSON, CacheCodecGob, CacheCodecMessagePack} {
+		t.Run(codec, func(t *testing.T) {
+			distributed := newMapDistributedCache()
+			config := CacheConfig{Serialization: CacheSerializationConfig{Codec: codec}}
+			writer := NewCacheManagerWithDistributed(config, distributed)
+			reader := NewCacheManagerWithDistributed(config, distributed)
+
+			if err := writer.Set("profile", testProfile, time.Minute); err != nil {
+				t.Fatalf("Failed to set: %v", err)
+			}
+			if raw, _ := distributed.Get("profile"); !isCacheEnvelope(raw.([]byte)) {
+				t.Fatalf("Expected an encoded value in the distributed cache, got %T", raw)
+			}
+
+			profile, err := GetTyped[cachedProfile](reader, "profile")
+			if err != nil || !reflect.DeepEqual(profile, testProfile) {
+				t.Errorf("Expected the decoded profile, got %+v, %v", profile, err)
+			}
+			if _, err := GetTyped[[]int](reader, "profile"); !errors.Is(err, ErrCacheDecode) {
+				t.Errorf("Expected a decoding error for the wrong type, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCacheManager_GetDecodesDistributedValues(t *testing.T) {
+	distributed := newMapDistributedCache()
+	writer := NewCacheManagerWithDistributed(CacheConfig{}, distributed)
+	reader := NewCacheManagerWithDistributed(CacheConfig{}, distributed)
+
+	writer.SetMultiple(map[string]interface{}{"profile": testProfile, "name": "Ada"}, time.Minute)
+	value, err := reader.Get("profile")
+	if err != nil {
+		t.Fatalf("Failed to get: %v", err)
+	}
+	if profile, ok := value.(map[string]interface{}); !ok || profile["name"] != "Ada" {
+		t.Errorf("Expected a JSON object, got %#v", value)
+	}
+	if value, _ := reader.Get("name"); value != "Ada" {
+		t.Errorf("Expected Ada, got %#v", value)
+	}
+
+	// Values written by other clients are returned as they are
+	distributed.Set("external", 42, time.Minute)
+	if value, _ := reader.Get("external"); value != 42 {
+		t.Errorf("Expected 42, got %#v", value)
+	}
+
+	// Corrupted values are reported instead of returned
+	distributed.Set("profile", []byte{cacheEnvelopeMagic, cacheEnvelopeVersion, 0, 4, 'j', 's', 'o', 'n', '{'}, time.Minute)
+	if _, err := reader.Get("profile"); !errors.Is(err, ErrCacheDecode) {
+		t.Errorf("Expected ErrCacheDecode, got %v", err)
+	}
+}
+
+func TestGetOrLoadTyped(t *testing.T) {
+	distributed := newMapDistributedCache()
+	config := CacheConfig{Serialization: CacheSerializationConfig{Codec: CacheCodecGob}}
+	first := NewCacheManagerWithDistributed(config, distributed)
+	second := NewCacheManagerWithDistributed(config, distributed)
+
+	loads := 0
+	loader := func(key string) (cachedProfile, error) {
+		loads++
+		return testProfile, nil
+	}
+	for _, cache := range []CacheManager{first, second, second} {
+		profile, err := GetOrLoadTyped(cache, "profile", time.Minute, loader)
+		if err != nil || !reflect.DeepEqual(profile, testProfile) {
+			t.Fatalf("Expected the profile, got %+v, %v", profile, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("Expected the second instance to read the distributed cache, got %d loads", loads)
+	}
+
+	// A corrupted distributed value is loaded again and overwritten
+	distributed.Set("broken", []byte{cacheEnvelopeMagic, cacheEnvelopeVersion, 0, 3, 'g', 'o', 'b', 0xff}, time.Minute)
+	if _, err := GetOrLoadTyped(first, "broken", time.Minute, loader); err != nil || loads != 2 {
+		t.Errorf("Expected the broken value to be reloaded, got %v after %d loads", err, loads)
+	}
+
+	if _, err := GetOrLoadTyped(first, "failing", time.Minute, func(key string) (cachedProfile, error) {
+		return cachedProfile{}, errors.New("database down")
+	}); err == nil {
+		t.Error("Expected the loader error")
+	}
+}
@@ -42,6 +42,8 @@ func (c *ServerConfig) ApplyDefaults() {
 // ApplyDefaults applies default values to DatabaseConfig for any zero-valued fields
 // Default: Host="localhost", MaxOpenConns=25, MaxIdleConns=5, ConnMaxLifetime=5m
 // Port defaults are driver-specific: postgres=5432, mysql=3306, mssql=1433, sqlite=0
+// TxMaxRetries=3, TxRetryBackoff=10ms,
+// ReplicaHealthCheckInterval=5s when replicas are configured
 func (c *DatabaseConfig) ApplyDefaults() {
 	if c.Host == "" {
 		c.Host = "localhost"
@@ -68,10 +70,20 @@ func (c *DatabaseConfig) ApplyDefaults() {
 	if c.ConnMaxLifetime == 0 {
 		c.ConnMaxLifetime = 5 * time.Minute
 	}
+	if c.TxMaxRetries == 0 {
+		c.TxMaxRetries = 3
+	}
+	if c.TxRetryBackoff == 0 {
+		c.TxRetryBackoff = 10 * time.Millisecond
+	}
+	if len(c.Replicas) > 0 && c.ReplicaHealthCheckInterval <= 0 {
+		c.ReplicaHealthCheckInterval = 5 * time.Second
+	}
 }
 
 // ApplyDefaults applies default values to CacheConfig for any zero-valued fields
-// Default: Type="memory", MaxSize=0 (unlimited), DefaultTTL=0 (no expiration)
+// Default: Type="memory", MaxSize=0 (unlimited), DefaultTTL=0 (no expiration),
+// EvictionPolicy="lru", Shards=16, LoadLockTimeout=10s, Serialization.Codec="json"
 // Negative values are normalized to 0
 func (c *CacheConfig) ApplyDefaults() {
 	if c.Type == "" {
@@ -84,11 +96,80 @@ func (c *CacheConfig) ApplyDefaults() {
 	if c.DefaultTTL < 0 {
 		c.DefaultTTL = 0
 	}
+	if c.EvictionPolicy == "" {
+		c.EvictionPolicy = CacheEvictionLRU
+	}
+	if c.Shards <= 0 {
+		c.Shards = 16
+	}
+	if c.StaleWhileRevalidate < 0 {
+		c.StaleWhileRevalidate = 0
+	}
+	if c.EarlyRefreshBeta < 0 {
+		c.EarlyRefreshBeta = 0
+	}
+	if c.NegativeTTL < 0 {
+		c.NegativeTTL = 0
+	}
+	if c.LoadLockTimeout <= 0 {
+		c.LoadLockTimeout = 10 * time.Second
+	}
+	c.Invalidation.ApplyDefaults()
+	if c.Serialization.Codec == "" {
+		c.Serialization.Codec = CacheCodecJSON
+	}
+	if c.Serialization.CompressionThreshold < 0 {
+		c.Serialization.CompressionThreshold = 0
+	}
+}
+
+// ApplyDefaults applies default values to CacheInvalidationConfig for any zero-valued fields
+// Default: PollInterval=1s, BatchSize=500, Retention=10m, TombstoneTTL=1m
+func (c *CacheInvalidationConfig) ApplyDefaults() {
+	if c.PollInterval <= 0 {
+		c.PollInterval = time.Second
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 500
+	}
+	if c.Retention <= 0 {
+		c.Retention = 10 * time.Minute
+	}
+	if c.TombstoneTTL <= 0 {
+		c.TombstoneTTL = time.Minute
+	}
+}
+
+// ApplyDefaults applies default values to HTTPCacheConfig for any zero-valued fields
+// Default: Name="rockstar", KeyPrefix="http:", StaleRetention=1h, MaxBodySize=1MB,
+// SurrogateKeyHeader="Surrogate-Key"
+// A negative DefaultTTL is normalized to 0
+func (c *HTTPCacheConfig) ApplyDefaults() {
+	if c.Name == "" {
+		c.Name = "rockstar"
+	}
+	if c.KeyPrefix == "" {
+		c.KeyPrefix = "http:"
+	}
+	if c.DefaultTTL < 0 {
+		c.DefaultTTL = 0
+	}
+	if c.StaleRetention <= 0 {
+		c.StaleRetention = time.Hour
+	}
+	if c.MaxBodySize <= 0 {
+		c.MaxBodySize = 1024 * 1024
+	}
+	if c.SurrogateKeyHeader == "" {
+		c.SurrogateKeyHeader = "Surrogate-Key"
+	}
 }
 
 // ApplyDefaults applies default values to SessionConfig for any zero-valued fields
 // Default: CookieName="rockstar_session", CookiePath="/", SessionLifetime=24h,
-// CleanupInterval=1h, FilesystemPath="./sessions", EncryptionKey=random 32 bytes
+// CleanupInterval=1h, FilesystemPath="./sessions", CookieChunkSize=3800,
+// CookieMaxChunks=5, SessionLimitPolicy=SessionLimitEvictOldest,
+// EncryptionKey=random 32 bytes
 func (c *SessionConfig) ApplyDefaults() {
 	if c.CookieName == "" {
 		c.CookieName = "rockstar_session"
@@ -105,6 +186,15 @@ func (c *SessionConfig) ApplyDefaults() {
 	if c.FilesystemPath == "" {
 		c.FilesystemPath = "./sessions"
 	}
+	if c.CookieChunkSize == 0 {
+		c.CookieChunkSize = 3800
+	}
+	if c.CookieMaxChunks == 0 {
+		c.CookieMaxChunks = 5
+	}
+	if c.SessionLimitPolicy == "" {
+		c.SessionLimitPolicy = SessionLimitEvictOldest
+	}
 	if len(c.EncryptionKey) == 0 {
 		// Generate a random 32-byte key for AES-256
 		// WARNING: Using a random key means sessions won't persist across restarts
@@ -138,3 +228,187 @@ func (c *MonitoringConfig) ApplyDefaults() {
 		c.OptimizationInterval = 5 * time.Minute
 	}
 }
+
+// ApplyDefaults applies default values to MFAConfig for any zero-valued fields
+// Default: Issuer="Rockstar", Algorithm=SHA1, Digits=6, Period=30s, Skew=1,
+// HOTPLookAhead=10, SecretSize=20, RecoveryCodeCount=10, RecoveryCodeLength=10,
+// Hasher=bcrypt
+func (c *MFAConfig) ApplyDefaults() {
+	if c.Issuer == "" {
+		c.Issuer = "Rockstar"
+	}
+	if c.Algorithm == "" {
+		c.Algorithm = OTPAlgorithmSHA1
+	}
+	if c.Digits == 0 {
+		c.Digits = 6
+	}
+	if c.Period == 0 {
+		c.Period = 30 * time.Second
+	}
+	// Negative skews and look-aheads are kept, so applying defaults again
+	// does not turn a disabled drift tolerance or look-ahead back on
+	if c.Skew == 0 {
+		c.Skew = 1
+	}
+	if c.HOTPLookAhead == 0 {
+		c.HOTPLookAhead = 10
+	}
+	if c.SecretSize == 0 {
+		c.SecretSize = 20
+	}
+	if c.RecoveryCodeCount == 0 {
+		c.RecoveryCodeCount = 10
+	}
+	if c.RecoveryCodeLength == 0 {
+		c.RecoveryCodeLength = 10
+	}
+	if c.Hasher == nil {
+		c.Hasher = NewPasswordHasher(AlgorithmBcrypt)
+	}
+}
+
+// ApplyDefaults applies default values to RateLimiterConfig for any zero-valued fields
+// Default: Algorithm=token_bucket, Window=1m, Burst=Limit, KeyFunc=RateLimitKeyByIP,
+// Store=in-memory, Prefix="ratelimit"
+func (c *RateLimiterConfig) ApplyDefaults() {
+	if c.Algorithm == "" {
+		c.Algorithm = RateLimitTokenBucket
+	}
+	if c.Window == 0 {
+		c.Window = time.Minute
+	}
+	if c.Burst <= 0 {
+		c.Burst = c.Limit
+	}
+	if c.KeyFunc == nil {
+		c.KeyFunc = RateLimitKeyByIP
+	}
+	if c.Store == nil {
+		c.Store = newInMemoryRateLimitStorage()
+	}
+	if c.Prefix == "" {
+		c.Prefix = "ratelimit"
+	}
+}
+
+// ApplyDefaults applies default values to QuotaConfig for any zero-valued fields
+// Default: BillingPeriod=monthly, Location=UTC, SoftLimitRatio=0.8
+func (c *QuotaConfig) ApplyDefaults() {
+	if c.BillingPeriod == "" {
+		c.BillingPeriod = QuotaPeriodMonthly
+	}
+	if c.Location == nil {
+		c.Location = time.UTC
+	}
+	if c.SoftLimitRatio <= 0 || c.SoftLimitRatio > 1 {
+		c.SoftLimitRatio = 0.8
+	}
+}
+
+// ApplyDefaults applies default values to TenantIsolationConfig for any zero-valued fields
+// Default: DefaultMode=row, TenantColumn="tenant_id", SchemaPrefix="tenant_"
+func (c *TenantIsolationConfig) ApplyDefaults() {
+	if c.DefaultMode == "" {
+		c.DefaultMode = TenantIsolationRow
+	}
+	if c.TenantColumn == "" {
+		c.TenantColumn = "tenant_id"
+	}
+	if c.SchemaPrefix == "" {
+		c.SchemaPrefix = "tenant_"
+	}
+}
+
+// ApplyDefaults applies default values to MigratorConfig for any zero-valued fields
+// Default: LockTimeout=1m, LockRetryInterval=250ms
+// Dir depends on the database driver and is resolved by NewMigrator
+func (c *MigratorConfig) ApplyDefaults() {
+	if c.LockTimeout <= 0 {
+		c.LockTimeout = time.Minute
+	}
+	if c.LockRetryInterval <= 0 {
+		c.LockRetryInterval = 250 * time.Millisecond
+	}
+}
+
+// ApplyDefaults applies default values to OutboxConfig for any zero-valued fields
+// Default: WebhookTimeout=10s, PollInterval=1s, BatchSize=100, MaxAttempts=10,
+// RetryBackoff=1s, MaxRetryBackoff=10m, LeaseTimeout=1m, Retention=24h
+func (c *OutboxConfig) ApplyDefaults() {
+	if c.WebhookTimeout <= 0 {
+		c.WebhookTimeout = 10 * time.Second
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = time.Second
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 10
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = time.Second
+	}
+	if c.MaxRetryBackoff <= 0 {
+		c.MaxRetryBackoff = 10 * time.Minute
+	}
+	if c.LeaseTimeout <= 0 {
+		c.LeaseTimeout = time.Minute
+	}
+	if c.Retention <= 0 {
+		c.Retention = 24 * time.Hour
+	}
+}
+
+// ApplyDefaults applies default values to JobConfig for any zero-valued fields
+// Default: Workers={"default": 10}, PollInterval=1s, MaxAttempts=10, RetryBackoff=1s,
+// MaxRetryBackoff=1h, LeaseTimeout=5m, Retention=24h
+func (c *JobConfig) ApplyDefaults() {
+	if len(c.Workers) == 0 {
+		c.Workers = map[string]int{DefaultJobQueue: 10}
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = time.Second
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 10
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = time.Second
+	}
+	if c.MaxRetryBackoff <= 0 {
+		c.MaxRetryBackoff = time.Hour
+	}
+	if c.LeaseTimeout <= 0 {
+		c.LeaseTimeout = 5 * time.Minute
+	}
+	if c.Retention <= 0 {
+		c.Retention = 24 * time.Hour
+	}
+}
+
+// ApplyDefaults applies default values to SchedulerConfig for any zero-valued fields
+// Default: TimeZone="UTC", LeaseName="scheduler", LeaseTimeout=30s, TickInterval=1s,
+// MissedRunThreshold=1m, HistoryRetention=7d
+func (c *SchedulerConfig) ApplyDefaults() {
+	if c.TimeZone == "" {
+		c.TimeZone = "UTC"
+	}
+	if c.LeaseName == "" {
+		c.LeaseName = "scheduler"
+	}
+	if c.LeaseTimeout <= 0 {
+		c.LeaseTimeout = 30 * time.Second
+	}
+	if c.TickInterval <= 0 {
+		c.TickInterval = time.Second
+	}
+	if c.MissedRunThreshold <= 0 {
+		c.MissedRunThreshold = time.Minute
+	}
+	if c.HistoryRetention <= 0 {
+		c.HistoryRetention = 7 * 24 * time.Hour
+	}
+}
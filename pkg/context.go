@@ -25,6 +25,9 @@ type Context interface {
 	DB() DatabaseManager
 	Cache() CacheManager
 
+	// Background jobs
+	Jobs() JobQueue
+
 	// Configuration and internationalization
 	Config() ConfigManager
 	I18n() I18nManager
@@ -38,6 +41,8 @@ type Context interface {
 
 	// Context control
 	Context() context.Context
+	// Deprecated: WithTimeout cannot release its timer before the timeout
+	// fires. Use ContextWithTimeout instead.
 	WithTimeout(timeout time.Duration) Context
 	WithCancel() (Context, context.CancelFunc)
 
@@ -68,3 +73,11 @@ type Context interface {
 	Set(key string, value interface{})
 	Get(key string) (interface{}, bool)
 }
+
+// ContextWithTimeout returns a copy of ctx that is canceled after timeout, and
+// a cancel function that releases its timer early. Like context.WithTimeout,
+// call cancel once the work is done.
+func ContextWithTimeout(ctx Context, timeout time.Duration) (Context, context.CancelFunc) {
+	cancelCtx, cancel := ctx.WithCancel()
+	return cancelCtx.WithTimeout(timeout), cancel
+}
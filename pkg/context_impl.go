@@ -27,6 +27,8 @@ type contextImpl struct {
 	files   FileManager
 	logger  Logger
 	metrics MetricsCollector
+	jobs    JobQueue
+	quotas  QuotaManager
 
 	// User context
 	user   *User
@@ -121,6 +123,15 @@ func (c *contextImpl) Cache() CacheManager {
 	return c.cache
 }
 
+// Jobs returns the background job queue. Jobs enqueued while a
+// transaction from WithTx is active are written in that transaction.
+func (c *contextImpl) Jobs() JobQueue {
+	if c.jobs == nil {
+		return nil
+	}
+	return &requestJobQueue{JobQueue: c.jobs, ctx: c}
+}
+
 // Config returns the configuration manager
 func (c *contextImpl) Config() ConfigManager {
 	return c.config
@@ -131,9 +142,13 @@ func (c *contextImpl) I18n() I18nManager {
 	return c.i18n
 }
 
-// Files returns the file manager
+// Files returns the file manager. Once a tenant is set, writes and
+// deletes are metered against its storage quota.
 func (c *contextImpl) Files() FileManager {
-	return c.files
+	if c.files == nil || c.quotas == nil || c.tenant == nil {
+		return c.files
+	}
+	return NewQuotaFileManager(c.files, c.quotas, c.tenant)
 }
 
 // Logger returns the logger
@@ -158,11 +173,16 @@ func (c *contextImpl) Context() context.Context {
 	return c.ctx
 }
 
-// WithTimeout creates a context with timeout
+// WithTimeout creates a context with timeout. The timer is only released when
+// it fires; ContextWithTimeout returns a cancel function that releases it early.
 func (c *contextImpl) WithTimeout(timeout time.Duration) Context {
-	ctx, _ := context.WithTimeout(c.Context(), timeout)
+	ctx, cancel := context.WithTimeout(c.Context(), timeout)
+	// WithTimeout cannot return cancel. The timer also stops when the parent
+	// is canceled, which is how ContextWithTimeout releases it early.
+	_ = cancel
 	newCtx := *c
 	newCtx.ctx = ctx
+	newCtx.db = bindDatabase(c.db, ctx)
 	// Share the same values map (same request)
 	newCtx.values = c.values
 	return &newCtx
@@ -173,11 +193,21 @@ func (c *contextImpl) WithCancel() (Context, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(c.Context())
 	newCtx := *c
 	newCtx.ctx = ctx
+	newCtx.db = bindDatabase(c.db, ctx)
 	// Share the same values map (same request)
 	newCtx.values = c.values
 	return &newCtx, cancel
 }
 
+// bindDatabase rebinds a request database view to a derived context so its
+// statements honor the new deadline or cancellation
+func bindDatabase(db DatabaseManager, ctx context.Context) DatabaseManager {
+	if bound, ok := db.(contextBoundDatabase); ok {
+		return bound.withContext(ctx)
+	}
+	return db
+}
+
 // JSON writes JSON response
 func (c *contextImpl) JSON(statusCode int, data interface{}) error {
 	return c.response.WriteJSON(statusCode, data)
@@ -340,6 +370,11 @@ func (c *contextImpl) SetDB(db DatabaseManager) {
 	c.db = db
 }
 
+// SetJobs sets the background job queue (for testing and initialization)
+func (c *contextImpl) SetJobs(jobs JobQueue) {
+	c.jobs = jobs
+}
+
 // SetSession sets the session manager (for testing and initialization)
 func (c *contextImpl) SetSession(session SessionManager) {
 	c.session = session
@@ -360,6 +395,11 @@ func (c *contextImpl) SetFiles(files FileManager) {
 	c.files = files
 }
 
+// SetQuotas sets the quota manager (for testing and initialization)
+func (c *contextImpl) SetQuotas(quotas QuotaManager) {
+	c.quotas = quotas
+}
+
 // SetLogger sets the logger (for testing and initialization)
 func (c *contextImpl) SetLogger(logger Logger) {
 	c.logger = logger
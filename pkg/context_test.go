@@ -183,6 +183,33 @@ func TestContextWithTimeout(t *testing.T) {
 	}
 }
 
+func TestContextWithTimeoutCancel(t *testing.T) {
+	req := &Request{ID: "test-req"}
+	w := httptest.NewRecorder()
+	resp := NewResponseWriter(w)
+	ctx := NewContext(req, resp, context.Background())
+
+	timeoutCtx, cancel := ContextWithTimeout(ctx, 5*time.Second)
+
+	if timeoutCtx.Request().ID != "test-req" {
+		t.Error("ContextWithTimeout should preserve request data")
+	}
+	if _, ok := timeoutCtx.Context().Deadline(); !ok {
+		t.Error("ContextWithTimeout should set a deadline")
+	}
+
+	// Cancel should end the context before the timeout fires
+	cancel()
+	select {
+	case <-timeoutCtx.Context().Done():
+	case <-time.After(time.Second):
+		t.Error("Cancel should cancel the timeout context")
+	}
+	if ctx.Context().Err() != nil {
+		t.Error("Cancel should not affect the parent context")
+	}
+}
+
 func TestContextWithCancel(t *testing.T) {
 	req := &Request{ID: "test-req"}
 	w := httptest.NewRecorder()
@@ -0,0 +1,250 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed cron expression. It accepts the five standard
+// fields (minute, hour, day of month, month, day of week), an optional
+// leading seconds field, the descriptors @yearly, @monthly, @weekly, @daily,
+// @hourly and "@every <duration>", and a "CRON_TZ=<zone>" prefix.
+type CronSchedule struct {
+	expr     string
+	second   uint64
+	minute   uint64
+	hour     uint64
+	dom      uint64
+	month    uint64
+	dow      uint64
+	every    time.Duration
+	location *time.Location
+}
+
+// cronField describes the range and names of a cron field
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronSeconds = cronField{name: "second", min: 0, max: 59}
+	cronMinutes = cronField{name: "minute", min: 0, max: 59}
+	cronHours   = cronField{name: "hour", min: 0, max: 23}
+	cronDom     = cronField{name: "day of month", min: 1, max: 31}
+	cronMonths  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDow = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// cronDescriptors maps the @ descriptors to their expressions
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// starBit marks a day field given as * or ?, for the day of month / day of week rule
+const starBit = 1 << 63
+
+// ParseCronSchedule parses expr. Times are evaluated in location, or in the
+// zone of a CRON_TZ prefix; a nil location means UTC.
+func ParseCronSchedule(expr string, location *time.Location) (*CronSchedule, error) {
+	spec := strings.TrimSpace(expr)
+	if location == nil {
+		location = time.UTC
+	}
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		zone, rest, _ := strings.Cut(spec, " ")
+		loc, err := time.LoadLocation(zone[strings.Index(zone, "=")+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone in cron expression %q: %w", expr, err)
+		}
+		location = loc
+		spec = strings.TrimSpace(rest)
+	}
+
+	schedule := &CronSchedule{expr: expr, location: location}
+
+	if strings.HasPrefix(spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || every < time.Second {
+			return nil, fmt.Errorf("invalid interval in cron expression %q: must be a duration of at least 1s", expr)
+		}
+		schedule.every = every
+		return schedule, nil
+	}
+	if descriptor, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = descriptor
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 or 6 fields, got %d", expr, len(fields))
+	}
+
+	var err error
+	targets := []*uint64{&schedule.second, &schedule.minute, &schedule.hour, &schedule.dom, &schedule.month, &schedule.dow}
+	for i, field := range []cronField{cronSeconds, cronMinutes, cronHours, cronDom, cronMonths, cronDow} {
+		if *targets[i], err = parseCronField(fields[i], field); err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+	}
+	// Sunday may be written as 7
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow = schedule.dow&^(1<<7) | 1
+	}
+	return schedule, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps
+func parseCronField(value string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(value, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepPart, field.name)
+			}
+			step = n
+		}
+
+		var start, end int
+		switch {
+		case rangePart == "*" || rangePart == "?":
+			start, end = field.min, field.max
+			if field.max == 7 {
+				end = 6
+			}
+			if !hasStep {
+				bits |= starBit
+			}
+		default:
+			low, high, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = parseCronValue(low, field); err != nil {
+				return 0, err
+			}
+			end = start
+			if isRange {
+				if end, err = parseCronValue(high, field); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				end = field.max
+			}
+			if end < start {
+				return 0, fmt.Errorf("invalid range %q in %s field", rangePart, field.name)
+			}
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// parseCronValue parses a number or name within the range of field
+func parseCronValue(value string, field cronField) (int, error) {
+	if n, ok := field.names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < field.min || n > field.max {
+		return 0, fmt.Errorf("invalid value %q in %s field (allowed %d-%d)", value, field.name, field.min, field.max)
+	}
+	return n, nil
+}
+
+// String returns the expression the schedule was parsed from
+func (s *CronSchedule) String() string {
+	return s.expr
+}
+
+// Location returns the time zone the schedule is evaluated in
+func (s *CronSchedule) Location() *time.Location {
+	return s.location
+}
+
+// Next returns the first activation after t, or the zero time if there is
+// none within the next five years (e.g. for February 30th). Intervals of
+// @every are aligned to the Unix epoch, so all instances agree on them.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Truncate(s.every).Add(s.every)
+	}
+
+	// Start at the next whole second in the schedule's time zone
+	t = t.In(s.location).Truncate(time.Second).Add(time.Second)
+	limit := t.Year() + 5
+
+wrap:
+	if t.Year() > limit {
+		return time.Time{}
+	}
+	for s.month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !s.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+		if !next.After(t) {
+			// Skipping into a repeated hour at the end of daylight saving time
+			next = t.Add(time.Hour - time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second)
+		}
+		t = next
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Truncate(time.Minute).Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+	for s.second&(1<<uint(t.Second())) == 0 {
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+	return t
+}
+
+// dayMatches applies the cron rule that a day matches either day field when
+// both are restricted, and both otherwise
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.dom&starBit != 0 || s.dow&starBit != 0 {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
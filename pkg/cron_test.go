@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func mustParseCron(t *testing.T, expr string, location *time.Location) *CronSchedule {
+	t.Helper()
+	schedule, err := ParseCronSchedule(expr, location)
+	if err != nil {
+		t.Fatalf("ParseCronSchedule(%q) failed: %v", expr, err)
+	}
+	return schedule
+}
+
+func TestCronSchedule_Next(t *testing.T) {
+	// Friday, 10:07:30 UTC
+	from := time.Date(2026, time.March, 13, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, 3, 13, 10, 15, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2026, 3, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan,jul *", time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 8 * * 7", time.Date(2026, 3, 15, 8, 30, 0, 0, time.UTC)},
+		{"*/10 * * * * *", time.Date(2026, 3, 13, 10, 7, 40, 0, time.UTC)},
+		{"@daily", time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 3, 13, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		// Restricted day of month and day of week match either
+		{"0 0 20 * fri", time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * fri", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+		{"@every 1h", time.Date(2026, 3, 13, 11, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := mustParseCron(t, tt.expr, nil).Next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.expr, tt.want, got)
+		}
+	}
+}
+
+func TestCronSchedule_TimeZones(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+
+	// 9:00 in Berlin is 8:00 UTC in winter and 7:00 UTC in summer
+	schedule := mustParseCron(t, "0 9 * * *", berlin)
+	winter := schedule.Next(time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC))
+	if !winter.Equal(time.Date(2026, 3, 21, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected 8:00 UTC, got %v", winter.UTC())
+	}
+	summer := schedule.Next(time.Date(2026, 3, 29, 0, 0, 0, 0, time.UTC))
+	if !summer.Equal(time.Date(2026, 3, 29, 7, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected 7:00 UTC after the switch to summer time, got %v", summer.UTC())
+	}
+
+	// A CRON_TZ prefix overrides the default location
+	prefixed := mustParseCron(t, "CRON_TZ=Europe/Berlin 0 9 * * *", time.UTC)
+	if prefixed.Location().String() != "Europe/Berlin" || !prefixed.Next(time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC)).Equal(winter) {
+		t.Errorf("Expected the CRON_TZ zone to be used, got %s", prefixed.Location())
+	}
+
+	// A time skipped by the switch to summer time does not run that day
+	skipped := mustParseCron(t, "30 2 * * *", berlin).Next(time.Date(2026, 3, 29, 0, 0, 0, 0, berlin))
+	if !skipped.Equal(time.Date(2026, 3, 30, 2, 30, 0, 0, berlin)) {
+		t.Errorf("Expected the next day, got %v", skipped)
+	}
+}
+
+func TestCronSchedule_Invalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * *",
+		"61 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * mon-sun-tue",
+		"10-5 * * * *",
+		"*/0 * * * *",
+		"@every 10ms",
+		"@every soon",
+		"CRON_TZ=Nowhere/Zone * * * * *",
+	} {
+		if _, err := ParseCronSchedule(expr, nil); err == nil {
+			t.Errorf("Expected %q to be rejected", expr)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"database/sql"
 	"time"
 )
@@ -19,12 +20,27 @@ type DatabaseManager interface {
 	QueryRow(query string, args ...interface{}) *sql.Row
 	Exec(query string, args ...interface{}) (sql.Result, error)
 
+	// Context-aware query execution. Canceling ctx aborts the statement, and
+	// DatabaseConfig.QueryTimeout bounds it unless ctx has an earlier deadline.
+	// Timeouts and cancellations are returned as FrameworkErrors.
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+
 	// Prepared statements
 	Prepare(query string) (*sql.Stmt, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
 
 	// Transaction support
 	Begin() (Transaction, error)
 	BeginTx(opts *sql.TxOptions) (Transaction, error)
+	// BeginTxContext starts a transaction that is rolled back if ctx is canceled
+	// before it commits
+	BeginTxContext(ctx context.Context, opts *sql.TxOptions) (Transaction, error)
+	// WithTx runs fn in a transaction that is committed when fn returns nil and
+	// rolled back on error or panic. Serialization failures and deadlocks are
+	// retried. Calls nested through the same request database use savepoints.
+	WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Transaction) error) error
 
 	// Framework-specific model operations
 	SaveSession(session *Session) error
@@ -61,12 +77,65 @@ type DatabaseManager interface {
 	GetQuery(name string) (string, error)
 }
 
+// requestScopedDatabase is implemented by database managers that keep
+// per-request state: the request context, which the context-less methods
+// then use, and read-your-writes stickiness
+type requestScopedDatabase interface {
+	forRequest(ctx context.Context) DatabaseManager
+}
+
+// contextBoundDatabase is implemented by request views that can be rebound to a
+// context derived from the request context, keeping their request state
+type contextBoundDatabase interface {
+	withContext(ctx context.Context) DatabaseManager
+}
+
+// transactionalDatabase is implemented by request views that track the
+// transaction started through WithTx
+type transactionalDatabase interface {
+	activeTransaction() Transaction
+}
+
+// TxFromContext returns the transaction the request is running in through
+// ctx.DB().WithTx, so services called from the transaction can join it
+func TxFromContext(ctx Context) (Transaction, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	if db, ok := ctx.DB().(transactionalDatabase); ok {
+		if tx := db.activeTransaction(); tx != nil {
+			return tx, true
+		}
+	}
+	return nil, false
+}
+
+// primaryScopedDatabase is implemented by database managers that route reads
+// to replicas and can pin them to the primary
+type primaryScopedDatabase interface {
+	forPrimary() DatabaseManager
+}
+
+// PrimaryDatabase returns a view of db whose reads always go to the primary.
+// Use it for read-modify-write sequences that must not observe replica lag.
+// Without replicas db is returned unchanged.
+func PrimaryDatabase(db DatabaseManager) DatabaseManager {
+	if scoped, ok := db.(primaryScopedDatabase); ok {
+		return scoped.forPrimary()
+	}
+	return db
+}
+
 // Transaction represents a database transaction
 type Transaction interface {
 	Query(query string, args ...interface{}) (*sql.Rows, error)
 	QueryRow(query string, args ...interface{}) *sql.Row
 	Exec(query string, args ...interface{}) (sql.Result, error)
 	Prepare(query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
 	Commit() error
 	Rollback() error
 }
@@ -121,9 +190,78 @@ type DatabaseConfig struct {
 	// Default: 5
 	MaxIdleConns int `json:"max_idle_conns"`
 
+	// QueryTimeout bounds every statement that has no earlier context deadline.
+	// Rows returned by Query must be read within the timeout.
+	// Default: 0 (statements are only bounded by their context)
+	QueryTimeout time.Duration `json:"query_timeout"`
+
+	// TxMaxRetries is how often WithTx retries a transaction that failed with a
+	// serialization failure or deadlock.
+	// Default: 3 (0 disables retries when ApplyDefaults is not used)
+	TxMaxRetries int `json:"tx_max_retries"`
+
+	// TxRetryBackoff is the delay before the first WithTx retry. It doubles
+	// with every further retry and is randomized by up to 50%.
+	// Default: 10 milliseconds
+	TxRetryBackoff time.Duration `json:"tx_retry_backoff"`
+
 	// Options provides driver-specific connection options.
 	// Default: nil
 	Options map[string]string `json:"options"`
+
+	// Replicas lists read replicas. Query and QueryRow are routed to healthy
+	// replicas; Exec, Prepare and transactions always use the primary.
+	// Default: nil (all queries use the primary)
+	Replicas []ReplicaConfig `json:"replicas"`
+
+	// ReplicaHealthCheckInterval is how often replicas are pinged and their lag measured.
+	// Default: 5 seconds
+	ReplicaHealthCheckInterval time.Duration `json:"replica_health_check_interval"`
+
+	// MaxReplicaLag takes a replica out of rotation while its replication lag
+	// exceeds this value. Lag is measured with the replica_lag query.
+	// Default: 0 (lag is not checked)
+	MaxReplicaLag time.Duration `json:"max_replica_lag"`
+}
+
+// ReplicaConfig defines a read replica. Empty fields are inherited from the
+// primary DatabaseConfig, so usually only Host is set.
+type ReplicaConfig struct {
+	// Name identifies the replica in DatabaseStats.
+	// Default: "replica-N"
+	Name string `json:"name"`
+
+	// DSN is a complete driver connection string. When set, the other fields are ignored.
+	// Default: ""
+	DSN string `json:"dsn"`
+
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Database string `json:"database"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// apply returns the primary configuration with the replica's fields applied
+func (r ReplicaConfig) apply(primary DatabaseConfig) DatabaseConfig {
+	config := primary
+	config.Replicas = nil
+	if r.Host != "" {
+		config.Host = r.Host
+	}
+	if r.Port != 0 {
+		config.Port = r.Port
+	}
+	if r.Database != "" {
+		config.Database = r.Database
+	}
+	if r.Username != "" {
+		config.Username = r.Username
+	}
+	if r.Password != "" {
+		config.Password = r.Password
+	}
+	return config
 }
 
 // DatabaseStats provides database connection statistics
@@ -135,6 +273,20 @@ type DatabaseStats struct {
 	WaitDuration      time.Duration `json:"wait_duration"`
 	MaxIdleClosed     int64         `json:"max_idle_closed"`
 	MaxLifetimeClosed int64         `json:"max_lifetime_closed"`
+
+	// Read routing (only populated when replicas are configured)
+	PrimaryReads int64          `json:"primary_reads,omitempty"`
+	Replicas     []ReplicaStats `json:"replicas,omitempty"`
+}
+
+// ReplicaStats provides the health and pool statistics of a read replica
+type ReplicaStats struct {
+	Name      string        `json:"name"`
+	Healthy   bool          `json:"healthy"`
+	Lag       time.Duration `json:"lag"`
+	Reads     int64         `json:"reads"`
+	LastError string        `json:"last_error,omitempty"`
+	Pool      DatabaseStats `json:"pool"`
 }
 
 // Session represents a user session stored in database
@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// errQueryTimeout is the cancellation cause of a statement that ran longer
+// than DatabaseConfig.QueryTimeout
+var errQueryTimeout = errors.New("query timeout exceeded")
+
+// sqlExecutor is the statement API shared by *sql.DB, *sql.Conn and *sql.Tx
+type sqlExecutor interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// withStatementTimeout derives the context a statement runs with. The timeout
+// only applies when ctx has no earlier deadline. The returned stop function
+// releases the timer; for statements that return rows it runs when the rows
+// are closed or the row is scanned, so the rows stay readable until then.
+func withStatementTimeout(ctx context.Context, timeout time.Duration) (context.Context, func()) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= timeout {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(timeout, func() { cancel(errQueryTimeout) })
+	return ctx, func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
+
+// statementError converts an error caused by a canceled or expired context into
+// a FrameworkError. Other errors are returned unchanged.
+func statementError(ctx context.Context, operation string, timeout time.Duration, err error) error {
+	if err == nil || ctx == nil || ctx.Err() == nil {
+		return err
+	}
+
+	var frameworkErr *FrameworkError
+	switch {
+	case errors.Is(context.Cause(ctx), errQueryTimeout):
+		frameworkErr = NewDatabaseTimeoutError(operation, timeout)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		frameworkErr = NewDatabaseTimeoutError(operation, 0)
+	default:
+		frameworkErr = NewDatabaseCanceledError(operation)
+	}
+	frameworkErr.Details["error"] = err.Error()
+	return frameworkErr
+}
+
+// queryWithTimeout runs a query on exec. The timeout keeps running while the
+// returned rows are read and is released when they are closed.
+func queryWithTimeout(ctx context.Context, exec sqlExecutor, timeout time.Duration, query string, args ...interface{}) (*Rows, error) {
+	ctx, stop := withStatementTimeout(ctx, timeout)
+	rows, err := exec.QueryContext(ctx, query, args...)
+	if err != nil {
+		stop()
+		return nil, statementError(ctx, "query", timeout, err)
+	}
+	return newRows(rows, stop), nil
+}
+
+// queryRowWithTimeout runs a single-row query on exec. The timeout keeps
+// running until the row is scanned.
+func queryRowWithTimeout(ctx context.Context, exec sqlExecutor, timeout time.Duration, query string, args ...interface{}) *Row {
+	ctx, stop := withStatementTimeout(ctx, timeout)
+	return newRow(exec.QueryRowContext(ctx, query, args...), stop)
+}
+
+// execWithTimeout runs a statement that returns no rows on exec
+func execWithTimeout(ctx context.Context, exec sqlExecutor, timeout time.Duration, query string, args ...interface{}) (sql.Result, error) {
+	ctx, stop := withStatementTimeout(ctx, timeout)
+	defer stop()
+
+	result, err := exec.ExecContext(ctx, query, args...)
+	return result, statementError(ctx, "exec", timeout, err)
+}
+
+// prepareWithTimeout prepares a statement on exec
+func prepareWithTimeout(ctx context.Context, exec sqlExecutor, timeout time.Duration, query string) (*sql.Stmt, error) {
+	ctx, stop := withStatementTimeout(ctx, timeout)
+	defer stop()
+
+	stmt, err := exec.PrepareContext(ctx, query)
+	return stmt, statementError(ctx, "prepare", timeout, err)
+}
@@ -0,0 +1,149 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// slowQuery never finishes on its own in SQLite
+const slowQuery = "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT COUNT(*) FROM c"
+
+// connectContextTestDB connects to a fresh SQLite database with the given query timeout
+func connectContextTestDB(t *testing.T, queryTimeout time.Duration) DatabaseManager {
+	t.Helper()
+	config := createTestDBConfig(filepath.Join(t.TempDir(), "context.db"))
+	config.QueryTimeout = queryTimeout
+
+	dm := NewDatabaseManager()
+	if err := dm.Connect(config); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { dm.Close() })
+
+	if _, err := dm.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	return dm
+}
+
+// assertFrameworkErrorCode checks that err is a FrameworkError with the given code
+func assertFrameworkErrorCode(t *testing.T, err error, code string) *FrameworkError {
+	t.Helper()
+	var frameworkErr *FrameworkError
+	if !errors.As(err, &frameworkErr) || frameworkErr.Code != code {
+		t.Fatalf("Expected %s error, got %v", code, err)
+	}
+	return frameworkErr
+}
+
+func TestIntegration_DatabaseQueryTimeout(t *testing.T) {
+	dm := connectContextTestDB(t, 50*time.Millisecond)
+
+	start := time.Now()
+	_, err := dm.Exec(slowQuery)
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("Expected the query timeout to abort the statement")
+	}
+
+	frameworkErr := assertFrameworkErrorCode(t, err, ErrCodeDatabaseTimeout)
+	if !errors.Is(err, context.DeadlineExceeded) || frameworkErr.Details["timeout"] != "50ms" {
+		t.Errorf("Unexpected timeout error: %+v", frameworkErr)
+	}
+
+	// Rows stay readable after QueryContext returns
+	if _, err := dm.Exec("INSERT INTO items (id) VALUES (1), (2)"); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	rows, err := dm.QueryContext(context.Background(), "SELECT id FROM items")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	count := 0
+	for rows.Next() {
+		count++
+	}
+	rows.Close()
+	if rows.Err() != nil || count != 2 {
+		t.Errorf("Expected 2 rows, got %d (%v)", count, rows.Err())
+	}
+}
+
+func TestIntegration_DatabaseContextDeadlineAndCancel(t *testing.T) {
+	dm := connectContextTestDB(t, time.Minute)
+
+	// A caller deadline shorter than QueryTimeout wins
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := dm.ExecContext(ctx, slowQuery)
+	frameworkErr := assertFrameworkErrorCode(t, err, ErrCodeDatabaseTimeout)
+	if _, ok := frameworkErr.Details["timeout"]; ok {
+		t.Error("Expected no configured timeout in details for a caller deadline")
+	}
+
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	_, err = dm.ExecContext(canceled, "INSERT INTO items (id) VALUES (1)")
+	assertFrameworkErrorCode(t, err, ErrCodeDatabaseCanceled)
+	if !errors.Is(err, context.Canceled) {
+		t.Error("Expected cancellation error to wrap context.Canceled")
+	}
+
+	// Ordinary errors are not wrapped
+	if _, err := dm.ExecContext(context.Background(), "INSERT INTO missing (id) VALUES (1)"); err == nil {
+		t.Fatal("Expected error for missing table")
+	} else if errors.As(err, &frameworkErr) {
+		t.Errorf("Expected driver error, got %v", err)
+	}
+}
+
+func TestIntegration_RequestDatabaseUsesRequestContext(t *testing.T) {
+	dm := connectContextTestDB(t, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	request := dm.(requestScopedDatabase).forRequest(ctx)
+
+	if _, err := request.Exec("INSERT INTO items (id) VALUES (1)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+
+	// A derived context bounds statements run through the rebound view
+	derived, cancelDerived := context.WithCancel(ctx)
+	cancelDerived()
+	if _, err := bindDatabase(request, derived).Exec("INSERT INTO items (id) VALUES (2)"); err == nil {
+		t.Error("Expected derived context cancellation to abort the statement")
+	}
+	if _, err := request.Exec("INSERT INTO items (id) VALUES (3)"); err != nil {
+		t.Errorf("Expected request view to be unaffected by the derived context: %v", err)
+	}
+
+	cancel()
+	_, err := request.Query("SELECT id FROM items")
+	assertFrameworkErrorCode(t, err, ErrCodeDatabaseCanceled)
+}
+
+func TestIntegration_TransactionRolledBackOnCancel(t *testing.T) {
+	dm := connectContextTestDB(t, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tx, err := dm.BeginTxContext(ctx, nil)
+	if err != nil {
+		t.Fatalf("BeginTxContext failed: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO items (id) VALUES (1)"); err != nil {
+		t.Fatalf("Exec in transaction failed: %v", err)
+	}
+
+	cancel()
+	assertFrameworkErrorCode(t, tx.Commit(), ErrCodeDatabaseCanceled)
+
+	var count int
+	if err := dm.QueryRow("SELECT COUNT(*) FROM items").Scan(&count); err != nil || count != 0 {
+		t.Errorf("Expected canceled transaction to be rolled back, got %d rows (%v)", count, err)
+	}
+}
@@ -4,11 +4,13 @@
 package pkg
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	// Database drivers
@@ -24,11 +26,16 @@ type databaseManager struct {
 	config    DatabaseConfig
 	mutex     sync.RWMutex
 	sqlLoader SQLLoader
+
+	replicas     *replicaSet  // Read replicas, nil when none are configured
+	primaryReads atomic.Int64 // Reads served by the primary
 }
 
 // transaction implements the Transaction interface
 type transaction struct {
-	tx *sql.Tx
+	tx      *sql.Tx
+	ctx     context.Context // Context the transaction was started with
+	timeout time.Duration   // Per-statement timeout
 }
 
 // NewDatabaseManager creates a new database manager instance
@@ -45,6 +52,10 @@ func (dm *databaseManager) Connect(config DatabaseConfig) error {
 	if dm.db != nil {
 		dm.db.Close()
 	}
+	if dm.replicas != nil {
+		dm.replicas.close()
+		dm.replicas = nil
+	}
 
 	// Build connection string based on driver
 	dsn, err := dm.buildDSN(config)
@@ -106,6 +117,24 @@ func (dm *databaseManager) Connect(config DatabaseConfig) error {
 		dm.sqlLoader = loader
 	}
 
+	// Connect read replicas
+	if len(config.Replicas) > 0 {
+		replicas, err := dm.connectReplicas(config, driverName)
+		if err != nil {
+			return err
+		}
+		if dm.sqlLoader != nil && dm.sqlLoader.HasQuery("replica_lag") {
+			replicas.lagSQL, _ = dm.sqlLoader.GetQuery("replica_lag")
+		}
+
+		interval := config.ReplicaHealthCheckInterval
+		if interval <= 0 {
+			interval = 5 * time.Second
+		}
+		replicas.start(interval)
+		dm.replicas = replicas
+	}
+
 	return nil
 }
 
@@ -212,6 +241,11 @@ func (dm *databaseManager) Close() error {
 	dm.mutex.Lock()
 	defer dm.mutex.Unlock()
 
+	if dm.replicas != nil {
+		dm.replicas.close()
+		dm.replicas = nil
+	}
+
 	if dm.db != nil {
 		err := dm.db.Close()
 		dm.db = nil
@@ -240,7 +274,16 @@ func (dm *databaseManager) Stats() DatabaseStats {
 		return DatabaseStats{}
 	}
 
-	stats := dm.db.Stats()
+	stats := newDatabaseStats(dm.db.Stats())
+	stats.PrimaryReads = dm.primaryReads.Load()
+	if dm.replicas != nil {
+		stats.Replicas = dm.replicas.stats()
+	}
+	return stats
+}
+
+// newDatabaseStats converts connection pool statistics
+func newDatabaseStats(stats sql.DBStats) DatabaseStats {
 	return DatabaseStats{
 		OpenConnections:   stats.OpenConnections,
 		InUse:             stats.InUse,
@@ -259,104 +302,201 @@ func (dm *databaseManager) IsConnected() bool {
 	return dm.db != nil
 }
 
-// Query executes a query that returns rows
+// driverName returns the configured driver, which selects the query builder dialect
+func (dm *databaseManager) driverName() string {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+	return dm.config.Driver
+}
+
+// statementTimeout returns the configured per-statement timeout
+func (dm *databaseManager) statementTimeout() time.Duration {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+	return dm.config.QueryTimeout
+}
+
+// primaryConn reserves a single connection to the primary
+func (dm *databaseManager) primaryConn(ctx context.Context) (*sql.Conn, error) {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+
+	if dm.db == nil {
+		return nil, fmt.Errorf("database connection not established")
+	}
+	conn, err := dm.db.Conn(ctx)
+	if err != nil {
+		return nil, statementError(ctx, "connect", 0, err)
+	}
+	return conn, nil
+}
+
+// Query executes a query that returns rows, on a healthy replica if configured
 func (dm *databaseManager) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return sqlRows(dm.QueryContext(context.Background(), query, args...))
+}
+
+// QueryContext executes a query that returns rows, on a healthy replica if configured
+func (dm *databaseManager) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
 	dm.mutex.RLock()
 	defer dm.mutex.RUnlock()
 
 	if dm.db == nil {
 		return nil, fmt.Errorf("database connection not established")
 	}
-	return dm.db.Query(query, args...)
+	return queryWithTimeout(ctx, dm.readDB(), dm.config.QueryTimeout, query, args...)
 }
 
-// QueryRow executes a query that returns at most one row
+// QueryRow executes a query that returns at most one row, on a healthy replica if configured
 func (dm *databaseManager) QueryRow(query string, args ...interface{}) *sql.Row {
+	return dm.QueryRowContext(context.Background(), query, args...).sqlRow()
+}
+
+// QueryRowContext executes a query that returns at most one row, on a healthy
+// replica if configured. Timeouts surface as context errors from Scan.
+func (dm *databaseManager) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
 	dm.mutex.RLock()
 	defer dm.mutex.RUnlock()
 
 	if dm.db == nil {
-		// Return a row that will return an error when scanned
-		return &sql.Row{}
+		return newErrorRow(fmt.Errorf("database connection not established"))
 	}
-	return dm.db.QueryRow(query, args...)
+	return queryRowWithTimeout(ctx, dm.readDB(), dm.config.QueryTimeout, query, args...)
 }
 
-// Exec executes a query without returning any rows
-func (dm *databaseManager) Exec(query string, args ...interface{}) (sql.Result, error) {
+// primaryQuery executes a query that returns rows on the primary
+func (dm *databaseManager) primaryQuery(query string, args ...interface{}) (*Rows, error) {
+	return dm.primaryQueryContext(context.Background(), query, args...)
+}
+
+// primaryQueryContext executes a query that returns rows on the primary
+func (dm *databaseManager) primaryQueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
 	dm.mutex.RLock()
 	defer dm.mutex.RUnlock()
 
 	if dm.db == nil {
 		return nil, fmt.Errorf("database connection not established")
 	}
-	return dm.db.Exec(query, args...)
+	dm.primaryReads.Add(1)
+	return queryWithTimeout(ctx, dm.db, dm.config.QueryTimeout, query, args...)
 }
 
-// Prepare creates a prepared statement
-func (dm *databaseManager) Prepare(query string) (*sql.Stmt, error) {
+// primaryQueryRow executes a query that returns at most one row on the primary
+func (dm *databaseManager) primaryQueryRow(query string, args ...interface{}) *Row {
+	return dm.primaryQueryRowContext(context.Background(), query, args...)
+}
+
+// primaryQueryRowContext executes a query that returns at most one row on the primary
+func (dm *databaseManager) primaryQueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
 	dm.mutex.RLock()
 	defer dm.mutex.RUnlock()
 
 	if dm.db == nil {
-		return nil, fmt.Errorf("database connection not established")
+		return newErrorRow(fmt.Errorf("database connection not established"))
 	}
-	return dm.db.Prepare(query)
+	dm.primaryReads.Add(1)
+	return queryRowWithTimeout(ctx, dm.db, dm.config.QueryTimeout, query, args...)
 }
 
-// Begin starts a transaction
-func (dm *databaseManager) Begin() (Transaction, error) {
+// Exec executes a query without returning any rows
+func (dm *databaseManager) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return dm.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext executes a query without returning any rows
+func (dm *databaseManager) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
 	dm.mutex.RLock()
 	defer dm.mutex.RUnlock()
 
 	if dm.db == nil {
 		return nil, fmt.Errorf("database connection not established")
 	}
+	return execWithTimeout(ctx, dm.db, dm.config.QueryTimeout, query, args...)
+}
 
-	tx, err := dm.db.Begin()
-	if err != nil {
-		return nil, err
+// Prepare creates a prepared statement
+func (dm *databaseManager) Prepare(query string) (*sql.Stmt, error) {
+	return dm.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext creates a prepared statement. The context only bounds the
+// preparation, not later executions of the statement.
+func (dm *databaseManager) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+
+	if dm.db == nil {
+		return nil, fmt.Errorf("database connection not established")
 	}
+	return prepareWithTimeout(ctx, dm.db, dm.config.QueryTimeout, query)
+}
 
-	return &transaction{tx: tx}, nil
+// Begin starts a transaction
+func (dm *databaseManager) Begin() (Transaction, error) {
+	return dm.BeginTxContext(context.Background(), nil)
 }
 
 // BeginTx starts a transaction with options
 func (dm *databaseManager) BeginTx(opts *sql.TxOptions) (Transaction, error) {
+	return dm.BeginTxContext(context.Background(), opts)
+}
+
+// BeginTxContext starts a transaction bound to ctx. QueryTimeout applies to each
+// statement in the transaction, not to the transaction as a whole.
+func (dm *databaseManager) BeginTxContext(ctx context.Context, opts *sql.TxOptions) (Transaction, error) {
 	dm.mutex.RLock()
 	defer dm.mutex.RUnlock()
 
 	if dm.db == nil {
 		return nil, fmt.Errorf("database connection not established")
 	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
-	tx, err := dm.db.BeginTx(nil, opts)
+	tx, err := dm.db.BeginTx(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, statementError(ctx, "begin", 0, err)
 	}
 
-	return &transaction{tx: tx}, nil
+	return &transaction{tx: tx, ctx: ctx, timeout: dm.config.QueryTimeout}, nil
 }
 
 // Transaction implementation
 func (t *transaction) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	return t.tx.Query(query, args...)
+	return sqlRows(t.QueryContext(t.ctx, query, args...))
 }
 
 func (t *transaction) QueryRow(query string, args ...interface{}) *sql.Row {
-	return t.tx.QueryRow(query, args...)
+	return t.QueryRowContext(t.ctx, query, args...).sqlRow()
 }
 
 func (t *transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
-	return t.tx.Exec(query, args...)
+	return t.ExecContext(t.ctx, query, args...)
 }
 
 func (t *transaction) Prepare(query string) (*sql.Stmt, error) {
-	return t.tx.Prepare(query)
+	return t.PrepareContext(t.ctx, query)
+}
+
+func (t *transaction) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return queryWithTimeout(ctx, t.tx, t.timeout, query, args...)
+}
+
+func (t *transaction) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	return queryRowWithTimeout(ctx, t.tx, t.timeout, query, args...)
+}
+
+func (t *transaction) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return execWithTimeout(ctx, t.tx, t.timeout, query, args...)
+}
+
+func (t *transaction) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return prepareWithTimeout(ctx, t.tx, t.timeout, query)
 }
 
 func (t *transaction) Commit() error {
-	return t.tx.Commit()
+	return statementError(t.ctx, "commit", 0, t.tx.Commit())
 }
 
 func (t *transaction) Rollback() error {
@@ -396,7 +536,7 @@ func (dm *databaseManager) LoadAccessToken(tokenValue string) (*AccessToken, err
 		return nil, fmt.Errorf("failed to load load_token query: %w", err)
 	}
 
-	row := dm.QueryRow(query, tokenValue)
+	row := dm.primaryQueryRow(query, tokenValue)
 
 	token := &AccessToken{}
 	var scopesJSON string
@@ -425,7 +565,7 @@ func (dm *databaseManager) ValidateAccessToken(tokenValue string) (*AccessToken,
 		return nil, fmt.Errorf("failed to load validate_token query: %w", err)
 	}
 
-	row := dm.QueryRow(query, tokenValue, time.Now())
+	row := dm.primaryQueryRow(query, tokenValue, time.Now())
 
 	token := &AccessToken{}
 	var scopesJSON string
@@ -505,7 +645,7 @@ func (dm *databaseManager) LoadTenant(tenantID string) (*Tenant, error) {
 		return nil, fmt.Errorf("failed to load load_tenant query: %w", err)
 	}
 
-	row := dm.QueryRow(query, tenantID)
+	row := dm.primaryQueryRow(query, tenantID)
 
 	tenant := &Tenant{}
 	var hostsJSON, configJSON string
@@ -540,7 +680,7 @@ func (dm *databaseManager) LoadTenantByHost(hostname string) (*Tenant, error) {
 	}
 
 	// Pass hostname directly - json_each.value returns raw string values
-	row := dm.QueryRow(query, hostname)
+	row := dm.primaryQueryRow(query, hostname)
 
 	tenant := &Tenant{}
 	var hostsJSON, configJSON string
@@ -589,7 +729,7 @@ func (dm *databaseManager) GetWorkloadMetrics(tenantID string, from, to time.Tim
 		return nil, fmt.Errorf("failed to load get_workload_metrics query: %w", err)
 	}
 
-	rows, err := dm.Query(query, tenantID, from, to)
+	rows, err := dm.primaryQuery(query, tenantID, from, to)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query workload metrics: %w", err)
 	}
@@ -619,7 +759,7 @@ func (dm *databaseManager) CheckRateLimit(key string, limit int, window time.Dur
 		return false, fmt.Errorf("failed to load check_rate_limit query: %w", err)
 	}
 
-	row := dm.QueryRow(query, key, windowStart)
+	row := dm.primaryQueryRow(query, key, windowStart)
 
 	var count int
 	if err := row.Scan(&count); err != nil {
@@ -654,12 +794,23 @@ func (dm *databaseManager) IncrementRateLimit(key string, window time.Duration)
 
 // Migration support methods
 
-// Migrate runs database migrations
+// Migrate creates the framework tables and applies pending versioned migrations
 func (dm *databaseManager) Migrate() error {
-	return dm.CreateTables()
+	if err := dm.CreateTables(); err != nil {
+		return err
+	}
+
+	migrator, err := NewMigrator(dm, MigratorConfig{})
+	if err != nil {
+		return err
+	}
+	_, err = migrator.Up(MigrateOptions{})
+	return err
 }
 
-// CreateTables creates all required database tables
+// CreateTables creates the framework tables that predate versioned
+// migrations. Tables added since, such as outbox_messages, are created by the
+// framework migrations that Migrate applies.
 func (dm *databaseManager) CreateTables() error {
 	// List of all table creation queries
 	tableQueries := []string{
@@ -707,9 +858,10 @@ func (dm *databaseManager) CreateTables() error {
 			return fmt.Errorf("failed to load query %s: %w", queryName, err)
 		}
 
-		if _, err := dm.Exec(indexSQL); err != nil {
-			// Log but don't fail if index already exists
-			// Some databases may error on duplicate index creation
+		// MySQL has no CREATE INDEX IF NOT EXISTS, so an index that already
+		// exists is not an error
+		if _, err := dm.Exec(indexSQL); err != nil && !isAlreadyExistsError(err) {
+			return fmt.Errorf("failed to create index %s: %w", queryName, err)
 		}
 	}
 
@@ -720,7 +872,8 @@ func (dm *databaseManager) CreateTables() error {
 func (dm *databaseManager) DropTables() error {
 	tables := []string{
 		"plugin_metrics", "plugin_storage", "plugin_events", "plugin_hooks", "plugins",
-		"workload_metrics", "rate_limits", "access_tokens", "sessions", "tenants",
+		"workload_metrics", "rate_limit_state", "rate_limits", "access_tokens", "sessions", "tenant_quota_usage", "tenants",
+		"outbox_messages", "background_jobs", "scheduler_leases", "scheduled_task_runs", "cache_invalidations", "schema_migrations", "schema_migrations_lock",
 	}
 
 	for _, table := range tables {
@@ -4,6 +4,7 @@
 package pkg
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"testing"
@@ -94,6 +95,37 @@ func (m *mockDatabaseManager) BeginTx(opts *sql.TxOptions) (Transaction, error)
 	return m.Begin()
 }
 
+func (m *mockDatabaseManager) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	if !m.connected {
+		return nil, fmt.Errorf("not connected")
+	}
+	return nil, fmt.Errorf("mock query not implemented")
+}
+
+func (m *mockDatabaseManager) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	return newErrorRow(fmt.Errorf("mock query not implemented"))
+}
+
+func (m *mockDatabaseManager) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return m.Exec(query, args...)
+}
+
+func (m *mockDatabaseManager) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return m.Prepare(query)
+}
+
+func (m *mockDatabaseManager) BeginTxContext(ctx context.Context, opts *sql.TxOptions) (Transaction, error) {
+	return m.BeginTx(opts)
+}
+
+func (m *mockDatabaseManager) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Transaction) error) error {
+	tx, err := m.BeginTx(opts)
+	if err != nil {
+		return err
+	}
+	return fn(tx)
+}
+
 // Session operations
 func (m *mockDatabaseManager) SaveSession(session *Session) error {
 	if !m.connected {
@@ -334,6 +366,22 @@ func (t *mockTransaction) Prepare(query string) (*sql.Stmt, error) {
 	return nil, fmt.Errorf("mock transaction prepare not implemented")
 }
 
+func (t *mockTransaction) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return nil, fmt.Errorf("mock transaction query not implemented")
+}
+
+func (t *mockTransaction) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	return newErrorRow(fmt.Errorf("mock transaction query not implemented"))
+}
+
+func (t *mockTransaction) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return t.Exec(query, args...)
+}
+
+func (t *mockTransaction) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return t.Prepare(query)
+}
+
 func (t *mockTransaction) Commit() error {
 	return nil
 }
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"testing"
@@ -293,6 +294,34 @@ func (m *MockDatabaseManager) BeginTx(opts *sql.TxOptions) (Transaction, error)
 	return nil, fmt.Errorf("not implemented")
 }
 
+func (m *MockDatabaseManager) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (m *MockDatabaseManager) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	return nil
+}
+
+func (m *MockDatabaseManager) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return m.Exec(query, args...)
+}
+
+func (m *MockDatabaseManager) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return m.Prepare(query)
+}
+
+func (m *MockDatabaseManager) BeginTxContext(ctx context.Context, opts *sql.TxOptions) (Transaction, error) {
+	return m.BeginTx(opts)
+}
+
+func (m *MockDatabaseManager) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Transaction) error) error {
+	tx, err := m.BeginTx(opts)
+	if err != nil {
+		return err
+	}
+	return fn(tx)
+}
+
 func (m *MockDatabaseManager) Migrate() error {
 	return nil
 }
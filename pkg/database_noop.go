@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"database/sql"
 	"time"
 )
@@ -43,19 +44,35 @@ func (n *noopDatabaseManager) Query(query string, args ...interface{}) (*sql.Row
 }
 
 func (n *noopDatabaseManager) QueryRow(query string, args ...interface{}) *sql.Row {
-	return &sql.Row{}
+	return errorSQLRow(ErrNoDatabaseConfigured)
 }
 
 func (n *noopDatabaseManager) Exec(query string, args ...interface{}) (sql.Result, error) {
 	return nil, ErrNoDatabaseConfigured
 }
 
+func (n *noopDatabaseManager) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return nil, ErrNoDatabaseConfigured
+}
+
+func (n *noopDatabaseManager) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	return newErrorRow(ErrNoDatabaseConfigured)
+}
+
+func (n *noopDatabaseManager) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return nil, ErrNoDatabaseConfigured
+}
+
 // Prepared statements
 
 func (n *noopDatabaseManager) Prepare(query string) (*sql.Stmt, error) {
 	return nil, ErrNoDatabaseConfigured
 }
 
+func (n *noopDatabaseManager) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, ErrNoDatabaseConfigured
+}
+
 // Transaction support
 
 func (n *noopDatabaseManager) Begin() (Transaction, error) {
@@ -66,6 +83,14 @@ func (n *noopDatabaseManager) BeginTx(opts *sql.TxOptions) (Transaction, error)
 	return nil, ErrNoDatabaseConfigured
 }
 
+func (n *noopDatabaseManager) BeginTxContext(ctx context.Context, opts *sql.TxOptions) (Transaction, error) {
+	return nil, ErrNoDatabaseConfigured
+}
+
+func (n *noopDatabaseManager) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Transaction) error) error {
+	return ErrNoDatabaseConfigured
+}
+
 // Session operations
 
 func (n *noopDatabaseManager) SaveSession(session *Session) error {
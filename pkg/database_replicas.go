@@ -0,0 +1,215 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replicaPingTimeout bounds a single replica health check
+const replicaPingTimeout = 2 * time.Second
+
+// replicaPool is a connection pool to a read replica with its health state
+type replicaPool struct {
+	name string
+	db   *sql.DB
+
+	healthy   atomic.Bool
+	lag       atomic.Int64 // time.Duration
+	reads     atomic.Int64
+	lastError atomic.Value // string
+}
+
+// replicaSet routes reads across healthy replicas and runs health checks
+type replicaSet struct {
+	pools  []*replicaPool
+	next   atomic.Uint64
+	maxLag time.Duration
+	lagSQL string
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// connectReplicas opens a pool per configured replica. Replicas that cannot be
+// reached start unhealthy and are retried by the health check.
+func (dm *databaseManager) connectReplicas(config DatabaseConfig, driverName string) (*replicaSet, error) {
+	set := &replicaSet{
+		maxLag: config.MaxReplicaLag,
+		stop:   make(chan struct{}),
+	}
+
+	for i, replica := range config.Replicas {
+		dsn := replica.DSN
+		if dsn == "" {
+			var err error
+			if dsn, err = dm.buildDSN(replica.apply(config)); err != nil {
+				set.close()
+				return nil, fmt.Errorf("failed to build replica DSN: %w", err)
+			}
+		}
+
+		db, err := sql.Open(driverName, dsn)
+		if err != nil {
+			set.close()
+			return nil, fmt.Errorf("failed to open replica connection: %w", err)
+		}
+		if config.MaxOpenConns > 0 {
+			db.SetMaxOpenConns(config.MaxOpenConns)
+		}
+		if config.MaxIdleConns > 0 {
+			db.SetMaxIdleConns(config.MaxIdleConns)
+		}
+		if config.ConnMaxLifetime > 0 {
+			db.SetConnMaxLifetime(config.ConnMaxLifetime)
+		}
+
+		name := replica.Name
+		if name == "" {
+			name = fmt.Sprintf("replica-%d", i+1)
+		}
+		pool := &replicaPool{name: name, db: db}
+		pool.lastError.Store("")
+		set.pools = append(set.pools, pool)
+	}
+
+	return set, nil
+}
+
+// start runs the first health check synchronously and then every interval
+func (rs *replicaSet) start(interval time.Duration) {
+	rs.checkAll()
+	for _, pool := range rs.pools {
+		if !pool.healthy.Load() {
+			fmt.Printf("WARN: Database replica %s is unavailable, reads fall back to the primary: %s\n", pool.name, pool.lastError.Load())
+		}
+	}
+
+	rs.done.Add(1)
+	go func() {
+		defer rs.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rs.checkAll()
+			case <-rs.stop:
+				return
+			}
+		}
+	}()
+}
+
+// checkAll pings every replica and, if a lag limit is set, measures its lag
+func (rs *replicaSet) checkAll() {
+	for _, pool := range rs.pools {
+		rs.check(pool)
+	}
+}
+
+// check updates the health of a single replica
+func (rs *replicaSet) check(pool *replicaPool) {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaPingTimeout)
+	defer cancel()
+
+	if err := pool.db.PingContext(ctx); err != nil {
+		pool.markUnhealthy(err.Error())
+		return
+	}
+
+	if rs.maxLag > 0 && rs.lagSQL != "" {
+		var seconds float64
+		if err := pool.db.QueryRowContext(ctx, rs.lagSQL).Scan(&seconds); err != nil {
+			pool.markUnhealthy("lag check failed: " + err.Error())
+			return
+		}
+
+		lag := time.Duration(seconds * float64(time.Second))
+		pool.lag.Store(int64(lag))
+		if lag > rs.maxLag {
+			pool.markUnhealthy(fmt.Sprintf("replication lag %v exceeds %v", lag, rs.maxLag))
+			return
+		}
+	}
+
+	pool.healthy.Store(true)
+	pool.lastError.Store("")
+}
+
+// markUnhealthy takes a replica out of rotation
+func (p *replicaPool) markUnhealthy(reason string) {
+	p.healthy.Store(false)
+	p.lastError.Store(reason)
+}
+
+// pick returns the next healthy replica in round-robin order, or nil
+func (rs *replicaSet) pick() *replicaPool {
+	n := uint64(len(rs.pools))
+	if n == 0 {
+		return nil
+	}
+
+	start := rs.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		pool := rs.pools[(start+i)%n]
+		if pool.healthy.Load() {
+			pool.reads.Add(1)
+			return pool
+		}
+	}
+	return nil
+}
+
+// stats returns per-replica statistics
+func (rs *replicaSet) stats() []ReplicaStats {
+	stats := make([]ReplicaStats, len(rs.pools))
+	for i, pool := range rs.pools {
+		lastError, _ := pool.lastError.Load().(string)
+		stats[i] = ReplicaStats{
+			Name:      pool.name,
+			Healthy:   pool.healthy.Load(),
+			Lag:       time.Duration(pool.lag.Load()),
+			Reads:     pool.reads.Load(),
+			LastError: lastError,
+			Pool:      newDatabaseStats(pool.db.Stats()),
+		}
+	}
+	return stats
+}
+
+// close stops health checks and closes all replica pools
+func (rs *replicaSet) close() error {
+	select {
+	case <-rs.stop:
+	default:
+		close(rs.stop)
+	}
+	rs.done.Wait()
+
+	var firstErr error
+	for _, pool := range rs.pools {
+		if err := pool.db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// readDB returns the pool a read should use: a healthy replica, or the primary
+// when there are no replicas or none is healthy. Callers hold dm.mutex.
+func (dm *databaseManager) readDB() *sql.DB {
+	if dm.replicas != nil {
+		if pool := dm.replicas.pick(); pool != nil {
+			return pool.db
+		}
+	}
+	dm.primaryReads.Add(1)
+	return dm.db
+}
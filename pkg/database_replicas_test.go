@@ -0,0 +1,132 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// connectReplicaTestDB connects to a SQLite primary with one replica file per
+// name. Each file holds a marker row so tests can tell which pool served a read.
+func connectReplicaTestDB(t *testing.T, replicas ...ReplicaConfig) DatabaseManager {
+	t.Helper()
+	dir := t.TempDir()
+
+	for i := range replicas {
+		if replicas[i].Database == "" {
+			replicas[i].Database = filepath.Join(dir, replicas[i].Name+".db")
+			writeReplicaMarker(t, replicas[i].Database, replicas[i].Name)
+		}
+	}
+
+	primaryPath := filepath.Join(dir, "primary.db")
+	writeReplicaMarker(t, primaryPath, "primary")
+
+	config := createTestDBConfig(primaryPath)
+	config.Replicas = replicas
+	config.ApplyDefaults()
+
+	dm := NewDatabaseManager()
+	if err := dm.Connect(config); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { dm.Close() })
+	return dm
+}
+
+// writeReplicaMarker creates a SQLite file whose marker table names its source
+func writeReplicaMarker(t *testing.T, path, name string) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE marker (source TEXT)"); err != nil {
+		t.Fatalf("Failed to create marker table: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO marker (source) VALUES (?)", name); err != nil {
+		t.Fatalf("Failed to write marker: %v", err)
+	}
+}
+
+// readSource returns which database served a read through db
+func readSource(t *testing.T, db DatabaseManager) string {
+	t.Helper()
+	var source string
+	if err := db.QueryRow("SELECT source FROM marker").Scan(&source); err != nil {
+		t.Fatalf("Failed to read marker: %v", err)
+	}
+	return source
+}
+
+func TestIntegration_ReplicaReadRouting(t *testing.T) {
+	dm := connectReplicaTestDB(t, ReplicaConfig{Name: "r1"}, ReplicaConfig{Name: "r2"})
+
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		seen[readSource(t, dm)]++
+	}
+	if seen["r1"] != 2 || seen["r2"] != 2 {
+		t.Errorf("Expected reads to alternate between replicas, got %v", seen)
+	}
+
+	// Writes always go to the primary
+	if _, err := dm.Exec("UPDATE marker SET source = 'primary-updated'"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if source := readSource(t, PrimaryDatabase(dm)); source != "primary-updated" {
+		t.Errorf("Expected PrimaryDatabase to read from the primary, got %s", source)
+	}
+
+	stats := dm.Stats()
+	if len(stats.Replicas) != 2 || !stats.Replicas[0].Healthy || stats.Replicas[0].Reads != 2 {
+		t.Errorf("Unexpected replica stats: %+v", stats.Replicas)
+	}
+	if stats.PrimaryReads != 1 {
+		t.Errorf("Expected 1 primary read, got %d", stats.PrimaryReads)
+	}
+}
+
+func TestIntegration_ReplicaReadYourWrites(t *testing.T) {
+	dm := connectReplicaTestDB(t, ReplicaConfig{Name: "r1"})
+
+	scoped, ok := dm.(requestScopedDatabase)
+	if !ok {
+		t.Fatal("Expected database manager to support request scoping")
+	}
+	request := scoped.forRequest(context.Background())
+
+	if source := readSource(t, request); source != "r1" {
+		t.Errorf("Expected read before writing to use the replica, got %s", source)
+	}
+	if _, err := request.Exec("UPDATE marker SET source = 'written'"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if source := readSource(t, request); source != "written" {
+		t.Errorf("Expected read after writing to use the primary, got %s", source)
+	}
+
+	// Other requests are not pinned
+	if source := readSource(t, scoped.forRequest(context.Background())); source != "r1" {
+		t.Errorf("Expected a new request to read from the replica, got %s", source)
+	}
+}
+
+func TestIntegration_UnhealthyReplicaFallsBackToPrimary(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing", "replica.db")
+	dm := connectReplicaTestDB(t, ReplicaConfig{Name: "down", Database: missing})
+
+	if source := readSource(t, dm); source != "primary" {
+		t.Errorf("Expected reads to fall back to the primary, got %s", source)
+	}
+
+	stats := dm.Stats()
+	if len(stats.Replicas) != 1 || stats.Replicas[0].Healthy || stats.Replicas[0].LastError == "" {
+		t.Errorf("Expected replica to be reported unhealthy: %+v", stats.Replicas)
+	}
+}
@@ -0,0 +1,127 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+)
+
+// Rows is the result of a query. It is read like *sql.Rows; closing it also
+// releases the statement's QueryTimeout, so rows should always be closed.
+type Rows struct {
+	*sql.Rows
+	release func()
+	once    sync.Once
+}
+
+// newRows wraps rows, calling release once they are closed
+func newRows(rows *sql.Rows, release func()) *Rows {
+	return &Rows{Rows: rows, release: release}
+}
+
+// Close closes the rows and releases the statement's timeout
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.once.Do(func() {
+		if r.release != nil {
+			r.release()
+		}
+	})
+	return err
+}
+
+// Row is the result of a single-row query. Scanning it releases the
+// statement's QueryTimeout.
+type Row struct {
+	row     *sql.Row
+	err     error
+	release func()
+}
+
+// newRow wraps row, calling release once it is scanned
+func newRow(row *sql.Row, release func()) *Row {
+	return &Row{row: row, release: release}
+}
+
+// newErrorRow returns a row whose Scan and Err report err without running a
+// statement
+func newErrorRow(err error) *Row {
+	return &Row{err: err}
+}
+
+// Scan copies the columns of the row into dest. If the query matched no rows,
+// Scan returns sql.ErrNoRows.
+func (r *Row) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.release != nil {
+		defer r.release()
+	}
+	return r.row.Scan(dest...)
+}
+
+// Err returns the error, if any, that was encountered while running the query
+func (r *Row) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.row.Err()
+}
+
+// sqlRows returns the *sql.Rows behind rows for the Query methods without a
+// context. Closing them does not release a statement timeout early; it lapses
+// after QueryTimeout instead.
+func sqlRows(rows *Rows, err error) (*sql.Rows, error) {
+	if err != nil || rows == nil {
+		return nil, err
+	}
+	return rows.Rows, nil
+}
+
+// sqlRow returns the *sql.Row behind r for the QueryRow methods without a
+// context. Like sqlRows, it leaves a statement timeout to lapse.
+func (r *Row) sqlRow() *sql.Row {
+	if r == nil {
+		return nil
+	}
+	if r.err != nil {
+		return errorSQLRow(r.err)
+	}
+	return r.row
+}
+
+// errorSQLRow returns a *sql.Row whose Scan and Err report err. database/sql
+// only creates rows with an error itself, so the row comes from errorRowDB,
+// whose connector fails with the error carried by the context of the query.
+func errorSQLRow(err error) *sql.Row {
+	return errorRowDB().QueryRowContext(context.WithValue(context.Background(), errorRowKey{}, err), "")
+}
+
+// errorRowDB is the database of errorSQLRow. It is opened once and never
+// holds a connection.
+var errorRowDB = sync.OnceValue(func() *sql.DB {
+	return sql.OpenDB(errorConnector{})
+})
+
+// errorRowKey carries the error of errorSQLRow to errorConnector
+type errorRowKey struct{}
+
+// errorConnector is a driver.Connector that fails every connection attempt
+// with the error in the context
+type errorConnector struct{}
+
+func (errorConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err, ok := ctx.Value(errorRowKey{}).(error); ok {
+		return nil, err
+	}
+	return nil, driver.ErrBadConn
+}
+
+func (errorConnector) Driver() driver.Driver { return errorDriver{} }
+
+// errorDriver is the driver of errorConnector
+type errorDriver struct{}
+
+func (errorDriver) Open(string) (driver.Conn, error) { return nil, driver.ErrBadConn }
@@ -0,0 +1,76 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIntegration_RowsReleaseStatementTimeout(t *testing.T) {
+	dm := connectContextTestDB(t, time.Hour)
+	db := dm.(*databaseManager).db
+	if _, err := dm.Exec("INSERT INTO items (id) VALUES (1), (2)"); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	released := 0
+	raw, err := db.Query("SELECT id FROM items")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	rows := newRows(raw, func() { released++ })
+	for rows.Next() {
+	}
+	if released != 0 {
+		t.Error("Expected the timeout to be kept until the rows are closed")
+	}
+	rows.Close()
+	rows.Close()
+	if released != 1 {
+		t.Errorf("Expected closing the rows to release the timeout once, got %d", released)
+	}
+
+	released = 0
+	row := newRow(db.QueryRow("SELECT id FROM items WHERE id = 2"), func() { released++ })
+	var id int
+	if err := row.Scan(&id); err != nil || id != 2 {
+		t.Fatalf("Scan failed: %d, %v", id, err)
+	}
+	if released != 1 {
+		t.Errorf("Expected scanning the row to release the timeout, got %d", released)
+	}
+
+	// Rows from the database manager still read normally
+	managed := dm.QueryRow("SELECT COUNT(*) FROM items")
+	if err := managed.Scan(&id); err != nil || id != 2 {
+		t.Errorf("Expected 2 items, got %d, %v", id, err)
+	}
+}
+
+func TestNewErrorRow(t *testing.T) {
+	want := errors.New("rejected")
+	row := newErrorRow(want)
+
+	var id int
+	if err := row.Scan(&id); !errors.Is(err, want) {
+		t.Errorf("Expected Scan to report the row's error, got %v", err)
+	}
+	if err := row.Err(); !errors.Is(err, want) {
+		t.Errorf("Expected Err to report the row's error, got %v", err)
+	}
+
+	if err := row.sqlRow().Scan(&id); !errors.Is(err, want) {
+		t.Errorf("Expected the *sql.Row to report the row's error, got %v", err)
+	}
+	other := errors.New("other")
+	if err := newErrorRow(other).sqlRow().Scan(&id); !errors.Is(err, other) {
+		t.Errorf("Expected each *sql.Row to report its own error, got %v", err)
+	}
+
+	if err := NewNoopDatabaseManager().QueryRow("SELECT 1").Scan(&id); !errors.Is(err, ErrNoDatabaseConfigured) {
+		t.Errorf("Expected the no-op database to report it is not configured, got %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// errTxManaged is returned when code inside WithTx commits or rolls back itself
+var errTxManaged = errors.New("transaction is managed by WithTx and cannot be committed or rolled back directly")
+
+// managedTx is the Transaction passed to WithTx callbacks. Commit and Rollback
+// belong to WithTx; nested WithTx calls create savepoints on it.
+type managedTx struct {
+	Transaction
+	dm    *databaseManager
+	mu    sync.Mutex
+	depth int
+}
+
+// Commit is reserved for WithTx
+func (m *managedTx) Commit() error {
+	return errTxManaged
+}
+
+// Rollback is reserved for WithTx
+func (m *managedTx) Rollback() error {
+	return errTxManaged
+}
+
+// WithTx runs fn in a transaction on the primary. The transaction is committed
+// when fn returns nil and rolled back when it returns an error or panics; a
+// panic continues to the caller after the rollback.
+// Serialization failures and deadlocks retry the whole transaction with
+// exponential backoff, so fn must be safe to run more than once.
+func (dm *databaseManager) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Transaction) error) error {
+	return dm.runTx(ctx, opts, dm.BeginTxContext, func(tx *managedTx) error { return fn(tx) })
+}
+
+// txBeginner starts the transactions of runTx
+type txBeginner func(ctx context.Context, opts *sql.TxOptions) (Transaction, error)
+
+// runTx runs fn in a transaction started by begin, retrying on serialization failures
+func (dm *databaseManager) runTx(ctx context.Context, opts *sql.TxOptions, begin txBeginner, fn func(tx *managedTx) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	dm.mutex.RLock()
+	retries, backoff := dm.config.TxMaxRetries, dm.config.TxRetryBackoff
+	dm.mutex.RUnlock()
+
+	for attempt := 0; ; attempt++ {
+		err := dm.runTxOnce(ctx, opts, begin, fn)
+		if err == nil || attempt >= retries || !IsRetryableTxError(err) {
+			return err
+		}
+
+		if err := sleepContext(ctx, txRetryDelay(backoff, attempt)); err != nil {
+			return statementError(ctx, "begin", 0, err)
+		}
+	}
+}
+
+// runTxOnce runs a single attempt of a transaction
+func (dm *databaseManager) runTxOnce(ctx context.Context, opts *sql.TxOptions, begin txBeginner, fn func(tx *managedTx) error) error {
+	tx, err := begin(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	// A panic in fn rolls the transaction back and continues to the caller
+	returned := false
+	defer func() {
+		if !returned {
+			tx.Rollback()
+		}
+	}()
+
+	err = fn(&managedTx{Transaction: tx, dm: dm})
+	returned = true
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// savepoint runs fn inside a savepoint of the transaction. Only the savepoint
+// is rolled back when fn fails; retries are left to the outermost WithTx.
+func (m *managedTx) savepoint(ctx context.Context, fn func(tx Transaction) error) error {
+	m.mu.Lock()
+	m.depth++
+	name := fmt.Sprintf("rockstar_sp_%d", m.depth)
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.depth--
+		m.mu.Unlock()
+	}()
+
+	if err := m.execSavepoint(ctx, "create_savepoint", name); err != nil {
+		return err
+	}
+
+	// A panic in fn rolls the savepoint back and continues to the caller
+	returned := false
+	defer func() {
+		if !returned {
+			m.execSavepoint(ctx, "rollback_to_savepoint", name)
+		}
+	}()
+
+	err := fn(m)
+	returned = true
+	if err != nil {
+		// A failed rollback leaves the whole transaction to be rolled back
+		m.execSavepoint(ctx, "rollback_to_savepoint", name)
+		return err
+	}
+	return m.execSavepoint(ctx, "release_savepoint", name)
+}
+
+// execSavepoint runs a savepoint statement from the SQL loader
+func (m *managedTx) execSavepoint(ctx context.Context, queryName, name string) error {
+	query, err := m.dm.GetQuery(queryName)
+	if err != nil {
+		return err
+	}
+	if _, err := m.ExecContext(ctx, fmt.Sprintf(query, name)); err != nil {
+		return fmt.Errorf("failed to %s %s: %w", queryName, name, err)
+	}
+	return nil
+}
+
+// IsRetryableTxError reports whether err is a serialization failure or deadlock
+// after which the whole transaction can be retried: PostgreSQL 40001/40P01,
+// MySQL 1213, MSSQL 1205 or SQLite BUSY/LOCKED
+func IsRetryableTxError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1213
+	}
+
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		return mssqlErr.Number == 1205
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// isDuplicateKeyError reports whether err is a primary key or unique
+// constraint violation: PostgreSQL 23505, MySQL 1062, MSSQL 2627/2601 or
+// SQLite CONSTRAINT_PRIMARYKEY/CONSTRAINT_UNIQUE
+func isDuplicateKeyError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		return mssqlErr.Number == 2627 || mssqlErr.Number == 2601
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey || sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	return false
+}
+
+// isAlreadyExistsError reports whether err is the driver's error for creating
+// a table or index that already exists
+func isAlreadyExistsError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "42P07"
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1050 || mysqlErr.Number == 1061
+	}
+
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		return mssqlErr.Number == 2714 || mssqlErr.Number == 1913
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrError && strings.Contains(sqliteErr.Error(), "already exists")
+	}
+	return false
+}
+
+// isMissingTableError reports whether err is the driver's error for a table
+// that does not exist
+func isMissingTableError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "42P01"
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1146
+	}
+
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		return mssqlErr.Number == 208
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrError && strings.Contains(sqliteErr.Error(), "no such table")
+	}
+	return false
+}
+
+// txRetryDelay returns the exponential backoff with jitter for a retry attempt
+func txRetryDelay(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	if attempt > 10 {
+		attempt = 10
+	}
+	delay := base << uint(attempt)
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
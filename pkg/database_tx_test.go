@@ -0,0 +1,323 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// connectTxTestDB connects to a fresh SQLite database with fast transaction retries
+func connectTxTestDB(t *testing.T) DatabaseManager {
+	t.Helper()
+	config := createTestDBConfig(filepath.Join(t.TempDir(), "tx.db"))
+	config.TxMaxRetries = 3
+	config.TxRetryBackoff = time.Millisecond
+
+	dm := NewDatabaseManager()
+	if err := dm.Connect(config); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { dm.Close() })
+
+	if _, err := dm.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	return dm
+}
+
+// itemIDs returns the ids stored in the items table
+func itemIDs(t *testing.T, db DatabaseManager) []int {
+	t.Helper()
+	rows, err := db.Query("SELECT id FROM items ORDER BY id")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestIntegration_WithTxCommitRollbackPanic(t *testing.T) {
+	dm := connectTxTestDB(t)
+	ctx := context.Background()
+
+	if err := dm.WithTx(ctx, nil, func(tx Transaction) error {
+		_, err := tx.Exec("INSERT INTO items (id) VALUES (1)")
+		return err
+	}); err != nil {
+		t.Fatalf("WithTx failed: %v", err)
+	}
+
+	failure := errors.New("boom")
+	if err := dm.WithTx(ctx, nil, func(tx Transaction) error {
+		tx.Exec("INSERT INTO items (id) VALUES (2)")
+		return failure
+	}); err != failure {
+		t.Errorf("Expected callback error, got %v", err)
+	}
+
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != "unexpected" {
+				t.Errorf("Expected the panic to continue after the rollback, got %v", recovered)
+			}
+		}()
+		dm.WithTx(ctx, nil, func(tx Transaction) error {
+			tx.Exec("INSERT INTO items (id) VALUES (3)")
+			panic("unexpected")
+		})
+	}()
+
+	if ids := itemIDs(t, dm); fmt.Sprint(ids) != "[1]" {
+		t.Errorf("Expected only the committed row, got %v", ids)
+	}
+
+	// The transaction is owned by WithTx
+	dm.WithTx(ctx, nil, func(tx Transaction) error {
+		if tx.Commit() != errTxManaged || tx.Rollback() != errTxManaged {
+			t.Error("Expected Commit and Rollback inside WithTx to be rejected")
+		}
+		return nil
+	})
+}
+
+func TestIntegration_WithTxRetriesSerializationFailures(t *testing.T) {
+	dm := connectTxTestDB(t)
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+
+	attempts := 0
+	err := dm.WithTx(context.Background(), nil, func(tx Transaction) error {
+		attempts++
+		if _, err := tx.Exec("INSERT INTO items (id) VALUES (1)"); err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return fmt.Errorf("insert: %w", busy)
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("Expected success on the third attempt, got %d attempts, %v", attempts, err)
+	}
+	if ids := itemIDs(t, dm); fmt.Sprint(ids) != "[1]" {
+		t.Errorf("Expected failed attempts to be rolled back, got %v", ids)
+	}
+
+	attempts = 0
+	err = dm.WithTx(context.Background(), nil, func(tx Transaction) error {
+		attempts++
+		return busy
+	})
+	if !errors.Is(err, busy) || attempts != 4 {
+		t.Errorf("Expected 1 attempt plus 3 retries, got %d attempts, %v", attempts, err)
+	}
+
+	attempts = 0
+	dm.WithTx(context.Background(), nil, func(tx Transaction) error {
+		attempts++
+		return errors.New("not retryable")
+	})
+	if attempts != 1 {
+		t.Errorf("Expected other errors not to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestIntegration_WithTxNestedSavepoints(t *testing.T) {
+	dm := connectTxTestDB(t)
+	ctx := &contextImpl{db: dm.(requestScopedDatabase).forRequest(context.Background())}
+
+	if _, ok := TxFromContext(ctx); ok {
+		t.Fatal("Expected no transaction outside WithTx")
+	}
+
+	err := ctx.DB().WithTx(ctx.Context(), nil, func(tx Transaction) error {
+		if joined, ok := TxFromContext(ctx); !ok || joined != tx {
+			t.Error("Expected the transaction to be exposed through the context")
+		}
+
+		// Statements through the request database join the transaction
+		if _, err := ctx.DB().Exec("INSERT INTO items (id) VALUES (1)"); err != nil {
+			return err
+		}
+
+		// A failing nested call only rolls back its savepoint
+		nestedErr := ctx.DB().WithTx(ctx.Context(), nil, func(tx Transaction) error {
+			tx.Exec("INSERT INTO items (id) VALUES (2)")
+			return errors.New("nested failure")
+		})
+		if nestedErr == nil {
+			t.Error("Expected nested error to be returned")
+		}
+
+		// A panicking nested call rolls back its savepoint before the panic continues
+		func() {
+			defer func() {
+				if recovered := recover(); recovered != "nested panic" {
+					t.Errorf("Expected the nested panic to continue, got %v", recovered)
+				}
+			}()
+			ctx.DB().WithTx(ctx.Context(), nil, func(tx Transaction) error {
+				tx.Exec("INSERT INTO items (id) VALUES (6)")
+				panic("nested panic")
+			})
+		}()
+
+		return ctx.DB().WithTx(ctx.Context(), nil, func(tx Transaction) error {
+			_, err := tx.Exec("INSERT INTO items (id) VALUES (3)")
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatalf("WithTx failed: %v", err)
+	}
+
+	if ids := itemIDs(t, dm); fmt.Sprint(ids) != "[1 3]" {
+		t.Errorf("Expected rows 1 and 3, got %v", ids)
+	}
+
+	// Rolling back the outer transaction discards joined statements and savepoints
+	ctx.DB().WithTx(ctx.Context(), nil, func(tx Transaction) error {
+		ctx.DB().Exec("INSERT INTO items (id) VALUES (4)")
+		ctx.DB().WithTx(ctx.Context(), nil, func(tx Transaction) error {
+			_, err := tx.Exec("INSERT INTO items (id) VALUES (5)")
+			return err
+		})
+		return errors.New("outer failure")
+	})
+	if ids := itemIDs(t, dm); fmt.Sprint(ids) != "[1 3]" {
+		t.Errorf("Expected outer rollback to discard rows 4 and 5, got %v", ids)
+	}
+}
+
+func TestIsRetryableTxError(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "40P01"}, true},
+		{&pq.Error{Code: "23505"}, false},
+		{&mysql.MySQLError{Number: 1213}, true},
+		{&mysql.MySQLError{Number: 1062}, false},
+		{mssql.Error{Number: 1205}, true},
+		{mssql.Error{Number: 2627}, false},
+		{sqlite3.Error{Code: sqlite3.ErrBusy}, true},
+		{sqlite3.Error{Code: sqlite3.ErrConstraint}, false},
+		{fmt.Errorf("wrapped: %w", &pq.Error{Code: "40001"}), true},
+		{errors.New("other"), false},
+	}
+
+	for _, tt := range tests {
+		if got := IsRetryableTxError(tt.err); got != tt.retryable {
+			t.Errorf("IsRetryableTxError(%v) = %v, want %v", tt.err, got, tt.retryable)
+		}
+	}
+}
+
+func TestIsDuplicateKeyError(t *testing.T) {
+	tests := []struct {
+		err       error
+		duplicate bool
+	}{
+		{&pq.Error{Code: "23505"}, true},
+		{&pq.Error{Code: "23503"}, false},
+		{&mysql.MySQLError{Number: 1062}, true},
+		{&mysql.MySQLError{Number: 1213}, false},
+		{mssql.Error{Number: 2627}, true},
+		{mssql.Error{Number: 2601}, true},
+		{mssql.Error{Number: 1205}, false},
+		{sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintPrimaryKey}, true},
+		{sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintNotNull}, false},
+		{sqlite3.Error{Code: sqlite3.ErrBusy}, false},
+		{fmt.Errorf("wrapped: %w", &pq.Error{Code: "23505"}), true},
+		{errors.New("other"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isDuplicateKeyError(tt.err); got != tt.duplicate {
+			t.Errorf("isDuplicateKeyError(%v) = %v, want %v", tt.err, got, tt.duplicate)
+		}
+	}
+}
+
+func TestIsAlreadyExistsError(t *testing.T) {
+	tests := []struct {
+		err    error
+		exists bool
+	}{
+		{&pq.Error{Code: "42P07"}, true},
+		{&pq.Error{Code: "42P01"}, false},
+		{&mysql.MySQLError{Number: 1061}, true},
+		{&mysql.MySQLError{Number: 1050}, true},
+		{&mysql.MySQLError{Number: 1146}, false},
+		{mssql.Error{Number: 1913}, true},
+		{mssql.Error{Number: 2714}, true},
+		{mssql.Error{Number: 208}, false},
+		{sqlite3.Error{Code: sqlite3.ErrBusy}, false},
+		{errors.New("index already exists"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isAlreadyExistsError(tt.err); got != tt.exists {
+			t.Errorf("isAlreadyExistsError(%v) = %v, want %v", tt.err, got, tt.exists)
+		}
+	}
+
+	// SQLite reports existing objects with a generic error and a message
+	dm := connectTxTestDB(t)
+	if _, err := dm.Exec("CREATE INDEX idx_items ON items(id)"); err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	_, err := dm.Exec("CREATE INDEX idx_items ON items(id)")
+	if !isAlreadyExistsError(err) {
+		t.Errorf("Expected duplicate SQLite index to be recognized, got %v", err)
+	}
+	_, err = dm.Exec("CREATE INDEX idx_missing ON missing(id)")
+	if err == nil || isAlreadyExistsError(err) {
+		t.Errorf("Expected a missing table not to be treated as existing, got %v", err)
+	}
+}
+
+func TestIsMissingTableError(t *testing.T) {
+	tests := []struct {
+		err     error
+		missing bool
+	}{
+		{&pq.Error{Code: "42P01"}, true},
+		{&pq.Error{Code: "42P07"}, false},
+		{&mysql.MySQLError{Number: 1146}, true},
+		{&mysql.MySQLError{Number: 1061}, false},
+		{mssql.Error{Number: 208}, true},
+		{mssql.Error{Number: 1913}, false},
+		{errors.New("no such table"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isMissingTableError(tt.err); got != tt.missing {
+			t.Errorf("isMissingTableError(%v) = %v, want %v", tt.err, got, tt.missing)
+		}
+	}
+
+	dm := connectTxTestDB(t)
+	_, err := dm.Exec("SELECT id FROM missing")
+	if !isMissingTableError(err) {
+		t.Errorf("Expected missing SQLite table to be recognized, got %v", err)
+	}
+}
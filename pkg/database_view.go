@@ -0,0 +1,308 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+	"sync/atomic"
+)
+
+// databaseView is a DatabaseManager bound to a context, which the methods
+// without a context argument use. It pins reads to the primary either always
+// or once it has been used to write, and while a WithTx callback runs it sends
+// every statement through that transaction. A view with a session runs all
+// statements on the session's connection.
+type databaseView struct {
+	*databaseManager
+	ctx         context.Context
+	primaryOnly bool
+	state       *viewState   // Shared by views of the same request
+	session     *connSession // Optional, shared like state
+}
+
+// viewState is the state shared by all views of one request
+type viewState struct {
+	wrote atomic.Bool
+
+	mu sync.Mutex
+	tx *managedTx // Transaction of the running WithTx callback
+}
+
+// forPrimary returns a view whose reads always go to the primary. The view is
+// returned even without replicas, since stores are often created before Connect.
+func (dm *databaseManager) forPrimary() DatabaseManager {
+	return &databaseView{databaseManager: dm, ctx: context.Background(), primaryOnly: true, state: &viewState{}}
+}
+
+// forRequest returns a view for a single request: statements are canceled with
+// ctx, and reads go to replicas until the request writes through Exec, Prepare
+// or a transaction, after which they go to the primary.
+func (dm *databaseManager) forRequest(ctx context.Context) DatabaseManager {
+	return &databaseView{databaseManager: dm, ctx: ctx, state: &viewState{}}
+}
+
+// forPrimary returns a view of the same manager whose reads always go to the primary
+func (v *databaseView) forPrimary() DatabaseManager {
+	return &databaseView{databaseManager: v.databaseManager, ctx: v.ctx, primaryOnly: true, state: &viewState{}}
+}
+
+// forRequest returns a fresh view bound to ctx that keeps the primary pinning
+func (v *databaseView) forRequest(ctx context.Context) DatabaseManager {
+	return &databaseView{databaseManager: v.databaseManager, ctx: ctx, primaryOnly: v.primaryOnly, state: &viewState{}}
+}
+
+// withContext returns a view of the same request bound to a derived context
+func (v *databaseView) withContext(ctx context.Context) DatabaseManager {
+	return v.bind(ctx)
+}
+
+// bind returns a view of the same request and session bound to ctx
+func (v *databaseView) bind(ctx context.Context) *databaseView {
+	return &databaseView{databaseManager: v.databaseManager, ctx: ctx, primaryOnly: v.primaryOnly, state: v.state, session: v.session}
+}
+
+// pinned reports whether reads must use the primary
+func (v *databaseView) pinned() bool {
+	return v.primaryOnly || v.state.wrote.Load()
+}
+
+// currentTx returns the transaction of the running WithTx callback, if any
+func (v *databaseView) currentTx() *managedTx {
+	v.state.mu.Lock()
+	defer v.state.mu.Unlock()
+	return v.state.tx
+}
+
+// activeTransaction returns the transaction of the running WithTx callback
+func (v *databaseView) activeTransaction() Transaction {
+	if tx := v.currentTx(); tx != nil {
+		return tx
+	}
+	return nil
+}
+
+// Query executes a query that returns rows
+func (v *databaseView) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return sqlRows(v.QueryContext(v.ctx, query, args...))
+}
+
+// QueryContext executes a query that returns rows
+func (v *databaseView) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	if tx := v.currentTx(); tx != nil {
+		return tx.QueryContext(ctx, query, args...)
+	}
+	if v.session != nil {
+		conn, err := v.session.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return queryWithTimeout(ctx, conn, v.statementTimeout(), query, args...)
+	}
+	if v.pinned() {
+		return v.databaseManager.primaryQueryContext(ctx, query, args...)
+	}
+	return v.databaseManager.QueryContext(ctx, query, args...)
+}
+
+// QueryRow executes a query that returns at most one row
+func (v *databaseView) QueryRow(query string, args ...interface{}) *sql.Row {
+	return v.QueryRowContext(v.ctx, query, args...).sqlRow()
+}
+
+// QueryRowContext executes a query that returns at most one row
+func (v *databaseView) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	if tx := v.currentTx(); tx != nil {
+		return tx.QueryRowContext(ctx, query, args...)
+	}
+	if v.session != nil {
+		conn, err := v.session.acquire(ctx)
+		if err != nil {
+			return newErrorRow(err)
+		}
+		return queryRowWithTimeout(ctx, conn, v.statementTimeout(), query, args...)
+	}
+	if v.pinned() {
+		return v.databaseManager.primaryQueryRowContext(ctx, query, args...)
+	}
+	return v.databaseManager.QueryRowContext(ctx, query, args...)
+}
+
+// Exec executes a query on the primary and pins later reads to it
+func (v *databaseView) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return v.ExecContext(v.ctx, query, args...)
+}
+
+// ExecContext executes a query on the primary and pins later reads to it
+func (v *databaseView) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	v.state.wrote.Store(true)
+	if tx := v.currentTx(); tx != nil {
+		return tx.ExecContext(ctx, query, args...)
+	}
+	if v.session != nil {
+		conn, err := v.session.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return execWithTimeout(ctx, conn, v.statementTimeout(), query, args...)
+	}
+	return v.databaseManager.ExecContext(ctx, query, args...)
+}
+
+// Prepare creates a prepared statement on the primary and pins later reads to it
+func (v *databaseView) Prepare(query string) (*sql.Stmt, error) {
+	return v.PrepareContext(v.ctx, query)
+}
+
+// PrepareContext creates a prepared statement on the primary and pins later reads to it
+func (v *databaseView) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	v.state.wrote.Store(true)
+	if tx := v.currentTx(); tx != nil {
+		return tx.PrepareContext(ctx, query)
+	}
+	if v.session != nil {
+		conn, err := v.session.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return prepareWithTimeout(ctx, conn, v.statementTimeout(), query)
+	}
+	return v.databaseManager.PrepareContext(ctx, query)
+}
+
+// Begin starts a transaction on the primary and pins later reads to it
+func (v *databaseView) Begin() (Transaction, error) {
+	return v.BeginTxContext(v.ctx, nil)
+}
+
+// BeginTx starts a transaction on the primary and pins later reads to it
+func (v *databaseView) BeginTx(opts *sql.TxOptions) (Transaction, error) {
+	return v.BeginTxContext(v.ctx, opts)
+}
+
+// BeginTxContext starts a transaction on the primary and pins later reads to it
+func (v *databaseView) BeginTxContext(ctx context.Context, opts *sql.TxOptions) (Transaction, error) {
+	v.state.wrote.Store(true)
+	if v.session == nil {
+		return v.databaseManager.BeginTxContext(ctx, opts)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	conn, err := v.session.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := conn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, statementError(ctx, "begin", 0, err)
+	}
+	return &transaction{tx: tx, ctx: ctx, timeout: v.statementTimeout()}, nil
+}
+
+// WithTx runs fn in a transaction that statements through this view and other
+// views of the request join. A WithTx call made while another one is running
+// becomes a savepoint of the outer transaction.
+func (v *databaseView) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Transaction) error) error {
+	if ctx == nil {
+		ctx = v.ctx
+	}
+	v.state.wrote.Store(true)
+
+	if tx := v.currentTx(); tx != nil {
+		return tx.savepoint(ctx, fn)
+	}
+
+	return v.databaseManager.runTx(ctx, opts, v.BeginTxContext, func(tx *managedTx) error {
+		v.state.mu.Lock()
+		v.state.tx = tx
+		v.state.mu.Unlock()
+		defer func() {
+			v.state.mu.Lock()
+			v.state.tx = nil
+			v.state.mu.Unlock()
+		}()
+		return fn(tx)
+	})
+}
+
+// connSession pins the statements of a view to one connection, for settings
+// such as search_path that only apply to the connection they were made on
+type connSession struct {
+	dm    *databaseManager
+	setup func(ctx context.Context, conn *sql.Conn) error
+	reset func(conn *sql.Conn) error // Optional; without it the connection is closed
+
+	mu   sync.Mutex
+	conn *sql.Conn
+}
+
+// acquire returns the session connection, reserving and setting it up on first use
+func (s *connSession) acquire(ctx context.Context) (*sql.Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		return s.conn, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	conn, err := s.dm.primaryConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.setup(ctx, conn); err != nil {
+		discardConn(conn)
+		return nil, err
+	}
+	s.conn = conn
+	return conn, nil
+}
+
+// release resets the connection and returns it to the pool. A connection that
+// cannot be reset is closed, so its settings never reach another request.
+func (s *connSession) release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conn := s.conn
+	if conn == nil {
+		return nil
+	}
+	s.conn = nil
+
+	if s.reset != nil {
+		err := s.reset(conn)
+		if err == nil {
+			return conn.Close()
+		}
+		discardConn(conn)
+		return err
+	}
+	discardConn(conn)
+	return nil
+}
+
+// discardConn closes a reserved connection instead of returning it to the pool
+func discardConn(conn *sql.Conn) {
+	// database/sql closes connections whose Raw callback reports ErrBadConn
+	conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	conn.Close()
+}
+
+// unwrapDatabaseManager returns the databaseManager behind db, if any
+func unwrapDatabaseManager(db DatabaseManager) (*databaseManager, bool) {
+	switch d := db.(type) {
+	case *databaseManager:
+		return d, true
+	case *databaseView:
+		return d.databaseManager, true
+	}
+	return nil, false
+}
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -74,6 +75,9 @@ const (
 	ErrCodeInvalidToken         = "INVALID_TOKEN"
 	ErrCodeTokenExpired         = "TOKEN_EXPIRED"
 	ErrCodeUnauthorized         = "UNAUTHORIZED"
+	ErrCodeMFARequired          = "MFA_REQUIRED"
+	ErrCodeMFAInvalidCode       = "MFA_INVALID_CODE"
+	ErrCodeMFACodeReused        = "MFA_CODE_REUSED"
 
 	// Authorization errors
 	ErrCodeForbidden           = "FORBIDDEN"
@@ -106,6 +110,8 @@ const (
 	ErrCodeDatabaseConnection   = "DATABASE_CONNECTION"
 	ErrCodeDatabaseQuery        = "DATABASE_QUERY"
 	ErrCodeDatabaseTransaction  = "DATABASE_TRANSACTION"
+	ErrCodeDatabaseTimeout      = "DATABASE_TIMEOUT"
+	ErrCodeDatabaseCanceled     = "DATABASE_CANCELED"
 	ErrCodeRecordNotFound       = "RECORD_NOT_FOUND"
 	ErrCodeDuplicateRecord      = "DUPLICATE_RECORD"
 	ErrCodeNoDatabaseConfigured = "NO_DATABASE_CONFIGURED"
@@ -128,6 +134,8 @@ const (
 	ErrCodeTenantNotFound      = "TENANT_NOT_FOUND"
 	ErrCodeTenantInactive      = "TENANT_INACTIVE"
 	ErrCodeTenantLimitExceeded = "TENANT_LIMIT_EXCEEDED"
+	ErrCodeTenantScope         = "TENANT_SCOPE_VIOLATION"
+	ErrCodeQuotaExceeded       = "QUOTA_EXCEEDED"
 
 	// WebSocket errors
 	ErrCodeWebSocketUpgradeFailed    = "WEBSOCKET_UPGRADE_FAILED"
@@ -199,6 +207,46 @@ func NewDatabaseError(message string, operation string) *FrameworkError {
 	}
 }
 
+// NewDatabaseTimeoutError creates an error for a statement that exceeded its
+// deadline. A zero timeout means the deadline came from the caller's context.
+func NewDatabaseTimeoutError(operation string, timeout time.Duration) *FrameworkError {
+	details := map[string]interface{}{"operation": operation}
+	if timeout > 0 {
+		details["timeout"] = timeout.String()
+	}
+	return &FrameworkError{
+		Code:       ErrCodeDatabaseTimeout,
+		Message:    "Database query timed out",
+		StatusCode: http.StatusGatewayTimeout,
+		I18nKey:    "error.database.timeout",
+		Details:    details,
+		Cause:      context.DeadlineExceeded,
+	}
+}
+
+// NewDatabaseCanceledError creates an error for a statement whose context was
+// canceled, typically because the client went away
+func NewDatabaseCanceledError(operation string) *FrameworkError {
+	return &FrameworkError{
+		Code:       ErrCodeDatabaseCanceled,
+		Message:    "Database query was canceled",
+		StatusCode: http.StatusRequestTimeout,
+		I18nKey:    "error.database.canceled",
+		Details:    map[string]interface{}{"operation": operation},
+		Cause:      context.Canceled,
+	}
+}
+
+// NewTransactionError creates a database transaction error
+func NewTransactionError(message string) *FrameworkError {
+	return &FrameworkError{
+		Code:       ErrCodeDatabaseTransaction,
+		Message:    message,
+		StatusCode: http.StatusInternalServerError,
+		I18nKey:    "error.database.transaction",
+	}
+}
+
 // NewInternalError creates an internal server error
 func NewInternalError(message string) *FrameworkError {
 	return &FrameworkError{
@@ -219,6 +267,39 @@ func NewTenantError(code, message string, statusCode int) *FrameworkError {
 	}
 }
 
+// NewTenantScopeError creates an error for a statement that would read or
+// change rows of another tenant
+func NewTenantScopeError(tenantID, table string) *FrameworkError {
+	return &FrameworkError{
+		Code:       ErrCodeTenantScope,
+		Message:    fmt.Sprintf("Statement on %s is not scoped to the current tenant", table),
+		StatusCode: http.StatusForbidden,
+		I18nKey:    "error.tenant.scope_violation",
+		TenantID:   tenantID,
+		Details:    map[string]interface{}{"table": table},
+	}
+}
+
+// NewQuotaExceededError creates a quota exceeded error for a tenant resource
+func NewQuotaExceededError(resource QuotaResource, used, limit int64) *FrameworkError {
+	statusCode := http.StatusForbidden
+	switch resource {
+	case QuotaRequests:
+		statusCode = http.StatusTooManyRequests
+	case QuotaStorage:
+		statusCode = http.StatusInsufficientStorage
+	}
+
+	return &FrameworkError{
+		Code:       ErrCodeQuotaExceeded,
+		Message:    fmt.Sprintf("%s quota exceeded", resource),
+		StatusCode: statusCode,
+		I18nKey:    "error.tenant.quota_exceeded",
+		I18nParams: map[string]interface{}{"resource": string(resource), "limit": limit},
+		Details:    map[string]interface{}{"resource": string(resource), "used": used, "limit": limit},
+	}
+}
+
 // ErrorHandler defines the interface for handling framework errors
 type ErrorHandler interface {
 	HandleError(ctx Context, err error) error
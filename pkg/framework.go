@@ -29,6 +29,13 @@ type Framework struct {
 	// Proxy
 	proxy ProxyManager
 
+	// Background jobs and scheduled tasks
+	jobs      JobQueue
+	scheduler Scheduler
+
+	// Tenant quotas
+	quotas QuotaManager
+
 	// Plugin system
 	pluginManager PluginManager
 
@@ -79,6 +86,15 @@ type FrameworkConfig struct {
 	// Proxy configuration
 	ProxyConfig ProxyConfig
 
+	// Background job configuration
+	JobConfig JobConfig
+
+	// Scheduler configuration
+	SchedulerConfig SchedulerConfig
+
+	// Tenant quota configuration
+	QuotaConfig QuotaConfig
+
 	// Plugin configuration
 	PluginConfigPath string
 	EnablePlugins    bool
@@ -133,8 +149,28 @@ func New(config FrameworkConfig) (*Framework, error) {
 	}
 	f.database = dbMgr
 
-	// Initialize cache manager with configuration
-	f.cache = NewCacheManager(config.CacheConfig)
+	// Initialize cache manager with configuration; with an invalidation bus,
+	// entries changed on other instances are removed from the in-memory cache
+	switch {
+	case config.CacheConfig.Invalidation.Bus == "":
+		f.cache = NewCacheManager(config.CacheConfig)
+	case config.CacheConfig.Invalidation.Bus != CacheInvalidationDatabase:
+		fmt.Printf("WARN: Unknown cache invalidation bus %q, entries are not invalidated across instances\n", config.CacheConfig.Invalidation.Bus)
+		f.cache = NewCacheManager(config.CacheConfig)
+	case isNoopDatabase(f.database):
+		fmt.Println("WARN: Cache invalidation bus requires a database, entries are not invalidated across instances")
+		f.cache = NewCacheManager(config.CacheConfig)
+	default:
+		bus := NewDatabaseInvalidationBus(PrimaryDatabase(f.database), config.CacheConfig.Invalidation)
+		cacheMgr, err := NewCacheManagerWithInvalidation(config.CacheConfig, nil, bus)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cache manager: %w", err)
+		}
+		f.cache = cacheMgr
+		f.RegisterShutdownHook(func(ctx context.Context) error {
+			return cacheMgr.(*cacheManagerImpl).closeInvalidation()
+		})
+	}
 
 	// Initialize session manager
 	sessionMgr, err := NewSessionManager(&config.SessionConfig, f.database, f.cache)
@@ -153,12 +189,41 @@ func New(config FrameworkConfig) (*Framework, error) {
 	// Initialize metrics collector
 	metricsMgr := NewMetricsCollector(f.database)
 	f.metrics = metricsMgr
+	if cacheMgr, ok := f.cache.(*cacheManagerImpl); ok {
+		cacheMgr.setMetrics(metricsMgr)
+	}
 
 	// Initialize monitoring manager
 	logger := NewLogger(nil)
 	monitoringMgr := NewMonitoringManager(config.MonitoringConfig, metricsMgr, f.database, logger)
 	f.monitoring = monitoringMgr
 
+	// Initialize background job queue; workers start with the server and
+	// finish their running jobs during graceful shutdown
+	f.jobs = NewJobQueue(f.database, config.JobConfig, metricsMgr)
+	f.RegisterStartupHook(func(ctx context.Context) error {
+		return f.jobs.Start()
+	})
+	f.RegisterShutdownHook(f.jobs.Shutdown)
+
+	// Initialize scheduler; only the elected leader runs scheduled tasks
+	schedulerMgr, err := NewScheduler(f.database, config.SchedulerConfig, metricsMgr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scheduler: %w", err)
+	}
+	f.scheduler = schedulerMgr
+	f.RegisterStartupHook(func(ctx context.Context) error {
+		return f.scheduler.Start()
+	})
+	f.RegisterShutdownHook(f.scheduler.Stop)
+	if err := f.scheduleMaintenanceTasks(config); err != nil {
+		return nil, fmt.Errorf("failed to schedule maintenance tasks: %w", err)
+	}
+
+	// Initialize quota manager; files written through Context.Files and the
+	// plugin storage services count towards the request tenant's quota
+	f.quotas = NewQuotaManager(f.database, config.QuotaConfig)
+
 	// Initialize proxy manager
 	proxyMgr := NewProxyManager(&config.ProxyConfig, f.cache)
 	f.proxy = proxyMgr
@@ -207,6 +272,10 @@ func New(config FrameworkConfig) (*Framework, error) {
 			f.networkClient,
 		)
 		f.pluginManager = pluginMgr
+		if pm, ok := pluginMgr.(*pluginManagerImpl); ok {
+			pm.scheduler = f.scheduler
+			pm.quotas = f.quotas
+		}
 
 		// Discover and initialize plugins
 		if err := pluginMgr.DiscoverPlugins(); err != nil {
@@ -222,6 +291,38 @@ func New(config FrameworkConfig) (*Framework, error) {
 	return f, nil
 }
 
+// scheduleMaintenanceTasks moves the cleanup of shared database state to the
+// scheduler, so that it runs on one instance instead of all of them
+func (f *Framework) scheduleMaintenanceTasks(config FrameworkConfig) error {
+	if isNoopDatabase(f.database) {
+		return nil
+	}
+
+	if err := f.scheduler.Schedule("framework.token_cleanup", "@hourly", func(ctx context.Context) error {
+		return f.database.CleanupExpiredTokens()
+	}); err != nil {
+		return err
+	}
+
+	// Sessions kept in memory or on local disk are still cleaned up by every
+	// instance. Database sessions are cleaned up locally until leader election
+	// works, so they are not left behind while the scheduler tables are missing.
+	if config.SessionConfig.StorageType == SessionStorageDatabase {
+		sm, isSessionManager := f.session.(*sessionManager)
+		s, isScheduler := f.scheduler.(*scheduler)
+		if isSessionManager && isScheduler {
+			s.onLeaseEstablished(sm.Stop)
+		}
+		interval := fmt.Sprintf("@every %s", config.SessionConfig.CleanupInterval)
+		if err := f.scheduler.Schedule("framework.session_cleanup", interval, func(ctx context.Context) error {
+			return f.session.CleanupExpired()
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Router returns the framework's router for route registration
 func (f *Framework) Router() RouterEngine {
 	return f.router
@@ -390,6 +491,9 @@ func (f *Framework) ListenWithConfig(addr string, config ServerConfig) error {
 	logger := NewLogger(nil)
 	if httpServer, ok := server.(*httpServer); ok {
 		httpServer.SetManagers(logger, f.metrics, f.session, f.database, f.cache, f.config, f.i18n, f.security)
+		httpServer.SetJobQueue(f.jobs)
+		httpServer.SetFileManager(f.fileManager)
+		httpServer.SetQuotaManager(f.quotas)
 
 		// Set hook system if plugin manager is available
 		if f.pluginManager != nil {
@@ -474,6 +578,16 @@ func (f *Framework) Cache() CacheManager {
 	return f.cache
 }
 
+// Jobs returns the framework's background job queue
+func (f *Framework) Jobs() JobQueue {
+	return f.jobs
+}
+
+// Scheduler returns the framework's task scheduler
+func (f *Framework) Scheduler() Scheduler {
+	return f.scheduler
+}
+
 // Session returns the framework's session manager
 func (f *Framework) Session() SessionManager {
 	return f.session
@@ -514,6 +628,11 @@ func (f *Framework) PluginManager() PluginManager {
 	return f.pluginManager
 }
 
+// Quotas returns the framework's tenant quota manager
+func (f *Framework) Quotas() QuotaManager {
+	return f.quotas
+}
+
 // FileManager returns the framework's file manager
 func (f *Framework) FileManager() FileManager {
 	return f.fileManager
@@ -595,6 +714,10 @@ func (c *startupHookContext) Cache() CacheManager {
 	return nil
 }
 
+func (c *startupHookContext) Jobs() JobQueue {
+	return nil
+}
+
 func (c *startupHookContext) Config() ConfigManager {
 	return nil
 }
@@ -738,6 +861,10 @@ func (c *shutdownHookContext) Cache() CacheManager {
 	return nil
 }
 
+func (c *shutdownHookContext) Jobs() JobQueue {
+	return nil
+}
+
 func (c *shutdownHookContext) Config() ConfigManager {
 	return nil
 }
@@ -161,15 +161,16 @@ func TestFrameworkLifecycleHooks(t *testing.T) {
 		t.Fatalf("Failed to create framework: %v", err)
 	}
 
-	// Test startup hooks
+	// Test startup hooks; the framework registers the job queue's hooks itself
+	startupHooks, shutdownHooks := len(app.startupHooks), len(app.shutdownHooks)
 	_ = false // startupCalled placeholder
 	app.RegisterStartupHook(func(ctx context.Context) error {
 		// startupCalled = true
 		return nil
 	})
 
-	if len(app.startupHooks) != 1 {
-		t.Errorf("Expected 1 startup hook, got %d", len(app.startupHooks))
+	if len(app.startupHooks) != startupHooks+1 {
+		t.Errorf("Expected %d startup hooks, got %d", startupHooks+1, len(app.startupHooks))
 	}
 
 	// Test shutdown hooks
@@ -179,8 +180,8 @@ func TestFrameworkLifecycleHooks(t *testing.T) {
 		return nil
 	})
 
-	if len(app.shutdownHooks) != 1 {
-		t.Errorf("Expected 1 shutdown hook, got %d", len(app.shutdownHooks))
+	if len(app.shutdownHooks) != shutdownHooks+1 {
+		t.Errorf("Expected %d shutdown hooks, got %d", shutdownHooks+1, len(app.shutdownHooks))
 	}
 }
 
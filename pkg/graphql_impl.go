@@ -316,7 +316,8 @@ func (g *graphqlManager) validationMiddleware(config GraphQLConfig, next GraphQL
 
 		// Apply timeout if configured
 		if config.Timeout > 0 {
-			timeoutCtx := ctx.WithTimeout(config.Timeout)
+			timeoutCtx, cancel := ContextWithTimeout(ctx, config.Timeout)
+			defer cancel()
 			return next(timeoutCtx)
 		}
 
@@ -300,7 +300,8 @@ func (g *grpcManager) validationMiddleware(config GRPCConfig, next GRPCHandler)
 
 		// Apply timeout if configured
 		if config.Timeout > 0 {
-			timeoutCtx := ctx.WithTimeout(config.Timeout)
+			timeoutCtx, cancel := ContextWithTimeout(ctx, config.Timeout)
+			defer cancel()
 			return next(timeoutCtx)
 		}
 
@@ -94,17 +94,35 @@ func (m *mockGRPCDB) IncrementRateLimit(key string, window time.Duration) error
 	m.rateLimits[key]++
 	return nil
 }
-func (m *mockGRPCDB) Connect(config DatabaseConfig) error                         { return nil }
-func (m *mockGRPCDB) Close() error                                                { return nil }
-func (m *mockGRPCDB) Ping() error                                                 { return nil }
-func (m *mockGRPCDB) Stats() DatabaseStats                                        { return DatabaseStats{} }
-func (m *mockGRPCDB) IsConnected() bool                                           { return true }
-func (m *mockGRPCDB) Query(query string, args ...interface{}) (*sql.Rows, error)  { return nil, nil }
-func (m *mockGRPCDB) QueryRow(query string, args ...interface{}) *sql.Row         { return nil }
-func (m *mockGRPCDB) Exec(query string, args ...interface{}) (sql.Result, error)  { return nil, nil }
-func (m *mockGRPCDB) Prepare(query string) (*sql.Stmt, error)                     { return nil, nil }
-func (m *mockGRPCDB) Begin() (Transaction, error)                                 { return nil, nil }
-func (m *mockGRPCDB) BeginTx(opts *sql.TxOptions) (Transaction, error)            { return nil, nil }
+func (m *mockGRPCDB) Connect(config DatabaseConfig) error                        { return nil }
+func (m *mockGRPCDB) Close() error                                               { return nil }
+func (m *mockGRPCDB) Ping() error                                                { return nil }
+func (m *mockGRPCDB) Stats() DatabaseStats                                       { return DatabaseStats{} }
+func (m *mockGRPCDB) IsConnected() bool                                          { return true }
+func (m *mockGRPCDB) Query(query string, args ...interface{}) (*sql.Rows, error) { return nil, nil }
+func (m *mockGRPCDB) QueryRow(query string, args ...interface{}) *sql.Row        { return nil }
+func (m *mockGRPCDB) Exec(query string, args ...interface{}) (sql.Result, error) { return nil, nil }
+func (m *mockGRPCDB) Prepare(query string) (*sql.Stmt, error)                    { return nil, nil }
+func (m *mockGRPCDB) Begin() (Transaction, error)                                { return nil, nil }
+func (m *mockGRPCDB) BeginTx(opts *sql.TxOptions) (Transaction, error)           { return nil, nil }
+func (m *mockGRPCDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return nil, nil
+}
+func (m *mockGRPCDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	return nil
+}
+func (m *mockGRPCDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return m.Exec(query, args...)
+}
+func (m *mockGRPCDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return m.Prepare(query)
+}
+func (m *mockGRPCDB) BeginTxContext(ctx context.Context, opts *sql.TxOptions) (Transaction, error) {
+	return m.BeginTx(opts)
+}
+func (m *mockGRPCDB) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Transaction) error) error {
+	return fn(nil)
+}
 func (m *mockGRPCDB) SaveSession(session *Session) error                          { return nil }
 func (m *mockGRPCDB) LoadSession(sessionID string) (*Session, error)              { return nil, nil }
 func (m *mockGRPCDB) DeleteSession(sessionID string) error                        { return nil }
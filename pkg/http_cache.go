@@ -0,0 +1,645 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPCacheTag tags every response stored by the HTTP cache, so that
+// InvalidateTag(HTTPCacheTag) purges all of them
+const HTTPCacheTag = "http-cache"
+
+// HTTPCacheConfig configures the HTTP response cache of HTTPCacheMiddleware
+type HTTPCacheConfig struct {
+	// Name identifies the cache in the Cache-Status response header.
+	// Default: "rockstar"
+	Name string
+
+	// KeyPrefix is prepended to the cache keys of stored responses.
+	// Default: "http:"
+	KeyPrefix string
+
+	// DefaultTTL is the freshness lifetime of cacheable responses without
+	// an explicit one (max-age, s-maxage or Expires). With 0 such responses
+	// are only stored if they can be revalidated.
+	// Default: 0
+	DefaultTTL time.Duration
+
+	// StaleRetention is how long responses with an ETag or Last-Modified
+	// header are kept after they became stale, so that they can be
+	// revalidated with a conditional request.
+	// Default: 1h
+	StaleRetention time.Duration
+
+	// MaxBodySize is the largest response body stored, in bytes.
+	// Default: 1MB
+	MaxBodySize int64
+
+	// SurrogateKeyHeader is the response header listing space-separated
+	// surrogate keys. They become tags of the stored response, so that
+	// InvalidateTag purges every response with the key. The header is not
+	// sent to clients.
+	// Default: "Surrogate-Key"
+	SurrogateKeyHeader string
+}
+
+// httpCacheEntry is a stored response
+type httpCacheEntry struct {
+	StatusCode   int         `json:"status"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"body"`
+	RequestTime  time.Time   `json:"request_time"`
+	ResponseTime time.Time   `json:"response_time"`
+}
+
+// httpCache implements the caching rules of RFC 9111 for a shared cache.
+// It is used by HTTPCacheMiddleware and by the proxy manager.
+type httpCache struct {
+	cache  CacheManager
+	config HTTPCacheConfig
+	now    func() time.Time
+}
+
+// newHTTPCache creates an HTTP cache storing responses in cache
+func newHTTPCache(cache CacheManager, config HTTPCacheConfig) *httpCache {
+	config.ApplyDefaults()
+	return &httpCache{cache: cache, config: config, now: time.Now}
+}
+
+// httpCacheLookup is the state of a request between lookup and complete
+type httpCacheLookup struct {
+	// bypass is set for requests the cache does not answer
+	bypass bool
+
+	key       string          // key of the request URI
+	variant   string          // key of the stored variant, if any
+	entry     *httpCacheEntry // stored response, if any
+	requestCC cacheControl
+	fwd       string // Cache-Status reason for forwarding the request
+
+	// conditional holds the validators the client sent
+	conditional http.Header
+	start       time.Time
+}
+
+// lookup looks up the stored response for req. It returns the response to
+// send if the request can be answered from the cache; otherwise the request
+// has to be forwarded with the validators of the lookup and the result
+// passed to complete.
+func (h *httpCache) lookup(req *Request) (*httpCacheLookup, *Response) {
+	l := &httpCacheLookup{start: h.now()}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		l.bypass = true
+		return l, nil
+	}
+
+	l.key = h.key(req.Host, req.URL, req.RequestURI)
+	l.requestCC = parseCacheControl(req.Header)
+	l.conditional = http.Header{}
+	for _, name := range []string{"If-None-Match", "If-Modified-Since"} {
+		if value := req.Header.Get(name); value != "" {
+			l.conditional.Set(name, value)
+		}
+	}
+
+	l.fwd = "uri-miss"
+	if vary, err := GetTyped[[]string](h.cache, l.key); err == nil {
+		l.variant = h.variantKey(l.key, vary, req.Header)
+		if entry, err := GetTyped[httpCacheEntry](h.cache, l.variant); err == nil {
+			l.entry = &entry
+		} else {
+			l.fwd = "vary-miss"
+		}
+	}
+
+	if l.entry != nil {
+		if h.usable(l.entry, l.requestCC) {
+			return l, h.respond(l, l.entry, req.Method, "hit")
+		}
+		l.fwd = "stale"
+		if l.requestCC.has("no-cache") {
+			l.fwd = "request"
+		}
+	}
+
+	if l.requestCC.has("only-if-cached") {
+		header := http.Header{}
+		header.Add("Cache-Status", h.config.Name+"; detail=only-if-cached")
+		return l, &Response{StatusCode: http.StatusGatewayTimeout, Header: header}
+	}
+	return l, nil
+}
+
+// validators returns the headers making the forwarded request conditional
+// on the stored response
+func (l *httpCacheLookup) validators() http.Header {
+	header := http.Header{}
+	if l.entry == nil {
+		return header
+	}
+	if etag := l.entry.Header.Get("ETag"); etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	if modified := l.entry.Header.Get("Last-Modified"); modified != "" {
+		header.Set("If-Modified-Since", modified)
+	}
+	return header
+}
+
+// complete stores the response of a forwarded request and returns the
+// response to send. resp may be nil if forwarding failed with err. A stored
+// response replaces resp when it was revalidated, or when resp is an error
+// and the stored response may be served stale.
+func (h *httpCache) complete(l *httpCacheLookup, req *Request, resp *Response, err error) (*Response, error) {
+	if l.bypass {
+		if err == nil && resp != nil && resp.StatusCode >= 200 && resp.StatusCode < 400 && isUnsafeMethod(req.Method) {
+			h.invalidateURIs(req, resp.Header)
+		}
+		return resp, err
+	}
+
+	if l.entry != nil {
+		if err == nil && resp != nil && resp.StatusCode == http.StatusNotModified && sameETag(l.entry, resp.Header) {
+			entry := h.freshen(l, resp.Header)
+			h.store(l.key, l.variant, entry)
+			return h.respond(l, entry, req.Method, "fwd=stale; fwd-status=304"), nil
+		}
+		if (err != nil || (resp != nil && isServerErrorStatus(resp.StatusCode))) && h.staleIfError(l.entry, l.requestCC) {
+			status := "fwd=" + l.fwd
+			if resp != nil {
+				status += "; fwd-status=" + strconv.Itoa(resp.StatusCode)
+			}
+			return h.respond(l, l.entry, req.Method, status+"; detail=stale-if-error"), nil
+		}
+	}
+	if err != nil || resp == nil {
+		return resp, err
+	}
+
+	status := "fwd=" + l.fwd + "; fwd-status=" + strconv.Itoa(resp.StatusCode)
+	header := resp.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Del(h.config.SurrogateKeyHeader)
+	if req.Method == http.MethodGet && h.storable(req, l.requestCC, resp) {
+		entry := &httpCacheEntry{
+			StatusCode:   resp.StatusCode,
+			Header:       resp.Header.Clone(),
+			Body:         resp.Body,
+			RequestTime:  l.start,
+			ResponseTime: h.now(),
+		}
+		if entry.Header.Get("Date") == "" {
+			entry.Header.Set("Date", entry.ResponseTime.UTC().Format(http.TimeFormat))
+			header.Set("Date", entry.Header.Get("Date"))
+		}
+		vary := varyHeaders(entry.Header)
+		if h.store(l.key, h.variantKey(l.key, vary, req.Header), entry) {
+			status += "; stored"
+		}
+	}
+	header.Add("Cache-Status", h.config.Name+"; "+status)
+
+	sent := *resp
+	sent.Header = header
+	return &sent, nil
+}
+
+// key returns the cache key of a URI
+func (h *httpCache) key(host string, u *url.URL, requestURI string) string {
+	if host == "" && u != nil {
+		host = u.Host
+	}
+	if requestURI == "" && u != nil {
+		requestURI = u.RequestURI()
+	}
+	return h.config.KeyPrefix + strings.ToLower(host) + requestURI
+}
+
+// variantKey returns the cache key of the response to a request with header
+// for a URI whose responses vary by the vary headers
+func (h *httpCache) variantKey(key string, vary []string, header http.Header) string {
+	sum := sha256.New()
+	for _, name := range vary {
+		sum.Write([]byte(name + ":" + normalizeHeaderValue(header.Values(name)) + "\n"))
+	}
+	return key + "#" + hex.EncodeToString(sum.Sum(nil)[:16])
+}
+
+// httpCacheClientHeaders are the response header fields that belong to the
+// client the response was sent to and are never stored
+var httpCacheClientHeaders = []string{"Set-Cookie", "Set-Cookie2"}
+
+// store stores entry as the variant of key and reports whether it did.
+// Cookies set by the response are removed from the stored copy.
+func (h *httpCache) store(key, variant string, entry *httpCacheEntry) bool {
+	cc := parseCacheControl(entry.Header)
+	retention := time.Duration(0)
+	if entry.Header.Get("ETag") != "" || entry.Header.Get("Last-Modified") != "" {
+		retention = h.config.StaleRetention
+	}
+	if d, ok := cc.seconds("stale-if-error"); ok && d > retention {
+		retention = d
+	}
+	ttl := h.freshness(entry, cc) - entry.age(h.now()) + retention
+	if ttl <= 0 {
+		return false
+	}
+
+	tags := []string{HTTPCacheTag, httpCacheURITag(key)}
+	if err := h.set(key, varyHeaders(entry.Header), ttl, tags); err != nil {
+		return false
+	}
+	tags = append(tags, strings.Fields(entry.Header.Get(h.config.SurrogateKeyHeader))...)
+
+	stored := *entry
+	for _, name := range httpCacheClientHeaders {
+		if _, exists := entry.Header[name]; exists {
+			stored.Header = entry.Header.Clone()
+			for _, name := range httpCacheClientHeaders {
+				stored.Header.Del(name)
+			}
+			break
+		}
+	}
+	return h.set(variant, stored, ttl, tags) == nil
+}
+
+// set stores value with tags if the cache implements TaggedCache. Other
+// caches store it without tags: invalidateURI still works, purging by
+// surrogate key does not.
+func (h *httpCache) set(key string, value interface{}, ttl time.Duration, tags []string) error {
+	if tagged, ok := h.cache.(TaggedCache); ok {
+		return tagged.SetWithTags(key, value, ttl, tags)
+	}
+	return h.cache.Set(key, value, ttl)
+}
+
+// storable reports whether the response to req may be stored (RFC 9111
+// section 3)
+func (h *httpCache) storable(req *Request, requestCC cacheControl, resp *Response) bool {
+	switch {
+	case resp.StatusCode < 200, resp.StatusCode == http.StatusPartialContent, resp.StatusCode == http.StatusNotModified:
+		return false
+	case int64(len(resp.Body)) > h.config.MaxBodySize:
+		return false
+	case requestCC.has("no-store"):
+		return false
+	}
+
+	cc := parseCacheControl(resp.Header)
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		if name == "*" {
+			return false
+		}
+	}
+	// A shared cache must not reuse responses to authenticated requests
+	// unless the origin allows it
+	if req.Header.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return false
+	}
+	// Cookies are usually specific to a client
+	for _, name := range httpCacheClientHeaders {
+		if len(resp.Header.Values(name)) > 0 && !cc.has("public") {
+			return false
+		}
+	}
+
+	explicit := cc.has("public") || cc.has("s-maxage") || cc.has("max-age") || resp.Header.Get("Expires") != ""
+	return explicit || heuristicallyCacheable(resp.StatusCode)
+}
+
+// freshness returns the freshness lifetime of entry (RFC 9111 section 4.2.1)
+func (h *httpCache) freshness(entry *httpCacheEntry, cc cacheControl) time.Duration {
+	if d, ok := cc.seconds("s-maxage"); ok {
+		return d
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d
+	}
+	if expires := entry.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			// An invalid Expires means already expired
+			return 0
+		}
+		if d := t.Sub(entry.date()); d > 0 {
+			return d
+		}
+		return 0
+	}
+	if heuristicallyCacheable(entry.StatusCode) {
+		return h.config.DefaultTTL
+	}
+	return 0
+}
+
+// usable reports whether entry may answer a request without validation
+// (RFC 9111 section 4.2)
+func (h *httpCache) usable(entry *httpCacheEntry, requestCC cacheControl) bool {
+	cc := parseCacheControl(entry.Header)
+	if cc.has("no-cache") || requestCC.has("no-cache") {
+		return false
+	}
+
+	age := entry.age(h.now())
+	fresh := h.freshness(entry, cc) - age
+	if d, ok := requestCC.seconds("max-age"); ok && age > d {
+		return false
+	}
+	if d, ok := requestCC.seconds("min-fresh"); ok && fresh < d {
+		return false
+	}
+	if fresh > 0 {
+		return true
+	}
+
+	// Stale responses are only served if the client accepts them
+	if !requestCC.has("max-stale") || mustRevalidate(cc) {
+		return false
+	}
+	d, ok := requestCC.seconds("max-stale")
+	return !ok || -fresh <= d
+}
+
+// staleIfError reports whether the stale entry may be served because the
+// origin failed (RFC 5861 section 4)
+func (h *httpCache) staleIfError(entry *httpCacheEntry, requestCC cacheControl) bool {
+	cc := parseCacheControl(entry.Header)
+	if mustRevalidate(cc) {
+		return false
+	}
+	d, ok := requestCC.seconds("stale-if-error")
+	if !ok {
+		if d, ok = cc.seconds("stale-if-error"); !ok {
+			return false
+		}
+	}
+	return entry.age(h.now())-h.freshness(entry, cc) <= d
+}
+
+// freshen returns the stored response updated with the header fields of a
+// 304 response (RFC 9111 section 4.3.4)
+func (h *httpCache) freshen(l *httpCacheLookup, header http.Header) *httpCacheEntry {
+	entry := *l.entry
+	entry.Header = l.entry.Header.Clone()
+	for name, values := range header {
+		if name == "Content-Length" || name == "Cache-Status" {
+			continue
+		}
+		entry.Header[name] = values
+	}
+	entry.RequestTime = l.start
+	entry.ResponseTime = h.now()
+	if header.Get("Date") == "" {
+		entry.Header.Set("Date", entry.ResponseTime.UTC().Format(http.TimeFormat))
+	}
+	return &entry
+}
+
+// respond returns the response sending entry to the client, or a 304
+// response if the client's validators match it
+func (h *httpCache) respond(l *httpCacheLookup, entry *httpCacheEntry, method, status string) *Response {
+	header := entry.Header.Clone()
+	header.Del(h.config.SurrogateKeyHeader)
+	header.Set("Age", strconv.FormatInt(int64(entry.age(h.now())/time.Second), 10))
+	if status == "hit" {
+		cc := parseCacheControl(entry.Header)
+		ttl := (h.freshness(entry, cc) - entry.age(h.now())) / time.Second
+		status += "; ttl=" + strconv.FormatInt(int64(ttl), 10)
+	}
+	header.Add("Cache-Status", h.config.Name+"; "+status)
+
+	if notModified(l.conditional, entry.Header) {
+		for name := range header {
+			switch name {
+			case "Age", "Cache-Control", "Cache-Status", "Content-Location", "Date", "ETag", "Expires", "Last-Modified", "Vary":
+			default:
+				header.Del(name)
+			}
+		}
+		return &Response{StatusCode: http.StatusNotModified, Header: header}
+	}
+
+	resp := &Response{StatusCode: entry.StatusCode, Header: header, Size: int64(len(entry.Body))}
+	if method != http.MethodHead {
+		resp.Body = entry.Body
+	}
+	return resp
+}
+
+// invalidateURIs removes the stored responses of the target URI of an
+// unsafe request and of its Location and Content-Location if they are on
+// the same host (RFC 9111 section 4.4)
+func (h *httpCache) invalidateURIs(req *Request, header http.Header) {
+	h.invalidateURI(h.key(req.Host, req.URL, req.RequestURI))
+
+	for _, name := range []string{"Location", "Content-Location"} {
+		location := header.Get(name)
+		if location == "" || req.URL == nil {
+			continue
+		}
+		target, err := req.URL.Parse(location)
+		if err != nil {
+			continue
+		}
+		host := req.Host
+		if host == "" {
+			host = req.URL.Host
+		}
+		if target.Host != "" && !strings.EqualFold(target.Host, host) {
+			continue
+		}
+		h.invalidateURI(h.key(host, target, ""))
+	}
+}
+
+// invalidateURI removes the stored responses of the URI with key. Caches
+// that do not implement TaggedCache remove them by key pattern.
+func (h *httpCache) invalidateURI(key string) {
+	if tagged, ok := h.cache.(TaggedCache); ok {
+		tagged.InvalidateTag(httpCacheURITag(key))
+		return
+	}
+	h.cache.Delete(key)
+	h.cache.Invalidate(key + "#*")
+}
+
+// httpCacheURITag returns the tag of the stored responses of a URI
+func httpCacheURITag(key string) string {
+	return HTTPCacheTag + ":" + key
+}
+
+// date returns the Date of the stored response
+func (e *httpCacheEntry) date() time.Time {
+	if t, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return t
+	}
+	return e.ResponseTime
+}
+
+// age returns the current age of the stored response (RFC 9111 section
+// 4.2.3)
+func (e *httpCacheEntry) age(now time.Time) time.Duration {
+	apparentAge := e.ResponseTime.Sub(e.date())
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+	var ageValue time.Duration
+	if seconds, err := strconv.ParseInt(strings.TrimSpace(e.Header.Get("Age")), 10, 64); err == nil && seconds > 0 {
+		ageValue = time.Duration(seconds) * time.Second
+	}
+	correctedAge := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	if correctedAge > apparentAge {
+		apparentAge = correctedAge
+	}
+	return apparentAge + now.Sub(e.ResponseTime)
+}
+
+// cacheControl holds the directives of Cache-Control headers
+type cacheControl map[string]string
+
+// parseCacheControl parses the Cache-Control fields of header. Of repeated
+// directives the first one is used.
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, line := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if _, exists := cc[name]; !exists {
+				cc[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	return cc
+}
+
+// has reports whether the directive is present
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns the delta-seconds argument of the directive
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	value, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	// RFC 9111 section 1.2.2: larger values are treated as 2^31
+	if n > 1<<31 {
+		n = 1 << 31
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// mustRevalidate reports whether a stale response must not be served
+// without validation. s-maxage implies proxy-revalidate for shared caches.
+func mustRevalidate(cc cacheControl) bool {
+	return cc.has("must-revalidate") || cc.has("proxy-revalidate") || cc.has("s-maxage")
+}
+
+// heuristicallyCacheable reports whether responses with status may be
+// cached without explicit freshness (RFC 9110 section 15.1)
+func heuristicallyCacheable(status int) bool {
+	switch status {
+	case 200, 203, 204, 206, 300, 301, 308, 404, 405, 410, 414, 501:
+		return true
+	}
+	return false
+}
+
+// isUnsafeMethod reports whether requests with method may change resources
+func isUnsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// isServerErrorStatus reports whether a status lets stale-if-error apply
+func isServerErrorStatus(status int) bool {
+	switch status {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// varyHeaders returns the canonical names of the request headers selecting
+// the response, sorted
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, line := range header.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// normalizeHeaderValue joins header values with normalized whitespace
+func normalizeHeaderValue(values []string) string {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.Join(strings.Fields(part), " "); part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// sameETag reports whether a 304 response refers to the stored response
+func sameETag(entry *httpCacheEntry, header http.Header) bool {
+	etag := header.Get("ETag")
+	return etag == "" || strings.TrimPrefix(etag, "W/") == strings.TrimPrefix(entry.Header.Get("ETag"), "W/")
+}
+
+// notModified reports whether the client's validators match a response with
+// header (RFC 9110 section 13.1)
+func notModified(conditional http.Header, header http.Header) bool {
+	if match := conditional.Get("If-None-Match"); match != "" {
+		etag := strings.TrimPrefix(header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(conditional.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
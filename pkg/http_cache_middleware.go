@@ -0,0 +1,183 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// HTTPCacheMiddleware creates a middleware caching responses in cache
+// following RFC 9111 for shared caches. Request and response Cache-Control
+// directives are honoured, responses are stored per Vary headers, stale
+// responses are revalidated with their ETag or Last-Modified, and
+// stale-if-error serves stored responses when the handler fails. Responses
+// to unsafe requests invalidate the stored responses of their URI.
+//
+// Responses are buffered until the handler returns. Responses that are
+// flushed, hijacked or larger than MaxBodySize are sent as they are written
+// and not stored.
+func HTTPCacheMiddleware(cache CacheManager, config HTTPCacheConfig) MiddlewareFunc {
+	return newHTTPCache(cache, config).middleware
+}
+
+// middleware serves a request from the cache or from next
+func (h *httpCache) middleware(ctx Context, next HandlerFunc) error {
+	req := ctx.Request()
+	impl, ok := ctx.(*contextImpl)
+	if !ok || req == nil || req.URL == nil || req.Header == nil || req.Header.Get("Upgrade") != "" {
+		return next(ctx)
+	}
+	rw, ok := impl.response.(*responseWriter)
+	if !ok {
+		return next(ctx)
+	}
+
+	l, cached := h.lookup(req)
+	if cached != nil {
+		return writeHTTPCacheResponse(rw, cached)
+	}
+
+	// Conditional headers of the client are replaced by the validators of
+	// the stored response while the handler runs
+	saved := req.Header.Clone()
+	for name, values := range l.validators() {
+		req.Header[name] = values
+	}
+
+	rw.mu.Lock()
+	original := rw.ResponseWriter
+	rec := &httpCacheRecorder{ResponseWriter: original, header: http.Header{}, limit: h.config.MaxBodySize}
+	rw.ResponseWriter = rec
+	rw.mu.Unlock()
+
+	err := next(ctx)
+
+	rw.mu.Lock()
+	rw.ResponseWriter = original
+	rw.mu.Unlock()
+	req.Header = saved
+
+	if rec.passthrough {
+		if l.bypass {
+			h.complete(l, req, &Response{StatusCode: rec.status, Header: rec.Header()}, err)
+		}
+		return err
+	}
+
+	var resp *Response
+	if rec.status != 0 {
+		resp = &Response{StatusCode: rec.status, Header: rec.header, Body: rec.body.Bytes(), Size: int64(rec.body.Len())}
+	}
+	sent, cacheErr := h.complete(l, req, resp, err)
+	if sent == nil {
+		return err
+	}
+
+	rw.reset()
+	if werr := writeHTTPCacheResponse(rw, sent); werr != nil && cacheErr == nil {
+		return werr
+	}
+	return cacheErr
+}
+
+// writeHTTPCacheResponse writes resp to rw
+func writeHTTPCacheResponse(rw *responseWriter, resp *Response) error {
+	header := rw.Header()
+	for name, values := range resp.Header {
+		header[name] = values
+	}
+	rw.WriteHeader(resp.StatusCode)
+	if len(resp.Body) == 0 {
+		return nil
+	}
+	_, err := rw.Write(resp.Body)
+	return err
+}
+
+// httpCacheRecorder buffers the response of a handler for the HTTP cache.
+// It passes the response through to the client, without storing it, once
+// the handler flushes, hijacks the connection or exceeds limit.
+type httpCacheRecorder struct {
+	http.ResponseWriter
+
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	limit       int64
+	passthrough bool
+}
+
+func (r *httpCacheRecorder) Header() http.Header {
+	if r.passthrough {
+		return r.ResponseWriter.Header()
+	}
+	return r.header
+}
+
+func (r *httpCacheRecorder) WriteHeader(status int) {
+	if r.passthrough {
+		r.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *httpCacheRecorder) Write(data []byte) (int, error) {
+	if r.passthrough {
+		return r.ResponseWriter.Write(data)
+	}
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if int64(r.body.Len()+len(data)) > r.limit {
+		if err := r.pass(); err != nil {
+			return 0, err
+		}
+		return r.ResponseWriter.Write(data)
+	}
+	return r.body.Write(data)
+}
+
+// Flush sends the buffered response and passes the rest through
+func (r *httpCacheRecorder) Flush() {
+	if err := r.pass(); err != nil {
+		return
+	}
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack passes the connection to the handler
+func (r *httpCacheRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	r.passthrough = true
+	return hijacker.Hijack()
+}
+
+// pass writes the buffered response and switches to passing writes through
+func (r *httpCacheRecorder) pass() error {
+	if r.passthrough {
+		return nil
+	}
+	r.passthrough = true
+
+	header := r.ResponseWriter.Header()
+	for name, values := range r.header {
+		header[name] = values
+	}
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.ResponseWriter.WriteHeader(r.status)
+	_, err := r.ResponseWriter.Write(r.body.Bytes())
+	r.body.Reset()
+	return err
+}
@@ -0,0 +1,364 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestHTTPCache creates an HTTP cache whose clock is controlled by the
+// returned clock
+func newTestHTTPCache(config HTTPCacheConfig) (*httpCache, CacheManager, *testClock) {
+	cache := NewCacheManager(CacheConfig{})
+	h := newHTTPCache(cache, config)
+	// Date headers have a precision of a second
+	clock := &testClock{now: time.Now().Truncate(time.Second)}
+	h.now = clock.Now
+	return h, cache, clock
+}
+
+// newHTTPCacheRequest creates a request for example.com with header given as
+// name and value pairs
+func newHTTPCacheRequest(method, target string, header ...string) *Request {
+	u, _ := url.Parse(target)
+	h := http.Header{}
+	for i := 0; i+1 < len(header); i += 2 {
+		h.Add(header[i], header[i+1])
+	}
+	return &Request{Method: method, URL: u, Header: h, Host: "example.com", RequestURI: u.RequestURI()}
+}
+
+// serveHTTPCache runs req through the middleware of h
+func serveHTTPCache(h *httpCache, req *Request, handler HandlerFunc) (*httptest.ResponseRecorder, error) {
+	rec := httptest.NewRecorder()
+	ctx := NewContext(req, NewResponseWriter(rec), context.Background())
+	err := h.middleware(ctx, handler)
+	return rec, err
+}
+
+// countingHandler returns a handler counting its calls, which sends body
+// with the headers given as name and value pairs
+func countingHandler(calls *int, body string, header ...string) HandlerFunc {
+	return func(ctx Context) error {
+		*calls++
+		for i := 0; i+1 < len(header); i += 2 {
+			ctx.Response().Header().Add(header[i], header[i+1])
+		}
+		return ctx.String(http.StatusOK, body)
+	}
+}
+
+func TestHTTPCacheMiddleware_FreshAndRevalidated(t *testing.T) {
+	h, _, clock := newTestHTTPCache(HTTPCacheConfig{})
+	calls := 0
+	handler := func(ctx Context) error {
+		calls++
+		ctx.SetHeader("Cache-Control", "max-age=60")
+		ctx.SetHeader("ETag", `"v1"`)
+		if ctx.GetHeader("If-None-Match") == `"v1"` {
+			ctx.Response().WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		return ctx.String(http.StatusOK, "hello")
+	}
+
+	rec, _ := serveHTTPCache(h, newHTTPCacheRequest("GET", "/page"), handler)
+	if got := rec.Header().Get("Cache-Status"); got != "rockstar; fwd=uri-miss; fwd-status=200; stored" {
+		t.Errorf("Unexpected Cache-Status %q", got)
+	}
+
+	clock.Advance(10 * time.Second)
+	rec, _ = serveHTTPCache(h, newHTTPCacheRequest("GET", "/page"), handler)
+	if calls != 1 || rec.Body.String() != "hello" || rec.Header().Get("Age") != "10" {
+		t.Errorf("Expected a cache hit aged 10s, got %d calls, %q, Age %q", calls, rec.Body.String(), rec.Header().Get("Age"))
+	}
+	if got := rec.Header().Get("Cache-Status"); got != "rockstar; hit; ttl=50" {
+		t.Errorf("Unexpected Cache-Status %q", got)
+	}
+
+	// Stale responses are revalidated with their ETag
+	clock.Advance(time.Minute)
+	rec, _ = serveHTTPCache(h, newHTTPCacheRequest("GET", "/page"), handler)
+	if calls != 2 || rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Errorf("Expected the revalidated response, got %d calls, %d %q", calls, rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Cache-Status"); got != "rockstar; fwd=stale; fwd-status=304" {
+		t.Errorf("Unexpected Cache-Status %q", got)
+	}
+
+	// Conditional requests of clients are answered from the cache
+	rec, _ = serveHTTPCache(h, newHTTPCacheRequest("GET", "/page", "If-None-Match", `W/"v0", "v1"`), handler)
+	if calls != 2 || rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("Expected 304 from the cache, got %d calls, %d", calls, rec.Code)
+	}
+
+	// HEAD requests are answered without a body
+	rec, _ = serveHTTPCache(h, newHTTPCacheRequest("HEAD", "/page"), handler)
+	if calls != 2 || rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("Expected a HEAD response from the cache, got %d calls, %d %q", calls, rec.Code, rec.Body.String())
+	}
+}
+
+func TestHTTPCacheMiddleware_Directives(t *testing.T) {
+	tests := []struct {
+		name    string
+		request []string
+		header  []string
+		stored  bool
+	}{
+		{"max-age", nil, []string{"Cache-Control", "max-age=60"}, true},
+		{"no explicit freshness", nil, nil, false},
+		{"Last-Modified", nil, []string{"Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT"}, true},
+		{"no-store", nil, []string{"Cache-Control", "no-store, max-age=60"}, false},
+		{"private", nil, []string{"Cache-Control", "private, max-age=60"}, false},
+		{"Vary *", nil, []string{"Cache-Control", "max-age=60", "Vary", "*"}, false},
+		{"request no-store", []string{"Cache-Control", "no-store"}, []string{"Cache-Control", "max-age=60"}, false},
+		{"Authorization", []string{"Authorization", "Bearer x"}, []string{"Cache-Control", "max-age=60"}, false},
+		{"Authorization public", []string{"Authorization", "Bearer x"}, []string{"Cache-Control", "public, max-age=60"}, true},
+		{"Set-Cookie", nil, []string{"Cache-Control", "max-age=60", "Set-Cookie", "id=1"}, false},
+		{"Set-Cookie2", nil, []string{"Cache-Control", "max-age=60", "Set-Cookie2", "id=1"}, false},
+		{"Set-Cookie public", nil, []string{"Cache-Control", "public, max-age=60", "Set-Cookie", "id=1"}, true},
+		{"expired", nil, []string{"Expires", "0"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, cache, _ := newTestHTTPCache(HTTPCacheConfig{})
+			calls := 0
+			rec, _ := serveHTTPCache(h, newHTTPCacheRequest("GET", "/page", tt.request...), countingHandler(&calls, "hello", tt.header...))
+			if stored := strings.HasSuffix(rec.Header().Get("Cache-Status"), "; stored"); stored != tt.stored {
+				t.Errorf("Expected stored=%v, got Cache-Status %q", tt.stored, rec.Header().Get("Cache-Status"))
+			}
+			if _, err := cache.Get("http:example.com/page"); (err == nil) != tt.stored {
+				t.Errorf("Expected stored=%v, got %v", tt.stored, err)
+			}
+		})
+	}
+}
+
+func TestHTTPCacheMiddleware_CookiesNotStored(t *testing.T) {
+	h, cache, clock := newTestHTTPCache(HTTPCacheConfig{})
+	calls := 0
+	handler := func(ctx Context) error {
+		calls++
+		ctx.SetHeader("Cache-Control", "public, max-age=60")
+		ctx.SetHeader("ETag", `"v1"`)
+		ctx.Response().Header().Add("Set-Cookie", "session=client-"+strconv.Itoa(calls))
+		ctx.Response().Header().Add("Set-Cookie2", "legacy=1")
+		if ctx.GetHeader("If-None-Match") == `"v1"` {
+			ctx.Response().WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		return ctx.String(http.StatusOK, "hello")
+	}
+
+	rec, _ := serveHTTPCache(h, newHTTPCacheRequest("GET", "/page"), handler)
+	if rec.Header().Get("Set-Cookie") != "session=client-1" {
+		t.Errorf("Expected the forwarded response to set its cookie, got %q", rec.Header().Get("Set-Cookie"))
+	}
+	value, err := cache.Get(h.variantKey("http:example.com/page", nil, http.Header{}))
+	if err != nil {
+		t.Fatalf("Expected the response to be stored: %v", err)
+	}
+	if entry := value.(httpCacheEntry); entry.Header.Get("Set-Cookie") != "" || entry.Header.Get("Set-Cookie2") != "" {
+		t.Errorf("Expected the stored response without cookies, got %v", entry.Header)
+	}
+
+	rec, _ = serveHTTPCache(h, newHTTPCacheRequest("GET", "/page"), handler)
+	if calls != 1 || rec.Header().Get("Set-Cookie") != "" || rec.Header().Get("Set-Cookie2") != "" {
+		t.Errorf("Expected a cache hit without cookies, got %d calls, %v", calls, rec.Header())
+	}
+
+	// The client revalidating the response gets the cookie of the 304, later clients do not
+	clock.Advance(2 * time.Minute)
+	rec, _ = serveHTTPCache(h, newHTTPCacheRequest("GET", "/page"), handler)
+	if calls != 2 || rec.Header().Get("Set-Cookie") != "session=client-2" {
+		t.Errorf("Expected the revalidated response to set its cookie, got %d calls, %q", calls, rec.Header().Get("Set-Cookie"))
+	}
+	rec, _ = serveHTTPCache(h, newHTTPCacheRequest("GET", "/page"), handler)
+	if calls != 2 || rec.Header().Get("Set-Cookie") != "" {
+		t.Errorf("Expected a cache hit without cookies, got %d calls, %q", calls, rec.Header().Get("Set-Cookie"))
+	}
+}
+
+func TestHTTPCacheMiddleware_RequestDirectives(t *testing.T) {
+	h, _, clock := newTestHTTPCache(HTTPCacheConfig{})
+	calls := 0
+	handler := countingHandler(&calls, "hello", "Cache-Control", "max-age=60")
+
+	rec, _ := serveHTTPCache(h, newHTTPCacheRequest("GET", "/page", "Cache-Control", "only-if-cached"), handler)
+	if calls != 0 || rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504 for only-if-cached, got %d calls, %d", calls, rec.Code)
+	}
+
+	serveHTTPCache(h, newHTTPCacheRequest("GET", "/page"), handler)
+
+	// Each forwarded request stores a new response
+	for _, tt := range []struct {
+		advance   time.Duration
+		directive string
+		calls     int
+	}{
+		{30 * time.Second, "max-age=40", 1},
+		{0, "min-fresh=20", 1},
+		{0, "min-fresh=45", 2},
+		{30 * time.Second, "max-age=10", 3},
+		{0, "no-cache", 4},
+	} {
+		clock.Advance(tt.advance)
+		serveHTTPCache(h, newHTTPCacheRequest("GET", "/page", "Cache-Control", tt.directive), handler)
+		if calls != tt.calls {
+			t.Errorf("%s: expected %d calls, got %d", tt.directive, tt.calls, calls)
+		}
+	}
+
+	// max-stale accepts stale responses
+	clock.Advance(2 * time.Minute)
+	if rec, _ = serveHTTPCache(h, newHTTPCacheRequest("GET", "/page", "Cache-Control", "max-stale=150"), handler); calls != 4 || rec.Body.String() != "hello" {
+		t.Errorf("Expected a stale response for max-stale, got %d calls", calls)
+	}
+	if serveHTTPCache(h, newHTTPCacheRequest("GET", "/page", "Cache-Control", "max-stale=30"), handler); calls != 5 {
+		t.Errorf("Expected a request for a response staler than max-stale, got %d calls", calls)
+	}
+}
+
+func TestHTTPCacheMiddleware_Vary(t *testing.T) {
+	h, _, _ := newTestHTTPCache(HTTPCacheConfig{})
+	calls := 0
+	handler := func(ctx Context) error {
+		calls++
+		ctx.SetHeader("Cache-Control", "max-age=60")
+		ctx.SetHeader("Vary", "Accept-Language")
+		return ctx.String(http.StatusOK, "hello "+ctx.GetHeader("Accept-Language"))
+	}
+
+	for _, lang := range []string{"en", "de", "en", "de"} {
+		rec, _ := serveHTTPCache(h, newHTTPCacheRequest("GET", "/page", "Accept-Language", lang), handler)
+		if rec.Body.String() != "hello "+lang {
+			t.Errorf("Expected the %s variant, got %q", lang, rec.Body.String())
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected one call per variant, got %d", calls)
+	}
+	rec, _ := serveHTTPCache(h, newHTTPCacheRequest("GET", "/page", "Accept-Language", "fr"), handler)
+	if got := rec.Header().Get("Cache-Status"); !strings.Contains(got, "fwd=vary-miss") {
+		t.Errorf("Expected a vary miss, got %q", got)
+	}
+}
+
+func TestHTTPCacheMiddleware_StaleIfError(t *testing.T) {
+	h, _, clock := newTestHTTPCache(HTTPCacheConfig{})
+	failing := func(ctx Context) error { return errors.New("database down") }
+	unavailable := func(ctx Context) error { return ctx.String(http.StatusServiceUnavailable, "down") }
+
+	calls := 0
+	serveHTTPCache(h, newHTTPCacheRequest("GET", "/ok"), countingHandler(&calls, "cached", "Cache-Control", "max-age=10, stale-if-error=60"))
+	serveHTTPCache(h, newHTTPCacheRequest("GET", "/strict"), countingHandler(&calls, "cached", "Cache-Control", "max-age=10, stale-if-error=60, must-revalidate"))
+	clock.Advance(30 * time.Second)
+
+	rec, err := serveHTTPCache(h, newHTTPCacheRequest("GET", "/ok"), failing)
+	if err != nil || rec.Code != http.StatusOK || rec.Body.String() != "cached" {
+		t.Errorf("Expected the stale response, got %v, %d %q", err, rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Cache-Status"); got != "rockstar; fwd=stale; detail=stale-if-error" {
+		t.Errorf("Unexpected Cache-Status %q", got)
+	}
+	if rec, _ = serveHTTPCache(h, newHTTPCacheRequest("GET", "/ok"), unavailable); rec.Body.String() != "cached" {
+		t.Errorf("Expected the stale response for a 503, got %d %q", rec.Code, rec.Body.String())
+	}
+	if _, err = serveHTTPCache(h, newHTTPCacheRequest("GET", "/strict"), failing); err == nil {
+		t.Error("Expected must-revalidate to prevent stale-if-error")
+	}
+
+	clock.Advance(time.Minute)
+	if rec, _ = serveHTTPCache(h, newHTTPCacheRequest("GET", "/ok"), unavailable); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the error after stale-if-error, got %d", rec.Code)
+	}
+}
+
+func TestHTTPCacheMiddleware_Invalidation(t *testing.T) {
+	h, cache, _ := newTestHTTPCache(HTTPCacheConfig{})
+	calls := 0
+	handler := countingHandler(&calls, "product", "Cache-Control", "max-age=60", "Surrogate-Key", "product-1 products")
+
+	rec, _ := serveHTTPCache(h, newHTTPCacheRequest("GET", "/products/1"), handler)
+	if rec.Header().Get("Surrogate-Key") != "" {
+		t.Error("Expected the surrogate keys not to be sent")
+	}
+	serveHTTPCache(h, newHTTPCacheRequest("GET", "/products/1?view=full"), handler)
+	serveHTTPCache(h, newHTTPCacheRequest("GET", "/products/1"), handler)
+	if calls != 2 {
+		t.Fatalf("Expected 2 calls, got %d", calls)
+	}
+
+	// Unsafe requests invalidate their URI
+	serveHTTPCache(h, newHTTPCacheRequest("PUT", "/products/1"), func(ctx Context) error { return ctx.String(http.StatusNoContent, "") })
+	serveHTTPCache(h, newHTTPCacheRequest("GET", "/products/1"), handler)
+	serveHTTPCache(h, newHTTPCacheRequest("GET", "/products/1?view=full"), handler)
+	if calls != 3 {
+		t.Errorf("Expected the PUT to invalidate /products/1 only, got %d calls", calls)
+	}
+
+	// Surrogate keys are tags
+	cache.(TaggedCache).InvalidateTag("product-1")
+	serveHTTPCache(h, newHTTPCacheRequest("GET", "/products/1"), handler)
+	serveHTTPCache(h, newHTTPCacheRequest("GET", "/products/1?view=full"), handler)
+	if calls != 5 {
+		t.Errorf("Expected the purge to invalidate both responses, got %d calls", calls)
+	}
+}
+
+func TestHTTPCacheMiddleware_InvalidationWithoutTags(t *testing.T) {
+	// The embedded interface hides the TaggedCache methods
+	h := newHTTPCache(struct{ CacheManager }{NewCacheManager(CacheConfig{})}, HTTPCacheConfig{})
+	calls := 0
+	handler := countingHandler(&calls, "product", "Cache-Control", "max-age=60", "Vary", "Accept-Language")
+
+	for _, lang := range []string{"en", "de", "en", "de"} {
+		serveHTTPCache(h, newHTTPCacheRequest("GET", "/products/1", "Accept-Language", lang), handler)
+	}
+	if calls != 2 {
+		t.Fatalf("Expected 2 calls, got %d", calls)
+	}
+
+	serveHTTPCache(h, newHTTPCacheRequest("DELETE", "/products/1"), func(ctx Context) error { return ctx.String(http.StatusNoContent, "") })
+	for _, lang := range []string{"en", "de"} {
+		serveHTTPCache(h, newHTTPCacheRequest("GET", "/products/1", "Accept-Language", lang), handler)
+	}
+	if calls != 4 {
+		t.Errorf("Expected the DELETE to invalidate every variant, got %d calls", calls)
+	}
+}
+
+func TestHTTPCacheMiddleware_StreamedResponses(t *testing.T) {
+	h, _, _ := newTestHTTPCache(HTTPCacheConfig{MaxBodySize: 8})
+	calls := 0
+	large := countingHandler(&calls, "larger than eight bytes", "Cache-Control", "max-age=60")
+	flushed := func(ctx Context) error {
+		calls++
+		ctx.SetHeader("Cache-Control", "max-age=60")
+		ctx.String(http.StatusOK, "a")
+		ctx.Response().Flush()
+		_, err := ctx.Response().Write([]byte("b"))
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec, _ := serveHTTPCache(h, newHTTPCacheRequest("GET", "/large"), large); rec.Body.String() != "larger than eight bytes" {
+			t.Errorf("Unexpected body %q", rec.Body.String())
+		}
+		if rec, _ := serveHTTPCache(h, newHTTPCacheRequest("GET", "/events"), flushed); rec.Body.String() != "ab" || !rec.Flushed {
+			t.Errorf("Expected a flushed body, got %q", rec.Body.String())
+		}
+	}
+	if calls != 4 {
+		t.Errorf("Expected streamed responses not to be stored, got %d calls", calls)
+	}
+}
@@ -0,0 +1,567 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultJobQueue is the queue of jobs enqueued without JobOptions.Queue
+const DefaultJobQueue = "default"
+
+// Job states stored in background_jobs.status
+const (
+	JobStatusPending   = "pending"
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusDead      = "dead"
+)
+
+// maxJobErrorLength bounds the error message stored with a failed job
+const maxJobErrorLength = 1000
+
+// JobConfig configures a JobQueue
+type JobConfig struct {
+	// Workers maps the queues this instance works on to their number of
+	// concurrent workers.
+	// Default: {"default": 10}
+	Workers map[string]int
+
+	// PollInterval is how often idle workers look for due jobs.
+	// Default: 1 second
+	PollInterval time.Duration
+
+	// MaxAttempts is the number of attempts after which a failing job is
+	// moved to the dead-letter queue, unless the job sets its own.
+	// Default: 10
+	MaxAttempts int
+
+	// RetryBackoff is the delay before the first retry, doubled for each further retry.
+	// Default: 1 second
+	RetryBackoff time.Duration
+
+	// MaxRetryBackoff caps the delay between retries.
+	// Default: 1 hour
+	MaxRetryBackoff time.Duration
+
+	// LeaseTimeout is how long a job stays claimed without a heartbeat from
+	// its worker. Jobs of a worker that dies are retried after it expires.
+	// Default: 5 minutes
+	LeaseTimeout time.Duration
+
+	// Retention is how long completed jobs are kept before they are deleted.
+	// Default: 24 hours
+	Retention time.Duration
+}
+
+// Job is a unit of background work
+type Job struct {
+	ID          string          `json:"id"`
+	Queue       string          `json:"queue"`
+	Name        string          `json:"name"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"` // Including the running attempt
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	UniqueKey   string          `json:"unique_key,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// Decode unmarshals the job's payload into v
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// JobHandler runs a job. A returned error or panic schedules a retry. ctx is
+// canceled when the queue shuts down before the job finishes.
+type JobHandler func(ctx context.Context, job *Job) error
+
+// JobOptions controls how a job is queued
+type JobOptions struct {
+	// Queue is the queue the job is added to.
+	// Default: DefaultJobQueue
+	Queue string
+
+	// Delay postpones the job. Ignored when RunAt is set.
+	Delay time.Duration
+
+	// RunAt schedules the job for a point in time
+	RunAt time.Time
+
+	// MaxAttempts overrides JobConfig.MaxAttempts for this job
+	MaxAttempts int
+
+	// UniqueKey makes the job unique: while a job with the same key is
+	// pending or running, enqueuing returns that job's ID instead.
+	UniqueKey string
+}
+
+// JobQueue runs background jobs. Jobs are persisted in the background_jobs
+// table, so they survive restarts and are shared by all instances; without a
+// database they are kept in memory. Jobs run at least once: a job whose worker
+// dies is run again, so handlers should be idempotent.
+type JobQueue interface {
+	// Register sets the handler for jobs named name. Register handlers
+	// before Start; jobs without a handler fail and are retried.
+	Register(name string, handler JobHandler) error
+
+	// Enqueue adds a job to the default queue and returns its ID. payload is
+	// encoded as JSON. Through Context.Jobs() the job joins the request's
+	// WithTx transaction and is discarded if it rolls back.
+	Enqueue(ctx context.Context, name string, payload interface{}) (string, error)
+
+	// EnqueueWithOptions adds a delayed, scheduled or unique job, or a job on another queue
+	EnqueueWithOptions(ctx context.Context, name string, payload interface{}, opts JobOptions) (string, error)
+
+	// EnqueueTx adds a job in tx. It only becomes visible to workers when tx commits.
+	EnqueueTx(ctx context.Context, tx Transaction, name string, payload interface{}, opts JobOptions) (string, error)
+
+	// Start starts the workers of JobConfig.Workers. It does nothing if no
+	// handlers are registered or the workers are already running.
+	Start() error
+
+	// Shutdown stops taking new jobs and waits for running jobs until ctx is
+	// done. Jobs still running then have their context canceled and are
+	// retried later.
+	Shutdown(ctx context.Context) error
+
+	// DeadJobs returns up to limit jobs of queue that exhausted their attempts
+	DeadJobs(queue string, limit int) ([]*Job, error)
+
+	// RetryDeadJob moves a dead job back to its queue with a fresh attempt count
+	RetryDeadJob(id string) error
+}
+
+// jobQueue implements JobQueue
+type jobQueue struct {
+	store    jobStore
+	inMemory bool
+	config   JobConfig
+	metrics  MetricsCollector
+	now      func() time.Time
+
+	mu          sync.Mutex
+	handlers    map[string]JobHandler
+	wake        map[string]chan struct{}
+	stop        chan struct{}
+	runCtx      context.Context
+	cancelRun   context.CancelFunc
+	pollers     sync.WaitGroup
+	running     sync.WaitGroup
+	lastCleanup time.Time
+}
+
+// NewJobQueue creates a job queue on db. Call Migrate before using it; the
+// background_jobs table is created by a framework migration. metrics is optional.
+func NewJobQueue(db DatabaseManager, config JobConfig, metrics MetricsCollector) JobQueue {
+	config.ApplyDefaults()
+
+	q := &jobQueue{
+		config:   config,
+		metrics:  metrics,
+		now:      time.Now,
+		handlers: make(map[string]JobHandler),
+		wake:     make(map[string]chan struct{}, len(config.Workers)),
+	}
+	if isNoopDatabase(db) {
+		q.store = newInMemoryJobStore()
+		q.inMemory = true
+	} else {
+		q.store = &databaseJobStore{db: PrimaryDatabase(db)}
+	}
+	for queue := range config.Workers {
+		q.wake[queue] = make(chan struct{}, 1)
+	}
+	return q
+}
+
+// Register sets the handler for jobs named name
+func (q *jobQueue) Register(name string, handler JobHandler) error {
+	if name == "" {
+		return errors.New("job name cannot be empty")
+	}
+	if handler == nil {
+		return errors.New("job handler cannot be nil")
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, exists := q.handlers[name]; exists {
+		return fmt.Errorf("job %s is already registered", name)
+	}
+	q.handlers[name] = handler
+	return nil
+}
+
+// Enqueue adds a job to the default queue
+func (q *jobQueue) Enqueue(ctx context.Context, name string, payload interface{}) (string, error) {
+	return q.enqueue(ctx, nil, name, payload, JobOptions{})
+}
+
+// EnqueueWithOptions adds a job as described by opts
+func (q *jobQueue) EnqueueWithOptions(ctx context.Context, name string, payload interface{}, opts JobOptions) (string, error) {
+	return q.enqueue(ctx, nil, name, payload, opts)
+}
+
+// EnqueueTx adds a job in tx
+func (q *jobQueue) EnqueueTx(ctx context.Context, tx Transaction, name string, payload interface{}, opts JobOptions) (string, error) {
+	if tx == nil {
+		return "", errors.New("transaction cannot be nil")
+	}
+	return q.enqueue(ctx, tx, name, payload, opts)
+}
+
+// enqueue stores a new job, in tx if it is not nil
+func (q *jobQueue) enqueue(ctx context.Context, tx Transaction, name string, payload interface{}, opts JobOptions) (string, error) {
+	if name == "" {
+		return "", errors.New("job name cannot be empty")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode payload of job %s: %w", name, err)
+	}
+
+	now := q.now().UTC()
+	job := &Job{
+		ID:          generateUUIDv7(),
+		Queue:       opts.Queue,
+		Name:        name,
+		Payload:     data,
+		Status:      JobStatusPending,
+		MaxAttempts: opts.MaxAttempts,
+		RunAt:       now,
+		UniqueKey:   opts.UniqueKey,
+		CreatedAt:   now,
+	}
+	if job.Queue == "" {
+		job.Queue = DefaultJobQueue
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = q.config.MaxAttempts
+	}
+	if !opts.RunAt.IsZero() {
+		job.RunAt = opts.RunAt.UTC()
+	} else if opts.Delay > 0 {
+		job.RunAt = now.Add(opts.Delay)
+	}
+
+	id, err := q.store.insert(ctx, tx, job)
+	if err != nil {
+		return "", fmt.Errorf("failed to enqueue job %s: %w", name, err)
+	}
+	if id == job.ID {
+		q.count("jobs.enqueued", job)
+		if !job.RunAt.After(now) {
+			q.signal(job.Queue)
+		}
+	}
+	return id, nil
+}
+
+// Start starts the workers of JobConfig.Workers
+func (q *jobQueue) Start() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.stop != nil || len(q.handlers) == 0 {
+		return nil
+	}
+	if q.inMemory {
+		fmt.Println("WARN: JobQueue using in-memory storage. Queued jobs will be lost on restart.")
+	}
+
+	q.stop = make(chan struct{})
+	q.runCtx, q.cancelRun = context.WithCancel(context.Background())
+	for queue, workers := range q.config.Workers {
+		if workers <= 0 {
+			continue
+		}
+		q.pollers.Add(1)
+		go q.poll(queue, workers, q.stop, q.runCtx)
+	}
+	return nil
+}
+
+// Shutdown stops the workers and waits for running jobs until ctx is done
+func (q *jobQueue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	stop, cancelRun := q.stop, q.cancelRun
+	q.stop = nil
+	q.mu.Unlock()
+
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	q.pollers.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		q.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// Canceled jobs are put back into their queue by their workers
+		fmt.Println("WARN: JobQueue shutdown timed out, canceling running jobs")
+		cancelRun()
+		return nil
+	}
+	cancelRun()
+	return nil
+}
+
+// poll claims due jobs of queue whenever one of its workers is free
+func (q *jobQueue) poll(queue string, workers int, stop chan struct{}, runCtx context.Context) {
+	defer q.pollers.Done()
+
+	slots := make(chan struct{}, workers)
+	ticker := time.NewTicker(q.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		q.cleanup()
+
+		if free := workers - len(slots); free > 0 {
+			jobs, owner, err := q.store.claim(runCtx, queue, free, q.now().UTC(), q.config.LeaseTimeout)
+			if err != nil && runCtx.Err() == nil {
+				fmt.Printf("WARN: Failed to claim jobs of queue %s: %v\n", queue, err)
+			}
+
+			for _, job := range jobs {
+				slots <- struct{}{}
+				q.running.Add(1)
+				go func(job *Job) {
+					defer func() {
+						<-slots
+						q.running.Done()
+						q.signal(queue)
+					}()
+					q.execute(runCtx, job, owner)
+				}(job)
+			}
+			if len(jobs) > 0 && len(jobs) == free {
+				// More jobs may be due once a worker is free
+				continue
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-q.wakeChannel(queue):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// execute runs a claimed job and records its outcome
+func (q *jobQueue) execute(runCtx context.Context, job *Job, owner string) {
+	if job.Attempts > job.MaxAttempts {
+		// The job's earlier workers died while running it
+		q.bury(job, owner, errors.New("attempts exhausted by lost workers"))
+		return
+	}
+
+	q.mu.Lock()
+	handler := q.handlers[job.Name]
+	q.mu.Unlock()
+	if handler == nil {
+		q.fail(job, owner, fmt.Errorf("no handler registered for job %s", job.Name))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(runCtx)
+	defer cancel()
+	stopHeartbeat := q.heartbeat(job, owner)
+
+	tags := jobTags(job)
+	if q.metrics != nil {
+		_ = q.metrics.IncrementGauge("jobs.running", 1, tags)
+		defer q.metrics.DecrementGauge("jobs.running", 1, tags)
+	}
+
+	start := time.Now()
+	err := callJobHandler(ctx, handler, job)
+	stopHeartbeat()
+	if q.metrics != nil {
+		_ = q.metrics.RecordTiming("jobs.duration", time.Since(start), tags)
+	}
+
+	switch {
+	case err == nil:
+		if err := q.store.complete(context.Background(), job, owner, q.now().UTC()); err != nil {
+			fmt.Printf("WARN: Failed to complete job %s (%s): %v\n", job.ID, job.Name, err)
+		}
+		q.count("jobs.completed", job)
+	case runCtx.Err() != nil:
+		// Interrupted by shutdown; run again without waiting for a backoff
+		if err := q.store.retry(context.Background(), job, owner, q.now().UTC(), "interrupted by shutdown"); err != nil {
+			fmt.Printf("WARN: Failed to requeue job %s (%s): %v\n", job.ID, job.Name, err)
+		}
+	default:
+		q.fail(job, owner, err)
+	}
+}
+
+// fail schedules a retry of job or moves it to the dead-letter queue
+func (q *jobQueue) fail(job *Job, owner string, cause error) {
+	q.count("jobs.failed", job)
+	if job.Attempts >= job.MaxAttempts {
+		q.bury(job, owner, cause)
+		return
+	}
+
+	runAt := q.now().UTC().Add(q.backoff(job.Attempts))
+	if err := q.store.retry(context.Background(), job, owner, runAt, jobError(cause)); err != nil {
+		fmt.Printf("WARN: Failed to schedule retry of job %s (%s): %v\n", job.ID, job.Name, err)
+	}
+	q.count("jobs.retried", job)
+}
+
+// bury moves job to the dead-letter queue
+func (q *jobQueue) bury(job *Job, owner string, cause error) {
+	fmt.Printf("WARN: Job %s (%s) moved to the dead-letter queue after %d attempts: %v\n", job.ID, job.Name, job.Attempts, cause)
+	if err := q.store.bury(context.Background(), job, owner, q.now().UTC(), jobError(cause)); err != nil {
+		fmt.Printf("WARN: Failed to dead-letter job %s (%s): %v\n", job.ID, job.Name, err)
+	}
+	q.count("jobs.dead", job)
+}
+
+// heartbeat extends the claim of a running job until the returned function is called
+func (q *jobQueue) heartbeat(job *Job, owner string) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(q.config.LeaseTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				until := q.now().UTC().Add(q.config.LeaseTimeout)
+				if err := q.store.extend(context.Background(), job, owner, until); err != nil {
+					fmt.Printf("WARN: Failed to extend claim of job %s (%s): %v\n", job.ID, job.Name, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// backoff returns the delay before the retry following attempt
+func (q *jobQueue) backoff(attempt int) time.Duration {
+	delay := q.config.RetryBackoff
+	for i := 1; i < attempt && delay < q.config.MaxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > q.config.MaxRetryBackoff {
+		delay = q.config.MaxRetryBackoff
+	}
+	return delay
+}
+
+// cleanup deletes expired completed jobs, at most once per minute
+func (q *jobQueue) cleanup() {
+	q.mu.Lock()
+	now := q.now()
+	due := now.Sub(q.lastCleanup) >= time.Minute
+	if due {
+		q.lastCleanup = now
+	}
+	q.mu.Unlock()
+
+	if due {
+		_ = q.store.cleanup(context.Background(), now.UTC().Add(-q.config.Retention))
+	}
+}
+
+// wakeChannel returns the channel that wakes the workers of queue
+func (q *jobQueue) wakeChannel(queue string) chan struct{} {
+	return q.wake[queue]
+}
+
+// signal wakes the workers of queue if this instance runs any
+func (q *jobQueue) signal(queue string) {
+	if ch := q.wake[queue]; ch != nil {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// count increments a job counter if metrics are configured
+func (q *jobQueue) count(name string, job *Job) {
+	if q.metrics != nil {
+		_ = q.metrics.IncrementCounter(name, jobTags(job))
+	}
+}
+
+// DeadJobs returns up to limit dead jobs of queue, oldest first
+func (q *jobQueue) DeadJobs(queue string, limit int) ([]*Job, error) {
+	if queue == "" {
+		queue = DefaultJobQueue
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+	return q.store.dead(context.Background(), queue, limit)
+}
+
+// RetryDeadJob moves a dead job back to its queue
+func (q *jobQueue) RetryDeadJob(id string) error {
+	return q.store.revive(context.Background(), id, q.now().UTC())
+}
+
+// callJobHandler runs handler and turns a panic into an error
+func callJobHandler(ctx context.Context, handler JobHandler, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job %s panicked: %v", job.Name, r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+func jobTags(job *Job) map[string]string {
+	return map[string]string{"queue": job.Queue, "job": job.Name}
+}
+
+// jobError returns the error message stored with a failed job
+func jobError(err error) string {
+	message := err.Error()
+	if len(message) > maxJobErrorLength {
+		message = message[:maxJobErrorLength]
+	}
+	return message
+}
+
+// requestJobQueue is the JobQueue of a request. Jobs enqueued while the
+// request runs in WithTx are written in its transaction.
+type requestJobQueue struct {
+	JobQueue
+	ctx Context
+}
+
+func (r *requestJobQueue) Enqueue(ctx context.Context, name string, payload interface{}) (string, error) {
+	return r.EnqueueWithOptions(ctx, name, payload, JobOptions{})
+}
+
+func (r *requestJobQueue) EnqueueWithOptions(ctx context.Context, name string, payload interface{}, opts JobOptions) (string, error) {
+	if tx, ok := TxFromContext(r.ctx); ok {
+		return r.JobQueue.EnqueueTx(ctx, tx, name, payload, opts)
+	}
+	return r.JobQueue.EnqueueWithOptions(ctx, name, payload, opts)
+}
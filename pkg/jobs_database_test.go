@@ -0,0 +1,200 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// connectJobsTestDB connects to a fresh SQLite database with the framework tables
+func connectJobsTestDB(t *testing.T) DatabaseManager {
+	t.Helper()
+	dm := NewDatabaseManager()
+	if err := dm.Connect(createTestDBConfig(filepath.Join(t.TempDir(), "jobs.db"))); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { dm.Close() })
+	if err := dm.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	return dm
+}
+
+// jobStatus returns the stored status of job id, or "" if it does not exist
+func jobStatus(t *testing.T, db DatabaseManager, id string) string {
+	t.Helper()
+	var status string
+	db.QueryRow("SELECT status FROM background_jobs WHERE id = ?", id).Scan(&status)
+	return status
+}
+
+func TestIntegration_JobQueueEnqueueInTransaction(t *testing.T) {
+	dm := connectJobsTestDB(t)
+	q, _ := newTestJobQueue(t, dm, JobConfig{}, nil)
+	ctx := &contextImpl{db: dm.(requestScopedDatabase).forRequest(context.Background()), jobs: q}
+
+	var committed, rolledBack string
+	err := ctx.DB().WithTx(ctx.Context(), nil, func(tx Transaction) error {
+		var err error
+		committed, err = ctx.Jobs().Enqueue(ctx.Context(), "invoice.send", 1)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithTx failed: %v", err)
+	}
+	ctx.DB().WithTx(ctx.Context(), nil, func(tx Transaction) error {
+		rolledBack, _ = ctx.Jobs().Enqueue(ctx.Context(), "invoice.send", 2)
+		return errors.New("payment declined")
+	})
+
+	if jobStatus(t, dm, committed) != JobStatusPending {
+		t.Error("Expected the job of the committed transaction to be queued")
+	}
+	if rolledBack == "" || jobStatus(t, dm, rolledBack) != "" {
+		t.Error("Expected the job of the rolled back transaction to be discarded")
+	}
+
+	// A unique key held by a pending job returns that job
+	first, err := q.EnqueueWithOptions(context.Background(), "invoice.remind", 1, JobOptions{UniqueKey: "remind:1", Delay: time.Hour})
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	tx, _ := dm.Begin()
+	second, err := q.EnqueueTx(context.Background(), tx, "invoice.remind", 1, JobOptions{UniqueKey: "remind:1"})
+	tx.Commit()
+	if err != nil || second != first {
+		t.Errorf("Expected the unique job's ID %s, got %s (%v)", first, second, err)
+	}
+}
+
+func TestIntegration_JobQueueEnqueueInTenantTransaction(t *testing.T) {
+	dm := connectJobsTestDB(t)
+	q, _ := newTestJobQueue(t, dm, JobConfig{}, nil)
+
+	// Default row isolation treats every table but the framework's as a tenant table
+	tenants, err := NewTenantDatabaseManager(dm, TenantIsolationConfig{})
+	if err != nil {
+		t.Fatalf("NewTenantDatabaseManager failed: %v", err)
+	}
+	db, err := tenants.ForTenant(context.Background(), &Tenant{ID: "t1"})
+	if err != nil {
+		t.Fatalf("ForTenant failed: %v", err)
+	}
+	defer db.Release()
+
+	ctx := &contextImpl{ctx: context.Background(), db: db, jobs: q}
+
+	var id string
+	err = ctx.DB().WithTx(ctx.Context(), nil, func(tx Transaction) error {
+		id, err = ctx.Jobs().Enqueue(ctx.Context(), "invoice.send", 1)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Expected job to be enqueued in the tenant's transaction: %v", err)
+	}
+	if jobStatus(t, dm, id) != JobStatusPending {
+		t.Error("Expected the job of the committed transaction to be queued")
+	}
+
+	// The caller's context reaches the statement
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = ctx.DB().WithTx(ctx.Context(), nil, func(tx Transaction) error {
+		_, err := ctx.Jobs().Enqueue(canceled, "invoice.send", 2)
+		return err
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected enqueue with a canceled context to fail, got %v", err)
+	}
+}
+
+func TestIntegration_JobQueueRetriesAndDeadLetters(t *testing.T) {
+	dm := connectJobsTestDB(t)
+	metrics := NewTrackingMetricsCollector()
+	q, clock := newTestJobQueue(t, dm, JobConfig{MaxAttempts: 2, RetryBackoff: time.Minute}, metrics)
+
+	var attempts atomic.Int32
+	fail := atomic.Bool{}
+	fail.Store(true)
+	q.Register("sync.crm", func(ctx context.Context, job *Job) error {
+		attempts.Add(1)
+		if fail.Load() {
+			return errors.New("crm unavailable")
+		}
+		return nil
+	})
+	id, _ := q.Enqueue(context.Background(), "sync.crm", map[string]int{"account": 7})
+	q.Start()
+
+	waitFor(t, "first attempt", func() bool { return countCalls(metrics, "jobs.retried") == 1 })
+	clock.Advance(time.Minute)
+	waitFor(t, "job to be dead-lettered", func() bool { return jobStatus(t, dm, id) == JobStatusDead })
+
+	dead, err := q.DeadJobs(DefaultJobQueue, 10)
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("Expected one dead job, got %v (%v)", dead, err)
+	}
+	if dead[0].ID != id || dead[0].Attempts != 2 || dead[0].LastError != "crm unavailable" || string(dead[0].Payload) != `{"account":7}` {
+		t.Errorf("Unexpected dead job %+v", dead[0])
+	}
+
+	fail.Store(false)
+	if err := q.RetryDeadJob(id); err != nil {
+		t.Fatalf("RetryDeadJob failed: %v", err)
+	}
+	waitFor(t, "revived job to complete", func() bool { return jobStatus(t, dm, id) == JobStatusCompleted })
+	if attempts.Load() != 3 || countCalls(metrics, "jobs.dead") != 1 || countCalls(metrics, "jobs.completed") != 1 {
+		t.Errorf("Unexpected attempts %d or metrics %+v", attempts.Load(), metrics.GetCounterCalls())
+	}
+
+	// Completed jobs are deleted after the retention period
+	clock.Advance(25 * time.Hour)
+	waitFor(t, "completed job to be deleted", func() bool { return jobStatus(t, dm, id) == "" })
+}
+
+func TestIntegration_JobQueueClaimLease(t *testing.T) {
+	dm := connectJobsTestDB(t)
+	q, clock := newTestJobQueue(t, dm, JobConfig{LeaseTimeout: time.Minute, MaxAttempts: 2}, nil)
+	store := q.store
+	ctx := context.Background()
+
+	id, _ := q.Enqueue(ctx, "export", nil)
+
+	// A worker that dies after claiming keeps the job until its lease expires
+	jobs, owner, err := store.claim(ctx, DefaultJobQueue, 10, clock.Now().UTC(), time.Minute)
+	if err != nil || len(jobs) != 1 || jobs[0].Attempts != 1 {
+		t.Fatalf("Expected to claim the job, got %v (%v)", jobs, err)
+	}
+	if again, _, _ := store.claim(ctx, DefaultJobQueue, 10, clock.Now().UTC(), time.Minute); len(again) != 0 {
+		t.Fatal("Expected a claimed job to be skipped")
+	}
+
+	clock.Advance(2 * time.Minute)
+	taken, newOwner, err := store.claim(ctx, DefaultJobQueue, 10, clock.Now().UTC(), time.Minute)
+	if err != nil || len(taken) != 1 || taken[0].Attempts != 2 {
+		t.Fatalf("Expected the expired claim to be taken over, got %v (%v)", taken, err)
+	}
+
+	// The dead worker cannot finish the job it lost
+	store.complete(ctx, jobs[0], owner, clock.Now().UTC())
+	if jobStatus(t, dm, id) != JobStatusRunning {
+		t.Error("Expected the late worker's result to be ignored")
+	}
+
+	// A job whose workers keep dying is dead-lettered instead of run again
+	clock.Advance(2 * time.Minute)
+	lost, lostOwner, _ := store.claim(ctx, DefaultJobQueue, 10, clock.Now().UTC(), time.Minute)
+	if len(lost) != 1 || lostOwner == newOwner {
+		t.Fatalf("Expected the job to be claimed a third time, got %v", lost)
+	}
+	q.execute(ctx, lost[0], lostOwner)
+	if jobStatus(t, dm, id) != JobStatusDead {
+		t.Errorf("Expected the job to be dead-lettered, got %s", jobStatus(t, dm, id))
+	}
+}
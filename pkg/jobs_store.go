@@ -0,0 +1,392 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// jobStore persists background jobs. Claims are identified by an owner token;
+// updates of a job whose claim was lost to another worker are ignored.
+type jobStore interface {
+	// insert stores job, in tx if it is not nil. If a pending or running job
+	// holds job.UniqueKey, nothing is stored and that job's ID is returned.
+	insert(ctx context.Context, tx Transaction, job *Job) (string, error)
+	claim(ctx context.Context, queue string, limit int, now time.Time, lease time.Duration) ([]*Job, string, error)
+	extend(ctx context.Context, job *Job, owner string, until time.Time) error
+	complete(ctx context.Context, job *Job, owner string, now time.Time) error
+	retry(ctx context.Context, job *Job, owner string, runAt time.Time, lastError string) error
+	bury(ctx context.Context, job *Job, owner string, now time.Time, lastError string) error
+	dead(ctx context.Context, queue string, limit int) ([]*Job, error)
+	revive(ctx context.Context, id string, now time.Time) error
+	cleanup(ctx context.Context, before time.Time) error
+}
+
+// inMemoryJobStore keeps jobs in memory
+type inMemoryJobStore struct {
+	mu     sync.Mutex
+	jobs   map[string]*memoryJob
+	unique map[string]string // unique key -> job ID
+}
+
+// memoryJob is a job with the claim and completion state the database keeps in columns
+type memoryJob struct {
+	Job
+	owner       string
+	lockedUntil time.Time
+	completedAt time.Time
+}
+
+func newInMemoryJobStore() *inMemoryJobStore {
+	return &inMemoryJobStore{jobs: make(map[string]*memoryJob), unique: make(map[string]string)}
+}
+
+func (s *inMemoryJobStore) insert(ctx context.Context, tx Transaction, job *Job) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if job.UniqueKey != "" {
+		if id, exists := s.unique[job.UniqueKey]; exists {
+			return id, nil
+		}
+		s.unique[job.UniqueKey] = job.ID
+	}
+	s.jobs[job.ID] = &memoryJob{Job: *job}
+	return job.ID, nil
+}
+
+func (s *inMemoryJobStore) claim(ctx context.Context, queue string, limit int, now time.Time, lease time.Duration) ([]*Job, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*memoryJob
+	for _, job := range s.jobs {
+		if job.Queue != queue {
+			continue
+		}
+		if (job.Status == JobStatusPending && !job.RunAt.After(now)) ||
+			(job.Status == JobStatusRunning && job.lockedUntil.Before(now)) {
+			due = append(due, job)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].RunAt.Before(due[j].RunAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	owner := generateUUIDv7()
+	claimed := make([]*Job, 0, len(due))
+	for _, job := range due {
+		job.Status = JobStatusRunning
+		job.Attempts++
+		job.owner = owner
+		job.lockedUntil = now.Add(lease)
+		copied := job.Job
+		claimed = append(claimed, &copied)
+	}
+	return claimed, owner, nil
+}
+
+// owned returns the stored job if owner still holds its claim
+func (s *inMemoryJobStore) owned(job *Job, owner string) *memoryJob {
+	stored := s.jobs[job.ID]
+	if stored == nil || stored.owner != owner {
+		return nil
+	}
+	return stored
+}
+
+// release clears the claim and, for finished jobs, the unique key of job
+func (s *inMemoryJobStore) release(job *memoryJob, finished bool) {
+	job.owner = ""
+	job.lockedUntil = time.Time{}
+	if finished && job.UniqueKey != "" {
+		delete(s.unique, job.UniqueKey)
+		job.UniqueKey = ""
+	}
+}
+
+func (s *inMemoryJobStore) extend(ctx context.Context, job *Job, owner string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored := s.owned(job, owner); stored != nil && stored.Status == JobStatusRunning {
+		stored.lockedUntil = until
+	}
+	return nil
+}
+
+func (s *inMemoryJobStore) complete(ctx context.Context, job *Job, owner string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored := s.owned(job, owner); stored != nil {
+		stored.Status = JobStatusCompleted
+		stored.completedAt = now
+		s.release(stored, true)
+	}
+	return nil
+}
+
+func (s *inMemoryJobStore) retry(ctx context.Context, job *Job, owner string, runAt time.Time, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored := s.owned(job, owner); stored != nil {
+		stored.Status = JobStatusPending
+		stored.RunAt = runAt
+		stored.LastError = lastError
+		s.release(stored, false)
+	}
+	return nil
+}
+
+func (s *inMemoryJobStore) bury(ctx context.Context, job *Job, owner string, now time.Time, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored := s.owned(job, owner); stored != nil {
+		stored.Status = JobStatusDead
+		stored.completedAt = now
+		stored.LastError = lastError
+		s.release(stored, true)
+	}
+	return nil
+}
+
+func (s *inMemoryJobStore) dead(ctx context.Context, queue string, limit int) ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var jobs []*Job
+	for _, job := range s.jobs {
+		if job.Queue == queue && job.Status == JobStatusDead {
+			copied := job.Job
+			jobs = append(jobs, &copied)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return jobs, nil
+}
+
+func (s *inMemoryJobStore) revive(ctx context.Context, id string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job := s.jobs[id]
+	if job == nil || job.Status != JobStatusDead {
+		return fmt.Errorf("job %s is not dead", id)
+	}
+	job.Status = JobStatusPending
+	job.Attempts = 0
+	job.RunAt = now
+	job.LastError = ""
+	job.completedAt = time.Time{}
+	return nil
+}
+
+func (s *inMemoryJobStore) cleanup(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, job := range s.jobs {
+		if job.Status == JobStatusCompleted && job.completedAt.Before(before) {
+			delete(s.jobs, id)
+		}
+	}
+	return nil
+}
+
+// databaseJobStore keeps jobs in the background_jobs table. Due jobs are
+// selected and claimed in one transaction, skipping rows locked by other
+// workers where the engine supports it (FOR UPDATE SKIP LOCKED, READPAST).
+type databaseJobStore struct {
+	db DatabaseManager
+}
+
+// query loads a named query
+func (s *databaseJobStore) query(name string) (string, error) {
+	query, err := s.db.GetQuery(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to load %s query: %w", name, err)
+	}
+	return query, nil
+}
+
+func (s *databaseJobStore) insert(ctx context.Context, tx Transaction, job *Job) (string, error) {
+	query, err := s.query("insert_background_job")
+	if err != nil {
+		return "", err
+	}
+
+	var uniqueKey interface{}
+	if job.UniqueKey != "" {
+		uniqueKey = job.UniqueKey
+	}
+	args := []interface{}{job.ID, job.Queue, job.Name, string(job.Payload), job.MaxAttempts, job.RunAt, uniqueKey, job.CreatedAt}
+
+	var result sql.Result
+	if tx != nil {
+		result, err = tx.ExecContext(ctx, query, args...)
+	} else {
+		result, err = s.db.ExecContext(ctx, query, args...)
+	}
+	if err != nil {
+		return "", err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 1 || job.UniqueKey == "" {
+		return job.ID, err
+	}
+
+	// The unique key is taken; return the job holding it
+	query, err = s.query("load_background_job_by_unique_key")
+	if err != nil {
+		return "", err
+	}
+	var row *Row
+	if tx != nil {
+		row = tx.QueryRowContext(ctx, query, job.UniqueKey)
+	} else {
+		row = s.db.QueryRowContext(ctx, query, job.UniqueKey)
+	}
+	var id string
+	if err := row.Scan(&id); err != nil {
+		return "", fmt.Errorf("unique job %s was neither queued nor found: %w", job.UniqueKey, err)
+	}
+	return id, nil
+}
+
+func (s *databaseJobStore) claim(ctx context.Context, queue string, limit int, now time.Time, lease time.Duration) (jobs []*Job, owner string, err error) {
+	selectQuery, err := s.query("select_due_background_jobs")
+	if err != nil {
+		return nil, "", err
+	}
+	claimQuery, err := s.query("claim_background_job")
+	if err != nil {
+		return nil, "", err
+	}
+
+	tx, err := s.db.BeginTxContext(ctx, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, selectQuery, queue, now, now, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	due, err := scanJobs(rows)
+	if err != nil {
+		return nil, "", err
+	}
+
+	owner = generateUUIDv7()
+	until := now.Add(lease)
+	for _, job := range due {
+		result, err := tx.ExecContext(ctx, claimQuery, owner, until, job.ID, now, now)
+		if err != nil {
+			return nil, "", err
+		}
+		if affected, _ := result.RowsAffected(); affected == 1 {
+			job.Status = JobStatusRunning
+			job.Attempts++
+			jobs = append(jobs, job)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+	return jobs, owner, nil
+}
+
+func (s *databaseJobStore) extend(ctx context.Context, job *Job, owner string, until time.Time) error {
+	return s.exec(ctx, "extend_background_job_lease", until, job.ID, owner)
+}
+
+func (s *databaseJobStore) complete(ctx context.Context, job *Job, owner string, now time.Time) error {
+	return s.exec(ctx, "complete_background_job", now, job.ID, owner)
+}
+
+func (s *databaseJobStore) retry(ctx context.Context, job *Job, owner string, runAt time.Time, lastError string) error {
+	return s.exec(ctx, "retry_background_job", runAt, lastError, job.ID, owner)
+}
+
+func (s *databaseJobStore) bury(ctx context.Context, job *Job, owner string, now time.Time, lastError string) error {
+	return s.exec(ctx, "bury_background_job", now, lastError, job.ID, owner)
+}
+
+func (s *databaseJobStore) dead(ctx context.Context, queue string, limit int) ([]*Job, error) {
+	query, err := s.query("load_dead_background_jobs")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, query, queue, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanJobs(rows)
+}
+
+func (s *databaseJobStore) revive(ctx context.Context, id string, now time.Time) error {
+	query, err := s.query("revive_background_job")
+	if err != nil {
+		return err
+	}
+	result, err := s.db.ExecContext(ctx, query, now, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("job %s is not dead", id)
+	}
+	return nil
+}
+
+func (s *databaseJobStore) cleanup(ctx context.Context, before time.Time) error {
+	return s.exec(ctx, "cleanup_background_jobs", before)
+}
+
+// exec runs a named statement
+func (s *databaseJobStore) exec(ctx context.Context, name string, args ...interface{}) error {
+	query, err := s.query(name)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// scanJobs reads and closes rows of background jobs
+func scanJobs(rows *Rows) ([]*Job, error) {
+	defer rows.Close()
+
+	var jobs []*Job
+	for rows.Next() {
+		var (
+			job       Job
+			payload   string
+			uniqueKey sql.NullString
+			lastError sql.NullString
+		)
+		err := rows.Scan(&job.ID, &job.Queue, &job.Name, &payload, &job.Status, &job.Attempts,
+			&job.MaxAttempts, &job.RunAt, &uniqueKey, &lastError, &job.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		job.Payload = []byte(payload)
+		job.UniqueKey = uniqueKey.String
+		job.LastError = lastError.String
+		jobs = append(jobs, &job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
@@ -0,0 +1,329 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testClock is a clock for job queues that only moves when advanced
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestJobQueue creates a job queue on db whose clock is controlled by the returned clock
+func newTestJobQueue(t *testing.T, db DatabaseManager, config JobConfig, metrics MetricsCollector) (*jobQueue, *testClock) {
+	t.Helper()
+	if config.PollInterval == 0 {
+		config.PollInterval = 5 * time.Millisecond
+	}
+	q := NewJobQueue(db, config, metrics).(*jobQueue)
+	clock := &testClock{now: time.Now()}
+	q.now = clock.Now
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		q.Shutdown(ctx)
+	})
+	return q, clock
+}
+
+// waitFor polls condition until it holds or a timeout fails the test
+func waitFor(t *testing.T, message string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting: %s", message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func countCalls(metrics *TrackingMetricsCollector, name string) int {
+	count := 0
+	for _, call := range metrics.GetCounterCalls() {
+		if call.Name == name {
+			count++
+		}
+	}
+	return count
+}
+
+func TestJobQueue_RunsEnqueuedJobs(t *testing.T) {
+	metrics := NewTrackingMetricsCollector()
+	q, _ := newTestJobQueue(t, NewNoopDatabaseManager(), JobConfig{}, metrics)
+
+	var received atomic.Value
+	if err := q.Register("email.send", func(ctx context.Context, job *Job) error {
+		var payload struct{ To string }
+		if err := job.Decode(&payload); err != nil {
+			return err
+		}
+		received.Store(payload.To)
+		return nil
+	}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := q.Register("email.send", func(ctx context.Context, job *Job) error { return nil }); err == nil {
+		t.Error("Expected registering a job twice to fail")
+	}
+
+	id, err := q.Enqueue(context.Background(), "email.send", map[string]string{"to": "ada@example.com"})
+	if err != nil || id == "" {
+		t.Fatalf("Enqueue failed: %q (%v)", id, err)
+	}
+	if err := q.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := q.Start(); err != nil {
+		t.Errorf("Expected a second Start to be a no-op, got %v", err)
+	}
+
+	waitFor(t, "job to complete", func() bool { return countCalls(metrics, "jobs.completed") == 1 })
+	if received.Load() != "ada@example.com" {
+		t.Errorf("Unexpected payload %v", received.Load())
+	}
+	calls := metrics.GetCounterCalls()
+	if calls[0].Name != "jobs.enqueued" || calls[0].Tags["queue"] != DefaultJobQueue || calls[0].Tags["job"] != "email.send" {
+		t.Errorf("Unexpected metrics %+v", calls)
+	}
+
+	if _, err := q.Enqueue(context.Background(), "", nil); err == nil {
+		t.Error("Expected a job without name to be rejected")
+	}
+	if _, err := q.Enqueue(context.Background(), "email.send", func() {}); err == nil {
+		t.Error("Expected a payload that cannot be encoded to be rejected")
+	}
+}
+
+func TestJobQueue_RetriesWithBackoffAndDeadLetters(t *testing.T) {
+	metrics := NewTrackingMetricsCollector()
+	q, clock := newTestJobQueue(t, NewNoopDatabaseManager(), JobConfig{
+		RetryBackoff:    time.Minute,
+		MaxRetryBackoff: 3 * time.Minute,
+	}, metrics)
+
+	var attempts atomic.Int32
+	q.Register("report.build", func(ctx context.Context, job *Job) error {
+		attempts.Add(1)
+		if job.Attempts == 2 {
+			panic("out of paper")
+		}
+		return errors.New("printer offline")
+	})
+	q.EnqueueWithOptions(context.Background(), "report.build", nil, JobOptions{MaxAttempts: 3})
+	q.Start()
+
+	waitFor(t, "first attempt", func() bool { return countCalls(metrics, "jobs.retried") == 1 })
+	// The retry waits for its backoff
+	time.Sleep(20 * time.Millisecond)
+	if attempts.Load() != 1 {
+		t.Fatalf("Expected the retry to wait, got %d attempts", attempts.Load())
+	}
+
+	clock.Advance(time.Minute)
+	waitFor(t, "second attempt", func() bool { return countCalls(metrics, "jobs.retried") == 2 })
+	// The backoff doubles after each attempt
+	clock.Advance(time.Minute)
+	time.Sleep(20 * time.Millisecond)
+	if attempts.Load() != 2 {
+		t.Fatalf("Expected the backoff to double, got %d attempts", attempts.Load())
+	}
+	clock.Advance(time.Minute)
+	waitFor(t, "job to be dead-lettered", func() bool { return countCalls(metrics, "jobs.dead") == 1 })
+
+	dead, err := q.DeadJobs("", 10)
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("Expected one dead job, got %v (%v)", dead, err)
+	}
+	if dead[0].Attempts != 3 || dead[0].Status != JobStatusDead || dead[0].LastError != "printer offline" {
+		t.Errorf("Unexpected dead job %+v", dead[0])
+	}
+
+	if err := q.RetryDeadJob(dead[0].ID); err != nil {
+		t.Fatalf("RetryDeadJob failed: %v", err)
+	}
+	if err := q.RetryDeadJob(dead[0].ID); err == nil {
+		t.Error("Expected retrying a pending job to fail")
+	}
+	waitFor(t, "revived job to run", func() bool { return attempts.Load() == 4 })
+	if q.backoff(1) != time.Minute || q.backoff(2) != 2*time.Minute || q.backoff(10) != 3*time.Minute {
+		t.Errorf("Unexpected backoff %v, %v, %v", q.backoff(1), q.backoff(2), q.backoff(10))
+	}
+}
+
+func TestJobQueue_DelayedAndUniqueJobs(t *testing.T) {
+	q, clock := newTestJobQueue(t, NewNoopDatabaseManager(), JobConfig{}, nil)
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var ran []string
+	q.Register("digest", func(ctx context.Context, job *Job) error {
+		var name string
+		job.Decode(&name)
+		mu.Lock()
+		ran = append(ran, name)
+		mu.Unlock()
+		return nil
+	})
+	runs := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ran...)
+	}
+
+	first, _ := q.EnqueueWithOptions(ctx, "digest", "delayed", JobOptions{Delay: time.Hour, UniqueKey: "digest:1"})
+	second, err := q.EnqueueWithOptions(ctx, "digest", "duplicate", JobOptions{UniqueKey: "digest:1"})
+	if err != nil || second != first {
+		t.Fatalf("Expected the unique job's ID %s, got %s (%v)", first, second, err)
+	}
+	q.EnqueueWithOptions(ctx, "digest", "scheduled", JobOptions{RunAt: clock.Now().Add(30 * time.Minute)})
+	q.Start()
+
+	time.Sleep(20 * time.Millisecond)
+	if len(runs()) != 0 {
+		t.Fatalf("Expected no job to be due, got %v", runs())
+	}
+	clock.Advance(30 * time.Minute)
+	waitFor(t, "scheduled job", func() bool { return len(runs()) == 1 })
+	clock.Advance(30 * time.Minute)
+	waitFor(t, "delayed job", func() bool { return len(runs()) == 2 })
+	if got := runs(); got[0] != "scheduled" || got[1] != "delayed" {
+		t.Errorf("Unexpected runs %v", got)
+	}
+
+	// The key is free again once the job finished
+	third, _ := q.EnqueueWithOptions(ctx, "digest", "again", JobOptions{UniqueKey: "digest:1"})
+	if third == first {
+		t.Error("Expected a new job after the unique job completed")
+	}
+}
+
+func TestJobQueue_ConcurrencyLimit(t *testing.T) {
+	q, _ := newTestJobQueue(t, NewNoopDatabaseManager(), JobConfig{Workers: map[string]int{"images": 2}}, nil)
+
+	var running, peak, done atomic.Int32
+	release := make(chan struct{})
+	q.Register("resize", func(ctx context.Context, job *Job) error {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+		running.Add(-1)
+		done.Add(1)
+		return nil
+	})
+	for i := 0; i < 5; i++ {
+		q.EnqueueWithOptions(context.Background(), "resize", i, JobOptions{Queue: "images"})
+	}
+	// Jobs of queues without workers on this instance stay queued
+	q.Enqueue(context.Background(), "resize", "other")
+	q.Start()
+
+	waitFor(t, "workers to be busy", func() bool { return running.Load() == 2 })
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	waitFor(t, "jobs to complete", func() bool { return done.Load() == 5 })
+	if peak.Load() != 2 {
+		t.Errorf("Expected at most 2 concurrent jobs, got %d", peak.Load())
+	}
+	time.Sleep(20 * time.Millisecond)
+	if done.Load() != 5 {
+		t.Errorf("Expected the default queue not to be worked on, got %d jobs", done.Load())
+	}
+}
+
+func TestJobQueue_GracefulShutdown(t *testing.T) {
+	q, _ := newTestJobQueue(t, NewNoopDatabaseManager(), JobConfig{}, nil)
+
+	started := make(chan struct{}, 2)
+	var finished, canceled atomic.Int32
+	q.Register("quick", func(ctx context.Context, job *Job) error {
+		started <- struct{}{}
+		time.Sleep(20 * time.Millisecond)
+		finished.Add(1)
+		return nil
+	})
+	q.Register("stuck", func(ctx context.Context, job *Job) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		canceled.Add(1)
+		return ctx.Err()
+	})
+
+	q.Enqueue(context.Background(), "quick", nil)
+	q.Start()
+	<-started
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if finished.Load() != 1 {
+		t.Error("Expected shutdown to wait for the running job")
+	}
+
+	// A job still running at the deadline is canceled and put back
+	id, _ := q.Enqueue(context.Background(), "stuck", nil)
+	q.Start()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	q.Shutdown(ctx)
+	waitFor(t, "job to be canceled", func() bool { return canceled.Load() == 1 })
+
+	store := q.store.(*inMemoryJobStore)
+	waitFor(t, "job to be requeued", func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		job := store.jobs[id]
+		return job.Status == JobStatusPending && job.owner == ""
+	})
+	if dead, _ := q.DeadJobs("", 10); len(dead) != 0 {
+		t.Error("Expected the interrupted job not to be dead-lettered")
+	}
+}
+
+func TestJobQueue_RequestJobQueue(t *testing.T) {
+	q, _ := newTestJobQueue(t, NewNoopDatabaseManager(), JobConfig{}, nil)
+
+	ctx := &contextImpl{ctx: context.Background()}
+	if ctx.Jobs() != nil {
+		t.Error("Expected no job queue when none is configured")
+	}
+	ctx.SetJobs(q)
+
+	// Without a transaction jobs are enqueued directly
+	id, err := ctx.Jobs().Enqueue(context.Background(), "welcome", "ada")
+	if err != nil || id == "" {
+		t.Fatalf("Enqueue failed: %q (%v)", id, err)
+	}
+	if _, err := q.EnqueueTx(context.Background(), nil, "welcome", "ada", JobOptions{}); err == nil {
+		t.Error("Expected EnqueueTx without transaction to fail")
+	}
+
+	long := jobError(errors.New(strings.Repeat("x", 2*maxJobErrorLength)))
+	if len(long) != maxJobErrorLength {
+		t.Errorf("Expected stored errors to be truncated, got %d characters", len(long))
+	}
+}
@@ -35,6 +35,9 @@ func (sm *serverManager) NewServer(config ServerConfig) Server {
 	defer sm.mu.Unlock()
 
 	server := NewServer(config)
+	if impl, ok := server.(*httpServer); ok {
+		impl.hostLookup = sm.GetHostConfig
+	}
 	return server
 }
 
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"testing"
@@ -58,6 +59,34 @@ func (m *mockDatabaseForMetrics) Begin() (Transaction, error)             { retu
 func (m *mockDatabaseForMetrics) BeginTx(opts *sql.TxOptions) (Transaction, error) {
 	return nil, nil
 }
+
+func (m *mockDatabaseForMetrics) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return nil, nil
+}
+
+func (m *mockDatabaseForMetrics) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	return nil
+}
+
+func (m *mockDatabaseForMetrics) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return m.Exec(query, args...)
+}
+
+func (m *mockDatabaseForMetrics) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return m.Prepare(query)
+}
+
+func (m *mockDatabaseForMetrics) BeginTxContext(ctx context.Context, opts *sql.TxOptions) (Transaction, error) {
+	return m.BeginTx(opts)
+}
+
+func (m *mockDatabaseForMetrics) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Transaction) error) error {
+	tx, err := m.BeginTx(opts)
+	if err != nil {
+		return err
+	}
+	return fn(tx)
+}
 func (m *mockDatabaseForMetrics) SaveSession(session *Session) error { return nil }
 func (m *mockDatabaseForMetrics) LoadSession(sessionID string) (*Session, error) {
 	return nil, nil
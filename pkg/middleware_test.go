@@ -515,6 +515,7 @@ func (m *mockMiddlewareContext) Session() SessionManager                     { r
 func (m *mockMiddlewareContext) User() *User                                 { return nil }
 func (m *mockMiddlewareContext) Tenant() *Tenant                             { return nil }
 func (m *mockMiddlewareContext) DB() DatabaseManager                         { return nil }
+func (m *mockMiddlewareContext) Jobs() JobQueue                              { return nil }
 func (m *mockMiddlewareContext) Cache() CacheManager                         { return nil }
 func (m *mockMiddlewareContext) Config() ConfigManager                       { return nil }
 func (m *mockMiddlewareContext) I18n() I18nManager                           { return nil }
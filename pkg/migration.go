@@ -0,0 +1,537 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MigrationDirection is the direction in which migrations are applied
+type MigrationDirection string
+
+const (
+	MigrationUp   MigrationDirection = "up"
+	MigrationDown MigrationDirection = "down"
+)
+
+// migrationFilePattern matches NNNN_name.up.sql and NNNN_name.down.sql
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([A-Za-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change loaded from a pair of up/down files
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // SHA-256 of the up script
+}
+
+// String returns the migration identifier, e.g. "0001_create_users"
+func (m *Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// MigrationRecord is a row of the schema_migrations history table
+type MigrationRecord struct {
+	Version       int64
+	Name          string
+	Checksum      string
+	AppliedAt     time.Time
+	ExecutionTime time.Duration
+}
+
+// MigrationStatus describes the state of a single migration
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Modified  bool // Applied, but the up script changed since
+	Missing   bool // Applied, but the migration files no longer exist
+}
+
+// MigrationPlan lists the migrations an Up or Down call applies, in order
+type MigrationPlan struct {
+	Direction  MigrationDirection
+	Migrations []*Migration
+	DryRun     bool
+}
+
+// Write prints the plan to w. With includeSQL the script of every step is
+// printed as well, which is the output of a dry run.
+func (p *MigrationPlan) Write(w io.Writer, includeSQL bool) error {
+	if len(p.Migrations) == 0 {
+		_, err := fmt.Fprintln(w, "No migrations to apply.")
+		return err
+	}
+
+	for _, m := range p.Migrations {
+		if _, err := fmt.Fprintf(w, "%s %s\n", p.Direction, m); err != nil {
+			return err
+		}
+		if !includeSQL {
+			continue
+		}
+
+		script := m.Up
+		if p.Direction == MigrationDown {
+			script = m.Down
+		}
+		if _, err := fmt.Fprintf(w, "%s\n\n", strings.TrimSpace(script)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateOptions selects the migrations of an Up or Down call
+type MigrateOptions struct {
+	// Target is the version to migrate to. Up applies pending migrations up to
+	// and including Target (0 = all). Down rolls back migrations newer than
+	// Target and takes precedence over Steps.
+	Target int64
+
+	// Steps is the number of migrations Down rolls back when Target is 0.
+	// Default: 1
+	Steps int
+
+	// DryRun returns the plan without taking the lock or changing the database
+	DryRun bool
+}
+
+// MigratorConfig configures a Migrator
+type MigratorConfig struct {
+	// Dir is the directory holding the NNNN_name.up.sql/.down.sql files.
+	// Default: <sql_dir>/<driver>/migrations
+	Dir string
+
+	// LockTimeout is how long to wait for another instance to finish migrating.
+	// Default: 1 minute
+	LockTimeout time.Duration
+
+	// LockRetryInterval is how often the lock is retried while waiting.
+	// Default: 250 milliseconds
+	LockRetryInterval time.Duration
+}
+
+// Migrator applies versioned schema migrations and records them in the
+// schema_migrations table. Up and Down take a database advisory lock so that
+// only one instance migrates at a time.
+type Migrator interface {
+	// Plan returns the migrations Up or Down would apply
+	Plan(direction MigrationDirection, options MigrateOptions) (*MigrationPlan, error)
+
+	// Up applies pending migrations
+	Up(options MigrateOptions) (*MigrationPlan, error)
+
+	// Down rolls back applied migrations
+	Down(options MigrateOptions) (*MigrationPlan, error)
+
+	// Status reports every known and applied migration
+	Status() ([]MigrationStatus, error)
+}
+
+// migrator implements the Migrator interface
+type migrator struct {
+	dm         *databaseManager
+	config     MigratorConfig
+	migrations []*Migration
+}
+
+// NewMigrator creates a migrator for db, loading migrations from config.Dir
+func NewMigrator(db DatabaseManager, config MigratorConfig) (Migrator, error) {
+	dm, ok := unwrapDatabaseManager(db)
+	if !ok || dm.db == nil || dm.sqlLoader == nil {
+		return nil, errors.New("migrations require a connected database")
+	}
+
+	config.ApplyDefaults()
+	if config.Dir == "" {
+		sqlDir := "./sql"
+		if customDir, ok := dm.config.Options["sql_dir"]; ok {
+			sqlDir = customDir
+		}
+		config.Dir = filepath.Join(sqlDir, dm.sqlLoader.GetDriver(), "migrations")
+	}
+
+	migrations, err := LoadMigrations(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &migrator{dm: dm, config: config, migrations: migrations}, nil
+}
+
+// LoadMigrations reads NNNN_name.up.sql and NNNN_name.down.sql files from dir,
+// sorted by version. A missing directory yields no migrations. Every version
+// needs an up script; the down script is optional but required to roll back.
+func LoadMigrations(dir string) ([]*Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration directory %s: %w", dir, err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration filename %s: expected NNNN_name.up.sql or NNNN_name.down.sql", entry.Name())
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %s", entry.Name())
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %s has no up script", m)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Plan returns the migrations Up or Down would apply. It changes nothing in
+// the database; without a schema_migrations table no migration is applied yet.
+func (m *migrator) Plan(direction MigrationDirection, options MigrateOptions) (*MigrationPlan, error) {
+	applied, err := m.loadHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := m.plan(direction, options, applied)
+	if err != nil {
+		return nil, err
+	}
+	plan.DryRun = true
+	return plan, nil
+}
+
+// Up applies pending migrations
+func (m *migrator) Up(options MigrateOptions) (*MigrationPlan, error) {
+	return m.migrate(MigrationUp, options)
+}
+
+// Down rolls back applied migrations
+func (m *migrator) Down(options MigrateOptions) (*MigrationPlan, error) {
+	return m.migrate(MigrationDown, options)
+}
+
+// Status reports every known and applied migration, in version order. It
+// changes nothing in the database; without a schema_migrations table no
+// migration is applied yet.
+func (m *migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.loadHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	known := make(map[int64]bool, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = true
+		status := MigrationStatus{Version: mig.Version, Name: mig.Name}
+		if record, ok := applied[mig.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+			status.Modified = record.Checksum != mig.Checksum
+		}
+		statuses = append(statuses, status)
+	}
+
+	for version, record := range applied {
+		if !known[version] {
+			statuses = append(statuses, MigrationStatus{
+				Version:   version,
+				Name:      record.Name,
+				Applied:   true,
+				AppliedAt: record.AppliedAt,
+				Missing:   true,
+			})
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// migrate plans and, unless it is a dry run, applies migrations while holding
+// the migration lock
+func (m *migrator) migrate(direction MigrationDirection, options MigrateOptions) (*MigrationPlan, error) {
+	if options.DryRun {
+		return m.Plan(direction, options)
+	}
+
+	if err := m.ensureHistoryTable(); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	conn, err := m.dm.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain migration connection: %w", err)
+	}
+	defer conn.Close()
+
+	if err := m.acquireLock(ctx, conn); err != nil {
+		return nil, err
+	}
+	defer m.releaseLock(ctx, conn)
+
+	// Plan under the lock so that work done by another instance is seen
+	applied, err := m.loadApplied(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := m.plan(direction, options, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mig := range plan.Migrations {
+		if err := m.apply(ctx, conn, direction, mig); err != nil {
+			return plan, err
+		}
+	}
+	return plan, nil
+}
+
+// plan selects the migrations to apply from the applied history
+func (m *migrator) plan(direction MigrationDirection, options MigrateOptions, applied map[int64]*MigrationRecord) (*MigrationPlan, error) {
+	plan := &MigrationPlan{Direction: direction}
+
+	if direction == MigrationUp {
+		for _, mig := range m.migrations {
+			if record, ok := applied[mig.Version]; ok {
+				if record.Checksum != mig.Checksum {
+					return nil, fmt.Errorf("migration %s was modified after it was applied (checksum mismatch)", mig)
+				}
+				continue
+			}
+			if options.Target > 0 && mig.Version > options.Target {
+				break
+			}
+			plan.Migrations = append(plan.Migrations, mig)
+		}
+		return plan, nil
+	}
+
+	if direction != MigrationDown {
+		return nil, fmt.Errorf("invalid migration direction: %s", direction)
+	}
+
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+	steps := options.Steps
+	if steps <= 0 {
+		steps = 1
+	}
+
+	byVersion := make(map[int64]*Migration, len(m.migrations))
+	for _, mig := range m.migrations {
+		byVersion[mig.Version] = mig
+	}
+
+	for _, version := range versions {
+		if options.Target > 0 {
+			if version <= options.Target {
+				break
+			}
+		} else if len(plan.Migrations) >= steps {
+			break
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("cannot roll back migration %d: migration files not found", version)
+		}
+		if strings.TrimSpace(mig.Down) == "" {
+			return nil, fmt.Errorf("cannot roll back migration %s: no down script", mig)
+		}
+		plan.Migrations = append(plan.Migrations, mig)
+	}
+	return plan, nil
+}
+
+// apply runs a single migration and updates the history in one transaction
+func (m *migrator) apply(ctx context.Context, conn *sql.Conn, direction MigrationDirection, mig *Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %s: %w", mig, err)
+	}
+
+	start := time.Now()
+	script := mig.Up
+	if direction == MigrationDown {
+		script = mig.Down
+	}
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %s %s failed: %w", mig, direction, err)
+	}
+
+	if direction == MigrationUp {
+		err = m.exec(ctx, tx, "insert_schema_migration",
+			mig.Version, mig.Name, mig.Checksum, time.Now().UTC(), time.Since(start).Milliseconds())
+	} else {
+		err = m.exec(ctx, tx, "delete_schema_migration", mig.Version)
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record migration %s: %w", mig, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %s: %w", mig, err)
+	}
+	return nil
+}
+
+// ensureHistoryTable creates the history table and, where the dialect has no
+// advisory locks, the lock table
+func (m *migrator) ensureHistoryTable() error {
+	if err := m.exec(context.Background(), m.dm.db, "create_schema_migrations_table"); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// acquireLock waits up to LockTimeout for the migration lock on conn
+func (m *migrator) acquireLock(ctx context.Context, conn *sql.Conn) error {
+	query, err := m.dm.sqlLoader.GetQuery("acquire_migration_lock")
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(m.config.LockTimeout)
+	for {
+		var acquired int
+		err := conn.QueryRowContext(ctx, query).Scan(&acquired)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		if acquired == 1 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %v waiting for migration lock held by another instance", m.config.LockTimeout)
+		}
+		time.Sleep(m.config.LockRetryInterval)
+	}
+}
+
+// releaseLock releases the migration lock held by conn
+func (m *migrator) releaseLock(ctx context.Context, conn *sql.Conn) {
+	// The lock is also released when the session ends, so errors are not fatal
+	_ = m.exec(ctx, conn, "release_migration_lock")
+}
+
+// migrationQueryer is satisfied by *sql.DB, *sql.Conn and *sql.Tx
+type migrationQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// exec runs a named query from the SQL loader
+func (m *migrator) exec(ctx context.Context, q migrationQueryer, name string, args ...interface{}) error {
+	query, err := m.dm.sqlLoader.GetQuery(name)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, query, args...)
+	return err
+}
+
+// loadApplied loads the migration history keyed by version
+// loadHistory loads the applied migrations without creating the history
+// table, reporting none if it does not exist
+func (m *migrator) loadHistory() (map[int64]*MigrationRecord, error) {
+	applied, err := m.loadApplied(m.dm.db)
+	if isMissingTableError(err) {
+		return map[int64]*MigrationRecord{}, nil
+	}
+	return applied, err
+}
+
+func (m *migrator) loadApplied(q migrationQueryer) (map[int64]*MigrationRecord, error) {
+	query, err := m.dm.sqlLoader.GetQuery("load_schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(context.Background(), query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migration history: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]*MigrationRecord)
+	for rows.Next() {
+		record := &MigrationRecord{}
+		var executionMs int64
+		if err := rows.Scan(&record.Version, &record.Name, &record.Checksum, &record.AppliedAt, &executionMs); err != nil {
+			return nil, fmt.Errorf("failed to scan migration history: %w", err)
+		}
+		record.ExecutionTime = time.Duration(executionMs) * time.Millisecond
+		applied[record.Version] = record
+	}
+	return applied, rows.Err()
+}
@@ -0,0 +1,270 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeMigrationFiles writes migration scripts into dir
+func writeMigrationFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+// connectMigrationTestDB connects to a fresh SQLite database
+func connectMigrationTestDB(t *testing.T) DatabaseManager {
+	t.Helper()
+	dm := NewDatabaseManager()
+	if err := dm.Connect(createTestDBConfig(filepath.Join(t.TempDir(), "migrations.db"))); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { dm.Close() })
+	return dm
+}
+
+func TestLoadMigrations(t *testing.T) {
+	dir := t.TempDir()
+	writeMigrationFiles(t, dir, map[string]string{
+		"0002_add_email.up.sql":      "ALTER TABLE users ADD COLUMN email TEXT;",
+		"0001_create_users.up.sql":   "CREATE TABLE users (id INTEGER PRIMARY KEY);",
+		"0001_create_users.down.sql": "DROP TABLE users;",
+		"README.md":                  "ignored",
+	})
+
+	migrations, err := LoadMigrations(dir)
+	if err != nil {
+		t.Fatalf("LoadMigrations failed: %v", err)
+	}
+	if len(migrations) != 2 || migrations[0].String() != "0001_create_users" || migrations[1].Version != 2 {
+		t.Fatalf("Unexpected migrations: %v", migrations)
+	}
+	if migrations[0].Down == "" || migrations[1].Down != "" || len(migrations[0].Checksum) != 64 {
+		t.Errorf("Unexpected migration contents: %+v", migrations[0])
+	}
+
+	if migrations, err := LoadMigrations(filepath.Join(dir, "missing")); err != nil || len(migrations) != 0 {
+		t.Errorf("Expected missing directory to yield no migrations, got %v, %v", migrations, err)
+	}
+
+	writeMigrationFiles(t, dir, map[string]string{"3_bad-name.up.sql": "SELECT 1;"})
+	if _, err := LoadMigrations(dir); err == nil {
+		t.Error("Expected invalid filename to be rejected")
+	}
+}
+
+func TestIntegration_MigratorUpDownStatus(t *testing.T) {
+	dm := connectMigrationTestDB(t)
+	dir := t.TempDir()
+	writeMigrationFiles(t, dir, map[string]string{
+		"0001_create_users.up.sql":   "CREATE TABLE users (id INTEGER PRIMARY KEY);",
+		"0001_create_users.down.sql": "DROP TABLE users;",
+		"0002_add_email.up.sql":      "ALTER TABLE users ADD COLUMN email TEXT;",
+		"0002_add_email.down.sql":    "ALTER TABLE users DROP COLUMN email;",
+	})
+
+	migrator, err := NewMigrator(dm, MigratorConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("NewMigrator failed: %v", err)
+	}
+
+	// A dry run reports the plan without applying it
+	plan, err := migrator.Up(MigrateOptions{DryRun: true})
+	if err != nil || len(plan.Migrations) != 2 || !plan.DryRun {
+		t.Fatalf("Unexpected dry-run plan: %+v, %v", plan, err)
+	}
+	var out bytes.Buffer
+	if err := plan.Write(&out, true); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "up 0001_create_users\nCREATE TABLE users") {
+		t.Errorf("Unexpected plan output:\n%s", out.String())
+	}
+	if _, err := dm.Exec("SELECT id FROM users"); err == nil {
+		t.Fatal("Dry run must not change the schema")
+	}
+	if _, err := dm.Exec("SELECT version FROM schema_migrations"); err == nil {
+		t.Fatal("Dry run must not create the history table")
+	}
+
+	if plan, err := migrator.Up(MigrateOptions{Target: 1}); err != nil || len(plan.Migrations) != 1 {
+		t.Fatalf("Up to version 1 failed: %+v, %v", plan, err)
+	}
+	if plan, err := migrator.Up(MigrateOptions{}); err != nil || len(plan.Migrations) != 1 {
+		t.Fatalf("Up failed: %+v, %v", plan, err)
+	}
+	if _, err := dm.Exec("INSERT INTO users (id, email) VALUES (1, 'a@example.com')"); err != nil {
+		t.Fatalf("Expected migrated schema: %v", err)
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil || len(statuses) != 2 || !statuses[0].Applied || !statuses[1].Applied || statuses[0].AppliedAt.IsZero() {
+		t.Fatalf("Unexpected status: %+v, %v", statuses, err)
+	}
+
+	// Up is idempotent
+	if plan, err := migrator.Up(MigrateOptions{}); err != nil || len(plan.Migrations) != 0 {
+		t.Errorf("Expected nothing to apply, got %+v, %v", plan, err)
+	}
+
+	if plan, err := migrator.Down(MigrateOptions{}); err != nil || len(plan.Migrations) != 1 || plan.Migrations[0].Version != 2 {
+		t.Fatalf("Down failed: %+v, %v", plan, err)
+	}
+	if _, err := dm.Exec("SELECT email FROM users"); err == nil {
+		t.Error("Expected email column to be rolled back")
+	}
+
+	statuses, _ = migrator.Status()
+	if !statuses[0].Applied || statuses[1].Applied {
+		t.Errorf("Unexpected status after down: %+v", statuses)
+	}
+
+	// Editing an applied migration is detected
+	writeMigrationFiles(t, dir, map[string]string{
+		"0001_create_users.up.sql": "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);",
+	})
+	edited, err := NewMigrator(dm, MigratorConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("NewMigrator failed: %v", err)
+	}
+	if _, err := edited.Up(MigrateOptions{}); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Expected checksum mismatch error, got %v", err)
+	}
+	if statuses, _ := edited.Status(); !statuses[0].Modified {
+		t.Error("Expected status to report the modified migration")
+	}
+}
+
+func TestIntegration_MigratorStatusIsReadOnly(t *testing.T) {
+	dm := connectMigrationTestDB(t)
+	dir := t.TempDir()
+	writeMigrationFiles(t, dir, map[string]string{
+		"0001_create_items.up.sql": "CREATE TABLE items (id INTEGER PRIMARY KEY);",
+	})
+
+	migrator, err := NewMigrator(dm, MigratorConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("NewMigrator failed: %v", err)
+	}
+	statuses, err := migrator.Status()
+	if err != nil || len(statuses) != 1 || statuses[0].Applied {
+		t.Fatalf("Expected one pending migration, got %+v, %v", statuses, err)
+	}
+	if _, err := dm.Exec("SELECT COUNT(*) FROM schema_migrations"); err == nil {
+		t.Error("Expected Status not to create schema_migrations")
+	}
+}
+
+func TestIntegration_MigratorLock(t *testing.T) {
+	dm := connectMigrationTestDB(t)
+	dir := t.TempDir()
+	writeMigrationFiles(t, dir, map[string]string{
+		"0001_create_items.up.sql": "CREATE TABLE items (id INTEGER PRIMARY KEY);",
+	})
+
+	migrator, err := NewMigrator(dm, MigratorConfig{Dir: dir, LockTimeout: 100 * time.Millisecond, LockRetryInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewMigrator failed: %v", err)
+	}
+	// Create the history and lock tables as a migration run would
+	query, err := dm.GetQuery("create_schema_migrations_table")
+	if err != nil {
+		t.Fatalf("Failed to load query: %v", err)
+	}
+	if _, err := dm.Exec(query); err != nil {
+		t.Fatalf("Failed to create history table: %v", err)
+	}
+
+	// Simulate another instance holding the lock
+	if _, err := dm.Exec("INSERT INTO schema_migrations_lock (id) VALUES (1)"); err != nil {
+		t.Fatalf("Failed to take lock: %v", err)
+	}
+	if _, err := migrator.Up(MigrateOptions{}); err == nil || !strings.Contains(err.Error(), "lock") {
+		t.Fatalf("Expected lock timeout, got %v", err)
+	}
+
+	if _, err := dm.Exec("DELETE FROM schema_migrations_lock"); err != nil {
+		t.Fatalf("Failed to release lock: %v", err)
+	}
+	if plan, err := migrator.Up(MigrateOptions{}); err != nil || len(plan.Migrations) != 1 {
+		t.Fatalf("Expected Up to succeed once the lock is free: %+v, %v", plan, err)
+	}
+
+	var locks int
+	dm.QueryRow("SELECT COUNT(*) FROM schema_migrations_lock").Scan(&locks)
+	if locks != 0 {
+		t.Error("Expected lock to be released after migrating")
+	}
+
+	// A stale lock left behind by a crashed instance is taken over
+	if _, err := dm.Exec("INSERT INTO schema_migrations_lock (id, locked_at) VALUES (1, datetime('now', '-1 hour'))"); err != nil {
+		t.Fatalf("Failed to take stale lock: %v", err)
+	}
+	if _, err := migrator.Up(MigrateOptions{}); err != nil {
+		t.Errorf("Expected stale lock to be taken over: %v", err)
+	}
+}
+
+func TestIntegration_MigrateAppliesFrameworkMigrations(t *testing.T) {
+	dm := connectMigrationTestDB(t)
+
+	if err := dm.Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	var count int
+	if err := dm.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+		t.Fatalf("Failed to read migration history: %v", err)
+	}
+	if count == 0 {
+		t.Error("Expected framework migrations to be recorded")
+	}
+
+	// Tables added by framework migrations exist
+	for _, table := range []string{"rate_limit_state", "outbox_messages", "background_jobs", "scheduler_leases", "scheduled_task_runs", "cache_invalidations", "tenant_quota_usage"} {
+		if _, err := dm.Exec("SELECT COUNT(*) FROM " + table); err != nil {
+			t.Errorf("Expected migrated table %s: %v", table, err)
+		}
+	}
+
+	// Running again is a no-op
+	if err := dm.Migrate(); err != nil {
+		t.Fatalf("Second Migrate failed: %v", err)
+	}
+}
+
+func TestFrameworkMigrationsCoverEveryDialect(t *testing.T) {
+	var versions map[int64]string
+	for _, driver := range []string{"sqlite", "postgres", "mysql", "mssql"} {
+		migrations, err := LoadMigrations(filepath.Join("..", "sql", driver, "migrations"))
+		if err != nil {
+			t.Fatalf("Failed to load %s migrations: %v", driver, err)
+		}
+
+		found := make(map[int64]string, len(migrations))
+		for _, mig := range migrations {
+			if strings.TrimSpace(mig.Down) == "" {
+				t.Errorf("%s migration %s has no down script", driver, mig)
+			}
+			found[mig.Version] = mig.Name
+		}
+		if versions == nil {
+			versions = found
+			continue
+		}
+		if !reflect.DeepEqual(found, versions) {
+			t.Errorf("%s migrations %v differ from sqlite migrations %v", driver, found, versions)
+		}
+	}
+}
@@ -0,0 +1,494 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Outbox message states stored in outbox_messages.status
+const (
+	outboxStatusPending   = "pending"
+	outboxStatusPublished = "published"
+	outboxStatusDead      = "dead"
+)
+
+// maxOutboxErrorLength bounds the delivery error stored with a message
+const maxOutboxErrorLength = 1000
+
+// OutboxConfig configures an OutboxManager. At least one of EventBus and
+// WebhookURL must be set.
+type OutboxConfig struct {
+	// EventBus receives relayed events. Subscribers get the payload as
+	// json.RawMessage in Event.Data and the deduplication ID in Event.ID.
+	// Default: nil (no EventBus delivery)
+	EventBus EventBus
+
+	// WebhookURL receives relayed events as JSON POST requests.
+	// Default: "" (no webhook delivery)
+	WebhookURL string
+
+	// WebhookSecret signs webhook bodies with HMAC-SHA256 in the
+	// X-Outbox-Signature header. Optional.
+	// Default: "" (unsigned)
+	WebhookSecret string
+
+	// WebhookTimeout bounds a single webhook request.
+	// Default: 10 seconds
+	WebhookTimeout time.Duration
+
+	// PollInterval is how often the relay looks for due messages.
+	// Default: 1 second
+	PollInterval time.Duration
+
+	// BatchSize is the maximum number of messages claimed per poll.
+	// Default: 100
+	BatchSize int
+
+	// MaxAttempts is the number of failed deliveries after which a message
+	// is dead-lettered.
+	// Default: 10
+	MaxAttempts int
+
+	// RetryBackoff is the delay before the first retry, doubled for each further retry.
+	// Default: 1 second
+	RetryBackoff time.Duration
+
+	// MaxRetryBackoff caps the delay between retries.
+	// Default: 10 minutes
+	MaxRetryBackoff time.Duration
+
+	// LeaseTimeout is how long a claimed batch is reserved for a relay. Messages
+	// of a relay that dies are claimed again after it expires, so it must exceed
+	// the time needed to deliver a batch.
+	// Default: 1 minute
+	LeaseTimeout time.Duration
+
+	// Retention is how long published messages are kept before they are deleted.
+	// Default: 24 hours
+	Retention time.Duration
+}
+
+// OutboxMessage is an event stored in the outbox
+type OutboxMessage struct {
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`
+	Data      json.RawMessage `json:"data"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// OutboxManager publishes events reliably. Events are written to the
+// outbox_messages table in the caller's transaction and delivered by a relay
+// after the transaction commits. Delivery is at-least-once: consumers must use
+// the message ID to ignore duplicates.
+type OutboxManager interface {
+	// Enqueue writes an event to the outbox in tx and returns its ID. data is
+	// encoded as JSON. The event is lost if tx is rolled back.
+	Enqueue(tx Transaction, event string, data interface{}) (string, error)
+
+	// EnqueueWithID is like Enqueue with a caller-chosen deduplication ID.
+	// Enqueuing an ID that is already in the outbox does nothing.
+	EnqueueWithID(tx Transaction, id, event string, data interface{}) error
+
+	// Start starts relaying in the background
+	Start() error
+
+	// Stop stops the relay and waits for the current batch
+	Stop() error
+
+	// RelayOnce delivers one batch of due messages and returns how many were delivered
+	RelayOnce(ctx context.Context) (int, error)
+
+	// DeadLetters returns up to limit messages whose delivery was given up
+	DeadLetters(limit int) ([]*OutboxMessage, error)
+
+	// Requeue returns a dead-lettered message to the relay
+	Requeue(id string) error
+}
+
+// outboxManager implements OutboxManager
+type outboxManager struct {
+	db     DatabaseManager
+	config OutboxConfig
+	client *http.Client
+	now    func() time.Time
+
+	mu          sync.Mutex
+	stop        chan struct{}
+	done        sync.WaitGroup
+	lastCleanup time.Time
+}
+
+// NewOutboxManager creates an outbox on db. Call Migrate before using it; the
+// outbox_messages table is created by a framework migration.
+func NewOutboxManager(db DatabaseManager, config OutboxConfig) (OutboxManager, error) {
+	if isNoopDatabase(db) {
+		return nil, errors.New("outbox requires a configured database")
+	}
+	if config.EventBus == nil && config.WebhookURL == "" {
+		return nil, errors.New("outbox requires an EventBus or a WebhookURL")
+	}
+	config.ApplyDefaults()
+
+	return &outboxManager{
+		db:     PrimaryDatabase(db),
+		config: config,
+		client: &http.Client{Timeout: config.WebhookTimeout},
+		now:    time.Now,
+	}, nil
+}
+
+// Enqueue writes an event with a new ID to the outbox in tx
+func (o *outboxManager) Enqueue(tx Transaction, event string, data interface{}) (string, error) {
+	id := generateUUIDv7()
+	if err := o.EnqueueWithID(tx, id, event, data); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// EnqueueWithID writes an event to the outbox in tx unless id is already present
+func (o *outboxManager) EnqueueWithID(tx Transaction, id, event string, data interface{}) error {
+	if tx == nil {
+		return errors.New("outbox messages must be written in a transaction")
+	}
+	if id == "" {
+		return errors.New("outbox message ID cannot be empty")
+	}
+	if event == "" {
+		return errors.New("event name cannot be empty")
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox event %s: %w", event, err)
+	}
+
+	query, err := o.db.GetQuery("insert_outbox_message")
+	if err != nil {
+		return fmt.Errorf("failed to load insert_outbox_message query: %w", err)
+	}
+
+	now := o.now().UTC()
+	if _, err := tx.Exec(query, id, event, string(payload), now, now); err != nil {
+		return fmt.Errorf("failed to write outbox event %s: %w", event, err)
+	}
+	return nil
+}
+
+// Start starts relaying in the background
+func (o *outboxManager) Start() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.stop != nil {
+		return errors.New("outbox relay already running")
+	}
+	o.stop = make(chan struct{})
+
+	o.done.Add(1)
+	go o.relayLoop(o.stop)
+	return nil
+}
+
+// Stop stops the relay and waits for the current batch
+func (o *outboxManager) Stop() error {
+	o.mu.Lock()
+	stop := o.stop
+	o.stop = nil
+	o.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		o.done.Wait()
+	}
+	return nil
+}
+
+// relayLoop relays due messages every PollInterval until stop is closed.
+// A full batch is followed by the next one right away.
+func (o *outboxManager) relayLoop(stop chan struct{}) {
+	defer o.done.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(o.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		delivered, err := o.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("WARN: Outbox relay failed: %v\n", err)
+		}
+		if err == nil && delivered == o.config.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// RelayOnce claims a batch of due messages and delivers them
+func (o *outboxManager) RelayOnce(ctx context.Context) (int, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	o.cleanup(ctx)
+
+	messages, claim, err := o.claim(ctx)
+	if err != nil || len(messages) == 0 {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, msg := range messages {
+		if ctx.Err() != nil {
+			// Unfinished messages are claimed again when the lease expires
+			return delivered, ctx.Err()
+		}
+
+		if err := o.deliver(ctx, msg); err != nil {
+			if markErr := o.markFailed(ctx, msg, claim, err); markErr != nil {
+				return delivered, markErr
+			}
+			continue
+		}
+
+		if err := o.markPublished(ctx, msg, claim); err != nil {
+			return delivered, err
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+// claim reserves a batch of due messages for this relay and loads them
+func (o *outboxManager) claim(ctx context.Context) ([]*OutboxMessage, string, error) {
+	query, err := o.db.GetQuery("claim_outbox_messages")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load claim_outbox_messages query: %w", err)
+	}
+
+	claim := generateUUIDv7()
+	now := o.now().UTC()
+	result, err := o.db.ExecContext(ctx, query, claim, now.Add(o.config.LeaseTimeout), now, now, o.config.BatchSize)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to claim outbox messages: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return nil, "", nil
+	}
+
+	query, err = o.db.GetQuery("load_claimed_outbox_messages")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load load_claimed_outbox_messages query: %w", err)
+	}
+	rows, err := o.db.QueryContext(ctx, query, claim)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load claimed outbox messages: %w", err)
+	}
+	messages, err := scanOutboxMessages(rows)
+	return messages, claim, err
+}
+
+// deliver publishes msg to the EventBus and the webhook
+func (o *outboxManager) deliver(ctx context.Context, msg *OutboxMessage) error {
+	if o.config.EventBus != nil {
+		event := Event{ID: msg.ID, Name: msg.Event, Data: msg.Data, Source: "outbox", Timestamp: msg.CreatedAt}
+		if bus, ok := o.config.EventBus.(interface{ publishEvent(event Event) error }); ok {
+			if err := bus.publishEvent(event); err != nil {
+				return err
+			}
+		} else if err := o.config.EventBus.Publish(msg.Event, msg.Data); err != nil {
+			return err
+		}
+	}
+
+	if o.config.WebhookURL != "" {
+		return o.postWebhook(ctx, msg)
+	}
+	return nil
+}
+
+// postWebhook sends msg to the webhook. Any non-2xx response is a failure.
+func (o *outboxManager) postWebhook(ctx context.Context, msg *OutboxMessage) error {
+	body, err := json.Marshal(struct {
+		ID        string          `json:"id"`
+		Event     string          `json:"event"`
+		Data      json.RawMessage `json:"data"`
+		CreatedAt time.Time       `json:"created_at"`
+	}{msg.ID, msg.Event, msg.Data, msg.CreatedAt})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", msg.ID)
+	req.Header.Set("X-Outbox-Event", msg.Event)
+	if o.config.WebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(o.config.WebhookSecret))
+		mac.Write(body)
+		req.Header.Set("X-Outbox-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// markPublished records a successful delivery
+func (o *outboxManager) markPublished(ctx context.Context, msg *OutboxMessage, claim string) error {
+	query, err := o.db.GetQuery("mark_outbox_published")
+	if err != nil {
+		return fmt.Errorf("failed to load mark_outbox_published query: %w", err)
+	}
+	if _, err := o.db.ExecContext(ctx, query, o.now().UTC(), msg.ID, claim); err != nil {
+		return fmt.Errorf("failed to mark outbox message %s as published: %w", msg.ID, err)
+	}
+	return nil
+}
+
+// markFailed schedules the next attempt of msg or dead-letters it
+func (o *outboxManager) markFailed(ctx context.Context, msg *OutboxMessage, claim string, cause error) error {
+	query, err := o.db.GetQuery("mark_outbox_failed")
+	if err != nil {
+		return fmt.Errorf("failed to load mark_outbox_failed query: %w", err)
+	}
+
+	attempts := msg.Attempts + 1
+	status := outboxStatusPending
+	if attempts >= o.config.MaxAttempts {
+		status = outboxStatusDead
+		fmt.Printf("WARN: Outbox message %s (%s) dead-lettered after %d attempts: %v\n", msg.ID, msg.Event, attempts, cause)
+	}
+
+	lastError := cause.Error()
+	if len(lastError) > maxOutboxErrorLength {
+		lastError = lastError[:maxOutboxErrorLength]
+	}
+
+	next := o.now().UTC().Add(o.backoff(attempts))
+	if _, err := o.db.ExecContext(ctx, query, status, attempts, lastError, next, msg.ID, claim); err != nil {
+		return fmt.Errorf("failed to record outbox delivery failure of %s: %w", msg.ID, err)
+	}
+	return nil
+}
+
+// backoff returns the delay before the retry following attempt
+func (o *outboxManager) backoff(attempt int) time.Duration {
+	delay := o.config.RetryBackoff
+	for i := 1; i < attempt && delay < o.config.MaxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > o.config.MaxRetryBackoff {
+		delay = o.config.MaxRetryBackoff
+	}
+	return delay
+}
+
+// cleanup deletes expired published messages, at most once per minute
+func (o *outboxManager) cleanup(ctx context.Context) {
+	o.mu.Lock()
+	now := o.now()
+	due := now.Sub(o.lastCleanup) >= time.Minute
+	if due {
+		o.lastCleanup = now
+	}
+	o.mu.Unlock()
+	if !due {
+		return
+	}
+
+	query, err := o.db.GetQuery("cleanup_outbox_messages")
+	if err != nil {
+		return
+	}
+	_, _ = o.db.ExecContext(ctx, query, now.UTC().Add(-o.config.Retention))
+}
+
+// DeadLetters returns up to limit dead-lettered messages, oldest first
+func (o *outboxManager) DeadLetters(limit int) ([]*OutboxMessage, error) {
+	if limit <= 0 {
+		limit = o.config.BatchSize
+	}
+
+	query, err := o.db.GetQuery("load_outbox_dead_letters")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load load_outbox_dead_letters query: %w", err)
+	}
+	rows, err := o.db.QueryContext(context.Background(), query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load outbox dead letters: %w", err)
+	}
+	return scanOutboxMessages(rows)
+}
+
+// Requeue returns a dead-lettered message to the relay with a fresh attempt count
+func (o *outboxManager) Requeue(id string) error {
+	query, err := o.db.GetQuery("requeue_outbox_message")
+	if err != nil {
+		return fmt.Errorf("failed to load requeue_outbox_message query: %w", err)
+	}
+
+	result, err := o.db.Exec(query, o.now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to requeue outbox message %s: %w", id, err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("outbox message %s is not dead-lettered", id)
+	}
+	return nil
+}
+
+// scanOutboxMessages reads and closes rows of outbox messages
+func scanOutboxMessages(rows *Rows) ([]*OutboxMessage, error) {
+	defer rows.Close()
+
+	var messages []*OutboxMessage
+	for rows.Next() {
+		var (
+			msg       OutboxMessage
+			payload   string
+			lastError sql.NullString
+		)
+		if err := rows.Scan(&msg.ID, &msg.Event, &payload, &msg.Attempts, &lastError, &msg.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox message: %w", err)
+		}
+		msg.Data = json.RawMessage(payload)
+		msg.LastError = lastError.String
+		messages = append(messages, &msg)
+	}
+	return messages, rows.Err()
+}
@@ -0,0 +1,305 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// connectOutboxTestDB connects to a fresh SQLite database with the framework tables
+func connectOutboxTestDB(t *testing.T) DatabaseManager {
+	t.Helper()
+	dm := NewDatabaseManager()
+	if err := dm.Connect(createTestDBConfig(filepath.Join(t.TempDir(), "outbox.db"))); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { dm.Close() })
+	if err := dm.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	return dm
+}
+
+// newTestOutbox creates an outbox whose clock is controlled by the returned function
+func newTestOutbox(t *testing.T, db DatabaseManager, config OutboxConfig) (*outboxManager, func(time.Duration)) {
+	t.Helper()
+	outbox, err := NewOutboxManager(db, config)
+	if err != nil {
+		t.Fatalf("NewOutboxManager failed: %v", err)
+	}
+	o := outbox.(*outboxManager)
+	now := time.Now()
+	o.now = func() time.Time { return now }
+	return o, func(d time.Duration) { now = now.Add(d) }
+}
+
+// enqueue writes an event in a transaction that is committed or rolled back
+func enqueue(t *testing.T, db DatabaseManager, o OutboxManager, id, event string, data interface{}, commit bool) {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if id == "" {
+		_, err = o.Enqueue(tx, event, data)
+	} else {
+		err = o.EnqueueWithID(tx, id, event, data)
+	}
+	if err != nil {
+		tx.Rollback()
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	if commit {
+		err = tx.Commit()
+	} else {
+		err = tx.Rollback()
+	}
+	if err != nil {
+		t.Fatalf("Finishing transaction failed: %v", err)
+	}
+}
+
+// eventRecorder subscribes to an event and records what it receives
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+	fail   error
+}
+
+func (r *eventRecorder) handle(event Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail != nil {
+		return r.fail
+	}
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *eventRecorder) received() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+func newRecordingBus(t *testing.T, event string) (EventBus, *eventRecorder) {
+	t.Helper()
+	bus := NewEventBus(nil)
+	recorder := &eventRecorder{}
+	if err := bus.Subscribe("billing", event, recorder.handle); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	return bus, recorder
+}
+
+func TestIntegration_OutboxRelaysCommittedEvents(t *testing.T) {
+	db := connectOutboxTestDB(t)
+	bus, recorder := newRecordingBus(t, "order.created")
+	o, _ := newTestOutbox(t, db, OutboxConfig{EventBus: bus})
+
+	enqueue(t, db, o, "", "order.created", map[string]interface{}{"order": 1}, true)
+	enqueue(t, db, o, "", "order.created", map[string]interface{}{"order": 2}, false)
+	// The same deduplication ID is only stored once
+	enqueue(t, db, o, "order-3", "order.created", map[string]interface{}{"order": 3}, true)
+	enqueue(t, db, o, "order-3", "order.created", map[string]interface{}{"order": 3}, true)
+
+	delivered, err := o.RelayOnce(context.Background())
+	if err != nil || delivered != 2 {
+		t.Fatalf("Expected 2 delivered messages, got %d (%v)", delivered, err)
+	}
+
+	events := recorder.received()
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	var data struct{ Order int }
+	if err := json.Unmarshal(events[0].Data.(json.RawMessage), &data); err != nil || data.Order != 1 {
+		t.Errorf("Unexpected event data %s (%v)", events[0].Data, err)
+	}
+	if events[0].ID == "" || events[1].ID != "order-3" || events[0].Source != "outbox" {
+		t.Errorf("Expected deduplication IDs on events, got %q and %q", events[0].ID, events[1].ID)
+	}
+
+	// Published messages are not delivered again
+	if delivered, err := o.RelayOnce(context.Background()); err != nil || delivered != 0 {
+		t.Errorf("Expected nothing to relay, got %d (%v)", delivered, err)
+	}
+}
+
+func TestIntegration_OutboxRetriesAndDeadLetters(t *testing.T) {
+	db := connectOutboxTestDB(t)
+	bus, recorder := newRecordingBus(t, "invoice.paid")
+	recorder.fail = errors.New("ledger unavailable")
+	o, advance := newTestOutbox(t, db, OutboxConfig{EventBus: bus, MaxAttempts: 2, RetryBackoff: time.Minute})
+	ctx := context.Background()
+
+	enqueue(t, db, o, "invoice-1", "invoice.paid", 42, true)
+
+	if delivered, err := o.RelayOnce(ctx); err != nil || delivered != 0 {
+		t.Fatalf("Expected a failed delivery, got %d (%v)", delivered, err)
+	}
+	// The retry waits for the backoff
+	if delivered, _ := o.RelayOnce(ctx); delivered != 0 {
+		t.Fatal("Expected the retry to wait for the backoff")
+	}
+	advance(time.Minute)
+	o.RelayOnce(ctx)
+
+	dead, err := o.DeadLetters(10)
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("Expected one dead letter, got %v (%v)", dead, err)
+	}
+	if dead[0].ID != "invoice-1" || dead[0].Attempts != 2 || dead[0].LastError == "" || string(dead[0].Data) != "42" {
+		t.Errorf("Unexpected dead letter: %+v", dead[0])
+	}
+
+	advance(time.Hour)
+	if delivered, _ := o.RelayOnce(ctx); delivered != 0 {
+		t.Error("Expected dead letters not to be relayed")
+	}
+
+	recorder.fail = nil
+	if err := o.Requeue("invoice-1"); err != nil {
+		t.Fatalf("Requeue failed: %v", err)
+	}
+	if err := o.Requeue("invoice-1"); err == nil {
+		t.Error("Expected requeueing a pending message to fail")
+	}
+	if delivered, err := o.RelayOnce(ctx); err != nil || delivered != 1 || len(recorder.received()) != 1 {
+		t.Errorf("Expected the requeued message to be delivered, got %d (%v)", delivered, err)
+	}
+}
+
+func TestIntegration_OutboxClaimLease(t *testing.T) {
+	db := connectOutboxTestDB(t)
+	bus, recorder := newRecordingBus(t, "user.created")
+	first, _ := newTestOutbox(t, db, OutboxConfig{EventBus: bus, LeaseTimeout: time.Minute})
+	second, advance := newTestOutbox(t, db, OutboxConfig{EventBus: bus, LeaseTimeout: time.Minute})
+
+	enqueue(t, db, first, "", "user.created", "a", true)
+
+	// A relay that dies after claiming keeps its messages until the lease expires
+	messages, _, err := first.claim(context.Background())
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("Expected to claim one message, got %d (%v)", len(messages), err)
+	}
+	if delivered, _ := second.RelayOnce(context.Background()); delivered != 0 {
+		t.Fatal("Expected claimed messages to be skipped")
+	}
+
+	advance(2 * time.Minute)
+	if delivered, err := second.RelayOnce(context.Background()); err != nil || delivered != 1 {
+		t.Fatalf("Expected the expired claim to be taken over, got %d (%v)", delivered, err)
+	}
+
+	// The late relay cannot overwrite the new owner's result
+	if err := first.markFailed(context.Background(), messages[0], "lost", errors.New("late")); err != nil {
+		t.Fatal(err)
+	}
+	if dead, _ := first.DeadLetters(10); len(dead) != 0 || len(recorder.received()) != 1 {
+		t.Errorf("Expected one delivery and no dead letters, got %d and %d", len(recorder.received()), len(dead))
+	}
+}
+
+func TestIntegration_OutboxWebhook(t *testing.T) {
+	db := connectOutboxTestDB(t)
+
+	var (
+		mu       sync.Mutex
+		requests []*http.Request
+		bodies   [][]byte
+		status   = http.StatusServiceUnavailable
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	o, advance := newTestOutbox(t, db, OutboxConfig{WebhookURL: server.URL, WebhookSecret: "s3cret"})
+	enqueue(t, db, o, "evt-1", "order.shipped", map[string]string{"carrier": "dhl"}, true)
+
+	if delivered, _ := o.RelayOnce(context.Background()); delivered != 0 {
+		t.Fatal("Expected a 503 response to fail the delivery")
+	}
+	mu.Lock()
+	status = http.StatusNoContent
+	mu.Unlock()
+	advance(time.Minute)
+	if delivered, err := o.RelayOnce(context.Background()); err != nil || delivered != 1 {
+		t.Fatalf("Expected the retry to be delivered, got %d (%v)", delivered, err)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 webhook requests, got %d", len(requests))
+	}
+	req, body := requests[1], bodies[1]
+	if req.Header.Get("Idempotency-Key") != "evt-1" || req.Header.Get("X-Outbox-Event") != "order.shipped" {
+		t.Errorf("Unexpected headers: %v", req.Header)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	if req.Header.Get("X-Outbox-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Error("Expected a valid signature")
+	}
+
+	var payload struct {
+		ID   string
+		Data map[string]string
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.ID != "evt-1" || payload.Data["carrier"] != "dhl" {
+		t.Errorf("Unexpected body %s (%v)", body, err)
+	}
+}
+
+func TestIntegration_OutboxRelayLoop(t *testing.T) {
+	db := connectOutboxTestDB(t)
+	bus, recorder := newRecordingBus(t, "user.created")
+	outbox, err := NewOutboxManager(db, OutboxConfig{EventBus: bus, PollInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewOutboxManager failed: %v", err)
+	}
+
+	if err := outbox.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := outbox.Start(); err == nil {
+		t.Error("Expected a second Start to fail")
+	}
+	enqueue(t, db, outbox, "", "user.created", "a", true)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(recorder.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := outbox.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if len(recorder.received()) != 1 {
+		t.Errorf("Expected the relay to deliver the event, got %d", len(recorder.received()))
+	}
+
+	if _, err := NewOutboxManager(db, OutboxConfig{}); err == nil {
+		t.Error("Expected an outbox without destination to be rejected")
+	}
+	if _, err := NewOutboxManager(NewNoopDatabaseManager(), OutboxConfig{EventBus: bus}); err == nil {
+		t.Error("Expected an outbox without database to be rejected")
+	}
+}
@@ -143,6 +143,24 @@ type PluginContext interface {
 	UnregisterMiddleware(name string) error
 }
 
+// PluginScheduler is implemented by plugin contexts that can register tasks
+// with the framework's scheduler. Like PluginQuotas, it is separate from
+// PluginContext; check for it with a type assertion.
+type PluginScheduler interface {
+	// Scheduled tasks, named "<plugin>.<name>" in the scheduler
+	ScheduleTask(name, schedule string, handler TaskHandler) error
+	UnscheduleTask(name string) error
+}
+
+// PluginQuotas is implemented by plugin contexts that give access to the
+// framework's tenant quotas. It is separate from PluginContext so that
+// existing implementations keep compiling; check for it with a type assertion.
+type PluginQuotas interface {
+	// Quotas meters tenant resources such as stored bytes; nil when the
+	// framework does not enforce quotas
+	Quotas() QuotaManager
+}
+
 // PluginStorage provides isolated key-value storage for plugins
 type PluginStorage interface {
 	Get(key string) (interface{}, error)
@@ -166,6 +184,7 @@ type EventHandler func(event Event) error
 
 // Event represents an event in the system
 type Event struct {
+	ID        string // Deduplication ID, set for events relayed from the outbox
 	Name      string
 	Data      interface{}
 	Source    string // Plugin name
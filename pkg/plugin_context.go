@@ -28,6 +28,8 @@ type pluginContextImpl struct {
 	eventBus           EventBus
 	serviceRegistry    ServiceRegistry
 	middlewareRegistry MiddlewareRegistry
+	scheduler          Scheduler
+	quotas             QuotaManager
 
 	// Permissions
 	permissions       PluginPermissions
@@ -174,6 +176,11 @@ func (c *pluginContextImpl) PluginStorage() PluginStorage {
 	return c.pluginStorage
 }
 
+// Quotas returns the framework's tenant quota manager, or nil
+func (c *pluginContextImpl) Quotas() QuotaManager {
+	return c.quotas
+}
+
 // RegisterHook registers a hook with the hook system
 func (c *pluginContextImpl) RegisterHook(hookType HookType, priority int, handler HookHandler) error {
 	if c.hookSystem == nil {
@@ -238,6 +245,22 @@ func (c *pluginContextImpl) UnregisterMiddleware(name string) error {
 	return c.middlewareRegistry.Unregister(c.pluginName, name)
 }
 
+// ScheduleTask registers a recurring task with the framework's scheduler
+func (c *pluginContextImpl) ScheduleTask(name, schedule string, handler TaskHandler) error {
+	if c.scheduler == nil {
+		return fmt.Errorf("scheduler not available")
+	}
+	return c.scheduler.Schedule(c.pluginName+"."+name, schedule, handler)
+}
+
+// UnscheduleTask removes a task registered with ScheduleTask
+func (c *pluginContextImpl) UnscheduleTask(name string) error {
+	if c.scheduler == nil {
+		return fmt.Errorf("scheduler not available")
+	}
+	return c.scheduler.Unregister(c.pluginName + "." + name)
+}
+
 // Note: PluginStorage implementation has been moved to plugin_storage.go
 // to provide database-backed persistent storage with proper isolation
 
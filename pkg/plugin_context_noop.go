@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"time"
@@ -60,7 +61,7 @@ func (n *permissionDeniedDatabaseManager) Query(query string, args ...interface{
 
 func (n *permissionDeniedDatabaseManager) QueryRow(query string, args ...interface{}) *sql.Row {
 	n.logViolation("QueryRow")
-	return nil
+	return errorSQLRow(fmt.Errorf("permission denied: database access not allowed"))
 }
 
 func (n *permissionDeniedDatabaseManager) Exec(query string, args ...interface{}) (sql.Result, error) {
@@ -68,11 +69,31 @@ func (n *permissionDeniedDatabaseManager) Exec(query string, args ...interface{}
 	return nil, fmt.Errorf("permission denied: database access not allowed")
 }
 
+func (n *permissionDeniedDatabaseManager) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	n.logViolation("QueryContext")
+	return nil, fmt.Errorf("permission denied: database access not allowed")
+}
+
+func (n *permissionDeniedDatabaseManager) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	n.logViolation("QueryRowContext")
+	return newErrorRow(fmt.Errorf("permission denied: database access not allowed"))
+}
+
+func (n *permissionDeniedDatabaseManager) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	n.logViolation("ExecContext")
+	return nil, fmt.Errorf("permission denied: database access not allowed")
+}
+
 func (n *permissionDeniedDatabaseManager) Prepare(query string) (*sql.Stmt, error) {
 	n.logViolation("Prepare")
 	return nil, fmt.Errorf("permission denied: database access not allowed")
 }
 
+func (n *permissionDeniedDatabaseManager) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	n.logViolation("PrepareContext")
+	return nil, fmt.Errorf("permission denied: database access not allowed")
+}
+
 func (n *permissionDeniedDatabaseManager) Begin() (Transaction, error) {
 	n.logViolation("Begin")
 	return nil, fmt.Errorf("permission denied: database access not allowed")
@@ -83,6 +104,16 @@ func (n *permissionDeniedDatabaseManager) BeginTx(opts *sql.TxOptions) (Transact
 	return nil, fmt.Errorf("permission denied: database access not allowed")
 }
 
+func (n *permissionDeniedDatabaseManager) BeginTxContext(ctx context.Context, opts *sql.TxOptions) (Transaction, error) {
+	n.logViolation("BeginTxContext")
+	return nil, fmt.Errorf("permission denied: database access not allowed")
+}
+
+func (n *permissionDeniedDatabaseManager) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Transaction) error) error {
+	n.logViolation("WithTx")
+	return fmt.Errorf("permission denied: database access not allowed")
+}
+
 func (n *permissionDeniedDatabaseManager) SaveSession(session *Session) error {
 	n.logViolation("SaveSession")
 	return fmt.Errorf("permission denied: database access not allowed")
@@ -282,6 +313,26 @@ func (n *permissionDeniedCacheManager) ClearRequestCache(requestID string) error
 	return fmt.Errorf("permission denied: cache access not allowed")
 }
 
+func (n *permissionDeniedCacheManager) GetOrLoad(key string, ttl time.Duration, loader CacheLoader) (interface{}, error) {
+	n.logViolation("GetOrLoad")
+	return nil, fmt.Errorf("permission denied: cache access not allowed")
+}
+
+func (n *permissionDeniedCacheManager) GetOrLoadWithOptions(key string, loader CacheLoader, opts CacheLoadOptions) (interface{}, error) {
+	n.logViolation("GetOrLoadWithOptions")
+	return nil, fmt.Errorf("permission denied: cache access not allowed")
+}
+
+func (n *permissionDeniedCacheManager) SetWithTags(key string, value interface{}, ttl time.Duration, tags []string) error {
+	n.logViolation("SetWithTags")
+	return fmt.Errorf("permission denied: cache access not allowed")
+}
+
+func (n *permissionDeniedCacheManager) InvalidateTag(tag string) error {
+	n.logViolation("InvalidateTag")
+	return fmt.Errorf("permission denied: cache access not allowed")
+}
+
 // permissionDeniedConfigManager is a no-op implementation of ConfigManager for permission-denied access
 type permissionDeniedConfigManager struct {
 	pluginName string
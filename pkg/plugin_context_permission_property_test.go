@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"database/sql"
 	"strings"
 	"testing"
@@ -385,6 +386,34 @@ func (m *mockDatabaseManager) Begin() (Transaction, error)             { return
 func (m *mockDatabaseManager) BeginTx(opts *sql.TxOptions) (Transaction, error) {
 	return nil, nil
 }
+
+func (m *mockDatabaseManager) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return nil, nil
+}
+
+func (m *mockDatabaseManager) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	return nil
+}
+
+func (m *mockDatabaseManager) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return m.Exec(query, args...)
+}
+
+func (m *mockDatabaseManager) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return m.Prepare(query)
+}
+
+func (m *mockDatabaseManager) BeginTxContext(ctx context.Context, opts *sql.TxOptions) (Transaction, error) {
+	return m.BeginTx(opts)
+}
+
+func (m *mockDatabaseManager) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Transaction) error) error {
+	tx, err := m.BeginTx(opts)
+	if err != nil {
+		return err
+	}
+	return fn(tx)
+}
 func (m *mockDatabaseManager) SaveSession(session *Session) error { return nil }
 func (m *mockDatabaseManager) LoadSession(sessionID string) (*Session, error) {
 	return nil, nil
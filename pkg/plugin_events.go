@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -60,19 +61,56 @@ func (e *eventBusImpl) Publish(eventName string, data interface{}) error {
 
 // deliverEvent delivers an event to a single subscriber with error isolation
 func (e *eventBusImpl) deliverEvent(pluginName string, event Event, handler EventHandler) {
+	if err := callEventHandler(pluginName, event, handler); err != nil {
+		if e.logger != nil {
+			e.logger.Error(err.Error())
+		}
+	}
+}
+
+// callEventHandler runs handler and turns a failure or panic into an error
+func callEventHandler(pluginName string, event Event, handler EventHandler) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			if e.logger != nil {
-				e.logger.Error(fmt.Sprintf("Plugin %s event handler panicked for event %s: %v", pluginName, event.Name, r))
-			}
+			err = fmt.Errorf("Plugin %s event handler panicked for event %s: %v", pluginName, event.Name, r)
 		}
 	}()
 
 	if err := handler(event); err != nil {
-		if e.logger != nil {
-			e.logger.Error(fmt.Sprintf("Plugin %s event handler failed for event %s: %v", pluginName, event.Name, err))
-		}
+		return fmt.Errorf("Plugin %s event handler failed for event %s: %w", pluginName, event.Name, err)
+	}
+	return nil
+}
+
+// publishEvent delivers event to all subscribers and waits for them. Unlike
+// Publish it reports handler failures, so the outbox relay can retry.
+func (e *eventBusImpl) publishEvent(event Event) error {
+	e.mu.RLock()
+	handlers := make(map[string]EventHandler, len(e.subscriptions[event.Name]))
+	for pluginName, handler := range e.subscriptions[event.Name] {
+		handlers[pluginName] = handler
+	}
+	e.mu.RUnlock()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for pluginName, handler := range handlers {
+		wg.Add(1)
+		go func(pluginName string, handler EventHandler) {
+			defer wg.Done()
+			if err := callEventHandler(pluginName, event, handler); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(pluginName, handler)
 	}
+	wg.Wait()
+
+	return errors.Join(errs...)
 }
 
 // Subscribe registers a plugin's event handler for a specific event
@@ -77,6 +77,12 @@ type pluginManagerImpl struct {
 	serviceRegistry    ServiceRegistry
 	middlewareRegistry MiddlewareRegistry
 
+	// Scheduler for plugin tasks, set by the framework
+	scheduler Scheduler
+
+	// Tenant quotas for plugin storage, set by the framework
+	quotas QuotaManager
+
 	// Error threshold configuration
 	errorThreshold int64 // Number of errors before warning/disabling
 	autoDisable    bool  // Whether to auto-disable plugins exceeding threshold
@@ -570,6 +576,11 @@ func (m *pluginManagerImpl) DisablePlugin(name string) error {
 		}
 	}
 
+	// Remove scheduled tasks
+	if m.scheduler != nil {
+		unregisterTasksWithPrefix(m.scheduler, name+".")
+	}
+
 	// Unregister from registry
 	if err := m.registry.Unregister(name); err != nil {
 		if m.logger != nil {
@@ -754,7 +765,7 @@ func (m *pluginManagerImpl) GetAllHealth() map[string]PluginHealth {
 
 // createPluginContext creates a plugin context for a plugin
 func (m *pluginManagerImpl) createPluginContext(pluginName string, config PluginConfig) PluginContext {
-	return NewPluginContext(
+	ctx := NewPluginContext(
 		pluginName,
 		m.router,
 		m.logger,
@@ -772,6 +783,11 @@ func (m *pluginManagerImpl) createPluginContext(pluginName string, config Plugin
 		m.middlewareRegistry,
 		m.permissionChecker,
 	)
+	if pc, ok := ctx.(*pluginContextImpl); ok {
+		pc.scheduler = m.scheduler
+		pc.quotas = m.quotas
+	}
+	return ctx
 }
 
 // recordLoadMetrics records metrics for plugin loading
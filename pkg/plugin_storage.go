@@ -20,7 +20,7 @@ type pluginStorageImpl struct {
 func NewPluginStorage(pluginName string, database DatabaseManager) PluginStorage {
 	return &pluginStorageImpl{
 		pluginName: pluginName,
-		database:   database,
+		database:   PrimaryDatabase(database),
 		cache:      make(map[string]interface{}),
 	}
 }
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"sync"
@@ -143,11 +144,29 @@ func (m *MockDatabase) Exec(query string, args ...interface{}) (sql.Result, erro
 func (m *MockDatabase) Prepare(query string) (*sql.Stmt, error)          { return nil, nil }
 func (m *MockDatabase) Begin() (Transaction, error)                      { return nil, nil }
 func (m *MockDatabase) BeginTx(opts *sql.TxOptions) (Transaction, error) { return nil, nil }
-func (m *MockDatabase) SaveSession(session *Session) error               { return nil }
-func (m *MockDatabase) LoadSession(sessionID string) (*Session, error)   { return nil, nil }
-func (m *MockDatabase) DeleteSession(sessionID string) error             { return nil }
-func (m *MockDatabase) CleanupExpiredSessions() error                    { return nil }
-func (m *MockDatabase) SaveAccessToken(token *AccessToken) error         { return nil }
+func (m *MockDatabase) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return nil, nil
+}
+func (m *MockDatabase) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	return nil
+}
+func (m *MockDatabase) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return m.Exec(query, args...)
+}
+func (m *MockDatabase) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return m.Prepare(query)
+}
+func (m *MockDatabase) BeginTxContext(ctx context.Context, opts *sql.TxOptions) (Transaction, error) {
+	return m.BeginTx(opts)
+}
+func (m *MockDatabase) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Transaction) error) error {
+	return fn(nil)
+}
+func (m *MockDatabase) SaveSession(session *Session) error             { return nil }
+func (m *MockDatabase) LoadSession(sessionID string) (*Session, error) { return nil, nil }
+func (m *MockDatabase) DeleteSession(sessionID string) error           { return nil }
+func (m *MockDatabase) CleanupExpiredSessions() error                  { return nil }
+func (m *MockDatabase) SaveAccessToken(token *AccessToken) error       { return nil }
 func (m *MockDatabase) LoadAccessToken(tokenValue string) (*AccessToken, error) {
 	return nil, nil
 }
@@ -425,6 +444,7 @@ type MockPluginContext struct {
 	// Plugin-specific data
 	pluginConfig  map[string]interface{}
 	pluginStorage PluginStorage
+	quotas        QuotaManager
 
 	// Systems
 	hookSystem         HookSystem
@@ -443,6 +463,7 @@ type MockPluginContext struct {
 	ExportedServices     map[string]interface{}
 	ImportedServices     []ServiceImport
 	RegisteredMiddleware []MiddlewareRegistration
+	ScheduledTasks       map[string]string // task name -> schedule
 	mu                   sync.RWMutex
 }
 
@@ -489,6 +510,7 @@ func NewMockPluginContext() *MockPluginContext {
 		ExportedServices:     make(map[string]interface{}),
 		ImportedServices:     []ServiceImport{},
 		RegisteredMiddleware: []MiddlewareRegistration{},
+		ScheduledTasks:       make(map[string]string),
 	}
 }
 
@@ -590,6 +612,11 @@ func (m *MockPluginContext) PluginStorage() PluginStorage {
 	return m.pluginStorage
 }
 
+// Quotas returns the mock quota manager
+func (m *MockPluginContext) Quotas() QuotaManager {
+	return m.quotas
+}
+
 // RegisterHook registers a hook and tracks it for testing
 func (m *MockPluginContext) RegisterHook(hookType HookType, priority int, handler HookHandler) error {
 	m.mu.Lock()
@@ -701,6 +728,22 @@ func (m *MockPluginContext) UnregisterMiddleware(name string) error {
 	return nil
 }
 
+// ScheduleTask tracks a scheduled task
+func (m *MockPluginContext) ScheduleTask(name, schedule string, handler TaskHandler) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ScheduledTasks[name] = schedule
+	return nil
+}
+
+// UnscheduleTask removes a tracked task
+func (m *MockPluginContext) UnscheduleTask(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.ScheduledTasks, name)
+	return nil
+}
+
 // SetRouter sets a custom router for testing
 func (m *MockPluginContext) SetRouter(router RouterEngine) {
 	m.router = router
@@ -741,6 +784,11 @@ func (m *MockPluginContext) SetNetwork(network NetworkClient) {
 	m.network = network
 }
 
+// SetQuotas sets a quota manager for testing
+func (m *MockPluginContext) SetQuotas(quotas QuotaManager) {
+	m.quotas = quotas
+}
+
 // ============================================================================
 // Mock Supporting Systems
 // ============================================================================
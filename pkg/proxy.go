@@ -41,6 +41,14 @@ type ProxyManager interface {
 	ResetMetrics()
 }
 
+// ProxyStreamer is implemented by proxy managers that stream responses to the
+// client as they arrive. The proxy manager of the framework implements it; it
+// is separate from ProxyManager so that existing implementations keep
+// compiling.
+type ProxyStreamer interface {
+	Stream(ctx Context) error
+}
+
 // Backend represents a backend server for proxy forwarding
 type Backend struct {
 	ID       string   `json:"id"`
@@ -52,6 +60,7 @@ type Backend struct {
 	HealthCheckPath     string        `json:"health_check_path"`
 	HealthCheckInterval time.Duration `json:"health_check_interval"`
 	HealthCheckTimeout  time.Duration `json:"health_check_timeout"`
+	HealthCheckType     string        `json:"health_check_type"` // http or tcp, defaults to ProxyConfig.HealthCheckType
 
 	// Circuit breaker state
 	FailureCount    int       `json:"failure_count"`
@@ -73,6 +82,15 @@ type BackendHealth struct {
 	ConsecutiveFails int           `json:"consecutive_fails"`
 	ResponseTime     time.Duration `json:"response_time"`
 	ErrorMessage     string        `json:"error_message,omitempty"`
+
+	// Active checks passed in a row
+	ConsecutiveSuccesses int `json:"consecutive_successes"`
+
+	// Outlier detection state
+	Ejected        bool      `json:"ejected"`
+	EjectedUntil   time.Time `json:"ejected_until,omitempty"`
+	Ejections      int       `json:"ejections"`
+	EjectionReason string    `json:"ejection_reason,omitempty"`
 }
 
 // LoadBalancer defines the interface for load balancing strategies
@@ -80,7 +98,8 @@ type LoadBalancer interface {
 	// Select a backend for the next request
 	SelectBackend(backends []*Backend) (*Backend, error)
 
-	// Update backend state after request
+	// Update backend state after request, called once for every backend
+	// returned by SelectBackend
 	UpdateBackend(backendID string, success bool, responseTime time.Duration)
 
 	// Get load balancer type
@@ -153,7 +172,11 @@ type ProxyMetrics struct {
 	CacheMisses int64 `json:"cache_misses"`
 
 	// Retry metrics
-	TotalRetries int64 `json:"total_retries"`
+	TotalRetries         int64 `json:"total_retries"`
+	RetryBudgetExhausted int64 `json:"retry_budget_exhausted"`
+
+	// Outlier detection metrics
+	OutlierEjections int64 `json:"outlier_ejections"`
 
 	mu sync.RWMutex
 }
@@ -171,7 +194,10 @@ type BackendMetrics struct {
 // ProxyConfig represents proxy configuration
 type ProxyConfig struct {
 	// Load balancing strategy
-	LoadBalancerType string `json:"load_balancer_type"` // round_robin, weighted_round_robin, least_connections
+	LoadBalancerType string `json:"load_balancer_type"` // round_robin, weighted_round_robin, least_connections, least_response_time, power_of_two_choices, ketama, maglev
+
+	// Request key for consistent hashing (ketama, maglev): ip, header:<name> or cookie:<name>
+	LoadBalancerHashKey string `json:"load_balancer_hash_key"`
 
 	// Circuit breaker configuration
 	CircuitBreakerEnabled      bool          `json:"circuit_breaker_enabled"`
@@ -183,22 +209,49 @@ type ProxyConfig struct {
 	MaxConnectionsPerBackend int           `json:"max_connections_per_backend"`
 	ConnectionTimeout        time.Duration `json:"connection_timeout"`
 	IdleConnTimeout          time.Duration `json:"idle_conn_timeout"`
+	H2CUpstreams             bool          `json:"h2c_upstreams"` // HTTP/2 without TLS to http:// backends
 
 	// Retry configuration
 	MaxRetries   int           `json:"max_retries"`
 	RetryDelay   time.Duration `json:"retry_delay"`
 	RetryBackoff bool          `json:"retry_backoff"` // Exponential backoff
 
+	// Per-try timeout, within the RequestTimeout of all attempts together
+	PerTryTimeout time.Duration `json:"per_try_timeout"`
+
+	// Retry budget: retries may add RetryBudgetRatio of the requests over
+	// RetryBudgetWindow, with at least RetryBudgetMinRetries. 0 disables it.
+	RetryBudgetRatio      float64       `json:"retry_budget_ratio"`
+	RetryBudgetMinRetries int           `json:"retry_budget_min_retries"`
+	RetryBudgetWindow     time.Duration `json:"retry_budget_window"`
+
 	// Cache configuration
 	CacheEnabled bool          `json:"cache_enabled"`
 	CacheTTL     time.Duration `json:"cache_ttl"`
 	CacheMaxSize int64         `json:"cache_max_size"`
 
 	// Health check configuration
-	HealthCheckEnabled  bool          `json:"health_check_enabled"`
-	HealthCheckInterval time.Duration `json:"health_check_interval"`
-	HealthCheckTimeout  time.Duration `json:"health_check_timeout"`
-	HealthCheckPath     string        `json:"health_check_path"`
+	HealthCheckEnabled            bool          `json:"health_check_enabled"`
+	HealthCheckInterval           time.Duration `json:"health_check_interval"`
+	HealthCheckTimeout            time.Duration `json:"health_check_timeout"`
+	HealthCheckPath               string        `json:"health_check_path"`
+	HealthCheckType               string        `json:"health_check_type"`                // http or tcp
+	HealthCheckExpectedStatus     []int         `json:"health_check_expected_status"`     // Passing statuses, any 2xx when empty
+	HealthCheckBodyMatch          string        `json:"health_check_body_match"`          // Regular expression the body must match
+	HealthCheckHealthyThreshold   int           `json:"health_check_healthy_threshold"`   // Passed checks in a row to become healthy
+	HealthCheckUnhealthyThreshold int           `json:"health_check_unhealthy_threshold"` // Failed checks in a row to become unhealthy
+
+	// Outlier detection: passive ejection of backends failing their traffic
+	OutlierDetectionEnabled   bool          `json:"outlier_detection_enabled"`
+	OutlierInterval           time.Duration `json:"outlier_interval"`             // Interval requests are analysed over
+	OutlierMinRequests        int           `json:"outlier_min_requests"`         // Requests in an interval before a backend is judged
+	OutlierErrorRate          float64       `json:"outlier_error_rate"`           // Error rate ejecting a backend, 0 disables
+	OutlierLatencyPercentile  float64       `json:"outlier_latency_percentile"`   // Percentile compared to OutlierLatencyThreshold, e.g. 0.99
+	OutlierLatencyThreshold   time.Duration `json:"outlier_latency_threshold"`    // Percentile latency ejecting a backend, 0 disables
+	OutlierBaseEjectionTime   time.Duration `json:"outlier_base_ejection_time"`   // Multiplied by the number of ejections
+	OutlierMaxEjectionTime    time.Duration `json:"outlier_max_ejection_time"`    // Longest ejection
+	OutlierMaxEjectionPercent int           `json:"outlier_max_ejection_percent"` // Most backends ejected at once
+	OutlierRecoveryPeriod     time.Duration `json:"outlier_recovery_period"`      // Time a returning backend takes to its full share
 
 	// Request timeout
 	RequestTimeout time.Duration `json:"request_timeout"`
@@ -211,26 +264,42 @@ type ProxyConfig struct {
 // DefaultProxyConfig returns default proxy configuration
 func DefaultProxyConfig() *ProxyConfig {
 	return &ProxyConfig{
-		LoadBalancerType:           "round_robin",
-		CircuitBreakerEnabled:      true,
-		CircuitBreakerThreshold:    5,
-		CircuitBreakerTimeout:      30 * time.Second,
-		CircuitBreakerResetTimeout: 60 * time.Second,
-		MaxConnectionsPerBackend:   100,
-		ConnectionTimeout:          10 * time.Second,
-		IdleConnTimeout:            90 * time.Second,
-		MaxRetries:                 3,
-		RetryDelay:                 100 * time.Millisecond,
-		RetryBackoff:               true,
-		CacheEnabled:               true,
-		CacheTTL:                   5 * time.Minute,
-		CacheMaxSize:               100 * 1024 * 1024, // 100MB
-		HealthCheckEnabled:         true,
-		HealthCheckInterval:        10 * time.Second,
-		HealthCheckTimeout:         5 * time.Second,
-		HealthCheckPath:            "/health",
-		RequestTimeout:             30 * time.Second,
-		DNSCacheEnabled:            true,
-		DNSCacheTTL:                5 * time.Minute,
+		LoadBalancerType:              "round_robin",
+		LoadBalancerHashKey:           "ip",
+		CircuitBreakerEnabled:         true,
+		CircuitBreakerThreshold:       5,
+		CircuitBreakerTimeout:         30 * time.Second,
+		CircuitBreakerResetTimeout:    60 * time.Second,
+		MaxConnectionsPerBackend:      100,
+		ConnectionTimeout:             10 * time.Second,
+		IdleConnTimeout:               90 * time.Second,
+		MaxRetries:                    3,
+		RetryDelay:                    100 * time.Millisecond,
+		RetryBackoff:                  true,
+		RetryBudgetRatio:              0.2,
+		RetryBudgetMinRetries:         10,
+		RetryBudgetWindow:             10 * time.Second,
+		CacheEnabled:                  true,
+		CacheTTL:                      5 * time.Minute,
+		CacheMaxSize:                  100 * 1024 * 1024, // 100MB
+		HealthCheckEnabled:            true,
+		HealthCheckInterval:           10 * time.Second,
+		HealthCheckTimeout:            5 * time.Second,
+		HealthCheckPath:               "/health",
+		HealthCheckType:               HealthCheckHTTP,
+		HealthCheckHealthyThreshold:   2,
+		HealthCheckUnhealthyThreshold: 3,
+		OutlierDetectionEnabled:       true,
+		OutlierInterval:               10 * time.Second,
+		OutlierMinRequests:            20,
+		OutlierErrorRate:              0.5,
+		OutlierLatencyPercentile:      0.99,
+		OutlierBaseEjectionTime:       30 * time.Second,
+		OutlierMaxEjectionTime:        5 * time.Minute,
+		OutlierMaxEjectionPercent:     50,
+		OutlierRecoveryPeriod:         30 * time.Second,
+		RequestTimeout:                30 * time.Second,
+		DNSCacheEnabled:               true,
+		DNSCacheTTL:                   5 * time.Minute,
 	}
 }
@@ -0,0 +1,578 @@
+package pkg
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Load balancer types for ProxyConfig.LoadBalancerType
+const (
+	LoadBalancerRoundRobin         = "round_robin"
+	LoadBalancerWeightedRoundRobin = "weighted_round_robin"
+	LoadBalancerLeastConnections   = "least_connections"
+	LoadBalancerLeastResponseTime  = "least_response_time"
+	LoadBalancerPowerOfTwoChoices  = "power_of_two_choices"
+	LoadBalancerKetama             = "ketama"
+	LoadBalancerMaglev             = "maglev"
+)
+
+// RequestLoadBalancer is implemented by load balancers that select the
+// backend based on the request, such as the consistent hashing balancers.
+// The proxy manager prefers SelectBackendForRequest when available.
+type RequestLoadBalancer interface {
+	LoadBalancer
+
+	// Select a backend for the request
+	SelectBackendForRequest(request *Request, backends []*Backend) (*Backend, error)
+}
+
+// NewLoadBalancer creates the load balancer configured by
+// config.LoadBalancerType, which defaults to round_robin
+func NewLoadBalancer(config *ProxyConfig) (LoadBalancer, error) {
+	switch config.LoadBalancerType {
+	case "", LoadBalancerRoundRobin:
+		return NewRoundRobinLoadBalancer(), nil
+	case LoadBalancerWeightedRoundRobin:
+		return NewWeightedRoundRobinLoadBalancer(), nil
+	case LoadBalancerLeastConnections:
+		return NewLeastConnectionsLoadBalancer(), nil
+	case LoadBalancerLeastResponseTime:
+		return NewLeastResponseTimeLoadBalancer(), nil
+	case LoadBalancerPowerOfTwoChoices:
+		return NewPowerOfTwoChoicesLoadBalancer(), nil
+	case LoadBalancerKetama:
+		return NewKetamaLoadBalancer(config.LoadBalancerHashKey)
+	case LoadBalancerMaglev:
+		return NewMaglevLoadBalancer(config.LoadBalancerHashKey)
+	default:
+		return nil, fmt.Errorf("unsupported load balancer type: %s", config.LoadBalancerType)
+	}
+}
+
+// weightedRoundRobinLoadBalancer implements smooth weighted round-robin as
+// in nginx: every selection adds each backend's weight to its current
+// weight and picks the highest, which then gives up the total weight. A
+// backend with weight 3 next to one with weight 1 is picked three times in
+// four, interleaved instead of in bursts.
+type weightedRoundRobinLoadBalancer struct {
+	mu      sync.Mutex
+	current map[string]int
+}
+
+// NewWeightedRoundRobinLoadBalancer creates a smooth weighted round-robin
+// load balancer using Backend.Weight
+func NewWeightedRoundRobinLoadBalancer() LoadBalancer {
+	return &weightedRoundRobinLoadBalancer{current: make(map[string]int)}
+}
+
+// SelectBackend selects the backend with the highest current weight
+func (lb *weightedRoundRobinLoadBalancer) SelectBackend(backends []*Backend) (*Backend, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("no backends available")
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	total := 0
+	var best *Backend
+	for _, backend := range backends {
+		weight := backendWeight(backend)
+		total += weight
+		lb.current[backend.ID] += weight
+		if best == nil || lb.current[backend.ID] > lb.current[best.ID] {
+			best = backend
+		}
+	}
+	lb.current[best.ID] -= total
+
+	return best, nil
+}
+
+// UpdateBackend updates backend state (no-op for weighted round-robin)
+func (lb *weightedRoundRobinLoadBalancer) UpdateBackend(backendID string, success bool, responseTime time.Duration) {
+	// No state to update for weighted round-robin
+}
+
+// Type returns the load balancer type
+func (lb *weightedRoundRobinLoadBalancer) Type() string {
+	return LoadBalancerWeightedRoundRobin
+}
+
+// backendLoad counts the requests in flight per backend. A request is in
+// flight from SelectBackend until UpdateBackend.
+type backendLoad struct {
+	mu       sync.Mutex
+	inflight map[string]int64
+}
+
+func newBackendLoad() backendLoad {
+	return backendLoad{inflight: make(map[string]int64)}
+}
+
+// acquire records a request to the backend, the caller holds mu
+func (l *backendLoad) acquire(backendID string) {
+	l.inflight[backendID]++
+}
+
+// release records the end of a request to the backend
+func (l *backendLoad) release(backendID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight[backendID] > 1 {
+		l.inflight[backendID]--
+	} else {
+		delete(l.inflight, backendID)
+	}
+}
+
+// leastConnectionsLoadBalancer selects the backend with the fewest requests
+// in flight relative to its weight
+type leastConnectionsLoadBalancer struct {
+	backendLoad
+	next uint64
+}
+
+// NewLeastConnectionsLoadBalancer creates a load balancer selecting the
+// backend with the fewest requests in flight per unit of Backend.Weight
+func NewLeastConnectionsLoadBalancer() LoadBalancer {
+	return &leastConnectionsLoadBalancer{backendLoad: newBackendLoad()}
+}
+
+// SelectBackend selects the least loaded backend, rotating between equally
+// loaded ones
+func (lb *leastConnectionsLoadBalancer) SelectBackend(backends []*Backend) (*Backend, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("no backends available")
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	start := int(lb.next % uint64(len(backends)))
+	lb.next++
+
+	var best *Backend
+	for i := range backends {
+		backend := backends[(start+i)%len(backends)]
+		if best == nil || lb.lessLoaded(backend, best) {
+			best = backend
+		}
+	}
+	lb.acquire(best.ID)
+
+	return best, nil
+}
+
+// lessLoaded reports whether a has fewer requests in flight per weight than b
+func (lb *leastConnectionsLoadBalancer) lessLoaded(a, b *Backend) bool {
+	return (lb.inflight[a.ID]+1)*int64(backendWeight(b)) < (lb.inflight[b.ID]+1)*int64(backendWeight(a))
+}
+
+// UpdateBackend ends the request to the backend
+func (lb *leastConnectionsLoadBalancer) UpdateBackend(backendID string, success bool, responseTime time.Duration) {
+	lb.release(backendID)
+}
+
+// Type returns the load balancer type
+func (lb *leastConnectionsLoadBalancer) Type() string {
+	return LoadBalancerLeastConnections
+}
+
+// leastResponseTimeDecay is the weight of a new response time in the moving
+// average of a backend
+const leastResponseTimeDecay = 0.3
+
+// leastResponseTimeLoadBalancer selects the backend with the lowest
+// exponentially weighted moving average of successful response times,
+// multiplied by its requests in flight and divided by its weight
+type leastResponseTimeLoadBalancer struct {
+	backendLoad
+	average map[string]float64
+	next    uint64
+}
+
+// NewLeastResponseTimeLoadBalancer creates a load balancer preferring the
+// backends responding fastest, using the timings passed to UpdateBackend.
+// Backends without successful responses yet are tried first.
+func NewLeastResponseTimeLoadBalancer() LoadBalancer {
+	return &leastResponseTimeLoadBalancer{
+		backendLoad: newBackendLoad(),
+		average:     make(map[string]float64),
+	}
+}
+
+// SelectBackend selects the backend with the lowest expected response time
+func (lb *leastResponseTimeLoadBalancer) SelectBackend(backends []*Backend) (*Backend, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("no backends available")
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	start := int(lb.next % uint64(len(backends)))
+	lb.next++
+
+	var best *Backend
+	bestScore := 0.0
+	for i := range backends {
+		backend := backends[(start+i)%len(backends)]
+		average, ok := lb.average[backend.ID]
+		if !ok {
+			average = 1
+		}
+		score := average * float64(lb.inflight[backend.ID]+1) / float64(backendWeight(backend))
+		if best == nil || score < bestScore {
+			best, bestScore = backend, score
+		}
+	}
+	lb.acquire(best.ID)
+
+	return best, nil
+}
+
+// UpdateBackend ends the request to the backend and adds the response time
+// of successful requests to its average. Failures are left to the circuit
+// breaker, since failing fast must not attract more requests.
+func (lb *leastResponseTimeLoadBalancer) UpdateBackend(backendID string, success bool, responseTime time.Duration) {
+	lb.release(backendID)
+	if !success || responseTime <= 0 {
+		return
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if average, ok := lb.average[backendID]; ok {
+		lb.average[backendID] = average + leastResponseTimeDecay*(float64(responseTime)-average)
+	} else {
+		lb.average[backendID] = float64(responseTime)
+	}
+}
+
+// Type returns the load balancer type
+func (lb *leastResponseTimeLoadBalancer) Type() string {
+	return LoadBalancerLeastResponseTime
+}
+
+// powerOfTwoChoicesLoadBalancer picks two random backends and selects the
+// one with fewer requests in flight per weight, which spreads load almost
+// as well as least connections without comparing every backend
+type powerOfTwoChoicesLoadBalancer struct {
+	backendLoad
+}
+
+// NewPowerOfTwoChoicesLoadBalancer creates a power-of-two-choices load
+// balancer
+func NewPowerOfTwoChoicesLoadBalancer() LoadBalancer {
+	return &powerOfTwoChoicesLoadBalancer{backendLoad: newBackendLoad()}
+}
+
+// SelectBackend selects the less loaded of two random backends
+func (lb *powerOfTwoChoicesLoadBalancer) SelectBackend(backends []*Backend) (*Backend, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("no backends available")
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	best := backends[0]
+	if len(backends) > 1 {
+		i := rand.Intn(len(backends))
+		j := rand.Intn(len(backends) - 1)
+		if j >= i {
+			j++
+		}
+		a, b := backends[i], backends[j]
+		best = a
+		if (lb.inflight[b.ID]+1)*int64(backendWeight(a)) < (lb.inflight[a.ID]+1)*int64(backendWeight(b)) {
+			best = b
+		}
+	}
+	lb.acquire(best.ID)
+
+	return best, nil
+}
+
+// UpdateBackend ends the request to the backend
+func (lb *powerOfTwoChoicesLoadBalancer) UpdateBackend(backendID string, success bool, responseTime time.Duration) {
+	lb.release(backendID)
+}
+
+// Type returns the load balancer type
+func (lb *powerOfTwoChoicesLoadBalancer) Type() string {
+	return LoadBalancerPowerOfTwoChoices
+}
+
+// hashKeyFunc returns the key of a request to hash on, or an empty string if
+// the request has none
+type hashKeyFunc func(request *Request) string
+
+// parseHashKey parses ProxyConfig.LoadBalancerHashKey: "ip" (the default),
+// "header:<name>" or "cookie:<name>"
+func parseHashKey(spec string) (hashKeyFunc, error) {
+	kind, name, _ := strings.Cut(spec, ":")
+	switch {
+	case spec == "" || spec == "ip":
+		return requestClientIP, nil
+	case kind == "header" && name != "":
+		return func(request *Request) string {
+			return request.Header.Get(name)
+		}, nil
+	case kind == "cookie" && name != "":
+		return func(request *Request) string {
+			cookie, err := (&http.Request{Header: request.Header}).Cookie(name)
+			if err != nil {
+				return ""
+			}
+			return cookie.Value
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid load balancer hash key: %q", spec)
+	}
+}
+
+// hashLoadBalancer is the part of the consistent hashing load balancers
+// shared by ketama and maglev: extracting the key of a request and
+// rebuilding the lookup table when the backends change. Requests without a
+// key are balanced round-robin.
+type hashLoadBalancer struct {
+	key      hashKeyFunc
+	fallback roundRobinLoadBalancer
+
+	mu        sync.Mutex
+	signature string                  // Backends the lookup table was built for
+	lookup    func(key string) string // Returns the backend ID of a key
+
+	// The previous table is kept for requests alternating between two sets
+	// of backends, e.g. while a backend recovers from an ejection
+	previousSignature string
+	previousLookup    func(key string) string
+}
+
+// SelectBackend selects a backend round-robin, since there is no request to
+// hash on
+func (lb *hashLoadBalancer) SelectBackend(backends []*Backend) (*Backend, error) {
+	return lb.fallback.SelectBackend(backends)
+}
+
+// UpdateBackend updates backend state (no-op for consistent hashing)
+func (lb *hashLoadBalancer) UpdateBackend(backendID string, success bool, responseTime time.Duration) {
+	// No state to update for consistent hashing
+}
+
+// selectBackend returns the backend the table built by build maps the key
+// of request to
+func (lb *hashLoadBalancer) selectBackend(request *Request, backends []*Backend, build func([]*Backend) func(key string) string) (*Backend, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("no backends available")
+	}
+	key := ""
+	if request != nil {
+		key = lb.key(request)
+	}
+	if key == "" {
+		return lb.fallback.SelectBackend(backends)
+	}
+
+	// Build the table in backend ID order, so it does not depend on the order of backends
+	sorted := make([]*Backend, len(backends))
+	copy(sorted, backends)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	var signature strings.Builder
+	for _, backend := range sorted {
+		signature.WriteString(backend.ID)
+		signature.WriteByte('/')
+		signature.WriteString(strconv.Itoa(backendWeight(backend)))
+		signature.WriteByte(',')
+	}
+
+	lb.mu.Lock()
+	if lb.signature != signature.String() {
+		lookup := lb.previousLookup
+		if lb.previousSignature != signature.String() {
+			lookup = build(sorted)
+		}
+		lb.previousSignature, lb.previousLookup = lb.signature, lb.lookup
+		lb.signature, lb.lookup = signature.String(), lookup
+	}
+	lookup := lb.lookup
+	lb.mu.Unlock()
+
+	id := lookup(key)
+	for _, backend := range sorted {
+		if backend.ID == id {
+			return backend, nil
+		}
+	}
+	return nil, fmt.Errorf("backend %s not found", id)
+}
+
+// ketamaPointsPerWeight is the number of points on the ring per unit of
+// Backend.Weight, as in libketama
+const ketamaPointsPerWeight = 160
+
+// ketamaLoadBalancer implements consistent hashing on a ring as in
+// libketama. Each backend owns points on the ring in proportion to its
+// weight; a key maps to the backend of the next point. Adding or removing a
+// backend only moves the keys of its own points.
+type ketamaLoadBalancer struct {
+	hashLoadBalancer
+}
+
+// NewKetamaLoadBalancer creates a ketama consistent hashing load balancer
+// hashing on hashKey: "ip" (the default), "header:<name>" or
+// "cookie:<name>". Requests with the same key go to the same backend as
+// long as it is available.
+func NewKetamaLoadBalancer(hashKey string) (LoadBalancer, error) {
+	key, err := parseHashKey(hashKey)
+	if err != nil {
+		return nil, err
+	}
+	return &ketamaLoadBalancer{hashLoadBalancer{key: key}}, nil
+}
+
+// SelectBackendForRequest selects the backend owning the key of request
+func (lb *ketamaLoadBalancer) SelectBackendForRequest(request *Request, backends []*Backend) (*Backend, error) {
+	return lb.selectBackend(request, backends, buildKetamaRing)
+}
+
+// Type returns the load balancer type
+func (lb *ketamaLoadBalancer) Type() string {
+	return LoadBalancerKetama
+}
+
+type ketamaPoint struct {
+	hash      uint32
+	backendID string
+}
+
+// buildKetamaRing places the points of the backends on the ring, four per
+// MD5 hash of "<backend ID>-<n>"
+func buildKetamaRing(backends []*Backend) func(key string) string {
+	var ring []ketamaPoint
+	for _, backend := range backends {
+		for n := 0; n < backendWeight(backend)*ketamaPointsPerWeight/4; n++ {
+			digest := md5.Sum([]byte(backend.ID + "-" + strconv.Itoa(n)))
+			for i := 0; i < 4; i++ {
+				ring = append(ring, ketamaPoint{
+					hash:      binary.LittleEndian.Uint32(digest[i*4:]),
+					backendID: backend.ID,
+				})
+			}
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	return func(key string) string {
+		digest := md5.Sum([]byte(key))
+		hash := binary.LittleEndian.Uint32(digest[:4])
+		i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= hash })
+		if i == len(ring) {
+			i = 0
+		}
+		return ring[i].backendID
+	}
+}
+
+// maglevTableSize is the size of the maglev lookup table, a prime much
+// larger than the number of backends
+const maglevTableSize = 65537
+
+// maglevLoadBalancer implements Maglev consistent hashing (Eisenbud et al.,
+// NSDI 2016). Backends fill a lookup table following their own permutation
+// of it, taking turns in proportion to their weight, which spreads keys
+// more evenly than a ring and makes lookups a single index.
+type maglevLoadBalancer struct {
+	hashLoadBalancer
+}
+
+// NewMaglevLoadBalancer creates a Maglev consistent hashing load balancer
+// hashing on hashKey: "ip" (the default), "header:<name>" or
+// "cookie:<name>". Requests with the same key go to the same backend as
+// long as it is available.
+func NewMaglevLoadBalancer(hashKey string) (LoadBalancer, error) {
+	key, err := parseHashKey(hashKey)
+	if err != nil {
+		return nil, err
+	}
+	return &maglevLoadBalancer{hashLoadBalancer{key: key}}, nil
+}
+
+// SelectBackendForRequest selects the backend the table maps the key of
+// request to
+func (lb *maglevLoadBalancer) SelectBackendForRequest(request *Request, backends []*Backend) (*Backend, error) {
+	return lb.selectBackend(request, backends, buildMaglevTable)
+}
+
+// Type returns the load balancer type
+func (lb *maglevLoadBalancer) Type() string {
+	return LoadBalancerMaglev
+}
+
+// buildMaglevTable fills the lookup table
+func buildMaglevTable(backends []*Backend) func(key string) string {
+	offsets := make([]uint64, len(backends))
+	skips := make([]uint64, len(backends))
+	next := make([]uint64, len(backends))
+	for i, backend := range backends {
+		offsets[i] = maglevHash(backend.ID, 0) % maglevTableSize
+		skips[i] = maglevHash(backend.ID, 1)%(maglevTableSize-1) + 1
+	}
+
+	table := make([]int32, maglevTableSize)
+	for i := range table {
+		table[i] = -1
+	}
+	for filled := 0; filled < maglevTableSize; {
+		for i, backend := range backends {
+			for turn := 0; turn < backendWeight(backend) && filled < maglevTableSize; turn++ {
+				// Take the next free entry of the backend's permutation
+				entry := (offsets[i] + next[i]*skips[i]) % maglevTableSize
+				for table[entry] >= 0 {
+					next[i]++
+					entry = (offsets[i] + next[i]*skips[i]) % maglevTableSize
+				}
+				table[entry] = int32(i)
+				next[i]++
+				filled++
+			}
+		}
+	}
+
+	ids := make([]string, len(backends))
+	for i, backend := range backends {
+		ids[i] = backend.ID
+	}
+	return func(key string) string {
+		return ids[table[maglevHash(key, 0)%maglevTableSize]]
+	}
+}
+
+// maglevHash returns the 64-bit FNV-1a hash of value with a seed byte
+func maglevHash(value string, seed byte) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte{seed})
+	h.Write([]byte(value))
+	return h.Sum64()
+}
+
+// backendWeight returns the weight of a backend, at least 1
+func backendWeight(backend *Backend) int {
+	if backend.Weight <= 0 {
+		return 1
+	}
+	return backend.Weight
+}
@@ -0,0 +1,279 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestBackends creates backends with the given weights, named backend1..N
+func newTestBackends(weights ...int) []*Backend {
+	backends := make([]*Backend, len(weights))
+	for i, weight := range weights {
+		id := fmt.Sprintf("backend%d", i+1)
+		backends[i] = &Backend{ID: id, URL: mustParseURL("http://" + id + ".example.com"), Weight: weight, IsActive: true}
+	}
+	return backends
+}
+
+func TestNewLoadBalancer(t *testing.T) {
+	for _, lbType := range []string{"", LoadBalancerRoundRobin, LoadBalancerWeightedRoundRobin, LoadBalancerLeastConnections,
+		LoadBalancerLeastResponseTime, LoadBalancerPowerOfTwoChoices, LoadBalancerKetama, LoadBalancerMaglev} {
+		lb, err := NewLoadBalancer(&ProxyConfig{LoadBalancerType: lbType})
+		if err != nil {
+			t.Fatalf("Failed to create %q load balancer: %v", lbType, err)
+		}
+		if want := lbType; want != "" && lb.Type() != want {
+			t.Errorf("Expected type %s, got %s", want, lb.Type())
+		}
+	}
+
+	if _, err := NewLoadBalancer(&ProxyConfig{LoadBalancerType: "random"}); err == nil {
+		t.Error("Expected an error for an unsupported type")
+	}
+	if _, err := NewLoadBalancer(&ProxyConfig{LoadBalancerType: LoadBalancerKetama, LoadBalancerHashKey: "query:id"}); err == nil {
+		t.Error("Expected an error for an invalid hash key")
+	}
+}
+
+func TestWeightedRoundRobinLoadBalancer(t *testing.T) {
+	lb := NewWeightedRoundRobinLoadBalancer()
+	backends := newTestBackends(5, 1, 1)
+
+	// The smooth sequence of nginx interleaves the lighter backends
+	var sequence []string
+	for i := 0; i < 7; i++ {
+		backend, err := lb.SelectBackend(backends)
+		if err != nil {
+			t.Fatalf("Failed to select backend: %v", err)
+		}
+		sequence = append(sequence, strings.TrimPrefix(backend.ID, "backend"))
+	}
+	if got := strings.Join(sequence, ""); got != "1121311" {
+		t.Errorf("Expected sequence 1121311, got %s", got)
+	}
+}
+
+func TestLeastConnectionsLoadBalancer(t *testing.T) {
+	lb := NewLeastConnectionsLoadBalancer()
+	backends := newTestBackends(1, 2)
+
+	// Requests in flight are spread by weight: backend2 takes two for each of backend1
+	counts := map[string]int{}
+	for i := 0; i < 6; i++ {
+		backend, _ := lb.SelectBackend(backends)
+		counts[backend.ID]++
+	}
+	if counts["backend1"] != 2 || counts["backend2"] != 4 {
+		t.Errorf("Expected 2 and 4 requests in flight, got %v", counts)
+	}
+
+	// Finished requests free their backend
+	lb.UpdateBackend("backend1", true, time.Millisecond)
+	lb.UpdateBackend("backend1", true, time.Millisecond)
+	if backend, _ := lb.SelectBackend(backends); backend.ID != "backend1" {
+		t.Errorf("Expected the idle backend1, got %s", backend.ID)
+	}
+}
+
+func TestLeastResponseTimeLoadBalancer(t *testing.T) {
+	lb := NewLeastResponseTimeLoadBalancer()
+	backends := newTestBackends(1, 1)
+
+	// Backends without timings are tried first
+	first, _ := lb.SelectBackend(backends)
+	second, _ := lb.SelectBackend(backends)
+	if first.ID == second.ID {
+		t.Fatalf("Expected both untimed backends to be tried, got %s twice", first.ID)
+	}
+	lb.UpdateBackend("backend1", true, 200*time.Millisecond)
+	lb.UpdateBackend("backend2", true, 20*time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		backend, _ := lb.SelectBackend(backends)
+		if backend.ID != "backend2" {
+			t.Fatalf("Expected the faster backend2, got %s", backend.ID)
+		}
+		lb.UpdateBackend(backend.ID, true, 20*time.Millisecond)
+	}
+
+	// Failures do not make a backend look fast
+	lb.UpdateBackend("backend1", false, time.Microsecond)
+	if backend, _ := lb.SelectBackend(backends); backend.ID != "backend2" {
+		t.Errorf("Expected the faster backend2 after a failure of backend1, got %s", backend.ID)
+	}
+}
+
+func TestPowerOfTwoChoicesLoadBalancer(t *testing.T) {
+	lb := NewPowerOfTwoChoicesLoadBalancer()
+	backends := newTestBackends(1, 1)
+
+	// With two backends both are compared, so requests in flight alternate
+	counts := map[string]int{}
+	for i := 0; i < 10; i++ {
+		backend, _ := lb.SelectBackend(backends)
+		counts[backend.ID]++
+	}
+	if counts["backend1"] != 5 || counts["backend2"] != 5 {
+		t.Errorf("Expected requests in flight to be balanced, got %v", counts)
+	}
+
+	if backend, err := lb.SelectBackend(backends[:1]); err != nil || backend.ID != "backend1" {
+		t.Errorf("Expected the only backend, got %v, %v", backend, err)
+	}
+}
+
+func TestConsistentHashLoadBalancers(t *testing.T) {
+	for _, lbType := range []string{LoadBalancerKetama, LoadBalancerMaglev} {
+		t.Run(lbType, func(t *testing.T) {
+			lb, err := NewLoadBalancer(&ProxyConfig{LoadBalancerType: lbType, LoadBalancerHashKey: "header:X-User"})
+			if err != nil {
+				t.Fatalf("Failed to create load balancer: %v", err)
+			}
+			hashing := lb.(RequestLoadBalancer)
+			backends := newTestBackends(1, 1, 2)
+
+			route := func(backends []*Backend, user string) string {
+				request := &Request{Header: http.Header{"X-User": {user}}}
+				backend, err := hashing.SelectBackendForRequest(request, backends)
+				if err != nil {
+					t.Fatalf("Failed to select backend: %v", err)
+				}
+				return backend.ID
+			}
+
+			// Keys are spread by weight and stick to their backend
+			routes := map[string]string{}
+			counts := map[string]int{}
+			for i := 0; i < 4000; i++ {
+				user := fmt.Sprintf("user-%d", i)
+				routes[user] = route(backends, user)
+				counts[routes[user]]++
+			}
+			if route(backends, "user-7") != routes["user-7"] {
+				t.Error("Expected the same key to select the same backend")
+			}
+			if counts["backend3"] < 1600 || counts["backend1"] < 700 || counts["backend2"] < 700 {
+				t.Errorf("Expected keys spread 1:1:2, got %v", counts)
+			}
+
+			// Removing a backend only moves its own keys, in any backend order
+			remaining := []*Backend{backends[2], backends[0]}
+			moved := 0
+			for user, id := range routes {
+				now := route(remaining, user)
+				if id != "backend2" && now != id {
+					moved++
+				}
+				if now == "backend2" {
+					t.Fatalf("Expected no keys on the removed backend")
+				}
+			}
+			if moved > 4000/100 {
+				t.Errorf("Expected keys of the remaining backends to stay, %d moved", moved)
+			}
+
+			// Requests without the key are balanced round-robin
+			first, _ := hashing.SelectBackendForRequest(&Request{Header: http.Header{}}, backends)
+			second, _ := hashing.SelectBackendForRequest(&Request{Header: http.Header{}}, backends)
+			if first.ID == second.ID {
+				t.Error("Expected requests without a key to be spread")
+			}
+		})
+	}
+}
+
+func TestHashLoadBalancer_KeepsPreviousTable(t *testing.T) {
+	lb := &hashLoadBalancer{key: func(request *Request) string { return request.Header.Get("X-User") }}
+	builds := 0
+	build := func(backends []*Backend) func(key string) string {
+		builds++
+		id := backends[0].ID
+		return func(key string) string { return id }
+	}
+
+	backends := newTestBackends(1, 1, 1)
+	request := &Request{Header: http.Header{"X-User": {"ada"}}}
+	for i := 0; i < 10; i++ {
+		lb.selectBackend(request, backends, build)
+		lb.selectBackend(request, backends[1:], build)
+	}
+	if builds != 2 {
+		t.Errorf("Expected a table per set of backends, got %d builds", builds)
+	}
+
+	lb.selectBackend(request, backends[2:], build)
+	if builds != 3 {
+		t.Errorf("Expected a new set of backends to build a table, got %d builds", builds)
+	}
+}
+
+func TestParseHashKey(t *testing.T) {
+	request := &Request{
+		RemoteAddr: "192.0.2.1:4000",
+		Header:     http.Header{"X-Tenant": {"acme"}, "Cookie": {"sticky=abc; other=1"}},
+	}
+	tests := map[string]string{
+		"":                "192.0.2.1",
+		"ip":              "192.0.2.1",
+		"header:X-Tenant": "acme",
+		"cookie:sticky":   "abc",
+		"cookie:missing":  "",
+	}
+	for spec, want := range tests {
+		key, err := parseHashKey(spec)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", spec, err)
+		}
+		if got := key(request); got != want {
+			t.Errorf("Expected key %q for %q, got %q", want, spec, got)
+		}
+	}
+	for _, spec := range []string{"header:", "cookie", "path"} {
+		if _, err := parseHashKey(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestProxyForwardStickyRouting(t *testing.T) {
+	config := DefaultProxyConfig()
+	config.HealthCheckEnabled = false
+	config.CacheEnabled = false
+	config.LoadBalancerType = LoadBalancerMaglev
+	config.LoadBalancerHashKey = "cookie:sticky"
+	pm := NewProxyManager(config, nil)
+
+	for i := 1; i <= 3; i++ {
+		name := fmt.Sprintf("backend%d", i)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer server.Close()
+		backendURL, _ := url.Parse(server.URL)
+		pm.AddBackend(&Backend{ID: name, URL: backendURL, IsActive: true})
+	}
+
+	forward := func(sticky string) string {
+		reqURL, _ := url.Parse("http://example.com/")
+		request := &Request{Method: "GET", URL: reqURL, Header: http.Header{"Cookie": {"sticky=" + sticky}}}
+		response, err := pm.Forward(&mockContext{}, request)
+		if err != nil {
+			t.Fatalf("Failed to forward request: %v", err)
+		}
+		return string(response.Body)
+	}
+
+	for _, sticky := range []string{"a", "b", "c", "d"} {
+		first := forward(sticky)
+		for i := 0; i < 3; i++ {
+			if got := forward(sticky); got != first {
+				t.Errorf("Expected %s to stick to %s, got %s", sticky, first, got)
+			}
+		}
+	}
+}
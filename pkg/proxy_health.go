@@ -0,0 +1,622 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health check types
+const (
+	HealthCheckHTTP = "http" // GET the health check path and check status and body
+	HealthCheckTCP  = "tcp"  // Open a TCP connection to the backend
+)
+
+const (
+	// healthCheckResolution is the longest time between two looks for
+	// backends due for an active check
+	healthCheckResolution = time.Second
+
+	// healthCheckMaxBody limits how much of a health check response is
+	// matched against HealthCheckBodyMatch
+	healthCheckMaxBody = 64 * 1024
+
+	// outlierLatencySamples limits the latencies kept per backend and window
+	outlierLatencySamples = 1000
+
+	// outlierMinAdmission is the share of requests a backend returning from
+	// ejection receives at first
+	outlierMinAdmission = 0.1
+)
+
+// startHealthChecks starts periodic health checks. Backends are checked
+// every HealthCheckInterval, or their own HealthCheckInterval when set.
+func (pm *proxyManager) startHealthChecks() {
+	resolution := healthCheckResolution
+	if pm.config.HealthCheckInterval > 0 && pm.config.HealthCheckInterval < resolution {
+		resolution = pm.config.HealthCheckInterval
+	}
+
+	pm.healthCheckWg.Add(1)
+	go func() {
+		defer pm.healthCheckWg.Done()
+
+		ticker := time.NewTicker(resolution)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				pm.checkDueBackends()
+			case <-pm.stopHealthCheck:
+				return
+			}
+		}
+	}()
+}
+
+// checkDueBackends checks the backends whose interval passed since their
+// last check
+func (pm *proxyManager) checkDueBackends() {
+	pm.backendsMu.RLock()
+	backends := make([]*Backend, 0, len(pm.backends))
+	for _, backend := range pm.backends {
+		backends = append(backends, backend)
+	}
+	pm.backendsMu.RUnlock()
+
+	now := time.Now()
+	due := make([]*Backend, 0, len(backends))
+	pm.healthMu.RLock()
+	for _, backend := range backends {
+		interval := backend.HealthCheckInterval
+		if interval <= 0 {
+			interval = pm.config.HealthCheckInterval
+		}
+		if health := pm.healthStatus[backend.ID]; health == nil || now.Sub(health.LastCheck) >= interval {
+			due = append(due, backend)
+		}
+	}
+	pm.healthMu.RUnlock()
+
+	pm.checkBackends(due)
+}
+
+// checkBackends actively checks backends concurrently
+func (pm *proxyManager) checkBackends(backends []*Backend) {
+	var wg sync.WaitGroup
+	for _, backend := range backends {
+		wg.Add(1)
+		go func(b *Backend) {
+			defer wg.Done()
+			pm.checkBackendHealth(b)
+		}(backend)
+	}
+	wg.Wait()
+}
+
+// checkBackendHealth checks the health of a single backend. A backend
+// changes state after HealthCheckHealthyThreshold passed or
+// HealthCheckUnhealthyThreshold failed checks in a row.
+func (pm *proxyManager) checkBackendHealth(backend *Backend) {
+	startTime := time.Now()
+	err := pm.probeBackend(backend)
+	responseTime := time.Since(startTime)
+
+	pm.healthMu.Lock()
+	defer pm.healthMu.Unlock()
+
+	health := pm.healthStatus[backend.ID]
+	if health == nil {
+		health = &BackendHealth{BackendID: backend.ID, IsHealthy: true}
+		pm.healthStatus[backend.ID] = health
+	}
+
+	health.LastCheck = time.Now()
+	health.ResponseTime = responseTime
+
+	if err == nil {
+		health.LastSuccess = time.Now()
+		health.ConsecutiveFails = 0
+		health.ConsecutiveSuccesses++
+		health.ErrorMessage = ""
+		if !health.IsHealthy && health.ConsecutiveSuccesses >= healthThreshold(pm.config.HealthCheckHealthyThreshold) {
+			health.IsHealthy = true
+		}
+	} else {
+		health.LastFailure = time.Now()
+		health.ConsecutiveSuccesses = 0
+		health.ConsecutiveFails++
+		health.ErrorMessage = err.Error()
+		if health.IsHealthy && health.ConsecutiveFails >= healthThreshold(pm.config.HealthCheckUnhealthyThreshold) {
+			health.IsHealthy = false
+		}
+	}
+}
+
+// healthThreshold returns the number of checks in a row changing the state
+// of a backend, at least one
+func healthThreshold(threshold int) int {
+	if threshold < 1 {
+		return 1
+	}
+	return threshold
+}
+
+// probeBackend runs the active check of backend, returning why it failed
+func (pm *proxyManager) probeBackend(backend *Backend) error {
+	timeout := backend.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = pm.config.HealthCheckTimeout
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	checkType := backend.HealthCheckType
+	if checkType == "" {
+		checkType = pm.config.HealthCheckType
+	}
+
+	switch checkType {
+	case HealthCheckTCP:
+		return probeTCP(ctx, backend.URL)
+	case HealthCheckHTTP, "":
+		return pm.probeHTTP(ctx, backend)
+	default:
+		return fmt.Errorf("unsupported health check type: %s", checkType)
+	}
+}
+
+// probeHTTP requests the health check path of backend, expecting one of
+// HealthCheckExpectedStatus (any 2xx when empty) and a body matching
+// HealthCheckBodyMatch
+func (pm *proxyManager) probeHTTP(ctx context.Context, backend *Backend) error {
+	healthPath := backend.HealthCheckPath
+	if healthPath == "" {
+		healthPath = pm.config.HealthCheckPath
+	}
+
+	healthURL := *backend.URL
+	healthURL.Path = healthPath
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !expectedHealthStatus(pm.config.HealthCheckExpectedStatus, resp.StatusCode) {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+
+	if pm.healthBodyMatch != nil {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, healthCheckMaxBody))
+		if err != nil {
+			return err
+		}
+		if !pm.healthBodyMatch.Match(body) {
+			return errors.New("health check body does not match")
+		}
+	}
+
+	return nil
+}
+
+// expectedHealthStatus reports whether status passes a health check
+func expectedHealthStatus(expected []int, status int) bool {
+	if len(expected) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, code := range expected {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// probeTCP connects to the host of target, on the default port of its
+// scheme when it has none
+func probeTCP(ctx context.Context, target *url.URL) error {
+	address := target.Host
+	if target.Port() == "" {
+		port := "80"
+		if target.Scheme == "https" || target.Scheme == "wss" {
+			port = "443"
+		}
+		address = net.JoinHostPort(target.Hostname(), port)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// compileHealthBodyMatch compiles HealthCheckBodyMatch, returning nil when
+// it is empty
+func compileHealthBodyMatch(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid health check body match: %w", err)
+	}
+	return re, nil
+}
+
+// recordOutcome feeds the result of a proxied request to passive health
+// checking. Requests do not mark a backend unhealthy themselves: active
+// checks decide IsHealthy, while outlier detection ejects backends that
+// fail their traffic.
+func (pm *proxyManager) recordOutcome(backendID string, success bool, responseTime time.Duration) {
+	if success {
+		pm.circuitBreaker.RecordSuccess(backendID)
+	} else {
+		pm.circuitBreaker.RecordFailure(backendID)
+	}
+
+	if pm.outliers == nil {
+		return
+	}
+
+	pm.backendsMu.RLock()
+	total := len(pm.backends)
+	pm.backendsMu.RUnlock()
+
+	ejections := pm.outliers.record(backendID, success, responseTime, total, time.Now())
+	if ejections > 0 {
+		atomic.AddInt64(&pm.metrics.OutlierEjections, int64(ejections))
+	}
+}
+
+// outlierDetector ejects backends whose error rate or latency percentile
+// over an interval exceeds the configured limits. Ejected backends return
+// after OutlierBaseEjectionTime, multiplied by the number of times they
+// were ejected, and receive a growing share of requests over
+// OutlierRecoveryPeriod.
+type outlierDetector struct {
+	config *ProxyConfig
+
+	mu          sync.Mutex
+	windowStart time.Time
+	backends    map[string]*outlierStats
+}
+
+// outlierStats holds the requests of a backend in the current interval
+// and its ejection state
+type outlierStats struct {
+	requests  int
+	failures  int
+	latencies []time.Duration
+
+	ejections    int
+	ejectedUntil time.Time
+	reason       string
+}
+
+// newOutlierDetector creates an outlier detector, or returns nil when
+// outlier detection is disabled
+func newOutlierDetector(config *ProxyConfig) *outlierDetector {
+	if !config.OutlierDetectionEnabled {
+		return nil
+	}
+	return &outlierDetector{
+		config:      config,
+		windowStart: time.Now(),
+		backends:    make(map[string]*outlierStats),
+	}
+}
+
+// record adds a request to the current interval of backendID, analysing
+// the interval once it is over. total is the number of backends, which
+// limits how many can be ejected. It returns the number of backends
+// ejected.
+func (od *outlierDetector) record(backendID string, success bool, latency time.Duration, total int, now time.Time) int {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	ejections := 0
+	if now.Sub(od.windowStart) >= od.config.OutlierInterval {
+		ejections = od.analyze(total, now)
+	}
+
+	stats := od.stats(backendID)
+	stats.requests++
+	if !success {
+		stats.failures++
+		return ejections
+	}
+
+	// Keep a uniform sample of the latencies of successful requests
+	if len(stats.latencies) < outlierLatencySamples {
+		stats.latencies = append(stats.latencies, latency)
+	} else if i := rand.Intn(stats.requests); i < outlierLatencySamples {
+		stats.latencies[i] = latency
+	}
+
+	return ejections
+}
+
+// analyze ejects the outliers of the interval ending now and starts the
+// next one. Callers hold od.mu.
+func (od *outlierDetector) analyze(total int, now time.Time) int {
+	ejected := 0
+	for _, stats := range od.backends {
+		if now.Before(stats.ejectedUntil) {
+			ejected++
+		}
+	}
+	maxEjected := total * od.config.OutlierMaxEjectionPercent / 100
+
+	// Judge backends in a stable order, so the same ones are ejected first
+	ids := make([]string, 0, len(od.backends))
+	for id := range od.backends {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	ejections := 0
+	for _, id := range ids {
+		stats := od.backends[id]
+		if now.Before(stats.ejectedUntil) || stats.requests < od.config.OutlierMinRequests || stats.requests == 0 {
+			continue
+		}
+
+		reason := od.outlierReason(stats)
+		if reason == "" {
+			// A backend behaving after its recovery is forgiven an ejection
+			if stats.ejections > 0 && now.After(stats.ejectedUntil.Add(od.config.OutlierRecoveryPeriod)) {
+				stats.ejections--
+			}
+			continue
+		}
+		if ejected >= maxEjected {
+			continue
+		}
+
+		stats.ejections++
+		ejectionTime := od.config.OutlierBaseEjectionTime * time.Duration(stats.ejections)
+		if max := od.config.OutlierMaxEjectionTime; max > 0 && ejectionTime > max {
+			ejectionTime = max
+		}
+		stats.ejectedUntil = now.Add(ejectionTime)
+		stats.reason = reason
+		ejected++
+		ejections++
+	}
+
+	for _, stats := range od.backends {
+		stats.requests = 0
+		stats.failures = 0
+		stats.latencies = stats.latencies[:0]
+	}
+	od.windowStart = now
+
+	return ejections
+}
+
+// outlierReason returns why stats make an outlier, or "" when they do not
+func (od *outlierDetector) outlierReason(stats *outlierStats) string {
+	errorRate := float64(stats.failures) / float64(stats.requests)
+	if od.config.OutlierErrorRate > 0 && errorRate >= od.config.OutlierErrorRate {
+		return fmt.Sprintf("error rate %.0f%%", errorRate*100)
+	}
+
+	if od.config.OutlierLatencyThreshold > 0 && od.config.OutlierLatencyPercentile > 0 && len(stats.latencies) > 0 {
+		latency := latencyPercentile(stats.latencies, od.config.OutlierLatencyPercentile)
+		if latency > od.config.OutlierLatencyThreshold {
+			return fmt.Sprintf("p%g latency %s", od.config.OutlierLatencyPercentile*100, latency)
+		}
+	}
+
+	return ""
+}
+
+// admit reports whether backendID may receive a request. Ejected backends
+// are refused; returning backends are admitted with a probability growing
+// linearly over OutlierRecoveryPeriod.
+func (od *outlierDetector) admit(backendID string, now time.Time) bool {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	stats := od.backends[backendID]
+	if stats == nil || stats.ejectedUntil.IsZero() {
+		return true
+	}
+	if now.Before(stats.ejectedUntil) {
+		return false
+	}
+
+	recovered := now.Sub(stats.ejectedUntil)
+	if od.config.OutlierRecoveryPeriod <= 0 || recovered >= od.config.OutlierRecoveryPeriod {
+		return true
+	}
+	share := math.Max(outlierMinAdmission, float64(recovered)/float64(od.config.OutlierRecoveryPeriod))
+	return rand.Float64() < share
+}
+
+// ejected reports whether backendID is ejected at now
+func (od *outlierDetector) ejected(backendID string, now time.Time) bool {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	stats := od.backends[backendID]
+	return stats != nil && now.Before(stats.ejectedUntil)
+}
+
+// recovering reports whether backendID returned from an ejection less than
+// OutlierRecoveryPeriod before now, so that admit refuses some requests
+func (od *outlierDetector) recovering(backendID string, now time.Time) bool {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	stats := od.backends[backendID]
+	if stats == nil || stats.ejectedUntil.IsZero() || now.Before(stats.ejectedUntil) {
+		return false
+	}
+	return now.Sub(stats.ejectedUntil) < od.config.OutlierRecoveryPeriod
+}
+
+// status fills the ejection state of health
+func (od *outlierDetector) status(health *BackendHealth, now time.Time) {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	stats := od.backends[health.BackendID]
+	if stats == nil {
+		return
+	}
+	health.Ejections = stats.ejections
+	if now.Before(stats.ejectedUntil) {
+		health.Ejected = true
+		health.EjectedUntil = stats.ejectedUntil
+		health.EjectionReason = stats.reason
+	}
+}
+
+// remove forgets a removed backend
+func (od *outlierDetector) remove(backendID string) {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	delete(od.backends, backendID)
+}
+
+// stats returns the statistics of backendID. Callers hold od.mu.
+func (od *outlierDetector) stats(backendID string) *outlierStats {
+	stats := od.backends[backendID]
+	if stats == nil {
+		stats = &outlierStats{}
+		od.backends[backendID] = stats
+	}
+	return stats
+}
+
+// latencyPercentile returns the p-th percentile (0-1) of latencies
+func latencyPercentile(latencies []time.Duration, p float64) time.Duration {
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	index := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
+
+// retryBudget limits retries to a ratio of the requests over a sliding
+// window, so retries cannot multiply the load on struggling backends.
+// RetryBudgetMinRetries retries per window are always allowed, so quiet
+// proxies can still retry.
+type retryBudget struct {
+	ratio      float64
+	minRetries int
+	window     time.Duration
+
+	mu           sync.Mutex
+	windowStart  time.Time
+	requests     int
+	retries      int
+	prevRequests int
+	prevRetries  int
+}
+
+// newRetryBudget creates a retry budget, or returns nil when retries are
+// not limited
+func newRetryBudget(config *ProxyConfig) *retryBudget {
+	if config.RetryBudgetRatio <= 0 {
+		return nil
+	}
+	window := config.RetryBudgetWindow
+	if window <= 0 {
+		window = 10 * time.Second
+	}
+	return &retryBudget{
+		ratio:       config.RetryBudgetRatio,
+		minRetries:  config.RetryBudgetMinRetries,
+		window:      window,
+		windowStart: time.Now(),
+	}
+}
+
+// recordRequest counts a request towards the budget
+func (rb *retryBudget) recordRequest(now time.Time) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.advance(now)
+	rb.requests++
+}
+
+// withdraw takes a retry from the budget, reporting false when it is
+// exhausted
+func (rb *retryBudget) withdraw(now time.Time) bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.advance(now)
+
+	// Weigh the previous window by how much of it still overlaps the
+	// sliding window
+	overlap := 1 - float64(now.Sub(rb.windowStart))/float64(rb.window)
+	requests := float64(rb.requests) + float64(rb.prevRequests)*overlap
+	retries := float64(rb.retries) + float64(rb.prevRetries)*overlap
+
+	allowed := math.Max(float64(rb.minRetries), requests*rb.ratio)
+	if retries+1 > allowed {
+		return false
+	}
+	rb.retries++
+	return true
+}
+
+// advance moves the budget to the window containing now. Callers hold
+// rb.mu.
+func (rb *retryBudget) advance(now time.Time) {
+	elapsed := now.Sub(rb.windowStart)
+	if elapsed < rb.window {
+		return
+	}
+	if elapsed < 2*rb.window {
+		rb.prevRequests, rb.prevRetries = rb.requests, rb.retries
+		rb.windowStart = rb.windowStart.Add(rb.window)
+	} else {
+		rb.prevRequests, rb.prevRetries = 0, 0
+		rb.windowStart = now
+	}
+	rb.requests, rb.retries = 0, 0
+}
+
+// retryDelay returns the delay before the given retry attempt
+func (pm *proxyManager) retryDelay(attempt int) time.Duration {
+	if pm.config.RetryBackoff {
+		return time.Duration(math.Pow(2, float64(attempt-1))) * pm.config.RetryDelay
+	}
+	return pm.config.RetryDelay
+}
@@ -0,0 +1,406 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newHealthProxy creates a proxy manager without background health checks
+func newHealthProxy(configure func(config *ProxyConfig)) *proxyManager {
+	config := DefaultProxyConfig()
+	config.HealthCheckEnabled = false
+	config.CacheEnabled = false
+	if configure != nil {
+		configure(config)
+	}
+	return NewProxyManager(config, nil).(*proxyManager)
+}
+
+func TestProxyActiveHealthCheck_HTTP(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	var body atomic.Value
+	body.Store(`{"status":"ok"}`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(int(status.Load()))
+		w.Write([]byte(body.Load().(string)))
+	}))
+	defer server.Close()
+
+	pm := newHealthProxy(func(config *ProxyConfig) {
+		config.HealthCheckPath = "/ready"
+		config.HealthCheckExpectedStatus = []int{http.StatusOK, http.StatusTooManyRequests}
+		config.HealthCheckBodyMatch = `"status":\s*"ok"`
+		config.HealthCheckHealthyThreshold = 2
+		config.HealthCheckUnhealthyThreshold = 2
+	})
+	backendURL, _ := url.Parse(server.URL)
+	pm.AddBackend(&Backend{ID: "backend1", URL: backendURL, IsActive: true})
+
+	check := func() *BackendHealth {
+		pm.HealthCheck()
+		return pm.GetHealthStatus()["backend1"]
+	}
+
+	// Listed statuses pass, as long as the body matches
+	status.Store(http.StatusTooManyRequests)
+	if health := check(); !health.IsHealthy || health.ConsecutiveSuccesses != 1 {
+		t.Fatalf("Expected an expected status to pass, got %+v", health)
+	}
+
+	// A single failed check does not make the backend unhealthy
+	body.Store(`{"status":"degraded"}`)
+	if health := check(); !health.IsHealthy || health.ConsecutiveFails != 1 || health.ErrorMessage == "" {
+		t.Fatalf("Expected the backend to stay healthy after one failure, got %+v", health)
+	}
+	status.Store(http.StatusNoContent)
+	body.Store(`{"status":"ok"}`)
+	if health := check(); health.IsHealthy {
+		t.Fatalf("Expected the backend to be unhealthy after two failures, got %+v", health)
+	}
+	if len(pm.getAvailableBackends()) != 0 {
+		t.Error("Expected the unhealthy backend not to be available")
+	}
+
+	// Nor does a single passed check make it healthy again
+	status.Store(http.StatusOK)
+	if health := check(); health.IsHealthy {
+		t.Fatalf("Expected the backend to stay unhealthy after one pass, got %+v", health)
+	}
+	if health := check(); !health.IsHealthy {
+		t.Fatalf("Expected the backend to be healthy after two passes, got %+v", health)
+	}
+}
+
+func TestProxyActiveHealthCheck_TCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	pm := newHealthProxy(func(config *ProxyConfig) {
+		config.HealthCheckUnhealthyThreshold = 1
+	})
+	backendURL, _ := url.Parse("http://" + listener.Addr().String())
+	pm.AddBackend(&Backend{ID: "backend1", URL: backendURL, IsActive: true, HealthCheckType: HealthCheckTCP})
+
+	pm.HealthCheck()
+	if health := pm.GetHealthStatus()["backend1"]; !health.IsHealthy {
+		t.Fatalf("Expected the listening backend to be healthy, got %+v", health)
+	}
+
+	listener.Close()
+	pm.HealthCheck()
+	if health := pm.GetHealthStatus()["backend1"]; health.IsHealthy {
+		t.Fatalf("Expected the closed backend to be unhealthy, got %+v", health)
+	}
+}
+
+func TestOutlierDetector_ErrorRate(t *testing.T) {
+	config := DefaultProxyConfig()
+	config.OutlierMinRequests = 10
+	config.OutlierMaxEjectionPercent = 50
+	od := newOutlierDetector(config)
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		od.record("backend1", i > 0, time.Millisecond, 4, now)
+		od.record("backend2", false, time.Millisecond, 4, now)
+		od.record("backend3", false, time.Millisecond, 4, now)
+		od.record("backend4", false, time.Millisecond, 4, now)
+	}
+	od.record("backend5", false, time.Millisecond, 4, now)
+
+	// Half of the four backends may be ejected at once
+	now = now.Add(config.OutlierInterval)
+	if ejections := od.analyze(4, now); ejections != 2 {
+		t.Fatalf("Expected 2 ejections, got %d", ejections)
+	}
+	for id, want := range map[string]bool{"backend1": true, "backend2": false, "backend3": false, "backend4": true, "backend5": true} {
+		if got := od.admit(id, now); got != want {
+			t.Errorf("Expected admit(%s) to be %v", id, want)
+		}
+	}
+	health := &BackendHealth{BackendID: "backend2"}
+	od.status(health, now)
+	if !health.Ejected || health.Ejections != 1 || health.EjectionReason != "error rate 100%" {
+		t.Errorf("Unexpected ejection state %+v", health)
+	}
+
+	// A backend ejected again stays out longer
+	od.record("backend2", true, time.Millisecond, 4, now)
+	returned := now.Add(config.OutlierBaseEjectionTime)
+	for i := 0; i < 10; i++ {
+		od.record("backend2", false, time.Millisecond, 4, returned)
+	}
+	od.analyze(4, returned)
+	if od.admit("backend2", returned.Add(config.OutlierBaseEjectionTime*3/2)) {
+		t.Error("Expected the second ejection to last twice as long")
+	}
+}
+
+func TestOutlierDetector_LatencyAndRecovery(t *testing.T) {
+	config := DefaultProxyConfig()
+	config.OutlierMinRequests = 100
+	config.OutlierLatencyPercentile = 0.9
+	config.OutlierLatencyThreshold = 100 * time.Millisecond
+	od := newOutlierDetector(config)
+
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		// One in twenty slow requests stays below the 90th percentile
+		latency := 10 * time.Millisecond
+		if i%20 == 0 {
+			latency = time.Second
+		}
+		od.record("backend1", true, latency, 2, now)
+
+		latency = 10 * time.Millisecond
+		if i%5 == 0 {
+			latency = time.Second
+		}
+		od.record("backend2", true, latency, 2, now)
+	}
+	if ejections := od.analyze(2, now); ejections != 1 {
+		t.Fatalf("Expected backend2 to be ejected, got %d ejections", ejections)
+	}
+
+	// Once back, the share of requests grows over the recovery period
+	back := now.Add(config.OutlierBaseEjectionTime)
+	admittedShare := func(at time.Time) float64 {
+		admitted := 0
+		for i := 0; i < 2000; i++ {
+			if od.admit("backend2", at) {
+				admitted++
+			}
+		}
+		return float64(admitted) / 2000
+	}
+	early := admittedShare(back.Add(config.OutlierRecoveryPeriod / 10))
+	late := admittedShare(back.Add(config.OutlierRecoveryPeriod * 8 / 10))
+	if early > 0.2 || late < 0.7 || late > 0.9 {
+		t.Errorf("Expected about 10%% then 80%% of requests, got %.2f and %.2f", early, late)
+	}
+	if share := admittedShare(back.Add(config.OutlierRecoveryPeriod)); share != 1 {
+		t.Errorf("Expected all requests after the recovery period, got %.2f", share)
+	}
+}
+
+func TestProxySelectBackend_ConsistentHashingWithRecoveringBackend(t *testing.T) {
+	pm := newHealthProxy(func(config *ProxyConfig) {
+		config.LoadBalancerType = LoadBalancerKetama
+		config.LoadBalancerHashKey = "header:X-User"
+		config.OutlierRecoveryPeriod = time.Hour
+	})
+	for _, backend := range newTestBackends(1, 1, 1) {
+		pm.AddBackend(backend)
+	}
+
+	// backend2 returned from an ejection a moment ago
+	pm.outliers.stats("backend2").ejectedUntil = time.Now().Add(-time.Second)
+	backends := pm.getAvailableBackends()
+	if len(backends) != 3 {
+		t.Fatalf("Expected the recovering backend to be available, got %d backends", len(backends))
+	}
+
+	hashing := pm.loadBalancer.(RequestLoadBalancer)
+	recovering := 0
+	for i := 0; i < 3000; i++ {
+		request := &Request{Header: http.Header{"X-User": {fmt.Sprintf("user-%d", i)}}}
+		owner, _ := hashing.SelectBackendForRequest(request, backends)
+		backend, err := pm.selectBackend(request, backends)
+		if err != nil {
+			t.Fatalf("Failed to select backend: %v", err)
+		}
+		if owner.ID != "backend2" && backend.ID != owner.ID {
+			t.Fatalf("Expected %s to stay on %s, got %s", request.Header.Get("X-User"), owner.ID, backend.ID)
+		}
+		if backend.ID == "backend2" {
+			recovering++
+		}
+	}
+	if recovering == 0 || recovering > 200 {
+		t.Errorf("Expected the recovering backend to receive about 10%% of its keys, got %d requests", recovering)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(&ProxyConfig{RetryBudgetRatio: 0.1, RetryBudgetMinRetries: 2, RetryBudgetWindow: time.Second})
+
+	// The minimum applies while there are few requests
+	now := time.Now()
+	if !budget.withdraw(now) || !budget.withdraw(now) || budget.withdraw(now) {
+		t.Fatal("Expected exactly the minimum of 2 retries")
+	}
+
+	// Then retries follow the requests
+	for i := 0; i < 50; i++ {
+		budget.recordRequest(now)
+	}
+	allowed := 0
+	for budget.withdraw(now) {
+		allowed++
+	}
+	if allowed != 3 {
+		t.Errorf("Expected 5 retries for 50 requests, 3 more than used, got %d", allowed)
+	}
+
+	// The previous window counts less the further it slides away
+	if budget.withdraw(now.Add(time.Second)) {
+		t.Error("Expected the previous window to still count")
+	}
+	if !budget.withdraw(now.Add(1900 * time.Millisecond)) {
+		t.Error("Expected the budget to recover as the window slides")
+	}
+
+	if newRetryBudget(&ProxyConfig{}) != nil {
+		t.Error("Expected no budget without a ratio")
+	}
+}
+
+func TestProxyForward_RetriesHonourContext(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	pm := newHealthProxy(func(config *ProxyConfig) {
+		config.RetryDelay = 5 * time.Second
+		config.CircuitBreakerEnabled = false
+	})
+	backendURL, _ := url.Parse(server.URL)
+	pm.AddBackend(&Backend{ID: "backend1", URL: backendURL, IsActive: true})
+
+	reqCtx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	reqURL, _ := url.Parse("http://example.com/")
+	request := &Request{Method: "GET", URL: reqURL, Header: make(http.Header)}
+
+	start := time.Now()
+	_, err := pm.Forward(NewContext(request, nil, reqCtx), request)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the cancellation, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the retry delay to end with the request, took %s", elapsed)
+	}
+	if attempts.Load() != 1 {
+		t.Errorf("Expected a single attempt, got %d", attempts.Load())
+	}
+}
+
+func TestProxyForward_PerTryTimeout(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Write([]byte("fast"))
+	}))
+	defer server.Close()
+
+	pm := newHealthProxy(func(config *ProxyConfig) {
+		config.PerTryTimeout = 50 * time.Millisecond
+		config.RetryDelay = time.Millisecond
+	})
+	backendURL, _ := url.Parse(server.URL)
+	pm.AddBackend(&Backend{ID: "backend1", URL: backendURL, IsActive: true})
+
+	reqURL, _ := url.Parse("http://example.com/")
+	request := &Request{Method: "GET", URL: reqURL, Header: make(http.Header)}
+	start := time.Now()
+	response, err := pm.Forward(&mockContext{}, request)
+	if err != nil || string(response.Body) != "fast" {
+		t.Fatalf("Expected the retry to succeed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the slow attempt to be cut short, took %s", elapsed)
+	}
+}
+
+func TestProxyForward_RetryBudgetExhausted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	pm := newHealthProxy(func(config *ProxyConfig) {
+		config.RetryDelay = time.Millisecond
+		config.RetryBackoff = false
+		config.CircuitBreakerEnabled = false
+		config.RetryBudgetMinRetries = 1
+	})
+	backendURL, _ := url.Parse(server.URL)
+	pm.AddBackend(&Backend{ID: "backend1", URL: backendURL, IsActive: true})
+
+	reqURL, _ := url.Parse("http://example.com/")
+	request := &Request{Method: "GET", URL: reqURL, Header: make(http.Header)}
+	if _, err := pm.Forward(&mockContext{}, request); err == nil {
+		t.Fatal("Expected the request to fail")
+	}
+
+	metrics := pm.GetMetrics()
+	if metrics.TotalRetries != 1 || metrics.RetryBudgetExhausted != 1 {
+		t.Errorf("Expected one retry before the budget ran out, got %d retries, %d exhausted", metrics.TotalRetries, metrics.RetryBudgetExhausted)
+	}
+}
+
+func TestProxyForward_EjectsOutliers(t *testing.T) {
+	pm := newHealthProxy(func(config *ProxyConfig) {
+		config.MaxRetries = 0
+		config.CircuitBreakerEnabled = false
+		config.OutlierInterval = 200 * time.Millisecond
+		config.OutlierMinRequests = 5
+	})
+	for i, status := range []int{http.StatusOK, http.StatusInternalServerError} {
+		status := status
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		defer server.Close()
+		backendURL, _ := url.Parse(server.URL)
+		pm.AddBackend(&Backend{ID: fmt.Sprintf("backend%d", i+1), URL: backendURL, IsActive: true})
+	}
+
+	forward := func() error {
+		reqURL, _ := url.Parse("http://example.com/")
+		_, err := pm.Forward(&mockContext{}, &Request{Method: "GET", URL: reqURL, Header: make(http.Header)})
+		return err
+	}
+	for i := 0; i < 20; i++ {
+		forward()
+	}
+	time.Sleep(pm.config.OutlierInterval)
+	forward()
+
+	health := pm.GetHealthStatus()["backend2"]
+	if !health.Ejected || !health.IsHealthy {
+		t.Fatalf("Expected the failing backend to be ejected but not unhealthy, got %+v", health)
+	}
+	if pm.GetMetrics().OutlierEjections != 1 {
+		t.Errorf("Expected one ejection, got %d", pm.GetMetrics().OutlierEjections)
+	}
+	for i := 0; i < 10; i++ {
+		if err := forward(); err != nil {
+			t.Fatalf("Expected requests to avoid the ejected backend, got %v", err)
+		}
+	}
+}
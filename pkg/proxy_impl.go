@@ -6,9 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math"
 	"net"
 	"net/http"
+	"regexp"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -26,10 +27,18 @@ type proxyManager struct {
 	config  *ProxyConfig
 	metrics *ProxyMetrics
 
-	healthStatus map[string]*BackendHealth
-	healthMu     sync.RWMutex
+	healthStatus    map[string]*BackendHealth
+	healthMu        sync.RWMutex
+	healthBodyMatch *regexp.Regexp
 
-	cache CacheManager
+	outliers    *outlierDetector // nil when outlier detection is disabled
+	retryBudget *retryBudget     // nil when retries are not limited
+
+	cache     CacheManager
+	httpCache *httpCache
+
+	upgradeTransport     *http.Transport // HTTP/1.1 transport for upgrade requests
+	upgradeTransportOnce sync.Once
 
 	stopHealthCheck chan struct{}
 	healthCheckWg   sync.WaitGroup
@@ -47,11 +56,24 @@ func NewProxyManager(config *ProxyConfig, cache CacheManager) ProxyManager {
 		metrics:         &ProxyMetrics{BackendMetrics: make(map[string]*BackendMetrics)},
 		healthStatus:    make(map[string]*BackendHealth),
 		cache:           cache,
+		outliers:        newOutlierDetector(config),
+		retryBudget:     newRetryBudget(config),
 		stopHealthCheck: make(chan struct{}),
 	}
 
+	bodyMatch, err := compileHealthBodyMatch(config.HealthCheckBodyMatch)
+	if err != nil {
+		fmt.Printf("WARN: %v, not matching health check bodies\n", err)
+	}
+	pm.healthBodyMatch = bodyMatch
+
 	// Initialize load balancer
-	pm.loadBalancer = NewRoundRobinLoadBalancer()
+	lb, err := NewLoadBalancer(config)
+	if err != nil {
+		fmt.Printf("WARN: %v, using round_robin load balancing\n", err)
+		lb = NewRoundRobinLoadBalancer()
+	}
+	pm.loadBalancer = lb
 
 	// Initialize circuit breaker
 	pm.circuitBreaker = NewCircuitBreaker(config)
@@ -59,6 +81,15 @@ func NewProxyManager(config *ProxyConfig, cache CacheManager) ProxyManager {
 	// Initialize connection pool
 	pm.connectionPool = NewConnectionPool(config)
 
+	// Initialize response cache
+	if cache != nil {
+		pm.httpCache = newHTTPCache(cache, HTTPCacheConfig{
+			Name:       "rockstar-proxy",
+			KeyPrefix:  "proxy:",
+			DefaultTTL: config.CacheTTL,
+		})
+	}
+
 	// Start health checks if enabled
 	if config.HealthCheckEnabled {
 		pm.startHealthChecks()
@@ -125,6 +156,10 @@ func (pm *proxyManager) RemoveBackend(backendID string) error {
 	delete(pm.healthStatus, backendID)
 	pm.healthMu.Unlock()
 
+	if pm.outliers != nil {
+		pm.outliers.remove(backendID)
+	}
+
 	return nil
 }
 
@@ -154,33 +189,83 @@ func (pm *proxyManager) ListBackends() []*Backend {
 	return backends
 }
 
-// Forward forwards a request to a backend server
+// Forward forwards a request to a backend server. With CacheEnabled,
+// responses are cached following RFC 9111.
 func (pm *proxyManager) Forward(ctx Context, request *Request) (*Response, error) {
-	startTime := time.Now()
+	if !pm.config.CacheEnabled || pm.httpCache == nil {
+		return pm.forwardWithRetries(ctx, request)
+	}
 
+	lookup, cached := pm.httpCache.lookup(request)
+	if cached != nil {
+		atomic.AddInt64(&pm.metrics.CacheHits, 1)
+		return cached, nil
+	}
+
+	forwarded := request
+	if !lookup.bypass {
+		atomic.AddInt64(&pm.metrics.CacheMisses, 1)
+
+		// Revalidate the stored response
+		if validators := lookup.validators(); len(validators) > 0 {
+			conditional := *request
+			conditional.Header = request.Header.Clone()
+			for name, values := range validators {
+				conditional.Header[name] = values
+			}
+			forwarded = &conditional
+		}
+	}
+
+	response, err := pm.forwardWithRetries(ctx, forwarded)
+	return pm.httpCache.complete(lookup, request, response, err)
+}
+
+// forwardWithRetries forwards a request to the available backends until one
+// succeeds or the retries are exhausted. RequestTimeout limits all attempts
+// together, PerTryTimeout each of them, and the request context cancels
+// them along with the delays between them.
+func (pm *proxyManager) forwardWithRetries(ctx Context, request *Request) (*Response, error) {
 	// Get available backends
 	availableBackends := pm.getAvailableBackends()
 	if len(availableBackends) == 0 {
 		return nil, errors.New("no available backends")
 	}
 
+	parent := context.Background()
+	if ctx != nil && ctx.Context() != nil {
+		parent = ctx.Context()
+	}
+	if pm.config.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		parent, cancel = context.WithTimeout(parent, pm.config.RequestTimeout)
+		defer cancel()
+	}
+
+	if pm.retryBudget != nil {
+		pm.retryBudget.recordRequest(time.Now())
+	}
+
 	// Try forwarding with retries
 	var lastErr error
 	for attempt := 0; attempt <= pm.config.MaxRetries; attempt++ {
 		if attempt > 0 {
+			if pm.retryBudget != nil && !pm.retryBudget.withdraw(time.Now()) {
+				atomic.AddInt64(&pm.metrics.RetryBudgetExhausted, 1)
+				return nil, fmt.Errorf("retry budget exhausted: %w", lastErr)
+			}
+
 			// Record retry
 			atomic.AddInt64(&pm.metrics.TotalRetries, 1)
 
 			// Apply retry delay with optional backoff
-			delay := pm.config.RetryDelay
-			if pm.config.RetryBackoff {
-				delay = time.Duration(math.Pow(2, float64(attempt-1))) * pm.config.RetryDelay
+			if err := sleepContext(parent, pm.retryDelay(attempt)); err != nil {
+				return nil, fmt.Errorf("request ended after %d attempts: %w", attempt, err)
 			}
-			time.Sleep(delay)
 		}
 
 		// Select backend using load balancer
-		backend, err := pm.loadBalancer.SelectBackend(availableBackends)
+		backend, err := pm.selectBackend(request, availableBackends)
 		if err != nil {
 			lastErr = err
 			continue
@@ -188,50 +273,40 @@ func (pm *proxyManager) Forward(ctx Context, request *Request) (*Response, error
 
 		// Check circuit breaker
 		if pm.circuitBreaker.IsOpen(backend.ID) {
+			pm.loadBalancer.UpdateBackend(backend.ID, false, 0)
 			lastErr = fmt.Errorf("circuit breaker open for backend %s", backend.ID)
 			continue
 		}
 
-		// Try to get from cache if enabled
-		if pm.config.CacheEnabled && request.Method == "GET" {
-			cacheKey := pm.getCacheKey(backend.ID, request)
-			if cached, err := pm.cache.Get(cacheKey); err == nil && cached != nil {
-				atomic.AddInt64(&pm.metrics.CacheHits, 1)
-				if response, ok := cached.(*Response); ok {
-					return response, nil
-				}
-			}
-			atomic.AddInt64(&pm.metrics.CacheMisses, 1)
-		}
-
 		// Forward request
-		response, err := pm.forwardToBackend(ctx, backend, request)
+		startTime := time.Now()
+		response, err := pm.forwardToBackend(parent, backend, request)
 
 		responseTime := time.Since(startTime)
 
 		if err != nil {
 			// Record failure
-			pm.circuitBreaker.RecordFailure(backend.ID)
 			pm.loadBalancer.UpdateBackend(backend.ID, false, responseTime)
-			pm.updateBackendHealth(backend.ID, false, err.Error())
 			pm.recordMetrics(backend.ID, false, responseTime)
 
+			// A cancelled request says nothing about the backend
+			if errors.Is(parent.Err(), context.Canceled) {
+				return nil, fmt.Errorf("request cancelled: %w", parent.Err())
+			}
+			pm.recordOutcome(backend.ID, false, responseTime)
+
+			if parent.Err() != nil {
+				return nil, fmt.Errorf("request timed out after %d attempts: %w", attempt+1, err)
+			}
 			lastErr = err
 			continue
 		}
 
 		// Record success
-		pm.circuitBreaker.RecordSuccess(backend.ID)
 		pm.loadBalancer.UpdateBackend(backend.ID, true, responseTime)
-		pm.updateBackendHealth(backend.ID, true, "")
+		pm.recordOutcome(backend.ID, true, responseTime)
 		pm.recordMetrics(backend.ID, true, responseTime)
 
-		// Cache successful GET responses
-		if pm.config.CacheEnabled && request.Method == "GET" && response.StatusCode == 200 {
-			cacheKey := pm.getCacheKey(backend.ID, request)
-			pm.cache.Set(cacheKey, response, pm.config.CacheTTL)
-		}
-
 		return response, nil
 	}
 
@@ -245,7 +320,7 @@ func (pm *proxyManager) ForwardHTTP(ctx context.Context, req *http.Request) (*ht
 		return nil, errors.New("no available backends")
 	}
 
-	backend, err := pm.loadBalancer.SelectBackend(availableBackends)
+	backend, err := pm.selectBackend(&Request{Header: req.Header, RemoteAddr: req.RemoteAddr}, availableBackends)
 	if err != nil {
 		return nil, err
 	}
@@ -253,6 +328,7 @@ func (pm *proxyManager) ForwardHTTP(ctx context.Context, req *http.Request) (*ht
 	// Get connection from pool
 	client, err := pm.connectionPool.GetConnection(backend.ID)
 	if err != nil {
+		pm.loadBalancer.UpdateBackend(backend.ID, false, 0)
 		return nil, err
 	}
 
@@ -261,18 +337,50 @@ func (pm *proxyManager) ForwardHTTP(ctx context.Context, req *http.Request) (*ht
 	req.URL.Host = backend.URL.Host
 
 	// Forward request
+	startTime := time.Now()
 	resp, err := client.Do(req)
-	if err != nil {
-		pm.circuitBreaker.RecordFailure(backend.ID)
-		return nil, err
+	responseTime := time.Since(startTime)
+	success := err == nil && resp.StatusCode < 500
+	pm.loadBalancer.UpdateBackend(backend.ID, success, responseTime)
+	if err == nil || !errors.Is(ctx.Err(), context.Canceled) {
+		pm.recordOutcome(backend.ID, success, responseTime)
+	}
+	return resp, err
+}
+
+// selectBackend selects a backend for request, letting load balancers that
+// implement RequestLoadBalancer see the request. Other load balancers choose
+// among the backends admitted by outlier detection. Request load balancers,
+// which hash requests to backends, choose among all backends so that their
+// tables only change with the set of backends; if the chosen backend is
+// recovering from an ejection and not admitted, they choose again among the
+// backends that are not recovering.
+func (pm *proxyManager) selectBackend(request *Request, backends []*Backend) (*Backend, error) {
+	now := time.Now()
+	lb, ok := pm.loadBalancer.(RequestLoadBalancer)
+	if !ok {
+		return pm.loadBalancer.SelectBackend(pm.admittedBackends(backends, now))
+	}
+
+	backend, err := lb.SelectBackendForRequest(request, backends)
+	if err != nil || pm.outliers == nil || pm.outliers.admit(backend.ID, now) {
+		return backend, err
+	}
+	settled := make([]*Backend, 0, len(backends))
+	for _, candidate := range backends {
+		if !pm.outliers.recovering(candidate.ID, now) {
+			settled = append(settled, candidate)
+		}
 	}
-
-	pm.circuitBreaker.RecordSuccess(backend.ID)
-	return resp, nil
+	if len(settled) == 0 {
+		return backend, nil
+	}
+	return lb.SelectBackendForRequest(request, settled)
 }
 
-// forwardToBackend forwards a request to a specific backend
-func (pm *proxyManager) forwardToBackend(ctx Context, backend *Backend, request *Request) (*Response, error) {
+// forwardToBackend forwards a request to a specific backend, within
+// PerTryTimeout of ctx
+func (pm *proxyManager) forwardToBackend(ctx context.Context, backend *Backend, request *Request) (*Response, error) {
 	// Create HTTP request
 	targetURL := *backend.URL
 	targetURL.Path = request.URL.Path
@@ -291,9 +399,12 @@ func (pm *proxyManager) forwardToBackend(ctx Context, backend *Backend, request
 	}
 
 	// Set timeout
-	httpCtx, cancel := context.WithTimeout(context.Background(), pm.config.RequestTimeout)
-	defer cancel()
-	httpReq = httpReq.WithContext(httpCtx)
+	if pm.config.PerTryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pm.config.PerTryTimeout)
+		defer cancel()
+	}
+	httpReq = httpReq.WithContext(ctx)
 
 	// Get connection from pool
 	client, err := pm.connectionPool.GetConnection(backend.ID)
@@ -330,7 +441,9 @@ func (pm *proxyManager) forwardToBackend(ctx Context, backend *Backend, request
 	return response, nil
 }
 
-// getAvailableBackends returns backends that are active and healthy
+// getAvailableBackends returns backends that are active, healthy and not
+// ejected. Backends returning from an ejection are included; selectBackend
+// admits them to a share of the requests.
 func (pm *proxyManager) getAvailableBackends() []*Backend {
 	pm.backendsMu.RLock()
 	defer pm.backendsMu.RUnlock()
@@ -357,9 +470,44 @@ func (pm *proxyManager) getAvailableBackends() []*Backend {
 		available = append(available, backend)
 	}
 
+	// Keep a stable order for load balancers rotating through the backends
+	sort.Slice(available, func(i, j int) bool { return available[i].ID < available[j].ID })
+
+	// Leave out ejected outliers, unless that leaves no backends at all
+	if pm.outliers != nil {
+		now := time.Now()
+		remaining := make([]*Backend, 0, len(available))
+		for _, backend := range available {
+			if !pm.outliers.ejected(backend.ID, now) {
+				remaining = append(remaining, backend)
+			}
+		}
+		if len(remaining) > 0 {
+			available = remaining
+		}
+	}
+
 	return available
 }
 
+// admittedBackends returns the backends admitted by outlier detection at
+// now, or all of them if none is admitted
+func (pm *proxyManager) admittedBackends(backends []*Backend, now time.Time) []*Backend {
+	if pm.outliers == nil {
+		return backends
+	}
+	admitted := make([]*Backend, 0, len(backends))
+	for _, backend := range backends {
+		if pm.outliers.admit(backend.ID, now) {
+			admitted = append(admitted, backend)
+		}
+	}
+	if len(admitted) == 0 {
+		return backends
+	}
+	return admitted
+}
+
 // SetLoadBalancer sets the load balancer
 func (pm *proxyManager) SetLoadBalancer(lb LoadBalancer) error {
 	if lb == nil {
@@ -411,129 +559,28 @@ func (pm *proxyManager) HealthCheck() error {
 	}
 	pm.backendsMu.RUnlock()
 
-	var wg sync.WaitGroup
-	for _, backend := range backends {
-		wg.Add(1)
-		go func(b *Backend) {
-			defer wg.Done()
-			pm.checkBackendHealth(b)
-		}(backend)
-	}
-
-	wg.Wait()
+	pm.checkBackends(backends)
 	return nil
 }
 
-// checkBackendHealth checks the health of a single backend
-func (pm *proxyManager) checkBackendHealth(backend *Backend) {
-	startTime := time.Now()
-
-	healthPath := backend.HealthCheckPath
-	if healthPath == "" {
-		healthPath = pm.config.HealthCheckPath
-	}
-
-	healthURL := *backend.URL
-	healthURL.Path = healthPath
-
-	client := &http.Client{
-		Timeout: pm.config.HealthCheckTimeout,
-	}
-
-	resp, err := client.Get(healthURL.String())
-	responseTime := time.Since(startTime)
-
-	isHealthy := err == nil && resp != nil && resp.StatusCode >= 200 && resp.StatusCode < 300
-
-	if resp != nil {
-		resp.Body.Close()
-	}
-
-	errorMsg := ""
-	if err != nil {
-		errorMsg = err.Error()
-	}
-
-	pm.healthMu.Lock()
-	health := pm.healthStatus[backend.ID]
-	if health == nil {
-		health = &BackendHealth{BackendID: backend.ID}
-		pm.healthStatus[backend.ID] = health
-	}
-
-	health.LastCheck = time.Now()
-	health.ResponseTime = responseTime
-	health.IsHealthy = isHealthy
-	health.ErrorMessage = errorMsg
-
-	if isHealthy {
-		health.LastSuccess = time.Now()
-		health.ConsecutiveFails = 0
-	} else {
-		health.LastFailure = time.Now()
-		health.ConsecutiveFails++
-	}
-	pm.healthMu.Unlock()
-}
-
-// startHealthChecks starts periodic health checks
-func (pm *proxyManager) startHealthChecks() {
-	pm.healthCheckWg.Add(1)
-	go func() {
-		defer pm.healthCheckWg.Done()
-
-		ticker := time.NewTicker(pm.config.HealthCheckInterval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				pm.HealthCheck()
-			case <-pm.stopHealthCheck:
-				return
-			}
-		}
-	}()
-}
-
 // GetHealthStatus returns health status for all backends
 func (pm *proxyManager) GetHealthStatus() map[string]*BackendHealth {
 	pm.healthMu.RLock()
 	defer pm.healthMu.RUnlock()
 
+	now := time.Now()
 	status := make(map[string]*BackendHealth)
 	for id, health := range pm.healthStatus {
-		status[id] = health
+		health := *health
+		if pm.outliers != nil {
+			pm.outliers.status(&health, now)
+		}
+		status[id] = &health
 	}
 
 	return status
 }
 
-// updateBackendHealth updates backend health status
-func (pm *proxyManager) updateBackendHealth(backendID string, isHealthy bool, errorMsg string) {
-	pm.healthMu.Lock()
-	defer pm.healthMu.Unlock()
-
-	health := pm.healthStatus[backendID]
-	if health == nil {
-		health = &BackendHealth{BackendID: backendID}
-		pm.healthStatus[backendID] = health
-	}
-
-	health.IsHealthy = isHealthy
-	health.LastCheck = time.Now()
-
-	if isHealthy {
-		health.LastSuccess = time.Now()
-		health.ConsecutiveFails = 0
-		health.ErrorMessage = ""
-	} else {
-		health.LastFailure = time.Now()
-		health.ConsecutiveFails++
-		health.ErrorMessage = errorMsg
-	}
-}
-
 // recordMetrics records request metrics
 func (pm *proxyManager) recordMetrics(backendID string, success bool, responseTime time.Duration) {
 	pm.metrics.mu.Lock()
@@ -580,15 +627,17 @@ func (pm *proxyManager) GetMetrics() *ProxyMetrics {
 
 	// Create a copy to avoid race conditions
 	metrics := &ProxyMetrics{
-		TotalRequests:       atomic.LoadInt64(&pm.metrics.TotalRequests),
-		SuccessfulRequests:  atomic.LoadInt64(&pm.metrics.SuccessfulRequests),
-		FailedRequests:      atomic.LoadInt64(&pm.metrics.FailedRequests),
-		TotalResponseTime:   pm.metrics.TotalResponseTime,
-		AverageResponseTime: pm.metrics.AverageResponseTime,
-		CacheHits:           atomic.LoadInt64(&pm.metrics.CacheHits),
-		CacheMisses:         atomic.LoadInt64(&pm.metrics.CacheMisses),
-		TotalRetries:        atomic.LoadInt64(&pm.metrics.TotalRetries),
-		BackendMetrics:      make(map[string]*BackendMetrics),
+		TotalRequests:        atomic.LoadInt64(&pm.metrics.TotalRequests),
+		SuccessfulRequests:   atomic.LoadInt64(&pm.metrics.SuccessfulRequests),
+		FailedRequests:       atomic.LoadInt64(&pm.metrics.FailedRequests),
+		TotalResponseTime:    pm.metrics.TotalResponseTime,
+		AverageResponseTime:  pm.metrics.AverageResponseTime,
+		CacheHits:            atomic.LoadInt64(&pm.metrics.CacheHits),
+		CacheMisses:          atomic.LoadInt64(&pm.metrics.CacheMisses),
+		TotalRetries:         atomic.LoadInt64(&pm.metrics.TotalRetries),
+		RetryBudgetExhausted: atomic.LoadInt64(&pm.metrics.RetryBudgetExhausted),
+		OutlierEjections:     atomic.LoadInt64(&pm.metrics.OutlierEjections),
+		BackendMetrics:       make(map[string]*BackendMetrics),
 	}
 
 	for id, bm := range pm.metrics.BackendMetrics {
@@ -618,14 +667,11 @@ func (pm *proxyManager) ResetMetrics() {
 	atomic.StoreInt64(&pm.metrics.CacheHits, 0)
 	atomic.StoreInt64(&pm.metrics.CacheMisses, 0)
 	atomic.StoreInt64(&pm.metrics.TotalRetries, 0)
+	atomic.StoreInt64(&pm.metrics.RetryBudgetExhausted, 0)
+	atomic.StoreInt64(&pm.metrics.OutlierEjections, 0)
 	pm.metrics.BackendMetrics = make(map[string]*BackendMetrics)
 }
 
-// getCacheKey generates a cache key for a request
-func (pm *proxyManager) getCacheKey(backendID string, request *Request) string {
-	return fmt.Sprintf("proxy:%s:%s:%s", backendID, request.Method, request.RequestURI)
-}
-
 // RoundRobinLoadBalancer implements round-robin load balancing
 type roundRobinLoadBalancer struct {
 	current uint64
@@ -804,24 +850,37 @@ func (cp *connectionPool) GetConnection(backendID string) (*http.Client, error)
 		return client, nil
 	}
 
+	client = &http.Client{
+		Transport: newProxyTransport(cp.config),
+		Timeout:   cp.config.RequestTimeout,
+	}
+
+	cp.clients[backendID] = client
+	return client, nil
+}
+
+// newProxyTransport creates the transport for connections to a backend.
+// With H2CUpstreams, http:// backends are spoken to in HTTP/2 without TLS.
+func newProxyTransport(config *ProxyConfig) *http.Transport {
 	transport := &http.Transport{
-		MaxIdleConns:        cp.config.MaxConnectionsPerBackend,
-		MaxIdleConnsPerHost: cp.config.MaxConnectionsPerBackend,
-		IdleConnTimeout:     cp.config.IdleConnTimeout,
+		MaxIdleConns:        config.MaxConnectionsPerBackend,
+		MaxIdleConnsPerHost: config.MaxConnectionsPerBackend,
+		IdleConnTimeout:     config.IdleConnTimeout,
 		TLSClientConfig:     &tls.Config{InsecureSkipVerify: false},
 		DialContext: (&net.Dialer{
-			Timeout:   cp.config.ConnectionTimeout,
+			Timeout:   config.ConnectionTimeout,
 			KeepAlive: 30 * time.Second,
 		}).DialContext,
+		ForceAttemptHTTP2: true,
 	}
 
-	client = &http.Client{
-		Transport: transport,
-		Timeout:   cp.config.RequestTimeout,
+	if config.H2CUpstreams {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
 	}
 
-	cp.clients[backendID] = client
-	return client, nil
+	return transport
 }
 
 // ReleaseConnection releases a connection back to the pool
@@ -0,0 +1,440 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// hopByHopHeaders are the headers of a single connection, which a proxy
+// must not forward (RFC 9110, section 7.6.1)
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// ProxyHandler returns a route handler streaming requests to the backends
+// of pm. stripPrefix is removed from the request path before forwarding,
+// so a handler mounted on "/api/*path" with stripPrefix "/api" forwards
+// /api/users as /users. Proxy managers that do not implement ProxyStreamer
+// forward the request with Forward and send the buffered response.
+func ProxyHandler(pm ProxyManager, stripPrefix string) HandlerFunc {
+	forward := func(ctx Context) error {
+		if streamer, ok := pm.(ProxyStreamer); ok {
+			return streamer.Stream(ctx)
+		}
+		return forwardBuffered(ctx, pm)
+	}
+
+	return func(ctx Context) error {
+		request := ctx.Request()
+		if request == nil || stripPrefix == "" || request.URL == nil {
+			return forward(ctx)
+		}
+
+		original := request.URL
+		stripped := *original
+		stripped.Path = "/" + strings.TrimLeft(strings.TrimPrefix(original.Path, stripPrefix), "/")
+		stripped.RawPath = ""
+		request.URL = &stripped
+		defer func() { request.URL = original }()
+
+		return forward(ctx)
+	}
+}
+
+// forwardBuffered forwards the request of ctx with Forward and sends the
+// response once the backend has answered completely
+func forwardBuffered(ctx Context, pm ProxyManager) error {
+	request := ctx.Request()
+	if request == nil || request.URL == nil {
+		return errors.New("request is required")
+	}
+
+	response, err := pm.Forward(ctx, request)
+	if err != nil {
+		return ctx.String(http.StatusBadGateway, http.StatusText(http.StatusBadGateway))
+	}
+
+	w := ctx.Response()
+	header := w.Header()
+	for key, values := range response.Header {
+		header[key] = append([]string(nil), values...)
+	}
+	removeHopByHopHeaders(header)
+	w.WriteHeader(response.StatusCode)
+	_, err = w.Write(response.Body)
+	return err
+}
+
+// Stream forwards the request of ctx to a backend and streams the response
+// to the client as it arrives, flushing server-sent events and responses of
+// unknown length after every write. Trailers are passed on, WebSocket and
+// other upgrades are tunnelled, and the response is not cached. Failures
+// before the response starts are answered with 502 Bad Gateway.
+func (pm *proxyManager) Stream(ctx Context) error {
+	request := ctx.Request()
+	if request == nil || request.URL == nil {
+		return errors.New("request is required")
+	}
+
+	availableBackends := pm.getAvailableBackends()
+	if len(availableBackends) == 0 {
+		return pm.badGateway(ctx, errors.New("no available backends"))
+	}
+
+	backend, err := pm.selectBackend(request, availableBackends)
+	if err != nil {
+		return pm.badGateway(ctx, err)
+	}
+	if pm.circuitBreaker.IsOpen(backend.ID) {
+		pm.loadBalancer.UpdateBackend(backend.ID, false, 0)
+		return pm.badGateway(ctx, fmt.Errorf("circuit breaker open for backend %s", backend.ID))
+	}
+
+	upgrade := upgradeType(request.Header)
+	outReq, cancel, err := pm.newStreamRequest(ctx, backend, request, upgrade)
+	if err != nil {
+		pm.loadBalancer.UpdateBackend(backend.ID, false, 0)
+		return pm.badGateway(ctx, err)
+	}
+	defer cancel()
+
+	transport, err := pm.streamTransport(backend, upgrade != "")
+	if err != nil {
+		pm.loadBalancer.UpdateBackend(backend.ID, false, 0)
+		return pm.badGateway(ctx, err)
+	}
+
+	// Give up if the response does not start within the request timeout,
+	// without limiting how long it streams afterwards
+	startTime := time.Now()
+	var timer *time.Timer
+	if pm.config.RequestTimeout > 0 {
+		timer = time.AfterFunc(pm.config.RequestTimeout, cancel)
+	}
+	resp, err := transport.RoundTrip(outReq)
+	if timer != nil {
+		timer.Stop()
+	}
+	responseTime := time.Since(startTime)
+
+	success := err == nil && resp.StatusCode < 500
+	pm.loadBalancer.UpdateBackend(backend.ID, success, responseTime)
+	pm.recordMetrics(backend.ID, success, responseTime)
+	if err != nil {
+		// A client going away says nothing about the backend
+		if parent := ctx.Context(); parent == nil || !errors.Is(parent.Err(), context.Canceled) {
+			pm.recordOutcome(backend.ID, false, responseTime)
+		}
+		return pm.badGateway(ctx, err)
+	}
+	pm.recordOutcome(backend.ID, success, responseTime)
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return tunnelUpgrade(ctx, upgrade, resp)
+	}
+	return streamResponse(ctx, resp)
+}
+
+// newStreamRequest creates the request to backend, removing hop-by-hop
+// headers and adding the X-Forwarded-* and Forwarded headers
+func (pm *proxyManager) newStreamRequest(ctx Context, backend *Backend, request *Request, upgrade string) (*http.Request, context.CancelFunc, error) {
+	target := *backend.URL
+	target.Path, target.RawPath = joinURLPath(backend.URL, request.URL)
+	target.RawQuery = request.URL.RawQuery
+
+	var body io.Reader
+	contentLength := int64(0)
+	if request.Body != nil {
+		body = request.Body
+		contentLength = -1
+	} else if len(request.RawBody) > 0 {
+		body = bytes.NewReader(request.RawBody)
+		contentLength = int64(len(request.RawBody))
+	}
+
+	parent := context.Background()
+	if ctx.Context() != nil {
+		parent = ctx.Context()
+	}
+	reqCtx, cancel := context.WithCancel(parent)
+
+	outReq, err := http.NewRequestWithContext(reqCtx, request.Method, target.String(), body)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	outReq.ContentLength = contentLength
+
+	outReq.Header = request.Header.Clone()
+	if outReq.Header == nil {
+		outReq.Header = make(http.Header)
+	}
+	removeHopByHopHeaders(outReq.Header)
+
+	// Keep the request for trailers, which gRPC relies on
+	if httpguts.HeaderValuesContainsToken(request.Header["Te"], "trailers") {
+		outReq.Header.Set("Te", "trailers")
+	}
+	if upgrade != "" {
+		outReq.Header.Set("Connection", "Upgrade")
+		outReq.Header.Set("Upgrade", upgrade)
+	}
+
+	setForwardedHeaders(outReq.Header, request, requestScheme(ctx))
+	return outReq, cancel, nil
+}
+
+// streamTransport returns the transport of the backend. Upgrades always use
+// HTTP/1.1, since upgraded connections cannot be pooled or multiplexed.
+func (pm *proxyManager) streamTransport(backend *Backend, upgrade bool) (http.RoundTripper, error) {
+	if upgrade {
+		pm.upgradeTransportOnce.Do(func() {
+			transport := newProxyTransport(pm.config)
+			transport.Protocols = new(http.Protocols)
+			transport.Protocols.SetHTTP1(true)
+			pm.upgradeTransport = transport
+		})
+		return pm.upgradeTransport, nil
+	}
+
+	client, err := pm.connectionPool.GetConnection(backend.ID)
+	if err != nil {
+		return nil, err
+	}
+	if client.Transport == nil {
+		return http.DefaultTransport, nil
+	}
+	return client.Transport, nil
+}
+
+// badGateway answers a request that could not be forwarded
+func (pm *proxyManager) badGateway(ctx Context, err error) error {
+	if ctx.Response() == nil || ctx.Response().Written() {
+		return err
+	}
+	return ctx.String(http.StatusBadGateway, http.StatusText(http.StatusBadGateway))
+}
+
+// streamResponse copies the response of the backend to the client. Once the
+// response has started, errors can only be reported by cutting it short.
+func streamResponse(ctx Context, resp *http.Response) error {
+	w := ctx.Response()
+	header := w.Header()
+
+	removeHopByHopHeaders(resp.Header)
+	for key, values := range resp.Header {
+		header[key] = append([]string(nil), values...)
+	}
+
+	// Announce the trailers, whose values arrive after the body
+	if len(resp.Trailer) > 0 {
+		trailers := make([]string, 0, len(resp.Trailer))
+		for key := range resp.Trailer {
+			trailers = append(trailers, key)
+		}
+		header.Set("Trailer", strings.Join(trailers, ", "))
+	}
+
+	w.WriteHeader(resp.StatusCode)
+
+	flush := resp.ContentLength == -1 || isEventStream(resp.Header)
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				// The client went away
+				return nil
+			}
+			if flush {
+				w.Flush()
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			// The backend failed mid-response, leave the trailers out
+			return nil
+		}
+	}
+
+	for key, values := range resp.Trailer {
+		header[http.TrailerPrefix+key] = values
+	}
+	return nil
+}
+
+// tunnelUpgrade connects the client to the upgraded backend connection and
+// copies data both ways until either side closes
+func tunnelUpgrade(ctx Context, upgrade string, resp *http.Response) error {
+	if !strings.EqualFold(upgradeType(resp.Header), upgrade) {
+		return fmt.Errorf("backend switched to protocol %q, requested %q", upgradeType(resp.Header), upgrade)
+	}
+	backendConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		return errors.New("backend connection does not support upgrades")
+	}
+
+	hijacker, ok := ctx.Response().(http.Hijacker)
+	if !ok {
+		return errors.New("response writer does not support hijacking")
+	}
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		return fmt.Errorf("failed to hijack connection: %w", err)
+	}
+	defer clientConn.Close()
+
+	header := resp.Header.Clone()
+	removeHopByHopHeaders(header)
+	header.Set("Connection", "Upgrade")
+	header.Set("Upgrade", upgrade)
+
+	// The connection is no longer an HTTP response, errors end the tunnel
+	fmt.Fprintf(clientBuf, "HTTP/1.1 %d %s\r\n", resp.StatusCode, http.StatusText(resp.StatusCode))
+	header.Write(clientBuf)
+	clientBuf.WriteString("\r\n")
+	if err := clientBuf.Flush(); err != nil {
+		return nil
+	}
+
+	// Closing both connections when one side is done ends the other copy
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			clientConn.Close()
+			backendConn.Close()
+		})
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer closeBoth()
+		io.Copy(backendConn, clientBuf)
+	}()
+	io.Copy(clientConn, backendConn)
+	closeBoth()
+	<-done
+
+	return nil
+}
+
+// removeHopByHopHeaders removes the hop-by-hop headers, including those
+// listed in Connection
+func removeHopByHopHeaders(header http.Header) {
+	for _, value := range header["Connection"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+// upgradeType returns the protocol a request or response upgrades to, or
+// an empty string
+func upgradeType(header http.Header) string {
+	if !httpguts.HeaderValuesContainsToken(header["Connection"], "Upgrade") {
+		return ""
+	}
+	return header.Get("Upgrade")
+}
+
+// setForwardedHeaders appends the client to X-Forwarded-For and Forwarded
+// and sets X-Forwarded-Host and X-Forwarded-Proto
+func setForwardedHeaders(header http.Header, request *Request, scheme string) {
+	clientIP, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		clientIP = request.RemoteAddr
+	}
+
+	if clientIP != "" {
+		if prior := header.Values("X-Forwarded-For"); len(prior) > 0 {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		header.Set("X-Forwarded-For", clientIP)
+	}
+	if request.Host != "" {
+		header.Set("X-Forwarded-Host", request.Host)
+	}
+	header.Set("X-Forwarded-Proto", scheme)
+
+	// RFC 7239 quotes IPv6 addresses in brackets
+	element := []string{}
+	if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+		if strings.Contains(host, ":") {
+			host = `"[` + host + `]"`
+		}
+		element = append(element, "for="+host)
+	}
+	if request.Host != "" {
+		element = append(element, `host="`+request.Host+`"`)
+	}
+	element = append(element, "proto="+scheme)
+	header.Add("Forwarded", strings.Join(element, ";"))
+}
+
+// requestScheme returns the scheme the client used for the request
+func requestScheme(ctx Context) string {
+	if c, ok := ctx.(*contextImpl); ok && c.httpReq != nil && c.httpReq.TLS != nil {
+		return "https"
+	}
+	if request := ctx.Request(); request != nil && request.URL != nil && request.URL.Scheme != "" {
+		return request.URL.Scheme
+	}
+	return "http"
+}
+
+// isEventStream reports whether a response contains server-sent events
+func isEventStream(header http.Header) bool {
+	return strings.HasPrefix(header.Get("Content-Type"), "text/event-stream")
+}
+
+// joinURLPath appends the path of request to the path of the backend URL
+func joinURLPath(base, request *url.URL) (path, rawPath string) {
+	if base.RawPath == "" && request.RawPath == "" {
+		return singleJoiningSlash(base.Path, request.Path), ""
+	}
+
+	basePath, requestPath := base.EscapedPath(), request.EscapedPath()
+	joined := singleJoiningSlash(basePath, requestPath)
+	return singleJoiningSlash(base.Path, request.Path), joined
+}
+
+// singleJoiningSlash joins a and b with exactly one slash between them
+func singleJoiningSlash(a, b string) string {
+	aSlash := strings.HasSuffix(a, "/")
+	bSlash := strings.HasPrefix(b, "/")
+	switch {
+	case aSlash && bSlash:
+		return a + b[1:]
+	case !aSlash && !bSlash && b != "":
+		return a + "/" + b
+	}
+	return a + b
+}
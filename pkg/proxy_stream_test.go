@@ -0,0 +1,299 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStreamProxy creates a proxy manager forwarding to the given backend servers
+func newStreamProxy(t *testing.T, configure func(config *ProxyConfig), backends ...*httptest.Server) ProxyManager {
+	t.Helper()
+	config := DefaultProxyConfig()
+	config.HealthCheckEnabled = false
+	config.CacheEnabled = false
+	if configure != nil {
+		configure(config)
+	}
+	pm := NewProxyManager(config, nil)
+	for i, server := range backends {
+		backendURL, _ := url.Parse(server.URL)
+		pm.AddBackend(&Backend{ID: fmt.Sprintf("backend%d", i+1), URL: backendURL, IsActive: true})
+	}
+	return pm
+}
+
+// newProxyFrontend serves handler with framework contexts, like the server does
+func newProxyFrontend(handler HandlerFunc) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req := &Request{
+			Method:     r.Method,
+			URL:        r.URL,
+			Header:     r.Header,
+			Host:       r.Host,
+			RemoteAddr: r.RemoteAddr,
+			RawBody:    body,
+		}
+		ctx := NewContext(req, NewResponseWriter(w), r.Context())
+		if err := handler(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+}
+
+func TestProxyStream_ServerSentEvents(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprint(w, "data: second\n\n")
+	}))
+	defer backend.Close()
+
+	frontend := newProxyFrontend(ProxyHandler(newStreamProxy(t, nil, backend), ""))
+	defer frontend.Close()
+
+	resp, err := http.Get(frontend.URL + "/events")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The first event arrives while the backend is still holding the second
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "data: first\n" {
+		t.Fatalf("Expected the first event before the response ended, got %q, %v", line, err)
+	}
+	close(release)
+
+	rest, _ := io.ReadAll(reader)
+	if string(rest) != "\ndata: second\n\n" {
+		t.Errorf("Expected the second event, got %q", rest)
+	}
+}
+
+func TestProxyHandler_WithoutStreamer(t *testing.T) {
+	var path string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Header().Set("X-Backend", "yes")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, "buffered")
+	}))
+	defer backend.Close()
+
+	// Embedding the interface hides Stream, so the handler falls back to Forward
+	pm := struct{ ProxyManager }{newStreamProxy(t, nil, backend)}
+	frontend := newProxyFrontend(ProxyHandler(pm, "/api"))
+	defer frontend.Close()
+
+	resp, err := http.Get(frontend.URL + "/api/users")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if path != "/users" {
+		t.Errorf("Expected /users at the backend, got %s", path)
+	}
+	if resp.StatusCode != http.StatusAccepted || string(body) != "buffered" || resp.Header.Get("X-Backend") != "yes" {
+		t.Errorf("Unexpected response: %d %q %v", resp.StatusCode, body, resp.Header)
+	}
+}
+
+func TestProxyStream_Headers(t *testing.T) {
+	var received *http.Request
+	var receivedBody string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ := io.ReadAll(r.Body)
+		receivedBody = string(body)
+		w.Header().Set("Connection", "X-Backend-Hop")
+		w.Header().Set("X-Backend-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-Backend", "yes")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer backend.Close()
+
+	frontend := newProxyFrontend(ProxyHandler(newStreamProxy(t, nil, backend), "/api"))
+	defer frontend.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, frontend.URL+"/api/users?page=2", strings.NewReader(`{"name":"ada"}`))
+	req.Host = "shop.example.com"
+	req.Header.Set("Connection", "X-Client-Hop")
+	req.Header.Set("X-Client-Hop", "1")
+	req.Header.Set("Proxy-Authorization", "Basic secret")
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	req.Header.Set("X-Request", "kept")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if received.URL.Path != "/users" || received.URL.RawQuery != "page=2" {
+		t.Errorf("Expected /users?page=2 at the backend, got %s", received.URL)
+	}
+	if receivedBody != `{"name":"ada"}` {
+		t.Errorf("Expected the request body, got %q", receivedBody)
+	}
+	if received.Header.Get("X-Client-Hop") != "" || received.Header.Get("Proxy-Authorization") != "" {
+		t.Errorf("Expected hop-by-hop request headers to be removed, got %v", received.Header)
+	}
+	if received.Header.Get("X-Request") != "kept" {
+		t.Error("Expected end-to-end request headers to be forwarded")
+	}
+	if got := received.Header.Get("X-Forwarded-For"); got != "198.51.100.7, 127.0.0.1" {
+		t.Errorf("Expected the client to be appended to X-Forwarded-For, got %q", got)
+	}
+	if received.Header.Get("X-Forwarded-Host") != "shop.example.com" || received.Header.Get("X-Forwarded-Proto") != "http" {
+		t.Errorf("Expected X-Forwarded-Host and X-Forwarded-Proto, got %v", received.Header)
+	}
+	if got := received.Header.Get("Forwarded"); got != `for=127.0.0.1;host="shop.example.com";proto=http` {
+		t.Errorf("Unexpected Forwarded header %q", got)
+	}
+
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("X-Backend") != "yes" {
+		t.Errorf("Expected the backend response, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if resp.Header.Get("X-Backend-Hop") != "" || resp.Header.Get("Keep-Alive") != "" {
+		t.Errorf("Expected hop-by-hop response headers to be removed, got %v", resp.Header)
+	}
+}
+
+func TestProxyStream_Trailers(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		fmt.Fprint(w, "payload")
+		w.Header().Set("X-Checksum", "abc123")
+	}))
+	defer backend.Close()
+
+	frontend := newProxyFrontend(ProxyHandler(newStreamProxy(t, nil, backend), ""))
+	defer frontend.Close()
+
+	resp, err := http.Get(frontend.URL + "/download")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "payload" {
+		t.Errorf("Expected the body, got %q", body)
+	}
+	if got := resp.Trailer.Get("X-Checksum"); got != "abc123" {
+		t.Errorf("Expected the trailer to be passed on, got %q", got)
+	}
+}
+
+func TestProxyStream_WebSocketUpgrade(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, buf, _ := w.(http.Hijacker).Hijack()
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nX-Backend: yes\r\n\r\n")
+		buf.Flush()
+
+		// Echo until the client closes
+		io.Copy(conn, buf)
+	}))
+	defer backend.Close()
+
+	frontend := newProxyFrontend(ProxyHandler(newStreamProxy(t, nil, backend), ""))
+	defer frontend.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(frontend.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprint(conn, "GET /socket HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read upgrade response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "websocket" || resp.Header.Get("X-Backend") != "yes" {
+		t.Fatalf("Expected the backend to switch protocols, got %d %v", resp.StatusCode, resp.Header)
+	}
+
+	for _, message := range []string{"ping", "pong"} {
+		fmt.Fprint(conn, message)
+		echo := make([]byte, len(message))
+		if _, err := io.ReadFull(reader, echo); err != nil || string(echo) != message {
+			t.Fatalf("Expected %q through the tunnel, got %q, %v", message, echo, err)
+		}
+	}
+}
+
+func TestProxyStream_H2CUpstream(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetHTTP1(true)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	defer backend.Close()
+
+	for _, h2c := range []bool{false, true} {
+		pm := newStreamProxy(t, func(config *ProxyConfig) { config.H2CUpstreams = h2c }, backend)
+		frontend := newProxyFrontend(ProxyHandler(pm, ""))
+
+		resp, err := http.Get(frontend.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		frontend.Close()
+
+		want := "HTTP/1.1"
+		if h2c {
+			want = "HTTP/2.0"
+		}
+		if string(body) != want {
+			t.Errorf("Expected %s to the backend with H2CUpstreams=%v, got %s", want, h2c, body)
+		}
+	}
+}
+
+func TestProxyStream_BadGateway(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	backend.Close()
+
+	for name, pm := range map[string]ProxyManager{
+		"no backends":     newStreamProxy(t, nil),
+		"backend is down": newStreamProxy(t, nil, backend),
+	} {
+		frontend := newProxyFrontend(ProxyHandler(pm, ""))
+		resp, err := http.Get(frontend.URL)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", name, err)
+		}
+		resp.Body.Close()
+		frontend.Close()
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Errorf("%s: expected 502, got %d", name, resp.StatusCode)
+		}
+	}
+}
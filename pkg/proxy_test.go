@@ -478,6 +478,65 @@ func TestProxyCaching(t *testing.T) {
 	}
 }
 
+// TestProxyHTTPCaching tests that cached responses are revalidated and
+// served when the backend fails
+func TestProxyHTTPCaching(t *testing.T) {
+	var mu sync.Mutex
+	status := http.StatusOK
+	var conditional []string
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		w.Header().Set("Cache-Control", "no-cache, stale-if-error=60")
+		w.Header().Set("ETag", `"v1"`)
+		if status == http.StatusOK && r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("content"))
+	}))
+	defer backendServer.Close()
+
+	config := DefaultProxyConfig()
+	config.HealthCheckEnabled = false
+	config.CircuitBreakerEnabled = false
+	config.MaxRetries = 0
+	pm := NewProxyManager(config, NewCacheManager(CacheConfig{}))
+	backendURL, _ := url.Parse(backendServer.URL)
+	pm.AddBackend(&Backend{ID: "backend1", URL: backendURL, IsActive: true})
+
+	reqURL, _ := url.Parse("http://example.com/doc")
+	request := &Request{Method: "GET", URL: reqURL, Header: make(http.Header), RequestURI: "/doc"}
+
+	first, err := pm.Forward(&mockContext{}, request)
+	if err != nil || string(first.Body) != "content" {
+		t.Fatalf("Failed to forward request: %v", err)
+	}
+
+	// no-cache responses are revalidated on every request
+	second, err := pm.Forward(&mockContext{}, request)
+	if err != nil || second.StatusCode != http.StatusOK || string(second.Body) != "content" {
+		t.Fatalf("Expected the revalidated response, got %v, %+v", err, second)
+	}
+	if len(conditional) != 2 || conditional[0] != "" || conditional[1] != `"v1"` {
+		t.Errorf("Expected the second request to be conditional, got %q", conditional)
+	}
+	if request.Header.Get("If-None-Match") != "" {
+		t.Error("Expected the request of the caller to be unchanged")
+	}
+
+	// Server errors are replaced by the stored response
+	mu.Lock()
+	status = http.StatusServiceUnavailable
+	mu.Unlock()
+	third, err := pm.Forward(&mockContext{}, request)
+	if err != nil || string(third.Body) != "content" {
+		t.Errorf("Expected the stale response, got %v, %+v", err, third)
+	}
+}
+
 // TestProxyMetrics tests metrics collection
 func TestProxyMetrics(t *testing.T) {
 	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
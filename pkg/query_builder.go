@@ -0,0 +1,1266 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLDialect identifies the SQL flavour a query is rendered for
+type SQLDialect string
+
+const (
+	DialectSQLite   SQLDialect = "sqlite"
+	DialectMySQL    SQLDialect = "mysql"
+	DialectPostgres SQLDialect = "postgres"
+	DialectMSSQL    SQLDialect = "mssql"
+)
+
+// DialectForDriver returns the dialect of a DatabaseConfig driver name
+func DialectForDriver(driverName string) (SQLDialect, error) {
+	switch driverName {
+	case "sqlite", "sqlite3":
+		return DialectSQLite, nil
+	case "mysql":
+		return DialectMySQL, nil
+	case "postgres":
+		return DialectPostgres, nil
+	case "mssql", "sqlserver":
+		return DialectMSSQL, nil
+	}
+	return "", fmt.Errorf("unsupported SQL dialect for driver %q", driverName)
+}
+
+// Placeholder returns the bind parameter for the n-th argument, starting at 1
+func (d SQLDialect) Placeholder(n int) string {
+	switch d {
+	case DialectPostgres:
+		return "$" + strconv.Itoa(n)
+	case DialectMSSQL:
+		return "@p" + strconv.Itoa(n)
+	default:
+		return "?"
+	}
+}
+
+// driverAware is implemented by database managers that know their driver
+type driverAware interface {
+	driverName() string
+}
+
+// queryExecutor is the part of DatabaseManager and Transaction a query needs
+type queryExecutor interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// QueryBuilder builds SQL statements for the dialect of a database and runs them.
+//
+// Table and column names passed to From, Insert, Update, Delete, Columns, Set,
+// OrderBy, OnConflict, Returning and the condition helpers are validated as
+// identifiers. Select columns, joins, GroupBy and Expr are SQL expressions and
+// must never contain user input; values always travel as bind parameters.
+type QueryBuilder struct {
+	exec    queryExecutor
+	dialect SQLDialect
+	scope   *tenantScope // Row filter of a tenant-scoped database
+	err     error
+}
+
+// NewQueryBuilder creates a query builder for the dialect of db. Statements run
+// through db, so on ctx.DB() they use the request context and join WithTx.
+// On a TenantDatabase with row isolation every statement is limited to the tenant.
+func NewQueryBuilder(db DatabaseManager) *QueryBuilder {
+	qb := &QueryBuilder{exec: db}
+	if scoped, ok := db.(tenantScopedDatabase); ok {
+		qb.scope = scoped.tenantScope()
+	}
+	if aware, ok := db.(driverAware); ok {
+		qb.dialect, qb.err = DialectForDriver(aware.driverName())
+	} else {
+		qb.err = fmt.Errorf("cannot determine the SQL dialect of the database manager")
+	}
+	return qb
+}
+
+// NewDialectQueryBuilder creates a query builder that only renders SQL for dialect
+func NewDialectQueryBuilder(dialect SQLDialect) *QueryBuilder {
+	qb := &QueryBuilder{dialect: dialect}
+	if _, err := DialectForDriver(string(dialect)); err != nil {
+		qb.err = err
+	}
+	return qb
+}
+
+// Dialect returns the dialect statements are rendered for
+func (qb *QueryBuilder) Dialect() SQLDialect {
+	return qb.dialect
+}
+
+// Using returns a builder whose statements run in tx
+func (qb *QueryBuilder) Using(tx Transaction) *QueryBuilder {
+	return &QueryBuilder{exec: tx, dialect: qb.dialect, scope: qb.scope, err: qb.err}
+}
+
+// tenantCondition returns the tenant predicate for table, or nil when the
+// builder is not tenant-scoped or the table holds no tenant rows
+func (qb *QueryBuilder) tenantCondition(table string, qualify bool) Condition {
+	if qb.scope == nil || !qb.scope.appliesTo(table) {
+		return nil
+	}
+	column := qb.scope.column
+	if qualify {
+		column = table + "." + column
+	}
+	return Eq(column, qb.scope.tenantID)
+}
+
+// Statement is a query that renders to SQL and bind arguments
+type Statement interface {
+	ToSQL() (string, []interface{}, error)
+	builder() *QueryBuilder
+}
+
+// Condition is a WHERE or HAVING predicate
+type Condition interface {
+	writeSQL(w *sqlWriter) error
+}
+
+// sqlWriter accumulates SQL text and bind arguments for one statement
+type sqlWriter struct {
+	dialect SQLDialect
+	sb      strings.Builder
+	args    []interface{}
+}
+
+// write appends SQL text
+func (w *sqlWriter) write(parts ...string) {
+	for _, part := range parts {
+		w.sb.WriteString(part)
+	}
+}
+
+// bind appends a bind parameter for value
+func (w *sqlWriter) bind(value interface{}) {
+	w.args = append(w.args, sqlValue(value))
+	w.sb.WriteString(w.dialect.Placeholder(len(w.args)))
+}
+
+// writeExpr appends a SQL expression, replacing each ? outside of string
+// literals with the dialect's placeholder for the next argument. ?? writes a
+// literal ?, for operators such as PostgreSQL's jsonb ?, ?| and ?&.
+func (w *sqlWriter) writeExpr(expr string, args []interface{}) error {
+	inString := false
+	used := 0
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case c == '\'':
+			inString = !inString
+			w.sb.WriteByte(c)
+		case c == '?' && !inString && i+1 < len(expr) && expr[i+1] == '?':
+			w.sb.WriteByte(c)
+			i++
+		case c == '?' && !inString:
+			if used >= len(args) {
+				return fmt.Errorf("expression %q has more placeholders than arguments", expr)
+			}
+			w.bind(args[used])
+			used++
+		default:
+			w.sb.WriteByte(c)
+		}
+	}
+	if used != len(args) {
+		return fmt.Errorf("expression %q has %d placeholders but %d arguments", expr, used, len(args))
+	}
+	return nil
+}
+
+// writeIdents appends a comma-separated identifier list with an optional prefix per item
+func (w *sqlWriter) writeIdents(prefix string, idents []string) error {
+	for i, ident := range idents {
+		if err := checkIdent(ident); err != nil {
+			return err
+		}
+		if i > 0 {
+			w.write(", ")
+		}
+		w.write(prefix, ident)
+	}
+	return nil
+}
+
+// identPattern matches a column or table name, optionally qualified
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// checkIdent rejects anything that is not a plain identifier
+func checkIdent(ident string) error {
+	if !identPattern.MatchString(ident) {
+		return fmt.Errorf("invalid SQL identifier %q", ident)
+	}
+	return nil
+}
+
+// sqlValue converts values the drivers cannot bind. Maps, slices and structs
+// are stored as JSON, which is how the framework tables keep such fields.
+func sqlValue(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch value.(type) {
+	case driver.Valuer, time.Time, []byte:
+		return value
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+		if (rv.Kind() == reflect.Map || rv.Kind() == reflect.Slice) && rv.IsNil() {
+			return nil
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return value
+		}
+		return string(data)
+	}
+	return value
+}
+
+// Condition helpers
+
+type comparison struct {
+	column   string
+	operator string
+	value    interface{}
+}
+
+func (c comparison) writeSQL(w *sqlWriter) error {
+	if err := checkIdent(c.column); err != nil {
+		return err
+	}
+	if c.value == nil && (c.operator == "=" || c.operator == "<>") {
+		if c.operator == "=" {
+			w.write(c.column, " IS NULL")
+		} else {
+			w.write(c.column, " IS NOT NULL")
+		}
+		return nil
+	}
+	w.write(c.column, " ", c.operator, " ")
+	w.bind(c.value)
+	return nil
+}
+
+// Eq matches rows where column equals value; a nil value matches NULL
+func Eq(column string, value interface{}) Condition { return comparison{column, "=", value} }
+
+// NotEq matches rows where column differs from value; a nil value matches NOT NULL
+func NotEq(column string, value interface{}) Condition { return comparison{column, "<>", value} }
+
+// Lt matches rows where column is less than value
+func Lt(column string, value interface{}) Condition { return comparison{column, "<", value} }
+
+// Lte matches rows where column is less than or equal to value
+func Lte(column string, value interface{}) Condition { return comparison{column, "<=", value} }
+
+// Gt matches rows where column is greater than value
+func Gt(column string, value interface{}) Condition { return comparison{column, ">", value} }
+
+// Gte matches rows where column is greater than or equal to value
+func Gte(column string, value interface{}) Condition { return comparison{column, ">=", value} }
+
+// Like matches rows where column matches the LIKE pattern
+func Like(column string, pattern string) Condition { return comparison{column, "LIKE", pattern} }
+
+// IsNull matches rows where column is NULL
+func IsNull(column string) Condition { return comparison{column, "=", nil} }
+
+// IsNotNull matches rows where column is not NULL
+func IsNotNull(column string) Condition { return comparison{column, "<>", nil} }
+
+type inCondition struct {
+	column string
+	values []interface{}
+}
+
+func (c inCondition) writeSQL(w *sqlWriter) error {
+	if err := checkIdent(c.column); err != nil {
+		return err
+	}
+	if len(c.values) == 0 {
+		// Nothing is in an empty set
+		w.write("1 = 0")
+		return nil
+	}
+	w.write(c.column, " IN (")
+	for i, value := range c.values {
+		if i > 0 {
+			w.write(", ")
+		}
+		w.bind(value)
+	}
+	w.write(")")
+	return nil
+}
+
+// In matches rows where column is one of values. A single slice argument is
+// expanded, so In("id", ids) and In("id", 1, 2, 3) both work.
+func In(column string, values ...interface{}) Condition {
+	if len(values) == 1 {
+		rv := reflect.ValueOf(values[0])
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+			expanded := make([]interface{}, rv.Len())
+			for i := range expanded {
+				expanded[i] = rv.Index(i).Interface()
+			}
+			values = expanded
+		}
+	}
+	return inCondition{column: column, values: values}
+}
+
+type junction struct {
+	operator   string
+	conditions []Condition
+}
+
+func (j junction) writeSQL(w *sqlWriter) error {
+	if len(j.conditions) == 0 {
+		if j.operator == "AND" {
+			w.write("1 = 1")
+		} else {
+			w.write("1 = 0")
+		}
+		return nil
+	}
+	w.write("(")
+	for i, cond := range j.conditions {
+		if i > 0 {
+			w.write(" ", j.operator, " ")
+		}
+		if err := cond.writeSQL(w); err != nil {
+			return err
+		}
+	}
+	w.write(")")
+	return nil
+}
+
+// And matches rows that satisfy all conditions
+func And(conditions ...Condition) Condition { return junction{"AND", conditions} }
+
+// Or matches rows that satisfy at least one condition
+func Or(conditions ...Condition) Condition { return junction{"OR", conditions} }
+
+type notCondition struct {
+	condition Condition
+}
+
+func (n notCondition) writeSQL(w *sqlWriter) error {
+	w.write("NOT (")
+	if err := n.condition.writeSQL(w); err != nil {
+		return err
+	}
+	w.write(")")
+	return nil
+}
+
+// Not negates a condition
+func Not(condition Condition) Condition { return notCondition{condition} }
+
+type exprCondition struct {
+	expr string
+	args []interface{}
+}
+
+func (e exprCondition) writeSQL(w *sqlWriter) error {
+	w.write("(")
+	if err := w.writeExpr(e.expr, e.args); err != nil {
+		return err
+	}
+	w.write(")")
+	return nil
+}
+
+// Expr is a raw SQL condition with ? placeholders, which are rewritten for the
+// dialect. The expression itself must not contain user input.
+func Expr(expr string, args ...interface{}) Condition { return exprCondition{expr, args} }
+
+// writeWhere appends a WHERE or HAVING clause for conditions
+func writeWhere(w *sqlWriter, keyword string, conditions []Condition) error {
+	if len(conditions) == 0 {
+		return nil
+	}
+	w.write(" ", keyword, " ")
+	if len(conditions) == 1 {
+		return conditions[0].writeSQL(w)
+	}
+	return And(conditions...).writeSQL(w)
+}
+
+// SELECT
+
+// SelectQuery builds a SELECT statement
+type SelectQuery struct {
+	qb       *QueryBuilder
+	columns  []string
+	table    string
+	joins    []joinClause
+	where    []Condition
+	groupBy  []string
+	having   []Condition
+	orderBy  []orderClause
+	limit    int64
+	offset   int64
+	hasLimit bool
+}
+
+type joinClause struct {
+	kind  string
+	table string
+	on    string
+	args  []interface{}
+}
+
+type orderClause struct {
+	column string
+	desc   bool
+}
+
+// Select starts a SELECT of the given column expressions, or * when none are given
+func (qb *QueryBuilder) Select(columns ...string) *SelectQuery {
+	return &SelectQuery{qb: qb, columns: columns}
+}
+
+// From sets the table to select from
+func (q *SelectQuery) From(table string) *SelectQuery {
+	q.table = table
+	return q
+}
+
+// Join adds an INNER JOIN with an ON expression using ? placeholders
+func (q *SelectQuery) Join(table, on string, args ...interface{}) *SelectQuery {
+	q.joins = append(q.joins, joinClause{"JOIN", table, on, args})
+	return q
+}
+
+// LeftJoin adds a LEFT JOIN with an ON expression using ? placeholders
+func (q *SelectQuery) LeftJoin(table, on string, args ...interface{}) *SelectQuery {
+	q.joins = append(q.joins, joinClause{"LEFT JOIN", table, on, args})
+	return q
+}
+
+// Where adds conditions that must all hold
+func (q *SelectQuery) Where(conditions ...Condition) *SelectQuery {
+	q.where = append(q.where, conditions...)
+	return q
+}
+
+// GroupBy adds GROUP BY expressions
+func (q *SelectQuery) GroupBy(expressions ...string) *SelectQuery {
+	q.groupBy = append(q.groupBy, expressions...)
+	return q
+}
+
+// Having adds conditions on grouped rows
+func (q *SelectQuery) Having(conditions ...Condition) *SelectQuery {
+	q.having = append(q.having, conditions...)
+	return q
+}
+
+// OrderBy sorts ascending by the given columns
+func (q *SelectQuery) OrderBy(columns ...string) *SelectQuery {
+	for _, column := range columns {
+		q.orderBy = append(q.orderBy, orderClause{column: column})
+	}
+	return q
+}
+
+// OrderByDesc sorts descending by the given columns
+func (q *SelectQuery) OrderByDesc(columns ...string) *SelectQuery {
+	for _, column := range columns {
+		q.orderBy = append(q.orderBy, orderClause{column: column, desc: true})
+	}
+	return q
+}
+
+// Limit returns at most n rows
+func (q *SelectQuery) Limit(n int64) *SelectQuery {
+	q.limit = n
+	q.hasLimit = true
+	return q
+}
+
+// Offset skips the first n rows
+func (q *SelectQuery) Offset(n int64) *SelectQuery {
+	q.offset = n
+	return q
+}
+
+// ToSQL renders the statement for the builder's dialect
+func (q *SelectQuery) ToSQL() (string, []interface{}, error) {
+	if q.qb.err != nil {
+		return "", nil, q.qb.err
+	}
+	if err := checkIdent(q.table); err != nil {
+		return "", nil, err
+	}
+
+	w := &sqlWriter{dialect: q.qb.dialect}
+	w.write("SELECT ")
+	if len(q.columns) == 0 {
+		w.write("*")
+	} else {
+		w.write(strings.Join(q.columns, ", "))
+	}
+	w.write(" FROM ", q.table)
+
+	for _, join := range q.joins {
+		if err := checkIdent(join.table); err != nil {
+			return "", nil, err
+		}
+		w.write(" ", join.kind, " ", join.table, " ON ")
+		cond := q.qb.tenantCondition(join.table, true)
+		if cond == nil {
+			if err := w.writeExpr(join.on, join.args); err != nil {
+				return "", nil, err
+			}
+			continue
+		}
+
+		// The tenant predicate goes into ON so a LEFT JOIN stays a left join
+		w.write("(")
+		if err := w.writeExpr(join.on, join.args); err != nil {
+			return "", nil, err
+		}
+		w.write(") AND ")
+		if err := cond.writeSQL(w); err != nil {
+			return "", nil, err
+		}
+	}
+
+	where := q.where
+	if cond := q.qb.tenantCondition(q.table, len(q.joins) > 0); cond != nil {
+		where = append(append([]Condition{}, q.where...), cond)
+	}
+	if err := writeWhere(w, "WHERE", where); err != nil {
+		return "", nil, err
+	}
+	if len(q.groupBy) > 0 {
+		w.write(" GROUP BY ", strings.Join(q.groupBy, ", "))
+	}
+	if err := writeWhere(w, "HAVING", q.having); err != nil {
+		return "", nil, err
+	}
+
+	if len(q.orderBy) > 0 {
+		w.write(" ORDER BY ")
+		for i, order := range q.orderBy {
+			if err := checkIdent(order.column); err != nil {
+				return "", nil, err
+			}
+			if i > 0 {
+				w.write(", ")
+			}
+			w.write(order.column)
+			if order.desc {
+				w.write(" DESC")
+			}
+		}
+	}
+
+	q.writePaging(w)
+	return w.sb.String(), w.args, nil
+}
+
+// writePaging appends LIMIT/OFFSET, or OFFSET/FETCH for MSSQL
+func (q *SelectQuery) writePaging(w *sqlWriter) {
+	if !q.hasLimit && q.offset == 0 {
+		return
+	}
+	limit := strconv.FormatInt(q.limit, 10)
+	offset := strconv.FormatInt(q.offset, 10)
+
+	switch q.qb.dialect {
+	case DialectMSSQL:
+		// OFFSET/FETCH requires an ORDER BY clause
+		if len(q.orderBy) == 0 {
+			w.write(" ORDER BY (SELECT NULL)")
+		}
+		w.write(" OFFSET ", offset, " ROWS")
+		if q.hasLimit {
+			w.write(" FETCH NEXT ", limit, " ROWS ONLY")
+		}
+	case DialectPostgres:
+		if q.hasLimit {
+			w.write(" LIMIT ", limit)
+		}
+		if q.offset > 0 {
+			w.write(" OFFSET ", offset)
+		}
+	default:
+		// MySQL and SQLite need a LIMIT before OFFSET
+		if !q.hasLimit {
+			if q.qb.dialect == DialectMySQL {
+				limit = "18446744073709551615"
+			} else {
+				limit = "-1"
+			}
+		}
+		w.write(" LIMIT ", limit)
+		if q.offset > 0 {
+			w.write(" OFFSET ", offset)
+		}
+	}
+}
+
+func (q *SelectQuery) builder() *QueryBuilder { return q.qb }
+
+// Query runs the statement and returns its rows
+func (q *SelectQuery) Query(ctx context.Context) (*Rows, error) { return runQuery(ctx, q) }
+
+// One scans the first row into dest, a pointer to a struct with db tags or to
+// a single value. It returns sql.ErrNoRows when there is no row.
+func (q *SelectQuery) One(ctx context.Context, dest interface{}) error { return scanOne(ctx, q, dest) }
+
+// All scans every row into dest, a pointer to a slice of structs, struct
+// pointers or single values
+func (q *SelectQuery) All(ctx context.Context, dest interface{}) error { return scanAll(ctx, q, dest) }
+
+// INSERT
+
+// InsertQuery builds an INSERT statement, optionally as an upsert
+type InsertQuery struct {
+	qb        *QueryBuilder
+	table     string
+	columns   []string
+	rows      [][]interface{}
+	err       error
+	conflict  []string
+	upsert    bool
+	doNothing bool
+	update    []string
+	returning []string
+
+	tenantColumn string // Set on the rendered copy of a tenant-scoped insert
+}
+
+// Insert starts an INSERT into table
+func (qb *QueryBuilder) Insert(table string) *InsertQuery {
+	return &InsertQuery{qb: qb, table: table}
+}
+
+// Columns sets the inserted columns
+func (q *InsertQuery) Columns(columns ...string) *InsertQuery {
+	q.columns = columns
+	return q
+}
+
+// Values adds a row of values in column order
+func (q *InsertQuery) Values(values ...interface{}) *InsertQuery {
+	q.rows = append(q.rows, values)
+	return q
+}
+
+// Record adds a row from a struct with db tags, or a map of column to value.
+// The first record sets the columns when Columns was not called.
+func (q *InsertQuery) Record(record interface{}) *InsertQuery {
+	columns, values, err := recordValues(record)
+	if err != nil {
+		q.err = err
+		return q
+	}
+	if q.columns == nil {
+		q.columns = columns
+	} else if strings.Join(q.columns, ",") != strings.Join(columns, ",") {
+		q.err = fmt.Errorf("record columns %v do not match %v", columns, q.columns)
+		return q
+	}
+	q.rows = append(q.rows, values)
+	return q
+}
+
+// OnConflict turns the insert into an upsert on the given unique key columns.
+// MySQL ignores the key columns and uses every unique index instead.
+func (q *InsertQuery) OnConflict(keys ...string) *InsertQuery {
+	q.conflict = keys
+	q.upsert = true
+	return q
+}
+
+// DoUpdate updates the given columns of a conflicting row, or every
+// non-key column when none are given
+func (q *InsertQuery) DoUpdate(columns ...string) *InsertQuery {
+	q.update = columns
+	q.doNothing = false
+	return q
+}
+
+// DoNothing keeps a conflicting row unchanged
+func (q *InsertQuery) DoNothing() *InsertQuery {
+	q.doNothing = true
+	return q
+}
+
+// Returning returns the given columns of the inserted rows. MySQL does not
+// support this; use sql.Result.LastInsertId instead.
+func (q *InsertQuery) Returning(columns ...string) *InsertQuery {
+	q.returning = columns
+	return q
+}
+
+// ToSQL renders the statement for the builder's dialect
+func (q *InsertQuery) ToSQL() (string, []interface{}, error) {
+	if q.qb.err != nil {
+		return "", nil, q.qb.err
+	}
+	if q.err != nil {
+		return "", nil, q.err
+	}
+	if err := checkIdent(q.table); err != nil {
+		return "", nil, err
+	}
+	if len(q.columns) == 0 || len(q.rows) == 0 {
+		return "", nil, fmt.Errorf("insert into %s has no values", q.table)
+	}
+	for _, row := range q.rows {
+		if len(row) != len(q.columns) {
+			return "", nil, fmt.Errorf("insert into %s has %d columns but a row with %d values", q.table, len(q.columns), len(row))
+		}
+	}
+	if len(q.returning) > 0 && q.qb.dialect == DialectMySQL {
+		return "", nil, fmt.Errorf("RETURNING is not supported by mysql")
+	}
+	if q.upsert && len(q.conflict) == 0 && q.qb.dialect != DialectMySQL {
+		return "", nil, fmt.Errorf("upsert into %s needs conflict key columns", q.table)
+	}
+
+	stmt, err := q.withTenant()
+	if err != nil {
+		return "", nil, err
+	}
+
+	w := &sqlWriter{dialect: q.qb.dialect}
+	if stmt.upsert && stmt.qb.dialect == DialectMSSQL {
+		err = stmt.writeMerge(w)
+	} else {
+		err = stmt.writeInsert(w)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return w.sb.String(), w.args, nil
+}
+
+// withTenant returns the insert to render. On a tenant-scoped builder this is
+// a copy that sets the tenant column of every row, or checks it when given.
+func (q *InsertQuery) withTenant() (*InsertQuery, error) {
+	scope := q.qb.scope
+	if scope == nil || !scope.appliesTo(q.table) {
+		return q, nil
+	}
+
+	stmt := *q
+	stmt.tenantColumn = scope.column
+	for i, column := range q.columns {
+		if column != scope.column {
+			continue
+		}
+		for _, row := range q.rows {
+			if !scope.matches(row[i]) {
+				return nil, NewTenantScopeError(scope.tenantID, q.table)
+			}
+		}
+		return &stmt, nil
+	}
+
+	stmt.columns = append(append([]string{}, q.columns...), scope.column)
+	stmt.rows = make([][]interface{}, len(q.rows))
+	for i, row := range q.rows {
+		stmt.rows[i] = append(append([]interface{}{}, row...), scope.tenantID)
+	}
+	return &stmt, nil
+}
+
+// updateColumns returns the columns an upsert updates on conflict. The tenant
+// column of a scoped insert is never updated.
+func (q *InsertQuery) updateColumns() []string {
+	if len(q.update) > 0 {
+		if q.tenantColumn == "" {
+			return q.update
+		}
+		var columns []string
+		for _, column := range q.update {
+			if column != q.tenantColumn {
+				columns = append(columns, column)
+			}
+		}
+		return columns
+	}
+	keys := map[string]bool{q.tenantColumn: true}
+	for _, key := range q.conflict {
+		keys[key] = true
+	}
+	var columns []string
+	for _, column := range q.columns {
+		if !keys[column] {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// writeValues appends the VALUES rows
+func (q *InsertQuery) writeValues(w *sqlWriter) {
+	w.write("VALUES ")
+	for i, row := range q.rows {
+		if i > 0 {
+			w.write(", ")
+		}
+		w.write("(")
+		for j, value := range row {
+			if j > 0 {
+				w.write(", ")
+			}
+			w.bind(value)
+		}
+		w.write(")")
+	}
+}
+
+// writeInsert renders INSERT with ON CONFLICT, ON DUPLICATE KEY, RETURNING or OUTPUT
+func (q *InsertQuery) writeInsert(w *sqlWriter) error {
+	w.write("INSERT INTO ", q.table, " (")
+	if err := w.writeIdents("", q.columns); err != nil {
+		return err
+	}
+	w.write(")")
+
+	if len(q.returning) > 0 && q.qb.dialect == DialectMSSQL {
+		w.write(" OUTPUT ")
+		if err := w.writeIdents("INSERTED.", q.returning); err != nil {
+			return err
+		}
+	}
+
+	w.write(" ")
+	q.writeValues(w)
+
+	if q.upsert {
+		update := q.updateColumns()
+		switch q.qb.dialect {
+		case DialectMySQL:
+			w.write(" ON DUPLICATE KEY UPDATE ")
+			if q.doNothing || len(update) == 0 {
+				// Assigning a column to itself leaves the row unchanged
+				w.write(q.columns[0], " = ", q.columns[0])
+			} else {
+				for i, column := range update {
+					if err := checkIdent(column); err != nil {
+						return err
+					}
+					if i > 0 {
+						w.write(", ")
+					}
+					if q.tenantColumn != "" {
+						// A conflicting row of another tenant is left unchanged
+						w.write(column, " = IF(", q.tenantColumn, " = VALUES(", q.tenantColumn, "), VALUES(", column, "), ", column, ")")
+					} else {
+						w.write(column, " = VALUES(", column, ")")
+					}
+				}
+			}
+		default:
+			w.write(" ON CONFLICT (")
+			if err := w.writeIdents("", q.conflict); err != nil {
+				return err
+			}
+			w.write(")")
+			if q.doNothing || len(update) == 0 {
+				w.write(" DO NOTHING")
+			} else {
+				w.write(" DO UPDATE SET ")
+				for i, column := range update {
+					if err := checkIdent(column); err != nil {
+						return err
+					}
+					if i > 0 {
+						w.write(", ")
+					}
+					w.write(column, " = excluded.", column)
+				}
+				if q.tenantColumn != "" {
+					// A conflicting row of another tenant is left unchanged
+					w.write(" WHERE ", q.table, ".", q.tenantColumn, " = excluded.", q.tenantColumn)
+				}
+			}
+		}
+	}
+
+	if len(q.returning) > 0 && q.qb.dialect != DialectMSSQL {
+		w.write(" RETURNING ")
+		return w.writeIdents("", q.returning)
+	}
+	return nil
+}
+
+// writeMerge renders an MSSQL upsert as a MERGE statement
+func (q *InsertQuery) writeMerge(w *sqlWriter) error {
+	w.write("MERGE INTO ", q.table, " WITH (HOLDLOCK) AS target USING (")
+	q.writeValues(w)
+	w.write(") AS source (")
+	if err := w.writeIdents("", q.columns); err != nil {
+		return err
+	}
+	w.write(") ON ")
+	for i, key := range q.conflict {
+		if err := checkIdent(key); err != nil {
+			return err
+		}
+		if i > 0 {
+			w.write(" AND ")
+		}
+		w.write("target.", key, " = source.", key)
+	}
+	if q.tenantColumn != "" {
+		// A row of another tenant never matches, so the insert fails on its key
+		w.write(" AND target.", q.tenantColumn, " = source.", q.tenantColumn)
+	}
+
+	if update := q.updateColumns(); !q.doNothing && len(update) > 0 {
+		w.write(" WHEN MATCHED THEN UPDATE SET ")
+		for i, column := range update {
+			if err := checkIdent(column); err != nil {
+				return err
+			}
+			if i > 0 {
+				w.write(", ")
+			}
+			w.write("target.", column, " = source.", column)
+		}
+	}
+
+	w.write(" WHEN NOT MATCHED THEN INSERT (")
+	if err := w.writeIdents("", q.columns); err != nil {
+		return err
+	}
+	w.write(") VALUES (")
+	if err := w.writeIdents("source.", q.columns); err != nil {
+		return err
+	}
+	w.write(")")
+
+	if len(q.returning) > 0 {
+		w.write(" OUTPUT ")
+		if err := w.writeIdents("INSERTED.", q.returning); err != nil {
+			return err
+		}
+	}
+	// MERGE must be terminated by a semicolon
+	w.write(";")
+	return nil
+}
+
+func (q *InsertQuery) builder() *QueryBuilder { return q.qb }
+
+// Exec runs the statement
+func (q *InsertQuery) Exec(ctx context.Context) (sql.Result, error) { return runExec(ctx, q) }
+
+// Query runs the statement and returns the rows of its Returning columns
+func (q *InsertQuery) Query(ctx context.Context) (*Rows, error) { return runQuery(ctx, q) }
+
+// One scans the first returned row into dest
+func (q *InsertQuery) One(ctx context.Context, dest interface{}) error { return scanOne(ctx, q, dest) }
+
+// All scans every returned row into dest
+func (q *InsertQuery) All(ctx context.Context, dest interface{}) error { return scanAll(ctx, q, dest) }
+
+// UPDATE
+
+// UpdateQuery builds an UPDATE statement
+type UpdateQuery struct {
+	qb        *QueryBuilder
+	table     string
+	columns   []string
+	values    []interface{}
+	where     []Condition
+	returning []string
+}
+
+// Update starts an UPDATE of table
+func (qb *QueryBuilder) Update(table string) *UpdateQuery {
+	return &UpdateQuery{qb: qb, table: table}
+}
+
+// Set assigns value to column
+func (q *UpdateQuery) Set(column string, value interface{}) *UpdateQuery {
+	q.columns = append(q.columns, column)
+	q.values = append(q.values, value)
+	return q
+}
+
+// Where adds conditions that must all hold
+func (q *UpdateQuery) Where(conditions ...Condition) *UpdateQuery {
+	q.where = append(q.where, conditions...)
+	return q
+}
+
+// Returning returns the given columns of the updated rows. MySQL does not support this.
+func (q *UpdateQuery) Returning(columns ...string) *UpdateQuery {
+	q.returning = columns
+	return q
+}
+
+// ToSQL renders the statement for the builder's dialect
+func (q *UpdateQuery) ToSQL() (string, []interface{}, error) {
+	if q.qb.err != nil {
+		return "", nil, q.qb.err
+	}
+	if err := checkIdent(q.table); err != nil {
+		return "", nil, err
+	}
+	if len(q.columns) == 0 {
+		return "", nil, fmt.Errorf("update of %s sets no columns", q.table)
+	}
+	if len(q.returning) > 0 && q.qb.dialect == DialectMySQL {
+		return "", nil, fmt.Errorf("RETURNING is not supported by mysql")
+	}
+
+	where := q.where
+	if cond := q.qb.tenantCondition(q.table, false); cond != nil {
+		where = append(append([]Condition{}, q.where...), cond)
+	}
+
+	w := &sqlWriter{dialect: q.qb.dialect}
+	w.write("UPDATE ", q.table, " SET ")
+	for i, column := range q.columns {
+		if err := checkIdent(column); err != nil {
+			return "", nil, err
+		}
+		if q.qb.scope != nil && column == q.qb.scope.column && q.qb.scope.appliesTo(q.table) {
+			return "", nil, NewTenantScopeError(q.qb.scope.tenantID, q.table)
+		}
+		if i > 0 {
+			w.write(", ")
+		}
+		w.write(column, " = ")
+		w.bind(q.values[i])
+	}
+
+	if err := writeReturning(w, "INSERTED.", q.returning, true); err != nil {
+		return "", nil, err
+	}
+	if err := writeWhere(w, "WHERE", where); err != nil {
+		return "", nil, err
+	}
+	if err := writeReturning(w, "", q.returning, false); err != nil {
+		return "", nil, err
+	}
+	return w.sb.String(), w.args, nil
+}
+
+func (q *UpdateQuery) builder() *QueryBuilder { return q.qb }
+
+// Exec runs the statement
+func (q *UpdateQuery) Exec(ctx context.Context) (sql.Result, error) { return runExec(ctx, q) }
+
+// Query runs the statement and returns the rows of its Returning columns
+func (q *UpdateQuery) Query(ctx context.Context) (*Rows, error) { return runQuery(ctx, q) }
+
+// One scans the first returned row into dest
+func (q *UpdateQuery) One(ctx context.Context, dest interface{}) error { return scanOne(ctx, q, dest) }
+
+// All scans every returned row into dest
+func (q *UpdateQuery) All(ctx context.Context, dest interface{}) error { return scanAll(ctx, q, dest) }
+
+// DELETE
+
+// DeleteQuery builds a DELETE statement
+type DeleteQuery struct {
+	qb        *QueryBuilder
+	table     string
+	where     []Condition
+	returning []string
+}
+
+// Delete starts a DELETE from table
+func (qb *QueryBuilder) Delete(table string) *DeleteQuery {
+	return &DeleteQuery{qb: qb, table: table}
+}
+
+// Where adds conditions that must all hold
+func (q *DeleteQuery) Where(conditions ...Condition) *DeleteQuery {
+	q.where = append(q.where, conditions...)
+	return q
+}
+
+// Returning returns the given columns of the deleted rows. MySQL does not support this.
+func (q *DeleteQuery) Returning(columns ...string) *DeleteQuery {
+	q.returning = columns
+	return q
+}
+
+// ToSQL renders the statement for the builder's dialect
+func (q *DeleteQuery) ToSQL() (string, []interface{}, error) {
+	if q.qb.err != nil {
+		return "", nil, q.qb.err
+	}
+	if err := checkIdent(q.table); err != nil {
+		return "", nil, err
+	}
+	if len(q.returning) > 0 && q.qb.dialect == DialectMySQL {
+		return "", nil, fmt.Errorf("RETURNING is not supported by mysql")
+	}
+
+	where := q.where
+	if cond := q.qb.tenantCondition(q.table, false); cond != nil {
+		where = append(append([]Condition{}, q.where...), cond)
+	}
+
+	w := &sqlWriter{dialect: q.qb.dialect}
+	w.write("DELETE FROM ", q.table)
+	if err := writeReturning(w, "DELETED.", q.returning, true); err != nil {
+		return "", nil, err
+	}
+	if err := writeWhere(w, "WHERE", where); err != nil {
+		return "", nil, err
+	}
+	if err := writeReturning(w, "", q.returning, false); err != nil {
+		return "", nil, err
+	}
+	return w.sb.String(), w.args, nil
+}
+
+func (q *DeleteQuery) builder() *QueryBuilder { return q.qb }
+
+// Exec runs the statement
+func (q *DeleteQuery) Exec(ctx context.Context) (sql.Result, error) { return runExec(ctx, q) }
+
+// Query runs the statement and returns the rows of its Returning columns
+func (q *DeleteQuery) Query(ctx context.Context) (*Rows, error) { return runQuery(ctx, q) }
+
+// One scans the first returned row into dest
+func (q *DeleteQuery) One(ctx context.Context, dest interface{}) error { return scanOne(ctx, q, dest) }
+
+// All scans every returned row into dest
+func (q *DeleteQuery) All(ctx context.Context, dest interface{}) error { return scanAll(ctx, q, dest) }
+
+// writeReturning appends MSSQL's OUTPUT clause (output=true, before WHERE) or
+// the RETURNING clause of the other dialects (output=false, at the end)
+func writeReturning(w *sqlWriter, outputPrefix string, columns []string, output bool) error {
+	if len(columns) == 0 || (w.dialect == DialectMSSQL) != output {
+		return nil
+	}
+	if output {
+		w.write(" OUTPUT ")
+		return w.writeIdents(outputPrefix, columns)
+	}
+	w.write(" RETURNING ")
+	return w.writeIdents("", columns)
+}
+
+// Execution
+
+// executor returns the database a statement runs on
+func executor(stmt Statement) (queryExecutor, error) {
+	qb := stmt.builder()
+	if qb.exec == nil {
+		return nil, fmt.Errorf("query builder has no database to run statements on")
+	}
+	return qb.exec, nil
+}
+
+// statementContext returns the context to run stmt with. Statements of a
+// tenant-scoped builder carry the tenant predicate, so the raw SQL check of
+// row isolation skips them.
+func statementContext(ctx context.Context, stmt Statement) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if stmt.builder().scope != nil {
+		ctx = withTenantScopedStatement(ctx)
+	}
+	return ctx
+}
+
+// runQuery renders and runs a statement that returns rows
+func runQuery(ctx context.Context, stmt Statement) (*Rows, error) {
+	query, args, err := stmt.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	exec, err := executor(stmt)
+	if err != nil {
+		return nil, err
+	}
+	return exec.QueryContext(statementContext(ctx, stmt), query, args...)
+}
+
+// runExec renders and runs a statement that returns no rows
+func runExec(ctx context.Context, stmt Statement) (sql.Result, error) {
+	query, args, err := stmt.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	exec, err := executor(stmt)
+	if err != nil {
+		return nil, err
+	}
+	return exec.ExecContext(statementContext(ctx, stmt), query, args...)
+}
+
+// scanOne runs a statement and scans its first row into dest
+func scanOne(ctx context.Context, stmt Statement, dest interface{}) error {
+	rows, err := runQuery(ctx, stmt)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := ScanRow(rows, dest); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// scanAll runs a statement and scans all rows into dest
+func scanAll(ctx context.Context, stmt Statement, dest interface{}) error {
+	rows, err := runQuery(ctx, stmt)
+	if err != nil {
+		return err
+	}
+	return ScanRows(rows, dest)
+}
+
+// QueryAll runs a statement and returns its rows as values of type T, which is
+// a struct with db tags or a single scannable value
+func QueryAll[T any](ctx context.Context, stmt Statement) ([]T, error) {
+	var result []T
+	if err := scanAll(ctx, stmt, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// QueryOne runs a statement and returns its first row as a T. It returns
+// sql.ErrNoRows when there is no row.
+func QueryOne[T any](ctx context.Context, stmt Statement) (T, error) {
+	var result T
+	err := scanOne(ctx, stmt, &result)
+	return result, err
+}
@@ -0,0 +1,226 @@
+package pkg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// renderAll renders a statement for every dialect
+func renderAll(t *testing.T, build func(qb *QueryBuilder) Statement) map[SQLDialect]string {
+	t.Helper()
+	result := make(map[SQLDialect]string)
+	for _, dialect := range []SQLDialect{DialectSQLite, DialectMySQL, DialectPostgres, DialectMSSQL} {
+		query, _, err := build(NewDialectQueryBuilder(dialect)).ToSQL()
+		if err != nil {
+			result[dialect] = "error: " + err.Error()
+			continue
+		}
+		result[dialect] = query
+	}
+	return result
+}
+
+func assertRendered(t *testing.T, got map[SQLDialect]string, want map[SQLDialect]string) {
+	t.Helper()
+	for dialect, query := range want {
+		if got[dialect] != query {
+			t.Errorf("%s:\n got: %s\nwant: %s", dialect, got[dialect], query)
+		}
+	}
+}
+
+func TestQueryBuilder_SelectPlaceholdersAndPaging(t *testing.T) {
+	got := renderAll(t, func(qb *QueryBuilder) Statement {
+		return qb.Select("id", "name").From("tenants").
+			Where(Eq("is_active", true), Or(Like("name", "a%"), In("id", []string{"t1", "t2"}))).
+			OrderByDesc("created_at").Limit(10).Offset(20)
+	})
+	assertRendered(t, got, map[SQLDialect]string{
+		DialectSQLite:   "SELECT id, name FROM tenants WHERE (is_active = ? AND (name LIKE ? OR id IN (?, ?))) ORDER BY created_at DESC LIMIT 10 OFFSET 20",
+		DialectMySQL:    "SELECT id, name FROM tenants WHERE (is_active = ? AND (name LIKE ? OR id IN (?, ?))) ORDER BY created_at DESC LIMIT 10 OFFSET 20",
+		DialectPostgres: "SELECT id, name FROM tenants WHERE (is_active = $1 AND (name LIKE $2 OR id IN ($3, $4))) ORDER BY created_at DESC LIMIT 10 OFFSET 20",
+		DialectMSSQL:    "SELECT id, name FROM tenants WHERE (is_active = @p1 AND (name LIKE @p2 OR id IN (@p3, @p4))) ORDER BY created_at DESC OFFSET 20 ROWS FETCH NEXT 10 ROWS ONLY",
+	})
+
+	// Offset without limit, and MSSQL paging without ORDER BY
+	got = renderAll(t, func(qb *QueryBuilder) Statement {
+		return qb.Select().From("sessions").Offset(5)
+	})
+	assertRendered(t, got, map[SQLDialect]string{
+		DialectSQLite:   "SELECT * FROM sessions LIMIT -1 OFFSET 5",
+		DialectMySQL:    "SELECT * FROM sessions LIMIT 18446744073709551615 OFFSET 5",
+		DialectPostgres: "SELECT * FROM sessions OFFSET 5",
+		DialectMSSQL:    "SELECT * FROM sessions ORDER BY (SELECT NULL) OFFSET 5 ROWS",
+	})
+}
+
+func TestQueryBuilder_ConditionsAndArgs(t *testing.T) {
+	qb := NewDialectQueryBuilder(DialectPostgres)
+	query, args, err := qb.Select("tenant_id", "COUNT(*)").From("workload_metrics").
+		Join("tenants", "tenants.id = workload_metrics.tenant_id AND tenants.name <> ?", "x").
+		Where(Eq("user_id", nil), NotEq("path", nil), Gte("status_code", 500), Not(In("method"))).
+		Where(Expr("duration_ms > ? AND memory_usage < ?", 100, 200)).
+		GroupBy("tenant_id").Having(Expr("COUNT(*) > ?", 3)).ToSQL()
+	if err != nil {
+		t.Fatalf("ToSQL failed: %v", err)
+	}
+
+	want := "SELECT tenant_id, COUNT(*) FROM workload_metrics JOIN tenants ON tenants.id = workload_metrics.tenant_id AND tenants.name <> $1 " +
+		"WHERE (user_id IS NULL AND path IS NOT NULL AND status_code >= $2 AND NOT (1 = 0) AND (duration_ms > $3 AND memory_usage < $4)) " +
+		"GROUP BY tenant_id HAVING (COUNT(*) > $5)"
+	if query != want {
+		t.Errorf("Unexpected query:\n got: %s\nwant: %s", query, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"x", 500, 100, 200, 3}) {
+		t.Errorf("Unexpected args: %v", args)
+	}
+
+	// Question marks inside string literals are not placeholders
+	query, _, err = qb.Select().From("tenants").Where(Expr("name = 'why?' OR id = ?", 1)).ToSQL()
+	if err != nil || !strings.HasSuffix(query, "(name = 'why?' OR id = $1)") {
+		t.Errorf("Unexpected literal handling: %s (%v)", query, err)
+	}
+	if _, _, err := qb.Select().From("tenants").Where(Expr("id = ?")).ToSQL(); err == nil {
+		t.Error("Expected error for missing placeholder argument")
+	}
+
+	// ?? is a literal question mark, such as the jsonb operators ?, ?| and ?&
+	query, args, err = qb.Select().From("tenants").
+		Where(Expr("config ?? 'plan' AND config ??| array['a'] AND config ??& array['b'] AND id = ?", 1)).ToSQL()
+	if err != nil || !strings.HasSuffix(query, "(config ? 'plan' AND config ?| array['a'] AND config ?& array['b'] AND id = $1)") || !reflect.DeepEqual(args, []interface{}{1}) {
+		t.Errorf("Unexpected escaped question marks: %s %v (%v)", query, args, err)
+	}
+}
+
+func TestQueryBuilder_RejectsInvalidIdentifiers(t *testing.T) {
+	qb := NewDialectQueryBuilder(DialectSQLite)
+	statements := []Statement{
+		qb.Select().From("tenants; DROP TABLE tenants"),
+		qb.Select().From("tenants").Where(Eq("id = 1 OR 1", 1)),
+		qb.Select().From("tenants").OrderBy("name DESC"),
+		qb.Insert("tenants").Columns("id", "name)").Values(1, 2),
+		qb.Update("tenants").Set("name = name", 1),
+		qb.Delete("tenants").Returning("*"),
+	}
+	for i, stmt := range statements {
+		if _, _, err := stmt.ToSQL(); err == nil {
+			t.Errorf("Statement %d: expected invalid identifier error", i)
+		}
+	}
+
+	if _, _, err := NewDialectQueryBuilder("oracle").Select().From("tenants").ToSQL(); err == nil {
+		t.Error("Expected error for unsupported dialect")
+	}
+}
+
+func TestQueryBuilder_UpsertAndReturning(t *testing.T) {
+	got := renderAll(t, func(qb *QueryBuilder) Statement {
+		return qb.Insert("tenants").Columns("id", "name", "is_active").Values("t1", "Acme", true).
+			OnConflict("id").DoUpdate().Returning("id", "created_at")
+	})
+	assertRendered(t, got, map[SQLDialect]string{
+		DialectSQLite:   "INSERT INTO tenants (id, name, is_active) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET name = excluded.name, is_active = excluded.is_active RETURNING id, created_at",
+		DialectPostgres: "INSERT INTO tenants (id, name, is_active) VALUES ($1, $2, $3) ON CONFLICT (id) DO UPDATE SET name = excluded.name, is_active = excluded.is_active RETURNING id, created_at",
+		DialectMySQL:    "error: RETURNING is not supported by mysql",
+		DialectMSSQL: "MERGE INTO tenants WITH (HOLDLOCK) AS target USING (VALUES (@p1, @p2, @p3)) AS source (id, name, is_active) ON target.id = source.id " +
+			"WHEN MATCHED THEN UPDATE SET target.name = source.name, target.is_active = source.is_active " +
+			"WHEN NOT MATCHED THEN INSERT (id, name, is_active) VALUES (source.id, source.name, source.is_active) OUTPUT INSERTED.id, INSERTED.created_at;",
+	})
+
+	got = renderAll(t, func(qb *QueryBuilder) Statement {
+		return qb.Insert("tenants").Columns("id", "name").Values("t1", "a").Values("t2", "b").OnConflict("id").DoNothing()
+	})
+	assertRendered(t, got, map[SQLDialect]string{
+		DialectSQLite: "INSERT INTO tenants (id, name) VALUES (?, ?), (?, ?) ON CONFLICT (id) DO NOTHING",
+		DialectMySQL:  "INSERT INTO tenants (id, name) VALUES (?, ?), (?, ?) ON DUPLICATE KEY UPDATE id = id",
+		DialectMSSQL: "MERGE INTO tenants WITH (HOLDLOCK) AS target USING (VALUES (@p1, @p2), (@p3, @p4)) AS source (id, name) ON target.id = source.id " +
+			"WHEN NOT MATCHED THEN INSERT (id, name) VALUES (source.id, source.name);",
+	})
+
+	got = renderAll(t, func(qb *QueryBuilder) Statement {
+		return qb.Insert("tenants").Columns("id", "name").Values("t1", "a").OnConflict("id").DoUpdate("name")
+	})
+	if got[DialectMySQL] != "INSERT INTO tenants (id, name) VALUES (?, ?) ON DUPLICATE KEY UPDATE name = VALUES(name)" {
+		t.Errorf("Unexpected MySQL upsert: %s", got[DialectMySQL])
+	}
+
+	got = renderAll(t, func(qb *QueryBuilder) Statement {
+		return qb.Update("sessions").Set("data", map[string]interface{}{"k": 1}).Where(Eq("id", "s1")).Returning("updated_at")
+	})
+	assertRendered(t, got, map[SQLDialect]string{
+		DialectPostgres: "UPDATE sessions SET data = $1 WHERE id = $2 RETURNING updated_at",
+		DialectMSSQL:    "UPDATE sessions SET data = @p1 OUTPUT INSERTED.updated_at WHERE id = @p2",
+	})
+
+	got = renderAll(t, func(qb *QueryBuilder) Statement {
+		return qb.Delete("sessions").Where(Lt("expires_at", 5)).Returning("id")
+	})
+	assertRendered(t, got, map[SQLDialect]string{
+		DialectSQLite: "DELETE FROM sessions WHERE expires_at < ? RETURNING id",
+		DialectMSSQL:  "DELETE FROM sessions OUTPUT DELETED.id WHERE expires_at < @p1",
+	})
+}
+
+func TestQueryBuilder_RecordValues(t *testing.T) {
+	type audit struct {
+		CreatedBy string `db:"created_by"`
+	}
+	type row struct {
+		audit
+		ID     int64             `db:"id,omitempty"`
+		Name   string            `db:"name"`
+		Labels map[string]string `db:"labels"`
+		Secret string            `db:"-"`
+		Plain  string
+	}
+
+	query, args, err := NewDialectQueryBuilder(DialectSQLite).Insert("things").
+		Record(row{audit: audit{CreatedBy: "admin"}, Name: "a", Labels: map[string]string{"k": "v"}}).ToSQL()
+	if err != nil {
+		t.Fatalf("ToSQL failed: %v", err)
+	}
+	if query != "INSERT INTO things (created_by, name, labels) VALUES (?, ?, ?)" {
+		t.Errorf("Unexpected query: %s", query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"admin", "a", `{"k":"v"}`}) {
+		t.Errorf("Expected maps to be encoded as JSON, got %v", args)
+	}
+
+	// Records with different columns cannot share an insert
+	_, _, err = NewDialectQueryBuilder(DialectSQLite).Insert("things").
+		Record(row{Name: "a"}).Record(row{ID: 2, Name: "b"}).ToSQL()
+	if err == nil {
+		t.Error("Expected error for mismatched record columns")
+	}
+}
+
+func TestQueryBuilder_TenantScope(t *testing.T) {
+	got := renderAll(t, func(qb *QueryBuilder) Statement {
+		qb.scope = newTenantScope("t1", "tenant_id", nil)
+		return qb.Insert("docs").Columns("id", "title").Values(1, "a").OnConflict("id").DoUpdate()
+	})
+	assertRendered(t, got, map[SQLDialect]string{
+		DialectPostgres: "INSERT INTO docs (id, title, tenant_id) VALUES ($1, $2, $3) ON CONFLICT (id) DO UPDATE SET title = excluded.title WHERE docs.tenant_id = excluded.tenant_id",
+		DialectMySQL:    "INSERT INTO docs (id, title, tenant_id) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE title = IF(tenant_id = VALUES(tenant_id), VALUES(title), title)",
+		DialectMSSQL: "MERGE INTO docs WITH (HOLDLOCK) AS target USING (VALUES (@p1, @p2, @p3)) AS source (id, title, tenant_id) ON target.id = source.id AND target.tenant_id = source.tenant_id " +
+			"WHEN MATCHED THEN UPDATE SET target.title = source.title WHEN NOT MATCHED THEN INSERT (id, title, tenant_id) VALUES (source.id, source.title, source.tenant_id);",
+	})
+
+	got = renderAll(t, func(qb *QueryBuilder) Statement {
+		qb.scope = newTenantScope("t1", "tenant_id", []string{"docs"})
+		return qb.Update("docs").Set("title", "x").Where(Eq("id", 1))
+	})
+	assertRendered(t, got, map[SQLDialect]string{
+		DialectSQLite: "UPDATE docs SET title = ? WHERE (id = ? AND tenant_id = ?)",
+		DialectMSSQL:  "UPDATE docs SET title = @p1 WHERE (id = @p2 AND tenant_id = @p3)",
+	})
+
+	// Tables outside TenantTables are not filtered
+	qb := NewDialectQueryBuilder(DialectSQLite)
+	qb.scope = newTenantScope("t1", "tenant_id", []string{"docs"})
+	query, args, err := qb.Select().From("countries").ToSQL()
+	if err != nil || query != "SELECT * FROM countries" || len(args) != 0 {
+		t.Errorf("Unexpected shared table query: %s %v (%v)", query, args, err)
+	}
+}
@@ -0,0 +1,412 @@
+package pkg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+// structFieldCache holds the db tag mapping of struct types, keyed by reflect.Type
+var structFieldCache sync.Map
+
+// dbField is a struct field mapped to a column
+type dbField struct {
+	index     []int
+	omitEmpty bool
+}
+
+// dbFields returns the columns of a struct type with their field index paths.
+// Only fields with a db tag are mapped; embedded structs are flattened.
+func dbFields(t reflect.Type) (map[string]dbField, []string) {
+	type cached struct {
+		fields  map[string]dbField
+		columns []string
+	}
+	if entry, ok := structFieldCache.Load(t); ok {
+		c := entry.(cached)
+		return c.fields, c.columns
+	}
+
+	c := cached{fields: make(map[string]dbField)}
+	var walk func(t reflect.Type, index []int)
+	walk = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			path := append(append([]int{}, index...), i)
+			tag, hasTag := field.Tag.Lookup("db")
+
+			if field.Anonymous && !hasTag && field.Type.Kind() == reflect.Struct {
+				walk(field.Type, path)
+				continue
+			}
+			if !hasTag || tag == "-" || !field.IsExported() {
+				continue
+			}
+
+			name, options, _ := strings.Cut(tag, ",")
+			if _, exists := c.fields[name]; exists {
+				continue
+			}
+			c.fields[name] = dbField{index: path, omitEmpty: options == "omitempty"}
+			c.columns = append(c.columns, name)
+		}
+	}
+	walk(t, nil)
+
+	structFieldCache.Store(t, c)
+	return c.fields, c.columns
+}
+
+// isRowStruct reports whether values of t are scanned column by column
+func isRowStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType && !reflect.PointerTo(t).Implements(scannerType)
+}
+
+// ScanRow scans the current row into dest, which is a pointer to a struct with
+// db tags or to a single value. Maps, slices and structs in struct fields are
+// decoded from JSON.
+func ScanRow(rows *Rows, dest interface{}) error {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("scan destination must be a non-nil pointer, got %T", dest)
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	targets, err := scanTargets(rv.Elem(), columns)
+	if err != nil {
+		return err
+	}
+	return rows.Scan(targets...)
+}
+
+// ScanRows scans all rows into dest, a pointer to a slice of structs, struct
+// pointers or single values, and closes rows
+func ScanRows(rows *Rows, dest interface{}) error {
+	defer rows.Close()
+
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("scan destination must be a pointer to a slice, got %T", dest)
+	}
+	slice := rv.Elem()
+	elemType := slice.Type().Elem()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		elem := reflect.New(elemType).Elem()
+		target := elem
+		if elemType.Kind() == reflect.Pointer {
+			elem.Set(reflect.New(elemType.Elem()))
+			target = elem.Elem()
+		}
+
+		targets, err := scanTargets(target, columns)
+		if err != nil {
+			return err
+		}
+		if err := rows.Scan(targets...); err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, elem))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// scanTargets returns the rows.Scan arguments that fill v from columns
+func scanTargets(v reflect.Value, columns []string) ([]interface{}, error) {
+	if !isRowStruct(v.Type()) {
+		if len(columns) != 1 {
+			return nil, fmt.Errorf("cannot scan %d columns into %s", len(columns), v.Type())
+		}
+		return []interface{}{&valueScanner{v}}, nil
+	}
+
+	fields, _ := dbFields(v.Type())
+	targets := make([]interface{}, len(columns))
+	for i, column := range columns {
+		field, ok := fields[column]
+		if !ok {
+			return nil, fmt.Errorf("column %q has no db tag in %s", column, v.Type())
+		}
+		targets[i] = &valueScanner{fieldByIndex(v, field.index)}
+	}
+	return targets, nil
+}
+
+// fieldByIndex returns a nested field, allocating nil embedded pointers
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, idx := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(idx)
+	}
+	return v
+}
+
+// recordValues returns the columns and values of a struct with db tags or a
+// map of column to value. Fields tagged omitempty are left out when zero.
+func recordValues(record interface{}) ([]string, []interface{}, error) {
+	rv := reflect.ValueOf(record)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, nil, fmt.Errorf("record must not be nil")
+		}
+		rv = rv.Elem()
+	}
+
+	switch {
+	case rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String:
+		columns := make([]string, 0, rv.Len())
+		for _, key := range rv.MapKeys() {
+			columns = append(columns, key.String())
+		}
+		sort.Strings(columns)
+		values := make([]interface{}, len(columns))
+		for i, column := range columns {
+			values[i] = rv.MapIndex(reflect.ValueOf(column).Convert(rv.Type().Key())).Interface()
+		}
+		return columns, values, nil
+
+	case isRowStruct(rv.Type()):
+		fields, all := dbFields(rv.Type())
+		var columns []string
+		var values []interface{}
+		for _, column := range all {
+			field := fields[column]
+			value, ok := fieldValue(rv, field.index)
+			if !ok || (field.omitEmpty && value.IsZero()) {
+				continue
+			}
+			columns = append(columns, column)
+			values = append(values, value.Interface())
+		}
+		if len(columns) == 0 {
+			return nil, nil, fmt.Errorf("record %s has no db tagged fields", rv.Type())
+		}
+		return columns, values, nil
+	}
+	return nil, nil, fmt.Errorf("record must be a struct or a map, got %T", record)
+}
+
+// fieldValue returns a nested field, reporting false when an embedded pointer is nil
+func fieldValue(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, idx := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(idx)
+	}
+	return v, true
+}
+
+// valueScanner converts a driver value into any supported Go value
+type valueScanner struct {
+	dest reflect.Value
+}
+
+// Scan implements sql.Scanner
+func (s *valueScanner) Scan(src interface{}) error {
+	return assignValue(s.dest, src)
+}
+
+// assignValue stores a driver value in dest. NULL becomes the zero value.
+func assignValue(dest reflect.Value, src interface{}) error {
+	if dest.CanAddr() && dest.Addr().Type().Implements(scannerType) {
+		return dest.Addr().Interface().(sql.Scanner).Scan(src)
+	}
+	if src == nil {
+		dest.Set(reflect.Zero(dest.Type()))
+		return nil
+	}
+	if dest.Kind() == reflect.Pointer {
+		value := reflect.New(dest.Type().Elem())
+		if err := assignValue(value.Elem(), src); err != nil {
+			return err
+		}
+		dest.Set(value)
+		return nil
+	}
+
+	if dest.Type() == timeType {
+		return assignTime(dest, src)
+	}
+
+	switch dest.Kind() {
+	case reflect.Interface:
+		if b, ok := src.([]byte); ok {
+			src = string(b)
+		}
+		dest.Set(reflect.ValueOf(src))
+		return nil
+
+	case reflect.String:
+		switch v := src.(type) {
+		case string:
+			dest.SetString(v)
+		case []byte:
+			dest.SetString(string(v))
+		case time.Time:
+			dest.SetString(v.Format(time.RFC3339Nano))
+		default:
+			dest.SetString(fmt.Sprint(v))
+		}
+		return nil
+
+	case reflect.Bool:
+		switch v := src.(type) {
+		case bool:
+			dest.SetBool(v)
+		case int64:
+			dest.SetBool(v != 0)
+		default:
+			b, err := strconv.ParseBool(asString(src))
+			if err != nil {
+				return fmt.Errorf("cannot convert %v to bool: %w", src, err)
+			}
+			dest.SetBool(b)
+		}
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch v := src.(type) {
+		case int64:
+			dest.SetInt(v)
+		case float64:
+			dest.SetInt(int64(v))
+		case bool:
+			if v {
+				dest.SetInt(1)
+			} else {
+				dest.SetInt(0)
+			}
+		default:
+			i, err := strconv.ParseInt(asString(src), 10, dest.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("cannot convert %v to %s: %w", src, dest.Type(), err)
+			}
+			dest.SetInt(i)
+		}
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		switch v := src.(type) {
+		case int64:
+			dest.SetUint(uint64(v))
+		default:
+			u, err := strconv.ParseUint(asString(src), 10, dest.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("cannot convert %v to %s: %w", src, dest.Type(), err)
+			}
+			dest.SetUint(u)
+		}
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		switch v := src.(type) {
+		case float64:
+			dest.SetFloat(v)
+		case int64:
+			dest.SetFloat(float64(v))
+		default:
+			f, err := strconv.ParseFloat(asString(src), dest.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("cannot convert %v to %s: %w", src, dest.Type(), err)
+			}
+			dest.SetFloat(f)
+		}
+		return nil
+
+	case reflect.Slice:
+		if dest.Type().Elem().Kind() == reflect.Uint8 {
+			if b, ok := src.([]byte); ok {
+				dest.SetBytes(append([]byte(nil), b...))
+				return nil
+			}
+			dest.SetBytes([]byte(asString(src)))
+			return nil
+		}
+		return assignJSON(dest, src)
+
+	case reflect.Map, reflect.Struct, reflect.Array:
+		return assignJSON(dest, src)
+	}
+	return fmt.Errorf("cannot scan %T into %s", src, dest.Type())
+}
+
+// assignJSON decodes a JSON column into dest
+func assignJSON(dest reflect.Value, src interface{}) error {
+	data := asString(src)
+	if data == "" {
+		dest.Set(reflect.Zero(dest.Type()))
+		return nil
+	}
+	value := reflect.New(dest.Type())
+	if err := json.Unmarshal([]byte(data), value.Interface()); err != nil {
+		return fmt.Errorf("cannot decode JSON into %s: %w", dest.Type(), err)
+	}
+	dest.Set(value.Elem())
+	return nil
+}
+
+// timeLayouts are the text formats drivers return timestamps in
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// assignTime stores a timestamp given as time.Time or text in dest
+func assignTime(dest reflect.Value, src interface{}) error {
+	if t, ok := src.(time.Time); ok {
+		dest.Set(reflect.ValueOf(t))
+		return nil
+	}
+	text := asString(src)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			dest.Set(reflect.ValueOf(t))
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot convert %q to time.Time", text)
+}
+
+// asString returns the text of a driver value
+func asString(src interface{}) string {
+	switch v := src.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(src)
+}
@@ -0,0 +1,130 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// connectQueryTestDB connects to a fresh SQLite database with the framework tables
+func connectQueryTestDB(t *testing.T) DatabaseManager {
+	t.Helper()
+	dm := NewDatabaseManager()
+	if err := dm.Connect(createTestDBConfig(filepath.Join(t.TempDir(), "query.db"))); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { dm.Close() })
+
+	if err := dm.CreateTables(); err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+	return dm
+}
+
+func TestIntegration_QueryBuilderScansStructs(t *testing.T) {
+	dm := connectQueryTestDB(t)
+	qb := NewQueryBuilder(dm)
+	ctx := context.Background()
+
+	if qb.Dialect() != DialectSQLite {
+		t.Fatalf("Expected sqlite dialect, got %s", qb.Dialect())
+	}
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tenant := range []Tenant{
+		{ID: "t1", Name: "Acme", Hosts: []string{"acme.test"}, Config: map[string]interface{}{"theme": "dark"}, IsActive: true, CreatedAt: created, UpdatedAt: created, MaxUsers: 5},
+		{ID: "t2", Name: "Globex", IsActive: false, CreatedAt: created, UpdatedAt: created},
+	} {
+		if _, err := qb.Insert("tenants").Record(tenant).Exec(ctx); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	var tenant Tenant
+	if err := qb.Select().From("tenants").Where(Eq("id", "t1")).One(ctx, &tenant); err != nil {
+		t.Fatalf("One failed: %v", err)
+	}
+	if !reflect.DeepEqual(tenant.Hosts, []string{"acme.test"}) || tenant.Config["theme"] != "dark" ||
+		!tenant.IsActive || tenant.MaxUsers != 5 || !tenant.CreatedAt.Equal(created) {
+		t.Errorf("Unexpected tenant: %+v", tenant)
+	}
+
+	tenants, err := QueryAll[*Tenant](ctx, qb.Select().From("tenants").OrderBy("id"))
+	if err != nil || len(tenants) != 2 || tenants[1].Name != "Globex" || tenants[1].Hosts != nil {
+		t.Fatalf("Unexpected tenants: %v (%v)", tenants, err)
+	}
+
+	names, err := QueryAll[string](ctx, qb.Select("name").From("tenants").Where(Eq("is_active", true)))
+	if err != nil || !reflect.DeepEqual(names, []string{"Acme"}) {
+		t.Errorf("Unexpected names: %v (%v)", names, err)
+	}
+
+	if _, err := QueryOne[Tenant](ctx, qb.Select().From("tenants").Where(Eq("id", "missing"))); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+
+	// Columns without a db tag are reported instead of silently dropped
+	var partial struct {
+		ID string `db:"id"`
+	}
+	if err := qb.Select("id", "name").From("tenants").Limit(1).One(ctx, &partial); err == nil {
+		t.Error("Expected error for unmapped column")
+	}
+}
+
+func TestIntegration_QueryBuilderUpsertReturning(t *testing.T) {
+	dm := connectQueryTestDB(t)
+	qb := NewQueryBuilder(dm)
+	ctx := context.Background()
+
+	upsert := func(name string) string {
+		var id string
+		err := qb.Insert("tenants").Columns("id", "name").Values("t1", name).
+			OnConflict("id").DoUpdate("name").Returning("id").One(ctx, &id)
+		if err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+		return id
+	}
+	if upsert("first") != "t1" || upsert("second") != "t1" {
+		t.Error("Expected RETURNING to report the upserted id")
+	}
+
+	name, err := QueryOne[string](ctx, qb.Select("name").From("tenants").Where(Eq("id", "t1")))
+	if err != nil || name != "second" {
+		t.Errorf("Expected upsert to update the name, got %q (%v)", name, err)
+	}
+
+	deleted, err := QueryAll[string](ctx, qb.Delete("tenants").Where(Eq("id", "t1")).Returning("name"))
+	if err != nil || !reflect.DeepEqual(deleted, []string{"second"}) {
+		t.Errorf("Unexpected deleted rows: %v (%v)", deleted, err)
+	}
+}
+
+func TestIntegration_QueryBuilderJoinsWithTx(t *testing.T) {
+	dm := connectQueryTestDB(t)
+	db := dm.(requestScopedDatabase).forRequest(context.Background())
+	ctx := context.Background()
+
+	err := db.WithTx(ctx, nil, func(tx Transaction) error {
+		if _, err := NewQueryBuilder(db).Insert("tenants").Columns("id", "name").Values("t1", "Acme").Exec(ctx); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	if err == nil {
+		t.Fatal("Expected callback error")
+	}
+
+	count, err := QueryOne[int](ctx, NewQueryBuilder(dm).Select("COUNT(*)").From("tenants"))
+	if err != nil || count != 0 {
+		t.Errorf("Expected the builder statement to be rolled back with the transaction, got %d (%v)", count, err)
+	}
+}
@@ -0,0 +1,441 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QuotaResource identifies a metered tenant resource
+type QuotaResource string
+
+const (
+	// QuotaRequests meters requests per billing period against Tenant.MaxRequests
+	QuotaRequests QuotaResource = "requests"
+
+	// QuotaStorage meters bytes stored against Tenant.MaxStorage
+	QuotaStorage QuotaResource = "storage"
+
+	// QuotaUsers meters the number of users against Tenant.MaxUsers
+	QuotaUsers QuotaResource = "users"
+)
+
+// QuotaPeriod defines the billing period for request quotas
+type QuotaPeriod string
+
+const (
+	QuotaPeriodHourly  QuotaPeriod = "hourly"
+	QuotaPeriodDaily   QuotaPeriod = "daily"
+	QuotaPeriodMonthly QuotaPeriod = "monthly"
+)
+
+// Quota event names published on the EventBus
+const (
+	// EventQuotaSoftLimit is published once per period when usage crosses the soft limit
+	EventQuotaSoftLimit = "quota.soft_limit"
+
+	// EventQuotaExceeded is published whenever a consumption is rejected by the hard limit
+	EventQuotaExceeded = "quota.exceeded"
+)
+
+// quotaLifetimePeriod is the period key for resources that are not reset per billing period
+const quotaLifetimePeriod = "lifetime"
+
+// QuotaConfig configures a QuotaManager
+type QuotaConfig struct {
+	// BillingPeriod is the period after which request usage is reset.
+	// Default: QuotaPeriodMonthly
+	BillingPeriod QuotaPeriod
+
+	// Location is the time zone billing periods are aligned to.
+	// Default: UTC
+	Location *time.Location
+
+	// SoftLimitRatio is the fraction of a limit at which a soft-limit event is published.
+	// Default: 0.8
+	SoftLimitRatio float64
+
+	// EventBus receives soft-limit and exceeded events. Optional.
+	// Default: nil (no events)
+	EventBus EventBus
+}
+
+// QuotaUsage reports the usage of a tenant resource
+type QuotaUsage struct {
+	TenantID  string        `json:"tenant_id"`
+	Resource  QuotaResource `json:"resource"`
+	Period    string        `json:"period"`
+	Used      int64         `json:"used"`
+	Limit     int64         `json:"limit"`     // 0 means unlimited
+	Remaining int64         `json:"remaining"` // -1 means unlimited
+	ResetsAt  time.Time     `json:"resets_at"` // Zero for resources without a billing period
+}
+
+// QuotaEvent is the data of quota events published on the EventBus
+type QuotaEvent struct {
+	TenantID string        `json:"tenant_id"`
+	Resource QuotaResource `json:"resource"`
+	Period   string        `json:"period"`
+	Used     int64         `json:"used"`
+	Limit    int64         `json:"limit"`
+	Amount   int64         `json:"amount"`
+}
+
+// QuotaManager meters and enforces per-tenant resource quotas
+type QuotaManager interface {
+	// Consume records amount units of resource for tenant. If the tenant's hard
+	// limit would be exceeded nothing is recorded and a QuotaExceeded error is returned.
+	Consume(tenant *Tenant, resource QuotaResource, amount int64) (*QuotaUsage, error)
+
+	// Release returns amount units of resource, e.g. after deleting a file or user
+	Release(tenant *Tenant, resource QuotaResource, amount int64) (*QuotaUsage, error)
+
+	// Usage returns the current usage of resource for tenant
+	Usage(tenant *Tenant, resource QuotaResource) (*QuotaUsage, error)
+
+	// Reset clears all recorded usage of resource for the tenant
+	Reset(tenantID string, resource QuotaResource) error
+}
+
+// quotaUsageStore persists usage counters
+type quotaUsageStore interface {
+	consume(tenantID string, resource QuotaResource, period string, amount, limit int64) (used int64, ok bool, err error)
+	release(tenantID string, resource QuotaResource, period string, amount int64) (int64, error)
+	load(tenantID string, resource QuotaResource, period string) (int64, error)
+	reset(tenantID string, resource QuotaResource) error
+}
+
+// quotaManagerImpl implements QuotaManager
+type quotaManagerImpl struct {
+	config QuotaConfig
+	store  quotaUsageStore
+	now    func() time.Time
+}
+
+// NewQuotaManager creates a new quota manager. Usage is persisted through db, so
+// all instances enforce the same quotas; without a database usage is kept in memory.
+func NewQuotaManager(db DatabaseManager, config QuotaConfig) QuotaManager {
+	config.ApplyDefaults()
+
+	var store quotaUsageStore
+	if isNoopDatabase(db) {
+		store = newInMemoryQuotaStore()
+		fmt.Println("WARN: QuotaManager using in-memory usage storage. Usage will not persist across restarts.")
+	} else {
+		store = &databaseQuotaStore{db: PrimaryDatabase(db)}
+	}
+
+	return &quotaManagerImpl{config: config, store: store, now: time.Now}
+}
+
+// Consume records amount units of resource for tenant
+func (qm *quotaManagerImpl) Consume(tenant *Tenant, resource QuotaResource, amount int64) (*QuotaUsage, error) {
+	if tenant == nil {
+		return nil, errors.New("tenant is required")
+	}
+	if amount < 0 {
+		return nil, errors.New("quota amount must not be negative")
+	}
+
+	limit := quotaLimit(tenant, resource)
+	period, resetsAt := qm.period(resource)
+
+	storeLimit := limit
+	if storeLimit <= 0 {
+		storeLimit = math.MaxInt64
+	}
+
+	used, ok, err := qm.store.consume(tenant.ID, resource, period, amount, storeLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record %s quota usage: %w", resource, err)
+	}
+
+	usage := newQuotaUsage(tenant.ID, resource, period, used, limit, resetsAt)
+	event := &QuotaEvent{TenantID: tenant.ID, Resource: resource, Period: period, Used: used, Limit: limit, Amount: amount}
+
+	if !ok {
+		qm.publish(EventQuotaExceeded, event)
+		return usage, NewQuotaExceededError(resource, used, limit)
+	}
+
+	if limit > 0 {
+		soft := int64(math.Ceil(float64(limit) * qm.config.SoftLimitRatio))
+		if used-amount < soft && used >= soft {
+			qm.publish(EventQuotaSoftLimit, event)
+		}
+	}
+
+	return usage, nil
+}
+
+// Release returns amount units of resource. Usage never drops below zero.
+func (qm *quotaManagerImpl) Release(tenant *Tenant, resource QuotaResource, amount int64) (*QuotaUsage, error) {
+	if tenant == nil {
+		return nil, errors.New("tenant is required")
+	}
+	if amount < 0 {
+		return nil, errors.New("quota amount must not be negative")
+	}
+
+	period, resetsAt := qm.period(resource)
+	used, err := qm.store.release(tenant.ID, resource, period, amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to release %s quota usage: %w", resource, err)
+	}
+
+	return newQuotaUsage(tenant.ID, resource, period, used, quotaLimit(tenant, resource), resetsAt), nil
+}
+
+// Usage returns the current usage of resource for tenant
+func (qm *quotaManagerImpl) Usage(tenant *Tenant, resource QuotaResource) (*QuotaUsage, error) {
+	if tenant == nil {
+		return nil, errors.New("tenant is required")
+	}
+
+	period, resetsAt := qm.period(resource)
+	used, err := qm.store.load(tenant.ID, resource, period)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s quota usage: %w", resource, err)
+	}
+
+	return newQuotaUsage(tenant.ID, resource, period, used, quotaLimit(tenant, resource), resetsAt), nil
+}
+
+// Reset clears all recorded usage of resource for the tenant
+func (qm *quotaManagerImpl) Reset(tenantID string, resource QuotaResource) error {
+	return qm.store.reset(tenantID, resource)
+}
+
+// period returns the period key and its end for resource at the current time
+func (qm *quotaManagerImpl) period(resource QuotaResource) (string, time.Time) {
+	if resource != QuotaRequests {
+		return quotaLifetimePeriod, time.Time{}
+	}
+
+	now := qm.now().In(qm.config.Location)
+	switch qm.config.BillingPeriod {
+	case QuotaPeriodHourly:
+		start := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
+		return start.Format("2006-01-02T15"), start.Add(time.Hour)
+	case QuotaPeriodDaily:
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		return start.Format("2006-01-02"), start.AddDate(0, 0, 1)
+	default:
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		return start.Format("2006-01"), start.AddDate(0, 1, 0)
+	}
+}
+
+// publish sends a quota event if an EventBus is configured
+func (qm *quotaManagerImpl) publish(name string, event *QuotaEvent) {
+	if qm.config.EventBus != nil {
+		_ = qm.config.EventBus.Publish(name, event)
+	}
+}
+
+// quotaLimit returns the tenant's limit for resource; zero means unlimited
+func quotaLimit(tenant *Tenant, resource QuotaResource) int64 {
+	switch resource {
+	case QuotaRequests:
+		return tenant.MaxRequests
+	case QuotaStorage:
+		return tenant.MaxStorage
+	case QuotaUsers:
+		return int64(tenant.MaxUsers)
+	}
+	return 0
+}
+
+func newQuotaUsage(tenantID string, resource QuotaResource, period string, used, limit int64, resetsAt time.Time) *QuotaUsage {
+	remaining := int64(-1)
+	if limit > 0 {
+		remaining = limit - used
+		if remaining < 0 {
+			remaining = 0
+		}
+	}
+
+	return &QuotaUsage{
+		TenantID:  tenantID,
+		Resource:  resource,
+		Period:    period,
+		Used:      used,
+		Limit:     limit,
+		Remaining: remaining,
+		ResetsAt:  resetsAt,
+	}
+}
+
+// IsQuotaExceeded reports whether err is a QuotaExceeded error
+func IsQuotaExceeded(err error) bool {
+	fwErr, ok := GetFrameworkError(err)
+	return ok && fwErr.Code == ErrCodeQuotaExceeded
+}
+
+// QuotaMiddleware creates a middleware that meters one request against the
+// tenant's request quota and blocks requests once the hard limit is reached.
+// Requests without a tenant are not metered.
+func QuotaMiddleware(quotas QuotaManager) MiddlewareFunc {
+	return func(ctx Context, next HandlerFunc) error {
+		tenant := ctx.Tenant()
+		if tenant == nil {
+			return next(ctx)
+		}
+
+		usage, err := quotas.Consume(tenant, QuotaRequests, 1)
+		if usage != nil && usage.Limit > 0 {
+			ctx.SetHeader("X-Quota-Limit", strconv.FormatInt(usage.Limit, 10))
+			ctx.SetHeader("X-Quota-Remaining", strconv.FormatInt(usage.Remaining, 10))
+			ctx.SetHeader("X-Quota-Reset", strconv.FormatInt(usage.ResetsAt.Unix(), 10))
+		}
+		if err != nil {
+			return err
+		}
+
+		return next(ctx)
+	}
+}
+
+// inMemoryQuotaStore keeps usage counters in memory
+type inMemoryQuotaStore struct {
+	mu    sync.Mutex
+	usage map[string]int64
+}
+
+func newInMemoryQuotaStore() *inMemoryQuotaStore {
+	return &inMemoryQuotaStore{usage: make(map[string]int64)}
+}
+
+func quotaStoreKey(tenantID string, resource QuotaResource, period string) string {
+	return tenantID + "|" + string(resource) + "|" + period
+}
+
+func (s *inMemoryQuotaStore) consume(tenantID string, resource QuotaResource, period string, amount, limit int64) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := quotaStoreKey(tenantID, resource, period)
+	used := s.usage[key]
+	if used+amount > limit {
+		return used, false, nil
+	}
+
+	s.usage[key] = used + amount
+	return used + amount, true, nil
+}
+
+func (s *inMemoryQuotaStore) release(tenantID string, resource QuotaResource, period string, amount int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := quotaStoreKey(tenantID, resource, period)
+	used := s.usage[key] - amount
+	if used < 0 {
+		used = 0
+	}
+	s.usage[key] = used
+	return used, nil
+}
+
+func (s *inMemoryQuotaStore) load(tenantID string, resource QuotaResource, period string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage[quotaStoreKey(tenantID, resource, period)], nil
+}
+
+func (s *inMemoryQuotaStore) reset(tenantID string, resource QuotaResource) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefix := tenantID + "|" + string(resource) + "|"
+	for key := range s.usage {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.usage, key)
+		}
+	}
+	return nil
+}
+
+// databaseQuotaStore keeps usage counters in the tenant_quota_usage table.
+// Consumption is a single conditional UPDATE, so concurrent instances cannot
+// push usage past a limit.
+type databaseQuotaStore struct {
+	db DatabaseManager
+}
+
+func (s *databaseQuotaStore) consume(tenantID string, resource QuotaResource, period string, amount, limit int64) (int64, bool, error) {
+	if err := s.ensure(tenantID, resource, period); err != nil {
+		return 0, false, err
+	}
+
+	query, err := s.db.GetQuery("consume_tenant_quota")
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to load consume_tenant_quota query: %w", err)
+	}
+
+	result, err := s.db.Exec(query, amount, time.Now(), tenantID, string(resource), period, amount, limit)
+	if err != nil {
+		return 0, false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, false, err
+	}
+
+	used, err := s.load(tenantID, resource, period)
+	return used, affected == 1, err
+}
+
+func (s *databaseQuotaStore) release(tenantID string, resource QuotaResource, period string, amount int64) (int64, error) {
+	query, err := s.db.GetQuery("release_tenant_quota")
+	if err != nil {
+		return 0, fmt.Errorf("failed to load release_tenant_quota query: %w", err)
+	}
+
+	if _, err := s.db.Exec(query, amount, amount, time.Now(), tenantID, string(resource), period); err != nil {
+		return 0, err
+	}
+
+	return s.load(tenantID, resource, period)
+}
+
+func (s *databaseQuotaStore) load(tenantID string, resource QuotaResource, period string) (int64, error) {
+	query, err := s.db.GetQuery("load_tenant_quota_usage")
+	if err != nil {
+		return 0, fmt.Errorf("failed to load load_tenant_quota_usage query: %w", err)
+	}
+
+	var used int64
+	err = s.db.QueryRow(query, tenantID, string(resource), period).Scan(&used)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return used, err
+}
+
+func (s *databaseQuotaStore) reset(tenantID string, resource QuotaResource) error {
+	query, err := s.db.GetQuery("reset_tenant_quota_usage")
+	if err != nil {
+		return fmt.Errorf("failed to load reset_tenant_quota_usage query: %w", err)
+	}
+
+	_, err = s.db.Exec(query, tenantID, string(resource))
+	return err
+}
+
+// ensure creates the usage row for a period if it does not exist yet
+func (s *databaseQuotaStore) ensure(tenantID string, resource QuotaResource, period string) error {
+	query, err := s.db.GetQuery("ensure_tenant_quota_usage")
+	if err != nil {
+		return fmt.Errorf("failed to load ensure_tenant_quota_usage query: %w", err)
+	}
+
+	_, err = s.db.Exec(query, tenantID, string(resource), period, time.Now())
+	return err
+}
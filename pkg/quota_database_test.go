@@ -0,0 +1,57 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIntegration_DatabaseQuotaStore(t *testing.T) {
+	dm := NewDatabaseManager()
+	if err := dm.Connect(createTestDBConfig(filepath.Join(t.TempDir(), "quota.db"))); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer dm.Close()
+
+	if err := dm.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	tenant := &Tenant{ID: "tenant-1", MaxRequests: 3, MaxStorage: 100}
+
+	// Two managers simulate two instances sharing the database
+	first := NewQuotaManager(dm, QuotaConfig{})
+	second := NewQuotaManager(dm, QuotaConfig{})
+
+	for i := 0; i < 2; i++ {
+		if _, err := first.Consume(tenant, QuotaRequests, 1); err != nil {
+			t.Fatalf("Consume failed: %v", err)
+		}
+	}
+	if _, err := second.Consume(tenant, QuotaRequests, 1); err != nil {
+		t.Fatalf("Consume failed: %v", err)
+	}
+	usage, err := second.Consume(tenant, QuotaRequests, 1)
+	if !IsQuotaExceeded(err) {
+		t.Fatalf("Expected QuotaExceeded error, got %v", err)
+	}
+	if usage.Used != 3 {
+		t.Errorf("Expected 3 requests used, got %d", usage.Used)
+	}
+
+	if _, err := first.Consume(tenant, QuotaStorage, 60); err != nil {
+		t.Fatalf("Consume storage failed: %v", err)
+	}
+	if usage, _ := second.Release(tenant, QuotaStorage, 100); usage.Used != 0 {
+		t.Errorf("Expected storage usage to stop at zero, got %d", usage.Used)
+	}
+
+	if err := first.Reset(tenant.ID, QuotaRequests); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if usage, _ := second.Usage(tenant, QuotaRequests); usage.Used != 0 {
+		t.Errorf("Expected request usage to be reset, got %d", usage.Used)
+	}
+}
@@ -0,0 +1,164 @@
+package pkg
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// quotaFileManager meters bytes written through a FileManager against the
+// tenant's storage quota
+type quotaFileManager struct {
+	files  FileManager
+	quotas QuotaManager
+	tenant *Tenant
+}
+
+// NewQuotaFileManager wraps files so that writes, uploads and deletes are metered
+// against tenant's storage quota. Writes that would exceed Tenant.MaxStorage fail
+// with a QuotaExceeded error before anything is written.
+func NewQuotaFileManager(files FileManager, quotas QuotaManager, tenant *Tenant) FileManager {
+	return &quotaFileManager{files: files, quotas: quotas, tenant: tenant}
+}
+
+// Read reads data from a file
+func (q *quotaFileManager) Read(path string) ([]byte, error) {
+	return q.files.Read(path)
+}
+
+// Write writes data to a file, metering the change in size
+func (q *quotaFileManager) Write(path string, data []byte) error {
+	return MeterStorageWrite(q.quotas, q.tenant, "file:"+path, q.sizer(path), int64(len(data)), func() error {
+		return q.files.Write(path, data)
+	})
+}
+
+// Delete deletes a file and releases its size
+func (q *quotaFileManager) Delete(path string) error {
+	return MeterStorageDelete(q.quotas, q.tenant, "file:"+path, q.sizer(path), func() error {
+		return q.files.Delete(path)
+	})
+}
+
+// Exists checks if a file exists
+func (q *quotaFileManager) Exists(path string) bool {
+	return q.files.Exists(path)
+}
+
+// CreateDir creates a directory
+func (q *quotaFileManager) CreateDir(path string) error {
+	return q.files.CreateDir(path)
+}
+
+// SaveUploadedFile saves an uploaded file, metering its size
+func (q *quotaFileManager) SaveUploadedFile(ctx Context, filename string, destPath string) error {
+	file, err := ctx.FormFile(filename)
+	if err != nil {
+		return err
+	}
+
+	return MeterStorageWrite(q.quotas, q.tenant, "file:"+destPath, q.sizer(destPath), int64(len(file.Content)), func() error {
+		return q.files.SaveUploadedFile(ctx, filename, destPath)
+	})
+}
+
+// fileSizer is implemented by file managers that can stat a file
+type fileSizer interface {
+	Size(path string) (int64, error)
+}
+
+// sizer returns a function reporting the current size of path, or zero if it
+// does not exist. File managers that cannot stat fall back to reading the file.
+func (q *quotaFileManager) sizer(path string) func() int64 {
+	return func() int64 {
+		if !q.files.Exists(path) {
+			return 0
+		}
+		if sizer, ok := q.files.(fileSizer); ok {
+			size, err := sizer.Size(path)
+			if err != nil {
+				return 0
+			}
+			return size
+		}
+		data, err := q.files.Read(path)
+		if err != nil {
+			return 0
+		}
+		return int64(len(data))
+	}
+}
+
+// quotaStorageLocks serialize metered writes to the same tenant object, so
+// the size check, the quota change and the write happen as one step
+var quotaStorageLocks [64]sync.Mutex
+
+// quotaStorageLock returns the lock guarding key of tenantID
+func quotaStorageLock(tenantID, key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(tenantID))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return &quotaStorageLocks[h.Sum32()%uint32(len(quotaStorageLocks))]
+}
+
+// MeterStorageWrite replaces the object key of tenant with newSize bytes by
+// calling write, metering the change in size against the tenant's storage quota.
+// Growth is consumed before write and returned if write fails; shrinkage is
+// released afterwards. size reports the object's current size. Writes to the
+// same key are serialized within the process. Without quotas or a tenant, write
+// is called unmetered.
+func MeterStorageWrite(quotas QuotaManager, tenant *Tenant, key string, size func() int64, newSize int64, write func() error) error {
+	if quotas == nil || tenant == nil {
+		return write()
+	}
+
+	lock := quotaStorageLock(tenant.ID, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	delta := newSize - size()
+
+	if delta > 0 {
+		if _, err := quotas.Consume(tenant, QuotaStorage, delta); err != nil {
+			return err
+		}
+	}
+
+	if err := write(); err != nil {
+		if delta > 0 {
+			_, _ = quotas.Release(tenant, QuotaStorage, delta)
+		}
+		return err
+	}
+
+	if delta < 0 {
+		if _, err := quotas.Release(tenant, QuotaStorage, -delta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MeterStorageDelete removes the object key of tenant by calling remove and
+// releases its size, as reported by size, from the tenant's storage quota
+func MeterStorageDelete(quotas QuotaManager, tenant *Tenant, key string, size func() int64, remove func() error) error {
+	if quotas == nil || tenant == nil {
+		return remove()
+	}
+
+	lock := quotaStorageLock(tenant.ID, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	released := size()
+	if err := remove(); err != nil {
+		return err
+	}
+
+	if released > 0 {
+		if _, err := quotas.Release(tenant, QuotaStorage, released); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package pkg
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQuotaFileManager(t *testing.T) {
+	qm := NewQuotaManager(nil, QuotaConfig{})
+	tenant := &Tenant{ID: "t1", MaxStorage: 10}
+	files := NewQuotaFileManager(NewFileManager(NewMemoryFileSystem()), qm, tenant)
+
+	if err := files.Write("/a.txt", []byte("123456")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := files.Write("/b.txt", []byte("12345")); !IsQuotaExceeded(err) {
+		t.Fatalf("Expected storage quota to be exceeded, got %v", err)
+	}
+	if files.Exists("/b.txt") {
+		t.Error("Rejected write must not create the file")
+	}
+
+	// Overwriting with smaller content releases the difference
+	if err := files.Write("/a.txt", []byte("12")); err != nil {
+		t.Fatalf("Overwrite failed: %v", err)
+	}
+	if usage, _ := qm.Usage(tenant, QuotaStorage); usage.Used != 2 {
+		t.Errorf("Expected 2 bytes used, got %d", usage.Used)
+	}
+
+	if err := files.Delete("/a.txt"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if usage, _ := qm.Usage(tenant, QuotaStorage); usage.Used != 0 {
+		t.Errorf("Expected 0 bytes used after delete, got %d", usage.Used)
+	}
+}
+
+// readCountingFiles counts whole-file reads of the wrapped file manager
+type readCountingFiles struct {
+	FileManager
+	sizer fileSizer
+	reads int
+}
+
+func (f *readCountingFiles) Read(path string) ([]byte, error) {
+	f.reads++
+	return f.FileManager.Read(path)
+}
+
+func (f *readCountingFiles) Size(path string) (int64, error) {
+	return f.sizer.Size(path)
+}
+
+func TestQuotaFileManager_StatsInsteadOfReading(t *testing.T) {
+	inner := NewFileManager(NewMemoryFileSystem())
+	counting := &readCountingFiles{FileManager: inner, sizer: inner.(fileSizer)}
+	qm := NewQuotaManager(nil, QuotaConfig{})
+	tenant := &Tenant{ID: "t1", MaxStorage: 100}
+	files := NewQuotaFileManager(counting, qm, tenant)
+
+	if err := files.Write("/a.txt", []byte("123456")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := files.Write("/a.txt", []byte("1234")); err != nil {
+		t.Fatalf("Overwrite failed: %v", err)
+	}
+	if err := files.Delete("/a.txt"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	if counting.reads != 0 {
+		t.Errorf("Expected sizes to be read with a stat, got %d file reads", counting.reads)
+	}
+	if usage, _ := qm.Usage(tenant, QuotaStorage); usage.Used != 0 {
+		t.Errorf("Expected 0 bytes used after delete, got %d", usage.Used)
+	}
+}
+
+// slowWriteFiles delays writes, so concurrent writers overlap
+type slowWriteFiles struct {
+	FileManager
+}
+
+func (f *slowWriteFiles) Write(path string, data []byte) error {
+	time.Sleep(time.Millisecond)
+	return f.FileManager.Write(path, data)
+}
+
+func TestQuotaFileManager_ConcurrentWrites(t *testing.T) {
+	qm := NewQuotaManager(nil, QuotaConfig{})
+	tenant := &Tenant{ID: "t1", MaxStorage: 100}
+	fs := &slowWriteFiles{NewFileManager(NewMemoryFileSystem())}
+
+	// Each writer has its own wrapper, as each request does
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			files := NewQuotaFileManager(fs, qm, tenant)
+			if err := files.Write("/shared.txt", []byte("12345")); err != nil {
+				t.Errorf("Write failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if usage, _ := qm.Usage(tenant, QuotaStorage); usage.Used != 5 {
+		t.Errorf("Expected 5 bytes used for one file, got %d", usage.Used)
+	}
+}
+
+func TestContextFiles_MeteredForTenant(t *testing.T) {
+	qm := NewQuotaManager(nil, QuotaConfig{})
+	fs := NewFileManager(NewMemoryFileSystem())
+	ctx := &contextImpl{files: fs, quotas: qm}
+
+	// Without a tenant nothing is metered
+	if err := ctx.Files().Write("/public.txt", []byte("1234567890")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	tenant := &Tenant{ID: "t1", MaxStorage: 8}
+	ctx.SetTenant(tenant)
+	if err := ctx.Files().Write("/a.txt", []byte("123456")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := ctx.Files().Write("/b.txt", []byte("123")); !IsQuotaExceeded(err) {
+		t.Fatalf("Expected storage quota to be exceeded, got %v", err)
+	}
+	if usage, _ := qm.Usage(tenant, QuotaStorage); usage.Used != 6 {
+		t.Errorf("Expected 6 bytes used, got %d", usage.Used)
+	}
+}
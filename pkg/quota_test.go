@@ -0,0 +1,134 @@
+package pkg
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingEventBus records published events synchronously
+type recordingEventBus struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (b *recordingEventBus) Publish(event string, data interface{}) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, Event{Name: event, Data: data})
+	return nil
+}
+
+func (b *recordingEventBus) Subscribe(pluginName, event string, handler EventHandler) error {
+	return nil
+}
+
+func (b *recordingEventBus) UnregisterAll(pluginName string) error      { return nil }
+func (b *recordingEventBus) Unsubscribe(pluginName, event string) error { return nil }
+func (b *recordingEventBus) ListSubscriptions(event string) []string    { return nil }
+
+func (b *recordingEventBus) names() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	names := make([]string, len(b.events))
+	for i, e := range b.events {
+		names[i] = e.Name
+	}
+	return names
+}
+
+func TestQuotaManager_RequestsPerBillingPeriod(t *testing.T) {
+	bus := &recordingEventBus{}
+	qm := NewQuotaManager(nil, QuotaConfig{EventBus: bus}).(*quotaManagerImpl)
+
+	now := time.Date(2026, 3, 31, 23, 59, 0, 0, time.UTC)
+	qm.now = func() time.Time { return now }
+
+	tenant := &Tenant{ID: "t1", MaxRequests: 5}
+	for i := 0; i < 5; i++ {
+		if _, err := qm.Consume(tenant, QuotaRequests, 1); err != nil {
+			t.Fatalf("Request %d should be within quota: %v", i, err)
+		}
+	}
+
+	usage, err := qm.Consume(tenant, QuotaRequests, 1)
+	if !IsQuotaExceeded(err) {
+		t.Fatalf("Expected QuotaExceeded error, got %v", err)
+	}
+	if usage.Used != 5 || usage.Remaining != 0 || usage.Period != "2026-03" {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+	if !usage.ResetsAt.Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected reset time: %v", usage.ResetsAt)
+	}
+
+	names := bus.names()
+	if len(names) != 2 || names[0] != EventQuotaSoftLimit || names[1] != EventQuotaExceeded {
+		t.Errorf("Expected soft limit then exceeded events, got %v", names)
+	}
+
+	// A new billing period starts from zero
+	now = now.Add(2 * time.Minute)
+	usage, err = qm.Consume(tenant, QuotaRequests, 1)
+	if err != nil || usage.Used != 1 || usage.Period != "2026-04" {
+		t.Errorf("Expected fresh period, got %+v, %v", usage, err)
+	}
+}
+
+func TestQuotaManager_UnlimitedAndRelease(t *testing.T) {
+	qm := NewQuotaManager(nil, QuotaConfig{})
+
+	unlimited := &Tenant{ID: "t1"}
+	usage, err := qm.Consume(unlimited, QuotaUsers, 1000)
+	if err != nil || usage.Remaining != -1 {
+		t.Fatalf("Expected unlimited quota, got %+v, %v", usage, err)
+	}
+
+	tenant := &Tenant{ID: "t2", MaxUsers: 2}
+	qm.Consume(tenant, QuotaUsers, 2)
+	if _, err := qm.Consume(tenant, QuotaUsers, 1); !IsQuotaExceeded(err) {
+		t.Fatalf("Expected user quota to be exceeded, got %v", err)
+	}
+
+	usage, _ = qm.Release(tenant, QuotaUsers, 5)
+	if usage.Used != 0 {
+		t.Errorf("Expected usage to stop at zero, got %d", usage.Used)
+	}
+
+	storageErr := NewQuotaExceededError(QuotaStorage, 10, 10)
+	if storageErr.StatusCode != http.StatusInsufficientStorage {
+		t.Errorf("Expected 507 for storage quota, got %d", storageErr.StatusCode)
+	}
+}
+
+func TestQuotaMiddleware(t *testing.T) {
+	qm := NewQuotaManager(nil, QuotaConfig{})
+	mw := QuotaMiddleware(qm)
+	tenant := &Tenant{ID: "t1", MaxRequests: 1}
+
+	newCtx := func() Context {
+		ctx := createTestContext(t, &Request{Header: http.Header{}})
+		ctx.(*contextImpl).SetTenant(tenant)
+		return ctx
+	}
+	handler := func(ctx Context) error { return nil }
+
+	ctx := newCtx()
+	if err := mw(ctx, handler); err != nil {
+		t.Fatalf("First request should pass: %v", err)
+	}
+	if ctx.Response().(*testResponseWriter).headers.Get("X-Quota-Remaining") != "0" {
+		t.Error("Expected X-Quota-Remaining header")
+	}
+
+	err := mw(newCtx(), handler)
+	if fwErr, ok := GetFrameworkError(err); !ok || fwErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 quota error, got %v", err)
+	}
+
+	// Requests without a tenant are not metered
+	if err := mw(createTestContext(t, &Request{Header: http.Header{}}), handler); err != nil {
+		t.Errorf("Expected request without tenant to pass: %v", err)
+	}
+}
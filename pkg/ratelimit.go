@@ -0,0 +1,504 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimitAlgorithm selects the algorithm used by a RateLimiter
+type RateLimitAlgorithm string
+
+const (
+	// RateLimitTokenBucket refills Limit tokens per Window up to Burst tokens
+	RateLimitTokenBucket RateLimitAlgorithm = "token_bucket"
+
+	// RateLimitSlidingWindowLog records every request and allows at most Limit
+	// requests in any Window. Exact, but stores one timestamp per request.
+	RateLimitSlidingWindowLog RateLimitAlgorithm = "sliding_window_log"
+
+	// RateLimitSlidingWindowCounter approximates a sliding window by weighting the
+	// previous fixed window's count. Constant memory per key.
+	RateLimitSlidingWindowCounter RateLimitAlgorithm = "sliding_window_counter"
+
+	// RateLimitGCRA is the generic cell rate algorithm: requests are spaced
+	// Window/Limit apart with a tolerance of Burst requests. Constant memory per key.
+	RateLimitGCRA RateLimitAlgorithm = "gcra"
+)
+
+// RateLimitKeyFunc extracts the key a request is rate limited by. An empty key
+// skips rate limiting for the request.
+type RateLimitKeyFunc func(ctx Context) string
+
+// RateLimitResult describes the outcome of a rate limit check
+type RateLimitResult struct {
+	Allowed    bool          // Whether the request is allowed
+	Limit      int           // Maximum number of requests that can be made at once
+	Window     time.Duration // Period the limit applies to
+	Remaining  int           // Requests remaining before the limit is reached
+	ResetAfter time.Duration // Time until the limit is fully replenished
+	RetryAfter time.Duration // Time until the request would be allowed (zero if allowed)
+}
+
+// RateLimitState is the per-key state persisted by a RateLimitStore. Its fields
+// are interpreted by the algorithm that owns the key.
+type RateLimitState struct {
+	Value     float64 `json:"v,omitempty"` // Tokens (token bucket) or current window count
+	Previous  float64 `json:"p,omitempty"` // Previous window count (sliding window counter)
+	Timestamp int64   `json:"t,omitempty"` // Unix nanoseconds: last refill, window start or TAT
+	Log       []int64 `json:"l,omitempty"` // Request timestamps (sliding window log)
+}
+
+// RateLimitStore persists rate limit state. Update must apply fn atomically with
+// respect to other updates of the same key, across every instance sharing the store.
+type RateLimitStore interface {
+	// Update loads the state for key, passes it to fn and stores the result with
+	// the given time-to-live. fn may be invoked more than once on contention.
+	Update(key string, ttl time.Duration, fn func(state *RateLimitState) error) error
+
+	// Delete removes the state for key
+	Delete(key string) error
+}
+
+// RateLimiter limits the rate of requests per key
+type RateLimiter interface {
+	// Allow consumes one request for the key extracted from ctx
+	Allow(ctx Context) (*RateLimitResult, error)
+
+	// AllowN consumes n requests for key
+	AllowN(key string, n int) (*RateLimitResult, error)
+
+	// Reset clears the state for key
+	Reset(key string) error
+}
+
+// RateLimiterConfig configures a RateLimiter
+type RateLimiterConfig struct {
+	// Algorithm selects the rate limiting algorithm.
+	// Default: RateLimitTokenBucket
+	Algorithm RateLimitAlgorithm
+
+	// Limit is the number of requests allowed per Window.
+	// Required
+	Limit int
+
+	// Window is the period Limit applies to.
+	// Default: 1 minute
+	Window time.Duration
+
+	// Burst is the number of requests that may be made at once. Used by the token
+	// bucket and GCRA algorithms; the window algorithms always allow Limit requests.
+	// Default: Limit
+	Burst int
+
+	// KeyFunc extracts the rate limit key from a request.
+	// Default: RateLimitKeyByIP
+	KeyFunc RateLimitKeyFunc
+
+	// Store persists rate limit state.
+	// Default: in-memory store
+	Store RateLimitStore
+
+	// Prefix namespaces keys in the store.
+	// Default: "ratelimit"
+	Prefix string
+}
+
+// rateLimiterImpl implements RateLimiter on top of a RateLimitStore
+type rateLimiterImpl struct {
+	config RateLimiterConfig
+	now    func() time.Time
+}
+
+// NewRateLimiter creates a new rate limiter
+func NewRateLimiter(config RateLimiterConfig) (RateLimiter, error) {
+	config.ApplyDefaults()
+
+	if config.Limit <= 0 {
+		return nil, errors.New("rate limit must be greater than zero")
+	}
+
+	switch config.Algorithm {
+	case RateLimitTokenBucket, RateLimitSlidingWindowLog, RateLimitSlidingWindowCounter, RateLimitGCRA:
+	default:
+		return nil, fmt.Errorf("unsupported rate limit algorithm: %s", config.Algorithm)
+	}
+
+	return &rateLimiterImpl{config: config, now: time.Now}, nil
+}
+
+// NewHostRateLimiter creates a rate limiter from a host's RateLimitConfig.
+// RequestsPerSecond and BurstSize map to Limit and Burst over a one second window.
+// Storage "database" persists state through db so that limits are shared by all
+// instances; any other value keeps state in memory.
+func NewHostRateLimiter(config *RateLimitConfig, db DatabaseManager) (RateLimiter, error) {
+	if config == nil || !config.Enabled {
+		return nil, errors.New("rate limiting is not enabled")
+	}
+
+	var store RateLimitStore
+	if config.Storage == "database" {
+		if isNoopDatabase(db) {
+			return nil, errors.New("database rate limit storage requires a database")
+		}
+		store = NewDatabaseRateLimitStore(db)
+	}
+
+	return NewRateLimiter(RateLimiterConfig{
+		Algorithm: config.Algorithm,
+		Limit:     config.RequestsPerSecond,
+		Window:    time.Second,
+		Burst:     config.BurstSize,
+		KeyFunc:   config.KeyFunc,
+		Store:     store,
+	})
+}
+
+// Allow consumes one request for the key extracted from ctx. Requests without a
+// key are always allowed.
+func (rl *rateLimiterImpl) Allow(ctx Context) (*RateLimitResult, error) {
+	key := rl.config.KeyFunc(ctx)
+	if key == "" {
+		return &RateLimitResult{Allowed: true, Limit: rl.capacity(), Remaining: rl.capacity()}, nil
+	}
+	return rl.AllowN(key, 1)
+}
+
+// AllowN consumes n requests for key
+func (rl *rateLimiterImpl) AllowN(key string, n int) (*RateLimitResult, error) {
+	if key == "" {
+		return nil, errors.New("rate limit key is required")
+	}
+	if n <= 0 {
+		return nil, errors.New("rate limit cost must be greater than zero")
+	}
+
+	var result RateLimitResult
+	err := rl.config.Store.Update(rl.storeKey(key), rl.ttl(), func(state *RateLimitState) error {
+		now := rl.now()
+		switch rl.config.Algorithm {
+		case RateLimitSlidingWindowLog:
+			result = rl.slidingWindowLog(state, now, n)
+		case RateLimitSlidingWindowCounter:
+			result = rl.slidingWindowCounter(state, now, n)
+		case RateLimitGCRA:
+			result = rl.gcra(state, now, n)
+		default:
+			result = rl.tokenBucket(state, now, n)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update rate limit: %w", err)
+	}
+
+	result.Window = rl.config.Window
+	return &result, nil
+}
+
+// Reset clears the state for key
+func (rl *rateLimiterImpl) Reset(key string) error {
+	return rl.config.Store.Delete(rl.storeKey(key))
+}
+
+func (rl *rateLimiterImpl) storeKey(key string) string {
+	return rl.config.Prefix + ":" + key
+}
+
+// capacity returns the number of requests that can be made at once
+func (rl *rateLimiterImpl) capacity() int {
+	switch rl.config.Algorithm {
+	case RateLimitTokenBucket, RateLimitGCRA:
+		return rl.config.Burst
+	}
+	return rl.config.Limit
+}
+
+// interval returns the time it takes to replenish one request
+func (rl *rateLimiterImpl) interval() time.Duration {
+	return rl.config.Window / time.Duration(rl.config.Limit)
+}
+
+// ttl returns how long idle state must be kept before it no longer matters
+func (rl *rateLimiterImpl) ttl() time.Duration {
+	switch rl.config.Algorithm {
+	case RateLimitTokenBucket, RateLimitGCRA:
+		return rl.interval()*time.Duration(rl.config.Burst) + time.Second
+	case RateLimitSlidingWindowCounter:
+		return 2*rl.config.Window + time.Second
+	}
+	return rl.config.Window + time.Second
+}
+
+// tokenBucket refills Limit/Window tokens per second up to Burst
+func (rl *rateLimiterImpl) tokenBucket(state *RateLimitState, now time.Time, n int) RateLimitResult {
+	capacity := float64(rl.config.Burst)
+	perToken := float64(rl.interval())
+
+	tokens := capacity
+	if state.Timestamp != 0 {
+		elapsed := float64(now.UnixNano() - state.Timestamp)
+		tokens = math.Min(capacity, state.Value+math.Max(0, elapsed)/perToken)
+	}
+
+	result := RateLimitResult{Limit: rl.config.Burst}
+	if tokens >= float64(n) {
+		tokens -= float64(n)
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((float64(n) - tokens) * perToken)
+	}
+
+	state.Value = tokens
+	state.Timestamp = now.UnixNano()
+
+	result.Remaining = int(math.Floor(tokens))
+	result.ResetAfter = time.Duration((capacity - tokens) * perToken)
+	return result
+}
+
+// slidingWindowLog allows at most Limit requests in any Window
+func (rl *rateLimiterImpl) slidingWindowLog(state *RateLimitState, now time.Time, n int) RateLimitResult {
+	window := rl.config.Window.Nanoseconds()
+	cutoff := now.UnixNano() - window
+
+	log := state.Log[:0]
+	for _, ts := range state.Log {
+		if ts > cutoff {
+			log = append(log, ts)
+		}
+	}
+
+	result := RateLimitResult{Limit: rl.config.Limit}
+	if len(log)+n <= rl.config.Limit {
+		for i := 0; i < n; i++ {
+			log = append(log, now.UnixNano())
+		}
+		result.Allowed = true
+	} else if n <= rl.config.Limit {
+		// Wait until enough of the oldest requests leave the window
+		oldest := log[len(log)+n-rl.config.Limit-1]
+		result.RetryAfter = time.Duration(oldest + window - now.UnixNano())
+	} else {
+		result.RetryAfter = rl.config.Window
+	}
+
+	state.Log = log
+	result.Remaining = rl.config.Limit - len(log)
+	if len(log) > 0 {
+		result.ResetAfter = time.Duration(log[len(log)-1] + window - now.UnixNano())
+	}
+	return result
+}
+
+// slidingWindowCounter weights the previous fixed window by its overlap with the
+// sliding window ending now
+func (rl *rateLimiterImpl) slidingWindowCounter(state *RateLimitState, now time.Time, n int) RateLimitResult {
+	window := rl.config.Window.Nanoseconds()
+	windowStart := now.UnixNano() - now.UnixNano()%window
+
+	if state.Timestamp != windowStart {
+		if state.Timestamp == windowStart-window {
+			state.Previous = state.Value
+		} else {
+			state.Previous = 0
+		}
+		state.Value = 0
+		state.Timestamp = windowStart
+	}
+
+	elapsed := float64(now.UnixNano()-windowStart) / float64(window)
+	limit := float64(rl.config.Limit)
+	estimated := state.Previous*(1-elapsed) + state.Value
+
+	result := RateLimitResult{Limit: rl.config.Limit}
+	if estimated+float64(n) <= limit {
+		state.Value += float64(n)
+		estimated += float64(n)
+		result.Allowed = true
+	} else if state.Previous > 0 && state.Value+float64(n) <= limit {
+		// Wait until the previous window's weight has decayed enough
+		overlap := (limit - state.Value - float64(n)) / state.Previous
+		result.RetryAfter = time.Duration((1-overlap)*float64(window)) - time.Duration(now.UnixNano()-windowStart)
+	} else {
+		result.RetryAfter = time.Duration(windowStart + window - now.UnixNano())
+	}
+
+	result.Remaining = int(math.Max(0, math.Floor(limit-estimated)))
+	switch {
+	case state.Value > 0:
+		result.ResetAfter = time.Duration(windowStart + 2*window - now.UnixNano())
+	case state.Previous > 0:
+		result.ResetAfter = time.Duration(windowStart + window - now.UnixNano())
+	}
+	return result
+}
+
+// gcra tracks the theoretical arrival time (TAT) of the next request
+func (rl *rateLimiterImpl) gcra(state *RateLimitState, now time.Time, n int) RateLimitResult {
+	interval := rl.interval().Nanoseconds()
+	tolerance := interval * int64(rl.config.Burst)
+	nowNs := now.UnixNano()
+
+	tat := state.Timestamp
+	if tat < nowNs {
+		tat = nowNs
+	}
+
+	newTat := tat + interval*int64(n)
+	allowAt := newTat - tolerance
+
+	result := RateLimitResult{Limit: rl.config.Burst}
+	if nowNs >= allowAt {
+		tat = newTat
+		state.Timestamp = newTat
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration(allowAt - nowNs)
+	}
+
+	result.Remaining = int(math.Max(0, float64((tolerance-(tat-nowNs))/interval)))
+	result.ResetAfter = time.Duration(tat - nowNs)
+	return result
+}
+
+// RateLimitKeyByIP keys requests by client IP address
+func RateLimitKeyByIP(ctx Context) string {
+	if ip := requestClientIP(ctx.Request()); ip != "" {
+		return "ip:" + ip
+	}
+	return ""
+}
+
+// RateLimitKeyByUser keys requests by authenticated user, falling back to the IP
+// address for anonymous requests
+func RateLimitKeyByUser(ctx Context) string {
+	if user := ctx.User(); user != nil && user.ID != "" {
+		return "user:" + user.ID
+	}
+	return RateLimitKeyByIP(ctx)
+}
+
+// RateLimitKeyByTenant keys requests by tenant, falling back to the IP address
+func RateLimitKeyByTenant(ctx Context) string {
+	if tenant := ctx.Tenant(); tenant != nil && tenant.ID != "" {
+		return "tenant:" + tenant.ID
+	}
+	if req := ctx.Request(); req != nil && req.TenantID != "" {
+		return "tenant:" + req.TenantID
+	}
+	return RateLimitKeyByIP(ctx)
+}
+
+// RateLimitKeyByAPIKey keys requests by the API key in the given header, falling
+// back to the IP address when the header is missing
+func RateLimitKeyByAPIKey(header string) RateLimitKeyFunc {
+	return func(ctx Context) string {
+		if key := ctx.GetHeader(header); key != "" {
+			return "apikey:" + key
+		}
+		return RateLimitKeyByIP(ctx)
+	}
+}
+
+// RateLimitKeyByHeader keys requests by the value of a header. Requests without
+// the header are not rate limited by this key.
+func RateLimitKeyByHeader(header string) RateLimitKeyFunc {
+	return func(ctx Context) string {
+		if value := ctx.GetHeader(header); value != "" {
+			return "header:" + strings.ToLower(header) + ":" + value
+		}
+		return ""
+	}
+}
+
+// requestClientIP extracts the client IP, honouring X-Forwarded-For and X-Real-IP
+func requestClientIP(req *Request) string {
+	if req == nil {
+		return ""
+	}
+
+	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
+		// Take the first IP in the chain
+		return strings.TrimSpace(strings.Split(xff, ",")[0])
+	}
+
+	if xri := req.Header.Get("X-Real-IP"); xri != "" {
+		return xri
+	}
+
+	addr := req.RemoteAddr
+	if idx := strings.LastIndex(addr, ":"); idx != -1 {
+		// Remove port if present
+		addr = addr[:idx]
+	}
+	return addr
+}
+
+// SetRateLimitHeaders emits RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset
+// and RateLimit-Policy headers, plus Retry-After for rejected requests
+func SetRateLimitHeaders(ctx Context, result *RateLimitResult) {
+	if result == nil {
+		return
+	}
+
+	ctx.SetHeader("RateLimit-Limit", strconv.Itoa(result.Limit))
+	ctx.SetHeader("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	ctx.SetHeader("RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.ResetAfter), 10))
+	if result.Window > 0 {
+		ctx.SetHeader("RateLimit-Policy", fmt.Sprintf("%d;w=%d", result.Limit, ceilSeconds(result.Window)))
+	}
+
+	if !result.Allowed {
+		ctx.SetHeader("Retry-After", strconv.FormatInt(ceilSeconds(result.RetryAfter), 10))
+	}
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// newRateLimitExceededError creates the error returned for rejected requests
+func newRateLimitExceededError(message string, result *RateLimitResult, details map[string]interface{}) *FrameworkError {
+	if details == nil {
+		details = make(map[string]interface{})
+	}
+	details["limit"] = result.Limit
+	details["window"] = result.Window.String()
+	details["retry_after"] = ceilSeconds(result.RetryAfter)
+
+	return &FrameworkError{
+		Code:       ErrCodeRateLimitExceeded,
+		Message:    message,
+		StatusCode: http.StatusTooManyRequests,
+		I18nKey:    "error.rate_limit.exceeded",
+		Details:    details,
+	}
+}
+
+// RateLimitMiddleware creates a middleware that rejects requests exceeding the
+// limiter's rate with a 429 error and emits RateLimit-* and Retry-After headers
+func RateLimitMiddleware(limiter RateLimiter) MiddlewareFunc {
+	return func(ctx Context, next HandlerFunc) error {
+		result, err := limiter.Allow(ctx)
+		if err != nil {
+			return err
+		}
+
+		SetRateLimitHeaders(ctx, result)
+
+		if !result.Allowed {
+			return newRateLimitExceededError("rate limit exceeded", result, nil)
+		}
+
+		return next(ctx)
+	}
+}
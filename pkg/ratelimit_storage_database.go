@@ -0,0 +1,127 @@
+package pkg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// databaseRateLimitStoreMaxAttempts bounds optimistic concurrency retries per update
+const databaseRateLimitStoreMaxAttempts = 10
+
+// databaseRateLimitStore implements RateLimitStore on the rate_limit_state table.
+// Updates use optimistic concurrency on a version column, so concurrent requests
+// on any number of instances cannot overshoot a limit.
+type databaseRateLimitStore struct {
+	db DatabaseManager
+}
+
+// NewDatabaseRateLimitStore creates a RateLimitStore backed by the database
+func NewDatabaseRateLimitStore(db DatabaseManager) RateLimitStore {
+	return &databaseRateLimitStore{db: PrimaryDatabase(db)}
+}
+
+// Update atomically applies fn to the state stored under key
+func (s *databaseRateLimitStore) Update(key string, ttl time.Duration, fn func(state *RateLimitState) error) error {
+	if key == "" {
+		return errors.New("rate limit key is required")
+	}
+
+	loadQuery, err := s.db.GetQuery("load_rate_limit_state")
+	if err != nil {
+		return fmt.Errorf("failed to load load_rate_limit_state query: %w", err)
+	}
+	insertQuery, err := s.db.GetQuery("insert_rate_limit_state")
+	if err != nil {
+		return fmt.Errorf("failed to load insert_rate_limit_state query: %w", err)
+	}
+	updateQuery, err := s.db.GetQuery("update_rate_limit_state")
+	if err != nil {
+		return fmt.Errorf("failed to load update_rate_limit_state query: %w", err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < databaseRateLimitStoreMaxAttempts; attempt++ {
+		var stateJSON string
+		var version int64
+		var expiresAt time.Time
+
+		exists := true
+		err := s.db.QueryRow(loadQuery, key).Scan(&stateJSON, &version, &expiresAt)
+		if err == sql.ErrNoRows {
+			exists = false
+		} else if err != nil {
+			return fmt.Errorf("failed to load rate limit state: %w", err)
+		}
+
+		var state RateLimitState
+		if exists && expiresAt.After(time.Now()) && stateJSON != "" {
+			if err := json.Unmarshal([]byte(stateJSON), &state); err != nil {
+				return fmt.Errorf("failed to decode rate limit state: %w", err)
+			}
+		}
+
+		if err := fn(&state); err != nil {
+			return err
+		}
+
+		encoded, err := json.Marshal(state)
+		if err != nil {
+			return fmt.Errorf("failed to encode rate limit state: %w", err)
+		}
+		newExpiry := time.Now().Add(ttl)
+
+		if !exists {
+			// A concurrent insert of the same key fails on the primary key; retry as update
+			if _, err := s.db.Exec(insertQuery, key, string(encoded), newExpiry); err != nil {
+				if !isDuplicateKeyError(err) {
+					return fmt.Errorf("failed to save rate limit state: %w", err)
+				}
+				lastErr = err
+				continue
+			}
+			return nil
+		}
+
+		result, err := s.db.Exec(updateQuery, string(encoded), newExpiry, key, version)
+		if err != nil {
+			return fmt.Errorf("failed to save rate limit state: %w", err)
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 1 {
+			return nil
+		}
+		lastErr = errors.New("concurrent rate limit update")
+	}
+
+	return fmt.Errorf("rate limit state for %s is under contention: %w", key, lastErr)
+}
+
+// Delete removes the state for key
+func (s *databaseRateLimitStore) Delete(key string) error {
+	query, err := s.db.GetQuery("delete_rate_limit_state")
+	if err != nil {
+		return fmt.Errorf("failed to load delete_rate_limit_state query: %w", err)
+	}
+
+	if _, err := s.db.Exec(query, key); err != nil {
+		return fmt.Errorf("failed to delete rate limit state: %w", err)
+	}
+
+	return nil
+}
+
+// Cleanup removes expired rate limit state
+func (s *databaseRateLimitStore) Cleanup() error {
+	query, err := s.db.GetQuery("cleanup_rate_limit_state")
+	if err != nil {
+		return fmt.Errorf("failed to load cleanup_rate_limit_state query: %w", err)
+	}
+
+	if _, err := s.db.Exec(query, time.Now()); err != nil {
+		return fmt.Errorf("failed to clean up rate limit state: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIntegration_DatabaseRateLimitStore(t *testing.T) {
+	dm := NewDatabaseManager()
+	if err := dm.Connect(createTestDBConfig(filepath.Join(t.TempDir(), "ratelimit.db"))); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer dm.Close()
+
+	if err := dm.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	newLimiter := func() RateLimiter {
+		limiter, err := NewRateLimiter(RateLimiterConfig{
+			Algorithm: RateLimitGCRA,
+			Limit:     5,
+			Window:    time.Minute,
+			Store:     NewDatabaseRateLimitStore(dm),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create rate limiter: %v", err)
+		}
+		return limiter
+	}
+
+	// Two limiters simulate two instances sharing the database
+	first, second := newLimiter(), newLimiter()
+
+	if got := allowCount(t, first, "client", 3); got != 3 {
+		t.Fatalf("Expected 3 allowed requests, got %d", got)
+	}
+	if got := allowCount(t, second, "client", 5); got != 2 {
+		t.Fatalf("Expected second instance to see shared state and allow 2, got %d", got)
+	}
+
+	if err := second.Reset("client"); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if got := allowCount(t, first, "client", 1); got != 1 {
+		t.Error("Expected request to be allowed after reset")
+	}
+
+	store := NewDatabaseRateLimitStore(dm).(*databaseRateLimitStore)
+	if err := store.Update("expired", -time.Second, func(state *RateLimitState) error {
+		state.Value = 1
+		return nil
+	}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := store.Cleanup(); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+
+	var count int
+	if err := dm.QueryRow("SELECT COUNT(*) FROM rate_limit_state WHERE rate_key = ?", "expired").Scan(&count); err != nil {
+		t.Fatalf("Count query failed: %v", err)
+	}
+	if count != 0 {
+		t.Error("Expected expired state to be cleaned up")
+	}
+}
@@ -0,0 +1,311 @@
+package pkg
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestRateLimiter creates a rate limiter with a controllable clock
+func newTestRateLimiter(t *testing.T, config RateLimiterConfig) (*rateLimiterImpl, *time.Time) {
+	limiter, err := NewRateLimiter(config)
+	if err != nil {
+		t.Fatalf("Failed to create rate limiter: %v", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	impl := limiter.(*rateLimiterImpl)
+	impl.now = func() time.Time { return now }
+	return impl, &now
+}
+
+// allowCount consumes n requests one at a time and returns how many were allowed
+func allowCount(t *testing.T, limiter RateLimiter, key string, n int) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		result, err := limiter.AllowN(key, 1)
+		if err != nil {
+			t.Fatalf("AllowN failed: %v", err)
+		}
+		if result.Allowed {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestNewRateLimiter_Validation(t *testing.T) {
+	if _, err := NewRateLimiter(RateLimiterConfig{}); err == nil {
+		t.Error("Expected error for zero limit")
+	}
+	if _, err := NewRateLimiter(RateLimiterConfig{Limit: 1, Algorithm: "leaky"}); err == nil {
+		t.Error("Expected error for unknown algorithm")
+	}
+}
+
+func TestRateLimiter_TokenBucketHonoursBurst(t *testing.T) {
+	limiter, now := newTestRateLimiter(t, RateLimiterConfig{
+		Algorithm: RateLimitTokenBucket,
+		Limit:     10,
+		Window:    time.Second,
+		Burst:     3,
+	})
+
+	if got := allowCount(t, limiter, "k", 5); got != 3 {
+		t.Fatalf("Expected burst of 3, got %d", got)
+	}
+
+	result, _ := limiter.AllowN("k", 1)
+	if result.Allowed || result.RetryAfter != 100*time.Millisecond {
+		t.Errorf("Expected rejection with 100ms retry, got %+v", result)
+	}
+
+	// One token refills every 100ms
+	*now = now.Add(100 * time.Millisecond)
+	if got := allowCount(t, limiter, "k", 2); got != 1 {
+		t.Errorf("Expected 1 refilled token, got %d", got)
+	}
+
+	// Refill never exceeds the burst
+	*now = now.Add(time.Hour)
+	if got := allowCount(t, limiter, "k", 5); got != 3 {
+		t.Errorf("Expected refill capped at burst of 3, got %d", got)
+	}
+}
+
+func TestRateLimiter_SlidingWindowLog(t *testing.T) {
+	limiter, now := newTestRateLimiter(t, RateLimiterConfig{
+		Algorithm: RateLimitSlidingWindowLog,
+		Limit:     3,
+		Window:    time.Minute,
+	})
+
+	allowCount(t, limiter, "k", 1)
+	*now = now.Add(30 * time.Second)
+	if got := allowCount(t, limiter, "k", 3); got != 2 {
+		t.Fatalf("Expected 2 more requests, got %d", got)
+	}
+
+	result, _ := limiter.AllowN("k", 1)
+	if result.Allowed || result.RetryAfter != 30*time.Second {
+		t.Errorf("Expected rejection until the first request leaves the window, got %+v", result)
+	}
+
+	*now = now.Add(30*time.Second + time.Millisecond)
+	if got := allowCount(t, limiter, "k", 2); got != 1 {
+		t.Errorf("Expected exactly one slot to free up, got %d", got)
+	}
+}
+
+func TestRateLimiter_SlidingWindowCounter(t *testing.T) {
+	limiter, now := newTestRateLimiter(t, RateLimiterConfig{
+		Algorithm: RateLimitSlidingWindowCounter,
+		Limit:     10,
+		Window:    time.Minute,
+	})
+
+	// Start at the beginning of a window
+	*now = now.Truncate(time.Minute)
+	if got := allowCount(t, limiter, "k", 15); got != 10 {
+		t.Fatalf("Expected 10 requests in the first window, got %d", got)
+	}
+
+	// Half-way into the next window the previous window still weighs 50%
+	*now = now.Add(90 * time.Second)
+	if got := allowCount(t, limiter, "k", 10); got != 5 {
+		t.Errorf("Expected 5 requests with half of the previous window counted, got %d", got)
+	}
+
+	// Two windows later the old counts no longer matter
+	*now = now.Add(2 * time.Minute)
+	if got := allowCount(t, limiter, "k", 15); got != 10 {
+		t.Errorf("Expected a full window, got %d", got)
+	}
+}
+
+func TestRateLimiter_GCRA(t *testing.T) {
+	limiter, now := newTestRateLimiter(t, RateLimiterConfig{
+		Algorithm: RateLimitGCRA,
+		Limit:     60,
+		Window:    time.Minute,
+		Burst:     5,
+	})
+
+	if got := allowCount(t, limiter, "k", 10); got != 5 {
+		t.Fatalf("Expected burst of 5, got %d", got)
+	}
+
+	result, _ := limiter.AllowN("k", 1)
+	if result.Allowed || result.RetryAfter != time.Second {
+		t.Errorf("Expected rejection with 1s retry, got %+v", result)
+	}
+
+	*now = now.Add(2 * time.Second)
+	if got := allowCount(t, limiter, "k", 5); got != 2 {
+		t.Errorf("Expected 2 requests after 2s, got %d", got)
+	}
+}
+
+func TestRateLimiter_ConcurrentRequestsDoNotOvershoot(t *testing.T) {
+	algorithms := []RateLimitAlgorithm{
+		RateLimitTokenBucket, RateLimitSlidingWindowLog, RateLimitSlidingWindowCounter, RateLimitGCRA,
+	}
+
+	for _, algorithm := range algorithms {
+		t.Run(string(algorithm), func(t *testing.T) {
+			limiter, err := NewRateLimiter(RateLimiterConfig{Algorithm: algorithm, Limit: 20, Window: time.Hour})
+			if err != nil {
+				t.Fatalf("Failed to create rate limiter: %v", err)
+			}
+
+			var allowed int64
+			var wg sync.WaitGroup
+			for i := 0; i < 100; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					result, err := limiter.AllowN("shared", 1)
+					if err == nil && result.Allowed {
+						atomic.AddInt64(&allowed, 1)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if allowed != 20 {
+				t.Errorf("Expected exactly 20 allowed requests, got %d", allowed)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_Reset(t *testing.T) {
+	limiter, _ := newTestRateLimiter(t, RateLimiterConfig{Limit: 1})
+
+	allowCount(t, limiter, "k", 1)
+	if err := limiter.Reset("k"); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if got := allowCount(t, limiter, "k", 1); got != 1 {
+		t.Error("Expected request to be allowed after reset")
+	}
+}
+
+func TestRateLimitKeyFuncs(t *testing.T) {
+	req := &Request{Header: http.Header{}, RemoteAddr: "10.0.0.1:5000"}
+	ctx := createTestContext(t, req)
+
+	if got := RateLimitKeyByIP(ctx); got != "ip:10.0.0.1" {
+		t.Errorf("RateLimitKeyByIP() = %s", got)
+	}
+
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	if got := RateLimitKeyByIP(ctx); got != "ip:203.0.113.7" {
+		t.Errorf("RateLimitKeyByIP() with X-Forwarded-For = %s", got)
+	}
+
+	if got := RateLimitKeyByAPIKey("X-API-Key")(ctx); got != "ip:203.0.113.7" {
+		t.Errorf("RateLimitKeyByAPIKey() fallback = %s", got)
+	}
+	req.Header.Set("X-API-Key", "abc")
+	if got := RateLimitKeyByAPIKey("X-API-Key")(ctx); got != "apikey:abc" {
+		t.Errorf("RateLimitKeyByAPIKey() = %s", got)
+	}
+
+	ctx.(*contextImpl).SetUser(&User{ID: "u1"})
+	if got := RateLimitKeyByUser(ctx); got != "user:u1" {
+		t.Errorf("RateLimitKeyByUser() = %s", got)
+	}
+
+	req.TenantID = "t1"
+	if got := RateLimitKeyByTenant(ctx); got != "tenant:t1" {
+		t.Errorf("RateLimitKeyByTenant() = %s", got)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limiter, err := NewRateLimiter(RateLimiterConfig{Limit: 2, Window: time.Minute})
+	if err != nil {
+		t.Fatalf("Failed to create rate limiter: %v", err)
+	}
+	mw := RateLimitMiddleware(limiter)
+	handler := func(ctx Context) error { return nil }
+
+	var ctx Context
+	for i := 0; i < 2; i++ {
+		ctx = createTestContext(t, &Request{Header: http.Header{}, RemoteAddr: "10.0.0.1:5000"})
+		if err := mw(ctx, handler); err != nil {
+			t.Fatalf("Request %d should be allowed: %v", i, err)
+		}
+	}
+
+	headers := ctx.Response().(*testResponseWriter).headers
+	if headers.Get("RateLimit-Limit") != "2" || headers.Get("RateLimit-Remaining") != "0" {
+		t.Errorf("Unexpected rate limit headers: %v", headers)
+	}
+	if headers.Get("RateLimit-Policy") != "2;w=60" {
+		t.Errorf("Unexpected RateLimit-Policy: %s", headers.Get("RateLimit-Policy"))
+	}
+
+	ctx = createTestContext(t, &Request{Header: http.Header{}, RemoteAddr: "10.0.0.1:5000"})
+	err = mw(ctx, handler)
+	fwErr, ok := GetFrameworkError(err)
+	if !ok || fwErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 error, got %v", err)
+	}
+	if ctx.Response().(*testResponseWriter).headers.Get("Retry-After") != "30" {
+		t.Errorf("Expected Retry-After of 30s, got %q", ctx.Response().(*testResponseWriter).headers.Get("Retry-After"))
+	}
+}
+
+func TestNewHostRateLimiter(t *testing.T) {
+	if _, err := NewHostRateLimiter(&RateLimitConfig{Enabled: false}, nil); err == nil {
+		t.Error("Expected error for disabled config")
+	}
+	if _, err := NewHostRateLimiter(&RateLimitConfig{Enabled: true, RequestsPerSecond: 1, Storage: "database"}, NewNoopDatabaseManager()); err == nil {
+		t.Error("Expected error for database storage without a database")
+	}
+
+	limiter, err := NewHostRateLimiter(&RateLimitConfig{Enabled: true, RequestsPerSecond: 5, BurstSize: 8}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create host rate limiter: %v", err)
+	}
+	if got := allowCount(t, limiter, "k", 20); got != 8 {
+		t.Errorf("Expected BurstSize of 8 to be honoured, got %d", got)
+	}
+}
+
+func TestSecurityManager_CheckRateLimitUsesConfig(t *testing.T) {
+	config := DefaultSecurityConfig()
+	config.EncryptionKey = "3132333435363738393031323334353637383930313233343536373839303132"
+	config.RateLimitRequests = 3
+	config.RateLimitWindow = time.Minute
+
+	sm, err := NewSecurityManager(NewNoopDatabaseManager(), config)
+	if err != nil {
+		t.Fatalf("Failed to create security manager: %v", err)
+	}
+
+	req := &Request{Header: http.Header{}, RemoteAddr: "10.0.0.9:1234"}
+	for i := 0; i < 3; i++ {
+		if err := sm.CheckRateLimit(createTestContext(t, req), "orders"); err != nil {
+			t.Fatalf("Request %d should be allowed: %v", i, err)
+		}
+	}
+
+	ctx := createTestContext(t, req)
+	err = sm.CheckRateLimit(ctx, "orders")
+	if fwErr, ok := GetFrameworkError(err); !ok || fwErr.Code != ErrCodeRateLimitExceeded {
+		t.Fatalf("Expected rate limit error, got %v", err)
+	}
+	if ctx.Response().(*testResponseWriter).headers.Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header")
+	}
+
+	// Other resources have their own budget
+	if err := sm.CheckRateLimit(createTestContext(t, req), "users"); err != nil {
+		t.Errorf("Expected other resource to be allowed: %v", err)
+	}
+}
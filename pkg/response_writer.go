@@ -236,6 +236,17 @@ func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
 	return errors.New("response writer does not support HTTP/2 push")
 }
 
+// reset discards the status and size of a response that was not sent, so
+// that another one can be written
+func (w *responseWriter) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status = http.StatusOK
+	w.size = 0
+	w.written = false
+	w.wroteHeader = false
+}
+
 // SetTemplateManager sets the template manager for the response writer
 func (w *responseWriter) SetTemplateManager(tm TemplateManager) {
 	w.mu.Lock()
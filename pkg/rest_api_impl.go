@@ -282,7 +282,8 @@ func (r *restAPIManager) validationMiddleware(config RESTRouteConfig, next RESTH
 
 		// Apply timeout if configured
 		if config.Timeout > 0 {
-			timeoutCtx := ctx.WithTimeout(config.Timeout)
+			timeoutCtx, cancel := ContextWithTimeout(ctx, config.Timeout)
+			defer cancel()
 			return next(timeoutCtx)
 		}
 
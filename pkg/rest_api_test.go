@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"testing"
@@ -456,17 +457,35 @@ func (m *mockDB) IncrementRateLimit(key string, window time.Duration) error {
 }
 
 // Implement other DatabaseManager methods as no-ops
-func (m *mockDB) Connect(config DatabaseConfig) error                         { return nil }
-func (m *mockDB) Close() error                                                { return nil }
-func (m *mockDB) Ping() error                                                 { return nil }
-func (m *mockDB) Stats() DatabaseStats                                        { return DatabaseStats{} }
-func (m *mockDB) IsConnected() bool                                           { return true }
-func (m *mockDB) Query(query string, args ...interface{}) (*sql.Rows, error)  { return nil, nil }
-func (m *mockDB) QueryRow(query string, args ...interface{}) *sql.Row         { return nil }
-func (m *mockDB) Exec(query string, args ...interface{}) (sql.Result, error)  { return nil, nil }
-func (m *mockDB) Prepare(query string) (*sql.Stmt, error)                     { return nil, nil }
-func (m *mockDB) Begin() (Transaction, error)                                 { return nil, nil }
-func (m *mockDB) BeginTx(opts *sql.TxOptions) (Transaction, error)            { return nil, nil }
+func (m *mockDB) Connect(config DatabaseConfig) error                        { return nil }
+func (m *mockDB) Close() error                                               { return nil }
+func (m *mockDB) Ping() error                                                { return nil }
+func (m *mockDB) Stats() DatabaseStats                                       { return DatabaseStats{} }
+func (m *mockDB) IsConnected() bool                                          { return true }
+func (m *mockDB) Query(query string, args ...interface{}) (*sql.Rows, error) { return nil, nil }
+func (m *mockDB) QueryRow(query string, args ...interface{}) *sql.Row        { return nil }
+func (m *mockDB) Exec(query string, args ...interface{}) (sql.Result, error) { return nil, nil }
+func (m *mockDB) Prepare(query string) (*sql.Stmt, error)                    { return nil, nil }
+func (m *mockDB) Begin() (Transaction, error)                                { return nil, nil }
+func (m *mockDB) BeginTx(opts *sql.TxOptions) (Transaction, error)           { return nil, nil }
+func (m *mockDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return nil, nil
+}
+func (m *mockDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	return nil
+}
+func (m *mockDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return m.Exec(query, args...)
+}
+func (m *mockDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return m.Prepare(query)
+}
+func (m *mockDB) BeginTxContext(ctx context.Context, opts *sql.TxOptions) (Transaction, error) {
+	return m.BeginTx(opts)
+}
+func (m *mockDB) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Transaction) error) error {
+	return fn(nil)
+}
 func (m *mockDB) SaveSession(session *Session) error                          { return nil }
 func (m *mockDB) LoadSession(sessionID string) (*Session, error)              { return nil, nil }
 func (m *mockDB) DeleteSession(sessionID string) error                        { return nil }
@@ -0,0 +1,652 @@
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MissedRunPolicy decides what happens to runs of a task that were due while
+// no instance was leading, or while the task's previous run was still going
+type MissedRunPolicy string
+
+const (
+	// MissedRunOnce runs the latest missed run once (default)
+	MissedRunOnce MissedRunPolicy = "once"
+	// MissedRunSkip drops missed runs and waits for the next scheduled time
+	MissedRunSkip MissedRunPolicy = "skip"
+	// MissedRunAll runs every missed run in order, up to maxCatchUpRuns
+	MissedRunAll MissedRunPolicy = "all"
+)
+
+// Task run states stored in scheduled_task_runs.status
+const (
+	TaskRunRunning   = "running"
+	TaskRunSucceeded = "succeeded"
+	TaskRunFailed    = "failed"
+)
+
+const (
+	// maxCatchUpRuns bounds the missed runs MissedRunAll executes at once
+	maxCatchUpRuns = 100
+	// maxMissedRunScan bounds the activations inspected when catching up
+	maxMissedRunScan = 100000
+)
+
+// SchedulerConfig configures a Scheduler
+type SchedulerConfig struct {
+	// TimeZone is the IANA time zone cron expressions are evaluated in,
+	// unless a task or a CRON_TZ prefix sets its own.
+	// Default: "UTC"
+	TimeZone string
+
+	// LeaseName is the scheduler_leases row instances compete for. Instances
+	// with different lease names elect separate leaders.
+	// Default: "scheduler"
+	LeaseName string
+
+	// LeaseTimeout is how long the leader holds its lease without renewing
+	// it. The lease is renewed every third of it; another instance takes
+	// over once it expires.
+	// Default: 30 seconds
+	LeaseTimeout time.Duration
+
+	// TickInterval is how often the leader checks for due tasks.
+	// Default: 1 second
+	TickInterval time.Duration
+
+	// MissedRunThreshold is how late a run may start before it counts as missed.
+	// Default: 1 minute
+	MissedRunThreshold time.Duration
+
+	// HistoryRetention is how long finished runs are kept. The latest run of
+	// every task is always kept.
+	// Default: 7 days
+	HistoryRetention time.Duration
+
+	// InstanceID identifies this instance in leases and the run history.
+	// Default: host name and a random suffix
+	InstanceID string
+}
+
+// TaskHandler runs a scheduled task. ctx is canceled when the task's timeout
+// expires or the scheduler stops before the task finishes.
+type TaskHandler func(ctx context.Context) error
+
+// ScheduledTask is a recurring task
+type ScheduledTask struct {
+	// Name identifies the task across instances and in the run history
+	Name string
+
+	// Schedule is a cron expression, see CronSchedule
+	Schedule string
+
+	// TimeZone overrides SchedulerConfig.TimeZone for this task
+	TimeZone string
+
+	// Handler runs the task
+	Handler TaskHandler
+
+	// MissedRuns is the policy for missed runs.
+	// Default: MissedRunOnce
+	MissedRuns MissedRunPolicy
+
+	// Timeout cancels the handler's context after this long. Zero means no timeout.
+	Timeout time.Duration
+}
+
+// TaskRun is an execution of a scheduled task
+type TaskRun struct {
+	ID          string    `json:"id"`
+	Task        string    `json:"task"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at,omitempty"` // Zero while running
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	Instance    string    `json:"instance"`
+}
+
+// TaskInfo describes a registered task
+type TaskInfo struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	NextRun  time.Time `json:"next_run"`
+	Running  bool      `json:"running"`
+}
+
+// Scheduler runs recurring tasks on cron schedules. With a database, the
+// instances elect a leader through a lease row in scheduler_leases, and every
+// scheduled run is recorded in scheduled_task_runs before it starts, so each
+// run executes on exactly one instance. Without a database every instance
+// runs all tasks.
+type Scheduler interface {
+	// Schedule registers handler to run on schedule with the default options
+	Schedule(name, schedule string, handler TaskHandler) error
+
+	// Register registers a task. Tasks can be added while the scheduler runs.
+	Register(task ScheduledTask) error
+
+	// Unregister removes a task. A running execution finishes normally.
+	Unregister(name string) error
+
+	// Tasks lists the registered tasks ordered by name
+	Tasks() []TaskInfo
+
+	// Start starts competing for leadership and running due tasks. It does
+	// nothing if the scheduler is already running.
+	Start() error
+
+	// Stop stops scheduling, waits for running tasks until ctx is done and
+	// releases the lease so another instance can take over at once.
+	Stop(ctx context.Context) error
+
+	// IsLeader reports whether this instance currently runs the tasks
+	IsLeader() bool
+
+	// History returns up to limit runs of task, newest first
+	History(task string, limit int) ([]*TaskRun, error)
+}
+
+// scheduler implements Scheduler
+type scheduler struct {
+	store    schedulerStore
+	inMemory bool
+	config   SchedulerConfig
+	location *time.Location
+	metrics  MetricsCollector
+	now      func() time.Time
+
+	mu          sync.Mutex
+	tasks       map[string]*scheduledTask
+	leader      bool
+	lastRenew   time.Time
+	lastCleanup time.Time
+	leaseFailed bool // The last lease renewal failed
+	// leaseHooks run once, when leader election first works
+	leaseHooks       []func()
+	leaseEstablished bool
+	stop             chan struct{}
+	cancelRun        context.CancelFunc
+	loop             sync.WaitGroup
+	running          sync.WaitGroup
+}
+
+// scheduledTask is a registered task and its scheduling state on the leader
+type scheduledTask struct {
+	ScheduledTask
+	schedule    *CronSchedule
+	initialized bool      // next was computed from the run history
+	next        time.Time // Zero if the schedule has no further activation
+	running     bool
+}
+
+// NewScheduler creates a scheduler on db. Call Migrate before using it; the
+// scheduler tables are created by framework migrations. metrics is optional.
+func NewScheduler(db DatabaseManager, config SchedulerConfig, metrics MetricsCollector) (Scheduler, error) {
+	config.ApplyDefaults()
+
+	location, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scheduler time zone %q: %w", config.TimeZone, err)
+	}
+	if config.InstanceID == "" {
+		config.InstanceID = defaultInstanceID()
+	}
+
+	s := &scheduler{
+		config:   config,
+		location: location,
+		metrics:  metrics,
+		now:      time.Now,
+		tasks:    make(map[string]*scheduledTask),
+	}
+	if isNoopDatabase(db) {
+		s.store = newInMemorySchedulerStore()
+		s.inMemory = true
+	} else {
+		s.store = &databaseSchedulerStore{db: PrimaryDatabase(db)}
+	}
+	return s, nil
+}
+
+// defaultInstanceID returns the host name with a random suffix
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "instance"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// Schedule registers handler to run on schedule
+func (s *scheduler) Schedule(name, schedule string, handler TaskHandler) error {
+	return s.Register(ScheduledTask{Name: name, Schedule: schedule, Handler: handler})
+}
+
+// Register registers a task
+func (s *scheduler) Register(task ScheduledTask) error {
+	if task.Name == "" {
+		return errors.New("task name cannot be empty")
+	}
+	if task.Handler == nil {
+		return errors.New("task handler cannot be nil")
+	}
+	switch task.MissedRuns {
+	case "":
+		task.MissedRuns = MissedRunOnce
+	case MissedRunOnce, MissedRunSkip, MissedRunAll:
+	default:
+		return fmt.Errorf("invalid missed run policy %q for task %s", task.MissedRuns, task.Name)
+	}
+
+	location := s.location
+	if task.TimeZone != "" {
+		loc, err := time.LoadLocation(task.TimeZone)
+		if err != nil {
+			return fmt.Errorf("invalid time zone %q for task %s: %w", task.TimeZone, task.Name, err)
+		}
+		location = loc
+	}
+	schedule, err := ParseCronSchedule(task.Schedule, location)
+	if err != nil {
+		return fmt.Errorf("invalid schedule for task %s: %w", task.Name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.tasks[task.Name]; exists {
+		return fmt.Errorf("task %s is already registered", task.Name)
+	}
+	s.tasks[task.Name] = &scheduledTask{ScheduledTask: task, schedule: schedule}
+	return nil
+}
+
+// Unregister removes a task
+func (s *scheduler) Unregister(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.tasks[name]; !exists {
+		return fmt.Errorf("task %s is not registered", name)
+	}
+	delete(s.tasks, name)
+	return nil
+}
+
+// Tasks lists the registered tasks
+func (s *scheduler) Tasks() []TaskInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	tasks := make([]TaskInfo, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		next := task.next
+		if !task.initialized {
+			next = task.schedule.Next(now)
+		}
+		tasks = append(tasks, TaskInfo{Name: task.Name, Schedule: task.Schedule, NextRun: next, Running: task.running})
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Name < tasks[j].Name })
+	return tasks
+}
+
+// Start starts the scheduler loop
+func (s *scheduler) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		return nil
+	}
+	if s.inMemory {
+		fmt.Println("WARN: Scheduler using in-memory storage. Scheduled tasks will run on every instance.")
+	}
+
+	s.stop = make(chan struct{})
+	var runCtx context.Context
+	runCtx, s.cancelRun = context.WithCancel(context.Background())
+	s.lastRenew = time.Time{}
+	s.loop.Add(1)
+	go s.run(s.stop, runCtx)
+	return nil
+}
+
+// Stop stops the scheduler loop
+func (s *scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	stop, cancelRun := s.stop, s.cancelRun
+	s.stop = nil
+	s.mu.Unlock()
+
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	s.loop.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		fmt.Println("WARN: Scheduler stop timed out, canceling running tasks")
+	}
+	cancelRun()
+
+	if s.IsLeader() {
+		if err := s.store.release(context.Background(), s.config.LeaseName, s.config.InstanceID, s.now().UTC()); err != nil {
+			fmt.Printf("WARN: Failed to release scheduler lease: %v\n", err)
+		}
+		s.setLeader(false)
+	}
+	return nil
+}
+
+// IsLeader reports whether this instance runs the tasks
+func (s *scheduler) IsLeader() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leader
+}
+
+// History returns up to limit runs of task, newest first
+func (s *scheduler) History(task string, limit int) ([]*TaskRun, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	return s.store.history(context.Background(), task, limit)
+}
+
+// run checks for due tasks every TickInterval until stop is closed
+func (s *scheduler) run(stop chan struct{}, runCtx context.Context) {
+	defer s.loop.Done()
+
+	ticker := time.NewTicker(s.config.TickInterval)
+	defer ticker.Stop()
+	for {
+		s.tick(runCtx)
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// tick renews the lease and starts the due tasks if this instance leads
+func (s *scheduler) tick(runCtx context.Context) {
+	now := s.now()
+	if !s.renewLease(runCtx, now) {
+		return
+	}
+	s.cleanup(now)
+
+	s.mu.Lock()
+	tasks := make([]*scheduledTask, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		tasks = append(tasks, task)
+	}
+	s.mu.Unlock()
+
+	for _, task := range tasks {
+		if err := s.dispatch(runCtx, task, now); err != nil && runCtx.Err() == nil {
+			fmt.Printf("WARN: Failed to schedule task %s: %v\n", task.Name, err)
+		}
+	}
+}
+
+// renewLease acquires or renews the lease when it is due and reports whether
+// this instance leads
+func (s *scheduler) renewLease(ctx context.Context, now time.Time) bool {
+	s.mu.Lock()
+	due := now.Sub(s.lastRenew) >= s.config.LeaseTimeout/3
+	leader := s.leader
+	s.mu.Unlock()
+	if !due {
+		return leader
+	}
+
+	acquired, err := s.store.acquire(ctx, s.config.LeaseName, s.config.InstanceID, now.UTC(), now.UTC().Add(s.config.LeaseTimeout))
+
+	s.mu.Lock()
+	s.lastRenew = now
+	warn := err != nil && !s.leaseFailed && ctx.Err() == nil
+	s.leaseFailed = err != nil
+	var hooks []func()
+	if err == nil && !s.leaseEstablished {
+		s.leaseEstablished = true
+		hooks, s.leaseHooks = s.leaseHooks, nil
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		// Warn once per outage rather than on every renewal
+		if warn {
+			fmt.Printf("WARN: Failed to renew scheduler lease, scheduled tasks do not run until it succeeds (are the scheduler tables migrated?): %v\n", err)
+		}
+		acquired = false
+	}
+	for _, hook := range hooks {
+		hook()
+	}
+	s.setLeader(acquired)
+	return acquired
+}
+
+// onLeaseEstablished runs fn once the lease has been acquired or renewed by any
+// instance for the first time, which shows that leader election works and the
+// scheduled tasks will run somewhere
+func (s *scheduler) onLeaseEstablished(fn func()) {
+	s.mu.Lock()
+	if !s.leaseEstablished {
+		s.leaseHooks = append(s.leaseHooks, fn)
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	fn()
+}
+
+// setLeader records a change of leadership
+func (s *scheduler) setLeader(leader bool) {
+	s.mu.Lock()
+	changed := s.leader != leader
+	s.leader = leader
+	if changed && leader {
+		// Another instance may have run tasks since this one last led
+		for _, task := range s.tasks {
+			task.initialized = false
+		}
+	}
+	s.mu.Unlock()
+
+	if changed && s.metrics != nil {
+		value := 0.0
+		if leader {
+			value = 1
+		}
+		_ = s.metrics.SetGauge("scheduler.leader", value, map[string]string{"instance": s.config.InstanceID})
+	}
+}
+
+// dispatch starts the due runs of task
+func (s *scheduler) dispatch(runCtx context.Context, task *scheduledTask, now time.Time) error {
+	s.mu.Lock()
+	initialized, running := task.initialized, task.running
+	s.mu.Unlock()
+	if running {
+		return nil
+	}
+
+	if !initialized {
+		last, found, err := s.store.lastRun(runCtx, task.Name)
+		if err != nil {
+			return err
+		}
+		next := task.schedule.Next(now)
+		if found {
+			next = task.schedule.Next(last)
+		}
+		s.mu.Lock()
+		task.next, task.initialized = next, true
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if task.next.IsZero() || task.next.After(now) || s.tasks[task.Name] != task {
+		return nil
+	}
+
+	runs, missed := s.dueRuns(task, now)
+	task.next = task.schedule.Next(now)
+	if missed > 0 {
+		fmt.Printf("WARN: Scheduled task %s missed %d runs\n", task.Name, missed)
+		if s.metrics != nil {
+			_ = s.metrics.IncrementCounterBy("scheduler.missed", int64(missed), map[string]string{"task": task.Name})
+		}
+	}
+	if len(runs) == 0 {
+		return nil
+	}
+
+	task.running = true
+	s.running.Add(1)
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			task.running = false
+			s.mu.Unlock()
+			s.running.Done()
+		}()
+		for _, at := range runs {
+			if runCtx.Err() != nil {
+				return
+			}
+			s.execute(runCtx, task, at)
+		}
+	}()
+	return nil
+}
+
+// dueRuns returns the runs of task to execute now according to its missed
+// run policy, and the number of runs it drops
+func (s *scheduler) dueRuns(task *scheduledTask, now time.Time) ([]time.Time, int) {
+	var due []time.Time
+	missed := 0
+	for at, n := task.next, 0; !at.IsZero() && !at.After(now) && n < maxMissedRunScan; at, n = task.schedule.Next(at), n+1 {
+		due = append(due, at)
+		if len(due) > maxCatchUpRuns {
+			due = due[1:]
+			missed++
+		}
+	}
+	if len(due) == 0 {
+		return nil, missed
+	}
+
+	latest := due[len(due)-1]
+	switch task.MissedRuns {
+	case MissedRunAll:
+		return due, missed
+	case MissedRunSkip:
+		if now.Sub(latest) <= s.config.MissedRunThreshold {
+			return []time.Time{latest}, missed + len(due) - 1
+		}
+		return nil, missed + len(due)
+	default:
+		return []time.Time{latest}, missed + len(due) - 1
+	}
+}
+
+// execute runs task for the activation at, unless another instance already did
+func (s *scheduler) execute(runCtx context.Context, task *scheduledTask, at time.Time) {
+	run := &TaskRun{
+		ID:          generateUUIDv7(),
+		Task:        task.Name,
+		ScheduledAt: at.UTC(),
+		StartedAt:   s.now().UTC(),
+		Status:      TaskRunRunning,
+		Instance:    s.config.InstanceID,
+	}
+	claimed, err := s.store.claimRun(context.Background(), run)
+	if err != nil {
+		fmt.Printf("WARN: Failed to record run of task %s: %v\n", task.Name, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	ctx, cancel := runCtx, context.CancelFunc(func() {})
+	if task.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(runCtx, task.Timeout)
+	}
+	start := time.Now()
+	err = callTaskHandler(ctx, task.Handler, task.Name)
+	cancel()
+
+	run.FinishedAt = s.now().UTC()
+	run.Status = TaskRunSucceeded
+	if err != nil {
+		run.Status = TaskRunFailed
+		run.Error = jobError(err)
+		fmt.Printf("WARN: Scheduled task %s failed: %v\n", task.Name, err)
+	}
+	if err := s.store.finishRun(context.Background(), run); err != nil {
+		fmt.Printf("WARN: Failed to record outcome of task %s: %v\n", task.Name, err)
+	}
+
+	if s.metrics != nil {
+		_ = s.metrics.IncrementCounter("scheduler.runs", map[string]string{"task": task.Name, "status": run.Status})
+		_ = s.metrics.RecordTiming("scheduler.duration", time.Since(start), map[string]string{"task": task.Name})
+	}
+}
+
+// cleanup removes expired runs from the history, at most once per hour
+func (s *scheduler) cleanup(now time.Time) {
+	s.mu.Lock()
+	due := now.Sub(s.lastCleanup) >= time.Hour
+	if due {
+		s.lastCleanup = now
+	}
+	s.mu.Unlock()
+
+	if due {
+		if err := s.store.cleanup(context.Background(), now.UTC().Add(-s.config.HistoryRetention)); err != nil {
+			fmt.Printf("WARN: Failed to clean up scheduled task runs: %v\n", err)
+		}
+	}
+}
+
+// callTaskHandler runs handler and turns a panic into an error
+func callTaskHandler(ctx context.Context, handler TaskHandler, name string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("task %s panicked: %v", name, r)
+		}
+	}()
+	return handler(ctx)
+}
+
+// unregisterTasksWithPrefix removes the tasks whose name starts with prefix
+func unregisterTasksWithPrefix(s Scheduler, prefix string) {
+	for _, task := range s.Tasks() {
+		if strings.HasPrefix(task.Name, prefix) {
+			_ = s.Unregister(task.Name)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// connectSchedulerTestDB connects to a fresh SQLite database with the framework tables
+func connectSchedulerTestDB(t *testing.T) DatabaseManager {
+	t.Helper()
+	dm := NewDatabaseManager()
+	if err := dm.Connect(createTestDBConfig(filepath.Join(t.TempDir(), "scheduler.db"))); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { dm.Close() })
+	if err := dm.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	return dm
+}
+
+func TestIntegration_SchedulerStoreLeaseAndRuns(t *testing.T) {
+	store := &databaseSchedulerStore{db: connectSchedulerTestDB(t)}
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if ok, err := store.acquire(ctx, "scheduler", "a", now, now.Add(30*time.Second)); err != nil || !ok {
+		t.Fatalf("Expected a to acquire the free lease, got %v, %v", ok, err)
+	}
+	if ok, _ := store.acquire(ctx, "scheduler", "b", now.Add(10*time.Second), now.Add(40*time.Second)); ok {
+		t.Error("Expected b not to acquire a lease held by a")
+	}
+	if ok, _ := store.acquire(ctx, "scheduler", "a", now.Add(10*time.Second), now.Add(40*time.Second)); !ok {
+		t.Error("Expected a to renew its lease")
+	}
+	// a stops renewing, e.g. because it crashed
+	if ok, _ := store.acquire(ctx, "scheduler", "b", now.Add(41*time.Second), now.Add(71*time.Second)); !ok {
+		t.Error("Expected b to take over the expired lease")
+	}
+	store.release(ctx, "scheduler", "a", now.Add(42*time.Second))
+	if ok, _ := store.acquire(ctx, "scheduler", "a", now.Add(42*time.Second), now.Add(72*time.Second)); ok {
+		t.Error("Expected a release by a former holder to be ignored")
+	}
+	store.release(ctx, "scheduler", "b", now.Add(43*time.Second))
+	if ok, _ := store.acquire(ctx, "scheduler", "a", now.Add(44*time.Second), now.Add(74*time.Second)); !ok {
+		t.Error("Expected a to acquire the released lease")
+	}
+
+	// Each activation is claimed once
+	at := now.Add(time.Minute)
+	run := &TaskRun{ID: generateUUIDv7(), Task: "reports", ScheduledAt: at, StartedAt: at, Status: TaskRunRunning, Instance: "a"}
+	if ok, err := store.claimRun(ctx, run); err != nil || !ok {
+		t.Fatalf("Expected the run to be claimed, got %v, %v", ok, err)
+	}
+	duplicate := &TaskRun{ID: generateUUIDv7(), Task: "reports", ScheduledAt: at, StartedAt: at, Status: TaskRunRunning, Instance: "b"}
+	if ok, err := store.claimRun(ctx, duplicate); err != nil || ok {
+		t.Errorf("Expected the activation to be claimed only once, got %v, %v", ok, err)
+	}
+	run.Status, run.Error, run.FinishedAt = TaskRunFailed, "disk full", at.Add(time.Second)
+	if err := store.finishRun(ctx, run); err != nil {
+		t.Fatalf("finishRun failed: %v", err)
+	}
+	later := &TaskRun{ID: generateUUIDv7(), Task: "reports", ScheduledAt: at.Add(time.Hour), StartedAt: at.Add(time.Hour), Status: TaskRunSucceeded, Instance: "a", FinishedAt: at.Add(time.Hour)}
+	store.claimRun(ctx, later)
+	store.finishRun(ctx, later)
+
+	history, err := store.history(ctx, "reports", 10)
+	if err != nil || len(history) != 2 {
+		t.Fatalf("Expected 2 runs, got %d: %v", len(history), err)
+	}
+	if history[1].Status != TaskRunFailed || history[1].Error != "disk full" || history[1].Instance != "a" || !history[1].ScheduledAt.Equal(at) {
+		t.Errorf("Unexpected run %+v", history[1])
+	}
+	if last, found, _ := store.lastRun(ctx, "reports"); !found || !last.Equal(at.Add(time.Hour)) {
+		t.Errorf("Expected the latest run, got %v", last)
+	}
+
+	// Cleanup keeps the latest run of each task for missed-run detection
+	if err := store.cleanup(ctx, now.Add(24*time.Hour)); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if history, _ := store.history(ctx, "reports", 10); len(history) != 1 || history[0].ID != later.ID {
+		t.Errorf("Expected only the latest run to remain, got %+v", history)
+	}
+}
+
+func TestIntegration_SchedulerLeaderElection(t *testing.T) {
+	dm := connectSchedulerTestDB(t)
+	start := time.Date(2026, 1, 1, 0, 0, 30, 0, time.UTC)
+	first, clock := newTestScheduler(t, dm, SchedulerConfig{InstanceID: "first"}, start, nil)
+	second, _ := newTestScheduler(t, dm, SchedulerConfig{InstanceID: "second"}, start, nil)
+	second.now = clock.Now
+
+	var runs atomic.Int32
+	for _, s := range []*scheduler{first, second} {
+		s.Schedule("reports", "@every 1m", func(ctx context.Context) error {
+			runs.Add(1)
+			return nil
+		})
+	}
+	first.Start()
+	waitFor(t, "first to lead", first.IsLeader)
+	second.Start()
+	waitForTasks(t, first)
+
+	clock.Advance(30 * time.Second)
+	waitFor(t, "first run", func() bool { return len(finishedRuns(first, "reports")) == 1 })
+	time.Sleep(20 * time.Millisecond)
+	if second.IsLeader() || runs.Load() != 1 {
+		t.Fatalf("Expected only the leader to run the task, got %d runs", runs.Load())
+	}
+
+	// Stopping the leader releases the lease to the other instance
+	first.Stop(context.Background())
+	clock.Advance(10 * time.Second)
+	waitFor(t, "second to lead", second.IsLeader)
+	clock.Advance(50 * time.Second)
+	waitFor(t, "second run", func() bool { return len(finishedRuns(second, "reports")) == 2 })
+
+	history := finishedRuns(second, "reports")
+	if history[0].Instance != "second" || history[1].Instance != "first" || runs.Load() != 2 {
+		t.Errorf("Unexpected history %+v, %+v", history[0], history[1])
+	}
+}
+
+func TestIntegration_SchedulerMissedRunsAfterRestart(t *testing.T) {
+	dm := connectSchedulerTestDB(t)
+	start := time.Date(2026, 1, 1, 0, 0, 30, 0, time.UTC)
+	handler := func(ctx context.Context) error { return nil }
+
+	s, clock := newTestScheduler(t, dm, SchedulerConfig{}, start, nil)
+	s.Schedule("reports", "@every 1m", handler)
+	s.Start()
+	waitForTasks(t, s)
+	clock.Advance(30 * time.Second)
+	waitFor(t, "first run", func() bool { return len(finishedRuns(s, "reports")) == 1 })
+	s.Stop(context.Background())
+
+	// Two runs were missed while no instance was running
+	restarted, _ := newTestScheduler(t, dm, SchedulerConfig{}, start.Add(195*time.Second), nil)
+	restarted.Schedule("reports", "@every 1m", handler)
+	restarted.Start()
+	waitFor(t, "catch-up run", func() bool { return len(finishedRuns(restarted, "reports")) == 2 })
+	time.Sleep(20 * time.Millisecond)
+
+	history := finishedRuns(restarted, "reports")
+	if len(history) != 2 || !history[0].ScheduledAt.Equal(start.Add(150*time.Second)) {
+		t.Errorf("Expected a single run for the latest missed activation, got %+v", history)
+	}
+}
+
+func TestIntegration_SchedulerLeaseHooksWaitForMigrations(t *testing.T) {
+	dm := NewDatabaseManager()
+	if err := dm.Connect(createTestDBConfig(filepath.Join(t.TempDir(), "scheduler.db"))); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { dm.Close() })
+
+	start := time.Date(2026, 1, 1, 0, 0, 30, 0, time.UTC)
+	s, clock := newTestScheduler(t, dm, SchedulerConfig{InstanceID: "first"}, start, nil)
+	var established atomic.Int32
+	s.onLeaseEstablished(func() { established.Add(1) })
+
+	// Without the scheduler tables the lease cannot be acquired
+	s.Start()
+	time.Sleep(20 * time.Millisecond)
+	if s.IsLeader() || established.Load() != 0 {
+		t.Fatal("Expected the lease hooks to wait while the scheduler tables are missing")
+	}
+
+	if err := dm.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	clock.Advance(10 * time.Second)
+	waitFor(t, "lease", s.IsLeader)
+	if established.Load() != 1 {
+		t.Errorf("Expected the lease hooks to run once, got %d", established.Load())
+	}
+
+	// Hooks added later run at once
+	s.onLeaseEstablished(func() { established.Add(1) })
+	if established.Load() != 2 {
+		t.Error("Expected a hook added after the lease was established to run at once")
+	}
+}
@@ -0,0 +1,214 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// schedulerStore persists the scheduler lease and the run history
+type schedulerStore interface {
+	// acquire takes or renews the lease name for holder and reports whether holder has it
+	acquire(ctx context.Context, name, holder string, now, until time.Time) (bool, error)
+	release(ctx context.Context, name, holder string, now time.Time) error
+	// lastRun returns the scheduled time of the latest run of task
+	lastRun(ctx context.Context, task string) (time.Time, bool, error)
+	// claimRun records run and reports false if its activation was already claimed
+	claimRun(ctx context.Context, run *TaskRun) (bool, error)
+	finishRun(ctx context.Context, run *TaskRun) error
+	history(ctx context.Context, task string, limit int) ([]*TaskRun, error)
+	cleanup(ctx context.Context, before time.Time) error
+}
+
+// inMemorySchedulerStore keeps the run history in memory. This instance always leads.
+type inMemorySchedulerStore struct {
+	mu   sync.Mutex
+	runs map[string][]*TaskRun // task -> runs ordered by scheduled time
+}
+
+func newInMemorySchedulerStore() *inMemorySchedulerStore {
+	return &inMemorySchedulerStore{runs: make(map[string][]*TaskRun)}
+}
+
+func (s *inMemorySchedulerStore) acquire(ctx context.Context, name, holder string, now, until time.Time) (bool, error) {
+	return true, nil
+}
+
+func (s *inMemorySchedulerStore) release(ctx context.Context, name, holder string, now time.Time) error {
+	return nil
+}
+
+func (s *inMemorySchedulerStore) lastRun(ctx context.Context, task string) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := s.runs[task]
+	if len(runs) == 0 {
+		return time.Time{}, false, nil
+	}
+	return runs[len(runs)-1].ScheduledAt, true, nil
+}
+
+func (s *inMemorySchedulerStore) claimRun(ctx context.Context, run *TaskRun) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.runs[run.Task] {
+		if existing.ScheduledAt.Equal(run.ScheduledAt) {
+			return false, nil
+		}
+	}
+	copied := *run
+	runs := append(s.runs[run.Task], &copied)
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ScheduledAt.Before(runs[j].ScheduledAt) })
+	s.runs[run.Task] = runs
+	return true, nil
+}
+
+func (s *inMemorySchedulerStore) finishRun(ctx context.Context, run *TaskRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.runs[run.Task] {
+		if existing.ID == run.ID {
+			existing.Status = run.Status
+			existing.Error = run.Error
+			existing.FinishedAt = run.FinishedAt
+		}
+	}
+	return nil
+}
+
+func (s *inMemorySchedulerStore) history(ctx context.Context, task string, limit int) ([]*TaskRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := s.runs[task]
+	history := make([]*TaskRun, 0, limit)
+	for i := len(runs) - 1; i >= 0 && len(history) < limit; i-- {
+		copied := *runs[i]
+		history = append(history, &copied)
+	}
+	return history, nil
+}
+
+func (s *inMemorySchedulerStore) cleanup(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for task, runs := range s.runs {
+		kept := runs[:0]
+		for i, run := range runs {
+			if i == len(runs)-1 || run.FinishedAt.IsZero() || !run.FinishedAt.Before(before) {
+				kept = append(kept, run)
+			}
+		}
+		s.runs[task] = kept
+	}
+	return nil
+}
+
+// databaseSchedulerStore keeps the lease in scheduler_leases and the run
+// history in scheduled_task_runs
+type databaseSchedulerStore struct {
+	db DatabaseManager
+}
+
+// query loads a named query
+func (s *databaseSchedulerStore) query(name string) (string, error) {
+	query, err := s.db.GetQuery(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to load %s query: %w", name, err)
+	}
+	return query, nil
+}
+
+// exec runs a named statement and returns the number of affected rows
+func (s *databaseSchedulerStore) exec(ctx context.Context, name string, args ...interface{}) (int64, error) {
+	query, err := s.query(name)
+	if err != nil {
+		return 0, err
+	}
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *databaseSchedulerStore) acquire(ctx context.Context, name, holder string, now, until time.Time) (bool, error) {
+	affected, err := s.exec(ctx, "acquire_scheduler_lease", holder, until, name, holder, now)
+	if err != nil || affected == 1 {
+		return affected == 1, err
+	}
+
+	// The lease is held by another instance or does not exist yet
+	affected, err = s.exec(ctx, "insert_scheduler_lease", name, holder, until)
+	return affected == 1, err
+}
+
+func (s *databaseSchedulerStore) release(ctx context.Context, name, holder string, now time.Time) error {
+	_, err := s.exec(ctx, "release_scheduler_lease", now, name, holder)
+	return err
+}
+
+func (s *databaseSchedulerStore) lastRun(ctx context.Context, task string) (time.Time, bool, error) {
+	query, err := s.query("load_last_scheduled_task_run")
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	var last time.Time
+	err = s.db.QueryRowContext(ctx, query, task).Scan(&last)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return last, true, nil
+}
+
+func (s *databaseSchedulerStore) claimRun(ctx context.Context, run *TaskRun) (bool, error) {
+	affected, err := s.exec(ctx, "insert_scheduled_task_run", run.ID, run.Task, run.ScheduledAt, run.Instance, run.StartedAt)
+	return affected == 1, err
+}
+
+func (s *databaseSchedulerStore) finishRun(ctx context.Context, run *TaskRun) error {
+	var runError interface{}
+	if run.Error != "" {
+		runError = run.Error
+	}
+	_, err := s.exec(ctx, "finish_scheduled_task_run", run.Status, runError, run.FinishedAt, run.ID)
+	return err
+}
+
+func (s *databaseSchedulerStore) history(ctx context.Context, task string, limit int) ([]*TaskRun, error) {
+	query, err := s.query("load_scheduled_task_runs")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, query, task, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []*TaskRun
+	for rows.Next() {
+		var (
+			run        TaskRun
+			runError   sql.NullString
+			finishedAt sql.NullTime
+		)
+		if err := rows.Scan(&run.ID, &run.Task, &run.ScheduledAt, &run.Instance, &run.Status, &runError, &run.StartedAt, &finishedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan task run: %w", err)
+		}
+		run.Error = runError.String
+		run.FinishedAt = finishedAt.Time
+		runs = append(runs, &run)
+	}
+	return runs, rows.Err()
+}
+
+func (s *databaseSchedulerStore) cleanup(ctx context.Context, before time.Time) error {
+	_, err := s.exec(ctx, "cleanup_scheduled_task_runs", before)
+	return err
+}
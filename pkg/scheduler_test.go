@@ -0,0 +1,257 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestScheduler creates a scheduler on db whose clock starts at start and
+// is controlled by the returned clock
+func newTestScheduler(t *testing.T, db DatabaseManager, config SchedulerConfig, start time.Time, metrics MetricsCollector) (*scheduler, *testClock) {
+	t.Helper()
+	if config.TickInterval == 0 {
+		config.TickInterval = 5 * time.Millisecond
+	}
+	s, err := NewScheduler(db, config, metrics)
+	if err != nil {
+		t.Fatalf("NewScheduler failed: %v", err)
+	}
+	sched := s.(*scheduler)
+	clock := &testClock{now: start}
+	sched.now = clock.Now
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		sched.Stop(ctx)
+	})
+	return sched, clock
+}
+
+// waitForTasks waits until the leader has computed the next run of all tasks
+func waitForTasks(t *testing.T, s *scheduler) {
+	t.Helper()
+	waitFor(t, "tasks to be initialized", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, task := range s.tasks {
+			if !task.initialized {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// finishedRuns returns the finished runs of task, newest first
+func finishedRuns(s Scheduler, task string) []*TaskRun {
+	runs, _ := s.History(task, 1000)
+	finished := runs[:0]
+	for _, run := range runs {
+		if run.Status != TaskRunRunning {
+			finished = append(finished, run)
+		}
+	}
+	return finished
+}
+
+func TestScheduler_RunsDueTasks(t *testing.T) {
+	metrics := NewTrackingMetricsCollector()
+	start := time.Date(2026, 1, 1, 0, 0, 30, 0, time.UTC)
+	s, clock := newTestScheduler(t, NewNoopDatabaseManager(), SchedulerConfig{}, start, metrics)
+
+	var runs atomic.Int32
+	if err := s.Schedule("reports.daily", "@every 1m", func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	}); err != nil {
+		t.Fatalf("Schedule failed: %v", err)
+	}
+	if err := s.Schedule("reports.daily", "@hourly", func(ctx context.Context) error { return nil }); err == nil {
+		t.Error("Expected registering a task twice to fail")
+	}
+
+	s.Start()
+	waitForTasks(t, s)
+	if !s.IsLeader() {
+		t.Fatal("Expected a scheduler without database to lead")
+	}
+	tasks := s.Tasks()
+	if len(tasks) != 1 || !tasks[0].NextRun.Equal(start.Add(30*time.Second)) {
+		t.Fatalf("Unexpected tasks %+v", tasks)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if runs.Load() != 0 {
+		t.Fatal("Expected a new task to wait for its first scheduled time")
+	}
+
+	clock.Advance(30 * time.Second)
+	waitFor(t, "first run", func() bool { return len(finishedRuns(s, "reports.daily")) == 1 })
+	clock.Advance(time.Minute)
+	waitFor(t, "second run", func() bool { return len(finishedRuns(s, "reports.daily")) == 2 })
+
+	history := finishedRuns(s, "reports.daily")
+	if !history[0].ScheduledAt.Equal(start.Add(90*time.Second)) || history[0].Status != TaskRunSucceeded || history[0].Instance != s.config.InstanceID {
+		t.Errorf("Unexpected history %+v", history[0])
+	}
+	if runs.Load() != 2 || countCalls(metrics, "scheduler.runs") != 2 {
+		t.Errorf("Expected 2 runs, got %d and metrics %+v", runs.Load(), metrics.GetCounterCalls())
+	}
+
+	if err := s.Unregister("reports.daily"); err != nil {
+		t.Fatalf("Unregister failed: %v", err)
+	}
+	clock.Advance(time.Minute)
+	time.Sleep(20 * time.Millisecond)
+	if runs.Load() != 2 {
+		t.Error("Expected an unregistered task not to run")
+	}
+}
+
+func TestScheduler_RegisterValidation(t *testing.T) {
+	s, _ := newTestScheduler(t, NewNoopDatabaseManager(), SchedulerConfig{}, time.Now(), nil)
+	handler := func(ctx context.Context) error { return nil }
+
+	for _, task := range []ScheduledTask{
+		{Schedule: "@daily", Handler: handler},
+		{Name: "a", Schedule: "@daily"},
+		{Name: "a", Schedule: "every day", Handler: handler},
+		{Name: "a", Schedule: "@daily", Handler: handler, TimeZone: "Nowhere/Zone"},
+		{Name: "a", Schedule: "@daily", Handler: handler, MissedRuns: "sometimes"},
+	} {
+		if err := s.Register(task); err == nil {
+			t.Errorf("Expected %+v to be rejected", task)
+		}
+	}
+	if err := s.Unregister("missing"); err == nil {
+		t.Error("Expected unregistering an unknown task to fail")
+	}
+	if _, err := NewScheduler(NewNoopDatabaseManager(), SchedulerConfig{TimeZone: "Nowhere/Zone"}, nil); err == nil {
+		t.Error("Expected an invalid time zone to be rejected")
+	}
+}
+
+func TestScheduler_MissedRunPolicies(t *testing.T) {
+	metrics := NewTrackingMetricsCollector()
+	start := time.Date(2026, 1, 1, 0, 0, 30, 0, time.UTC)
+	s, clock := newTestScheduler(t, NewNoopDatabaseManager(), SchedulerConfig{MissedRunThreshold: time.Second}, start, metrics)
+
+	counters := map[MissedRunPolicy]*atomic.Int32{}
+	for _, policy := range []MissedRunPolicy{MissedRunOnce, MissedRunSkip, MissedRunAll} {
+		counter := &atomic.Int32{}
+		counters[policy] = counter
+		s.Register(ScheduledTask{Name: string(policy), Schedule: "@every 1m", MissedRuns: policy, Handler: func(ctx context.Context) error {
+			counter.Add(1)
+			return nil
+		}})
+	}
+	s.Start()
+	waitForTasks(t, s)
+
+	// Five runs were due while the clock jumped; the latest is 30s late
+	clock.Advance(5 * time.Minute)
+	waitFor(t, "catch-up runs", func() bool {
+		return len(finishedRuns(s, "all")) == 5 && len(finishedRuns(s, "once")) == 1
+	})
+	time.Sleep(20 * time.Millisecond)
+
+	if counters[MissedRunSkip].Load() != 0 || counters[MissedRunOnce].Load() != 1 || counters[MissedRunAll].Load() != 5 {
+		t.Errorf("Unexpected runs: skip=%d once=%d all=%d", counters[MissedRunSkip].Load(), counters[MissedRunOnce].Load(), counters[MissedRunAll].Load())
+	}
+	if once := finishedRuns(s, "once"); !once[0].ScheduledAt.Equal(start.Add(270 * time.Second)) {
+		t.Errorf("Expected the latest missed run, got %v", once[0].ScheduledAt)
+	}
+
+	// Runs that start on time are not affected by the policy
+	clock.Advance(time.Minute - 29*time.Second)
+	waitFor(t, "on-time run", func() bool { return counters[MissedRunSkip].Load() == 1 })
+}
+
+func TestScheduler_FailuresTimeoutsAndStop(t *testing.T) {
+	metrics := NewTrackingMetricsCollector()
+	start := time.Date(2026, 1, 1, 0, 0, 30, 0, time.UTC)
+	s, clock := newTestScheduler(t, NewNoopDatabaseManager(), SchedulerConfig{}, start, metrics)
+
+	release := make(chan struct{})
+	var slowRuns atomic.Int32
+	s.Schedule("failing", "@every 1m", func(ctx context.Context) error { return errors.New("disk full") })
+	s.Schedule("panicking", "@every 1m", func(ctx context.Context) error { panic("boom") })
+	s.Register(ScheduledTask{Name: "timeout", Schedule: "@every 1m", Timeout: 10 * time.Millisecond, Handler: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	s.Schedule("slow", "@every 1m", func(ctx context.Context) error {
+		slowRuns.Add(1)
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return ctx.Err()
+	})
+	s.Start()
+	waitForTasks(t, s)
+
+	clock.Advance(30 * time.Second)
+	waitFor(t, "runs to finish", func() bool {
+		return len(finishedRuns(s, "failing")) == 1 && len(finishedRuns(s, "panicking")) == 1 && len(finishedRuns(s, "timeout")) == 1
+	})
+	for name, message := range map[string]string{"failing": "disk full", "panicking": "panicked: boom", "timeout": "deadline exceeded"} {
+		run := finishedRuns(s, name)[0]
+		if run.Status != TaskRunFailed || !strings.Contains(run.Error, message) {
+			t.Errorf("Expected %s to fail with %q, got %+v", name, message, run)
+		}
+	}
+
+	// A task still running is not started again
+	clock.Advance(time.Minute)
+	time.Sleep(20 * time.Millisecond)
+	if slowRuns.Load() != 1 {
+		t.Errorf("Expected runs of a task not to overlap, got %d", slowRuns.Load())
+	}
+
+	// Stop cancels tasks still running when its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Stop(ctx); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	waitFor(t, "slow run to be canceled", func() bool { return len(finishedRuns(s, "slow")) == 1 })
+	if s.IsLeader() {
+		t.Error("Expected a stopped scheduler not to lead")
+	}
+	close(release)
+}
+
+func TestScheduler_PluginTasks(t *testing.T) {
+	s, _ := newTestScheduler(t, NewNoopDatabaseManager(), SchedulerConfig{}, time.Now(), nil)
+	ctx := &pluginContextImpl{pluginName: "reports", scheduler: s}
+	handler := func(ctx context.Context) error { return nil }
+	if _, ok := PluginContext(ctx).(PluginScheduler); !ok {
+		t.Fatal("Expected plugin contexts to implement PluginScheduler")
+	}
+
+	if err := ctx.ScheduleTask("nightly", "@daily", handler); err != nil {
+		t.Fatalf("ScheduleTask failed: %v", err)
+	}
+	ctx.ScheduleTask("weekly", "@weekly", handler)
+	s.Schedule("reportsbackup", "@daily", handler)
+	if tasks := s.Tasks(); len(tasks) != 3 || tasks[0].Name != "reports.nightly" {
+		t.Fatalf("Expected tasks to be namespaced by plugin, got %+v", tasks)
+	}
+	if err := ctx.UnscheduleTask("weekly"); err != nil {
+		t.Fatalf("UnscheduleTask failed: %v", err)
+	}
+
+	// Disabling the plugin removes its remaining tasks only
+	unregisterTasksWithPrefix(s, "reports.")
+	if tasks := s.Tasks(); len(tasks) != 1 || tasks[0].Name != "reportsbackup" {
+		t.Errorf("Unexpected tasks %+v", tasks)
+	}
+
+	if err := (&pluginContextImpl{pluginName: "reports"}).ScheduleTask("nightly", "@daily", handler); err == nil {
+		t.Error("Expected scheduling without scheduler to fail")
+	}
+}
@@ -0,0 +1,576 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Common Content-Security-Policy source expressions
+const (
+	CSPSelf           = "'self'"
+	CSPNone           = "'none'"
+	CSPUnsafeInline   = "'unsafe-inline'"
+	CSPUnsafeEval     = "'unsafe-eval'"
+	CSPStrictDynamic  = "'strict-dynamic'"
+	CSPReportSample   = "'report-sample'"
+	CSPWasmUnsafeEval = "'wasm-unsafe-eval'"
+
+	// CSPNonce is replaced with 'nonce-<value>' using the per-request nonce
+	CSPNonce = "'nonce'"
+)
+
+// CSPNonceContextKey is the context key holding the per-request CSP nonce
+const CSPNonceContextKey = "csp_nonce"
+
+// cspNonceBytes is the amount of randomness in a nonce (128 bits)
+const cspNonceBytes = 16
+
+// maxCSPReportSize limits the size of accepted violation reports
+const maxCSPReportSize = 64 * 1024
+
+// cspDirectives are the directive names violation metrics are tagged with.
+// Reported directives are client input, so anything else is tagged "other".
+var cspDirectives = map[string]bool{
+	"default-src": true, "child-src": true, "connect-src": true, "fenced-frame-src": true,
+	"font-src": true, "frame-src": true, "img-src": true, "manifest-src": true,
+	"media-src": true, "object-src": true, "prefetch-src": true, "script-src": true,
+	"script-src-elem": true, "script-src-attr": true, "style-src": true,
+	"style-src-elem": true, "style-src-attr": true, "worker-src": true,
+	"base-uri": true, "sandbox": true, "form-action": true, "frame-ancestors": true,
+	"navigate-to": true, "require-trusted-types-for": true, "trusted-types": true,
+	"upgrade-insecure-requests": true, "block-all-mixed-content": true,
+	"webrtc": true, "report-uri": true, "report-to": true,
+}
+
+// Default values used when no header policy is configured
+const (
+	defaultReferrerPolicy    = "strict-origin-when-cross-origin"
+	defaultPermissionsPolicy = "geolocation=(), microphone=(), camera=()"
+)
+
+// SecurityHeadersConfig configures response headers that restrict what a page
+// may load and how it may be embedded. It is shared by the framework-wide
+// SecurityConfig and the per-host ServerSecurityConfig; non-empty host values
+// override the framework-wide ones.
+type SecurityHeadersConfig struct {
+	// ContentSecurityPolicy is sent as Content-Security-Policy, or as
+	// Content-Security-Policy-Report-Only when the policy is report-only.
+	// It replaces the fixed "default-src 'self'" policy of EnableXSSProtect.
+	// Default: nil
+	ContentSecurityPolicy *CSPPolicy
+
+	// PermissionsPolicy is the Permissions-Policy header value.
+	// Default: "geolocation=(), microphone=(), camera=()"
+	PermissionsPolicy string
+
+	// ReferrerPolicy is the Referrer-Policy header value.
+	// Default: "strict-origin-when-cross-origin"
+	ReferrerPolicy string
+
+	// CrossOriginOpenerPolicy is the Cross-Origin-Opener-Policy header value,
+	// e.g. "same-origin". Default: "" (not sent)
+	CrossOriginOpenerPolicy string
+
+	// CrossOriginEmbedderPolicy is the Cross-Origin-Embedder-Policy header value,
+	// e.g. "require-corp". Default: "" (not sent)
+	CrossOriginEmbedderPolicy string
+
+	// CrossOriginResourcePolicy is the Cross-Origin-Resource-Policy header value,
+	// e.g. "same-site". Default: "" (not sent)
+	CrossOriginResourcePolicy string
+}
+
+// merge returns c with non-empty values of override applied
+func (c SecurityHeadersConfig) merge(override SecurityHeadersConfig) SecurityHeadersConfig {
+	if override.ContentSecurityPolicy != nil {
+		c.ContentSecurityPolicy = override.ContentSecurityPolicy
+	}
+	if override.PermissionsPolicy != "" {
+		c.PermissionsPolicy = override.PermissionsPolicy
+	}
+	if override.ReferrerPolicy != "" {
+		c.ReferrerPolicy = override.ReferrerPolicy
+	}
+	if override.CrossOriginOpenerPolicy != "" {
+		c.CrossOriginOpenerPolicy = override.CrossOriginOpenerPolicy
+	}
+	if override.CrossOriginEmbedderPolicy != "" {
+		c.CrossOriginEmbedderPolicy = override.CrossOriginEmbedderPolicy
+	}
+	if override.CrossOriginResourcePolicy != "" {
+		c.CrossOriginResourcePolicy = override.CrossOriginResourcePolicy
+	}
+	return c
+}
+
+// apply sets the configured headers on the response
+func (c SecurityHeadersConfig) apply(ctx Context) error {
+	referrerPolicy := c.ReferrerPolicy
+	if referrerPolicy == "" {
+		referrerPolicy = defaultReferrerPolicy
+	}
+	ctx.SetHeader("Referrer-Policy", referrerPolicy)
+
+	permissionsPolicy := c.PermissionsPolicy
+	if permissionsPolicy == "" {
+		permissionsPolicy = defaultPermissionsPolicy
+	}
+	ctx.SetHeader("Permissions-Policy", permissionsPolicy)
+
+	if c.CrossOriginOpenerPolicy != "" {
+		ctx.SetHeader("Cross-Origin-Opener-Policy", c.CrossOriginOpenerPolicy)
+	}
+	if c.CrossOriginEmbedderPolicy != "" {
+		ctx.SetHeader("Cross-Origin-Embedder-Policy", c.CrossOriginEmbedderPolicy)
+	}
+	if c.CrossOriginResourcePolicy != "" {
+		ctx.SetHeader("Cross-Origin-Resource-Policy", c.CrossOriginResourcePolicy)
+	}
+
+	if c.ContentSecurityPolicy != nil {
+		return c.ContentSecurityPolicy.Apply(ctx)
+	}
+	return nil
+}
+
+// CSPPolicy builds a Content-Security-Policy header. Directives keep the order
+// in which they were first added. Sources equal to CSPNonce are replaced with
+// the per-request nonce when the header is written.
+type CSPPolicy struct {
+	directives []string
+	sources    map[string][]string
+	reportOnly bool
+	endpoints  map[string]string // Reporting-Endpoints group -> URL
+}
+
+// NewCSPPolicy creates an empty policy
+func NewCSPPolicy() *CSPPolicy {
+	return &CSPPolicy{
+		sources:   make(map[string][]string),
+		endpoints: make(map[string]string),
+	}
+}
+
+// StrictCSPPolicy returns a nonce-based policy suitable for most server-rendered
+// applications: scripts must carry the request nonce, plugins and base-uri
+// hijacking are blocked and the page may only be framed by itself.
+func StrictCSPPolicy() *CSPPolicy {
+	return NewCSPPolicy().
+		DefaultSrc(CSPSelf).
+		ScriptSrc(CSPNonce, CSPStrictDynamic).
+		StyleSrc(CSPSelf, CSPNonce).
+		ObjectSrc(CSPNone).
+		BaseURI(CSPSelf).
+		FrameAncestors(CSPSelf)
+}
+
+// Directive appends sources to a directive. A directive without sources, such
+// as upgrade-insecure-requests, is written on its own.
+func (p *CSPPolicy) Directive(name string, sources ...string) *CSPPolicy {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if _, exists := p.sources[name]; !exists {
+		p.directives = append(p.directives, name)
+		p.sources[name] = nil
+	}
+	for _, source := range sources {
+		if !contains(p.sources[name], source) {
+			p.sources[name] = append(p.sources[name], source)
+		}
+	}
+	return p
+}
+
+// DefaultSrc appends sources to default-src
+func (p *CSPPolicy) DefaultSrc(sources ...string) *CSPPolicy {
+	return p.Directive("default-src", sources...)
+}
+
+// ScriptSrc appends sources to script-src
+func (p *CSPPolicy) ScriptSrc(sources ...string) *CSPPolicy {
+	return p.Directive("script-src", sources...)
+}
+
+// StyleSrc appends sources to style-src
+func (p *CSPPolicy) StyleSrc(sources ...string) *CSPPolicy {
+	return p.Directive("style-src", sources...)
+}
+
+// ImgSrc appends sources to img-src
+func (p *CSPPolicy) ImgSrc(sources ...string) *CSPPolicy {
+	return p.Directive("img-src", sources...)
+}
+
+// ConnectSrc appends sources to connect-src
+func (p *CSPPolicy) ConnectSrc(sources ...string) *CSPPolicy {
+	return p.Directive("connect-src", sources...)
+}
+
+// FontSrc appends sources to font-src
+func (p *CSPPolicy) FontSrc(sources ...string) *CSPPolicy {
+	return p.Directive("font-src", sources...)
+}
+
+// FrameSrc appends sources to frame-src
+func (p *CSPPolicy) FrameSrc(sources ...string) *CSPPolicy {
+	return p.Directive("frame-src", sources...)
+}
+
+// ObjectSrc appends sources to object-src
+func (p *CSPPolicy) ObjectSrc(sources ...string) *CSPPolicy {
+	return p.Directive("object-src", sources...)
+}
+
+// BaseURI appends sources to base-uri
+func (p *CSPPolicy) BaseURI(sources ...string) *CSPPolicy {
+	return p.Directive("base-uri", sources...)
+}
+
+// FormAction appends sources to form-action
+func (p *CSPPolicy) FormAction(sources ...string) *CSPPolicy {
+	return p.Directive("form-action", sources...)
+}
+
+// FrameAncestors appends sources to frame-ancestors
+func (p *CSPPolicy) FrameAncestors(sources ...string) *CSPPolicy {
+	return p.Directive("frame-ancestors", sources...)
+}
+
+// UpgradeInsecureRequests adds the upgrade-insecure-requests directive
+func (p *CSPPolicy) UpgradeInsecureRequests() *CSPPolicy {
+	return p.Directive("upgrade-insecure-requests")
+}
+
+// ReportURI sends violation reports to uri using the legacy report-uri directive
+func (p *CSPPolicy) ReportURI(uri string) *CSPPolicy {
+	return p.Directive("report-uri", uri)
+}
+
+// ReportTo sends violation reports to the Reporting API endpoint group. The
+// group is announced with a Reporting-Endpoints header pointing at url.
+func (p *CSPPolicy) ReportTo(group, url string) *CSPPolicy {
+	p.endpoints[group] = url
+	return p.Directive("report-to", group)
+}
+
+// ReportOnly switches the policy between enforcing and report-only mode
+func (p *CSPPolicy) ReportOnly(reportOnly bool) *CSPPolicy {
+	p.reportOnly = reportOnly
+	return p
+}
+
+// IsReportOnly reports whether the policy is sent in report-only mode
+func (p *CSPPolicy) IsReportOnly() bool {
+	return p.reportOnly
+}
+
+// UsesNonce reports whether any directive contains CSPNonce
+func (p *CSPPolicy) UsesNonce() bool {
+	for _, name := range p.directives {
+		if contains(p.sources[name], CSPNonce) {
+			return true
+		}
+	}
+	return false
+}
+
+// HeaderName returns the header the policy is sent in
+func (p *CSPPolicy) HeaderName() string {
+	if p.reportOnly {
+		return "Content-Security-Policy-Report-Only"
+	}
+	return "Content-Security-Policy"
+}
+
+// Build renders the policy, replacing CSPNonce sources with nonce. When nonce
+// is empty, nonce sources are omitted.
+func (p *CSPPolicy) Build(nonce string) string {
+	parts := make([]string, 0, len(p.directives))
+	for _, name := range p.directives {
+		values := []string{name}
+		for _, source := range p.sources[name] {
+			if source == CSPNonce {
+				if nonce == "" {
+					continue
+				}
+				source = "'nonce-" + nonce + "'"
+			}
+			values = append(values, source)
+		}
+		parts = append(parts, strings.Join(values, " "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Apply writes the policy for the current request. If the policy uses nonces,
+// the request nonce is generated (or reused) and stored under CSPNonceContextKey.
+func (p *CSPPolicy) Apply(ctx Context) error {
+	nonce := ""
+	if p.UsesNonce() {
+		var err error
+		if nonce, err = GenerateCSPNonce(ctx); err != nil {
+			return err
+		}
+	}
+
+	ctx.SetHeader(p.HeaderName(), p.Build(nonce))
+
+	if len(p.endpoints) > 0 {
+		groups := make([]string, 0, len(p.endpoints))
+		for group := range p.endpoints {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+
+		endpoints := make([]string, len(groups))
+		for i, group := range groups {
+			endpoints[i] = fmt.Sprintf("%s=%q", group, p.endpoints[group])
+		}
+		ctx.SetHeader("Reporting-Endpoints", strings.Join(endpoints, ", "))
+	}
+	return nil
+}
+
+// GenerateCSPNonce returns the CSP nonce of the current request, creating it on
+// first use. The same nonce is returned for the rest of the request so that the
+// header and every rendered tag agree.
+func GenerateCSPNonce(ctx Context) (string, error) {
+	if nonce := CSPNonceFromContext(ctx); nonce != "" {
+		return nonce, nil
+	}
+
+	buf := make([]byte, cspNonceBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate CSP nonce: %w", err)
+	}
+
+	nonce := base64.RawURLEncoding.EncodeToString(buf)
+	ctx.Set(CSPNonceContextKey, nonce)
+	return nonce, nil
+}
+
+// CSPNonceFromContext returns the CSP nonce of the current request, or an empty
+// string if none was generated
+func CSPNonceFromContext(ctx Context) string {
+	value, ok := ctx.Get(CSPNonceContextKey)
+	if !ok {
+		return ""
+	}
+	nonce, _ := value.(string)
+	return nonce
+}
+
+// RegisterCSPTemplateFuncs registers the cspNonce template function, which
+// returns the request nonce for use in nonce attributes:
+//
+//	<script nonce="{{ cspNonce .Ctx }}">...</script>
+func RegisterCSPTemplateFuncs(tm TemplateManager) error {
+	return tm.AddFunc("cspNonce", GenerateCSPNonce)
+}
+
+// SecurityHeadersMiddleware sets the security headers of the security manager
+// on every response, applying the header overrides of a host. hostConfig may
+// be nil, in which case only the framework-wide headers are used.
+func SecurityHeadersMiddleware(security SecurityManager, hostConfig *ServerSecurityConfig) MiddlewareFunc {
+	return func(ctx Context, next HandlerFunc) error {
+		var err error
+		if impl, ok := security.(*securityManagerImpl); ok && hostConfig != nil {
+			err = impl.setSecurityHeaders(ctx, hostConfig.SecurityHeadersConfig)
+		} else {
+			err = security.SetSecurityHeaders(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		return next(ctx)
+	}
+}
+
+// CSPViolation is a single Content-Security-Policy violation report
+type CSPViolation struct {
+	DocumentURI        string `json:"document-uri"`
+	Referrer           string `json:"referrer"`
+	BlockedURI         string `json:"blocked-uri"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	OriginalPolicy     string `json:"original-policy"`
+	Disposition        string `json:"disposition"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+	ColumnNumber       int    `json:"column-number"`
+	StatusCode         int    `json:"status-code"`
+	ScriptSample       string `json:"script-sample"`
+}
+
+// reportingAPIViolation is the body of a Reporting API csp-violation report,
+// which uses camelCase member names
+type reportingAPIViolation struct {
+	DocumentURL        string `json:"documentURL"`
+	Referrer           string `json:"referrer"`
+	BlockedURL         string `json:"blockedURL"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	OriginalPolicy     string `json:"originalPolicy"`
+	Disposition        string `json:"disposition"`
+	SourceFile         string `json:"sourceFile"`
+	LineNumber         int    `json:"lineNumber"`
+	ColumnNumber       int    `json:"columnNumber"`
+	StatusCode         int    `json:"statusCode"`
+	Sample             string `json:"sample"`
+}
+
+// ParseCSPReports decodes violation reports in either the legacy
+// application/csp-report format or the Reporting API application/reports+json
+// format. Reports of other types are ignored.
+func ParseCSPReports(body []byte) ([]CSPViolation, error) {
+	trimmed := strings.TrimSpace(string(body))
+
+	if strings.HasPrefix(trimmed, "[") {
+		var reports []struct {
+			Type string                `json:"type"`
+			Body reportingAPIViolation `json:"body"`
+		}
+		if err := json.Unmarshal(body, &reports); err != nil {
+			return nil, err
+		}
+
+		violations := make([]CSPViolation, 0, len(reports))
+		for _, report := range reports {
+			if report.Type != "csp-violation" {
+				continue
+			}
+			b := report.Body
+			violations = append(violations, CSPViolation{
+				DocumentURI:        b.DocumentURL,
+				Referrer:           b.Referrer,
+				BlockedURI:         b.BlockedURL,
+				ViolatedDirective:  b.EffectiveDirective,
+				EffectiveDirective: b.EffectiveDirective,
+				OriginalPolicy:     b.OriginalPolicy,
+				Disposition:        b.Disposition,
+				SourceFile:         b.SourceFile,
+				LineNumber:         b.LineNumber,
+				ColumnNumber:       b.ColumnNumber,
+				StatusCode:         b.StatusCode,
+				ScriptSample:       b.Sample,
+			})
+		}
+		return violations, nil
+	}
+
+	var legacy struct {
+		Report *CSPViolation `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return nil, err
+	}
+	if legacy.Report == nil {
+		return nil, fmt.Errorf("missing csp-report member")
+	}
+	return []CSPViolation{*legacy.Report}, nil
+}
+
+// normalizeCSPDirective returns the known directive name a report refers to,
+// or "other". Older browsers report the violated directive with its sources.
+func normalizeCSPDirective(directive string) string {
+	fields := strings.Fields(strings.ToLower(directive))
+	if len(fields) > 0 && cspDirectives[fields[0]] {
+		return fields[0]
+	}
+	return "other"
+}
+
+// normalizeCSPDisposition returns "enforce" or "report", or "other"
+func normalizeCSPDisposition(disposition string) string {
+	switch strings.ToLower(strings.TrimSpace(disposition)) {
+	case "", "enforce":
+		return "enforce"
+	case "report":
+		return "report"
+	default:
+		return "other"
+	}
+}
+
+// readCSPReport returns the report body, rejecting reports larger than
+// maxCSPReportSize. A streamed body is not read past the limit.
+func readCSPReport(ctx Context) ([]byte, error) {
+	req := ctx.Request()
+	if req == nil {
+		return nil, NewBogusDataError("invalid CSP violation report")
+	}
+
+	if length, err := strconv.ParseInt(req.Header.Get("Content-Length"), 10, 64); err == nil && length > maxCSPReportSize {
+		return nil, NewRequestTooLargeError(maxCSPReportSize)
+	}
+
+	body := req.RawBody
+	if req.Body != nil && len(body) == 0 {
+		data, err := io.ReadAll(io.LimitReader(req.Body, maxCSPReportSize+1))
+		if err != nil {
+			return nil, NewBogusDataError("invalid CSP violation report")
+		}
+		body = data
+	}
+
+	if len(body) > maxCSPReportSize {
+		return nil, NewRequestTooLargeError(maxCSPReportSize)
+	}
+	return body, nil
+}
+
+// CSPReportHandler returns a handler for a policy's report-uri or report-to
+// endpoint. Every violation is logged as a warning through the context logger
+// and counted in the security.csp.violations metric, tagged by directive and
+// disposition. Tags are limited to known directive names; other values are
+// tagged "other". Reports larger than 64 KB are rejected with 413. The
+// handler responds with 204 No Content.
+func CSPReportHandler() HandlerFunc {
+	return func(ctx Context) error {
+		body, err := readCSPReport(ctx)
+		if err != nil {
+			return err
+		}
+
+		violations, err := ParseCSPReports(body)
+		if err != nil {
+			return NewBogusDataError("invalid CSP violation report")
+		}
+
+		logger := ctx.Logger()
+		metrics := ctx.Metrics()
+		for _, v := range violations {
+			directive := v.EffectiveDirective
+			if directive == "" {
+				directive = v.ViolatedDirective
+			}
+			directive = normalizeCSPDirective(directive)
+			disposition := normalizeCSPDisposition(v.Disposition)
+
+			if logger != nil {
+				logger.Warn("CSP violation",
+					"directive", directive,
+					"blocked_uri", v.BlockedURI,
+					"document_uri", v.DocumentURI,
+					"source_file", v.SourceFile,
+					"line", v.LineNumber,
+					"disposition", disposition,
+				)
+			}
+			if metrics != nil {
+				_ = metrics.IncrementCounter("security.csp.violations", map[string]string{
+					"directive":   directive,
+					"disposition": disposition,
+				})
+			}
+		}
+
+		ctx.Response().WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
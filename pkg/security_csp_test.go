@@ -0,0 +1,235 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// recordingLogger records warnings for assertions
+type recordingLogger struct {
+	warnings []string
+}
+
+func (l *recordingLogger) Debug(msg string, fields ...interface{}) {}
+func (l *recordingLogger) Info(msg string, fields ...interface{})  {}
+func (l *recordingLogger) Warn(msg string, fields ...interface{}) {
+	l.warnings = append(l.warnings, msg)
+}
+func (l *recordingLogger) Error(msg string, fields ...interface{}) {}
+func (l *recordingLogger) WithRequestID(requestID string) Logger   { return l }
+
+func TestCSPPolicy_Build(t *testing.T) {
+	policy := NewCSPPolicy().
+		DefaultSrc(CSPSelf).
+		ScriptSrc(CSPSelf, CSPNonce).
+		ScriptSrc(CSPSelf).
+		UpgradeInsecureRequests().
+		ReportURI("/csp-report")
+
+	expected := "default-src 'self'; script-src 'self' 'nonce-abc'; upgrade-insecure-requests; report-uri /csp-report"
+	if got := policy.Build("abc"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	if got := policy.Build(""); strings.Contains(got, "nonce") {
+		t.Errorf("Expected nonce source to be omitted without a nonce, got %q", got)
+	}
+
+	if policy.HeaderName() != "Content-Security-Policy" {
+		t.Errorf("Unexpected header name: %s", policy.HeaderName())
+	}
+	if policy.ReportOnly(true).HeaderName() != "Content-Security-Policy-Report-Only" {
+		t.Errorf("Unexpected report-only header name: %s", policy.HeaderName())
+	}
+}
+
+func TestCSPPolicy_NonceSharedWithTemplates(t *testing.T) {
+	ctx := createTestContext(t, &Request{Header: http.Header{}})
+
+	policy := StrictCSPPolicy().ReportTo("csp", "https://example.com/csp")
+	if err := policy.Apply(ctx); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	nonce := CSPNonceFromContext(ctx)
+	if len(nonce) < 22 {
+		t.Fatalf("Expected a 128-bit nonce, got %q", nonce)
+	}
+
+	headers := ctx.Response().(*testResponseWriter).headers
+	if !strings.Contains(headers.Get("Content-Security-Policy"), "'nonce-"+nonce+"'") {
+		t.Errorf("Expected header to contain the request nonce, got %q", headers.Get("Content-Security-Policy"))
+	}
+	if headers.Get("Reporting-Endpoints") != `csp="https://example.com/csp"` {
+		t.Errorf("Unexpected Reporting-Endpoints header: %q", headers.Get("Reporting-Endpoints"))
+	}
+
+	tm := NewTemplateManager()
+	if err := RegisterCSPTemplateFuncs(tm); err != nil {
+		t.Fatalf("RegisterCSPTemplateFuncs failed: %v", err)
+	}
+	if err := tm.LoadTemplate("page", `<script nonce="{{ cspNonce .Ctx }}"></script>`); err != nil {
+		t.Fatalf("LoadTemplate failed: %v", err)
+	}
+	out, err := tm.Render("page", map[string]interface{}{"Ctx": ctx})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if !strings.Contains(out, `nonce="`+nonce+`"`) {
+		t.Errorf("Expected template to render the header nonce, got %q", out)
+	}
+
+	// A new request gets a new nonce
+	other := createTestContext(t, &Request{Header: http.Header{}})
+	if n, _ := GenerateCSPNonce(other); n == nonce {
+		t.Error("Expected nonces to differ between requests")
+	}
+}
+
+func TestSecurityHeadersMiddleware_HostOverrides(t *testing.T) {
+	config := DefaultSecurityConfig()
+	config.EncryptionKey = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	config.JWTSecret = "test-secret"
+	config.ContentSecurityPolicy = NewCSPPolicy().DefaultSrc(CSPSelf)
+	config.CrossOriginOpenerPolicy = "same-origin"
+
+	sm, err := NewSecurityManager(NewNoopDatabaseManager(), config)
+	if err != nil {
+		t.Fatalf("Failed to create security manager: %v", err)
+	}
+
+	host := &ServerSecurityConfig{
+		SecurityHeadersConfig: SecurityHeadersConfig{
+			ContentSecurityPolicy:     NewCSPPolicy().DefaultSrc(CSPNone).ReportOnly(true),
+			ReferrerPolicy:            "no-referrer",
+			CrossOriginEmbedderPolicy: "require-corp",
+		},
+	}
+
+	ctx := createTestContext(t, &Request{Header: http.Header{}})
+	err = SecurityHeadersMiddleware(sm, host)(ctx, func(ctx Context) error { return nil })
+	if err != nil {
+		t.Fatalf("Middleware failed: %v", err)
+	}
+
+	headers := ctx.Response().(*testResponseWriter).headers
+	expected := map[string]string{
+		"Content-Security-Policy":             "",
+		"Content-Security-Policy-Report-Only": "default-src 'none'",
+		"Referrer-Policy":                     "no-referrer",
+		"Permissions-Policy":                  defaultPermissionsPolicy,
+		"Cross-Origin-Opener-Policy":          "same-origin",
+		"Cross-Origin-Embedder-Policy":        "require-corp",
+		"Cross-Origin-Resource-Policy":        "",
+	}
+	for name, value := range expected {
+		if got := headers.Get(name); got != value {
+			t.Errorf("Expected %s %q, got %q", name, value, got)
+		}
+	}
+}
+
+func TestCSPReportHandler(t *testing.T) {
+	legacy := []byte(`{"csp-report":{"document-uri":"https://example.com/","blocked-uri":"https://evil.example/x.js","violated-directive":"script-src","effective-directive":"script-src","disposition":"report"}}`)
+	reporting := []byte(`[{"type":"csp-violation","body":{"documentURL":"https://example.com/","blockedURL":"inline","effectiveDirective":"style-src-elem","disposition":"enforce"}},{"type":"deprecation","body":{}}]`)
+
+	for name, body := range map[string][]byte{"legacy": legacy, "reporting": reporting} {
+		t.Run(name, func(t *testing.T) {
+			violations, err := ParseCSPReports(body)
+			if err != nil || len(violations) != 1 {
+				t.Fatalf("Expected one violation, got %v, %v", violations, err)
+			}
+
+			logger := &recordingLogger{}
+			metrics := NewMetricsCollector(nil)
+			ctx := createTestContext(t, &Request{Header: http.Header{}, RawBody: body})
+			ctx.(*contextImpl).logger = logger
+			ctx.(*contextImpl).metrics = metrics
+
+			if err := CSPReportHandler()(ctx); err != nil {
+				t.Fatalf("Handler failed: %v", err)
+			}
+			if len(logger.warnings) != 1 {
+				t.Errorf("Expected one logged violation, got %d", len(logger.warnings))
+			}
+
+			exported, _ := metrics.Export()
+			if !strings.Contains(fmt.Sprint(exported["counters"]), "security.csp.violations") {
+				t.Errorf("Expected violation metric, got %v", exported)
+			}
+		})
+	}
+
+	ctx := createTestContext(t, &Request{Header: http.Header{}, RawBody: []byte("not json")})
+	if err := CSPReportHandler()(ctx); err == nil {
+		t.Error("Expected malformed report to be rejected")
+	}
+}
+
+func TestCSPReportHandler_NormalizesTags(t *testing.T) {
+	body := []byte(`[` +
+		`{"type":"csp-violation","body":{"effectiveDirective":"Script-Src 'self'","disposition":"report"}},` +
+		`{"type":"csp-violation","body":{"effectiveDirective":"evil-<random-1>","disposition":"bogus-<random-2>"}}]`)
+
+	metrics := NewMetricsCollector(nil)
+	ctx := createTestContext(t, &Request{Header: http.Header{}, RawBody: body})
+	ctx.(*contextImpl).metrics = metrics
+
+	if err := CSPReportHandler()(ctx); err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	exported, _ := metrics.Export()
+	counters := fmt.Sprint(exported["counters"])
+	if !strings.Contains(counters, "directive=script-src") || !strings.Contains(counters, "disposition=report") {
+		t.Errorf("Expected known directive and disposition tags, got %s", counters)
+	}
+	if strings.Contains(counters, "random") {
+		t.Errorf("Expected unknown tag values to be dropped, got %s", counters)
+	}
+	if !strings.Contains(counters, "directive=other") {
+		t.Errorf("Expected unknown directives to be tagged other, got %s", counters)
+	}
+}
+
+func TestCSPReportHandler_RejectsOversizedReports(t *testing.T) {
+	// Declared length is rejected without touching the body
+	header := http.Header{}
+	header.Set("Content-Length", "1048576")
+	ctx := createTestContext(t, &Request{Header: header})
+	if err := CSPReportHandler()(ctx); !isStatus(err, http.StatusRequestEntityTooLarge) {
+		t.Errorf("Expected 413 for a declared oversized report, got %v", err)
+	}
+
+	// Streamed bodies are read no further than the limit
+	stream := &countingReader{r: strings.NewReader(strings.Repeat("x", 1<<20))}
+	ctx = createTestContext(t, &Request{Header: http.Header{}, Body: io.NopCloser(stream)})
+	if err := CSPReportHandler()(ctx); !isStatus(err, http.StatusRequestEntityTooLarge) {
+		t.Errorf("Expected 413 for a streamed oversized report, got %v", err)
+	}
+	if stream.n > maxCSPReportSize+1 {
+		t.Errorf("Expected at most %d bytes to be read, got %d", maxCSPReportSize+1, stream.n)
+	}
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// isStatus reports whether err is a FrameworkError with the given status
+func isStatus(err error, status int) bool {
+	var fe *FrameworkError
+	return errors.As(err, &fe) && fe.StatusCode == status
+}
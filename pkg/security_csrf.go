@@ -0,0 +1,509 @@
+package pkg
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CSRFMode defines how CSRF tokens are bound and validated
+type CSRFMode string
+
+const (
+	// CSRFModeDoubleSubmit issues a signed token in a cookie and requires the same
+	// token (or a per-action token derived from it) in the request. Tokens are bound
+	// to the session ID when a session exists. This is the default.
+	CSRFModeDoubleSubmit CSRFMode = "double_submit"
+
+	// CSRFModeHMAC issues tokens that are HMAC-bound to the session ID only, without
+	// a cookie. A session is required.
+	CSRFModeHMAC CSRFMode = "hmac"
+
+	// CSRFModeOrigin validates Sec-Fetch-Site, Origin and Referer headers instead of
+	// tokens.
+	CSRFModeOrigin CSRFMode = "origin"
+)
+
+// CSRFContextKey is the Context key under which CSRFMiddleware stores the token
+// issued for the current request
+const CSRFContextKey = "csrf_token"
+
+// CSRFManager is implemented by security managers that issue per-action CSRF
+// tokens and validate the origin of requests. The security manager of the
+// framework implements it; it is separate from SecurityManager so that
+// existing implementations keep compiling.
+type CSRFManager interface {
+	GenerateCSRFToken(ctx Context, action string) (string, error)
+	ValidateCSRFActionToken(ctx Context, token string, action string) error
+	ValidateCSRFOrigin(ctx Context) error
+}
+
+const (
+	defaultCSRFCookieName = "csrf_token"
+	defaultCSRFHeaderName = "X-CSRF-Token"
+	defaultCSRFFieldName  = "csrf_token"
+
+	csrfTokenVersion = 1
+	csrfNonceSize    = 16
+	csrfPayloadSize  = 1 + 8 + csrfNonceSize
+)
+
+// EnableCSRFProtection issues the base CSRF token for the current request. In
+// double-submit mode the token is also set as a cookie; an existing valid cookie
+// token is reused so that concurrently open forms stay valid.
+func (s *securityManagerImpl) EnableCSRFProtection(ctx Context) (string, error) {
+	if s.csrfMode() == CSRFModeDoubleSubmit {
+		if cookie, err := ctx.GetCookie(s.csrfCookieName()); err == nil && cookie != nil {
+			if _, err := s.verifyCSRFToken(cookie.Value, s.csrfSessionID(ctx), "", ""); err == nil {
+				return cookie.Value, nil
+			}
+		}
+	}
+
+	return s.issueBaseCSRFToken(ctx)
+}
+
+// GenerateCSRFToken issues a token that is only valid for the given action (for
+// example a form name or "POST /account/delete"). An empty action returns the base
+// token issued by EnableCSRFProtection.
+func (s *securityManagerImpl) GenerateCSRFToken(ctx Context, action string) (string, error) {
+	if action == "" {
+		return s.EnableCSRFProtection(ctx)
+	}
+
+	if s.csrfMode() == CSRFModeOrigin {
+		return "", nil
+	}
+
+	sessionID := s.csrfSessionID(ctx)
+	binding := ""
+
+	if s.csrfMode() == CSRFModeDoubleSubmit {
+		base, err := s.EnableCSRFProtection(ctx)
+		if err != nil {
+			return "", err
+		}
+		nonce, err := csrfTokenNonce(base)
+		if err != nil {
+			return "", err
+		}
+		binding = nonce
+	} else if sessionID == "" {
+		return "", fmt.Errorf("CSRF mode %s requires a session", CSRFModeHMAC)
+	}
+
+	return s.signCSRFToken(sessionID, action, binding)
+}
+
+// ValidateCSRFToken validates the base CSRF token of a request
+func (s *securityManagerImpl) ValidateCSRFToken(ctx Context, token string) error {
+	return s.ValidateCSRFActionToken(ctx, token, "")
+}
+
+// ValidateCSRFActionToken validates a token issued by GenerateCSRFToken for action.
+// Validation is stateless: any replica sharing the CSRF secret can verify it.
+func (s *securityManagerImpl) ValidateCSRFActionToken(ctx Context, token string, action string) error {
+	if s.csrfMode() == CSRFModeOrigin {
+		return s.ValidateCSRFOrigin(ctx)
+	}
+
+	if token == "" {
+		return newCSRFError("CSRF token is empty", "error.csrf.token_empty")
+	}
+
+	sessionID := s.csrfSessionID(ctx)
+	binding := ""
+
+	if s.csrfMode() == CSRFModeDoubleSubmit {
+		cookie, err := ctx.GetCookie(s.csrfCookieName())
+		if err != nil || cookie == nil || cookie.Value == "" {
+			return newCSRFError("CSRF cookie not found", "error.csrf.cookie_not_found")
+		}
+
+		if action == "" {
+			// Use constant-time comparison to prevent timing attacks
+			if subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) != 1 {
+				return newCSRFError("CSRF token mismatch", "error.csrf.token_mismatch")
+			}
+		} else {
+			if _, err := s.verifyCSRFToken(cookie.Value, sessionID, "", ""); err != nil {
+				return err
+			}
+			nonce, err := csrfTokenNonce(cookie.Value)
+			if err != nil {
+				return newCSRFError("CSRF cookie is invalid", "error.csrf.token_invalid")
+			}
+			binding = nonce
+		}
+	}
+
+	_, err := s.verifyCSRFToken(token, sessionID, action, binding)
+	return err
+}
+
+// ValidateCSRFOrigin validates that a state-changing request originates from the
+// same origin or a trusted origin using Sec-Fetch-Site, Origin and Referer headers
+func (s *securityManagerImpl) ValidateCSRFOrigin(ctx Context) error {
+	req := ctx.Request()
+	if req == nil || isCSRFSafeMethod(req.Method) {
+		return nil
+	}
+
+	switch strings.ToLower(ctx.GetHeader("Sec-Fetch-Site")) {
+	case "same-origin", "none":
+		return nil
+	case "same-site", "cross-site":
+		// Fall through to Origin validation so trusted origins can still be allowed
+	}
+
+	origin := ctx.GetHeader("Origin")
+	if origin == "" || origin == "null" {
+		if referer := ctx.GetHeader("Referer"); referer != "" {
+			if u, err := url.Parse(referer); err == nil && u.Host != "" {
+				origin = u.Scheme + "://" + u.Host
+			}
+		}
+	}
+
+	if origin == "" || origin == "null" {
+		return newCSRFError("request origin could not be verified", "error.csrf.origin_missing")
+	}
+
+	if s.isTrustedCSRFOrigin(ctx, origin) {
+		return nil
+	}
+
+	return newCSRFError(fmt.Sprintf("cross-origin request from %s rejected", origin), "error.csrf.origin_mismatch")
+}
+
+// isTrustedCSRFOrigin checks an origin against the scheme and host of the
+// request and the trusted origins
+func (s *securityManagerImpl) isTrustedCSRFOrigin(ctx Context, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	req := ctx.Request()
+	host := req.Host
+	if host == "" && req.Header != nil {
+		host = req.Header.Get("Host")
+	}
+	if host != "" && strings.EqualFold(u.Scheme+"://"+u.Host, requestScheme(ctx)+"://"+host) {
+		return true
+	}
+
+	for _, trusted := range s.config.CSRFTrustedOrigins {
+		if trusted == "*" {
+			continue
+		}
+		if strings.EqualFold(strings.TrimRight(trusted, "/"), u.Scheme+"://"+u.Host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// issueBaseCSRFToken signs a new base token and sets the cookie in double-submit mode
+func (s *securityManagerImpl) issueBaseCSRFToken(ctx Context) (string, error) {
+	if s.csrfMode() == CSRFModeOrigin {
+		return "", nil
+	}
+
+	sessionID := s.csrfSessionID(ctx)
+	if s.csrfMode() == CSRFModeHMAC && sessionID == "" {
+		return "", fmt.Errorf("CSRF mode %s requires a session", CSRFModeHMAC)
+	}
+
+	token, err := s.signCSRFToken(sessionID, "", "")
+	if err != nil {
+		return "", err
+	}
+
+	if s.csrfMode() == CSRFModeDoubleSubmit {
+		cookie := &Cookie{
+			Name:     s.csrfCookieName(),
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+			MaxAge:   int(s.csrfExpiry().Seconds()),
+		}
+
+		if err := ctx.SetCookie(cookie); err != nil {
+			return "", fmt.Errorf("failed to set CSRF cookie: %w", err)
+		}
+	}
+
+	return token, nil
+}
+
+// signCSRFToken creates a token of the form base64(payload).base64(mac)
+func (s *securityManagerImpl) signCSRFToken(sessionID, action, binding string) (string, error) {
+	payload := make([]byte, csrfPayloadSize)
+	payload[0] = csrfTokenVersion
+	binary.BigEndian.PutUint64(payload[1:9], uint64(time.Now().Add(s.csrfExpiry()).Unix()))
+	if _, err := rand.Read(payload[9:]); err != nil {
+		return "", fmt.Errorf("failed to generate CSRF token: %w", err)
+	}
+
+	mac := s.csrfMAC(payload, sessionID, action, binding)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac), nil
+}
+
+// verifyCSRFToken checks signature and expiry of a token and returns its payload
+func (s *securityManagerImpl) verifyCSRFToken(token, sessionID, action, binding string) ([]byte, error) {
+	payload, mac, err := decodeCSRFToken(token)
+	if err != nil {
+		return nil, newCSRFError("CSRF token not found", "error.csrf.token_invalid")
+	}
+
+	if !hmac.Equal(mac, s.csrfMAC(payload, sessionID, action, binding)) {
+		return nil, newCSRFError("CSRF token not found", "error.csrf.token_invalid")
+	}
+
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(payload[1:9])), 0)
+	if time.Now().After(expiry) {
+		return nil, newCSRFError("CSRF token expired", "error.csrf.token_expired")
+	}
+
+	return payload, nil
+}
+
+// csrfMAC computes the HMAC binding a token payload to session, action and cookie
+func (s *securityManagerImpl) csrfMAC(payload []byte, sessionID, action, binding string) []byte {
+	mac := hmac.New(sha256.New, s.csrfKey)
+	mac.Write([]byte("rockstar-csrf\x00"))
+	mac.Write([]byte(sessionID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(action))
+	mac.Write([]byte{0})
+	mac.Write([]byte(binding))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// csrfSessionID returns the ID of the session the request belongs to, if any
+func (s *securityManagerImpl) csrfSessionID(ctx Context) string {
+	if req := ctx.Request(); req != nil && req.SessionID != "" {
+		return req.SessionID
+	}
+
+	if sessions := ctx.Session(); sessions != nil {
+		if session, err := sessions.GetSessionFromCookie(ctx); err == nil && session != nil {
+			return session.ID
+		}
+	}
+
+	return ""
+}
+
+func (s *securityManagerImpl) csrfMode() CSRFMode {
+	if s.config.CSRFMode == "" {
+		return CSRFModeDoubleSubmit
+	}
+	return s.config.CSRFMode
+}
+
+func (s *securityManagerImpl) csrfCookieName() string {
+	if s.config.CSRFCookieName == "" {
+		return defaultCSRFCookieName
+	}
+	return s.config.CSRFCookieName
+}
+
+func (s *securityManagerImpl) csrfHeaderName() string {
+	if s.config.CSRFHeaderName == "" {
+		return defaultCSRFHeaderName
+	}
+	return s.config.CSRFHeaderName
+}
+
+func (s *securityManagerImpl) csrfFieldName() string {
+	if s.config.CSRFFieldName == "" {
+		return defaultCSRFFieldName
+	}
+	return s.config.CSRFFieldName
+}
+
+func (s *securityManagerImpl) csrfExpiry() time.Duration {
+	if s.config.CSRFTokenExpiry <= 0 {
+		return 24 * time.Hour
+	}
+	return s.config.CSRFTokenExpiry
+}
+
+// deriveCSRFKey returns the configured CSRF secret or derives one from the
+// encryption key so that all replicas sharing the key accept each other's tokens
+func deriveCSRFKey(secret string, encryptionKey []byte) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+
+	mac := hmac.New(sha256.New, encryptionKey)
+	mac.Write([]byte("rockstar-csrf-key"))
+	return mac.Sum(nil)
+}
+
+// decodeCSRFToken splits a token into payload and MAC
+func decodeCSRFToken(token string) ([]byte, []byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("invalid CSRF token format")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(payload) != csrfPayloadSize || payload[0] != csrfTokenVersion {
+		return nil, nil, fmt.Errorf("invalid CSRF token payload")
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSRF token signature")
+	}
+
+	return payload, mac, nil
+}
+
+// csrfTokenNonce returns the hex-encoded nonce of a token
+func csrfTokenNonce(token string) (string, error) {
+	payload, _, err := decodeCSRFToken(token)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(payload[9:]), nil
+}
+
+// isCSRFSafeMethod reports whether a method is exempt from CSRF validation
+func isCSRFSafeMethod(method string) bool {
+	switch strings.ToUpper(method) {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// newCSRFError creates a CSRF validation error
+func newCSRFError(message, i18nKey string) *FrameworkError {
+	return &FrameworkError{
+		Code:       ErrCodeCSRFTokenInvalid,
+		Message:    message,
+		StatusCode: http.StatusForbidden,
+		I18nKey:    i18nKey,
+	}
+}
+
+// CSRFMiddleware creates a middleware that issues a CSRF token on safe requests and
+// validates the base token (or request origin, in origin mode) on state-changing
+// requests. The token is read from the configured header or form field.
+func CSRFMiddleware(security SecurityManager) MiddlewareFunc {
+	return CSRFActionMiddleware(security, "")
+}
+
+// CSRFActionMiddleware is like CSRFMiddleware but requires a per-action token
+// generated for action, so a token leaked from one form cannot be replayed on another
+func CSRFActionMiddleware(security SecurityManager, action string) MiddlewareFunc {
+	return func(ctx Context, next HandlerFunc) error {
+		req := ctx.Request()
+		if req == nil || isCSRFSafeMethod(req.Method) {
+			token, err := generateCSRFToken(security, ctx, action)
+			if err != nil {
+				return err
+			}
+			ctx.Set(CSRFContextKey, token)
+			return next(ctx)
+		}
+
+		headerName, fieldName := defaultCSRFHeaderName, defaultCSRFFieldName
+		if impl, ok := security.(*securityManagerImpl); ok {
+			headerName, fieldName = impl.csrfHeaderName(), impl.csrfFieldName()
+		}
+
+		token := ctx.GetHeader(headerName)
+		if token == "" {
+			token = ctx.FormValue(fieldName)
+		}
+
+		if err := validateCSRFToken(security, ctx, token, action); err != nil {
+			return err
+		}
+
+		return next(ctx)
+	}
+}
+
+// generateCSRFToken issues a token for action. Security managers that do not
+// implement CSRFManager only issue the base token.
+func generateCSRFToken(security SecurityManager, ctx Context, action string) (string, error) {
+	if csrf, ok := security.(CSRFManager); ok {
+		return csrf.GenerateCSRFToken(ctx, action)
+	}
+	if action != "" {
+		return "", fmt.Errorf("per-action CSRF tokens require a security manager implementing CSRFManager")
+	}
+	return security.EnableCSRFProtection(ctx)
+}
+
+// validateCSRFToken validates a token for action. Security managers that do
+// not implement CSRFManager only validate the base token.
+func validateCSRFToken(security SecurityManager, ctx Context, token string, action string) error {
+	if csrf, ok := security.(CSRFManager); ok {
+		return csrf.ValidateCSRFActionToken(ctx, token, action)
+	}
+	if action != "" {
+		return fmt.Errorf("per-action CSRF tokens require a security manager implementing CSRFManager")
+	}
+	return security.ValidateCSRFToken(ctx, token)
+}
+
+// RegisterCSRFTemplateFuncs registers the csrfToken and csrfField template functions.
+// Both take the request Context and an optional action:
+//
+//	<form method="post">{{ csrfField .Ctx "delete-account" }}</form>
+func RegisterCSRFTemplateFuncs(tm TemplateManager, security SecurityManager) error {
+	fieldName := defaultCSRFFieldName
+	if impl, ok := security.(*securityManagerImpl); ok {
+		fieldName = impl.csrfFieldName()
+	}
+
+	tokenFunc := func(ctx Context, action ...string) (string, error) {
+		name := ""
+		if len(action) > 0 {
+			name = action[0]
+		}
+		if name == "" {
+			if token, ok := ctx.Get(CSRFContextKey); ok {
+				if s, ok := token.(string); ok && s != "" {
+					return s, nil
+				}
+			}
+		}
+		return generateCSRFToken(security, ctx, name)
+	}
+
+	if err := tm.AddFunc("csrfToken", tokenFunc); err != nil {
+		return err
+	}
+
+	return tm.AddFunc("csrfField", func(ctx Context, action ...string) (template.HTML, error) {
+		token, err := tokenFunc(ctx, action...)
+		if err != nil {
+			return "", err
+		}
+		return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+			template.HTMLEscapeString(fieldName), template.HTMLEscapeString(token))), nil
+	})
+}
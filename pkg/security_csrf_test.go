@@ -0,0 +1,289 @@
+package pkg
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func createTestCSRFManager(t *testing.T, mutate func(*SecurityConfig)) *securityManagerImpl {
+	config := DefaultSecurityConfig()
+	config.EncryptionKey = hex.EncodeToString([]byte("12345678901234567890123456789012"))
+	config.JWTSecret = "test-jwt-secret"
+	if mutate != nil {
+		mutate(&config)
+	}
+
+	sm, err := NewSecurityManager(NewMockDatabaseManager(), config)
+	if err != nil {
+		t.Fatalf("Failed to create security manager: %v", err)
+	}
+	return sm.(*securityManagerImpl)
+}
+
+// csrfRequest builds a request context that carries the given CSRF cookie
+func csrfRequest(t *testing.T, method, cookie string) (Context, *Request) {
+	req := &Request{Method: method, Header: http.Header{}, Host: "example.com"}
+	if cookie != "" {
+		req.Header.Set("Cookie", "csrf_token="+cookie)
+	}
+	return createTestContext(t, req), req
+}
+
+func TestCSRF_TokenValidAcrossInstances(t *testing.T) {
+	issuer := createTestCSRFManager(t, nil)
+	verifier := createTestCSRFManager(t, nil)
+
+	ctx, _ := csrfRequest(t, http.MethodGet, "")
+	token, err := issuer.EnableCSRFProtection(ctx)
+	if err != nil {
+		t.Fatalf("Failed to generate CSRF token: %v", err)
+	}
+
+	ctx, _ = csrfRequest(t, http.MethodPost, token)
+	if err := verifier.ValidateCSRFToken(ctx, token); err != nil {
+		t.Errorf("Expected token to validate on another instance, got: %v", err)
+	}
+
+	other := createTestCSRFManager(t, func(c *SecurityConfig) { c.CSRFSecret = "different-secret" })
+	if err := other.ValidateCSRFToken(ctx, token); err == nil {
+		t.Error("Expected token to be rejected with a different secret")
+	}
+}
+
+func TestCSRF_ReusesExistingCookieToken(t *testing.T) {
+	sm := createTestCSRFManager(t, nil)
+
+	ctx, _ := csrfRequest(t, http.MethodGet, "")
+	first, _ := sm.EnableCSRFProtection(ctx)
+
+	ctx, _ = csrfRequest(t, http.MethodGet, first)
+	second, err := sm.EnableCSRFProtection(ctx)
+	if err != nil {
+		t.Fatalf("Failed to generate CSRF token: %v", err)
+	}
+	if first != second {
+		t.Error("Expected valid cookie token to be reused")
+	}
+}
+
+func TestCSRF_BoundToSession(t *testing.T) {
+	sm := createTestCSRFManager(t, nil)
+
+	ctx, req := csrfRequest(t, http.MethodGet, "")
+	req.SessionID = "session-a"
+	token, _ := sm.EnableCSRFProtection(ctx)
+
+	ctx, req = csrfRequest(t, http.MethodPost, token)
+	req.SessionID = "session-b"
+	if err := sm.ValidateCSRFToken(ctx, token); err == nil {
+		t.Error("Expected token bound to another session to be rejected")
+	}
+
+	req.SessionID = "session-a"
+	if err := sm.ValidateCSRFToken(ctx, token); err != nil {
+		t.Errorf("Expected token to validate for its session, got: %v", err)
+	}
+}
+
+func TestCSRF_PerActionTokens(t *testing.T) {
+	sm := createTestCSRFManager(t, nil)
+
+	ctx, _ := csrfRequest(t, http.MethodGet, "")
+	base, _ := sm.EnableCSRFProtection(ctx)
+
+	ctx, _ = csrfRequest(t, http.MethodGet, base)
+	deleteToken, err := sm.GenerateCSRFToken(ctx, "delete-account")
+	if err != nil {
+		t.Fatalf("Failed to generate action token: %v", err)
+	}
+
+	ctx, _ = csrfRequest(t, http.MethodPost, base)
+	if err := sm.ValidateCSRFActionToken(ctx, deleteToken, "delete-account"); err != nil {
+		t.Errorf("Expected action token to validate, got: %v", err)
+	}
+	if err := sm.ValidateCSRFActionToken(ctx, deleteToken, "change-email"); err == nil {
+		t.Error("Expected action token to be rejected for another action")
+	}
+	if err := sm.ValidateCSRFActionToken(ctx, base, "delete-account"); err == nil {
+		t.Error("Expected base token to be rejected for an action")
+	}
+
+	// Action tokens are bound to the cookie they were derived from
+	ctx, _ = csrfRequest(t, http.MethodGet, "")
+	otherBase, _ := sm.EnableCSRFProtection(ctx)
+	ctx, _ = csrfRequest(t, http.MethodPost, otherBase)
+	if err := sm.ValidateCSRFActionToken(ctx, deleteToken, "delete-account"); err == nil {
+		t.Error("Expected action token to be rejected with a different cookie")
+	}
+}
+
+func TestCSRF_HMACModeRequiresSession(t *testing.T) {
+	sm := createTestCSRFManager(t, func(c *SecurityConfig) { c.CSRFMode = CSRFModeHMAC })
+
+	ctx, _ := csrfRequest(t, http.MethodGet, "")
+	if _, err := sm.EnableCSRFProtection(ctx); err == nil {
+		t.Error("Expected error without a session")
+	}
+
+	ctx, req := csrfRequest(t, http.MethodGet, "")
+	req.SessionID = "session-a"
+	token, err := sm.GenerateCSRFToken(ctx, "checkout")
+	if err != nil {
+		t.Fatalf("Failed to generate CSRF token: %v", err)
+	}
+	if ctx.Response().(*testResponseWriter).headers.Get("Set-Cookie") != "" {
+		t.Error("HMAC mode should not set a cookie")
+	}
+
+	// No cookie is needed to validate
+	ctx, req = csrfRequest(t, http.MethodPost, "")
+	req.SessionID = "session-a"
+	if err := sm.ValidateCSRFActionToken(ctx, token, "checkout"); err != nil {
+		t.Errorf("Expected token to validate, got: %v", err)
+	}
+}
+
+func TestCSRF_ValidateOrigin(t *testing.T) {
+	sm := createTestCSRFManager(t, func(c *SecurityConfig) {
+		c.CSRFMode = CSRFModeOrigin
+		c.CSRFTrustedOrigins = []string{"https://app.example.org"}
+	})
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		wantErr bool
+	}{
+		{"safe method", http.MethodGet, nil, false},
+		{"same-origin fetch metadata", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-origin"}, false},
+		{"cross-site fetch metadata", http.MethodPost, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.test"}, true},
+		{"same host origin", http.MethodPost, map[string]string{"Origin": "https://example.com"}, false},
+		{"same host over another scheme", http.MethodPost, map[string]string{"Origin": "http://example.com"}, true},
+		{"trusted origin", http.MethodPost, map[string]string{"Origin": "https://app.example.org"}, false},
+		{"referer fallback", http.MethodPost, map[string]string{"Referer": "https://example.com/form"}, false},
+		{"referer over another scheme", http.MethodPost, map[string]string{"Referer": "http://example.com/form"}, true},
+		{"foreign origin", http.MethodPost, map[string]string{"Origin": "https://evil.test"}, true},
+		{"missing origin", http.MethodDelete, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, req := csrfRequest(t, tt.method, "")
+			req.URL = &url.URL{Scheme: "https", Host: "example.com", Path: "/"}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			err := sm.ValidateCSRFToken(ctx, "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCSRFToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	sm := createTestCSRFManager(t, nil)
+	mw := CSRFMiddleware(sm)
+
+	called := false
+	handler := func(ctx Context) error {
+		called = true
+		return nil
+	}
+
+	// Safe request issues a token
+	ctx, _ := csrfRequest(t, http.MethodGet, "")
+	if err := mw(ctx, handler); err != nil {
+		t.Fatalf("Expected GET to pass, got: %v", err)
+	}
+	value, ok := ctx.Get(CSRFContextKey)
+	token, _ := value.(string)
+	if !ok || token == "" {
+		t.Fatal("Expected CSRF token in context")
+	}
+
+	// Unsafe request without token is rejected
+	called = false
+	ctx, _ = csrfRequest(t, http.MethodPost, token)
+	err := mw(ctx, handler)
+	if fwErr, ok := GetFrameworkError(err); !ok || fwErr.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 CSRF error, got: %v", err)
+	}
+	if called {
+		t.Error("Handler should not run without a CSRF token")
+	}
+
+	// Token in header
+	ctx, req := csrfRequest(t, http.MethodPost, token)
+	req.Header.Set("X-CSRF-Token", token)
+	if err := mw(ctx, handler); err != nil || !called {
+		t.Errorf("Expected POST with header token to pass, got: %v", err)
+	}
+
+	// Token in form field
+	called = false
+	ctx, req = csrfRequest(t, http.MethodPost, token)
+	req.Form = map[string]string{"csrf_token": token}
+	if err := mw(ctx, handler); err != nil || !called {
+		t.Errorf("Expected POST with form token to pass, got: %v", err)
+	}
+}
+
+func TestCSRFMiddleware_WithoutCSRFManager(t *testing.T) {
+	// Embedding the interface hides the CSRFManager methods
+	sm := struct{ SecurityManager }{createTestCSRFManager(t, nil)}
+	handler := func(ctx Context) error { return nil }
+
+	ctx, _ := csrfRequest(t, http.MethodGet, "")
+	if err := CSRFMiddleware(sm)(ctx, handler); err != nil {
+		t.Fatalf("Expected GET to pass, got: %v", err)
+	}
+	value, _ := ctx.Get(CSRFContextKey)
+	token, _ := value.(string)
+	if token == "" {
+		t.Fatal("Expected base CSRF token in context")
+	}
+
+	ctx, req := csrfRequest(t, http.MethodPost, token)
+	req.Header.Set("X-CSRF-Token", token)
+	if err := CSRFMiddleware(sm)(ctx, handler); err != nil {
+		t.Errorf("Expected POST with base token to pass, got: %v", err)
+	}
+
+	ctx, _ = csrfRequest(t, http.MethodGet, "")
+	if err := CSRFActionMiddleware(sm, "delete-account")(ctx, handler); err == nil {
+		t.Error("Expected per-action token to require a CSRFManager")
+	}
+}
+
+func TestRegisterCSRFTemplateFuncs(t *testing.T) {
+	sm := createTestCSRFManager(t, nil)
+	tm := NewTemplateManager()
+
+	if err := RegisterCSRFTemplateFuncs(tm, sm); err != nil {
+		t.Fatalf("Failed to register template funcs: %v", err)
+	}
+	if err := tm.LoadTemplate("form", `<form>{{ csrfField .Ctx }}{{ csrfField .Ctx "delete" }}</form>`); err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	ctx, _ := csrfRequest(t, http.MethodGet, "")
+	ctx.Set(CSRFContextKey, "issued-token")
+
+	out, err := tm.Render("form", map[string]interface{}{"Ctx": ctx})
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+
+	if !strings.Contains(out, `<input type="hidden" name="csrf_token" value="issued-token">`) {
+		t.Errorf("Expected hidden field with issued token, got: %s", out)
+	}
+	if strings.Count(out, `type="hidden"`) != 2 {
+		t.Errorf("Expected two hidden fields, got: %s", out)
+	}
+}
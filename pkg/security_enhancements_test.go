@@ -252,6 +252,7 @@ func (m *mockSecurityContext) Session() SessionManager                     { ret
 func (m *mockSecurityContext) User() *User                                 { return nil }
 func (m *mockSecurityContext) Tenant() *Tenant                             { return nil }
 func (m *mockSecurityContext) DB() DatabaseManager                         { return nil }
+func (m *mockSecurityContext) Jobs() JobQueue                              { return nil }
 func (m *mockSecurityContext) Cache() CacheManager                         { return nil }
 func (m *mockSecurityContext) Config() ConfigManager                       { return nil }
 func (m *mockSecurityContext) I18n() I18nManager                           { return nil }
@@ -4,7 +4,6 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
-	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
@@ -20,11 +19,14 @@ import (
 type securityManagerImpl struct {
 	db            DatabaseManager
 	config        SecurityConfig
-	csrfTokens    map[string]time.Time // token -> expiration time
+	csrfKey       []byte // HMAC key for stateless CSRF tokens
 	encryptionKey []byte
 	jwtSecret     []byte
 	tokenStorage  *inMemoryTokenStorage     // In-memory token storage when no database
 	rateLimits    *inMemoryRateLimitStorage // In-memory rate limit storage when no database
+
+	rateLimiter       RateLimiter // Per-resource rate limiter
+	globalRateLimiter RateLimiter // Global per-client rate limiter
 }
 
 // SecurityConfig holds security configuration
@@ -39,12 +41,31 @@ type SecurityConfig struct {
 	EnableCSRF       bool          // Enable CSRF protection
 	AllowedOrigins   []string      // Allowed origins for CORS
 
+	// CSRF (stateless, verifiable by every instance sharing the secret)
+	CSRFMode           CSRFMode // Token binding mode (default: double_submit)
+	CSRFSecret         string   // HMAC secret for CSRF tokens (default: derived from EncryptionKey)
+	CSRFCookieName     string   // Cookie name for double-submit tokens (default: csrf_token)
+	CSRFHeaderName     string   // Header carrying the token (default: X-CSRF-Token)
+	CSRFFieldName      string   // Form field carrying the token (default: csrf_token)
+	CSRFTrustedOrigins []string // Additional origins accepted by origin validation
+
+	// Rate limiting (CheckRateLimit and CheckGlobalRateLimit)
+	RateLimitAlgorithm      RateLimitAlgorithm // Algorithm for both limiters (default: token_bucket)
+	RateLimitRequests       int                // Requests per client and resource per window (default: 100)
+	RateLimitWindow         time.Duration      // Per-resource window (default: 1 minute)
+	RateLimitBurst          int                // Per-resource burst (default: RateLimitRequests)
+	GlobalRateLimitRequests int                // Requests per client per global window (default: 1000)
+	GlobalRateLimitWindow   time.Duration      // Global window (default: 1 hour)
+
 	// HSTS (HTTP Strict Transport Security)
 	EnableHSTS            bool // Enable HSTS header (default: true)
 	HSTSMaxAge            int  // HSTS max-age in seconds (default: 31536000 = 1 year)
 	HSTSIncludeSubdomains bool // Include subdomains in HSTS (default: true)
 	HSTSPreload           bool // Enable HSTS preload (default: false)
 
+	// CSP, Permissions-Policy, Referrer-Policy and cross-origin isolation headers
+	SecurityHeadersConfig
+
 	// Production mode
 	ProductionMode bool // Hide sensitive error details in production (default: false)
 
@@ -72,7 +93,7 @@ func NewSecurityManager(db DatabaseManager, config SecurityConfig) (SecurityMana
 	sm := &securityManagerImpl{
 		db:            db,
 		config:        config,
-		csrfTokens:    make(map[string]time.Time),
+		csrfKey:       deriveCSRFKey(config.CSRFSecret, encKey),
 		encryptionKey: encKey,
 		jwtSecret:     jwtSecret,
 	}
@@ -86,6 +107,10 @@ func NewSecurityManager(db DatabaseManager, config SecurityConfig) (SecurityMana
 		fmt.Println("WARN: SecurityManager using in-memory rate limiting. Rate limits will not persist across restarts.")
 	}
 
+	if err := sm.initRateLimiters(); err != nil {
+		return nil, err
+	}
+
 	return sm, nil
 }
 
@@ -98,8 +123,19 @@ func DefaultSecurityConfig() SecurityConfig {
 		XFrameOptions:    "SAMEORIGIN",
 		EnableXSSProtect: true,
 		EnableCSRF:       true,
+		CSRFMode:         CSRFModeDoubleSubmit,
+		CSRFCookieName:   "csrf_token",
+		CSRFHeaderName:   "X-CSRF-Token",
+		CSRFFieldName:    "csrf_token",
 		AllowedOrigins:   []string{}, // SECURITY: No wildcard by default - must be explicitly configured
 
+		// Rate limiting
+		RateLimitAlgorithm:      RateLimitTokenBucket,
+		RateLimitRequests:       100,
+		RateLimitWindow:         time.Minute,
+		GlobalRateLimitRequests: 1000,
+		GlobalRateLimitWindow:   time.Hour,
+
 		// HSTS enabled by default for security
 		EnableHSTS:            true,
 		HSTSMaxAge:            31536000, // 1 year
@@ -647,14 +683,23 @@ func (s *securityManagerImpl) ValidateExpectedFiles(ctx Context, expectedFiles [
 
 // SetSecurityHeaders sets all security headers
 func (s *securityManagerImpl) SetSecurityHeaders(ctx Context) error {
+	return s.setSecurityHeaders(ctx, SecurityHeadersConfig{})
+}
+
+// setSecurityHeaders sets all security headers, applying per-host overrides
+func (s *securityManagerImpl) setSecurityHeaders(ctx Context, override SecurityHeadersConfig) error {
 	// Set X-Frame-Options
 	if err := s.SetXFrameOptions(ctx, s.config.XFrameOptions); err != nil {
 		return err
 	}
 
-	// Enable XSS protection
+	headers := s.config.SecurityHeadersConfig.merge(override)
+
+	// Enable XSS protection. A configured CSP replaces the fixed default policy.
 	if s.config.EnableXSSProtect {
-		if err := s.EnableXSSProtection(ctx); err != nil {
+		if headers.ContentSecurityPolicy != nil {
+			ctx.SetHeader("X-XSS-Protection", "1; mode=block")
+		} else if err := s.EnableXSSProtection(ctx); err != nil {
 			return err
 		}
 	}
@@ -673,10 +718,8 @@ func (s *securityManagerImpl) SetSecurityHeaders(ctx Context) error {
 
 	// Set other security headers
 	ctx.SetHeader("X-Content-Type-Options", "nosniff")
-	ctx.SetHeader("Referrer-Policy", "strict-origin-when-cross-origin")
-	ctx.SetHeader("Permissions-Policy", "geolocation=(), microphone=(), camera=()")
 
-	return nil
+	return headers.apply(ctx)
 }
 
 // SetXFrameOptions sets the X-Frame-Options header
@@ -749,93 +792,6 @@ func (s *securityManagerImpl) EnableXSSProtection(ctx Context) error {
 	return nil
 }
 
-// EnableCSRFProtection generates and returns a CSRF token
-func (s *securityManagerImpl) EnableCSRFProtection(ctx Context) (string, error) {
-	// Generate random token
-	tokenBytes := make([]byte, 32)
-	if _, err := rand.Read(tokenBytes); err != nil {
-		return "", fmt.Errorf("failed to generate CSRF token: %w", err)
-	}
-
-	token := base64.URLEncoding.EncodeToString(tokenBytes)
-
-	// Store token with expiration
-	s.csrfTokens[token] = time.Now().Add(s.config.CSRFTokenExpiry)
-
-	// Set token in cookie
-	cookie := &Cookie{
-		Name:     "csrf_token",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   int(s.config.CSRFTokenExpiry.Seconds()),
-	}
-
-	if err := ctx.SetCookie(cookie); err != nil {
-		return "", fmt.Errorf("failed to set CSRF cookie: %w", err)
-	}
-
-	return token, nil
-}
-
-// ValidateCSRFToken validates a CSRF token
-func (s *securityManagerImpl) ValidateCSRFToken(ctx Context, token string) error {
-	if token == "" {
-		return &FrameworkError{
-			Code:       ErrCodeCSRFTokenInvalid,
-			Message:    "CSRF token is empty",
-			StatusCode: http.StatusForbidden,
-			I18nKey:    "error.csrf.token_empty",
-		}
-	}
-
-	// Check if token exists and is not expired
-	expiry, exists := s.csrfTokens[token]
-	if !exists {
-		return &FrameworkError{
-			Code:       ErrCodeCSRFTokenInvalid,
-			Message:    "CSRF token not found",
-			StatusCode: http.StatusForbidden,
-			I18nKey:    "error.csrf.token_invalid",
-		}
-	}
-
-	if time.Now().After(expiry) {
-		delete(s.csrfTokens, token)
-		return &FrameworkError{
-			Code:       ErrCodeCSRFTokenInvalid,
-			Message:    "CSRF token expired",
-			StatusCode: http.StatusForbidden,
-			I18nKey:    "error.csrf.token_expired",
-		}
-	}
-
-	// Get token from cookie
-	cookie, err := ctx.GetCookie("csrf_token")
-	if err != nil {
-		return &FrameworkError{
-			Code:       ErrCodeCSRFTokenInvalid,
-			Message:    "CSRF cookie not found",
-			StatusCode: http.StatusForbidden,
-			I18nKey:    "error.csrf.cookie_not_found",
-		}
-	}
-
-	// Use constant-time comparison to prevent timing attacks
-	if subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) != 1 {
-		return &FrameworkError{
-			Code:       ErrCodeCSRFTokenInvalid,
-			Message:    "CSRF token mismatch",
-			StatusCode: http.StatusForbidden,
-			I18nKey:    "error.csrf.token_mismatch",
-		}
-	}
-
-	return nil
-}
-
 // Input validation and sanitization methods
 
 // ValidateInput validates input against provided rules
@@ -915,7 +871,17 @@ func (s *securityManagerImpl) SanitizeInput(input string) string {
 
 // EncryptCookie encrypts a cookie value using AES
 func (s *securityManagerImpl) EncryptCookie(value string) (string, error) {
-	block, err := aes.NewCipher(s.encryptionKey)
+	return encryptCookie(s.encryptionKey, value)
+}
+
+// DecryptCookie decrypts an encrypted cookie value
+func (s *securityManagerImpl) DecryptCookie(encryptedValue string) (string, error) {
+	return decryptCookie(s.encryptionKey, encryptedValue)
+}
+
+// encryptCookie encrypts and authenticates value with AES-GCM under key
+func encryptCookie(key []byte, value string) (string, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", fmt.Errorf("failed to create cipher: %w", err)
 	}
@@ -934,14 +900,14 @@ func (s *securityManagerImpl) EncryptCookie(value string) (string, error) {
 	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
-// DecryptCookie decrypts an encrypted cookie value
-func (s *securityManagerImpl) DecryptCookie(encryptedValue string) (string, error) {
+// decryptCookie decrypts a value encrypted by encryptCookie under key
+func decryptCookie(key []byte, encryptedValue string) (string, error) {
 	ciphertext, err := base64.StdEncoding.DecodeString(encryptedValue)
 	if err != nil {
 		return "", fmt.Errorf("failed to decode cookie: %w", err)
 	}
 
-	block, err := aes.NewCipher(s.encryptionKey)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", fmt.Errorf("failed to create cipher: %w", err)
 	}
@@ -967,101 +933,101 @@ func (s *securityManagerImpl) DecryptCookie(encryptedValue string) (string, erro
 
 // Rate limiting methods
 
-// CheckRateLimit checks rate limit for a specific resource
-func (s *securityManagerImpl) CheckRateLimit(ctx Context, resource string) error {
-	// Get client identifier (IP address or user ID)
-	clientID := s.getClientIdentifier(ctx)
-
-	// Create rate limit key for this resource
-	rateLimitKey := fmt.Sprintf("ratelimit:%s:%s", clientID, resource)
-
-	// Default rate limit: 100 requests per minute
-	limit := 100
-	window := time.Minute
-
-	var allowed bool
-	var err error
-
-	// Use in-memory storage if no database is available
+// initRateLimiters creates the per-resource and global rate limiters. State is kept
+// in memory without a database and in the rate_limit_state table otherwise.
+func (s *securityManagerImpl) initRateLimiters() error {
+	var store RateLimitStore
 	if s.rateLimits != nil {
-		allowed, err = s.rateLimits.CheckRateLimit(rateLimitKey, limit, window)
+		store = s.rateLimits
 	} else {
-		allowed, err = s.db.CheckRateLimit(rateLimitKey, limit, window)
+		store = NewDatabaseRateLimitStore(s.db)
 	}
 
-	if err != nil {
-		return fmt.Errorf("failed to check rate limit: %w", err)
+	requests, window := s.config.RateLimitRequests, s.config.RateLimitWindow
+	if requests <= 0 {
+		requests = 100
+	}
+	if window <= 0 {
+		window = time.Minute
 	}
 
-	if !allowed {
-		return &FrameworkError{
-			Code:       ErrCodeRateLimitExceeded,
-			Message:    fmt.Sprintf("rate limit exceeded for resource: %s", resource),
-			StatusCode: http.StatusTooManyRequests,
-			I18nKey:    "error.rate_limit.exceeded",
-			Details:    map[string]interface{}{"resource": resource, "limit": limit, "window": window.String()},
-		}
+	limiter, err := NewRateLimiter(RateLimiterConfig{
+		Algorithm: s.config.RateLimitAlgorithm,
+		Limit:     requests,
+		Window:    window,
+		Burst:     s.config.RateLimitBurst,
+		Store:     store,
+		Prefix:    "ratelimit",
+	})
+	if err != nil {
+		return fmt.Errorf("invalid rate limit configuration: %w", err)
 	}
 
-	// Increment rate limit counter
-	if s.rateLimits != nil {
-		err = s.rateLimits.IncrementRateLimit(rateLimitKey, window)
-	} else {
-		err = s.db.IncrementRateLimit(rateLimitKey, window)
+	globalRequests, globalWindow := s.config.GlobalRateLimitRequests, s.config.GlobalRateLimitWindow
+	if globalRequests <= 0 {
+		globalRequests = 1000
+	}
+	if globalWindow <= 0 {
+		globalWindow = time.Hour
 	}
 
+	globalLimiter, err := NewRateLimiter(RateLimiterConfig{
+		Algorithm: s.config.RateLimitAlgorithm,
+		Limit:     globalRequests,
+		Window:    globalWindow,
+		Store:     store,
+		Prefix:    "ratelimit:global",
+	})
 	if err != nil {
-		return fmt.Errorf("failed to increment rate limit: %w", err)
+		return fmt.Errorf("invalid global rate limit configuration: %w", err)
 	}
 
+	s.rateLimiter = limiter
+	s.globalRateLimiter = globalLimiter
 	return nil
 }
 
-// CheckGlobalRateLimit checks global rate limit
-func (s *securityManagerImpl) CheckGlobalRateLimit(ctx Context) error {
+// CheckRateLimit checks rate limit for a specific resource. The check and the
+// consumption of the request happen atomically in the rate limit store.
+func (s *securityManagerImpl) CheckRateLimit(ctx Context, resource string) error {
 	// Get client identifier (IP address or user ID)
 	clientID := s.getClientIdentifier(ctx)
 
-	// Create global rate limit key
-	rateLimitKey := fmt.Sprintf("ratelimit:global:%s", clientID)
-
-	// Default global rate limit: 1000 requests per hour
-	limit := 1000
-	window := time.Hour
+	result, err := s.rateLimiter.AllowN(fmt.Sprintf("%s:%s", clientID, resource), 1)
+	if err != nil {
+		return fmt.Errorf("failed to check rate limit: %w", err)
+	}
 
-	var allowed bool
-	var err error
+	SetRateLimitHeaders(ctx, result)
 
-	// Use in-memory storage if no database is available
-	if s.rateLimits != nil {
-		allowed, err = s.rateLimits.CheckRateLimit(rateLimitKey, limit, window)
-	} else {
-		allowed, err = s.db.CheckRateLimit(rateLimitKey, limit, window)
+	if !result.Allowed {
+		return newRateLimitExceededError(
+			fmt.Sprintf("rate limit exceeded for resource: %s", resource),
+			result,
+			map[string]interface{}{"resource": resource},
+		)
 	}
 
+	return nil
+}
+
+// CheckGlobalRateLimit checks global rate limit
+func (s *securityManagerImpl) CheckGlobalRateLimit(ctx Context) error {
+	// Get client identifier (IP address or user ID)
+	clientID := s.getClientIdentifier(ctx)
+
+	result, err := s.globalRateLimiter.AllowN(clientID, 1)
 	if err != nil {
 		return fmt.Errorf("failed to check global rate limit: %w", err)
 	}
 
-	if !allowed {
-		return &FrameworkError{
-			Code:       ErrCodeRateLimitExceeded,
-			Message:    "global rate limit exceeded",
-			StatusCode: http.StatusTooManyRequests,
-			I18nKey:    "error.rate_limit.global_exceeded",
-			Details:    map[string]interface{}{"limit": limit, "window": window.String()},
-		}
-	}
-
-	// Increment rate limit counter
-	if s.rateLimits != nil {
-		err = s.rateLimits.IncrementRateLimit(rateLimitKey, window)
-	} else {
-		err = s.db.IncrementRateLimit(rateLimitKey, window)
-	}
+	if !result.Allowed {
+		// Only rejections report the global limit; per-resource headers take precedence otherwise
+		SetRateLimitHeaders(ctx, result)
 
-	if err != nil {
-		return fmt.Errorf("failed to increment global rate limit: %w", err)
+		err := newRateLimitExceededError("global rate limit exceeded", result, nil)
+		err.I18nKey = "error.rate_limit.global_exceeded"
+		return err
 	}
 
 	return nil
@@ -1069,38 +1035,9 @@ func (s *securityManagerImpl) CheckGlobalRateLimit(ctx Context) error {
 
 // getClientIdentifier extracts a unique identifier for the client
 func (s *securityManagerImpl) getClientIdentifier(ctx Context) string {
-	// Try to get authenticated user ID first
-	user := ctx.User()
-	if user != nil && user.ID != "" {
-		return fmt.Sprintf("user:%s", user.ID)
-	}
-
-	// Fall back to IP address
-	req := ctx.Request()
-	if req != nil {
-		// Check for X-Forwarded-For header (proxy/load balancer)
-		if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
-			// Take the first IP in the chain
-			ips := strings.Split(xff, ",")
-			if len(ips) > 0 {
-				return fmt.Sprintf("ip:%s", strings.TrimSpace(ips[0]))
-			}
-		}
-
-		// Check for X-Real-IP header
-		if xri := req.Header.Get("X-Real-IP"); xri != "" {
-			return fmt.Sprintf("ip:%s", xri)
-		}
-
-		// Use RemoteAddr as fallback
-		if req.RemoteAddr != "" {
-			// Remove port if present
-			addr := req.RemoteAddr
-			if idx := strings.LastIndex(addr, ":"); idx != -1 {
-				addr = addr[:idx]
-			}
-			return fmt.Sprintf("ip:%s", addr)
-		}
+	// Authenticated user ID first, then IP address
+	if key := RateLimitKeyByUser(ctx); key != "" {
+		return key
 	}
 
 	// Ultimate fallback
@@ -1205,16 +1142,6 @@ func containsSQLInjection(input string) bool {
 	return false
 }
 
-// CleanupExpiredCSRFTokens removes expired CSRF tokens
-func (s *securityManagerImpl) CleanupExpiredCSRFTokens() {
-	now := time.Now()
-	for token, expiry := range s.csrfTokens {
-		if now.After(expiry) {
-			delete(s.csrfTokens, token)
-		}
-	}
-}
-
 // SafeError returns a safe error message for production mode
 // In production, sensitive details are hidden and only generic messages are shown
 func (s *securityManagerImpl) SafeError(err error) error {
@@ -941,33 +941,25 @@ func TestContainsHTML(t *testing.T) {
 	}
 }
 
-// Test CSRF token cleanup
-func TestCleanupExpiredCSRFTokens(t *testing.T) {
-	db := NewMockDatabaseManager()
+// Test CSRF token expiry
+func TestValidateCSRFToken_Expired(t *testing.T) {
+	sm := createTestSecurityManager(t)
 
-	config := DefaultSecurityConfig()
-	config.EncryptionKey = hex.EncodeToString([]byte("12345678901234567890123456789012"))
-	config.JWTSecret = "test-jwt-secret"
-	config.CSRFTokenExpiry = 1 * time.Millisecond
+	// Tokens carry their expiry with second precision, so a nanosecond expiry is already past
+	smImpl := sm.(*securityManagerImpl)
+	smImpl.config.CSRFTokenExpiry = time.Nanosecond
 
-	sm, err := NewSecurityManager(db, config)
+	token, err := smImpl.signCSRFToken("", "", "")
 	if err != nil {
-		t.Fatalf("Failed to create security manager: %v", err)
+		t.Fatalf("Failed to sign CSRF token: %v", err)
 	}
 
-	smImpl := sm.(*securityManagerImpl)
-
-	// Add some tokens
-	smImpl.csrfTokens["token1"] = time.Now().Add(-2 * time.Millisecond) // Expired
-	smImpl.csrfTokens["token2"] = time.Now().Add(1 * time.Hour)         // Valid
-
-	smImpl.CleanupExpiredCSRFTokens()
-
-	if _, exists := smImpl.csrfTokens["token1"]; exists {
-		t.Error("Expected expired token to be removed")
-	}
+	req := &Request{Header: http.Header{}}
+	req.Header.Set("Cookie", "csrf_token="+token)
+	ctx := createTestContext(t, req)
 
-	if _, exists := smImpl.csrfTokens["token2"]; !exists {
-		t.Error("Expected valid token to remain")
+	err = sm.ValidateCSRFToken(ctx, token)
+	if fwErr, ok := GetFrameworkError(err); !ok || fwErr.I18nKey != "error.csrf.token_expired" {
+		t.Errorf("Expected expired token error, got: %v", err)
 	}
 }
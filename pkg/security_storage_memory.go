@@ -148,10 +148,13 @@ func (s *inMemoryTokenStorage) Count() int {
 	return len(s.tokens)
 }
 
-// inMemoryRateLimitStorage implements rate limiting in memory
+// inMemoryRateLimitStorage implements rate limiting in memory. It also implements
+// RateLimitStore for the RateLimiter algorithms.
 type inMemoryRateLimitStorage struct {
-	mu     sync.RWMutex
-	limits map[string]*rateLimitEntry
+	mu      sync.RWMutex
+	limits  map[string]*rateLimitEntry
+	states  map[string]*rateLimitStateEntry
+	updates int
 }
 
 // rateLimitEntry stores rate limit information for a key
@@ -160,13 +163,71 @@ type rateLimitEntry struct {
 	expiresAt time.Time
 }
 
+// rateLimitStateEntry stores RateLimiter state for a key
+type rateLimitStateEntry struct {
+	state     RateLimitState
+	expiresAt time.Time
+}
+
+// inMemoryRateLimitSweepInterval is the number of updates between sweeps of expired state
+const inMemoryRateLimitSweepInterval = 1024
+
 // newInMemoryRateLimitStorage creates a new in-memory rate limit storage instance
 func newInMemoryRateLimitStorage() *inMemoryRateLimitStorage {
 	return &inMemoryRateLimitStorage{
 		limits: make(map[string]*rateLimitEntry),
+		states: make(map[string]*rateLimitStateEntry),
 	}
 }
 
+// NewMemoryRateLimitStore creates an in-memory RateLimitStore. State is local to
+// the process; use NewDatabaseRateLimitStore to share limits between instances.
+func NewMemoryRateLimitStore() RateLimitStore {
+	return newInMemoryRateLimitStorage()
+}
+
+// Update atomically applies fn to the state stored under key
+func (s *inMemoryRateLimitStorage) Update(key string, ttl time.Duration, fn func(state *RateLimitState) error) error {
+	if key == "" {
+		return errors.New("rate limit key is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	s.updates++
+	if s.updates%inMemoryRateLimitSweepInterval == 0 {
+		for k, entry := range s.states {
+			if entry.expiresAt.Before(now) {
+				delete(s.states, k)
+			}
+		}
+	}
+
+	var state RateLimitState
+	if entry, exists := s.states[key]; exists && !entry.expiresAt.Before(now) {
+		state = entry.state
+	}
+
+	if err := fn(&state); err != nil {
+		return err
+	}
+
+	s.states[key] = &rateLimitStateEntry{state: state, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+// Delete removes the RateLimiter state for key
+func (s *inMemoryRateLimitStorage) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.states, key)
+	return nil
+}
+
 // CheckRateLimit checks if a rate limit has been exceeded
 func (s *inMemoryRateLimitStorage) CheckRateLimit(key string, limit int, window time.Duration) (bool, error) {
 	if key == "" {
@@ -229,6 +290,11 @@ func (s *inMemoryRateLimitStorage) Cleanup() error {
 			delete(s.limits, key)
 		}
 	}
+	for key, entry := range s.states {
+		if entry.expiresAt.Before(now) {
+			delete(s.states, key)
+		}
+	}
 
 	return nil
 }
@@ -237,5 +303,5 @@ func (s *inMemoryRateLimitStorage) Cleanup() error {
 func (s *inMemoryRateLimitStorage) Count() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return len(s.limits)
+	return len(s.limits) + len(s.states)
 }
@@ -274,6 +274,7 @@ func (m *validationMockContext) Session() SessionManager                     { r
 func (m *validationMockContext) User() *User                                 { return nil }
 func (m *validationMockContext) Tenant() *Tenant                             { return nil }
 func (m *validationMockContext) DB() DatabaseManager                         { return nil }
+func (m *validationMockContext) Jobs() JobQueue                              { return nil }
 func (m *validationMockContext) Cache() CacheManager                         { return nil }
 func (m *validationMockContext) Config() ConfigManager                       { return nil }
 func (m *validationMockContext) I18n() I18nManager                           { return nil }
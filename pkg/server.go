@@ -153,8 +153,10 @@ type HostConfig struct {
 type RateLimitConfig struct {
 	Enabled           bool
 	RequestsPerSecond int
-	BurstSize         int
-	Storage           string // "memory", "database", "redis"
+	BurstSize         int                // Requests allowed at once (default: RequestsPerSecond)
+	Storage           string             // "memory", "database", "redis"
+	Algorithm         RateLimitAlgorithm // Default: token_bucket
+	KeyFunc           RateLimitKeyFunc   // Default: RateLimitKeyByIP
 }
 
 // ServerSecurityConfig holds security configuration for the server
@@ -167,6 +169,9 @@ type ServerSecurityConfig struct {
 	EnableXSS           bool
 	MaxRequestSize      int64
 	RequestTimeout      time.Duration
+
+	// Header overrides for this host; empty values fall back to SecurityConfig
+	SecurityHeadersConfig
 }
 
 // ServerManager manages multiple server instances
@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// hostMiddlewareCache holds the middleware built from each HostConfig, so
+// limiters keep their state across requests. It maps *HostConfig to
+// *hostMiddlewareEntry; requests to a known host take no lock.
+type hostMiddlewareCache struct {
+	entries sync.Map
+}
+
+// hostMiddlewareEntry is the middleware of one host, built once
+type hostMiddlewareEntry struct {
+	once       sync.Once
+	middleware []MiddlewareFunc
+}
+
+// hostConfig returns the configuration of the host a request was sent to,
+// from ServerConfig.HostConfigs or the hosts registered with the server
+// manager. Hosts are looked up with and without their port.
+func (s *httpServer) hostConfig(host string) *HostConfig {
+	names := []string{host}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		names = append(names, hostname)
+	}
+
+	for _, name := range names {
+		if config, ok := s.config.HostConfigs[name]; ok && config != nil {
+			return config
+		}
+		if s.hostLookup != nil {
+			if config, ok := s.hostLookup(name); ok && config != nil {
+				return config
+			}
+		}
+	}
+	return nil
+}
+
+// hostMiddleware returns the middleware a host's configuration asks for:
+// its security header overrides and its rate limits. The middleware is
+// built on the first request to the host.
+func (s *httpServer) hostMiddleware(host string) []MiddlewareFunc {
+	config := s.hostConfig(host)
+	if config == nil {
+		return nil
+	}
+
+	value, ok := s.hostCache.entries.Load(config)
+	if !ok {
+		value, _ = s.hostCache.entries.LoadOrStore(config, &hostMiddlewareEntry{})
+	}
+	entry := value.(*hostMiddlewareEntry)
+	entry.once.Do(func() {
+		entry.middleware = s.buildHostMiddleware(config)
+	})
+	return entry.middleware
+}
+
+// buildHostMiddleware creates the middleware of a host's configuration
+func (s *httpServer) buildHostMiddleware(config *HostConfig) []MiddlewareFunc {
+	var middleware []MiddlewareFunc
+	if config.SecurityConfig != nil && s.security != nil {
+		middleware = append(middleware, SecurityHeadersMiddleware(s.security, config.SecurityConfig))
+	}
+	if config.RateLimits != nil && config.RateLimits.Enabled {
+		limiter, err := NewHostRateLimiter(config.RateLimits, s.database)
+		if err != nil {
+			fmt.Printf("WARN: failed to create rate limiter for host %s: %v\n", config.Hostname, err)
+		} else {
+			middleware = append(middleware, RateLimitMiddleware(limiter))
+		}
+	}
+	return middleware
+}
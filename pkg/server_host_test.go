@@ -0,0 +1,134 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// newHostTestServer serves a router answering "ok" with the given host configs
+func newHostTestServer(t *testing.T, hosts map[string]*HostConfig) *httptest.Server {
+	t.Helper()
+	server := NewServer(ServerConfig{HostConfigs: hosts}).(*httpServer)
+	router := NewRouter()
+	router.GET("/", func(ctx Context) error {
+		return ctx.String(http.StatusOK, "ok")
+	})
+	server.SetRouter(router)
+	server.SetErrorHandler(func(ctx Context, err error) error {
+		status := http.StatusInternalServerError
+		if fwErr, ok := GetFrameworkError(err); ok {
+			status = fwErr.StatusCode
+		}
+		ctx.Response().WriteHeader(status)
+		return nil
+	})
+	return httptest.NewServer(server.createHandler())
+}
+
+// getHost requests url with the given Host header and returns the response
+func getHost(t *testing.T, url, host string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Host = host
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestServerHostRateLimits(t *testing.T) {
+	frontend := newHostTestServer(t, map[string]*HostConfig{
+		"limited.example.com": {
+			Hostname:   "limited.example.com",
+			RateLimits: &RateLimitConfig{Enabled: true, RequestsPerSecond: 1, BurstSize: 3},
+		},
+	})
+	defer frontend.Close()
+
+	// BurstSize requests are allowed at once, with or without a port in Host
+	for i, host := range []string{"limited.example.com", "limited.example.com:8080", "limited.example.com"} {
+		if resp := getHost(t, frontend.URL, host); resp.StatusCode != http.StatusOK || resp.Header.Get("RateLimit-Limit") != "3" {
+			t.Fatalf("Request %d: expected 200 with rate limit headers, got %d %v", i, resp.StatusCode, resp.Header)
+		}
+	}
+	if resp := getHost(t, frontend.URL, "limited.example.com"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 after the burst, got %d", resp.StatusCode)
+	}
+
+	// Other hosts are not limited
+	for i := 0; i < 5; i++ {
+		if resp := getHost(t, frontend.URL, "other.example.com"); resp.StatusCode != http.StatusOK || resp.Header.Get("RateLimit-Limit") != "" {
+			t.Fatalf("Expected other hosts to be unlimited, got %d %v", resp.StatusCode, resp.Header)
+		}
+	}
+}
+
+func TestServerHostRateLimitsConcurrentFirstRequests(t *testing.T) {
+	frontend := newHostTestServer(t, map[string]*HostConfig{
+		"limited.example.com": {
+			Hostname:   "limited.example.com",
+			RateLimits: &RateLimitConfig{Enabled: true, RequestsPerSecond: 1, BurstSize: 3},
+		},
+	})
+	defer frontend.Close()
+
+	// Concurrent first requests share one limiter
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, frontend.URL, nil)
+			req.Host = "limited.example.com"
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Errorf("Request failed: %v", err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed.Load() != 3 {
+		t.Errorf("Expected the burst of 3 requests to be allowed, got %d", allowed.Load())
+	}
+}
+
+func TestServerHostSecurityHeaders(t *testing.T) {
+	security, err := NewSecurityManager(nil, DefaultSecurityConfig())
+	if err != nil {
+		t.Fatalf("Failed to create security manager: %v", err)
+	}
+
+	sm := NewServerManager()
+	sm.RegisterHost("admin.example.com", HostConfig{
+		Hostname:       "admin.example.com",
+		SecurityConfig: &ServerSecurityConfig{SecurityHeadersConfig: SecurityHeadersConfig{ReferrerPolicy: "no-referrer"}},
+	})
+	server := sm.NewServer(ServerConfig{}).(*httpServer)
+	router := NewRouter()
+	router.GET("/", func(ctx Context) error {
+		return ctx.String(http.StatusOK, "ok")
+	})
+	server.SetRouter(router)
+	server.SetManagers(nil, nil, nil, nil, nil, nil, nil, security)
+	frontend := httptest.NewServer(server.createHandler())
+	defer frontend.Close()
+
+	if got := getHost(t, frontend.URL, "admin.example.com").Header.Get("Referrer-Policy"); got != "no-referrer" {
+		t.Errorf("Expected the host override of Referrer-Policy, got %q", got)
+	}
+	if got := getHost(t, frontend.URL, "www.example.com").Header.Get("Referrer-Policy"); got != "" {
+		t.Errorf("Expected no headers for hosts without security config, got %q", got)
+	}
+}
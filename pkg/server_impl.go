@@ -59,6 +59,17 @@ type httpServer struct {
 
 	// Plugin system
 	hookSystem HookSystem
+
+	// Background jobs
+	jobs JobQueue
+
+	// Files and the quotas they are metered against
+	files  FileManager
+	quotas QuotaManager
+
+	// Host configuration registered with the server manager
+	hostLookup func(hostname string) (*HostConfig, bool)
+	hostCache  hostMiddlewareCache
 }
 
 // NewServer creates a new HTTP server instance
@@ -576,6 +587,31 @@ func (s *httpServer) SetHookSystem(hookSystem HookSystem) Server {
 	return s
 }
 
+// SetJobQueue sets the background job queue exposed through Context.Jobs
+func (s *httpServer) SetJobQueue(jobs JobQueue) Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = jobs
+	return s
+}
+
+// SetFileManager sets the file manager exposed through Context.Files
+func (s *httpServer) SetFileManager(files FileManager) Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files = files
+	return s
+}
+
+// SetQuotaManager sets the quota manager that meters files written by a
+// request's tenant
+func (s *httpServer) SetQuotaManager(quotas QuotaManager) Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.quotas = quotas
+	return s
+}
+
 // Addr returns the server address
 func (s *httpServer) Addr() string {
 	s.mu.RLock()
@@ -763,6 +799,13 @@ func (s *httpServer) parseRequest(r *http.Request) (*Request, error) {
 
 // createContext creates a framework Context from the request
 func (s *httpServer) createContext(req *Request, respWriter ResponseWriter, httpReq *http.Request) Context {
+	// Give each request its own database view, bound to the request context so
+	// canceled requests abort their queries and reads see the request's writes
+	db := s.database
+	if scoped, ok := db.(requestScopedDatabase); ok {
+		db = scoped.forRequest(httpReq.Context())
+	}
+
 	return &contextImpl{
 		request:  req,
 		response: respWriter,
@@ -774,10 +817,13 @@ func (s *httpServer) createContext(req *Request, respWriter ResponseWriter, http
 		logger:   s.logger,
 		metrics:  s.metrics,
 		session:  s.session,
-		db:       s.database,
+		db:       db,
 		cache:    s.cache,
 		config:   s.configMgr,
 		i18n:     s.i18n,
+		jobs:     s.jobs,
+		files:    s.files,
+		quotas:   s.quotas,
 	}
 }
 
@@ -836,6 +882,22 @@ func (s *httpServer) executeHandler(ctx Context) error {
 		}
 	}
 
+	// Apply the middleware of the request's host (in reverse order)
+	hostMiddleware := s.hostMiddleware(req.Host)
+	for i := len(hostMiddleware) - 1; i >= 0; i-- {
+		mw := hostMiddleware[i]
+		next := handler
+		handler = func(ctx Context) error {
+			// Check for cancellation before each middleware
+			select {
+			case <-ctx.Context().Done():
+				return ctx.Context().Err()
+			default:
+			}
+			return mw(ctx, next)
+		}
+	}
+
 	// Apply global middleware (in reverse order)
 	for i := len(s.middleware) - 1; i >= 0; i-- {
 		mw := s.middleware[i]
@@ -5,12 +5,13 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -22,14 +23,38 @@ const (
 	SessionStorageDatabase   SessionStorageType = "database"
 	SessionStorageCache      SessionStorageType = "cache"
 	SessionStorageFilesystem SessionStorageType = "filesystem"
+	SessionStorageCookie     SessionStorageType = "cookie"
 )
 
+// SessionLimitPolicy decides how a session exceeding SessionConfig.MaxSessionsPerUser is handled
+type SessionLimitPolicy string
+
+const (
+	// SessionLimitEvictOldest deletes the oldest sessions of the user
+	SessionLimitEvictOldest SessionLimitPolicy = "evict_oldest"
+	// SessionLimitReject fails saving the new session with ErrSessionLimitExceeded
+	SessionLimitReject SessionLimitPolicy = "reject"
+)
+
+// ErrSessionLimitExceeded is returned when a user already has
+// SessionConfig.MaxSessionsPerUser sessions and the policy is SessionLimitReject
+var ErrSessionLimitExceeded = errors.New("maximum number of sessions per user exceeded")
+
+// ErrSessionUserIndexUnsupported is returned when the sessions of a user are
+// requested from a store that does not implement SessionUserIndex
+var ErrSessionUserIndexUnsupported = errors.New("session store cannot find the sessions of a user")
+
 // SessionConfig defines configuration for session management
 type SessionConfig struct {
-	// StorageType specifies the session storage backend.
+	// StorageType specifies the session storage backend, one of the built-in
+	// types or a type registered with RegisterSessionStore.
 	// Default: SessionStorageDatabase
 	StorageType SessionStorageType `json:"storage_type"`
 
+	// Store is a custom session store used instead of StorageType.
+	// Default: nil
+	Store SessionStore `json:"-"`
+
 	// CookieName is the name of the session cookie.
 	// Default: "rockstar_session"
 	CookieName string `json:"cookie_name"`
@@ -69,6 +94,51 @@ type SessionConfig struct {
 	// CleanupInterval is the interval for cleaning up expired sessions.
 	// Default: 1 hour
 	CleanupInterval time.Duration `json:"cleanup_interval"`
+
+	// IdleTimeout expires sessions that are not loaded for this long. Loading
+	// a session extends it, up to AbsoluteTimeout. When zero, sessions expire
+	// SessionLifetime after creation or Refresh.
+	// Default: 0 (disabled)
+	IdleTimeout time.Duration `json:"idle_timeout"`
+
+	// AbsoluteTimeout is the longest a session lasts after creation, however
+	// often it is refreshed.
+	// Default: 0 (disabled)
+	AbsoluteTimeout time.Duration `json:"absolute_timeout"`
+
+	// BindUserAgent rejects sessions presented with a different User-Agent
+	// header than the session was created with.
+	// Default: false
+	BindUserAgent bool `json:"bind_user_agent"`
+
+	// BindIPv4Prefix rejects sessions presented from an IPv4 address outside
+	// the network of this prefix length around the creating address, e.g. 24.
+	// Default: 0 (disabled)
+	BindIPv4Prefix int `json:"bind_ipv4_prefix"`
+
+	// BindIPv6Prefix is BindIPv4Prefix for IPv6 addresses, e.g. 64.
+	// Default: 0 (disabled)
+	BindIPv6Prefix int `json:"bind_ipv6_prefix"`
+
+	// MaxSessionsPerUser limits the concurrent sessions of a user. The
+	// session store must implement SessionUserIndex.
+	// Default: 0 (unlimited)
+	MaxSessionsPerUser int `json:"max_sessions_per_user"`
+
+	// SessionLimitPolicy decides what happens when saving a session would
+	// exceed MaxSessionsPerUser.
+	// Default: SessionLimitEvictOldest
+	SessionLimitPolicy SessionLimitPolicy `json:"session_limit_policy"`
+
+	// CookieChunkSize is the largest cookie value written by cookie storage.
+	// Larger sessions are split across several cookies.
+	// Default: 3800
+	CookieChunkSize int `json:"cookie_chunk_size"`
+
+	// CookieMaxChunks is the largest number of cookies a session is split
+	// into by cookie storage. Saving a larger session fails.
+	// Default: 5
+	CookieMaxChunks int `json:"cookie_max_chunks"`
 }
 
 // DefaultSessionConfig returns default session configuration
@@ -84,20 +154,20 @@ func DefaultSessionConfig() *SessionConfig {
 		SessionLifetime: 24 * time.Hour,
 		FilesystemPath:  "./sessions",
 		CleanupInterval: 1 * time.Hour,
+		CookieChunkSize: 3800,
+		CookieMaxChunks: 5,
+
+		SessionLimitPolicy: SessionLimitEvictOldest,
 	}
 }
 
 // sessionManager implements the SessionManager interface
 type sessionManager struct {
-	config        *SessionConfig
-	db            DatabaseManager
-	cache         CacheManager
-	cipher        cipher.Block
-	mu            sync.RWMutex
-	sessions      map[string]*Session // In-memory cache for filesystem storage
-	stopCleanup   chan struct{}
-	memoryStorage *inMemorySessionStorage // In-memory storage when no database is available
-	usingInMemory bool                    // Flag to indicate if using in-memory storage
+	config      *SessionConfig
+	store       SessionStore
+	cipher      cipher.Block
+	stopCleanup chan struct{}
+	stopOnce    sync.Once
 }
 
 // NewSessionManager creates a new session manager instance
@@ -120,30 +190,24 @@ func NewSessionManager(config *SessionConfig, db DatabaseManager, cache CacheMan
 		return nil, fmt.Errorf("failed to create cipher: %w", err)
 	}
 
+	store, err := newSessionStore(config, db, cache)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.MaxSessionsPerUser > 0 {
+		if _, ok := store.(SessionUserIndex); !ok {
+			return nil, fmt.Errorf("MaxSessionsPerUser: %w", ErrSessionUserIndexUnsupported)
+		}
+	}
+
 	sm := &sessionManager{
 		config:      config,
-		db:          db,
-		cache:       cache,
+		store:       store,
 		cipher:      block,
-		sessions:    make(map[string]*Session),
 		stopCleanup: make(chan struct{}),
 	}
 
-	// Check if database is available and storage type is database
-	if isNoopDatabase(db) && config.StorageType == SessionStorageDatabase {
-		// Switch to in-memory storage when no database is available and database storage is requested
-		sm.memoryStorage = newInMemorySessionStorage()
-		sm.usingInMemory = true
-		fmt.Println("WARN: SessionManager using in-memory storage. Sessions will not persist across restarts.")
-	} else {
-		// Create filesystem directory if using filesystem storage
-		if config.StorageType == SessionStorageFilesystem {
-			if err := os.MkdirAll(config.FilesystemPath, 0755); err != nil {
-				return nil, fmt.Errorf("failed to create session directory: %w", err)
-			}
-		}
-	}
-
 	// Start cleanup goroutine
 	go sm.cleanupLoop()
 
@@ -157,14 +221,86 @@ func (sm *sessionManager) Create(ctx Context) (*Session, error) {
 		return nil, fmt.Errorf("failed to generate session ID: %w", err)
 	}
 
+	session := sm.newSession(ctx, sessionID, make(map[string]interface{}))
+
+	// Save session to storage
+	if err := sm.save(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to save session: %w", err)
+	}
+
+	return session, nil
+}
+
+// SessionSecurityManager is implemented by session managers that can move a
+// session to a new ID and find and revoke the sessions of a user. The
+// session manager of the framework implements it; it is separate from
+// SessionManager so that existing implementations keep compiling.
+type SessionSecurityManager interface {
+	Regenerate(ctx Context) (*Session, error)
+	UserSessions(userID string) ([]*Session, error)
+	RevokeUserSessions(userID string) error
+}
+
+// Regenerate moves the session of the request to a new ID, deleting the old
+// one, and sets the session cookie. Call it whenever the privileges of the
+// session change, such as on login, to prevent session fixation. The data of
+// the session is kept and the user and tenant are taken from ctx when set.
+// The idle timeout starts over, while AbsoluteTimeout still counts from the
+// creation of the original session. Without a valid session a new one is
+// created.
+func (sm *sessionManager) Regenerate(ctx Context) (*Session, error) {
+	if ctx == nil {
+		return nil, errors.New("context is required")
+	}
+
+	sessionID, err := generateSessionID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+
+	data := make(map[string]interface{})
+	old, err := sm.GetSessionFromCookie(ctx)
+	if err == nil {
+		for k, v := range old.Data {
+			data[k] = v
+		}
+	}
+
+	session := sm.newSession(ctx, sessionID, data)
+	if old != nil {
+		session.CreatedAt = old.CreatedAt
+		session.ExpiresAt = sm.expiry(session, time.Now())
+		if session.UserID == "" {
+			session.UserID = old.UserID
+		}
+		if session.TenantID == "" {
+			session.TenantID = old.TenantID
+		}
+		if err := sm.store.Delete(ctx, old.ID); err != nil {
+			return nil, fmt.Errorf("failed to delete previous session: %w", err)
+		}
+	}
+
+	if err := sm.save(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to save session: %w", err)
+	}
+	if err := sm.SetCookie(ctx, session); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// newSession creates a session for the request of ctx, which may be nil
+func (sm *sessionManager) newSession(ctx Context, sessionID string, data map[string]interface{}) *Session {
 	now := time.Now()
 	session := &Session{
 		ID:        sessionID,
-		Data:      make(map[string]interface{}),
-		ExpiresAt: now.Add(sm.config.SessionLifetime),
+		Data:      data,
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
+	session.ExpiresAt = sm.expiry(session, now)
 
 	// Set user and tenant from context if available
 	if ctx != nil {
@@ -182,12 +318,7 @@ func (sm *sessionManager) Create(ctx Context) (*Session, error) {
 		}
 	}
 
-	// Save session to storage
-	if err := sm.saveToStorage(session); err != nil {
-		return nil, fmt.Errorf("failed to save session: %w", err)
-	}
-
-	return session, nil
+	return session
 }
 
 // Load loads a session by ID
@@ -196,18 +327,33 @@ func (sm *sessionManager) Load(ctx Context, sessionID string) (*Session, error)
 		return nil, errors.New("session ID is required")
 	}
 
-	session, err := sm.loadFromStorage(sessionID)
+	session, err := sm.store.Load(ctx, sessionID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load session: %w", err)
 	}
 
 	// Check if session is expired
-	if session.ExpiresAt.Before(time.Now()) {
+	now := time.Now()
+	if sm.expired(session, now) {
 		// Clean up expired session
 		_ = sm.Destroy(ctx, sessionID)
 		return nil, errors.New("session expired")
 	}
 
+	if err := sm.checkBinding(ctx, session); err != nil {
+		return nil, err
+	}
+
+	// Extend idle sessions, saving at most every tenth of the idle timeout
+	if sm.config.IdleTimeout > 0 {
+		if expires := sm.expiry(session, now); expires.Sub(session.ExpiresAt) >= sm.config.IdleTimeout/10 {
+			session.ExpiresAt = expires
+			if err := sm.store.Save(ctx, session); err != nil {
+				return nil, fmt.Errorf("failed to save session: %w", err)
+			}
+		}
+	}
+
 	return session, nil
 }
 
@@ -219,7 +365,7 @@ func (sm *sessionManager) Save(ctx Context, session *Session) error {
 
 	session.UpdatedAt = time.Now()
 
-	if err := sm.saveToStorage(session); err != nil {
+	if err := sm.save(ctx, session); err != nil {
 		return fmt.Errorf("failed to save session: %w", err)
 	}
 
@@ -232,7 +378,7 @@ func (sm *sessionManager) Destroy(ctx Context, sessionID string) error {
 		return errors.New("session ID is required")
 	}
 
-	if err := sm.deleteFromStorage(sessionID); err != nil {
+	if err := sm.store.Delete(ctx, sessionID); err != nil {
 		return fmt.Errorf("failed to destroy session: %w", err)
 	}
 
@@ -246,13 +392,14 @@ func (sm *sessionManager) Refresh(ctx Context, sessionID string) error {
 		return err
 	}
 
-	session.ExpiresAt = time.Now().Add(sm.config.SessionLifetime)
+	session.ExpiresAt = sm.expiry(session, time.Now())
 	return sm.Save(ctx, session)
 }
 
-// Get retrieves a value from session data
+// Get retrieves a value from session data, as its registered type if
+// RegisterSessionType declared one for key
 func (sm *sessionManager) Get(sessionID, key string) (interface{}, error) {
-	session, err := sm.loadFromStorage(sessionID)
+	session, err := sm.store.Load(nil, sessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -262,12 +409,12 @@ func (sm *sessionManager) Get(sessionID, key string) (interface{}, error) {
 		return nil, fmt.Errorf("key %s not found in session", key)
 	}
 
-	return value, nil
+	return decodeSessionValue(key, value)
 }
 
 // Set sets a value in session data
 func (sm *sessionManager) Set(sessionID, key string, value interface{}) error {
-	session, err := sm.loadFromStorage(sessionID)
+	session, err := sm.store.Load(nil, sessionID)
 	if err != nil {
 		return err
 	}
@@ -275,12 +422,12 @@ func (sm *sessionManager) Set(sessionID, key string, value interface{}) error {
 	session.Data[key] = value
 	session.UpdatedAt = time.Now()
 
-	return sm.saveToStorage(session)
+	return sm.store.Save(nil, session)
 }
 
 // Delete deletes a key from session data
 func (sm *sessionManager) Delete(sessionID, key string) error {
-	session, err := sm.loadFromStorage(sessionID)
+	session, err := sm.store.Load(nil, sessionID)
 	if err != nil {
 		return err
 	}
@@ -288,12 +435,12 @@ func (sm *sessionManager) Delete(sessionID, key string) error {
 	delete(session.Data, key)
 	session.UpdatedAt = time.Now()
 
-	return sm.saveToStorage(session)
+	return sm.store.Save(nil, session)
 }
 
 // Clear clears all data from a session
 func (sm *sessionManager) Clear(sessionID string) error {
-	session, err := sm.loadFromStorage(sessionID)
+	session, err := sm.store.Load(nil, sessionID)
 	if err != nil {
 		return err
 	}
@@ -301,7 +448,7 @@ func (sm *sessionManager) Clear(sessionID string) error {
 	session.Data = make(map[string]interface{})
 	session.UpdatedAt = time.Now()
 
-	return sm.saveToStorage(session)
+	return sm.store.Save(nil, session)
 }
 
 // SetCookie sets an encrypted session cookie
@@ -317,16 +464,8 @@ func (sm *sessionManager) SetCookie(ctx Context, session *Session) error {
 	}
 
 	// Create cookie
-	cookie := &Cookie{
-		Name:      sm.config.CookieName,
-		Value:     encryptedID,
-		Path:      sm.config.CookiePath,
-		Domain:    sm.config.CookieDomain,
-		Expires:   session.ExpiresAt,
-		Secure:    sm.config.CookieSecure,
-		HttpOnly:  sm.config.CookieHTTPOnly,
-		Encrypted: true,
-	}
+	cookie := sm.config.cookie(sm.config.CookieName, encryptedID, session.ExpiresAt)
+	cookie.Encrypted = true
 
 	return ctx.SetCookie(cookie)
 }
@@ -355,260 +494,213 @@ func (sm *sessionManager) GetSessionFromCookie(ctx Context) (*Session, error) {
 
 // IsValid checks if a session is valid
 func (sm *sessionManager) IsValid(sessionID string) bool {
-	session, err := sm.loadFromStorage(sessionID)
+	session, err := sm.store.Load(nil, sessionID)
 	if err != nil {
 		return false
 	}
 
-	return !session.ExpiresAt.Before(time.Now())
+	return !sm.expired(session, time.Now())
 }
 
 // IsExpired checks if a session is expired
 func (sm *sessionManager) IsExpired(sessionID string) bool {
-	session, err := sm.loadFromStorage(sessionID)
+	session, err := sm.store.Load(nil, sessionID)
 	if err != nil {
 		return true
 	}
 
-	return session.ExpiresAt.Before(time.Now())
+	return sm.expired(session, time.Now())
 }
 
 // CleanupExpired removes expired sessions
 func (sm *sessionManager) CleanupExpired() error {
-	// Use in-memory storage cleanup if no database is available
-	if sm.usingInMemory {
-		return sm.memoryStorage.Cleanup()
-	}
-
-	switch sm.config.StorageType {
-	case SessionStorageDatabase:
-		if sm.db != nil {
-			return sm.db.CleanupExpiredSessions()
-		}
-	case SessionStorageCache:
-		// Cache typically handles expiration automatically
-		return nil
-	case SessionStorageFilesystem:
-		return sm.cleanupFilesystemSessions()
-	}
-
-	return nil
+	return sm.store.Cleanup()
 }
 
-// Storage backend methods
-
-func (sm *sessionManager) saveToStorage(session *Session) error {
-	// Use in-memory storage if no database is available
-	if sm.usingInMemory {
-		return sm.memoryStorage.Save(session)
+// UserSessions returns the unexpired sessions of the user, oldest first
+func (sm *sessionManager) UserSessions(userID string) ([]*Session, error) {
+	index, ok := sm.store.(SessionUserIndex)
+	if !ok {
+		return nil, ErrSessionUserIndexUnsupported
 	}
 
-	switch sm.config.StorageType {
-	case SessionStorageDatabase:
-		if sm.db == nil {
-			return errors.New("database manager not configured")
-		}
-		return sm.db.SaveSession(session)
+	sessions, err := index.UserSessions(userID)
+	if err != nil {
+		return nil, err
+	}
 
-	case SessionStorageCache:
-		if sm.cache == nil {
-			return errors.New("cache manager not configured")
+	now := time.Now()
+	live := sessions[:0]
+	for _, session := range sessions {
+		if !sm.expired(session, now) {
+			live = append(live, session)
 		}
-		ttl := time.Until(session.ExpiresAt)
-		return sm.cache.Set(sessionKey(session.ID), session, ttl)
-
-	case SessionStorageFilesystem:
-		return sm.saveToFilesystem(session)
-
-	default:
-		return fmt.Errorf("unsupported storage type: %s", sm.config.StorageType)
 	}
+	sort.SliceStable(live, func(i, j int) bool {
+		return live[i].CreatedAt.Before(live[j].CreatedAt)
+	})
+	return live, nil
 }
 
-func (sm *sessionManager) loadFromStorage(sessionID string) (*Session, error) {
-	// Use in-memory storage if no database is available
-	if sm.usingInMemory {
-		return sm.memoryStorage.Load(sessionID)
+// RevokeUserSessions deletes every session of the user, logging the user
+// out everywhere
+func (sm *sessionManager) RevokeUserSessions(userID string) error {
+	if userID == "" {
+		return errors.New("user ID is required")
 	}
 
-	switch sm.config.StorageType {
-	case SessionStorageDatabase:
-		if sm.db == nil {
-			return nil, errors.New("database manager not configured")
-		}
-		return sm.db.LoadSession(sessionID)
+	sessions, err := sm.UserSessions(userID)
+	if err != nil {
+		return err
+	}
 
-	case SessionStorageCache:
-		if sm.cache == nil {
-			return nil, errors.New("cache manager not configured")
-		}
-		value, err := sm.cache.Get(sessionKey(sessionID))
-		if err != nil {
-			return nil, err
+	var errs []error
+	for _, session := range sessions {
+		if err := sm.store.Delete(nil, session.ID); err != nil {
+			errs = append(errs, err)
 		}
-		session, ok := value.(*Session)
-		if !ok {
-			return nil, errors.New("invalid session data in cache")
-		}
-		return session, nil
-
-	case SessionStorageFilesystem:
-		return sm.loadFromFilesystem(sessionID)
-
-	default:
-		return nil, fmt.Errorf("unsupported storage type: %s", sm.config.StorageType)
 	}
+	return errors.Join(errs...)
 }
 
-func (sm *sessionManager) deleteFromStorage(sessionID string) error {
-	// Use in-memory storage if no database is available
-	if sm.usingInMemory {
-		return sm.memoryStorage.Delete(sessionID)
-	}
-
-	switch sm.config.StorageType {
-	case SessionStorageDatabase:
-		if sm.db == nil {
-			return errors.New("database manager not configured")
-		}
-		return sm.db.DeleteSession(sessionID)
-
-	case SessionStorageCache:
-		if sm.cache == nil {
-			return errors.New("cache manager not configured")
+// save enforces MaxSessionsPerUser and saves the session
+func (sm *sessionManager) save(ctx Context, session *Session) error {
+	if sm.config.MaxSessionsPerUser > 0 && session.UserID != "" {
+		if err := sm.enforceSessionLimit(session); err != nil {
+			return err
 		}
-		return sm.cache.Delete(sessionKey(sessionID))
-
-	case SessionStorageFilesystem:
-		return sm.deleteFromFilesystem(sessionID)
-
-	default:
-		return fmt.Errorf("unsupported storage type: %s", sm.config.StorageType)
 	}
+	return sm.store.Save(ctx, session)
 }
 
-// Filesystem storage methods
-
-func (sm *sessionManager) saveToFilesystem(session *Session) error {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-
-	data, err := json.Marshal(session)
+// enforceSessionLimit makes room for session among the sessions of its user
+func (sm *sessionManager) enforceSessionLimit(session *Session) error {
+	sessions, err := sm.UserSessions(session.UserID)
 	if err != nil {
-		return fmt.Errorf("failed to marshal session: %w", err)
+		return err
 	}
 
-	// Validate session ID to prevent path traversal
-	validator := NewPathValidator(sm.config.FilesystemPath)
-	filename, err := validator.ResolvePath(session.ID + ".json")
-	if err != nil {
-		return fmt.Errorf("invalid session ID: %w", err)
+	others := make([]*Session, 0, len(sessions))
+	for _, other := range sessions {
+		if other.ID != session.ID {
+			others = append(others, other)
+		}
 	}
-
-	if err := os.WriteFile(filename, data, 0600); err != nil {
-		return fmt.Errorf("failed to write session file: %w", err)
+	excess := len(others) + 1 - sm.config.MaxSessionsPerUser
+	if excess <= 0 {
+		return nil
 	}
 
-	sm.sessions[session.ID] = session
+	// Sessions already counted are saved even if the limit was lowered since
+	if sm.config.SessionLimitPolicy == SessionLimitReject && len(others) == len(sessions) {
+		return ErrSessionLimitExceeded
+	}
+	for _, other := range others[:excess] {
+		if err := sm.store.Delete(nil, other.ID); err != nil {
+			return fmt.Errorf("failed to evict session: %w", err)
+		}
+	}
 	return nil
 }
 
-func (sm *sessionManager) loadFromFilesystem(sessionID string) (*Session, error) {
-	sm.mu.RLock()
-	if session, exists := sm.sessions[sessionID]; exists {
-		sm.mu.RUnlock()
-		return session, nil
+// expiry returns the expiration time of session when extended at now
+func (sm *sessionManager) expiry(session *Session, now time.Time) time.Time {
+	lifetime := sm.config.SessionLifetime
+	if sm.config.IdleTimeout > 0 {
+		lifetime = sm.config.IdleTimeout
 	}
-	sm.mu.RUnlock()
 
-	// Validate session ID to prevent path traversal
-	validator := NewPathValidator(sm.config.FilesystemPath)
-	filename, err := validator.ResolvePath(sessionID + ".json")
-	if err != nil {
-		return nil, fmt.Errorf("invalid session ID: %w", err)
-	}
-
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, errors.New("session not found")
+	expires := now.Add(lifetime)
+	if sm.config.AbsoluteTimeout > 0 {
+		if deadline := session.CreatedAt.Add(sm.config.AbsoluteTimeout); deadline.Before(expires) {
+			expires = deadline
 		}
-		return nil, fmt.Errorf("failed to read session file: %w", err)
 	}
+	return expires
+}
 
-	var session Session
-	if err := json.Unmarshal(data, &session); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal session: %w", err)
+// expired reports whether session is past its idle or absolute timeout
+func (sm *sessionManager) expired(session *Session, now time.Time) bool {
+	if session.ExpiresAt.Before(now) {
+		return true
 	}
-
-	sm.mu.Lock()
-	sm.sessions[sessionID] = &session
-	sm.mu.Unlock()
-
-	return &session, nil
+	return sm.config.AbsoluteTimeout > 0 && session.CreatedAt.Add(sm.config.AbsoluteTimeout).Before(now)
 }
 
-func (sm *sessionManager) deleteFromFilesystem(sessionID string) error {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-
-	delete(sm.sessions, sessionID)
+// checkBinding rejects sessions presented by another client than the one
+// that created them, as far as configured. The address is the remote address
+// of the connection, as when the session was created, since forwarding
+// headers can be set by any client.
+func (sm *sessionManager) checkBinding(ctx Context, session *Session) error {
+	if ctx == nil || ctx.Request() == nil {
+		return nil
+	}
 
-	// Validate session ID to prevent path traversal
-	validator := NewPathValidator(sm.config.FilesystemPath)
-	filename, err := validator.ResolvePath(sessionID + ".json")
-	if err != nil {
-		return fmt.Errorf("invalid session ID: %w", err)
+	if sm.config.BindUserAgent && ctx.GetHeader("User-Agent") != session.UserAgent {
+		return NewSessionError(ErrCodeSessionInvalid, "session is bound to another user agent")
 	}
 
-	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to delete session file: %w", err)
+	if sm.config.BindIPv4Prefix > 0 || sm.config.BindIPv6Prefix > 0 {
+		created, ok := parseClientAddr(session.IPAddress)
+		if !ok {
+			return nil
+		}
+		bits := sm.config.BindIPv4Prefix
+		if created.Is6() {
+			bits = sm.config.BindIPv6Prefix
+		}
+		if bits <= 0 {
+			return nil
+		}
+
+		current, ok := parseClientAddr(ctx.Request().RemoteAddr)
+		network, err := created.Prefix(bits)
+		if !ok || err != nil || !network.Contains(current) {
+			return NewSessionError(ErrCodeSessionInvalid, "session is bound to another network")
+		}
 	}
 
 	return nil
 }
 
-func (sm *sessionManager) cleanupFilesystemSessions() error {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-
-	files, err := os.ReadDir(sm.config.FilesystemPath)
+// parseClientAddr parses an IP address with optional port and brackets
+func parseClientAddr(addr string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(addr); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	ip, err := netip.ParseAddr(strings.Trim(addr, "[]"))
 	if err != nil {
-		return fmt.Errorf("failed to read session directory: %w", err)
+		return netip.Addr{}, false
 	}
+	return ip.Unmap(), true
+}
 
-	now := time.Now()
-	for _, file := range files {
-		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
-			continue
-		}
-
-		sessionID := file.Name()[:len(file.Name())-5] // Remove .json extension
-		if session, exists := sm.sessions[sessionID]; exists {
-			if session.ExpiresAt.Before(now) {
-				delete(sm.sessions, sessionID)
-				os.Remove(filepath.Join(sm.config.FilesystemPath, file.Name()))
-			}
-		} else {
-			// Load and check expiration
-			filename := filepath.Join(sm.config.FilesystemPath, file.Name())
-			data, err := os.ReadFile(filename)
-			if err != nil {
-				continue
-			}
-
-			var session Session
-			if err := json.Unmarshal(data, &session); err != nil {
-				continue
-			}
-
-			if session.ExpiresAt.Before(now) {
-				os.Remove(filename)
-			}
-		}
+// cookie creates a session cookie with the configured attributes
+func (c *SessionConfig) cookie(name, value string, expires time.Time) *Cookie {
+	return &Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     c.CookiePath,
+		Domain:   c.CookieDomain,
+		Expires:  expires,
+		Secure:   c.CookieSecure,
+		HttpOnly: c.CookieHTTPOnly,
+		SameSite: parseSameSite(c.CookieSameSite),
 	}
+}
 
-	return nil
+// parseSameSite converts a SameSite attribute name to http.SameSite
+func parseSameSite(value string) http.SameSite {
+	switch strings.ToLower(value) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteDefaultMode
+	}
 }
 
 // Encryption methods
@@ -683,7 +775,7 @@ func (sm *sessionManager) cleanupLoop() {
 
 // Stop stops the session manager and cleanup goroutine
 func (sm *sessionManager) Stop() {
-	close(sm.stopCleanup)
+	sm.stopOnce.Do(func() { close(sm.stopCleanup) })
 }
 
 // Helper functions
@@ -699,3 +791,7 @@ func generateSessionID() (string, error) {
 func sessionKey(sessionID string) string {
 	return "session:" + sessionID
 }
+
+func userSessionsKey(userID string) string {
+	return "session_user:" + userID
+}
@@ -28,7 +28,7 @@ func (ss *sessionStorage) SaveSession(session *Session) error {
 	}
 
 	// Get the SQL query from the loader
-	dm, ok := ss.db.(*databaseManager)
+	dm, ok := unwrapDatabaseManager(ss.db)
 	if !ok {
 		return fmt.Errorf("invalid database manager type")
 	}
@@ -59,7 +59,7 @@ func (ss *sessionStorage) SaveSession(session *Session) error {
 // LoadSession loads a session from the database
 func (ss *sessionStorage) LoadSession(sessionID string) (*Session, error) {
 	// Get the SQL query from the loader
-	dm, ok := ss.db.(*databaseManager)
+	dm, ok := unwrapDatabaseManager(ss.db)
 	if !ok {
 		return nil, fmt.Errorf("invalid database manager type")
 	}
@@ -73,7 +73,7 @@ func (ss *sessionStorage) LoadSession(sessionID string) (*Session, error) {
 		return nil, fmt.Errorf("failed to load load_session query: %w", err)
 	}
 
-	row := ss.db.QueryRow(query, sessionID, time.Now())
+	row := dm.primaryQueryRow(query, sessionID, time.Now())
 
 	session := &Session{}
 	var dataJSON string
@@ -99,7 +99,7 @@ func (ss *sessionStorage) LoadSession(sessionID string) (*Session, error) {
 // DeleteSession deletes a session from the database
 func (ss *sessionStorage) DeleteSession(sessionID string) error {
 	// Get the SQL query from the loader
-	dm, ok := ss.db.(*databaseManager)
+	dm, ok := unwrapDatabaseManager(ss.db)
 	if !ok {
 		return fmt.Errorf("invalid database manager type")
 	}
@@ -120,7 +120,7 @@ func (ss *sessionStorage) DeleteSession(sessionID string) error {
 // CleanupExpiredSessions removes expired sessions from the database
 func (ss *sessionStorage) CleanupExpiredSessions() error {
 	// Get the SQL query from the loader
-	dm, ok := ss.db.(*databaseManager)
+	dm, ok := unwrapDatabaseManager(ss.db)
 	if !ok {
 		return fmt.Errorf("invalid database manager type")
 	}
@@ -0,0 +1,223 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSessionCookieTooLarge is returned when an encrypted session does not
+// fit into SessionConfig.CookieMaxChunks cookies
+var ErrSessionCookieTooLarge = errors.New("session too large for cookie storage")
+
+// cookieSessionStore implements SessionStore without server-side state. The
+// session is encrypted and authenticated with AES-GCM, like EncryptCookie,
+// and sent to the client in one or more cookies next to the session ID
+// cookie. Sessions cannot be revoked on the server: a client can present a
+// copy of an older cookie until it expires.
+type cookieSessionStore struct {
+	config *SessionConfig
+	key    []byte
+}
+
+// cookieSessionState is the session written during the current request,
+// which the request cookies do not contain yet
+type cookieSessionState struct {
+	data   []byte // JSON encoded session, nil once deleted
+	chunks int    // Number of cookies sent to the client
+}
+
+// NewCookieSessionStore creates a SessionStore keeping sessions in encrypted
+// cookies. Cookie names and attributes, the encryption key and the chunking
+// limits are taken from config, which must have its defaults applied.
+func NewCookieSessionStore(config *SessionConfig) (SessionStore, error) {
+	if len(config.EncryptionKey) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes for AES-256")
+	}
+	return &cookieSessionStore{config: config, key: config.EncryptionKey}, nil
+}
+
+func newCookieSessionStoreFromConfig(config *SessionConfig, db DatabaseManager, cache CacheManager) (SessionStore, error) {
+	return NewCookieSessionStore(config)
+}
+
+func (s *cookieSessionStore) Save(ctx Context, session *Session) error {
+	if ctx == nil {
+		return ErrSessionContextRequired
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+	value, err := encryptCookie(s.key, string(data))
+	if err != nil {
+		return err
+	}
+
+	size := s.config.CookieChunkSize
+	chunks := (len(value) + size - 1) / size
+	if chunks > s.config.CookieMaxChunks {
+		return fmt.Errorf("%w: %d bytes in %d cookies, limit is %d", ErrSessionCookieTooLarge, len(value), chunks, s.config.CookieMaxChunks)
+	}
+
+	previous := s.sentChunks(ctx)
+	for i := 0; i < chunks; i++ {
+		part := value[i*size : min((i+1)*size, len(value))]
+		if i == 0 {
+			part = strconv.Itoa(chunks) + "." + part
+		}
+		if err := ctx.SetCookie(s.config.cookie(s.chunkName(i), part, session.ExpiresAt)); err != nil {
+			return err
+		}
+	}
+	if err := s.expire(ctx, chunks, previous); err != nil {
+		return err
+	}
+
+	ctx.Set(s.stateKey(), &cookieSessionState{data: data, chunks: chunks})
+	return nil
+}
+
+func (s *cookieSessionStore) Load(ctx Context, sessionID string) (*Session, error) {
+	if ctx == nil {
+		return nil, ErrSessionContextRequired
+	}
+
+	var data []byte
+	if state, ok := s.state(ctx); ok {
+		data = state.data
+	} else {
+		value, err := s.read(ctx)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := decryptCookie(s.key, value)
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(plaintext)
+	}
+	if data == nil {
+		return nil, errors.New("session not found")
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session: %w", err)
+	}
+	if session.ID != sessionID {
+		return nil, errors.New("session not found")
+	}
+	if session.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("session expired")
+	}
+	return &session, nil
+}
+
+func (s *cookieSessionStore) Delete(ctx Context, sessionID string) error {
+	if ctx == nil {
+		return ErrSessionContextRequired
+	}
+	if err := s.expire(ctx, 0, max(s.sentChunks(ctx), 1)); err != nil {
+		return err
+	}
+	ctx.Set(s.stateKey(), &cookieSessionState{})
+	return nil
+}
+
+// Cleanup does nothing, expired sessions are rejected by Load and their
+// cookies expire in the browser
+func (s *cookieSessionStore) Cleanup() error {
+	return nil
+}
+
+// read joins the session cookies of the request
+func (s *cookieSessionStore) read(ctx Context) (string, error) {
+	first, err := ctx.GetCookie(s.chunkName(0))
+	if err != nil {
+		return "", errors.New("session not found")
+	}
+	chunks, value, err := parseSessionChunk(first.Value, s.config.CookieMaxChunks)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(value)
+	for i := 1; i < chunks; i++ {
+		cookie, err := ctx.GetCookie(s.chunkName(i))
+		if err != nil {
+			return "", fmt.Errorf("session cookie %d of %d is missing", i+1, chunks)
+		}
+		b.WriteString(cookie.Value)
+	}
+	return b.String(), nil
+}
+
+// sentChunks returns the number of cookies the client holds or will hold
+// after this response
+func (s *cookieSessionStore) sentChunks(ctx Context) int {
+	if state, ok := s.state(ctx); ok {
+		return state.chunks
+	}
+	first, err := ctx.GetCookie(s.chunkName(0))
+	if err != nil {
+		return 0
+	}
+	chunks, _, err := parseSessionChunk(first.Value, s.config.CookieMaxChunks)
+	if err != nil {
+		return 1
+	}
+	return chunks
+}
+
+// expire deletes the cookies with indexes from..to-1
+func (s *cookieSessionStore) expire(ctx Context, from, to int) error {
+	for i := from; i < to; i++ {
+		cookie := s.config.cookie(s.chunkName(i), "", time.Unix(0, 0))
+		cookie.MaxAge = -1
+		if err := ctx.SetCookie(cookie); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *cookieSessionStore) state(ctx Context) (*cookieSessionState, bool) {
+	value, ok := ctx.Get(s.stateKey())
+	if !ok {
+		return nil, false
+	}
+	state, ok := value.(*cookieSessionState)
+	return state, ok
+}
+
+func (s *cookieSessionStore) stateKey() string {
+	return "rockstar.session_cookie." + s.config.CookieName
+}
+
+// chunkName returns the name of the i-th session cookie
+func (s *cookieSessionStore) chunkName(i int) string {
+	if i == 0 {
+		return s.config.CookieName + "_data"
+	}
+	return s.config.CookieName + "_data_" + strconv.Itoa(i)
+}
+
+// parseSessionChunk splits the first session cookie into the number of
+// cookies and its part of the encrypted session
+func parseSessionChunk(value string, maxChunks int) (int, string, error) {
+	count, rest, ok := strings.Cut(value, ".")
+	if !ok {
+		return 0, "", errors.New("invalid session cookie")
+	}
+	chunks, err := strconv.Atoi(count)
+	if err != nil || chunks < 1 || chunks > maxChunks {
+		return 0, "", errors.New("invalid session cookie")
+	}
+	return chunks, rest, nil
+}
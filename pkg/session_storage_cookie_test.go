@@ -0,0 +1,197 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newCookieSessionManager creates a session manager with cookie storage
+func newCookieSessionManager(t *testing.T, key []byte, chunkSize int) *sessionManager {
+	t.Helper()
+	config := DefaultSessionConfig()
+	config.StorageType = SessionStorageCookie
+	config.EncryptionKey = key
+	config.CookieChunkSize = chunkSize
+	sm, err := NewSessionManager(config, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session manager: %v", err)
+	}
+	t.Cleanup(sm.(*sessionManager).Stop)
+	return sm.(*sessionManager)
+}
+
+// newCookieRequestContext creates a context for a request sending the
+// cookies set on rec, or no cookies if rec is nil
+func newCookieRequestContext(rec *httptest.ResponseRecorder) (Context, *httptest.ResponseRecorder) {
+	header := http.Header{}
+	if rec != nil {
+		// Like a browser, later cookies replace earlier ones of the same name
+		jar := map[string]*http.Cookie{}
+		for _, cookie := range rec.Result().Cookies() {
+			jar[cookie.Name] = cookie
+		}
+		var pairs []string
+		for name, cookie := range jar {
+			if cookie.MaxAge >= 0 {
+				pairs = append(pairs, name+"="+cookie.Value)
+			}
+		}
+		header.Set("Cookie", strings.Join(pairs, "; "))
+	}
+	next := httptest.NewRecorder()
+	req := &Request{Method: http.MethodGet, Header: header}
+	return NewContext(req, NewResponseWriter(next), context.Background()), next
+}
+
+func TestCookieSessionStore_RoundTrip(t *testing.T) {
+	key := generateEncryptionKey()
+	sm := newCookieSessionManager(t, key, 3800)
+
+	ctx, rec := newCookieRequestContext(nil)
+	session, err := sm.Create(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	session.Data["user"] = "ada"
+	if err := sm.Save(ctx, session); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+	if err := sm.SetCookie(ctx, session); err != nil {
+		t.Fatalf("Failed to set cookie: %v", err)
+	}
+
+	// The session saved during the request is visible before it reaches the client
+	if loaded, err := sm.Load(ctx, session.ID); err != nil || loaded.Data["user"] != "ada" {
+		t.Fatalf("Expected the saved session in the same request, got %+v, %v", loaded, err)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if strings.Contains(cookie.Value, "ada") {
+			t.Errorf("Expected cookie %s to be encrypted, got %q", cookie.Name, cookie.Value)
+		}
+		if cookie.SameSite != http.SameSiteLaxMode || !cookie.HttpOnly || !cookie.Secure {
+			t.Errorf("Expected the configured attributes on cookie %s, got %+v", cookie.Name, cookie)
+		}
+	}
+
+	// Another instance with the same key reads the session from the cookies alone
+	other := newCookieSessionManager(t, key, 3800)
+	next, _ := newCookieRequestContext(rec)
+	loaded, err := other.GetSessionFromCookie(next)
+	if err != nil {
+		t.Fatalf("Failed to load session from cookies: %v", err)
+	}
+	if loaded.ID != session.ID || loaded.Data["user"] != "ada" {
+		t.Errorf("Expected the saved session, got %+v", loaded)
+	}
+
+	// Without a request context there is no session to access
+	if _, err := sm.Get(session.ID, "user"); !errors.Is(err, ErrSessionContextRequired) {
+		t.Errorf("Expected ErrSessionContextRequired, got %v", err)
+	}
+}
+
+func TestCookieSessionStore_Chunking(t *testing.T) {
+	sm := newCookieSessionManager(t, generateEncryptionKey(), 1000)
+
+	ctx, rec := newCookieRequestContext(nil)
+	session, _ := sm.Create(ctx)
+	session.Data["notes"] = strings.Repeat("rockstar ", 300)
+	if err := sm.Save(ctx, session); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	next, nextRec := newCookieRequestContext(rec)
+	names := map[string]bool{}
+	for _, cookie := range rec.Result().Cookies() {
+		if strings.HasPrefix(cookie.Name, "rockstar_session_data") {
+			names[cookie.Name] = true
+			if len(cookie.Value) > 1000+len("4.") {
+				t.Errorf("Expected chunks of at most 1000 bytes, got %d", len(cookie.Value))
+			}
+		}
+	}
+	chunks := len(names)
+	if chunks < 3 {
+		t.Fatalf("Expected the session to be split into several cookies, got %d", chunks)
+	}
+	loaded, err := sm.Load(next, session.ID)
+	if err != nil || loaded.Data["notes"] != session.Data["notes"] {
+		t.Fatalf("Expected the chunked session, got %v", err)
+	}
+
+	// Shrinking the session expires the cookies no longer needed
+	loaded.Data["notes"] = "short"
+	if err := sm.Save(next, loaded); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+	expired := 0
+	for _, cookie := range nextRec.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			expired++
+		}
+	}
+	if expired != chunks-1 {
+		t.Errorf("Expected %d expired cookies, got %d", chunks-1, expired)
+	}
+	final, _ := newCookieRequestContext(nextRec)
+	if loaded, err := sm.Load(final, session.ID); err != nil || loaded.Data["notes"] != "short" {
+		t.Errorf("Expected the shrunk session, got %v", err)
+	}
+
+	// Sessions larger than CookieMaxChunks cookies are rejected
+	session.Data["notes"] = strings.Repeat("x", 10000)
+	if err := sm.Save(ctx, session); !errors.Is(err, ErrSessionCookieTooLarge) {
+		t.Errorf("Expected ErrSessionCookieTooLarge, got %v", err)
+	}
+}
+
+func TestCookieSessionStore_RejectsTamperedCookies(t *testing.T) {
+	sm := newCookieSessionManager(t, generateEncryptionKey(), 3800)
+
+	ctx, rec := newCookieRequestContext(nil)
+	session, _ := sm.Create(ctx)
+
+	// Cookies encrypted with another key are rejected
+	other := newCookieSessionManager(t, generateEncryptionKey(), 3800)
+	next, _ := newCookieRequestContext(rec)
+	if _, err := other.Load(next, session.ID); err == nil {
+		t.Error("Expected an error for a cookie encrypted with another key")
+	}
+
+	// Modified cookies fail authentication
+	data, _ := next.GetCookie("rockstar_session_data")
+	value := []byte(data.Value)
+	value[len(value)/2] ^= 1
+	tampered := NewContext(&Request{Header: http.Header{"Cookie": {"rockstar_session_data=" + string(value)}}}, NewResponseWriter(httptest.NewRecorder()), context.Background())
+	if _, err := sm.Load(tampered, session.ID); err == nil {
+		t.Error("Expected an error for a modified cookie")
+	}
+
+	// The session ID cookie must match the session in the data cookies
+	if _, err := sm.Load(next, "another-session"); err == nil {
+		t.Error("Expected an error for a different session ID")
+	}
+}
+
+func TestCookieSessionStore_Destroy(t *testing.T) {
+	sm := newCookieSessionManager(t, generateEncryptionKey(), 3800)
+
+	ctx, rec := newCookieRequestContext(nil)
+	session, _ := sm.Create(ctx)
+
+	next, nextRec := newCookieRequestContext(rec)
+	if err := sm.Destroy(next, session.ID); err != nil {
+		t.Fatalf("Failed to destroy session: %v", err)
+	}
+	if _, err := sm.Load(next, session.ID); err == nil {
+		t.Error("Expected the destroyed session to be gone within the request")
+	}
+	cookies := nextRec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "rockstar_session_data" || cookies[0].MaxAge >= 0 {
+		t.Errorf("Expected the session cookie to be expired, got %+v", cookies)
+	}
+}
@@ -0,0 +1,186 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// filesystemSessionStore implements SessionStore with one JSON file per
+// session, keeping loaded sessions in memory
+type filesystemSessionStore struct {
+	path     string
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+// NewFilesystemSessionStore creates a SessionStore keeping sessions in
+// files in the directory path, which is created if it does not exist
+func NewFilesystemSessionStore(path string) (SessionStore, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	return &filesystemSessionStore{
+		path:     path,
+		sessions: make(map[string]*Session),
+	}, nil
+}
+
+func newFilesystemSessionStoreFromConfig(config *SessionConfig, db DatabaseManager, cache CacheManager) (SessionStore, error) {
+	return NewFilesystemSessionStore(config.FilesystemPath)
+}
+
+func (s *filesystemSessionStore) Save(ctx Context, session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+
+	// Validate session ID to prevent path traversal
+	validator := NewPathValidator(s.path)
+	filename, err := validator.ResolvePath(session.ID + ".json")
+	if err != nil {
+		return fmt.Errorf("invalid session ID: %w", err)
+	}
+
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *filesystemSessionStore) Load(ctx Context, sessionID string) (*Session, error) {
+	s.mu.RLock()
+	if session, exists := s.sessions[sessionID]; exists {
+		s.mu.RUnlock()
+		return session, nil
+	}
+	s.mu.RUnlock()
+
+	// Validate session ID to prevent path traversal
+	validator := NewPathValidator(s.path)
+	filename, err := validator.ResolvePath(sessionID + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("invalid session ID: %w", err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.New("session not found")
+		}
+		return nil, fmt.Errorf("failed to read session file: %w", err)
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session: %w", err)
+	}
+
+	s.mu.Lock()
+	s.sessions[sessionID] = &session
+	s.mu.Unlock()
+
+	return &session, nil
+}
+
+func (s *filesystemSessionStore) Delete(ctx Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, sessionID)
+
+	// Validate session ID to prevent path traversal
+	validator := NewPathValidator(s.path)
+	filename, err := validator.ResolvePath(sessionID + ".json")
+	if err != nil {
+		return fmt.Errorf("invalid session ID: %w", err)
+	}
+
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete session file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *filesystemSessionStore) Cleanup() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := os.ReadDir(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read session directory: %w", err)
+	}
+
+	now := time.Now()
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+
+		sessionID := file.Name()[:len(file.Name())-5] // Remove .json extension
+		if session, exists := s.sessions[sessionID]; exists {
+			if session.ExpiresAt.Before(now) {
+				delete(s.sessions, sessionID)
+				os.Remove(filepath.Join(s.path, file.Name()))
+			}
+		} else {
+			// Load and check expiration
+			filename := filepath.Join(s.path, file.Name())
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				continue
+			}
+
+			var session Session
+			if err := json.Unmarshal(data, &session); err != nil {
+				continue
+			}
+
+			if session.ExpiresAt.Before(now) {
+				os.Remove(filename)
+			}
+		}
+	}
+
+	return nil
+}
+
+// UserSessions reads every session file and returns the unexpired sessions
+// of the user, oldest first
+func (s *filesystemSessionStore) UserSessions(userID string) ([]*Session, error) {
+	files, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session directory: %w", err)
+	}
+
+	var sessions []*Session
+	now := time.Now()
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		session, err := s.Load(nil, strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil || session.UserID != userID || session.ExpiresAt.Before(now) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
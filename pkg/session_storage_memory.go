@@ -2,6 +2,7 @@ package pkg
 
 import (
 	"errors"
+	"sort"
 	"sync"
 	"time"
 )
@@ -121,6 +122,31 @@ func (s *inMemorySessionStorage) Cleanup() error {
 	return nil
 }
 
+// UserSessions returns copies of the unexpired sessions of the user, oldest first
+func (s *inMemorySessionStorage) UserSessions(userID string) []*Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []*Session
+	now := time.Now()
+	for _, session := range s.sessions {
+		if session.UserID != userID || session.ExpiresAt.Before(now) {
+			continue
+		}
+		sessionCopy := *session
+		sessionCopy.Data = make(map[string]interface{}, len(session.Data))
+		for k, v := range session.Data {
+			sessionCopy.Data[k] = v
+		}
+		sessions = append(sessions, &sessionCopy)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions
+}
+
 // Count returns the number of sessions in memory (useful for testing)
 func (s *inMemorySessionStorage) Count() int {
 	s.mu.RLock()
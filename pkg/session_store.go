@@ -0,0 +1,303 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// SessionStore persists sessions for the session manager. Stores keeping
+// sessions on the server ignore ctx, which is nil when sessions are accessed
+// by ID outside of a request; client-side stores such as the cookie store
+// read and write the session through ctx.
+type SessionStore interface {
+	// Save creates or replaces the session
+	Save(ctx Context, session *Session) error
+
+	// Load returns the session with the given ID or an error if it does not exist
+	Load(ctx Context, sessionID string) (*Session, error)
+
+	// Delete removes the session
+	Delete(ctx Context, sessionID string) error
+
+	// Cleanup removes expired sessions
+	Cleanup() error
+}
+
+// SessionUserIndex is implemented by session stores that can find the
+// sessions of a user. Per-user session limits and revoking the sessions of a
+// user require it.
+type SessionUserIndex interface {
+	// UserSessions returns the unexpired sessions of the user
+	UserSessions(userID string) ([]*Session, error)
+}
+
+// SessionStoreFactory creates the SessionStore for a session manager
+type SessionStoreFactory func(config *SessionConfig, db DatabaseManager, cache CacheManager) (SessionStore, error)
+
+// ErrSessionContextRequired is returned by stores that keep sessions on the
+// client when a session is accessed without a request context
+var ErrSessionContextRequired = errors.New("session store requires a request context")
+
+var (
+	sessionStoresMu sync.RWMutex
+	sessionStores   = map[SessionStorageType]SessionStoreFactory{
+		SessionStorageDatabase:   newDatabaseSessionStoreFromConfig,
+		SessionStorageCache:      newCacheSessionStoreFromConfig,
+		SessionStorageFilesystem: newFilesystemSessionStoreFromConfig,
+		SessionStorageCookie:     newCookieSessionStoreFromConfig,
+	}
+)
+
+// RegisterSessionStore makes a custom session store available to
+// SessionConfig.StorageType under storageType. Registering a built-in
+// storage type replaces it.
+func RegisterSessionStore(storageType SessionStorageType, factory SessionStoreFactory) {
+	sessionStoresMu.Lock()
+	defer sessionStoresMu.Unlock()
+	sessionStores[storageType] = factory
+}
+
+// newSessionStore creates the store configured by config
+func newSessionStore(config *SessionConfig, db DatabaseManager, cache CacheManager) (SessionStore, error) {
+	if config.Store != nil {
+		return config.Store, nil
+	}
+
+	sessionStoresMu.RLock()
+	factory, ok := sessionStores[config.StorageType]
+	sessionStoresMu.RUnlock()
+	if !ok {
+		return unsupportedSessionStore{storageType: config.StorageType}, nil
+	}
+	return factory(config, db, cache)
+}
+
+// databaseSessionStore implements SessionStore on the sessions table
+type databaseSessionStore struct {
+	db DatabaseManager
+}
+
+// NewDatabaseSessionStore creates a SessionStore backed by the database
+func NewDatabaseSessionStore(db DatabaseManager) SessionStore {
+	return &databaseSessionStore{db: db}
+}
+
+func newDatabaseSessionStoreFromConfig(config *SessionConfig, db DatabaseManager, cache CacheManager) (SessionStore, error) {
+	if isNoopDatabase(db) {
+		// Switch to in-memory storage when no database is available and database storage is requested
+		fmt.Println("WARN: SessionManager using in-memory storage. Sessions will not persist across restarts.")
+		return NewMemorySessionStore(), nil
+	}
+	return NewDatabaseSessionStore(db), nil
+}
+
+func (s *databaseSessionStore) Save(ctx Context, session *Session) error {
+	return s.db.SaveSession(session)
+}
+
+func (s *databaseSessionStore) Load(ctx Context, sessionID string) (*Session, error) {
+	return s.db.LoadSession(sessionID)
+}
+
+func (s *databaseSessionStore) Delete(ctx Context, sessionID string) error {
+	return s.db.DeleteSession(sessionID)
+}
+
+func (s *databaseSessionStore) Cleanup() error {
+	return s.db.CleanupExpiredSessions()
+}
+
+func (s *databaseSessionStore) UserSessions(userID string) ([]*Session, error) {
+	db := PrimaryDatabase(s.db)
+	query, err := db.GetQuery("load_user_sessions")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load load_user_sessions query: %w", err)
+	}
+
+	rows, err := db.Query(query, userID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		session := &Session{}
+		var dataJSON string
+		if err := rows.Scan(&session.ID, &session.UserID, &session.TenantID, &dataJSON,
+			&session.ExpiresAt, &session.CreatedAt, &session.UpdatedAt,
+			&session.IPAddress, &session.UserAgent); err != nil {
+			return nil, fmt.Errorf("failed to load user sessions: %w", err)
+		}
+		if err := json.Unmarshal([]byte(dataJSON), &session.Data); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal session data: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// cacheSessionStore implements SessionStore on a CacheManager. The IDs of
+// the sessions of each user are kept in an index entry next to the sessions.
+type cacheSessionStore struct {
+	cache CacheManager
+	mu    sync.Mutex // Serializes index updates of this instance
+}
+
+// NewCacheSessionStore creates a SessionStore backed by cache. Sessions
+// expire from the cache with the session.
+func NewCacheSessionStore(cache CacheManager) SessionStore {
+	return &cacheSessionStore{cache: cache}
+}
+
+func newCacheSessionStoreFromConfig(config *SessionConfig, db DatabaseManager, cache CacheManager) (SessionStore, error) {
+	return NewCacheSessionStore(cache), nil
+}
+
+func (s *cacheSessionStore) Save(ctx Context, session *Session) error {
+	if s.cache == nil {
+		return errors.New("cache manager not configured")
+	}
+	ttl := time.Until(session.ExpiresAt)
+	if err := s.cache.Set(sessionKey(session.ID), session, ttl); err != nil {
+		return err
+	}
+	if session.UserID == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := userSessionsKey(session.UserID)
+	ids, _ := GetTyped[[]string](s.cache, key)
+	if !slices.Contains(ids, session.ID) {
+		ids = append(ids, session.ID)
+	}
+	// Keep the index as long as its longest-lived session
+	if remaining, err := s.cache.TTL(key); err == nil && remaining > ttl {
+		ttl = remaining
+	}
+	return s.cache.Set(key, ids, ttl)
+}
+
+func (s *cacheSessionStore) Load(ctx Context, sessionID string) (*Session, error) {
+	if s.cache == nil {
+		return nil, errors.New("cache manager not configured")
+	}
+	value, err := s.cache.Get(sessionKey(sessionID))
+	if err != nil {
+		return nil, err
+	}
+	session, ok := value.(*Session)
+	if !ok {
+		return nil, errors.New("invalid session data in cache")
+	}
+	return session, nil
+}
+
+func (s *cacheSessionStore) Delete(ctx Context, sessionID string) error {
+	if s.cache == nil {
+		return errors.New("cache manager not configured")
+	}
+	return s.cache.Delete(sessionKey(sessionID))
+}
+
+// Cleanup does nothing, the cache expires sessions itself
+func (s *cacheSessionStore) Cleanup() error {
+	return nil
+}
+
+// UserSessions loads the sessions in the index of the user, dropping
+// sessions that expired, were deleted or belong to another user by now
+func (s *cacheSessionStore) UserSessions(userID string) ([]*Session, error) {
+	if s.cache == nil {
+		return nil, errors.New("cache manager not configured")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids, err := GetTyped[[]string](s.cache, userSessionsKey(userID))
+	if err == ErrCacheKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []*Session
+	var live []string
+	now := time.Now()
+	for _, id := range ids {
+		session, err := s.Load(nil, id)
+		if err != nil || session.UserID != userID || session.ExpiresAt.Before(now) {
+			continue
+		}
+		sessions = append(sessions, session)
+		live = append(live, id)
+	}
+
+	if len(live) == 0 {
+		return nil, s.cache.Delete(userSessionsKey(userID))
+	}
+	if len(live) < len(ids) {
+		ttl, err := s.cache.TTL(userSessionsKey(userID))
+		if err == nil && ttl > 0 {
+			s.cache.Set(userSessionsKey(userID), live, ttl)
+		}
+	}
+	return sessions, nil
+}
+
+// memorySessionStore adapts inMemorySessionStorage to SessionStore
+type memorySessionStore struct {
+	*inMemorySessionStorage
+}
+
+// NewMemorySessionStore creates a SessionStore keeping sessions in memory.
+// Sessions are lost on restart and not shared between instances.
+func NewMemorySessionStore() SessionStore {
+	return memorySessionStore{newInMemorySessionStorage()}
+}
+
+func (s memorySessionStore) Save(ctx Context, session *Session) error {
+	return s.inMemorySessionStorage.Save(session)
+}
+
+func (s memorySessionStore) Load(ctx Context, sessionID string) (*Session, error) {
+	return s.inMemorySessionStorage.Load(sessionID)
+}
+
+func (s memorySessionStore) Delete(ctx Context, sessionID string) error {
+	return s.inMemorySessionStorage.Delete(sessionID)
+}
+
+func (s memorySessionStore) UserSessions(userID string) ([]*Session, error) {
+	return s.inMemorySessionStorage.UserSessions(userID), nil
+}
+
+// unsupportedSessionStore reports an unknown storage type when used
+type unsupportedSessionStore struct {
+	storageType SessionStorageType
+}
+
+func (s unsupportedSessionStore) Save(ctx Context, session *Session) error {
+	return fmt.Errorf("unsupported storage type: %s", s.storageType)
+}
+
+func (s unsupportedSessionStore) Load(ctx Context, sessionID string) (*Session, error) {
+	return nil, fmt.Errorf("unsupported storage type: %s", s.storageType)
+}
+
+func (s unsupportedSessionStore) Delete(ctx Context, sessionID string) error {
+	return fmt.Errorf("unsupported storage type: %s", s.storageType)
+}
+
+func (s unsupportedSessionStore) Cleanup() error {
+	return nil
+}
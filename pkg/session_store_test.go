@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingSessionStore is a custom SessionStore counting its calls
+type recordingSessionStore struct {
+	SessionStore
+	saves int
+}
+
+func (s *recordingSessionStore) Save(ctx Context, session *Session) error {
+	s.saves++
+	return s.SessionStore.Save(ctx, session)
+}
+
+func TestRegisterSessionStore(t *testing.T) {
+	store := &recordingSessionStore{SessionStore: NewMemorySessionStore()}
+	RegisterSessionStore("recording", func(config *SessionConfig, db DatabaseManager, cache CacheManager) (SessionStore, error) {
+		return store, nil
+	})
+
+	config := DefaultSessionConfig()
+	config.StorageType = "recording"
+	sm, err := NewSessionManager(config, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session manager: %v", err)
+	}
+	defer sm.(*sessionManager).Stop()
+
+	session, err := sm.Create(nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if err := sm.Set(session.ID, "theme", "dark"); err != nil {
+		t.Fatalf("Failed to set value: %v", err)
+	}
+	if value, _ := sm.Get(session.ID, "theme"); value != "dark" || store.saves != 2 {
+		t.Errorf("Expected the registered store to be used, got %v after %d saves", value, store.saves)
+	}
+}
+
+func TestSessionConfig_Store(t *testing.T) {
+	store := &recordingSessionStore{SessionStore: NewCacheSessionStore(newMockSessionCacheManager())}
+	config := DefaultSessionConfig()
+	config.Store = store
+	sm, err := NewSessionManager(config, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session manager: %v", err)
+	}
+	defer sm.(*sessionManager).Stop()
+
+	// The configured store takes precedence over StorageType
+	if _, err := sm.Create(nil); err != nil || store.saves != 1 {
+		t.Errorf("Expected the configured store to be used, got %v after %d saves", err, store.saves)
+	}
+}
+
+func TestSessionStore_UnsupportedType(t *testing.T) {
+	config := DefaultSessionConfig()
+	config.StorageType = "carrier-pigeon"
+	sm, err := NewSessionManager(config, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session manager: %v", err)
+	}
+	defer sm.(*sessionManager).Stop()
+
+	if _, err := sm.Create(nil); err == nil || !strings.Contains(err.Error(), "unsupported storage type") {
+		t.Errorf("Expected an unsupported storage type error, got %v", err)
+	}
+}
+
+func TestFilesystemSessionStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFilesystemSessionStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	expired := &Session{ID: "old", Data: map[string]interface{}{}, ExpiresAt: time.Now().Add(-time.Minute)}
+	active := &Session{ID: "new", Data: map[string]interface{}{"k": "v"}, ExpiresAt: time.Now().Add(time.Hour)}
+	store.Save(nil, expired)
+	store.Save(nil, active)
+
+	// A second store reads the files written by the first
+	reopened, _ := NewFilesystemSessionStore(dir)
+	if session, err := reopened.Load(nil, "new"); err != nil || session.Data["k"] != "v" {
+		t.Errorf("Expected the session from disk, got %+v, %v", session, err)
+	}
+	if _, err := reopened.Load(nil, "../escape"); err == nil {
+		t.Error("Expected path traversal to be rejected")
+	}
+
+	if err := store.Cleanup(); err != nil {
+		t.Fatalf("Failed to clean up: %v", err)
+	}
+	if _, err := reopened.Load(nil, "old"); err == nil {
+		t.Error("Expected the expired session file to be removed")
+	}
+}
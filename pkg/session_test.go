@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"errors"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
@@ -36,6 +37,7 @@ func (m *mockContext) Session() SessionManager
 func (m *mockContext) User() *User                                                  { return m.user }
 func (m *mockContext) Tenant() *Tenant                                              { return m.tenant }
 func (m *mockContext) DB() DatabaseManager                                          { return nil }
+func (m *mockContext) Jobs() JobQueue                                               { return nil }
 func (m *mockContext) Cache() CacheManager                                          { return nil }
 func (m *mockContext) Config() ConfigManager                                        { return nil }
 func (m *mockContext) I18n() I18nManager                                            { return nil }
@@ -738,3 +740,277 @@ func TestProperty_SessionManagerInMemoryStorage(t *testing.T) {
 
 	properties.TestingRun(t, gopter.ConsoleReporter(false))
 }
+
+// newSecureSessionManager creates a session manager keeping sessions in memory
+func newSecureSessionManager(t *testing.T, configure func(config *SessionConfig)) *sessionManager {
+	t.Helper()
+	config := DefaultSessionConfig()
+	config.EncryptionKey = generateEncryptionKey()
+	if configure != nil {
+		configure(config)
+	}
+	sm, err := NewSessionManager(config, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session manager: %v", err)
+	}
+	t.Cleanup(sm.(*sessionManager).Stop)
+	return sm.(*sessionManager)
+}
+
+// newClientContext creates a request context from a client with the given
+// address and User-Agent, sending the cookies set on rec
+func newClientContext(rec *httptest.ResponseRecorder, remoteAddr, userAgent string) (Context, *httptest.ResponseRecorder) {
+	ctx, next := newCookieRequestContext(rec)
+	ctx.Request().RemoteAddr = remoteAddr
+	ctx.Request().Header.Set("User-Agent", userAgent)
+	return ctx, next
+}
+
+func TestSessionManager_Regenerate(t *testing.T) {
+	sm := newSecureSessionManager(t, nil)
+	if _, ok := SessionManager(sm).(SessionSecurityManager); !ok {
+		t.Fatal("Expected the session manager to implement SessionSecurityManager")
+	}
+
+	ctx, rec := newClientContext(nil, "192.0.2.1:4000", "Browser")
+	anonymous, _ := sm.Create(ctx)
+	anonymous.Data["cart"] = "3 items"
+	sm.Save(ctx, anonymous)
+	sm.SetCookie(ctx, anonymous)
+
+	// Logging in moves the session to a new ID
+	login, loginRec := newClientContext(rec, "192.0.2.1:4000", "Browser")
+	login.(*contextImpl).SetUser(&User{ID: "ada"})
+	session, err := sm.Regenerate(login)
+	if err != nil {
+		t.Fatalf("Failed to regenerate session: %v", err)
+	}
+	if session.ID == anonymous.ID {
+		t.Fatal("Expected a new session ID")
+	}
+	if session.Data["cart"] != "3 items" || session.UserID != "ada" {
+		t.Errorf("Expected the data and the logged in user, got %+v", session)
+	}
+	if _, err := sm.Load(nil, anonymous.ID); err == nil {
+		t.Error("Expected the pre-login session to be deleted")
+	}
+
+	next, _ := newClientContext(loginRec, "192.0.2.1:4000", "Browser")
+	if loaded, err := sm.GetSessionFromCookie(next); err != nil || loaded.ID != session.ID {
+		t.Errorf("Expected the cookie to carry the new session, got %v", err)
+	}
+
+	// Without a session a new one is created
+	fresh, _ := newClientContext(nil, "192.0.2.1:4000", "Browser")
+	if session, err := sm.Regenerate(fresh); err != nil || len(session.Data) != 0 {
+		t.Errorf("Expected a new empty session, got %+v, %v", session, err)
+	}
+}
+
+func TestSessionManager_Timeouts(t *testing.T) {
+	sm := newSecureSessionManager(t, func(config *SessionConfig) {
+		config.IdleTimeout = time.Hour
+		config.AbsoluteTimeout = 8 * time.Hour
+	})
+
+	session, _ := sm.Create(nil)
+	if remaining := time.Until(session.ExpiresAt); remaining > time.Hour || remaining < 59*time.Minute {
+		t.Fatalf("Expected the idle timeout as lifetime, got %v", remaining)
+	}
+
+	// Loading an idle session extends it
+	session.ExpiresAt = time.Now().Add(30 * time.Minute)
+	sm.store.Save(nil, session)
+	loaded, err := sm.Load(nil, session.ID)
+	if err != nil || time.Until(loaded.ExpiresAt) < 59*time.Minute {
+		t.Errorf("Expected the session to be extended, got %v, %v", loaded, err)
+	}
+
+	// Sessions idle for longer than the idle timeout expire
+	session.ExpiresAt = time.Now().Add(-time.Second)
+	sm.store.Save(nil, session)
+	if _, err := sm.Load(nil, session.ID); err == nil {
+		t.Error("Expected the idle session to expire")
+	}
+
+	// Refreshing never extends a session beyond the absolute timeout
+	old, _ := sm.Create(nil)
+	old.CreatedAt = time.Now().Add(-7*time.Hour - 30*time.Minute)
+	sm.store.Save(nil, old)
+	if err := sm.Refresh(nil, old.ID); err != nil {
+		t.Fatalf("Failed to refresh session: %v", err)
+	}
+	refreshed, _ := sm.Load(nil, old.ID)
+	if deadline := old.CreatedAt.Add(8 * time.Hour); !refreshed.ExpiresAt.Equal(deadline) {
+		t.Errorf("Expected the absolute deadline %v, got %v", deadline, refreshed.ExpiresAt)
+	}
+
+	// Nor does regenerating its ID
+	ctx, rec := newClientContext(nil, "192.0.2.1:4000", "Browser")
+	sm.SetCookie(ctx, old)
+	next, _ := newClientContext(rec, "192.0.2.1:4000", "Browser")
+	regenerated, err := sm.Regenerate(next)
+	if err != nil {
+		t.Fatalf("Failed to regenerate session: %v", err)
+	}
+	if deadline := old.CreatedAt.Add(8 * time.Hour); !regenerated.CreatedAt.Equal(old.CreatedAt) || !regenerated.ExpiresAt.Equal(deadline) {
+		t.Errorf("Expected the absolute deadline %v to be kept, got %+v", deadline, regenerated)
+	}
+
+	old, _ = sm.Create(nil)
+	old.CreatedAt = time.Now().Add(-9 * time.Hour)
+	sm.store.Save(nil, old)
+	if !sm.IsExpired(old.ID) || sm.IsValid(old.ID) {
+		t.Error("Expected the session to be past its absolute timeout")
+	}
+	if _, err := sm.Load(nil, old.ID); err == nil {
+		t.Error("Expected the session to be past its absolute timeout")
+	}
+}
+
+func TestSessionManager_Binding(t *testing.T) {
+	sm := newSecureSessionManager(t, func(config *SessionConfig) {
+		config.BindUserAgent = true
+		config.BindIPv4Prefix = 24
+		config.BindIPv6Prefix = 64
+	})
+
+	tests := []struct {
+		name      string
+		created   string
+		presented string
+		userAgent string
+		valid     bool
+	}{
+		{"same client", "203.0.113.7:5000", "203.0.113.7:6000", "Browser", true},
+		{"same IPv4 network", "203.0.113.7:5000", "203.0.113.99:6000", "Browser", true},
+		{"other IPv4 network", "203.0.113.7:5000", "198.51.100.7:6000", "Browser", false},
+		{"other user agent", "203.0.113.7:5000", "203.0.113.7:6000", "curl", false},
+		{"same IPv6 network", "[2001:db8::1]:5000", "[2001:db8::ffff]:6000", "Browser", true},
+		{"other IPv6 network", "[2001:db8::1]:5000", "[2001:db8:1::1]:6000", "Browser", false},
+		{"other address family", "203.0.113.7:5000", "[2001:db8::1]:6000", "Browser", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := newClientContext(nil, tt.created, "Browser")
+			session, _ := sm.Create(ctx)
+
+			next, _ := newClientContext(nil, tt.presented, tt.userAgent)
+			_, err := sm.Load(next, session.ID)
+			if tt.valid && err != nil {
+				t.Errorf("Expected the session to be accepted, got %v", err)
+			}
+			var frameworkErr *FrameworkError
+			if !tt.valid && (!errors.As(err, &frameworkErr) || frameworkErr.Code != ErrCodeSessionInvalid) {
+				t.Errorf("Expected %s, got %v", ErrCodeSessionInvalid, err)
+			}
+		})
+	}
+}
+
+func TestSessionManager_BindingIgnoresForwardingHeaders(t *testing.T) {
+	sm := newSecureSessionManager(t, func(config *SessionConfig) {
+		config.BindIPv4Prefix = 24
+	})
+
+	ctx, _ := newClientContext(nil, "203.0.113.7:5000", "Browser")
+	ctx.Request().Header.Set("X-Forwarded-For", "198.51.100.7")
+	session, _ := sm.Create(ctx)
+
+	// The client's own connection is accepted without the header
+	next, _ := newClientContext(nil, "203.0.113.7:6000", "Browser")
+	if _, err := sm.Load(next, session.ID); err != nil {
+		t.Errorf("Expected the session to be accepted, got %v", err)
+	}
+
+	// Another network cannot claim the creating address
+	next, _ = newClientContext(nil, "198.51.100.7:6000", "Browser")
+	next.Request().Header.Set("X-Forwarded-For", "203.0.113.7")
+	next.Request().Header.Set("X-Real-IP", "203.0.113.7")
+	var frameworkErr *FrameworkError
+	if _, err := sm.Load(next, session.ID); !errors.As(err, &frameworkErr) || frameworkErr.Code != ErrCodeSessionInvalid {
+		t.Errorf("Expected %s, got %v", ErrCodeSessionInvalid, err)
+	}
+}
+
+func TestSessionManager_MaxSessionsPerUser(t *testing.T) {
+	user := &mockContext{user: &User{ID: "ada"}}
+
+	sm := newSecureSessionManager(t, func(config *SessionConfig) {
+		config.MaxSessionsPerUser = 2
+	})
+	first, _ := sm.Create(user)
+	second, _ := sm.Create(user)
+	third, err := sm.Create(user)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	sessions, _ := sm.UserSessions("ada")
+	if len(sessions) != 2 || sessions[0].ID != second.ID || sessions[1].ID != third.ID {
+		t.Errorf("Expected the oldest session to be evicted, got %d sessions", len(sessions))
+	}
+	if _, err := sm.Load(nil, first.ID); err == nil {
+		t.Error("Expected the oldest session to be deleted")
+	}
+
+	strict := newSecureSessionManager(t, func(config *SessionConfig) {
+		config.MaxSessionsPerUser = 1
+		config.SessionLimitPolicy = SessionLimitReject
+	})
+	session, _ := strict.Create(user)
+	if _, err := strict.Create(user); !errors.Is(err, ErrSessionLimitExceeded) {
+		t.Errorf("Expected ErrSessionLimitExceeded, got %v", err)
+	}
+	session.Data["theme"] = "dark"
+	if err := strict.Save(user, session); err != nil {
+		t.Errorf("Expected the existing session to be saved, got %v", err)
+	}
+
+	// Limits need a store that finds the sessions of a user
+	config := DefaultSessionConfig()
+	config.StorageType = SessionStorageCookie
+	config.MaxSessionsPerUser = 1
+	if _, err := NewSessionManager(config, nil, nil); !errors.Is(err, ErrSessionUserIndexUnsupported) {
+		t.Errorf("Expected ErrSessionUserIndexUnsupported, got %v", err)
+	}
+}
+
+func TestSessionManager_RevokeUserSessions(t *testing.T) {
+	stores := map[string]func(t *testing.T) SessionStore{
+		"memory": func(t *testing.T) SessionStore { return NewMemorySessionStore() },
+		"cache":  func(t *testing.T) SessionStore { return NewCacheSessionStore(NewCacheManager(CacheConfig{})) },
+		"filesystem": func(t *testing.T) SessionStore {
+			store, _ := NewFilesystemSessionStore(t.TempDir())
+			return store
+		},
+		"database": func(t *testing.T) SessionStore { return NewDatabaseSessionStore(connectCacheTestDB(t)) },
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			sm := newSecureSessionManager(t, func(config *SessionConfig) {
+				config.Store = store
+			})
+
+			ada := &mockContext{user: &User{ID: "ada"}}
+			sm.Create(ada)
+			sm.Create(ada)
+			other, _ := sm.Create(&mockContext{user: &User{ID: "grace"}})
+
+			if sessions, err := sm.UserSessions("ada"); err != nil || len(sessions) != 2 {
+				t.Fatalf("Expected 2 sessions, got %d, %v", len(sessions), err)
+			}
+			if err := sm.RevokeUserSessions("ada"); err != nil {
+				t.Fatalf("Failed to revoke sessions: %v", err)
+			}
+			if sessions, _ := sm.UserSessions("ada"); len(sessions) != 0 {
+				t.Errorf("Expected no sessions after revoking, got %d", len(sessions))
+			}
+			if !sm.IsValid(other.ID) {
+				t.Error("Expected the sessions of other users to be kept")
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ErrSessionTypeMismatch is returned when a session value cannot be read as
+// the requested type
+var ErrSessionTypeMismatch = errors.New("session value has a different type")
+
+// flashSessionKey is the session data key holding the flash messages for
+// the next request
+const flashSessionKey = "_flash"
+
+// flashContextKey is the context key holding the flash state of the request
+const flashContextKey = "flash"
+
+var (
+	sessionTypesMu sync.RWMutex
+	sessionTypes   = map[string]func(value interface{}) (interface{}, error){}
+)
+
+// RegisterSessionType declares the type of the session values stored under
+// key. SessionManager.Get returns registered values as T, also after stores
+// serializing sessions to JSON turned them into float64, map or slice values.
+func RegisterSessionType[T any](key string) {
+	sessionTypesMu.Lock()
+	defer sessionTypesMu.Unlock()
+	sessionTypes[key] = func(value interface{}) (interface{}, error) {
+		return sessionValueAs[T](key, value)
+	}
+}
+
+// SessionValue returns the value of key in session as a T. Values that were
+// serialized by the store are decoded into T; values that cannot be
+// represented as a T return ErrSessionTypeMismatch.
+func SessionValue[T any](session *Session, key string) (T, error) {
+	var result T
+	if session == nil {
+		return result, errors.New("session is nil")
+	}
+	value, exists := session.Data[key]
+	if !exists {
+		return result, fmt.Errorf("key %s not found in session", key)
+	}
+	return sessionValueAs[T](key, value)
+}
+
+// GetSessionTyped returns the value of key in the session with the given ID
+// as a T
+func GetSessionTyped[T any](sm SessionManager, sessionID, key string) (T, error) {
+	var result T
+	value, err := sm.Get(sessionID, key)
+	if err != nil {
+		return result, err
+	}
+	return sessionValueAs[T](key, value)
+}
+
+// SetSessionTyped stores value under key in the session with the given ID
+func SetSessionTyped[T any](sm SessionManager, sessionID, key string, value T) error {
+	return sm.Set(sessionID, key, value)
+}
+
+// decodeSessionValue returns value as the type registered for key, or
+// unchanged if no type is registered
+func decodeSessionValue(key string, value interface{}) (interface{}, error) {
+	sessionTypesMu.RLock()
+	decode, ok := sessionTypes[key]
+	sessionTypesMu.RUnlock()
+	if !ok {
+		return value, nil
+	}
+	return decode(value)
+}
+
+// sessionValueAs returns value as a T, converting values decoded from JSON
+// by encoding them again
+func sessionValueAs[T any](key string, value interface{}) (T, error) {
+	var result T
+	if value == nil {
+		return result, nil
+	}
+	if typed, ok := value.(T); ok {
+		return typed, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err == nil {
+		err = json.Unmarshal(data, &result)
+	}
+	if err != nil {
+		var zero T
+		return zero, fmt.Errorf("%w: %s holds %T, not %v", ErrSessionTypeMismatch, key, value, reflect.TypeOf((*T)(nil)).Elem())
+	}
+	return result, nil
+}
+
+// flashState is the flash state of a request
+type flashState struct {
+	incoming  map[string][]interface{} // Flash messages of the previous request
+	sessionID string                   // Session created for flash messages during the request
+}
+
+// AddFlash adds a flash message under key, such as "error" or "notice". The
+// message can be read with Flashes during the next request of the client,
+// which consumes it. A session is created if the request has none.
+func AddFlash(ctx Context, key string, value interface{}) error {
+	state, err := requestFlashes(ctx)
+	if err != nil {
+		return err
+	}
+
+	sm := ctx.Session()
+	session, err := flashSession(ctx, state)
+	if err != nil {
+		session, err = sm.Create(ctx)
+		if err != nil {
+			return err
+		}
+		if err := sm.SetCookie(ctx, session); err != nil {
+			return err
+		}
+		state.sessionID = session.ID
+	}
+
+	outgoing, err := sessionValueAs[map[string][]interface{}](flashSessionKey, session.Data[flashSessionKey])
+	if err != nil {
+		return err
+	}
+	if outgoing == nil {
+		outgoing = make(map[string][]interface{})
+	}
+	outgoing[key] = append(outgoing[key], value)
+	session.Data[flashSessionKey] = outgoing
+
+	return sm.Save(ctx, session)
+}
+
+// Flashes returns the flash messages added under key during the previous
+// request. The first access to flash messages in a request consumes all of
+// them, so they are shown once; later calls in the same request return the
+// same messages.
+func Flashes(ctx Context, key string) ([]interface{}, error) {
+	state, err := requestFlashes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return state.incoming[key], nil
+}
+
+// FlashMiddleware consumes the flash messages at the start of every request,
+// so messages not read by the request that follows them are discarded
+// instead of appearing later
+func FlashMiddleware() MiddlewareFunc {
+	return func(ctx Context, next HandlerFunc) error {
+		if _, err := requestFlashes(ctx); err != nil {
+			return err
+		}
+		return next(ctx)
+	}
+}
+
+// RegisterFlashTemplateFuncs registers the flashes template function, which
+// takes the request Context and a key:
+//
+//	{{ range flashes .Ctx "notice" }}<p class="notice">{{ . }}</p>{{ end }}
+func RegisterFlashTemplateFuncs(tm TemplateManager) error {
+	return tm.AddFunc("flashes", Flashes)
+}
+
+// requestFlashes returns the flash state of the request, moving the flash
+// messages out of the session on first use
+func requestFlashes(ctx Context) (*flashState, error) {
+	if ctx == nil || ctx.Session() == nil {
+		return nil, errors.New("session manager not available")
+	}
+	if value, ok := ctx.Get(flashContextKey); ok {
+		if state, ok := value.(*flashState); ok {
+			return state, nil
+		}
+	}
+
+	state := &flashState{}
+	ctx.Set(flashContextKey, state)
+
+	// Without a session there are no flash messages
+	session, err := ctx.Session().GetSessionFromCookie(ctx)
+	if err != nil {
+		return state, nil
+	}
+	value, exists := session.Data[flashSessionKey]
+	if !exists {
+		return state, nil
+	}
+
+	state.incoming, err = sessionValueAs[map[string][]interface{}](flashSessionKey, value)
+	if err != nil {
+		return nil, err
+	}
+	delete(session.Data, flashSessionKey)
+	if err := ctx.Session().Save(ctx, session); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// flashSession returns the session of the request, including a session
+// created for flash messages earlier in the request
+func flashSession(ctx Context, state *flashState) (*Session, error) {
+	if state.sessionID != "" {
+		return ctx.Session().Load(ctx, state.sessionID)
+	}
+	return ctx.Session().GetSessionFromCookie(ctx)
+}
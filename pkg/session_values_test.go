@@ -0,0 +1,167 @@
+package pkg
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type sessionCart struct {
+	Items []string `json:"items"`
+	Total float64  `json:"total"`
+}
+
+// newFlashContext creates a request context using sm, sending the cookies
+// set on rec
+func newFlashContext(sm SessionManager, rec *httptest.ResponseRecorder) (Context, *httptest.ResponseRecorder) {
+	ctx, next := newCookieRequestContext(rec)
+	ctx.(*contextImpl).SetSession(sm)
+	return ctx, next
+}
+
+func TestSessionValue_SurvivesSerialization(t *testing.T) {
+	config := DefaultSessionConfig()
+	config.EncryptionKey = generateEncryptionKey()
+	config.StorageType = SessionStorageFilesystem
+	config.FilesystemPath = t.TempDir()
+	sm, err := NewSessionManager(config, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session manager: %v", err)
+	}
+	defer sm.(*sessionManager).Stop()
+
+	session, _ := sm.Create(nil)
+	loggedIn := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+	SetSessionTyped(sm, session.ID, "visits", 3)
+	SetSessionTyped(sm, session.ID, "cart", sessionCart{Items: []string{"guitar"}, Total: 499.5})
+	SetSessionTyped(sm, session.ID, "logged_in", loggedIn)
+
+	// A second manager reads the JSON files, turning the values into float64, map and string
+	reopened, _ := NewSessionManager(config, nil, nil)
+	defer reopened.(*sessionManager).Stop()
+	loaded, err := reopened.Load(nil, session.ID)
+	if err != nil {
+		t.Fatalf("Failed to load session: %v", err)
+	}
+	if _, ok := loaded.Data["visits"].(float64); !ok {
+		t.Fatalf("Expected the raw value to be a float64, got %T", loaded.Data["visits"])
+	}
+
+	if visits, err := SessionValue[int](loaded, "visits"); err != nil || visits != 3 {
+		t.Errorf("Expected 3 visits, got %v, %v", visits, err)
+	}
+	if cart, err := GetSessionTyped[sessionCart](reopened, session.ID, "cart"); err != nil || !reflect.DeepEqual(cart, sessionCart{Items: []string{"guitar"}, Total: 499.5}) {
+		t.Errorf("Expected the cart, got %+v, %v", cart, err)
+	}
+	if at, err := SessionValue[time.Time](loaded, "logged_in"); err != nil || !at.Equal(loggedIn) {
+		t.Errorf("Expected %v, got %v, %v", loggedIn, at, err)
+	}
+
+	if _, err := SessionValue[int](loaded, "cart"); !errors.Is(err, ErrSessionTypeMismatch) {
+		t.Errorf("Expected ErrSessionTypeMismatch, got %v", err)
+	}
+	if _, err := SessionValue[int](loaded, "missing"); err == nil {
+		t.Error("Expected an error for a missing key")
+	}
+}
+
+func TestRegisterSessionType(t *testing.T) {
+	RegisterSessionType[sessionCart]("registered_cart")
+
+	config := DefaultSessionConfig()
+	config.EncryptionKey = generateEncryptionKey()
+	config.StorageType = SessionStorageFilesystem
+	config.FilesystemPath = t.TempDir()
+	sm, _ := NewSessionManager(config, nil, nil)
+	defer sm.(*sessionManager).Stop()
+
+	session, _ := sm.Create(nil)
+	sm.Set(session.ID, "registered_cart", sessionCart{Items: []string{"drums"}})
+	sm.Set(session.ID, "unregistered", sessionCart{Items: []string{"drums"}})
+
+	reopened, _ := NewSessionManager(config, nil, nil)
+	defer reopened.(*sessionManager).Stop()
+	if value, err := reopened.Get(session.ID, "registered_cart"); err != nil || !reflect.DeepEqual(value, sessionCart{Items: []string{"drums"}}) {
+		t.Errorf("Expected the registered type, got %#v, %v", value, err)
+	}
+	if value, _ := reopened.Get(session.ID, "unregistered"); reflect.TypeOf(value) != reflect.TypeOf(map[string]interface{}{}) {
+		t.Errorf("Expected unregistered values unchanged, got %T", value)
+	}
+}
+
+func TestFlashes(t *testing.T) {
+	sm := newSecureSessionManager(t, nil)
+
+	// The request adding a message does not see it
+	ctx, rec := newFlashContext(sm, nil)
+	if err := AddFlash(ctx, "notice", "Profile saved"); err != nil {
+		t.Fatalf("Failed to add flash: %v", err)
+	}
+	if err := AddFlash(ctx, "notice", "Welcome back"); err != nil {
+		t.Fatalf("Failed to add flash: %v", err)
+	}
+	if messages, _ := Flashes(ctx, "notice"); len(messages) != 0 {
+		t.Errorf("Expected no messages in the adding request, got %v", messages)
+	}
+	if len(rec.Result().Cookies()) != 1 {
+		t.Fatalf("Expected a single session for the messages, got %d cookies", len(rec.Result().Cookies()))
+	}
+
+	// The next request reads them, as often as it likes
+	next, _ := newFlashContext(sm, rec)
+	for i := 0; i < 2; i++ {
+		messages, err := Flashes(next, "notice")
+		if err != nil || !reflect.DeepEqual(messages, []interface{}{"Profile saved", "Welcome back"}) {
+			t.Fatalf("Expected both messages, got %v, %v", messages, err)
+		}
+	}
+	if messages, _ := Flashes(next, "error"); len(messages) != 0 {
+		t.Errorf("Expected no error messages, got %v", messages)
+	}
+
+	// The request after that does not see them again
+	final, _ := newFlashContext(sm, rec)
+	if messages, _ := Flashes(final, "notice"); len(messages) != 0 {
+		t.Errorf("Expected the messages to be consumed, got %v", messages)
+	}
+}
+
+func TestFlashMiddleware(t *testing.T) {
+	sm := newSecureSessionManager(t, nil)
+
+	ctx, rec := newFlashContext(sm, nil)
+	AddFlash(ctx, "error", "Payment failed")
+
+	// A request that ignores the message still discards it
+	next, _ := newFlashContext(sm, rec)
+	if err := FlashMiddleware()(next, func(ctx Context) error { return nil }); err != nil {
+		t.Fatalf("Middleware failed: %v", err)
+	}
+
+	final, _ := newFlashContext(sm, rec)
+	if messages, err := Flashes(final, "error"); err != nil || len(messages) != 0 {
+		t.Errorf("Expected the ignored message to be discarded, got %v, %v", messages, err)
+	}
+}
+
+func TestRegisterFlashTemplateFuncs(t *testing.T) {
+	sm := newSecureSessionManager(t, nil)
+	ctx, rec := newFlashContext(sm, nil)
+	AddFlash(ctx, "notice", "Saved")
+
+	tm := NewTemplateManager()
+	if err := RegisterFlashTemplateFuncs(tm); err != nil {
+		t.Fatalf("Failed to register template functions: %v", err)
+	}
+	if err := tm.LoadTemplate("page", `{{ range flashes .Ctx "notice" }}<p>{{ . }}</p>{{ end }}`); err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	next, _ := newFlashContext(sm, rec)
+	out, err := tm.Render("page", map[string]interface{}{"Ctx": next})
+	if err != nil || out != "<p>Saved</p>" {
+		t.Errorf("Expected the flash message, got %q, %v", out, err)
+	}
+}
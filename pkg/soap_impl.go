@@ -302,7 +302,8 @@ func (s *soapManager) validationMiddleware(config SOAPConfig, next SOAPHandler)
 
 		// Apply timeout if configured
 		if config.Timeout > 0 {
-			timeoutCtx := ctx.WithTimeout(config.Timeout)
+			timeoutCtx, cancel := ContextWithTimeout(ctx, config.Timeout)
+			defer cancel()
 			return next(timeoutCtx)
 		}
 
@@ -291,6 +291,7 @@ func TestProperty_NoEmbeddedSQLInGoSource(t *testing.T) {
 		"sql_loader.go":      true,
 		"sql_loader_test.go": true,
 		"database_impl.go":   true, // Contains DropTables() utility method with simple DROP TABLE SQL
+		"query_builder.go":   true, // Renders SQL keywords for dynamically built statements
 	}
 
 	// Property: For any Go source file in pkg/ (excluding allowed files),
@@ -614,6 +615,7 @@ func TestProperty_CrossEngineCompatibility(t *testing.T) {
 		// Session operations
 		"save_session",
 		"load_session",
+		"load_user_sessions",
 		"delete_session",
 		"cleanup_expired_sessions",
 
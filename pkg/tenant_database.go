@@ -0,0 +1,357 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// tenantDatabaseManager implements TenantDatabaseManager
+type tenantDatabaseManager struct {
+	dm     *databaseManager
+	config TenantIsolationConfig
+
+	mu        sync.Mutex
+	databases map[string]*databaseManager // Connections of database isolation by tenant ID
+}
+
+// NewTenantDatabaseManager creates a manager that scopes db to tenants. db must
+// be the framework database manager, or a handle obtained from it.
+func NewTenantDatabaseManager(db DatabaseManager, config TenantIsolationConfig) (TenantDatabaseManager, error) {
+	config.ApplyDefaults()
+
+	dm, ok := unwrapDatabaseManager(db)
+	if !ok {
+		return nil, fmt.Errorf("tenant isolation requires the framework database manager, got %T", db)
+	}
+	return &tenantDatabaseManager{dm: dm, config: config, databases: make(map[string]*databaseManager)}, nil
+}
+
+// ForTenant returns a handle whose statements only see tenant's data
+func (tm *tenantDatabaseManager) ForTenant(ctx context.Context, tenant *Tenant) (TenantDatabase, error) {
+	if tenant == nil || tenant.ID == "" {
+		return nil, NewTenantError(ErrCodeTenantNotFound, "Tenant not found", 404)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	mode := tenantIsolationMode(tenant, tm.config.DefaultMode)
+	db := &tenantDatabase{tenant: tenant, mode: mode}
+
+	switch mode {
+	case TenantIsolationRow:
+		db.databaseView = tm.dm.forRequest(ctx).(*databaseView)
+		db.scope = newTenantScope(tenant.ID, tm.config.TenantColumn, tm.config.TenantTables)
+
+	case TenantIsolationRLS:
+		if driver := tm.dm.driverName(); driver != "postgres" && driver != "mssql" {
+			return nil, fmt.Errorf("row-level security isolation is not supported by %s", driver)
+		}
+		db.databaseView = tm.sessionView(ctx, func(ctx context.Context, conn *sql.Conn) error {
+			return tm.execSessionQuery(ctx, conn, "set_tenant_context", "", tenant.ID)
+		})
+
+	case TenantIsolationSchema:
+		if driver := tm.dm.driverName(); driver != "postgres" && driver != "mysql" {
+			return nil, fmt.Errorf("schema isolation is not supported by %s", driver)
+		}
+		schema := tm.tenantSchema(tenant)
+		if err := checkIdent(schema); err != nil {
+			return nil, fmt.Errorf("invalid schema for tenant %s: %w", tenant.ID, err)
+		}
+		db.databaseView = tm.sessionView(ctx, func(ctx context.Context, conn *sql.Conn) error {
+			return tm.execSessionQuery(ctx, conn, "set_tenant_schema", schema)
+		})
+
+	case TenantIsolationDatabase:
+		tenantDM, err := tm.tenantDatabase(tenant)
+		if err != nil {
+			return nil, err
+		}
+		db.databaseView = tenantDM.forRequest(ctx).(*databaseView)
+
+	default:
+		return nil, fmt.Errorf("unknown isolation mode %q for tenant %s", mode, tenant.ID)
+	}
+
+	return db, nil
+}
+
+// sessionView returns a request view whose statements run on one connection
+// prepared by setup
+func (tm *tenantDatabaseManager) sessionView(ctx context.Context, setup func(ctx context.Context, conn *sql.Conn) error) *databaseView {
+	view := tm.dm.forRequest(ctx).(*databaseView)
+	view.session = &connSession{
+		dm:    tm.dm,
+		setup: setup,
+		reset: tm.resetSession,
+	}
+	return view
+}
+
+// resetSession clears the tenant settings of a connection
+func (tm *tenantDatabaseManager) resetSession(conn *sql.Conn) error {
+	tm.dm.mutex.RLock()
+	database := tm.dm.config.Database
+	tm.dm.mutex.RUnlock()
+
+	return tm.execSessionQuery(context.Background(), conn, "reset_tenant_session", database)
+}
+
+// execSessionQuery runs a session statement from the SQL loader on conn. name
+// is formatted into the query, e.g. a schema name, and must be an identifier.
+// Dialects without the statement ship a file with comments only, which is skipped.
+func (tm *tenantDatabaseManager) execSessionQuery(ctx context.Context, conn *sql.Conn, queryName, name string, args ...interface{}) error {
+	query, err := tm.dm.GetQuery(queryName)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(blankSQLLiterals(query)) == "" {
+		return nil
+	}
+	if strings.Contains(query, "%s") {
+		if err := checkIdent(name); err != nil {
+			return fmt.Errorf("failed to %s: %w", strings.ReplaceAll(queryName, "_", " "), err)
+		}
+		query = fmt.Sprintf(query, name)
+	}
+	if _, err := execWithTimeout(ctx, conn, tm.dm.statementTimeout(), query, args...); err != nil {
+		return fmt.Errorf("failed to %s: %w", strings.ReplaceAll(queryName, "_", " "), err)
+	}
+	return nil
+}
+
+// tenantSchema returns the schema of a tenant with schema isolation
+func (tm *tenantDatabaseManager) tenantSchema(tenant *Tenant) string {
+	if schema, ok := tenant.Config["schema"].(string); ok && schema != "" {
+		return schema
+	}
+	return tm.config.SchemaPrefix + strings.ReplaceAll(tenant.ID, "-", "_")
+}
+
+// tenantDatabase returns the connection of a tenant with database isolation,
+// connecting on first use
+func (tm *tenantDatabaseManager) tenantDatabase(tenant *Tenant) (*databaseManager, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if dm, ok := tm.databases[tenant.ID]; ok {
+		return dm, nil
+	}
+
+	raw, ok := tenant.Config["database"]
+	if !ok {
+		return nil, fmt.Errorf("tenant %s has database isolation but no database configuration", tenant.ID)
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database configuration for tenant %s: %w", tenant.ID, err)
+	}
+
+	// Unset fields are inherited from the shared database
+	tm.dm.mutex.RLock()
+	config := tm.dm.config
+	config.Options = make(map[string]string, len(tm.dm.config.Options))
+	for key, value := range tm.dm.config.Options {
+		config.Options[key] = value
+	}
+	tm.dm.mutex.RUnlock()
+	config.Replicas = nil
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid database configuration for tenant %s: %w", tenant.ID, err)
+	}
+
+	dm := NewDatabaseManager().(*databaseManager)
+	if err := dm.Connect(config); err != nil {
+		return nil, fmt.Errorf("failed to connect database of tenant %s: %w", tenant.ID, err)
+	}
+	tm.databases[tenant.ID] = dm
+	return dm, nil
+}
+
+// Close closes the connections opened for database isolation
+func (tm *tenantDatabaseManager) Close() error {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	var firstErr error
+	for id, dm := range tm.databases {
+		if err := dm.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(tm.databases, id)
+	}
+	return firstErr
+}
+
+// tenantDatabase is the TenantDatabase handed out by tenantDatabaseManager.
+// Row isolation checks raw statements; the other modes isolate through the
+// connection or database the view runs on. Framework operations such as
+// sessions and tokens are not tenant data and pass through to the view.
+type tenantDatabase struct {
+	*databaseView
+	tenant *Tenant
+	mode   TenantIsolationMode
+	scope  *tenantScope // Row isolation only
+}
+
+// Tenant returns the tenant the handle is scoped to
+func (t *tenantDatabase) Tenant() *Tenant {
+	return t.tenant
+}
+
+// IsolationMode returns how the tenant's data is isolated
+func (t *tenantDatabase) IsolationMode() TenantIsolationMode {
+	return t.mode
+}
+
+// Release returns the reserved connection to the pool
+func (t *tenantDatabase) Release() error {
+	if t.databaseView.session == nil {
+		return nil
+	}
+	return t.databaseView.session.release()
+}
+
+// tenantScope returns the row filter of row isolation
+func (t *tenantDatabase) tenantScope() *tenantScope {
+	return t.scope
+}
+
+// withView returns a handle for the same tenant on another view
+func (t *tenantDatabase) withView(view *databaseView) *tenantDatabase {
+	return &tenantDatabase{databaseView: view, tenant: t.tenant, mode: t.mode, scope: t.scope}
+}
+
+// forRequest returns a handle for the same tenant bound to another request
+func (t *tenantDatabase) forRequest(ctx context.Context) DatabaseManager {
+	view := t.databaseView.bind(ctx)
+	view.state = &viewState{}
+	if session := t.databaseView.session; session != nil {
+		view.session = &connSession{dm: session.dm, setup: session.setup, reset: session.reset}
+	}
+	return t.withView(view)
+}
+
+// withContext returns a handle of the same request bound to a derived context
+func (t *tenantDatabase) withContext(ctx context.Context) DatabaseManager {
+	return t.withView(t.databaseView.bind(ctx))
+}
+
+// forPrimary keeps the tenant scope for stores that pin reads to the primary
+func (t *tenantDatabase) forPrimary() DatabaseManager {
+	view := t.databaseView.bind(t.databaseView.ctx)
+	view.primaryOnly = true
+	return t.withView(view)
+}
+
+// activeTransaction returns the transaction of the running WithTx callback
+func (t *tenantDatabase) activeTransaction() Transaction {
+	if tx := t.databaseView.activeTransaction(); tx != nil {
+		return t.scopeTx(tx)
+	}
+	return nil
+}
+
+// check applies the row isolation safeguard to a raw statement run with ctx
+func (t *tenantDatabase) check(ctx context.Context, query string, args []interface{}) error {
+	if t.scope == nil {
+		return nil
+	}
+	return t.scope.checkContext(ctx, query, args)
+}
+
+// scopeTx applies the row isolation safeguard to the statements of tx
+func (t *tenantDatabase) scopeTx(tx Transaction) Transaction {
+	if t.scope == nil {
+		return tx
+	}
+	return &tenantTransaction{Transaction: tx, scope: t.scope}
+}
+
+// Query executes a query that returns rows
+func (t *tenantDatabase) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return sqlRows(t.QueryContext(t.databaseView.ctx, query, args...))
+}
+
+// QueryContext executes a query that returns rows
+func (t *tenantDatabase) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	if err := t.check(ctx, query, args); err != nil {
+		return nil, err
+	}
+	return t.databaseView.QueryContext(ctx, query, args...)
+}
+
+// QueryRow executes a query that returns at most one row
+func (t *tenantDatabase) QueryRow(query string, args ...interface{}) *sql.Row {
+	return t.QueryRowContext(t.databaseView.ctx, query, args...).sqlRow()
+}
+
+// QueryRowContext executes a query that returns at most one row. Scanning the
+// row of a statement that fails the tenant check returns an error.
+func (t *tenantDatabase) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	if err := t.check(ctx, query, args); err != nil {
+		return newErrorRow(err)
+	}
+	return t.databaseView.QueryRowContext(ctx, query, args...)
+}
+
+// Exec executes a query without returning rows
+func (t *tenantDatabase) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.ExecContext(t.databaseView.ctx, query, args...)
+}
+
+// ExecContext executes a query without returning rows
+func (t *tenantDatabase) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if err := t.check(ctx, query, args); err != nil {
+		return nil, err
+	}
+	return t.databaseView.ExecContext(ctx, query, args...)
+}
+
+// Prepare creates a prepared statement. Its arguments are only known when it
+// runs, so row isolation rejects statements on tenant tables.
+func (t *tenantDatabase) Prepare(query string) (*sql.Stmt, error) {
+	return t.PrepareContext(t.databaseView.ctx, query)
+}
+
+// PrepareContext creates a prepared statement
+func (t *tenantDatabase) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	if err := t.check(ctx, query, nil); err != nil {
+		return nil, err
+	}
+	return t.databaseView.PrepareContext(ctx, query)
+}
+
+// Begin starts a transaction in the tenant scope
+func (t *tenantDatabase) Begin() (Transaction, error) {
+	return t.BeginTxContext(t.databaseView.ctx, nil)
+}
+
+// BeginTx starts a transaction in the tenant scope
+func (t *tenantDatabase) BeginTx(opts *sql.TxOptions) (Transaction, error) {
+	return t.BeginTxContext(t.databaseView.ctx, opts)
+}
+
+// BeginTxContext starts a transaction in the tenant scope
+func (t *tenantDatabase) BeginTxContext(ctx context.Context, opts *sql.TxOptions) (Transaction, error) {
+	tx, err := t.databaseView.BeginTxContext(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return t.scopeTx(tx), nil
+}
+
+// WithTx runs fn in a transaction in the tenant scope
+func (t *tenantDatabase) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Transaction) error) error {
+	return t.databaseView.WithTx(ctx, opts, func(tx Transaction) error {
+		return fn(t.scopeTx(tx))
+	})
+}
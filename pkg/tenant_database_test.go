@@ -0,0 +1,261 @@
+//go:build !test
+// +build !test
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// connectTenantTestDB connects to a fresh SQLite database with documents of two tenants
+func connectTenantTestDB(t *testing.T) DatabaseManager {
+	t.Helper()
+	dm := NewDatabaseManager()
+	if err := dm.Connect(createTestDBConfig(filepath.Join(t.TempDir(), "tenants.db"))); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { dm.Close() })
+
+	for _, stmt := range []string{
+		"CREATE TABLE documents (id INTEGER PRIMARY KEY, tenant_id TEXT NOT NULL, title TEXT)",
+		"CREATE TABLE countries (code TEXT PRIMARY KEY)",
+		"INSERT INTO documents (id, tenant_id, title) VALUES (1, 't1', 'a'), (2, 't2', 'b'), (3, 't1', 'c')",
+	} {
+		if _, err := dm.Exec(stmt); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+	}
+	return dm
+}
+
+// forTenant returns a handle for tenant, released at the end of the test
+func forTenant(t *testing.T, tenants TenantDatabaseManager, tenant *Tenant) TenantDatabase {
+	t.Helper()
+	db, err := tenants.ForTenant(context.Background(), tenant)
+	if err != nil {
+		t.Fatalf("ForTenant failed: %v", err)
+	}
+	t.Cleanup(func() { db.Release() })
+	return db
+}
+
+func TestIntegration_TenantRowIsolationQueryBuilder(t *testing.T) {
+	dm := connectTenantTestDB(t)
+	tenants, err := NewTenantDatabaseManager(dm, TenantIsolationConfig{TenantTables: []string{"documents"}})
+	if err != nil {
+		t.Fatalf("NewTenantDatabaseManager failed: %v", err)
+	}
+	db := forTenant(t, tenants, &Tenant{ID: "t1"})
+	qb := NewQueryBuilder(db)
+	ctx := context.Background()
+
+	titles, err := QueryAll[string](ctx, qb.Select("title").From("documents").OrderBy("id"))
+	if err != nil || !reflect.DeepEqual(titles, []string{"a", "c"}) {
+		t.Fatalf("Expected only the tenant's documents, got %v (%v)", titles, err)
+	}
+
+	// Inserts get the tenant column; another tenant's ID is rejected
+	if _, err := qb.Insert("documents").Columns("id", "title").Values(4, "d").Exec(ctx); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	_, err = qb.Insert("documents").Columns("id", "tenant_id", "title").Values(5, "t2", "e").Exec(ctx)
+	assertFrameworkErrorCode(t, err, ErrCodeTenantScope)
+
+	// An upsert conflicting with another tenant's row leaves it unchanged
+	if _, err := qb.Insert("documents").Columns("id", "title").Values(2, "stolen").OnConflict("id").DoUpdate().Exec(ctx); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+
+	// Updates and deletes only reach the tenant's rows
+	if _, err := qb.Update("documents").Set("title", "x").Exec(ctx); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := qb.Update("documents").Set("tenant_id", "t2").Exec(ctx); err == nil {
+		t.Error("Expected moving rows to another tenant to be rejected")
+	}
+	if _, err := qb.Delete("documents").Where(Eq("id", 3)).Exec(ctx); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	rows, err := QueryAll[string](ctx, NewQueryBuilder(dm).Select("tenant_id || ':' || title").From("documents").OrderBy("id"))
+	if err != nil || !reflect.DeepEqual(rows, []string{"t1:x", "t2:b", "t1:x"}) {
+		t.Errorf("Unexpected documents: %v (%v)", rows, err)
+	}
+
+	// Joins are scoped in their ON clause
+	query, args, err := qb.Select("documents.title").From("documents").
+		LeftJoin("documents_archive", "documents_archive.id = documents.id").ToSQL()
+	if err != nil || query != "SELECT documents.title FROM documents LEFT JOIN documents_archive ON documents_archive.id = documents.id WHERE documents.tenant_id = ?" || !reflect.DeepEqual(args, []interface{}{"t1"}) {
+		t.Errorf("Unexpected join: %s %v (%v)", query, args, err)
+	}
+}
+
+func TestIntegration_TenantRowIsolationRawSQL(t *testing.T) {
+	dm := connectTenantTestDB(t)
+	tenants, _ := NewTenantDatabaseManager(dm, TenantIsolationConfig{TenantTables: []string{"documents"}})
+	db := forTenant(t, tenants, &Tenant{ID: "t1"})
+
+	_, err := db.Query("SELECT title FROM documents")
+	assertFrameworkErrorCode(t, err, ErrCodeTenantScope)
+	_, err = db.Exec("DELETE FROM documents WHERE id = ?", 2)
+	assertFrameworkErrorCode(t, err, ErrCodeTenantScope)
+	var title string
+	err = db.QueryRow("SELECT title FROM documents WHERE id = ?", 2).Scan(&title)
+	assertFrameworkErrorCode(t, err, ErrCodeTenantScope)
+	_, err = db.Query("SELECT title FROM documents WHERE tenant_id = ? OR id = ?", "t1", 2)
+	assertFrameworkErrorCode(t, err, ErrCodeTenantScope)
+
+	// Scoped statements and tables without tenant rows pass
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM documents WHERE tenant_id = ?", "t1").Scan(&count); err != nil || count != 2 {
+		t.Errorf("Expected 2 documents, got %d (%v)", count, err)
+	}
+	if _, err := db.Exec("INSERT INTO countries (code) VALUES (?)", "de"); err != nil {
+		t.Errorf("Expected statement on a shared table to pass: %v", err)
+	}
+
+	// Transactions are checked too
+	err = db.WithTx(context.Background(), nil, func(tx Transaction) error {
+		_, err := tx.Exec("UPDATE documents SET title = ?", "x")
+		return err
+	})
+	assertFrameworkErrorCode(t, err, ErrCodeTenantScope)
+}
+
+func TestIntegration_TenantDatabaseIsolation(t *testing.T) {
+	dm := connectTenantTestDB(t)
+	tenants, _ := NewTenantDatabaseManager(dm, TenantIsolationConfig{})
+	t.Cleanup(func() { tenants.Close() })
+
+	tenant := &Tenant{ID: "t3", Config: map[string]interface{}{
+		"isolation": "database",
+		"database":  map[string]interface{}{"database": filepath.Join(t.TempDir(), "t3.db")},
+	}}
+	db := forTenant(t, tenants, tenant)
+	if db.IsolationMode() != TenantIsolationDatabase {
+		t.Fatalf("Expected database isolation, got %s", db.IsolationMode())
+	}
+
+	if _, err := db.Exec("CREATE TABLE documents (id INTEGER PRIMARY KEY, title TEXT)"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO documents (id, title) VALUES (1, 'own')"); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	var count int
+	dm.QueryRow("SELECT COUNT(*) FROM documents").Scan(&count)
+	if count != 3 {
+		t.Errorf("Expected the shared database to be untouched, got %d documents", count)
+	}
+
+	// The same connection is reused for the tenant
+	again := forTenant(t, tenants, tenant)
+	if err := again.QueryRow("SELECT COUNT(*) FROM documents").Scan(&count); err != nil || count != 1 {
+		t.Errorf("Expected the tenant database, got %d documents (%v)", count, err)
+	}
+
+	for _, mode := range []string{"rls", "schema", "unknown"} {
+		_, err := tenants.ForTenant(context.Background(), &Tenant{ID: "t4", Config: map[string]interface{}{"isolation": mode}})
+		if err == nil {
+			t.Errorf("Expected %s isolation to be rejected on sqlite", mode)
+		}
+	}
+}
+
+func TestIntegration_TenantIsolationMiddleware(t *testing.T) {
+	dm := connectTenantTestDB(t)
+	tenants, _ := NewTenantDatabaseManager(dm, TenantIsolationConfig{})
+	middleware := TenantIsolationMiddleware(tenants)
+
+	ctx := &contextImpl{db: dm, ctx: context.Background()}
+	ctx.SetTenant(&Tenant{ID: "t2"})
+
+	err := middleware(ctx, func(ctx Context) error {
+		db, ok := ctx.DB().(TenantDatabase)
+		if !ok || db.Tenant().ID != "t2" {
+			return fmt.Errorf("expected a database scoped to t2, got %T", ctx.DB())
+		}
+		titles, err := QueryAll[string](ctx.Context(), NewQueryBuilder(ctx.DB()).Select("title").From("documents"))
+		if err != nil || !reflect.DeepEqual(titles, []string{"b"}) {
+			return fmt.Errorf("unexpected titles %v (%v)", titles, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.DB() != dm {
+		t.Error("Expected the database to be restored after the request")
+	}
+
+	// Requests without a tenant are left alone
+	ctx.SetTenant(nil)
+	middleware(ctx, func(ctx Context) error {
+		if _, ok := ctx.DB().(TenantDatabase); ok {
+			t.Error("Expected no tenant scope without a tenant")
+		}
+		return nil
+	})
+}
+
+// scopeReporter records the failures reported by CheckTenantScope
+type scopeReporter struct {
+	errors []string
+}
+
+func (r *scopeReporter) Helper() {}
+
+func (r *scopeReporter) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestIntegration_CheckTenantScope(t *testing.T) {
+	dm := connectTenantTestDB(t)
+	tenants, _ := NewTenantDatabaseManager(dm, TenantIsolationConfig{})
+	t.Cleanup(func() { tenants.Close() })
+
+	// A swallowed scope error still fails the test
+	reporter := &scopeReporter{}
+	db := CheckTenantScope(reporter, forTenant(t, tenants, &Tenant{ID: "t1"}))
+	db.Exec("UPDATE documents SET title = ?", "x")
+	if len(reporter.errors) != 1 {
+		t.Errorf("Expected one reported violation, got %v", reporter.errors)
+	}
+
+	// Builder statements are scoped
+	reporter.errors = nil
+	if _, err := QueryAll[string](context.Background(), NewQueryBuilder(db).Select("title").From("documents")); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(reporter.errors) != 0 {
+		t.Errorf("Expected no violations, got %v", reporter.errors)
+	}
+
+	// Rows of another tenant are detected in any isolation mode
+	reporter.errors = nil
+	isolated := CheckTenantScope(reporter, forTenant(t, tenants, &Tenant{ID: "t5", Config: map[string]interface{}{
+		"isolation": "database",
+		"database":  map[string]interface{}{"database": filepath.Join(t.TempDir(), "t5.db")},
+	}}))
+	isolated.Exec("CREATE TABLE documents (id INTEGER PRIMARY KEY, tenant_id TEXT)")
+	isolated.Exec("INSERT INTO documents (id, tenant_id) VALUES (1, 't5'), (2, 't1')")
+	rows, err := isolated.Query("SELECT id, tenant_id FROM documents")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	rows.Close()
+	if len(reporter.errors) != 1 {
+		t.Errorf("Expected the foreign row to be reported, got %v", reporter.errors)
+	}
+
+	var frameworkErr *FrameworkError
+	if !errors.As(NewTenantScopeError("t1", "documents"), &frameworkErr) || frameworkErr.StatusCode != 403 {
+		t.Error("Expected scope violations to be forbidden")
+	}
+}
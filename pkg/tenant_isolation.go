@@ -0,0 +1,270 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TenantIsolationMode selects how the data of a tenant is kept apart from
+// other tenants. A tenant overrides the default with Tenant.Config["isolation"].
+type TenantIsolationMode string
+
+const (
+	// TenantIsolationRow keeps all tenants in shared tables with a tenant column.
+	// The query builder adds the tenant predicate, raw SQL on tenant tables is
+	// rejected unless its WHERE clause requires tenant_column = ? bound to the tenant.
+	TenantIsolationRow TenantIsolationMode = "row"
+
+	// TenantIsolationRLS sets app.tenant_id on the connection for row-level
+	// security policies (PostgreSQL and MSSQL)
+	TenantIsolationRLS TenantIsolationMode = "rls"
+
+	// TenantIsolationSchema resolves tables in the tenant's schema, taken from
+	// Tenant.Config["schema"] or SchemaPrefix plus the tenant ID (PostgreSQL and MySQL)
+	TenantIsolationSchema TenantIsolationMode = "schema"
+
+	// TenantIsolationDatabase connects to the database configured in
+	// Tenant.Config["database"], an object with the fields of DatabaseConfig
+	TenantIsolationDatabase TenantIsolationMode = "database"
+)
+
+// TenantIsolationConfig configures a TenantDatabaseManager
+type TenantIsolationConfig struct {
+	// DefaultMode is used for tenants without an isolation setting.
+	// Default: TenantIsolationRow
+	DefaultMode TenantIsolationMode
+
+	// TenantColumn is the column holding the tenant ID in row isolation.
+	// Default: "tenant_id"
+	TenantColumn string
+
+	// TenantTables lists the tables that hold tenant rows in row isolation.
+	// Default: empty (every table except the framework's background_jobs and
+	// outbox_messages is treated as a tenant table)
+	TenantTables []string
+
+	// SchemaPrefix is prepended to the tenant ID to name tenant schemas
+	// when Tenant.Config has no schema.
+	// Default: "tenant_"
+	SchemaPrefix string
+}
+
+// TenantDatabase is a DatabaseManager scoped to a single tenant. Release must
+// be called when the handle is no longer used; TenantIsolationMiddleware does
+// that at the end of the request.
+type TenantDatabase interface {
+	DatabaseManager
+
+	// Tenant returns the tenant the handle is scoped to
+	Tenant() *Tenant
+
+	// IsolationMode returns how the tenant's data is isolated
+	IsolationMode() TenantIsolationMode
+
+	// Release returns the connection reserved by RLS and schema isolation to the pool
+	Release() error
+
+	driverName() string
+	tenantScope() *tenantScope
+}
+
+// TenantDatabaseManager hands out tenant-scoped database handles
+type TenantDatabaseManager interface {
+	// ForTenant returns a handle whose statements only see tenant's data.
+	// Statements run with ctx unless they are given their own context.
+	ForTenant(ctx context.Context, tenant *Tenant) (TenantDatabase, error)
+
+	// Close closes the connections opened for database isolation
+	Close() error
+}
+
+// TenantIsolationMiddleware replaces ctx.DB() with a handle scoped to
+// ctx.Tenant() for the rest of the request. Requests without a tenant keep
+// the unscoped database.
+func TenantIsolationMiddleware(tenants TenantDatabaseManager) MiddlewareFunc {
+	return func(ctx Context, next HandlerFunc) error {
+		tenant := ctx.Tenant()
+		if tenant == nil {
+			return next(ctx)
+		}
+
+		setter, ok := ctx.(interface{ SetDB(db DatabaseManager) })
+		if !ok {
+			return fmt.Errorf("context %T does not support replacing the database", ctx)
+		}
+
+		db, err := tenants.ForTenant(ctx.Context(), tenant)
+		if err != nil {
+			return err
+		}
+		defer db.Release()
+
+		original := ctx.DB()
+		setter.SetDB(db)
+		defer setter.SetDB(original)
+
+		return next(ctx)
+	}
+}
+
+// tenantIsolationMode returns the isolation mode of tenant
+func tenantIsolationMode(tenant *Tenant, defaultMode TenantIsolationMode) TenantIsolationMode {
+	if mode, ok := tenant.Config["isolation"].(string); ok && mode != "" {
+		return TenantIsolationMode(mode)
+	}
+	return defaultMode
+}
+
+// tenantScopedDatabase is implemented by databases that filter rows by tenant
+type tenantScopedDatabase interface {
+	tenantScope() *tenantScope
+}
+
+// tableRefPattern finds the tables a statement reads or writes
+var tableRefPattern = regexp.MustCompile(`(?i)\b(?:from|join|into|update)\s+([A-Za-z_][A-Za-z0-9_.]*)`)
+
+// tenantScope restricts statements to the rows of one tenant
+type tenantScope struct {
+	tenantID     string
+	column       string
+	predicateRes []*regexp.Regexp // tenant_column = ? in both operand orders
+	tables       map[string]bool  // Empty means every table
+}
+
+// newTenantScope creates the row filter of a tenant
+func newTenantScope(tenantID, column string, tables []string) *tenantScope {
+	scope := &tenantScope{
+		tenantID: tenantID,
+		column:   column,
+		tables:   make(map[string]bool, len(tables)),
+	}
+	columnPattern := "(?:(?P<qualifier>[\\w\"`\\[\\]]+)\\.)?[\"`\\[]?" + regexp.QuoteMeta(column) + "[\"`\\]]?"
+	placeholderPattern := `(?P<placeholder>` + sqlPlaceholderPattern + `)`
+	scope.predicateRes = []*regexp.Regexp{
+		regexp.MustCompile(`(?is)^` + columnPattern + `\s*=\s*` + placeholderPattern + `$`),
+		regexp.MustCompile(`(?is)^` + placeholderPattern + `\s*=\s*` + columnPattern + `$`),
+	}
+	for _, table := range tables {
+		scope.tables[strings.ToLower(table)] = true
+	}
+	return scope
+}
+
+// frameworkTables are written by ctx.Jobs() and the outbox inside a tenant's
+// transaction. They hold no tenant column, so they are only tenant tables when
+// TenantTables names them.
+var frameworkTables = map[string]bool{
+	"background_jobs": true,
+	"outbox_messages": true,
+}
+
+// appliesTo reports whether table holds tenant rows
+func (s *tenantScope) appliesTo(table string) bool {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		table = table[i+1:]
+	}
+	table = strings.ToLower(table)
+	if len(s.tables) == 0 {
+		return !frameworkTables[table]
+	}
+	return s.tables[table]
+}
+
+// checkContext applies check to a statement run with ctx
+func (s *tenantScope) checkContext(ctx context.Context, query string, args []interface{}) error {
+	if ctx != nil && isTenantScopedStatement(ctx) {
+		return nil
+	}
+	return s.check(query, args)
+}
+
+// bindsTenant reports whether the tenant ID is one of the arguments
+func (s *tenantScope) bindsTenant(args []interface{}) bool {
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			if v == s.tenantID {
+				return true
+			}
+		case []byte:
+			if string(v) == s.tenantID {
+				return true
+			}
+		case fmt.Stringer:
+			if v.String() == s.tenantID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matches reports whether a tenant column value belongs to the tenant
+func (s *tenantScope) matches(value interface{}) bool {
+	return s.bindsTenant([]interface{}{value})
+}
+
+// tenantTransaction applies the row isolation safeguard to the statements of a transaction
+type tenantTransaction struct {
+	Transaction
+	scope *tenantScope
+}
+
+func (t *tenantTransaction) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	if err := t.scope.check(query, args); err != nil {
+		return nil, err
+	}
+	return t.Transaction.Query(query, args...)
+}
+
+func (t *tenantTransaction) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	if err := t.scope.checkContext(ctx, query, args); err != nil {
+		return nil, err
+	}
+	return t.Transaction.QueryContext(ctx, query, args...)
+}
+
+func (t *tenantTransaction) QueryRow(query string, args ...interface{}) *sql.Row {
+	if err := t.scope.check(query, args); err != nil {
+		return errorSQLRow(err)
+	}
+	return t.Transaction.QueryRow(query, args...)
+}
+
+func (t *tenantTransaction) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	if err := t.scope.checkContext(ctx, query, args); err != nil {
+		return newErrorRow(err)
+	}
+	return t.Transaction.QueryRowContext(ctx, query, args...)
+}
+
+func (t *tenantTransaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if err := t.scope.check(query, args); err != nil {
+		return nil, err
+	}
+	return t.Transaction.Exec(query, args...)
+}
+
+func (t *tenantTransaction) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if err := t.scope.checkContext(ctx, query, args); err != nil {
+		return nil, err
+	}
+	return t.Transaction.ExecContext(ctx, query, args...)
+}
+
+func (t *tenantTransaction) Prepare(query string) (*sql.Stmt, error) {
+	if err := t.scope.check(query, nil); err != nil {
+		return nil, err
+	}
+	return t.Transaction.Prepare(query)
+}
+
+func (t *tenantTransaction) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	if err := t.scope.check(query, nil); err != nil {
+		return nil, err
+	}
+	return t.Transaction.PrepareContext(ctx, query)
+}
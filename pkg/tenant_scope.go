@@ -0,0 +1,442 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Patterns of the raw SQL check of row isolation. They run on statements
+// whose comments and string literals have been blanked out.
+var (
+	// sqlPlaceholderPattern matches a bind parameter in any dialect
+	sqlPlaceholderPattern = `(\?|\$\d+|@\w+|:\w+)`
+
+	// sqlSegmentPattern separates the statements and set operands of a query
+	sqlSegmentPattern = regexp.MustCompile(`(?i);|\b(?:union|intersect|except)\b`)
+
+	// sqlWherePattern finds the WHERE clause of a statement
+	sqlWherePattern = regexp.MustCompile(`(?i)\bwhere\b`)
+
+	// sqlClauseEndPattern finds the clause following a WHERE clause
+	sqlClauseEndPattern = regexp.MustCompile(`(?i)\b(?:group\s+by|order\s+by|having|limit|offset|returning|fetch|for\s+update|window)\b`)
+
+	// sqlAndPattern and sqlOrPattern split a condition into its operands
+	sqlAndPattern = regexp.MustCompile(`(?i)\band\b`)
+	sqlOrPattern  = regexp.MustCompile(`(?i)\bor\b`)
+
+	// sqlTableAliasPattern matches the alias following a table reference
+	sqlTableAliasPattern = regexp.MustCompile(`(?i)^\s+(?:as\s+)?([A-Za-z_][A-Za-z0-9_]*)`)
+
+	// sqlTableListPattern matches the next table of a comma-separated FROM list
+	sqlTableListPattern = regexp.MustCompile(`^\s*,\s*([A-Za-z_][A-Za-z0-9_.]*)`)
+
+	// sqlInsertPattern matches an INSERT with a column list and VALUES
+	sqlInsertPattern = regexp.MustCompile(`(?is)^\s*insert\s+(?:or\s+\w+\s+)?into\s+[\w.]+\s*\(([^()]*)\)\s*(?:output\s+[^()]*?\s+)?values\s*`)
+)
+
+// sqlKeywords can follow a table reference, so they are not taken as its alias
+var sqlKeywords = map[string]bool{
+	"as": true, "cross": true, "except": true, "fetch": true, "for": true,
+	"full": true, "group": true, "having": true, "inner": true, "intersect": true,
+	"join": true, "left": true, "limit": true, "natural": true, "offset": true,
+	"on": true, "order": true, "output": true, "outer": true, "returning": true,
+	"right": true, "select": true, "set": true, "union": true, "using": true,
+	"values": true, "where": true, "window": true, "with": true,
+}
+
+// tenantScopedStatementKey marks the context of a statement the query builder
+// has scoped to the tenant itself
+type tenantScopedStatementKey struct{}
+
+// withTenantScopedStatement marks ctx as running a statement of the query builder
+func withTenantScopedStatement(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantScopedStatementKey{}, true)
+}
+
+// isTenantScopedStatement reports whether ctx runs a statement of the query builder
+func isTenantScopedStatement(ctx context.Context) bool {
+	scoped, _ := ctx.Value(tenantScopedStatementKey{}).(bool)
+	return scoped
+}
+
+// check rejects a raw statement on a tenant table unless every statement and
+// set operand that reads or writes a tenant table either
+//
+//   - has a WHERE clause whose top-level AND chain contains tenant_column = ?
+//     with the placeholder bound to the tenant ID, qualified by the table or
+//     its alias when the statement references more than one tenant table,
+//     for every tenant table of the statement, or
+//   - is an INSERT ... VALUES that binds the tenant ID to the tenant column
+//     of every row.
+//
+// A WHERE clause with a top-level OR is rejected. This is a safeguard against
+// forgotten predicates, not a SQL parser, and it does not cover:
+//
+//   - subqueries and derived tables; only the tables of the outer statement
+//     are filtered by the predicate
+//   - UPDATE statements assigning the tenant column
+//   - INSERT ... SELECT and MERGE, unless the statement has a matching WHERE clause
+//
+// The query builder scopes its statements itself, so they are not checked.
+func (s *tenantScope) check(query string, args []interface{}) error {
+	query = blankSQLLiterals(query)
+
+	start := 0
+	for _, bound := range append(sqlSegmentPattern.FindAllStringIndex(maskNestedSQL(query), -1), []int{len(query), len(query)}) {
+		end := bound[0]
+		if table := s.unfilteredTable(query, start, end, args); table != "" {
+			return NewTenantScopeError(s.tenantID, table)
+		}
+		start = bound[1]
+	}
+	return nil
+}
+
+// tenantTable returns the first tenant table a statement reads or writes
+func (s *tenantScope) tenantTable(statement string) string {
+	for _, match := range tableRefPattern.FindAllStringSubmatch(statement, -1) {
+		if s.appliesTo(match[1]) {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// sqlTableRef is a tenant table in the outer statement
+type sqlTableRef struct {
+	table string
+	name  string // Alias or unqualified table name, lowercased
+}
+
+// filteredBy reports whether a tenant predicate with one of qualifiers
+// filters the table. An unqualified predicate only filters the only table.
+func (r sqlTableRef) filteredBy(qualifiers []string, only bool) bool {
+	for _, qualifier := range qualifiers {
+		if qualifier == r.name || (qualifier == "" && only) {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantTableRefs returns the tenant tables of a statement whose nested SQL
+// has been masked, including every table of a comma-separated FROM list
+func (s *tenantScope) tenantTableRefs(statement string) []sqlTableRef {
+	var refs []sqlTableRef
+	add := func(table string, pos int) int {
+		name := table
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			name = name[i+1:]
+		}
+		if alias := sqlTableAliasPattern.FindStringSubmatchIndex(statement[pos:]); alias != nil && !sqlKeywords[strings.ToLower(statement[pos+alias[2]:pos+alias[3]])] {
+			name = statement[pos+alias[2] : pos+alias[3]]
+			pos += alias[1]
+		}
+		if s.appliesTo(table) {
+			refs = append(refs, sqlTableRef{table: table, name: strings.ToLower(name)})
+		}
+		return pos
+	}
+
+	for _, match := range tableRefPattern.FindAllStringSubmatchIndex(statement, -1) {
+		pos := add(statement[match[2]:match[3]], match[3])
+		if !strings.EqualFold(statement[match[0]:match[0]+4], "from") {
+			continue
+		}
+		for {
+			next := sqlTableListPattern.FindStringSubmatchIndex(statement[pos:])
+			if next == nil {
+				break
+			}
+			pos = add(statement[pos+next[2]:pos+next[3]], pos+next[3])
+		}
+	}
+	return refs
+}
+
+// unfilteredTable returns a tenant table the statement in query[start:end]
+// reads or writes without restricting it to the tenant, or ""
+func (s *tenantScope) unfilteredTable(query string, start, end int, args []interface{}) string {
+	table := s.tenantTable(query[start:end])
+	if table == "" || s.insertBindsTenant(query, start, end, args) {
+		return ""
+	}
+
+	masked := maskNestedSQL(query[start:end])
+	where := sqlWherePattern.FindStringIndex(masked)
+	if where == nil {
+		return table
+	}
+	clauseEnd := end
+	if next := sqlClauseEndPattern.FindStringIndex(masked[where[1]:]); next != nil {
+		clauseEnd = start + where[1] + next[0]
+	}
+	qualifiers := s.tenantPredicates(query, start+where[1], clauseEnd, args)
+	if len(qualifiers) == 0 {
+		return table
+	}
+
+	refs := s.tenantTableRefs(masked[:where[0]])
+	for _, ref := range refs {
+		if !ref.filteredBy(qualifiers, len(refs) == 1) {
+			return ref.table
+		}
+	}
+	return ""
+}
+
+// tenantPredicates returns the qualifiers of the tenant predicates in the
+// condition in query[start:end], "" for an unqualified column. Only operands
+// of a top-level AND chain count, so a condition with an OR has none.
+func (s *tenantScope) tenantPredicates(query string, start, end int, args []interface{}) []string {
+	start, end = trimSQLSpan(query, start, end)
+	for start < end && query[start] == '(' && closingParen(query, start) == end-1 {
+		start, end = trimSQLSpan(query, start+1, end-1)
+	}
+
+	masked := maskNestedSQL(query[start:end])
+	if sqlOrPattern.MatchString(masked) {
+		return nil
+	}
+
+	var qualifiers []string
+	operandStart := start
+	for _, and := range append(sqlAndPattern.FindAllStringIndex(masked, -1), []int{end - start, end - start}) {
+		operandEnd := start + and[0]
+		qualifiers = append(qualifiers, s.operandPredicates(query, operandStart, operandEnd, args)...)
+		operandStart = start + and[1]
+	}
+	return qualifiers
+}
+
+// operandPredicates returns the qualifier of query[start:end] if it is
+// tenant_column = ? with the tenant ID bound, or the qualifiers of a
+// parenthesized AND chain
+func (s *tenantScope) operandPredicates(query string, start, end int, args []interface{}) []string {
+	start, end = trimSQLSpan(query, start, end)
+	if start < end && query[start] == '(' && closingParen(query, start) == end-1 {
+		return s.tenantPredicates(query, start, end, args)
+	}
+
+	for _, pattern := range s.predicateRes {
+		match := pattern.FindStringSubmatchIndex(query[start:end])
+		if match == nil {
+			continue
+		}
+		placeholder := 2 * pattern.SubexpIndex("placeholder")
+		arg, ok := placeholderArg(query, start+match[placeholder], query[start+match[placeholder]:start+match[placeholder+1]], args)
+		if !ok || !s.matches(arg) {
+			return nil
+		}
+		qualifier := ""
+		if i := 2 * pattern.SubexpIndex("qualifier"); match[i] >= 0 {
+			qualifier = strings.ToLower(strings.Trim(query[start+match[i]:start+match[i+1]], `"[]`+"`"))
+		}
+		return []string{qualifier}
+	}
+	return nil
+}
+
+// insertBindsTenant reports whether the statement in query[start:end] is an
+// INSERT ... VALUES binding the tenant ID to the tenant column of every row
+func (s *tenantScope) insertBindsTenant(query string, start, end int, args []interface{}) bool {
+	match := sqlInsertPattern.FindStringSubmatchIndex(query[start:end])
+	if match == nil {
+		return false
+	}
+
+	column := -1
+	for i, name := range strings.Split(query[start+match[2]:start+match[3]], ",") {
+		if strings.EqualFold(strings.Trim(strings.TrimSpace(name), `"[]`+"`"), s.column) {
+			column = i
+		}
+	}
+	if column < 0 {
+		return false
+	}
+
+	rows := 0
+	pos := start + match[1]
+	for {
+		pos, _ = trimSQLSpan(query, pos, end)
+		if pos >= end || query[pos] != '(' {
+			break
+		}
+		closing := closingParen(query, pos)
+		if closing < 0 || closing >= end {
+			return false
+		}
+
+		values := splitSQLList(query, pos+1, closing)
+		if column >= len(values) {
+			return false
+		}
+		valueStart, valueEnd := trimSQLSpan(query, values[column][0], values[column][1])
+		arg, ok := placeholderArg(query, valueStart, query[valueStart:valueEnd], args)
+		if !ok || !s.matches(arg) {
+			return false
+		}
+		rows++
+
+		pos, _ = trimSQLSpan(query, closing+1, end)
+		if pos >= end || query[pos] != ',' {
+			break
+		}
+		pos++
+	}
+	return rows > 0
+}
+
+// placeholderArg returns the argument bound to the placeholder at query[pos:]
+func placeholderArg(query string, pos int, placeholder string, args []interface{}) (interface{}, bool) {
+	index := -1
+	switch {
+	case placeholder == "?":
+		index = strings.Count(query[:pos], "?")
+	case strings.HasPrefix(placeholder, "$"):
+		n, err := strconv.Atoi(placeholder[1:])
+		if err != nil {
+			return nil, false
+		}
+		index = n - 1
+	case strings.HasPrefix(placeholder, "@p"):
+		if n, err := strconv.Atoi(placeholder[2:]); err == nil {
+			index = n - 1
+		}
+	}
+
+	if index < 0 {
+		// Named parameter (@name or :name)
+		for _, arg := range args {
+			if named, ok := arg.(sql.NamedArg); ok && named.Name == placeholder[1:] {
+				return named.Value, true
+			}
+		}
+		return nil, false
+	}
+	if index >= len(args) {
+		return nil, false
+	}
+	if named, ok := args[index].(sql.NamedArg); ok {
+		return named.Value, true
+	}
+	return args[index], true
+}
+
+// blankSQLLiterals replaces comments and the contents of string literals with
+// spaces, keeping the offsets of the remaining SQL
+func blankSQLLiterals(query string) string {
+	out := []byte(query)
+	for i := 0; i < len(out); i++ {
+		switch {
+		case out[i] == '\'':
+			for i++; i < len(out); i++ {
+				if out[i] == '\'' {
+					if i+1 < len(out) && out[i+1] == '\'' {
+						out[i], out[i+1] = ' ', ' '
+						i++
+						continue
+					}
+					break
+				}
+				out[i] = ' '
+			}
+		case out[i] == '-' && i+1 < len(out) && out[i+1] == '-':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(out)
+			} else {
+				end += i + 4
+			}
+			for ; i < end; i++ {
+				out[i] = ' '
+			}
+			i--
+		}
+	}
+	return string(out)
+}
+
+// maskNestedSQL replaces everything inside parentheses with spaces, so
+// patterns only match the top level of a statement
+func maskNestedSQL(query string) string {
+	out := []byte(query)
+	depth := 0
+	for i, c := range out {
+		switch {
+		case c == '(':
+			if depth > 0 {
+				out[i] = ' '
+			}
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+			if depth > 0 {
+				out[i] = ' '
+			}
+		case depth > 0:
+			out[i] = ' '
+		}
+	}
+	return string(out)
+}
+
+// closingParen returns the offset of the parenthesis closing query[open], or -1
+func closingParen(query string, open int) int {
+	depth := 0
+	for i := open; i < len(query); i++ {
+		switch query[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitSQLList returns the spans of the comma-separated items in query[start:end]
+func splitSQLList(query string, start, end int) [][2]int {
+	var items [][2]int
+	depth := 0
+	itemStart := start
+	for i := start; i < end; i++ {
+		switch query[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, [2]int{itemStart, i})
+				itemStart = i + 1
+			}
+		}
+	}
+	return append(items, [2]int{itemStart, end})
+}
+
+// trimSQLSpan shrinks query[start:end] to exclude surrounding whitespace
+func trimSQLSpan(query string, start, end int) (int, int) {
+	for start < end && isSQLSpace(query[start]) {
+		start++
+	}
+	for end > start && isSQLSpace(query[end-1]) {
+		end--
+	}
+	return start, end
+}
+
+func isSQLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
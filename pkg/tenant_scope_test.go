@@ -0,0 +1,114 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestTenantScopeCheck(t *testing.T) {
+	scope := newTenantScope("t1", "tenant_id", []string{"documents"})
+
+	tests := []struct {
+		name  string
+		query string
+		args  []interface{}
+		ok    bool
+	}{
+		{"shared table", "SELECT * FROM countries", nil, true},
+		{"predicate", "SELECT * FROM documents WHERE tenant_id = ?", []interface{}{"t1"}, true},
+		{"qualified predicate", "SELECT d.title FROM documents d WHERE d.id = ? AND d.tenant_id = ?", []interface{}{5, "t1"}, true},
+		{"reversed predicate", "DELETE FROM documents WHERE $1 = tenant_id", []interface{}{"t1"}, true},
+		{"mssql placeholder", "UPDATE documents SET title = @p1 WHERE id = @p2 AND tenant_id = @p3", []interface{}{"x", 5, "t1"}, true},
+		{"named argument", "SELECT * FROM documents WHERE tenant_id = @tenant", []interface{}{sql.Named("tenant", "t1")}, true},
+		{"nested conjunction", "SELECT * FROM documents WHERE ((id = ? OR id = ?) AND (tenant_id = ?)) ORDER BY id", []interface{}{1, 2, "t1"}, true},
+		{"insert", "INSERT INTO documents (id, tenant_id, title) VALUES (?, ?, ?), (?, ?, ?)", []interface{}{1, "t1", "a", 2, "t1", "b"}, true},
+		{"union of scoped selects", "SELECT id FROM documents WHERE tenant_id = ? UNION SELECT id FROM documents WHERE tenant_id = ?", []interface{}{"t1", "t1"}, true},
+
+		{"no predicate", "SELECT * FROM documents", nil, false},
+		{"tenant bound to another column", "SELECT * FROM documents WHERE tenant_id IS NOT NULL AND title = ?", []interface{}{"t1"}, false},
+		{"predicate bound to another tenant", "SELECT * FROM documents WHERE title = ? AND tenant_id = ?", []interface{}{"t1", "t2"}, false},
+		{"top-level or", "SELECT * FROM documents WHERE tenant_id = ? OR 1 = 1", []interface{}{"t1"}, false},
+		{"predicate inside or", "SELECT * FROM documents WHERE (tenant_id = ? OR id > 0)", []interface{}{"t1"}, false},
+		{"predicate in subquery", "SELECT * FROM documents WHERE id IN (SELECT id FROM documents WHERE tenant_id = ?)", []interface{}{"t1"}, false},
+		{"predicate in literal", "SELECT * FROM documents WHERE title = 'tenant_id = ?' AND id = ?", []interface{}{"t1"}, false},
+		{"predicate in comment", "SELECT * FROM documents -- WHERE tenant_id = ?\nWHERE id = ?", []interface{}{"t1"}, false},
+		{"assignment instead of predicate", "UPDATE documents SET tenant_id = ? WHERE id = ?", []interface{}{"t1", 5}, false},
+		{"unscoped union operand", "SELECT id FROM documents WHERE tenant_id = ? UNION SELECT id FROM documents", []interface{}{"t1"}, false},
+		{"second statement", "DELETE FROM documents WHERE tenant_id = ?; DELETE FROM documents", []interface{}{"t1"}, false},
+		{"insert of another tenant", "INSERT INTO documents (id, tenant_id) VALUES (?, ?), (?, ?)", []interface{}{1, "t1", 2, "t2"}, false},
+		{"insert without tenant column", "INSERT INTO documents (id, title) VALUES (?, ?)", []interface{}{1, "t1"}, false},
+		{"prepared statement", "SELECT * FROM documents WHERE tenant_id = ?", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := scope.check(tt.query, tt.args)
+			if tt.ok && err != nil {
+				t.Errorf("Expected %q to pass, got %v", tt.query, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("Expected %q to be rejected", tt.query)
+			}
+		})
+	}
+}
+
+func TestTenantScopeCheck_MultipleTenantTables(t *testing.T) {
+	scope := newTenantScope("t1", "tenant_id", nil)
+
+	tests := []struct {
+		name  string
+		query string
+		args  []interface{}
+		ok    bool
+	}{
+		{"join filtered by alias", "SELECT i.* FROM invoices i JOIN orders o ON o.id = i.order_id WHERE i.tenant_id = ? AND o.tenant_id = ?", []interface{}{"t1", "t1"}, true},
+		{"comma join filtered by table", "SELECT * FROM orders, invoices WHERE orders.tenant_id = ? AND invoices.tenant_id = ?", []interface{}{"t1", "t1"}, true},
+		{"comma join with aliases", "SELECT * FROM orders AS o, invoices i WHERE (o.tenant_id = ? AND i.tenant_id = ?)", []interface{}{"t1", "t1"}, true},
+		{"join filtered for one table", "SELECT i.* FROM invoices i JOIN orders o ON 1=1 WHERE o.tenant_id = ?", []interface{}{"t1"}, false},
+		{"comma join filtered for one table", "SELECT * FROM orders, invoices WHERE orders.tenant_id = ?", []interface{}{"t1"}, false},
+		{"unqualified predicate with a join", "SELECT * FROM orders o JOIN invoices i ON i.order_id = o.id WHERE tenant_id = ?", []interface{}{"t1"}, false},
+		{"predicate of another table", "SELECT d.* FROM documents d WHERE x.tenant_id = ?", []interface{}{"t1"}, false},
+		{"self join filtered once", "SELECT * FROM orders a JOIN orders b ON a.id = b.parent_id WHERE a.tenant_id = ?", []interface{}{"t1"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := scope.check(tt.query, tt.args)
+			if tt.ok && err != nil {
+				t.Errorf("Expected %q to pass, got %v", tt.query, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("Expected %q to be rejected", tt.query)
+			}
+		})
+	}
+}
+
+func TestTenantScopeCheck_QueryBuilderStatements(t *testing.T) {
+	scope := newTenantScope("t1", "tenant_id", nil)
+	query := "SELECT * FROM documents"
+
+	if err := scope.checkContext(context.Background(), query, nil); err == nil {
+		t.Error("Expected raw statement to be rejected")
+	}
+	if err := scope.checkContext(withTenantScopedStatement(context.Background()), query, nil); err != nil {
+		t.Errorf("Expected statement of the query builder to pass, got %v", err)
+	}
+}
+
+func TestTenantTransaction_RejectedQueryRow(t *testing.T) {
+	tx := &tenantTransaction{scope: newTenantScope("t1", "tenant_id", nil)}
+
+	var title string
+	err := tx.QueryRow("SELECT title FROM documents WHERE id = ?", 1).Scan(&title)
+	var frameworkErr *FrameworkError
+	if !errors.As(err, &frameworkErr) || frameworkErr.Code != ErrCodeTenantScope || frameworkErr.StatusCode != 403 {
+		t.Fatalf("Expected tenant scope error, got %v", err)
+	}
+	if err := tx.QueryRowContext(context.Background(), "SELECT title FROM documents").Err(); !errors.As(err, &frameworkErr) {
+		t.Fatalf("Expected tenant scope error from Err, got %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// TenantScopeReporter is the part of *testing.T that CheckTenantScope reports to
+type TenantScopeReporter interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// CheckTenantScope wraps db for tests so that the test fails when a statement
+// escapes the tenant scope, even if the code under test swallows the error.
+// With row isolation every raw statement on a tenant table must filter by the
+// tenant. In every mode, queries are run a second time and fail the test when
+// they return a tenant column holding another tenant's ID.
+func CheckTenantScope(t TenantScopeReporter, db TenantDatabase) TenantDatabase {
+	scope := db.tenantScope()
+	if scope == nil {
+		scope = newTenantScope(db.Tenant().ID, "tenant_id", nil)
+	}
+	return &tenantScopeChecker{TenantDatabase: db, t: t, scope: scope, static: db.tenantScope() != nil}
+}
+
+// tenantScopeChecker is the TenantDatabase returned by CheckTenantScope
+type tenantScopeChecker struct {
+	TenantDatabase
+	t      TenantScopeReporter
+	scope  *tenantScope
+	static bool // Whether raw statements must carry the tenant predicate
+}
+
+// checkStatement reports a statement that is not scoped to the tenant
+func (c *tenantScopeChecker) checkStatement(ctx context.Context, query string, args []interface{}) {
+	if !c.static {
+		return
+	}
+	if err := c.scope.checkContext(ctx, query, args); err != nil {
+		c.t.Helper()
+		c.t.Errorf("tenant scope violation: %v: %s", err, query)
+	}
+}
+
+// probe runs a read-only query and reports rows of other tenants
+func (c *tenantScopeChecker) probe(ctx context.Context, db rowsQuerier, query string, args []interface{}) {
+	fields := strings.Fields(query)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "select") {
+		// Only plain reads can safely run twice
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return
+	}
+	index := -1
+	for i, column := range columns {
+		if strings.EqualFold(column, c.scope.column) {
+			index = i
+		}
+	}
+	if index < 0 {
+		return
+	}
+
+	values := make([]interface{}, len(columns))
+	targets := make([]interface{}, len(columns))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(targets...); err != nil {
+			return
+		}
+		if values[index] != nil && !c.scope.matches(values[index]) {
+			c.t.Helper()
+			c.t.Errorf("tenant scope violation: query returned %s %v instead of %s: %s",
+				c.scope.column, asString(values[index]), c.scope.tenantID, query)
+			return
+		}
+	}
+}
+
+// rowsQuerier runs queries that return rows
+type rowsQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error)
+}
+
+func (c *tenantScopeChecker) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	c.t.Helper()
+	c.checkStatement(nil, query, args)
+	c.probe(nil, c.TenantDatabase, query, args)
+	return c.TenantDatabase.Query(query, args...)
+}
+
+func (c *tenantScopeChecker) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	c.t.Helper()
+	c.checkStatement(ctx, query, args)
+	c.probe(ctx, c.TenantDatabase, query, args)
+	return c.TenantDatabase.QueryContext(ctx, query, args...)
+}
+
+func (c *tenantScopeChecker) QueryRow(query string, args ...interface{}) *sql.Row {
+	c.t.Helper()
+	c.checkStatement(nil, query, args)
+	c.probe(nil, c.TenantDatabase, query, args)
+	return c.TenantDatabase.QueryRow(query, args...)
+}
+
+func (c *tenantScopeChecker) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	c.t.Helper()
+	c.checkStatement(ctx, query, args)
+	c.probe(ctx, c.TenantDatabase, query, args)
+	return c.TenantDatabase.QueryRowContext(ctx, query, args...)
+}
+
+func (c *tenantScopeChecker) Exec(query string, args ...interface{}) (sql.Result, error) {
+	c.t.Helper()
+	c.checkStatement(nil, query, args)
+	return c.TenantDatabase.Exec(query, args...)
+}
+
+func (c *tenantScopeChecker) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	c.t.Helper()
+	c.checkStatement(ctx, query, args)
+	return c.TenantDatabase.ExecContext(ctx, query, args...)
+}
+
+func (c *tenantScopeChecker) Begin() (Transaction, error) {
+	return c.BeginTxContext(context.Background(), nil)
+}
+
+func (c *tenantScopeChecker) BeginTx(opts *sql.TxOptions) (Transaction, error) {
+	return c.BeginTxContext(context.Background(), opts)
+}
+
+func (c *tenantScopeChecker) BeginTxContext(ctx context.Context, opts *sql.TxOptions) (Transaction, error) {
+	tx, err := c.TenantDatabase.BeginTxContext(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &tenantScopeCheckerTx{Transaction: tx, checker: c}, nil
+}
+
+func (c *tenantScopeChecker) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Transaction) error) error {
+	return c.TenantDatabase.WithTx(ctx, opts, func(tx Transaction) error {
+		return fn(&tenantScopeCheckerTx{Transaction: tx, checker: c})
+	})
+}
+
+// activeTransaction returns the checked transaction of the running WithTx callback
+func (c *tenantScopeChecker) activeTransaction() Transaction {
+	if db, ok := c.TenantDatabase.(transactionalDatabase); ok {
+		if tx := db.activeTransaction(); tx != nil {
+			return &tenantScopeCheckerTx{Transaction: tx, checker: c}
+		}
+	}
+	return nil
+}
+
+// tenantScopeCheckerTx checks the statements of a transaction like tenantScopeChecker
+type tenantScopeCheckerTx struct {
+	Transaction
+	checker *tenantScopeChecker
+}
+
+func (tx *tenantScopeCheckerTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	tx.checker.t.Helper()
+	tx.checker.checkStatement(nil, query, args)
+	tx.checker.probe(nil, tx.Transaction, query, args)
+	return tx.Transaction.Query(query, args...)
+}
+
+func (tx *tenantScopeCheckerTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	tx.checker.t.Helper()
+	tx.checker.checkStatement(ctx, query, args)
+	tx.checker.probe(ctx, tx.Transaction, query, args)
+	return tx.Transaction.QueryContext(ctx, query, args...)
+}
+
+func (tx *tenantScopeCheckerTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	tx.checker.t.Helper()
+	tx.checker.checkStatement(nil, query, args)
+	tx.checker.probe(nil, tx.Transaction, query, args)
+	return tx.Transaction.QueryRow(query, args...)
+}
+
+func (tx *tenantScopeCheckerTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	tx.checker.t.Helper()
+	tx.checker.checkStatement(ctx, query, args)
+	tx.checker.probe(ctx, tx.Transaction, query, args)
+	return tx.Transaction.QueryRowContext(ctx, query, args...)
+}
+
+func (tx *tenantScopeCheckerTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	tx.checker.t.Helper()
+	tx.checker.checkStatement(nil, query, args)
+	return tx.Transaction.Exec(query, args...)
+}
+
+func (tx *tenantScopeCheckerTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	tx.checker.t.Helper()
+	tx.checker.checkStatement(ctx, query, args)
+	return tx.Transaction.ExecContext(ctx, query, args...)
+}
@@ -1,6 +1,7 @@
 package storageplugin
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -61,6 +62,13 @@ type StorageBackend interface {
 	GetURL(path string) (string, error)
 }
 
+// StorageSizer is implemented by storage backends that can report the
+// stored size of a file without reading it. Files of other backends are
+// read to measure them for quota metering.
+type StorageSizer interface {
+	Size(path string) (int64, error)
+}
+
 // StorageService is exported for other plugins to use
 type StorageService struct {
 	plugin *StoragePlugin
@@ -71,6 +79,12 @@ func (s *StorageService) Store(path string, data io.Reader) error {
 	return s.plugin.store(path, data)
 }
 
+// StoreForTenant saves a file to storage, metering its size against the
+// tenant's storage quota
+func (s *StorageService) StoreForTenant(tenant *pkg.Tenant, path string, data io.Reader) error {
+	return s.plugin.storeForTenant(tenant, path, data)
+}
+
 // Retrieve gets a file from storage
 func (s *StorageService) Retrieve(path string) (io.ReadCloser, error) {
 	return s.plugin.retrieve(path)
@@ -81,6 +95,12 @@ func (s *StorageService) Delete(path string) error {
 	return s.plugin.delete(path)
 }
 
+// DeleteForTenant removes a file from storage and releases its size from
+// the tenant's storage quota
+func (s *StorageService) DeleteForTenant(tenant *pkg.Tenant, path string) error {
+	return s.plugin.deleteForTenant(tenant, path)
+}
+
 // Exists checks if a file exists in storage
 func (s *StorageService) Exists(path string) (bool, error) {
 	return s.plugin.exists(path)
@@ -376,6 +396,32 @@ func (p *StoragePlugin) store(path string, data io.Reader) error {
 	return nil
 }
 
+func (p *StoragePlugin) storeForTenant(tenant *pkg.Tenant, path string, data io.Reader) error {
+	// Validate file extension
+	if !p.isAllowedExtension(path) {
+		return fmt.Errorf("file extension not allowed")
+	}
+
+	// Buffer the file so its size is known before quota is consumed
+	content, err := io.ReadAll(io.LimitReader(data, p.maxFileSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	if int64(len(content)) > p.maxFileSize {
+		return fmt.Errorf("file exceeds maximum size of %d bytes", p.maxFileSize)
+	}
+
+	err = pkg.MeterStorageWrite(p.quotas(), tenant, p.quotaKey(path), p.sizer(path), int64(len(content)), func() error {
+		return p.backend.Store(path, bytes.NewReader(content))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+
+	fmt.Printf("[%s] Stored file: %s\n", p.Name(), path)
+	return nil
+}
+
 func (p *StoragePlugin) retrieve(path string) (io.ReadCloser, error) {
 	return p.backend.Retrieve(path)
 }
@@ -389,6 +435,55 @@ func (p *StoragePlugin) delete(path string) error {
 	return nil
 }
 
+func (p *StoragePlugin) deleteForTenant(tenant *pkg.Tenant, path string) error {
+	err := pkg.MeterStorageDelete(p.quotas(), tenant, p.quotaKey(path), p.sizer(path), func() error {
+		return p.backend.Delete(path)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+
+	fmt.Printf("[%s] Deleted file: %s\n", p.Name(), path)
+	return nil
+}
+
+// quotas returns the framework's quota manager, or nil if quotas are not enforced
+func (p *StoragePlugin) quotas() pkg.QuotaManager {
+	if q, ok := p.ctx.(pkg.PluginQuotas); ok {
+		return q.Quotas()
+	}
+	return nil
+}
+
+// quotaKey identifies a stored file for quota metering
+func (p *StoragePlugin) quotaKey(path string) string {
+	return p.Name() + ":" + path
+}
+
+// sizer returns a function reporting the stored size of path, or zero.
+// Backends that cannot report sizes fall back to reading the file.
+func (p *StoragePlugin) sizer(path string) func() int64 {
+	return func() int64 {
+		if sizer, ok := p.backend.(StorageSizer); ok {
+			size, err := sizer.Size(path)
+			if err != nil {
+				return 0
+			}
+			return size
+		}
+		reader, err := p.backend.Retrieve(path)
+		if err != nil {
+			return 0
+		}
+		defer reader.Close()
+		size, err := io.Copy(io.Discard, reader)
+		if err != nil {
+			return 0
+		}
+		return size
+	}
+}
+
 func (p *StoragePlugin) exists(path string) (bool, error) {
 	return p.backend.Exists(path)
 }
@@ -462,6 +557,15 @@ func (b *LocalStorageBackend) Exists(path string) (bool, error) {
 	return false, err
 }
 
+func (b *LocalStorageBackend) Size(path string) (int64, error) {
+	fullPath := filepath.Join(b.basePath, path)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
 func (b *LocalStorageBackend) List(prefix string) ([]string, error) {
 	fullPath := filepath.Join(b.basePath, prefix)
 	var files []string
@@ -518,6 +622,11 @@ func (b *S3StorageBackend) Exists(path string) (bool, error) {
 	return false, nil
 }
 
+func (b *S3StorageBackend) Size(path string) (int64, error) {
+	// In a real implementation, use AWS SDK to read the object's content length
+	return 0, nil
+}
+
 func (b *S3StorageBackend) List(prefix string) ([]string, error) {
 	// In a real implementation, use AWS SDK to list objects
 	return []string{}, nil
@@ -0,0 +1,7 @@
+-- Try to acquire the migration application lock (MSSQL)
+-- Returns 1 if the lock was acquired, 0 if another session holds it
+-- The lock is held by the session until release_migration_lock or disconnect
+
+DECLARE @result INT;
+EXEC @result = sp_getapplock @Resource = 'rockstar_schema_migrations', @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = 0;
+SELECT CASE WHEN @result >= 0 THEN 1 ELSE 0 END;
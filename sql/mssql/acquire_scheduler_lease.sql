@@ -0,0 +1,7 @@
+-- Acquire or renew a scheduler lease (MSSQL)
+-- Parameters: holder, expires_at, name, holder, now
+-- Affects one row when the lease is held by holder or has expired
+
+UPDATE scheduler_leases
+SET holder = @p1, expires_at = @p2
+WHERE name = @p3 AND (holder = @p4 OR expires_at < @p5);
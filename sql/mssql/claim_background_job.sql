@@ -0,0 +1,6 @@
+-- Claim a due job for a worker (MSSQL)
+-- Parameters: locked_by, locked_until, id, now, now
+-- Affects no rows when another worker claimed the job first
+
+UPDATE background_jobs SET status = 'running', locked_by = @p1, locked_until = @p2, attempts = attempts + 1
+WHERE id = @p3 AND ((status = 'pending' AND run_at <= @p4) OR (status = 'running' AND locked_until < @p5));
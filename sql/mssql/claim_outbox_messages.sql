@@ -0,0 +1,10 @@
+-- Claim a batch of due outbox messages for a relay (MSSQL)
+-- Parameters: locked_by, locked_until, now, now, batch_size
+-- Messages whose previous claim expired are claimed again
+
+WITH batch AS (
+    SELECT TOP (@p5) * FROM outbox_messages WITH (ROWLOCK, UPDLOCK, READPAST)
+    WHERE status = 'pending' AND next_attempt_at <= @p3 AND (locked_until IS NULL OR locked_until < @p4)
+    ORDER BY created_at
+)
+UPDATE batch SET locked_by = @p1, locked_until = @p2;
@@ -0,0 +1,5 @@
+-- Clean up completed background jobs (MSSQL)
+-- Parameters: completed_before
+-- Pending, running and dead-lettered jobs are kept
+
+DELETE FROM background_jobs WHERE status = 'completed' AND completed_at < @p1;
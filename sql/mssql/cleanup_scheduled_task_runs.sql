@@ -0,0 +1,7 @@
+-- Remove old runs from the execution history (MSSQL)
+-- Parameters: before
+-- The latest run of every task is kept to detect missed runs
+
+DELETE FROM scheduled_task_runs
+WHERE finished_at < @p1
+  AND scheduled_at < (SELECT MAX(r.scheduled_at) FROM scheduled_task_runs r WHERE r.task_name = scheduled_task_runs.task_name);
@@ -0,0 +1,6 @@
+-- Atomically add usage if the limit is not exceeded (MSSQL)
+-- Parameters: amount, updated_at, tenant_id, resource, period, amount, limit
+-- Affects no rows when the consumption would exceed the limit
+
+UPDATE tenant_quota_usage SET used = used + @p1, updated_at = @p2
+WHERE tenant_id = @p3 AND resource = @p4 AND period = @p5 AND used + @p6 <= @p7;
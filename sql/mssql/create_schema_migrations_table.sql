@@ -0,0 +1,15 @@
+-- Create schema_migrations table for MSSQL
+-- Records the versioned migrations applied by the Migrator
+-- The checksum detects migration files that were edited after being applied
+-- MSSQL uses DATETIME2 for timestamp storage with better precision
+
+IF NOT EXISTS (SELECT * FROM sys.tables WHERE name = 'schema_migrations')
+BEGIN
+    CREATE TABLE schema_migrations (
+        version BIGINT PRIMARY KEY,
+        name NVARCHAR(255) NOT NULL,
+        checksum NVARCHAR(64) NOT NULL,
+        applied_at DATETIME2 NOT NULL,
+        execution_ms BIGINT NOT NULL DEFAULT 0
+    );
+END;
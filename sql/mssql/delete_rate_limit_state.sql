@@ -0,0 +1,4 @@
+-- Delete rate limiter state for a key (MSSQL)
+-- Parameters: rate_key
+
+DELETE FROM rate_limit_state WHERE rate_key = @p1;
@@ -0,0 +1,5 @@
+-- Remove a rolled back migration from the history (MSSQL)
+-- Parameters: version
+-- Runs in the same transaction as the down migration
+
+DELETE FROM schema_migrations WHERE version = @p1;
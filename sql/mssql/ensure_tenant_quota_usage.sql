@@ -0,0 +1,8 @@
+-- Create the usage row for a tenant, resource and period if it does not exist (MSSQL)
+-- Parameters: tenant_id, resource, period, updated_at
+-- Uses IF NOT EXISTS with UPDLOCK/HOLDLOCK so concurrent instances can run it safely
+
+IF NOT EXISTS (SELECT 1 FROM tenant_quota_usage WITH (UPDLOCK, HOLDLOCK) WHERE tenant_id = @p1 AND resource = @p2 AND period = @p3)
+BEGIN
+    INSERT INTO tenant_quota_usage (tenant_id, resource, period, used, updated_at) VALUES (@p1, @p2, @p3, 0, @p4);
+END;
@@ -0,0 +1,5 @@
+-- Extend the claim of a running job (MSSQL)
+-- Parameters: locked_until, id, locked_by
+
+UPDATE background_jobs SET locked_until = @p1
+WHERE id = @p2 AND locked_by = @p3 AND status = 'running';
@@ -0,0 +1,6 @@
+-- Record the outcome of a scheduled run (MSSQL)
+-- Parameters: status, error, finished_at, id
+
+UPDATE scheduled_task_runs
+SET status = @p1, error = @p2, finished_at = @p3
+WHERE id = @p4;
@@ -0,0 +1,7 @@
+-- Queue a background job (MSSQL)
+-- Parameters: id, queue, name, payload (JSON), max_attempts, run_at, unique_key, created_at
+-- Affects no rows when a pending or running job has the same unique_key
+
+INSERT INTO background_jobs (id, queue, name, payload, status, attempts, max_attempts, run_at, unique_key, created_at)
+SELECT @p1, @p2, @p3, @p4, 'pending', 0, @p5, @p6, @p7, @p8
+WHERE NOT EXISTS (SELECT 1 FROM background_jobs WITH (UPDLOCK, HOLDLOCK) WHERE unique_key = @p7);
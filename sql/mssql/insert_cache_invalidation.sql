@@ -0,0 +1,5 @@
+-- Publish a cache invalidation (MSSQL)
+-- Parameters: source, kind, value, version, created_at
+
+INSERT INTO cache_invalidations (source, kind, value, version, created_at)
+VALUES (@p1, @p2, @p3, @p4, @p5);
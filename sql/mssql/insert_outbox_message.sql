@@ -0,0 +1,7 @@
+-- Write an event to the outbox (MSSQL)
+-- Parameters: id, event, payload (JSON), next_attempt_at, created_at
+-- Affects no rows when a message with the same ID exists (deduplication)
+
+INSERT INTO outbox_messages (id, event, payload, status, attempts, next_attempt_at, created_at)
+SELECT @p1, @p2, @p3, 'pending', 0, @p4, @p5
+WHERE NOT EXISTS (SELECT 1 FROM outbox_messages WITH (UPDLOCK, HOLDLOCK) WHERE id = @p1);
@@ -0,0 +1,5 @@
+-- Insert rate limiter state for a new key (MSSQL)
+-- Parameters: rate_key, state, expires_at
+-- Fails on the primary key if another instance inserted the key concurrently
+
+INSERT INTO rate_limit_state (rate_key, state, version, expires_at) VALUES (@p1, @p2, 1, @p3);
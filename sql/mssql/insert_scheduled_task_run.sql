@@ -0,0 +1,7 @@
+-- Claim a scheduled run of a task (MSSQL)
+-- Parameters: id, task_name, scheduled_at, instance_id, started_at
+-- Affects no rows when the run was already claimed by another instance
+
+INSERT INTO scheduled_task_runs (id, task_name, scheduled_at, instance_id, status, started_at)
+SELECT @p1, @p2, @p3, @p4, 'running', @p5
+WHERE NOT EXISTS (SELECT 1 FROM scheduled_task_runs WITH (UPDLOCK, HOLDLOCK) WHERE task_name = @p2 AND scheduled_at = @p3);
@@ -0,0 +1,7 @@
+-- Create a scheduler lease (MSSQL)
+-- Parameters: name, holder, expires_at
+-- Affects no rows when the lease already exists
+
+INSERT INTO scheduler_leases (name, holder, expires_at)
+SELECT @p1, @p2, @p3
+WHERE NOT EXISTS (SELECT 1 FROM scheduler_leases WITH (UPDLOCK, HOLDLOCK) WHERE name = @p1);
@@ -0,0 +1,5 @@
+-- Record an applied migration (MSSQL)
+-- Parameters: version, name, checksum, applied_at, execution_ms
+-- Runs in the same transaction as the migration itself
+
+INSERT INTO schema_migrations (version, name, checksum, applied_at, execution_ms) VALUES (@p1, @p2, @p3, @p4, @p5);
@@ -0,0 +1,8 @@
+-- Load the cache invalidations published after an ID (MSSQL)
+-- Parameters: after_id, limit
+-- Oldest invalidations first
+
+SELECT TOP (@p2) id, source, kind, value, version
+FROM cache_invalidations
+WHERE id > @p1
+ORDER BY id;
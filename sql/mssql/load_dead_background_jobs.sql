@@ -0,0 +1,8 @@
+-- Load dead-lettered jobs of a queue (MSSQL)
+-- Parameters: queue, limit
+-- Oldest jobs first
+
+SELECT TOP (@p2) id, queue, name, payload, status, attempts, max_attempts, run_at, unique_key, last_error, created_at
+FROM background_jobs
+WHERE queue = @p1 AND status = 'dead'
+ORDER BY created_at;
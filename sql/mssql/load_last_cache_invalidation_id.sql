@@ -0,0 +1,4 @@
+-- Load the ID of the latest cache invalidation (MSSQL)
+-- New subscribers start after it
+
+SELECT COALESCE(MAX(id), 0) FROM cache_invalidations;
@@ -0,0 +1,7 @@
+-- Load the time of the latest scheduled run of a task (MSSQL)
+-- Parameters: task_name
+
+SELECT TOP 1 scheduled_at
+FROM scheduled_task_runs
+WHERE task_name = @p1
+ORDER BY scheduled_at DESC;
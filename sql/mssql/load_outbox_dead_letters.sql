@@ -0,0 +1,7 @@
+-- Load dead-lettered outbox messages (MSSQL)
+-- Parameters: limit
+-- Oldest messages first
+
+SELECT TOP (@p1) id, event, payload, attempts, last_error, created_at FROM outbox_messages
+WHERE status = 'dead'
+ORDER BY created_at;
@@ -0,0 +1,5 @@
+-- Load rate limiter state for a key (MSSQL)
+-- Parameters: rate_key
+-- Returns the encoded state, its version for optimistic concurrency and its expiry
+
+SELECT state, version, expires_at FROM rate_limit_state WHERE rate_key = @p1;
@@ -0,0 +1,8 @@
+-- Load the execution history of a task (MSSQL)
+-- Parameters: task_name, limit
+-- Newest runs first
+
+SELECT TOP (@p2) id, task_name, scheduled_at, instance_id, status, error, started_at, finished_at
+FROM scheduled_task_runs
+WHERE task_name = @p1
+ORDER BY scheduled_at DESC;
@@ -0,0 +1,4 @@
+-- Load usage for a tenant, resource and period (MSSQL)
+-- Parameters: tenant_id, resource, period
+
+SELECT used FROM tenant_quota_usage WHERE tenant_id = @p1 AND resource = @p2 AND period = @p3;
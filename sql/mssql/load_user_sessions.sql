@@ -0,0 +1,18 @@
+-- Load the sessions of a user from the database (MSSQL)
+-- Parameters: @p1 = user_id (user ID), @p2 = current_timestamp (to check expiration)
+-- Returns: id, user_id, tenant_id, data, expires_at, created_at, updated_at, ip_address, user_agent
+-- Only returns sessions that have not expired, oldest first
+
+SELECT 
+    id, 
+    user_id, 
+    tenant_id, 
+    data, 
+    expires_at, 
+    created_at, 
+    updated_at, 
+    ip_address, 
+    user_agent
+FROM sessions 
+WHERE user_id = ? AND expires_at > ?
+ORDER BY created_at;
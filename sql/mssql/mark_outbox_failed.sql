@@ -0,0 +1,6 @@
+-- Record a failed delivery of a claimed outbox message (MSSQL)
+-- Parameters: status, attempts, last_error, next_attempt_at, id, locked_by
+-- status is 'pending' for another attempt or 'dead' once attempts are exhausted
+
+UPDATE outbox_messages SET status = @p1, attempts = @p2, last_error = @p3, next_attempt_at = @p4, locked_by = NULL, locked_until = NULL
+WHERE id = @p5 AND locked_by = @p6;
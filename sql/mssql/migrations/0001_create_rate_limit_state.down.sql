@@ -0,0 +1,6 @@
+-- Drop the rate_limit_state table (MSSQL)
+
+IF EXISTS (SELECT * FROM sys.tables WHERE name = 'rate_limit_state')
+BEGIN
+    DROP TABLE rate_limit_state;
+END;
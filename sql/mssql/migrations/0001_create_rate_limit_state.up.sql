@@ -0,0 +1,19 @@
+-- Create the rate_limit_state table (MSSQL)
+-- Stores per-key state of the RateLimiter algorithms (token bucket, sliding window, GCRA)
+-- The version column provides optimistic concurrency so updates are atomic across instances
+
+IF NOT EXISTS (SELECT * FROM sys.tables WHERE name = 'rate_limit_state')
+BEGIN
+    CREATE TABLE rate_limit_state (
+        rate_key NVARCHAR(255) PRIMARY KEY,
+        state NVARCHAR(MAX) NOT NULL,
+        version BIGINT NOT NULL DEFAULT 1,
+        expires_at DATETIME2 NOT NULL
+    );
+END;
+
+-- Index on expires_at so cleanup of idle keys does not scan the whole table
+IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'idx_rate_limit_state_expires' AND object_id = OBJECT_ID('rate_limit_state'))
+BEGIN
+    CREATE INDEX idx_rate_limit_state_expires ON rate_limit_state(expires_at);
+END;
@@ -0,0 +1,6 @@
+-- Drop the outbox_messages table (MSSQL)
+
+IF EXISTS (SELECT * FROM sys.tables WHERE name = 'outbox_messages')
+BEGIN
+    DROP TABLE outbox_messages;
+END;
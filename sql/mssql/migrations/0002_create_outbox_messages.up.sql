@@ -0,0 +1,32 @@
+-- Create the outbox_messages table (MSSQL)
+-- Stores events written in the same transaction as the data they describe
+-- status is 'pending', 'published' or 'dead'; locked_by/locked_until hold a relay's claim
+
+IF NOT EXISTS (SELECT * FROM sys.tables WHERE name = 'outbox_messages')
+BEGIN
+    CREATE TABLE outbox_messages (
+        id NVARCHAR(255) PRIMARY KEY,
+        event NVARCHAR(255) NOT NULL,
+        payload NVARCHAR(MAX) NOT NULL,
+        status NVARCHAR(16) NOT NULL DEFAULT 'pending',
+        attempts INT NOT NULL DEFAULT 0,
+        next_attempt_at DATETIME2 NOT NULL,
+        locked_by NVARCHAR(64) NULL,
+        locked_until DATETIME2 NULL,
+        last_error NVARCHAR(MAX) NULL,
+        created_at DATETIME2 NOT NULL,
+        published_at DATETIME2 NULL
+    );
+END;
+
+-- Index on status and next_attempt_at for claiming due messages
+IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'idx_outbox_due' AND object_id = OBJECT_ID('outbox_messages'))
+BEGIN
+    CREATE INDEX idx_outbox_due ON outbox_messages(status, next_attempt_at);
+END;
+
+-- Index on locked_by for loading a relay's claimed batch
+IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'idx_outbox_locked_by' AND object_id = OBJECT_ID('outbox_messages'))
+BEGIN
+    CREATE INDEX idx_outbox_locked_by ON outbox_messages(locked_by);
+END;
@@ -0,0 +1,6 @@
+-- Drop the background_jobs table (MSSQL)
+
+IF EXISTS (SELECT * FROM sys.tables WHERE name = 'background_jobs')
+BEGIN
+    DROP TABLE background_jobs;
+END;
@@ -0,0 +1,42 @@
+-- Create the background_jobs table (MSSQL)
+-- Stores queued, running, completed and dead-lettered background jobs
+-- status is 'pending', 'running', 'completed' or 'dead'; locked_by/locked_until hold a worker's claim
+-- unique_key is cleared when a job finishes so the key can be used again
+
+IF NOT EXISTS (SELECT * FROM sys.tables WHERE name = 'background_jobs')
+BEGIN
+    CREATE TABLE background_jobs (
+        id NVARCHAR(64) PRIMARY KEY,
+        queue NVARCHAR(100) NOT NULL,
+        name NVARCHAR(255) NOT NULL,
+        payload NVARCHAR(MAX) NOT NULL,
+        status NVARCHAR(16) NOT NULL DEFAULT 'pending',
+        attempts INT NOT NULL DEFAULT 0,
+        max_attempts INT NOT NULL,
+        run_at DATETIME2 NOT NULL,
+        unique_key NVARCHAR(255) NULL,
+        locked_by NVARCHAR(64) NULL,
+        locked_until DATETIME2 NULL,
+        last_error NVARCHAR(MAX) NULL,
+        created_at DATETIME2 NOT NULL,
+        completed_at DATETIME2 NULL
+    );
+END;
+
+-- Index on queue, status and run_at for claiming due jobs
+IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'idx_jobs_due' AND object_id = OBJECT_ID('background_jobs'))
+BEGIN
+    CREATE INDEX idx_jobs_due ON background_jobs(queue, status, run_at);
+END;
+
+-- Unique index on unique_key so a unique job is only queued once
+IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'idx_jobs_unique' AND object_id = OBJECT_ID('background_jobs'))
+BEGIN
+    CREATE UNIQUE INDEX idx_jobs_unique ON background_jobs(unique_key) WHERE unique_key IS NOT NULL;
+END;
+
+-- Index on status and completed_at for cleanup of finished jobs
+IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'idx_jobs_completed' AND object_id = OBJECT_ID('background_jobs'))
+BEGIN
+    CREATE INDEX idx_jobs_completed ON background_jobs(status, completed_at);
+END;
@@ -0,0 +1,6 @@
+-- Drop the scheduler_leases table (MSSQL)
+
+IF EXISTS (SELECT * FROM sys.tables WHERE name = 'scheduler_leases')
+BEGIN
+    DROP TABLE scheduler_leases;
+END;
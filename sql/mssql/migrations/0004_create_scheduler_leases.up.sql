@@ -0,0 +1,12 @@
+-- Create the scheduler_leases table (MSSQL)
+-- Stores the leases used to elect the instance that runs scheduled tasks
+-- holder is the instance holding the lease until expires_at
+
+IF NOT EXISTS (SELECT * FROM sys.tables WHERE name = 'scheduler_leases')
+BEGIN
+    CREATE TABLE scheduler_leases (
+        name NVARCHAR(100) PRIMARY KEY,
+        holder NVARCHAR(255) NOT NULL,
+        expires_at DATETIME2 NOT NULL
+    );
+END;
@@ -0,0 +1,6 @@
+-- Drop the scheduled_task_runs table (MSSQL)
+
+IF EXISTS (SELECT * FROM sys.tables WHERE name = 'scheduled_task_runs')
+BEGIN
+    DROP TABLE scheduled_task_runs;
+END;
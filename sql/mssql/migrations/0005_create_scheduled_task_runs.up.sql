@@ -0,0 +1,25 @@
+-- Create the scheduled_task_runs table (MSSQL)
+-- Stores the execution history of scheduled tasks
+-- The unique (task_name, scheduled_at) pair makes every scheduled run execute once
+-- status is 'running', 'succeeded' or 'failed'
+
+IF NOT EXISTS (SELECT * FROM sys.tables WHERE name = 'scheduled_task_runs')
+BEGIN
+    CREATE TABLE scheduled_task_runs (
+        id NVARCHAR(64) PRIMARY KEY,
+        task_name NVARCHAR(255) NOT NULL,
+        scheduled_at DATETIME2 NOT NULL,
+        instance_id NVARCHAR(255) NOT NULL,
+        status NVARCHAR(16) NOT NULL,
+        error NVARCHAR(MAX) NULL,
+        started_at DATETIME2 NOT NULL,
+        finished_at DATETIME2 NULL,
+        CONSTRAINT uq_scheduled_task_runs UNIQUE (task_name, scheduled_at)
+    );
+END;
+
+-- Index on finished_at for removing old runs
+IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'idx_scheduled_task_runs_finished' AND object_id = OBJECT_ID('scheduled_task_runs'))
+BEGIN
+    CREATE INDEX idx_scheduled_task_runs_finished ON scheduled_task_runs(finished_at);
+END;
@@ -0,0 +1,6 @@
+-- Drop the cache_invalidations table (MSSQL)
+
+IF EXISTS (SELECT * FROM sys.tables WHERE name = 'cache_invalidations')
+BEGIN
+    DROP TABLE cache_invalidations;
+END;
@@ -0,0 +1,21 @@
+-- Create the cache_invalidations table (MSSQL)
+-- Carries cache invalidations between instances, which poll for new rows
+-- kind is 'key', 'pattern', 'tag' or 'all'; version orders the invalidation against the entries of the publishing instance
+
+IF NOT EXISTS (SELECT * FROM sys.tables WHERE name = 'cache_invalidations')
+BEGIN
+    CREATE TABLE cache_invalidations (
+        id BIGINT IDENTITY(1,1) PRIMARY KEY,
+        source NVARCHAR(255) NOT NULL,
+        kind NVARCHAR(16) NOT NULL,
+        value NVARCHAR(MAX) NOT NULL,
+        version BIGINT NOT NULL,
+        created_at DATETIME2 NOT NULL
+    );
+END;
+
+-- Index on created_at for removing old invalidations
+IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'idx_cache_invalidations_created' AND object_id = OBJECT_ID('cache_invalidations'))
+BEGIN
+    CREATE INDEX idx_cache_invalidations_created ON cache_invalidations(created_at);
+END;
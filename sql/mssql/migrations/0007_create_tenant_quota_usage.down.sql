@@ -0,0 +1,6 @@
+-- Drop the tenant_quota_usage table (MSSQL)
+
+IF EXISTS (SELECT * FROM sys.tables WHERE name = 'tenant_quota_usage')
+BEGIN
+    DROP TABLE tenant_quota_usage;
+END;
@@ -0,0 +1,15 @@
+-- Create the tenant_quota_usage table (MSSQL)
+-- Stores metered usage per tenant, resource and billing period
+-- Storage and user quotas use the period 'lifetime'
+
+IF NOT EXISTS (SELECT * FROM sys.tables WHERE name = 'tenant_quota_usage')
+BEGIN
+    CREATE TABLE tenant_quota_usage (
+        tenant_id NVARCHAR(255) NOT NULL,
+        resource NVARCHAR(50) NOT NULL,
+        period NVARCHAR(32) NOT NULL,
+        used BIGINT NOT NULL DEFAULT 0,
+        updated_at DATETIME2 DEFAULT GETDATE(),
+        PRIMARY KEY (tenant_id, resource, period)
+    );
+END;
@@ -0,0 +1,3 @@
+-- Release the migration application lock (MSSQL)
+
+EXEC sp_releaseapplock @Resource = 'rockstar_schema_migrations', @LockOwner = 'Session';
@@ -0,0 +1,3 @@
+-- Release a savepoint after a nested WithTx call succeeded (MSSQL)
+-- MSSQL cannot release savepoints, they are discarded when the transaction ends
+-- Savepoint: %s
@@ -0,0 +1,7 @@
+-- Release a scheduler lease (MSSQL)
+-- Parameters: expires_at, name, holder
+-- Lets another instance take over without waiting for the lease to expire
+
+UPDATE scheduler_leases
+SET expires_at = @p1
+WHERE name = @p2 AND holder = @p3;
@@ -0,0 +1,5 @@
+-- Subtract usage without going below zero (MSSQL)
+-- Parameters: amount, amount, updated_at, tenant_id, resource, period
+
+UPDATE tenant_quota_usage SET used = CASE WHEN used > @p1 THEN used - @p2 ELSE 0 END, updated_at = @p3
+WHERE tenant_id = @p4 AND resource = @p5 AND period = @p6;
@@ -0,0 +1,6 @@
+-- Measure replication lag on a readable secondary in seconds (MSSQL)
+-- Returns 0 when the database is not part of an availability group
+
+SELECT COALESCE(MAX(secondary_lag_seconds), 0)
+FROM sys.dm_hadr_database_replica_states
+WHERE is_local = 1;
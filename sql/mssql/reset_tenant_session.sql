@@ -0,0 +1,3 @@
+-- Clear tenant settings before a reserved connection returns to the pool (MSSQL)
+-- app.tenant_id is read-only for the rest of the session, so it is cleared by
+-- the driver resetting the connection (sp_reset_connection) when it is reused
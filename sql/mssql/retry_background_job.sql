@@ -0,0 +1,5 @@
+-- Schedule another attempt of a failed job (MSSQL)
+-- Parameters: run_at, last_error, id, locked_by
+
+UPDATE background_jobs SET status = 'pending', run_at = @p1, last_error = @p2, locked_by = NULL, locked_until = NULL
+WHERE id = @p3 AND locked_by = @p4;
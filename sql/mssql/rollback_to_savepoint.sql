@@ -0,0 +1,4 @@
+-- Roll back to a savepoint after a nested WithTx call failed (MSSQL)
+-- The savepoint name is formatted in by the caller
+
+ROLLBACK TRANSACTION %s;
@@ -0,0 +1,9 @@
+-- Select due jobs of a queue for claiming (MSSQL)
+-- Parameters: queue, now, now, limit
+-- Includes running jobs whose worker lost its claim
+-- Runs in the claiming transaction; locked rows are skipped where the engine supports it
+
+SELECT TOP (@p4) id, queue, name, payload, status, attempts, max_attempts, run_at, unique_key, last_error, created_at
+FROM background_jobs WITH (UPDLOCK, READPAST, ROWLOCK)
+WHERE queue = @p1 AND ((status = 'pending' AND run_at <= @p2) OR (status = 'running' AND locked_until < @p3))
+ORDER BY run_at;
@@ -0,0 +1,5 @@
+-- Set the tenant of a reserved connection for row-level security policies (MSSQL)
+-- Security predicates read it with SESSION_CONTEXT(N'app.tenant_id'). The key is
+-- read-only, so statements on the connection cannot switch to another tenant
+
+EXEC sp_set_session_context @key = N'app.tenant_id', @value = @p1, @read_only = 1;
@@ -0,0 +1,2 @@
+-- Resolve unqualified table names in the tenant's schema (MSSQL)
+-- MSSQL cannot change the default schema of a connection, use row or database isolation instead
@@ -0,0 +1,5 @@
+-- Update rate limiter state if it has not changed since it was loaded (MSSQL)
+-- Parameters: state, expires_at, rate_key, expected_version
+-- Affects no rows when another instance updated the key concurrently
+
+UPDATE rate_limit_state SET state = @p1, version = version + 1, expires_at = @p2 WHERE rate_key = @p3 AND version = @p4;
@@ -0,0 +1,5 @@
+-- Try to acquire the migration advisory lock (MySQL)
+-- Returns 1 if the lock was acquired, 0 if another session holds it
+-- The lock is held by the session until release_migration_lock or disconnect
+
+SELECT COALESCE(GET_LOCK('rockstar_schema_migrations', 0), 0);
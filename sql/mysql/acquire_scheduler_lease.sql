@@ -0,0 +1,7 @@
+-- Acquire or renew a scheduler lease (MySQL)
+-- Parameters: holder, expires_at, name, holder, now
+-- Affects one row when the lease is held by holder or has expired
+
+UPDATE scheduler_leases
+SET holder = ?, expires_at = ?
+WHERE name = ? AND (holder = ? OR expires_at < ?);
@@ -0,0 +1,6 @@
+-- Move a failed job to the dead-letter queue (MySQL)
+-- Parameters: completed_at, last_error, id, locked_by
+-- Releases the unique key
+
+UPDATE background_jobs SET status = 'dead', completed_at = ?, last_error = ?, unique_key = NULL, locked_by = NULL, locked_until = NULL
+WHERE id = ? AND locked_by = ?;
@@ -0,0 +1,6 @@
+-- Claim a due job for a worker (MySQL)
+-- Parameters: locked_by, locked_until, id, now, now
+-- Affects no rows when another worker claimed the job first
+
+UPDATE background_jobs SET status = 'running', locked_by = ?, locked_until = ?, attempts = attempts + 1
+WHERE id = ? AND ((status = 'pending' AND run_at <= ?) OR (status = 'running' AND locked_until < ?));
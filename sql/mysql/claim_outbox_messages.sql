@@ -0,0 +1,8 @@
+-- Claim a batch of due outbox messages for a relay (MySQL)
+-- Parameters: locked_by, locked_until, now, now, batch_size
+-- Messages whose previous claim expired are claimed again
+
+UPDATE outbox_messages SET locked_by = ?, locked_until = ?
+WHERE status = 'pending' AND next_attempt_at <= ? AND (locked_until IS NULL OR locked_until < ?)
+ORDER BY created_at
+LIMIT ?;
@@ -0,0 +1,4 @@
+-- Remove old cache invalidations (MySQL)
+-- Parameters: before
+
+DELETE FROM cache_invalidations WHERE created_at < ?;
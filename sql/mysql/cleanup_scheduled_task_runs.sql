@@ -0,0 +1,8 @@
+-- Remove old runs from the execution history (MySQL)
+-- Parameters: before
+-- The latest run of every task is kept to detect missed runs
+
+DELETE r FROM scheduled_task_runs r
+JOIN (SELECT task_name, MAX(scheduled_at) AS latest FROM scheduled_task_runs GROUP BY task_name) l
+    ON l.task_name = r.task_name
+WHERE r.finished_at < ? AND r.scheduled_at < l.latest;
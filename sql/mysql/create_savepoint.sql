@@ -0,0 +1,4 @@
+-- Create a savepoint for a nested WithTx call (MySQL)
+-- The savepoint name is formatted in by the caller
+
+SAVEPOINT %s;
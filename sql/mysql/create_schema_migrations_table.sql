@@ -0,0 +1,12 @@
+-- Create schema_migrations table for MySQL
+-- Records the versioned migrations applied by the Migrator
+-- The checksum detects migration files that were edited after being applied
+-- MySQL uses DATETIME(6) for microsecond timestamp precision
+
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    applied_at DATETIME(6) NOT NULL,
+    execution_ms BIGINT NOT NULL DEFAULT 0
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
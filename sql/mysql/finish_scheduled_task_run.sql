@@ -0,0 +1,6 @@
+-- Record the outcome of a scheduled run (MySQL)
+-- Parameters: status, error, finished_at, id
+
+UPDATE scheduled_task_runs
+SET status = ?, error = ?, finished_at = ?
+WHERE id = ?;
@@ -0,0 +1,7 @@
+-- Queue a background job (MySQL)
+-- Parameters: id, queue, name, payload (JSON), max_attempts, run_at, unique_key, created_at
+-- Affects no rows when a pending or running job has the same unique_key
+
+INSERT INTO background_jobs (id, queue, name, payload, status, attempts, max_attempts, run_at, unique_key, created_at)
+VALUES (?, ?, ?, ?, 'pending', 0, ?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE id = id;
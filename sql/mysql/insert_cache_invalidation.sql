@@ -0,0 +1,5 @@
+-- Publish a cache invalidation (MySQL)
+-- Parameters: source, kind, value, version, created_at
+
+INSERT INTO cache_invalidations (source, kind, value, version, created_at)
+VALUES (?, ?, ?, ?, ?);
@@ -0,0 +1,7 @@
+-- Create a scheduler lease (MySQL)
+-- Parameters: name, holder, expires_at
+-- Affects no rows when the lease already exists
+
+INSERT INTO scheduler_leases (name, holder, expires_at)
+VALUES (?, ?, ?)
+ON DUPLICATE KEY UPDATE name = name;
@@ -0,0 +1,8 @@
+-- Load the time of the latest scheduled run of a task (MySQL)
+-- Parameters: task_name
+
+SELECT scheduled_at
+FROM scheduled_task_runs
+WHERE task_name = ?
+ORDER BY scheduled_at DESC
+LIMIT 1;
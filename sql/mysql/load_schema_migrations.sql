@@ -0,0 +1,4 @@
+-- Load applied migrations (MySQL)
+-- Returns the migration history in version order
+
+SELECT version, name, checksum, applied_at, execution_ms FROM schema_migrations ORDER BY version;
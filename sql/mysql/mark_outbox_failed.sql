@@ -0,0 +1,6 @@
+-- Record a failed delivery of a claimed outbox message (MySQL)
+-- Parameters: status, attempts, last_error, next_attempt_at, id, locked_by
+-- status is 'pending' for another attempt or 'dead' once attempts are exhausted
+
+UPDATE outbox_messages SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ?, locked_by = NULL, locked_until = NULL
+WHERE id = ? AND locked_by = ?;
@@ -0,0 +1,12 @@
+-- Create the rate_limit_state table (MySQL)
+-- Stores per-key state of the RateLimiter algorithms (token bucket, sliding window, GCRA)
+-- The version column provides optimistic concurrency so updates are atomic across instances
+-- The expires_at index keeps cleanup of idle keys from scanning the whole table
+
+CREATE TABLE IF NOT EXISTS rate_limit_state (
+    rate_key VARCHAR(255) PRIMARY KEY,
+    state TEXT NOT NULL,
+    version BIGINT NOT NULL DEFAULT 1,
+    expires_at DATETIME(6) NOT NULL,
+    INDEX idx_rate_limit_state_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,20 @@
+-- Create the outbox_messages table (MySQL)
+-- Stores events written in the same transaction as the data they describe
+-- status is 'pending', 'published' or 'dead'; locked_by/locked_until hold a relay's claim
+-- Indexes are declared inline so the migration is a single statement
+
+CREATE TABLE IF NOT EXISTS outbox_messages (
+    id VARCHAR(255) PRIMARY KEY,
+    event VARCHAR(255) NOT NULL,
+    payload LONGTEXT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME(6) NOT NULL,
+    locked_by VARCHAR(64) NULL,
+    locked_until DATETIME(6) NULL,
+    last_error TEXT NULL,
+    created_at DATETIME(6) NOT NULL,
+    published_at DATETIME(6) NULL,
+    INDEX idx_outbox_due (status, next_attempt_at),
+    INDEX idx_outbox_locked_by (locked_by)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,26 @@
+-- Create the background_jobs table (MySQL)
+-- Stores queued, running, completed and dead-lettered background jobs
+-- status is 'pending', 'running', 'completed' or 'dead'; locked_by/locked_until hold a worker's claim
+-- unique_key is cleared when a job finishes so the key can be used again
+-- Indexes are declared inline so the migration is a single statement
+-- MySQL allows several NULL keys in idx_jobs_unique
+
+CREATE TABLE IF NOT EXISTS background_jobs (
+    id VARCHAR(64) PRIMARY KEY,
+    queue VARCHAR(100) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    payload LONGTEXT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL,
+    run_at DATETIME(6) NOT NULL,
+    unique_key VARCHAR(255) NULL,
+    locked_by VARCHAR(64) NULL,
+    locked_until DATETIME(6) NULL,
+    last_error TEXT NULL,
+    created_at DATETIME(6) NOT NULL,
+    completed_at DATETIME(6) NULL,
+    INDEX idx_jobs_due (queue, status, run_at),
+    UNIQUE INDEX idx_jobs_unique (unique_key),
+    INDEX idx_jobs_completed (status, completed_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,3 @@
+-- Drop the scheduler_leases table (MySQL)
+
+DROP TABLE IF EXISTS scheduler_leases;
@@ -0,0 +1,18 @@
+-- Create the scheduled_task_runs table (MySQL)
+-- Stores the execution history of scheduled tasks
+-- The unique (task_name, scheduled_at) pair makes every scheduled run execute once
+-- status is 'running', 'succeeded' or 'failed'
+-- Indexes are declared inline so the migration is a single statement
+
+CREATE TABLE IF NOT EXISTS scheduled_task_runs (
+    id VARCHAR(64) PRIMARY KEY,
+    task_name VARCHAR(255) NOT NULL,
+    scheduled_at DATETIME(6) NOT NULL,
+    instance_id VARCHAR(255) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    error TEXT NULL,
+    started_at DATETIME(6) NOT NULL,
+    finished_at DATETIME(6) NULL,
+    UNIQUE KEY uq_scheduled_task_runs (task_name, scheduled_at),
+    INDEX idx_scheduled_task_runs_finished (finished_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
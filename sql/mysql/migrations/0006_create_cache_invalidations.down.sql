@@ -0,0 +1,3 @@
+-- Drop the cache_invalidations table (MySQL)
+
+DROP TABLE IF EXISTS cache_invalidations;
@@ -0,0 +1,14 @@
+-- Create the cache_invalidations table (MySQL)
+-- Carries cache invalidations between instances, which poll for new rows
+-- kind is 'key', 'pattern', 'tag' or 'all'; version orders the invalidation against the entries of the publishing instance
+-- Indexes are declared inline so the migration is a single statement
+
+CREATE TABLE IF NOT EXISTS cache_invalidations (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    source VARCHAR(255) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    value TEXT NOT NULL,
+    version BIGINT NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    INDEX idx_cache_invalidations_created (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,3 @@
+-- Drop the tenant_quota_usage table (MySQL)
+
+DROP TABLE IF EXISTS tenant_quota_usage;
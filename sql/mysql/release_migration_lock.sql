@@ -0,0 +1,3 @@
+-- Release the migration advisory lock (MySQL)
+
+SELECT RELEASE_LOCK('rockstar_schema_migrations');
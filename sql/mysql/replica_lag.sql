@@ -0,0 +1,7 @@
+-- Measure replication lag on a read replica in seconds (MySQL 8.0+)
+-- Returns 0 when the server has no replication applier workers
+
+SELECT COALESCE(MAX(TIMESTAMPDIFF(MICROSECOND,
+    LAST_APPLIED_TRANSACTION_ORIGINAL_COMMIT_TIMESTAMP,
+    LAST_APPLIED_TRANSACTION_END_APPLY_TIMESTAMP)), 0) / 1000000
+FROM performance_schema.replication_applier_status_by_worker;
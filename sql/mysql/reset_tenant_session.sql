@@ -0,0 +1,4 @@
+-- Clear tenant settings before a reserved connection returns to the pool (MySQL)
+-- The configured database name is formatted in by the caller
+
+USE %s;
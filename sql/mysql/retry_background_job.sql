@@ -0,0 +1,5 @@
+-- Schedule another attempt of a failed job (MySQL)
+-- Parameters: run_at, last_error, id, locked_by
+
+UPDATE background_jobs SET status = 'pending', run_at = ?, last_error = ?, locked_by = NULL, locked_until = NULL
+WHERE id = ? AND locked_by = ?;
@@ -0,0 +1,11 @@
+-- Select due jobs of a queue for claiming (MySQL)
+-- Parameters: queue, now, now, limit
+-- Includes running jobs whose worker lost its claim
+-- Runs in the claiming transaction; locked rows are skipped where the engine supports it
+
+SELECT id, queue, name, payload, status, attempts, max_attempts, run_at, unique_key, last_error, created_at
+FROM background_jobs
+WHERE queue = ? AND ((status = 'pending' AND run_at <= ?) OR (status = 'running' AND locked_until < ?))
+ORDER BY run_at
+LIMIT ?
+FOR UPDATE SKIP LOCKED;
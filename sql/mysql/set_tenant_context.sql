@@ -0,0 +1,2 @@
+-- Set the tenant of a reserved connection for row-level security policies (MySQL)
+-- MySQL has no row-level security, use row or schema isolation instead
@@ -0,0 +1,4 @@
+-- Resolve unqualified table names in the tenant's schema (MySQL)
+-- The schema name is formatted in by the caller
+
+USE %s;
@@ -0,0 +1,5 @@
+-- Try to acquire the migration advisory lock (PostgreSQL)
+-- Returns 1 if the lock was acquired, 0 if another session holds it
+-- The lock is held by the session until release_migration_lock or disconnect
+
+SELECT CASE WHEN pg_try_advisory_lock(7428374821) THEN 1 ELSE 0 END;
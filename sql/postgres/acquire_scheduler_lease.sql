@@ -0,0 +1,7 @@
+-- Acquire or renew a scheduler lease (PostgreSQL)
+-- Parameters: holder, expires_at, name, holder, now
+-- Affects one row when the lease is held by holder or has expired
+
+UPDATE scheduler_leases
+SET holder = $1, expires_at = $2
+WHERE name = $3 AND (holder = $4 OR expires_at < $5);
@@ -0,0 +1,6 @@
+-- Move a failed job to the dead-letter queue (PostgreSQL)
+-- Parameters: completed_at, last_error, id, locked_by
+-- Releases the unique key
+
+UPDATE background_jobs SET status = 'dead', completed_at = $1, last_error = $2, unique_key = NULL, locked_by = NULL, locked_until = NULL
+WHERE id = $3 AND locked_by = $4;
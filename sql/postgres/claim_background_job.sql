@@ -0,0 +1,6 @@
+-- Claim a due job for a worker (PostgreSQL)
+-- Parameters: locked_by, locked_until, id, now, now
+-- Affects no rows when another worker claimed the job first
+
+UPDATE background_jobs SET status = 'running', locked_by = $1, locked_until = $2, attempts = attempts + 1
+WHERE id = $3 AND ((status = 'pending' AND run_at <= $4) OR (status = 'running' AND locked_until < $5));
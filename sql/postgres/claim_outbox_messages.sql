@@ -0,0 +1,12 @@
+-- Claim a batch of due outbox messages for a relay (PostgreSQL)
+-- Parameters: locked_by, locked_until, now, now, batch_size
+-- Messages whose previous claim expired are claimed again
+
+UPDATE outbox_messages SET locked_by = $1, locked_until = $2
+WHERE id IN (
+    SELECT id FROM outbox_messages
+    WHERE status = 'pending' AND next_attempt_at <= $3 AND (locked_until IS NULL OR locked_until < $4)
+    ORDER BY created_at
+    LIMIT $5
+    FOR UPDATE SKIP LOCKED
+);
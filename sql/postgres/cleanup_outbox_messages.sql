@@ -0,0 +1,5 @@
+-- Clean up published outbox messages (PostgreSQL)
+-- Parameters: published_before
+-- Pending and dead-lettered messages are kept
+
+DELETE FROM outbox_messages WHERE status = 'published' AND published_at < $1;
@@ -0,0 +1,5 @@
+-- Clean up expired rate limiter state (PostgreSQL)
+-- Parameters: now
+-- Removes state of keys that have been idle longer than their time-to-live
+
+DELETE FROM rate_limit_state WHERE expires_at < $1;
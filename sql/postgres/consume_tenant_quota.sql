@@ -0,0 +1,6 @@
+-- Atomically add usage if the limit is not exceeded (PostgreSQL)
+-- Parameters: amount, updated_at, tenant_id, resource, period, amount, limit
+-- Affects no rows when the consumption would exceed the limit
+
+UPDATE tenant_quota_usage SET used = used + $1, updated_at = $2
+WHERE tenant_id = $3 AND resource = $4 AND period = $5 AND used + $6 <= $7;
@@ -0,0 +1,4 @@
+-- Create a savepoint for a nested WithTx call (PostgreSQL)
+-- The savepoint name is formatted in by the caller
+
+SAVEPOINT %s;
@@ -0,0 +1,12 @@
+-- Create schema_migrations table for PostgreSQL
+-- Records the versioned migrations applied by the Migrator
+-- The checksum detects migration files that were edited after being applied
+-- PostgreSQL uses TIMESTAMP for timestamp storage
+
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    applied_at TIMESTAMP NOT NULL,
+    execution_ms BIGINT NOT NULL DEFAULT 0
+);
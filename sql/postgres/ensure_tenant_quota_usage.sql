@@ -0,0 +1,6 @@
+-- Create the usage row for a tenant, resource and period if it does not exist (PostgreSQL)
+-- Parameters: tenant_id, resource, period, updated_at
+-- Uses ON CONFLICT DO NOTHING so concurrent instances can run it safely
+
+INSERT INTO tenant_quota_usage (tenant_id, resource, period, used, updated_at) VALUES ($1, $2, $3, 0, $4)
+ON CONFLICT (tenant_id, resource, period) DO NOTHING;
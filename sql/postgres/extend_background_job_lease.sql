@@ -0,0 +1,5 @@
+-- Extend the claim of a running job (PostgreSQL)
+-- Parameters: locked_until, id, locked_by
+
+UPDATE background_jobs SET locked_until = $1
+WHERE id = $2 AND locked_by = $3 AND status = 'running';
@@ -0,0 +1,6 @@
+-- Record the outcome of a scheduled run (PostgreSQL)
+-- Parameters: status, error, finished_at, id
+
+UPDATE scheduled_task_runs
+SET status = $1, error = $2, finished_at = $3
+WHERE id = $4;
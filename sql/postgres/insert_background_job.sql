@@ -0,0 +1,7 @@
+-- Queue a background job (PostgreSQL)
+-- Parameters: id, queue, name, payload (JSON), max_attempts, run_at, unique_key, created_at
+-- Affects no rows when a pending or running job has the same unique_key
+
+INSERT INTO background_jobs (id, queue, name, payload, status, attempts, max_attempts, run_at, unique_key, created_at)
+VALUES ($1, $2, $3, $4, 'pending', 0, $5, $6, $7, $8)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,5 @@
+-- Publish a cache invalidation (PostgreSQL)
+-- Parameters: source, kind, value, version, created_at
+
+INSERT INTO cache_invalidations (source, kind, value, version, created_at)
+VALUES ($1, $2, $3, $4, $5);
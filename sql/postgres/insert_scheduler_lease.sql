@@ -0,0 +1,7 @@
+-- Create a scheduler lease (PostgreSQL)
+-- Parameters: name, holder, expires_at
+-- Affects no rows when the lease already exists
+
+INSERT INTO scheduler_leases (name, holder, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (name) DO NOTHING;
@@ -0,0 +1,9 @@
+-- Load the cache invalidations published after an ID (PostgreSQL)
+-- Parameters: after_id, limit
+-- Oldest invalidations first
+
+SELECT id, source, kind, value, version
+FROM cache_invalidations
+WHERE id > $1
+ORDER BY id
+LIMIT $2;
@@ -0,0 +1,9 @@
+-- Load dead-lettered jobs of a queue (PostgreSQL)
+-- Parameters: queue, limit
+-- Oldest jobs first
+
+SELECT id, queue, name, payload, status, attempts, max_attempts, run_at, unique_key, last_error, created_at
+FROM background_jobs
+WHERE queue = $1 AND status = 'dead'
+ORDER BY created_at
+LIMIT $2;
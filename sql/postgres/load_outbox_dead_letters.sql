@@ -0,0 +1,8 @@
+-- Load dead-lettered outbox messages (PostgreSQL)
+-- Parameters: limit
+-- Oldest messages first
+
+SELECT id, event, payload, attempts, last_error, created_at FROM outbox_messages
+WHERE status = 'dead'
+ORDER BY created_at
+LIMIT $1;
@@ -0,0 +1,4 @@
+-- Load usage for a tenant, resource and period (PostgreSQL)
+-- Parameters: tenant_id, resource, period
+
+SELECT used FROM tenant_quota_usage WHERE tenant_id = $1 AND resource = $2 AND period = $3;
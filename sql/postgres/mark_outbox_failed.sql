@@ -0,0 +1,6 @@
+-- Record a failed delivery of a claimed outbox message (PostgreSQL)
+-- Parameters: status, attempts, last_error, next_attempt_at, id, locked_by
+-- status is 'pending' for another attempt or 'dead' once attempts are exhausted
+
+UPDATE outbox_messages SET status = $1, attempts = $2, last_error = $3, next_attempt_at = $4, locked_by = NULL, locked_until = NULL
+WHERE id = $5 AND locked_by = $6;
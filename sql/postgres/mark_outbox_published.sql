@@ -0,0 +1,6 @@
+-- Mark a claimed outbox message as published (PostgreSQL)
+-- Parameters: published_at, id, locked_by
+-- Affects no rows when the claim was lost to another relay
+
+UPDATE outbox_messages SET status = 'published', published_at = $1, locked_by = NULL, locked_until = NULL
+WHERE id = $2 AND locked_by = $3;
@@ -0,0 +1,13 @@
+-- Create the rate_limit_state table (PostgreSQL)
+-- Stores per-key state of the RateLimiter algorithms (token bucket, sliding window, GCRA)
+-- The version column provides optimistic concurrency so updates are atomic across instances
+
+CREATE TABLE IF NOT EXISTS rate_limit_state (
+    rate_key VARCHAR(255) PRIMARY KEY,
+    state TEXT NOT NULL,
+    version BIGINT NOT NULL DEFAULT 1,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Index on expires_at so cleanup of idle keys does not scan the whole table
+CREATE INDEX IF NOT EXISTS idx_rate_limit_state_expires ON rate_limit_state(expires_at);
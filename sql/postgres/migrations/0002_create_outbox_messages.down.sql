@@ -0,0 +1,3 @@
+-- Drop the outbox_messages table (PostgreSQL)
+
+DROP TABLE IF EXISTS outbox_messages;
@@ -0,0 +1,23 @@
+-- Create the outbox_messages table (PostgreSQL)
+-- Stores events written in the same transaction as the data they describe
+-- status is 'pending', 'published' or 'dead'; locked_by/locked_until hold a relay's claim
+
+CREATE TABLE IF NOT EXISTS outbox_messages (
+    id VARCHAR(255) PRIMARY KEY,
+    event VARCHAR(255) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    locked_by VARCHAR(64),
+    locked_until TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    published_at TIMESTAMP
+);
+
+-- Index on status and next_attempt_at for claiming due messages
+CREATE INDEX IF NOT EXISTS idx_outbox_due ON outbox_messages(status, next_attempt_at);
+
+-- Index on locked_by for loading a relay's claimed batch
+CREATE INDEX IF NOT EXISTS idx_outbox_locked_by ON outbox_messages(locked_by);
@@ -0,0 +1,3 @@
+-- Drop the background_jobs table (PostgreSQL)
+
+DROP TABLE IF EXISTS background_jobs;
@@ -0,0 +1,9 @@
+-- Create the scheduler_leases table (PostgreSQL)
+-- Stores the leases used to elect the instance that runs scheduled tasks
+-- holder is the instance holding the lease until expires_at
+
+CREATE TABLE IF NOT EXISTS scheduler_leases (
+    name VARCHAR(100) PRIMARY KEY,
+    holder VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,3 @@
+-- Drop the scheduled_task_runs table (PostgreSQL)
+
+DROP TABLE IF EXISTS scheduled_task_runs;
@@ -0,0 +1,19 @@
+-- Create the scheduled_task_runs table (PostgreSQL)
+-- Stores the execution history of scheduled tasks
+-- The unique (task_name, scheduled_at) pair makes every scheduled run execute once
+-- status is 'running', 'succeeded' or 'failed'
+
+CREATE TABLE IF NOT EXISTS scheduled_task_runs (
+    id VARCHAR(64) PRIMARY KEY,
+    task_name VARCHAR(255) NOT NULL,
+    scheduled_at TIMESTAMP NOT NULL,
+    instance_id VARCHAR(255) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    error TEXT,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP,
+    UNIQUE (task_name, scheduled_at)
+);
+
+-- Index on finished_at for removing old runs
+CREATE INDEX IF NOT EXISTS idx_scheduled_task_runs_finished ON scheduled_task_runs(finished_at);
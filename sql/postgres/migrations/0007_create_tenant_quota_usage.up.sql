@@ -0,0 +1,12 @@
+-- Create the tenant_quota_usage table (PostgreSQL)
+-- Stores metered usage per tenant, resource and billing period
+-- Storage and user quotas use the period 'lifetime'
+
+CREATE TABLE IF NOT EXISTS tenant_quota_usage (
+    tenant_id VARCHAR(255) NOT NULL,
+    resource VARCHAR(50) NOT NULL,
+    period VARCHAR(32) NOT NULL,
+    used BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, resource, period)
+);
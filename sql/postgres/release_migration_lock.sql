@@ -0,0 +1,3 @@
+-- Release the migration advisory lock (PostgreSQL)
+
+SELECT pg_advisory_unlock(7428374821);
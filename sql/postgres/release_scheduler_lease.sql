@@ -0,0 +1,7 @@
+-- Release a scheduler lease (PostgreSQL)
+-- Parameters: expires_at, name, holder
+-- Lets another instance take over without waiting for the lease to expire
+
+UPDATE scheduler_leases
+SET expires_at = $1
+WHERE name = $2 AND holder = $3;
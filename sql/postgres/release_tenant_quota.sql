@@ -0,0 +1,5 @@
+-- Subtract usage without going below zero (PostgreSQL)
+-- Parameters: amount, amount, updated_at, tenant_id, resource, period
+
+UPDATE tenant_quota_usage SET used = CASE WHEN used > $1 THEN used - $2 ELSE 0 END, updated_at = $3
+WHERE tenant_id = $4 AND resource = $5 AND period = $6;
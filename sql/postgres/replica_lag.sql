@@ -0,0 +1,6 @@
+-- Measure replication lag on a read replica in seconds (PostgreSQL)
+-- Returns 0 when run against a server that is not in recovery
+
+SELECT CASE WHEN pg_is_in_recovery()
+    THEN COALESCE(EXTRACT(EPOCH FROM (now() - pg_last_xact_replay_timestamp())), 0)
+    ELSE 0 END;
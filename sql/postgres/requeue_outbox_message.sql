@@ -0,0 +1,6 @@
+-- Return a dead-lettered outbox message to the relay (PostgreSQL)
+-- Parameters: next_attempt_at, id
+-- Affects no rows unless the message is dead-lettered
+
+UPDATE outbox_messages SET status = 'pending', attempts = 0, last_error = NULL, next_attempt_at = $1
+WHERE id = $2 AND status = 'dead';
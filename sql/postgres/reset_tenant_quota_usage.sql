@@ -0,0 +1,4 @@
+-- Delete all usage of a resource for a tenant (PostgreSQL)
+-- Parameters: tenant_id, resource
+
+DELETE FROM tenant_quota_usage WHERE tenant_id = $1 AND resource = $2;
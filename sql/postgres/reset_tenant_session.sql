@@ -0,0 +1,4 @@
+-- Clear tenant settings before a reserved connection returns to the pool (PostgreSQL)
+-- Resets search_path and app.tenant_id; the connection keeps its database
+
+RESET ALL;
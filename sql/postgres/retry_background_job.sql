@@ -0,0 +1,5 @@
+-- Schedule another attempt of a failed job (PostgreSQL)
+-- Parameters: run_at, last_error, id, locked_by
+
+UPDATE background_jobs SET status = 'pending', run_at = $1, last_error = $2, locked_by = NULL, locked_until = NULL
+WHERE id = $3 AND locked_by = $4;
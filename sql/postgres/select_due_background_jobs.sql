@@ -0,0 +1,11 @@
+-- Select due jobs of a queue for claiming (PostgreSQL)
+-- Parameters: queue, now, now, limit
+-- Includes running jobs whose worker lost its claim
+-- Runs in the claiming transaction; locked rows are skipped where the engine supports it
+
+SELECT id, queue, name, payload, status, attempts, max_attempts, run_at, unique_key, last_error, created_at
+FROM background_jobs
+WHERE queue = $1 AND ((status = 'pending' AND run_at <= $2) OR (status = 'running' AND locked_until < $3))
+ORDER BY run_at
+LIMIT $4
+FOR UPDATE SKIP LOCKED;
@@ -0,0 +1,4 @@
+-- Set the tenant of a reserved connection for row-level security policies (PostgreSQL)
+-- Policies read it with current_setting('app.tenant_id', true)
+
+SELECT set_config('app.tenant_id', $1, false);
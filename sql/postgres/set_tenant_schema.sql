@@ -0,0 +1,4 @@
+-- Resolve unqualified table names in the tenant's schema (PostgreSQL)
+-- The schema name is formatted in by the caller
+
+SET search_path TO %s;
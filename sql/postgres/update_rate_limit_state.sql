@@ -0,0 +1,5 @@
+-- Update rate limiter state if it has not changed since it was loaded (PostgreSQL)
+-- Parameters: state, expires_at, rate_key, expected_version
+-- Affects no rows when another instance updated the key concurrently
+
+UPDATE rate_limit_state SET state = $1, version = version + 1, expires_at = $2 WHERE rate_key = $3 AND version = $4;
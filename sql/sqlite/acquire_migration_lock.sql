@@ -0,0 +1,9 @@
+-- Try to acquire the migration lock (SQLite)
+-- Returns 1 if the lock row was inserted, no row if another process holds it
+-- A lock row older than 15 minutes was left behind by a process that died while
+-- migrating and is taken over
+
+INSERT INTO schema_migrations_lock (id, locked_at) VALUES (1, CURRENT_TIMESTAMP)
+ON CONFLICT (id) DO UPDATE SET locked_at = CURRENT_TIMESTAMP
+WHERE schema_migrations_lock.locked_at < datetime('now', '-15 minutes')
+RETURNING 1;
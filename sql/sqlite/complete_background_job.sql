@@ -0,0 +1,6 @@
+-- Mark a running job as completed (SQLite)
+-- Parameters: completed_at, id, locked_by
+-- Releases the unique key
+
+UPDATE background_jobs SET status = 'completed', completed_at = ?, unique_key = NULL, locked_by = NULL, locked_until = NULL
+WHERE id = ? AND locked_by = ?;
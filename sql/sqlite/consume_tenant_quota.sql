@@ -0,0 +1,6 @@
+-- Atomically add usage if the limit is not exceeded (SQLite)
+-- Parameters: amount, updated_at, tenant_id, resource, period, amount, limit
+-- Affects no rows when the consumption would exceed the limit
+
+UPDATE tenant_quota_usage SET used = used + ?, updated_at = ?
+WHERE tenant_id = ? AND resource = ? AND period = ? AND used + ? <= ?;
@@ -0,0 +1,18 @@
+-- Create schema_migrations table for SQLite
+-- Records the versioned migrations applied by the Migrator
+-- The checksum detects migration files that were edited after being applied
+-- SQLite uses DATETIME for timestamp storage
+
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    checksum TEXT NOT NULL,
+    applied_at DATETIME NOT NULL,
+    execution_ms INTEGER NOT NULL DEFAULT 0
+);
+
+-- SQLite has no advisory locks, so the Migrator holds a single lock row instead
+CREATE TABLE IF NOT EXISTS schema_migrations_lock (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    locked_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
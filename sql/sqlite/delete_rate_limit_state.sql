@@ -0,0 +1,4 @@
+-- Delete rate limiter state for a key (SQLite)
+-- Parameters: rate_key
+
+DELETE FROM rate_limit_state WHERE rate_key = ?;
@@ -0,0 +1,5 @@
+-- Create the usage row for a tenant, resource and period if it does not exist (SQLite)
+-- Parameters: tenant_id, resource, period, updated_at
+-- Uses INSERT OR IGNORE so concurrent instances can run it safely
+
+INSERT OR IGNORE INTO tenant_quota_usage (tenant_id, resource, period, used, updated_at) VALUES (?, ?, ?, 0, ?);
@@ -0,0 +1,5 @@
+-- Extend the claim of a running job (SQLite)
+-- Parameters: locked_until, id, locked_by
+
+UPDATE background_jobs SET locked_until = ?
+WHERE id = ? AND locked_by = ? AND status = 'running';
@@ -0,0 +1,7 @@
+-- Write an event to the outbox (SQLite)
+-- Parameters: id, event, payload (JSON), next_attempt_at, created_at
+-- Affects no rows when a message with the same ID exists (deduplication)
+
+INSERT INTO outbox_messages (id, event, payload, status, attempts, next_attempt_at, created_at)
+VALUES (?, ?, ?, 'pending', 0, ?, ?)
+ON CONFLICT (id) DO NOTHING;
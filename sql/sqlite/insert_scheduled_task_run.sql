@@ -0,0 +1,7 @@
+-- Claim a scheduled run of a task (SQLite)
+-- Parameters: id, task_name, scheduled_at, instance_id, started_at
+-- Affects no rows when the run was already claimed by another instance
+
+INSERT INTO scheduled_task_runs (id, task_name, scheduled_at, instance_id, status, started_at)
+VALUES (?, ?, ?, ?, 'running', ?)
+ON CONFLICT (task_name, scheduled_at) DO NOTHING;
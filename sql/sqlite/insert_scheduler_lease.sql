@@ -0,0 +1,7 @@
+-- Create a scheduler lease (SQLite)
+-- Parameters: name, holder, expires_at
+-- Affects no rows when the lease already exists
+
+INSERT INTO scheduler_leases (name, holder, expires_at)
+VALUES (?, ?, ?)
+ON CONFLICT (name) DO NOTHING;
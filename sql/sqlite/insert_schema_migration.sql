@@ -0,0 +1,5 @@
+-- Record an applied migration (SQLite)
+-- Parameters: version, name, checksum, applied_at, execution_ms
+-- Runs in the same transaction as the migration itself
+
+INSERT INTO schema_migrations (version, name, checksum, applied_at, execution_ms) VALUES (?, ?, ?, ?, ?);
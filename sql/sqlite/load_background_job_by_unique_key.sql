@@ -0,0 +1,4 @@
+-- Load the ID of the job holding a unique key (SQLite)
+-- Parameters: unique_key
+
+SELECT id FROM background_jobs WHERE unique_key = ?;
@@ -0,0 +1,9 @@
+-- Load the cache invalidations published after an ID (SQLite)
+-- Parameters: after_id, limit
+-- Oldest invalidations first
+
+SELECT id, source, kind, value, version
+FROM cache_invalidations
+WHERE id > ?
+ORDER BY id
+LIMIT ?;
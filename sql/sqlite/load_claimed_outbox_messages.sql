@@ -0,0 +1,7 @@
+-- Load the outbox messages claimed by a relay (SQLite)
+-- Parameters: locked_by
+
+
+SELECT id, event, payload, attempts, last_error, created_at FROM outbox_messages
+WHERE locked_by = ? AND status = 'pending'
+ORDER BY created_at;
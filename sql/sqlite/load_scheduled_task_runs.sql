@@ -0,0 +1,9 @@
+-- Load the execution history of a task (SQLite)
+-- Parameters: task_name, limit
+-- Newest runs first
+
+SELECT id, task_name, scheduled_at, instance_id, status, error, started_at, finished_at
+FROM scheduled_task_runs
+WHERE task_name = ?
+ORDER BY scheduled_at DESC
+LIMIT ?;
@@ -0,0 +1,4 @@
+-- Load usage for a tenant, resource and period (SQLite)
+-- Parameters: tenant_id, resource, period
+
+SELECT used FROM tenant_quota_usage WHERE tenant_id = ? AND resource = ? AND period = ?;
@@ -0,0 +1,3 @@
+-- Drop the rate_limit_state table (SQLite)
+
+DROP TABLE IF EXISTS rate_limit_state;
@@ -0,0 +1,3 @@
+-- Drop the outbox_messages table (SQLite)
+
+DROP TABLE IF EXISTS outbox_messages;
@@ -0,0 +1,23 @@
+-- Create the outbox_messages table (SQLite)
+-- Stores events written in the same transaction as the data they describe
+-- status is 'pending', 'published' or 'dead'; locked_by/locked_until hold a relay's claim
+
+CREATE TABLE IF NOT EXISTS outbox_messages (
+    id TEXT PRIMARY KEY,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    locked_by TEXT,
+    locked_until DATETIME,
+    last_error TEXT,
+    created_at DATETIME NOT NULL,
+    published_at DATETIME
+);
+
+-- Index on status and next_attempt_at for claiming due messages
+CREATE INDEX IF NOT EXISTS idx_outbox_due ON outbox_messages(status, next_attempt_at);
+
+-- Index on locked_by for loading a relay's claimed batch
+CREATE INDEX IF NOT EXISTS idx_outbox_locked_by ON outbox_messages(locked_by);
@@ -0,0 +1,30 @@
+-- Create the background_jobs table (SQLite)
+-- Stores queued, running, completed and dead-lettered background jobs
+-- status is 'pending', 'running', 'completed' or 'dead'; locked_by/locked_until hold a worker's claim
+-- unique_key is cleared when a job finishes so the key can be used again
+
+CREATE TABLE IF NOT EXISTS background_jobs (
+    id TEXT PRIMARY KEY,
+    queue TEXT NOT NULL,
+    name TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    run_at DATETIME NOT NULL,
+    unique_key TEXT,
+    locked_by TEXT,
+    locked_until DATETIME,
+    last_error TEXT,
+    created_at DATETIME NOT NULL,
+    completed_at DATETIME
+);
+
+-- Index on queue, status and run_at for claiming due jobs
+CREATE INDEX IF NOT EXISTS idx_jobs_due ON background_jobs(queue, status, run_at);
+
+-- Unique index on unique_key so a unique job is only queued once
+CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_unique ON background_jobs(unique_key) WHERE unique_key IS NOT NULL;
+
+-- Index on status and completed_at for cleanup of finished jobs
+CREATE INDEX IF NOT EXISTS idx_jobs_completed ON background_jobs(status, completed_at);
@@ -0,0 +1,3 @@
+-- Drop the scheduler_leases table (SQLite)
+
+DROP TABLE IF EXISTS scheduler_leases;
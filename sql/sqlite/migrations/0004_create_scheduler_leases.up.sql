@@ -0,0 +1,9 @@
+-- Create the scheduler_leases table (SQLite)
+-- Stores the leases used to elect the instance that runs scheduled tasks
+-- holder is the instance holding the lease until expires_at
+
+CREATE TABLE IF NOT EXISTS scheduler_leases (
+    name TEXT PRIMARY KEY,
+    holder TEXT NOT NULL,
+    expires_at DATETIME NOT NULL
+);
@@ -0,0 +1,3 @@
+-- Drop the scheduled_task_runs table (SQLite)
+
+DROP TABLE IF EXISTS scheduled_task_runs;
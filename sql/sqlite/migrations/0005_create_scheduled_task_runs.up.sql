@@ -0,0 +1,19 @@
+-- Create the scheduled_task_runs table (SQLite)
+-- Stores the execution history of scheduled tasks
+-- The unique (task_name, scheduled_at) pair makes every scheduled run execute once
+-- status is 'running', 'succeeded' or 'failed'
+
+CREATE TABLE IF NOT EXISTS scheduled_task_runs (
+    id TEXT PRIMARY KEY,
+    task_name TEXT NOT NULL,
+    scheduled_at DATETIME NOT NULL,
+    instance_id TEXT NOT NULL,
+    status TEXT NOT NULL,
+    error TEXT,
+    started_at DATETIME NOT NULL,
+    finished_at DATETIME,
+    UNIQUE (task_name, scheduled_at)
+);
+
+-- Index on finished_at for removing old runs
+CREATE INDEX IF NOT EXISTS idx_scheduled_task_runs_finished ON scheduled_task_runs(finished_at);
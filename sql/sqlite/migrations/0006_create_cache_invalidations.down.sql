@@ -0,0 +1,3 @@
+-- Drop the cache_invalidations table (SQLite)
+
+DROP TABLE IF EXISTS cache_invalidations;
@@ -0,0 +1,15 @@
+-- Create the cache_invalidations table (SQLite)
+-- Carries cache invalidations between instances, which poll for new rows
+-- kind is 'key', 'pattern', 'tag' or 'all'; version orders the invalidation against the entries of the publishing instance
+
+CREATE TABLE IF NOT EXISTS cache_invalidations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    value TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+-- Index on created_at for removing old invalidations
+CREATE INDEX IF NOT EXISTS idx_cache_invalidations_created ON cache_invalidations(created_at);
@@ -0,0 +1,12 @@
+-- Create the tenant_quota_usage table (SQLite)
+-- Stores metered usage per tenant, resource and billing period
+-- Storage and user quotas use the period 'lifetime'
+
+CREATE TABLE IF NOT EXISTS tenant_quota_usage (
+    tenant_id TEXT NOT NULL,
+    resource TEXT NOT NULL,
+    period TEXT NOT NULL,
+    used INTEGER NOT NULL DEFAULT 0,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, resource, period)
+);
@@ -0,0 +1,3 @@
+-- Release the migration lock (SQLite)
+
+DELETE FROM schema_migrations_lock WHERE id = 1;
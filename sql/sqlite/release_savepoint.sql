@@ -0,0 +1,4 @@
+-- Release a savepoint after a nested WithTx call succeeded (SQLite)
+-- The savepoint name is formatted in by the caller
+
+RELEASE SAVEPOINT %s;
@@ -0,0 +1,7 @@
+-- Release a scheduler lease (SQLite)
+-- Parameters: expires_at, name, holder
+-- Lets another instance take over without waiting for the lease to expire
+
+UPDATE scheduler_leases
+SET expires_at = ?
+WHERE name = ? AND holder = ?;
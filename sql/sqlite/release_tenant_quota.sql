@@ -0,0 +1,5 @@
+-- Subtract usage without going below zero (SQLite)
+-- Parameters: amount, amount, updated_at, tenant_id, resource, period
+
+UPDATE tenant_quota_usage SET used = CASE WHEN used > ? THEN used - ? ELSE 0 END, updated_at = ?
+WHERE tenant_id = ? AND resource = ? AND period = ?;
@@ -0,0 +1,4 @@
+-- Measure replication lag on a read replica in seconds (SQLite)
+-- SQLite has no built-in replication, replicas are always reported as current
+
+SELECT 0;
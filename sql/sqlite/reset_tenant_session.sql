@@ -0,0 +1,2 @@
+-- Clear tenant settings before a reserved connection returns to the pool (SQLite)
+-- SQLite connections carry no tenant settings
@@ -0,0 +1,6 @@
+-- Return a dead-lettered job to its queue (SQLite)
+-- Parameters: run_at, id
+-- Affects no rows unless the job is dead-lettered
+
+UPDATE background_jobs SET status = 'pending', attempts = 0, run_at = ?, last_error = NULL, completed_at = NULL
+WHERE id = ? AND status = 'dead';
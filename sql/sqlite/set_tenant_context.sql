@@ -0,0 +1,2 @@
+-- Set the tenant of a reserved connection for row-level security policies (SQLite)
+-- SQLite has no row-level security, use row or database isolation instead
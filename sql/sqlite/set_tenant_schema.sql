@@ -0,0 +1,2 @@
+-- Resolve unqualified table names in the tenant's schema (SQLite)
+-- SQLite has no schemas, use row or database isolation instead
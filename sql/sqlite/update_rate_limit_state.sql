@@ -0,0 +1,5 @@
+-- Update rate limiter state if it has not changed since it was loaded (SQLite)
+-- Parameters: state, expires_at, rate_key, expected_version
+-- Affects no rows when another instance updated the key concurrently
+
+UPDATE rate_limit_state SET state = ?, version = version + 1, expires_at = ? WHERE rate_key = ? AND version = ?;
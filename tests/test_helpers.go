@@ -44,6 +44,7 @@ func (m *mockContext) Session() pkg.SessionManager                   { return ni
 func (m *mockContext) User() *pkg.User                               { return m.user }
 func (m *mockContext) Tenant() *pkg.Tenant                           { return m.tenant }
 func (m *mockContext) DB() pkg.DatabaseManager                       { return nil }
+func (m *mockContext) Jobs() pkg.JobQueue                            { return nil }
 func (m *mockContext) Cache() pkg.CacheManager                       { return nil }
 func (m *mockContext) Config() pkg.ConfigManager                     { return nil }
 func (m *mockContext) I18n() pkg.I18nManager                         { return nil }
@@ -263,6 +264,34 @@ func (m *testMockDB) BeginTx(opts *sql.TxOptions) (pkg.Transaction, error) {
 	return nil, nil
 }
 
+func (m *testMockDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*pkg.Rows, error) {
+	return nil, nil
+}
+
+func (m *testMockDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *pkg.Row {
+	return nil
+}
+
+func (m *testMockDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return m.Exec(query, args...)
+}
+
+func (m *testMockDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return m.Prepare(query)
+}
+
+func (m *testMockDB) BeginTxContext(ctx context.Context, opts *sql.TxOptions) (pkg.Transaction, error) {
+	return m.BeginTx(opts)
+}
+
+func (m *testMockDB) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx pkg.Transaction) error) error {
+	tx, err := m.BeginTx(opts)
+	if err != nil {
+		return err
+	}
+	return fn(tx)
+}
+
 // Note: Save, Find, FindAll, Delete, Update are not part of DatabaseManager interface
 // They were removed as they're not used by the framework
 func (m *testMockDB) Migrate() error                { return nil }